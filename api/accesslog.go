@@ -0,0 +1,58 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogMiddleware 请求结束后记录一行访问日志，与采集日志写入同一个日志文件和缓冲区，
+// 带上请求ID，便于把接口问题与采集日志对应起来
+func accessLogMiddleware(cfg *config.APIConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for _, p := range cfg.AccessLogSkipPaths {
+			if p != "" && (path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/")) {
+				c.Next()
+				return
+			}
+		}
+
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		status := c.Writer.Status()
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		utils.IncCounter(utils.MetricName("biupdata_http_requests_total", "method", c.Request.Method, "status", strconv.Itoa(status)))
+
+		fields := utils.Fields{
+			"method":     c.Request.Method,
+			"path":       path,
+			"status":     strconv.Itoa(status),
+			"latency_ms": strconv.FormatFloat(float64(latency.Microseconds())/1000, 'f', 1, 64),
+			"ip":         c.ClientIP(),
+			"request_id": requestID(c),
+			"size":       strconv.Itoa(size),
+		}
+		if c.Request.URL.RawQuery != "" {
+			fields["query"] = c.Request.URL.RawQuery
+		}
+		if subject := c.GetString(authSubjectKey); subject != "" {
+			fields["user"] = subject
+		}
+
+		level := utils.LevelInfo
+		if status >= 500 {
+			level = utils.LevelWarning
+		}
+		utils.LogWithFields(level, fields, "access")
+	}
+}
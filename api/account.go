@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 币安myTrades接口单次最多返回的成交数量
+const accountTradesLimit = 1000
+
+// FetchAccountBalances 获取账户中余额不为0的资产
+func FetchAccountBalances() ([]db.AccountBalance, error) {
+	body, err := binanceSignedGet("/api/v3/account", url.Values{"omitZeroBalances": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Balances []db.AccountBalance `json:"balances"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析账户余额失败: %v", err)
+	}
+	return resp.Balances, nil
+}
+
+// FetchOpenOrders 获取全部交易对的挂单
+func FetchOpenOrders() ([]db.AccountOrder, error) {
+	body, err := binanceSignedGet("/api/v3/openOrders", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []struct {
+		Symbol      string `json:"symbol"`
+		OrderID     int64  `json:"orderId"`
+		Side        string `json:"side"`
+		Type        string `json:"type"`
+		Price       string `json:"price"`
+		OrigQty     string `json:"origQty"`
+		ExecutedQty string `json:"executedQty"`
+		Status      string `json:"status"`
+		Time        int64  `json:"time"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析挂单失败: %v", err)
+	}
+
+	orders := make([]db.AccountOrder, len(resp))
+	for i, o := range resp {
+		orders[i] = db.AccountOrder(o)
+	}
+	return orders, nil
+}
+
+// FetchAccountTrades 获取交易对从fromID开始的成交记录，按成交ID升序
+func FetchAccountTrades(symbol string, fromID int64) ([]db.AccountTrade, error) {
+	body, err := binanceSignedGet("/api/v3/myTrades", url.Values{
+		"symbol": {symbol},
+		"fromId": {strconv.FormatInt(fromID, 10)},
+		"limit":  {strconv.Itoa(accountTradesLimit)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []struct {
+		Symbol          string `json:"symbol"`
+		ID              int64  `json:"id"`
+		OrderID         int64  `json:"orderId"`
+		Price           string `json:"price"`
+		Qty             string `json:"qty"`
+		QuoteQty        string `json:"quoteQty"`
+		Commission      string `json:"commission"`
+		CommissionAsset string `json:"commissionAsset"`
+		Time            int64  `json:"time"`
+		IsBuyer         bool   `json:"isBuyer"`
+		IsMaker         bool   `json:"isMaker"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析 %s 成交记录失败: %v", symbol, err)
+	}
+
+	trades := make([]db.AccountTrade, len(resp))
+	for i, t := range resp {
+		trades[i] = db.AccountTrade(t)
+	}
+	return trades, nil
+}
+
+// SyncAccount 同步账户余额、挂单，并增量同步各交易对的成交记录
+func SyncAccount(cfg *config.Config) error {
+	var failed []string
+
+	balances, err := FetchAccountBalances()
+	if err == nil {
+		err = db.SaveAccountBalances(balances)
+	}
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("余额: %v", err))
+	}
+
+	orders, err := FetchOpenOrders()
+	if err == nil {
+		err = db.SaveOpenOrders(orders)
+	}
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("挂单: %v", err))
+	}
+
+	symbols := cfg.Binance.AccountSymbols
+	if len(symbols) == 0 {
+		updateMutex.Lock()
+		symbols = cfg.Binance.CurrentSymbols()
+		updateMutex.Unlock()
+	}
+	total := 0
+	for _, symbol := range symbols {
+		n, err := syncAccountTrades(strings.ToUpper(symbol))
+		total += n
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s 成交: %v", symbol, err))
+		}
+	}
+
+	utils.LogInfo("账户同步完成：%d 个资产，%d 个挂单，新增 %d 笔成交", len(balances), len(orders), total)
+	if len(failed) > 0 {
+		return fmt.Errorf("账户同步失败: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// syncAccountTrades 从已保存的最大成交ID之后分页获取成交记录，返回新增的成交数量
+func syncAccountTrades(symbol string) (int, error) {
+	lastID, err := db.GetLastAccountTradeID(symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		trades, err := FetchAccountTrades(symbol, lastID+1)
+		if err != nil {
+			return total, err
+		}
+		if len(trades) == 0 {
+			return total, nil
+		}
+		if err := db.SaveAccountTrades(trades); err != nil {
+			return total, err
+		}
+
+		total += len(trades)
+		utils.AddCounter(utils.MetricName("biupdata_account_trades_synced_total", "symbol", symbol), float64(len(trades)))
+		lastID = trades[len(trades)-1].ID
+		if len(trades) < accountTradesLimit {
+			return total, nil
+		}
+	}
+}
+
+// AddAccountTask 添加定期同步账户数据的定时任务，未启用BINANCE_ACCOUNT_SYNC时不添加
+func AddAccountTask(cfg *config.Config) error {
+	if !cfg.Binance.AccountSync {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	if err := db.CreateAccountTablesIfNotExists(); err != nil {
+		return err
+	}
+
+	err := addScheduledTask("account", cfg.Cron.AccountSchedule, func() error {
+		err := SyncAccount(cfg)
+		if err != nil {
+			utils.LogError("定时同步账户数据失败: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("添加账户同步任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加账户同步任务，cron表达式: %s", cfg.Cron.AccountSchedule)
+	return nil
+}
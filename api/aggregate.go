@@ -0,0 +1,79 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/gin-gonic/gin"
+)
+
+// KlineAggregateResponse 一段时间内K线的聚合统计，区间内没有K线时只返回count=0
+type KlineAggregateResponse struct {
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	StartTime int64  `json:"start_time,omitempty"`
+	EndTime   int64  `json:"end_time,omitempty"`
+	Count     int64  `json:"count"`
+	FirstTime int64  `json:"first_time,omitempty"`
+	LastTime  int64  `json:"last_time,omitempty"`
+	High      string `json:"high_price,omitempty"`
+	Low       string `json:"low_price,omitempty"`
+	AvgClose  string `json:"avg_close_price,omitempty"`
+	Volume    string `json:"total_volume,omitempty"`
+}
+
+// getKlineAggregate 在数据库中计算区间内的最高价、最低价、平均收盘价、总成交量和K线数量
+func getKlineAggregate(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	var startTime, endTime int64
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+	if startTime > 0 && endTime > 0 && endTime < startTime {
+		badRequest(c, "end_time 不能早于 start_time")
+		return
+	}
+
+	agg, err := db.GetKlineAggregate(symbol, interval, startTime, endTime)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	respondOK(c, KlineAggregateResponse{
+		Symbol:    symbol,
+		Interval:  interval,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Count:     agg.Count,
+		FirstTime: agg.FirstTime,
+		LastTime:  agg.LastTime,
+		High:      agg.High,
+		Low:       agg.Low,
+		AvgClose:  agg.AvgClose,
+		Volume:    agg.Volume,
+	})
+}
@@ -0,0 +1,638 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 告警规则类型
+const (
+	alertTypeCross       = "cross"        // 收盘价上穿或下穿均线
+	alertTypeVolumeSpike = "volume_spike" // 成交量超过前period根平均成交量的若干倍
+	alertTypeChange      = "change"       // 一段时间内的涨跌幅超过阈值
+)
+
+// 告警规则缓存的有效期，通过接口修改规则时立即失效
+const alertRulesTTL = time.Minute
+
+// K线收盘后超过该周期数才写入时视为历史数据，不求值告警
+const alertMaxLagBars = 2
+
+// 均线和平均成交量周期的上限，避免每次求值读取过多K线
+const maxAlertPeriod = 500
+
+// EMA需要额外的K线预热，读取period的倍数
+const alertEMAWarmup = 4
+
+// alertEngine 每次写入K线后对最新一根已收盘K线求值告警规则，作为扩展挂在K线处理流程上
+type alertEngine struct {
+	mu        sync.Mutex
+	rules     []db.AlertRule
+	loadedAt  time.Time
+	evaluated map[string]int64 // 规则ID/交易对/时间间隔 -> 已求值的K线开盘时间，同一根K线重复写入时不再求值
+}
+
+var (
+	alertMutex   sync.Mutex
+	activeAlerts *alertEngine
+)
+
+// initAlerts 启用告警时返回对应的扩展，未启用时返回nil
+func initAlerts(cfg *config.AlertsConfig) *alertEngine {
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+	activeAlerts = nil
+	if !cfg.Enabled {
+		return nil
+	}
+	activeAlerts = &alertEngine{evaluated: make(map[string]int64)}
+	return activeAlerts
+}
+
+// invalidateAlertRules 规则变化后让下一次求值重新读取规则
+func invalidateAlertRules() {
+	alertMutex.Lock()
+	e := activeAlerts
+	alertMutex.Unlock()
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.loadedAt = time.Time{}
+	e.mu.Unlock()
+}
+
+// Name 扩展名称
+func (e *alertEngine) Name() string {
+	return "alerts"
+}
+
+// OnCandleFetched 告警只在写入后求值
+func (e *alertEngine) OnCandleFetched(symbol, interval string, klines []db.Candle) ([]db.Candle, error) {
+	return klines, nil
+}
+
+// OnCandleStored 对本次写入的最新一根已收盘K线求值匹配的规则；
+// 收盘时间早于当前时间超过alertMaxLagBars个周期的K线（回补、启动追赶写入的历史数据）不求值，避免按过去的价格告警
+func (e *alertEngine) OnCandleStored(symbol, interval string, klines []db.Candle) error {
+	var last *db.Candle
+	for i := len(klines) - 1; i >= 0; i-- {
+		if klines[i].Closed {
+			last = &klines[i]
+			break
+		}
+	}
+	if last == nil {
+		return nil
+	}
+	if closeTime := nextIntervalStart(interval, last.OpenTime); advanceIntervals(interval, closeTime, alertMaxLagBars) < time.Now().UnixMilli() {
+		return nil
+	}
+
+	rules, err := e.matchingRules(symbol, interval)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	var failed []string
+	for _, rule := range rules {
+		if !e.markEvaluated(rule.ID, symbol, interval, last.OpenTime) {
+			continue
+		}
+		if err := evaluateAlertRule(rule, symbol, interval, last.OpenTime); err != nil {
+			failed = append(failed, fmt.Sprintf("规则 %d: %v", rule.ID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// matchingRules 返回对该交易对和时间间隔生效的已启用规则，规则缓存过期时重新读取
+func (e *alertEngine) matchingRules(symbol, interval string) ([]db.AlertRule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Since(e.loadedAt) > alertRulesTTL {
+		rules, err := db.ListAlertRules()
+		if err != nil {
+			return nil, err
+		}
+		e.rules = rules
+		e.loadedAt = time.Now()
+	}
+
+	var result []db.AlertRule
+	for _, r := range e.rules {
+		if r.Enabled && r.Interval == interval && (r.Symbol == "*" || r.Symbol == symbol) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// markEvaluated 记录规则已对该K线求值，已求值过时返回false
+func (e *alertEngine) markEvaluated(ruleID int64, symbol, interval string, openTime int64) bool {
+	key := fmt.Sprintf("%d/%s/%s", ruleID, symbol, interval)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.evaluated[key] >= openTime {
+		return false
+	}
+	e.evaluated[key] = openTime
+	return true
+}
+
+// evaluateAlertRule 读取截至openTime（UTC毫秒）的K线求值规则，满足条件且不在冷却期内时记录并发送告警
+func evaluateAlertRule(rule db.AlertRule, symbol, interval string, openTime int64) error {
+	series, err := loadSeries(symbol, interval, 0, openTime, alertBars(rule, interval))
+	if err != nil {
+		return err
+	}
+	// 写入进入缓存队列时数据库中还没有这根K线
+	if len(series) == 0 || utils.StoredTimestampToUTC(series[len(series)-1].Timestamp) != openTime {
+		return nil
+	}
+
+	message, fired := checkAlertRule(rule, interval, series)
+	if !fired {
+		return nil
+	}
+
+	if rule.Cooldown > 0 {
+		lastFired, err := db.LastAlertTime(rule.ID, symbol, interval)
+		if err != nil {
+			return err
+		}
+		if lastFired > 0 && time.Since(time.UnixMilli(lastFired)) < time.Duration(rule.Cooldown)*time.Second {
+			utils.LogInfo("告警规则 %d 对 %s %s 仍在冷却期内，不再通知", rule.ID, symbol, interval)
+			return nil
+		}
+	}
+
+	rec := &db.AlertRecord{
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		Symbol:    symbol,
+		Interval:  interval,
+		Timestamp: openTime,
+		Message:   fmt.Sprintf("%s %s %s", symbol, interval, message),
+	}
+	if err := db.RecordAlert(rec); err != nil {
+		return err
+	}
+	invalidateAlertRules()
+	utils.IncCounter(utils.MetricName("biupdata_alerts_fired_total", "rule", strconv.FormatInt(rule.ID, 10)))
+	utils.LogInfo("告警规则 %d（%s）触发: %s", rule.ID, rule.Name, rec.Message)
+	go notifyAlert(rec)
+	return nil
+}
+
+// alertBars 求值规则需要读取的K线数量（含最新一根）
+func alertBars(rule db.AlertRule, interval string) int {
+	switch rule.Type {
+	case alertTypeCross:
+		if rule.Indicator == "ema" {
+			return rule.Period*alertEMAWarmup + 1
+		}
+		return rule.Period + 1
+	case alertTypeVolumeSpike:
+		return rule.Period + 1
+	default:
+		return alertWindowBars(rule.Window, interval) + 1
+	}
+}
+
+// alertWindowBars change规则的时间窗口包含的K线数，窗口为空时为1根
+func alertWindowBars(window, interval string) int {
+	if window == "" {
+		return 1
+	}
+	windowMs, _ := parseIntervalMilliseconds(window)
+	bars := int(windowMs / getIntervalMilliseconds(interval))
+	if bars < 1 {
+		return 1
+	}
+	return bars
+}
+
+// checkAlertRule 对按时间升序、最后一根为最新已收盘K线的序列求值规则，返回是否触发和告警内容
+func checkAlertRule(rule db.AlertRule, interval string, series []ohlcv) (string, bool) {
+	n := len(series)
+	cur := series[n-1]
+
+	switch rule.Type {
+	case alertTypeCross:
+		if n < rule.Period+1 {
+			return "", false
+		}
+		var ma []float64
+		if rule.Indicator == "ema" {
+			ma = computeEMA(seriesCloses(series), rule.Period)
+		} else {
+			ma = computeSMA(seriesCloses(series), rule.Period)
+		}
+		prev, prevMA, curMA := series[n-2].Close, ma[n-2], ma[n-1]
+		if math.IsNaN(prevMA) || math.IsNaN(curMA) {
+			return "", false
+		}
+		name := fmt.Sprintf("%s%d", strings.ToUpper(rule.Indicator), rule.Period)
+		if prev <= prevMA && cur.Close > curMA && rule.Direction != "below" {
+			return fmt.Sprintf("收盘价 %g 上穿 %s（%g）", cur.Close, name, round8(curMA)), true
+		}
+		if prev >= prevMA && cur.Close < curMA && rule.Direction != "above" {
+			return fmt.Sprintf("收盘价 %g 下穿 %s（%g）", cur.Close, name, round8(curMA)), true
+		}
+
+	case alertTypeVolumeSpike:
+		if n < rule.Period+1 {
+			return "", false
+		}
+		sum := 0.0
+		for _, c := range series[n-1-rule.Period : n-1] {
+			sum += c.Volume
+		}
+		avg := sum / float64(rule.Period)
+		if avg > 0 && cur.Volume > avg*rule.Multiplier {
+			return fmt.Sprintf("成交量 %g 是前%d根平均成交量 %g 的 %.2f 倍", cur.Volume, rule.Period, round8(avg), cur.Volume/avg), true
+		}
+
+	case alertTypeChange:
+		bars := alertWindowBars(rule.Window, interval)
+		if n < bars+1 || series[n-1-bars].Close == 0 {
+			return "", false
+		}
+		base := series[n-1-bars].Close
+		pct := (cur.Close - base) / base * 100
+		window := rule.Window
+		if window == "" {
+			window = interval
+		}
+		if (pct >= rule.Threshold && rule.Direction != "down") || (pct <= -rule.Threshold && rule.Direction != "up") {
+			return fmt.Sprintf("%s内涨跌幅 %.2f%%（%g -> %g）", window, pct, base, cur.Close), true
+		}
+	}
+	return "", false
+}
+
+// round8 保留8位小数，避免告警内容中出现过长的浮点数
+func round8(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
+
+// AlertWebhook 告警触发时发送到webhook的内容
+type AlertWebhook struct {
+	Type      string `json:"type"` // alert
+	RuleID    int64  `json:"rule_id"`
+	Rule      string `json:"rule"`
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	Timestamp int64  `json:"timestamp"` // 触发告警的K线开盘时间（UTC毫秒）
+	Message   string `json:"message"`
+	Time      string `json:"time"`
+}
+
+// notifyAlert 通过配置的邮件、webhook和Telegram发送告警
+func notifyAlert(rec *db.AlertRecord) {
+	defer utils.Recover("alert_notify", nil)
+	if appConfig == nil {
+		return
+	}
+	cfg := &appConfig.Notify
+	text := fmt.Sprintf("[%s] %s", rec.RuleName, rec.Message)
+
+	if cfg.EmailEnabled() {
+		if err := utils.SendEmail(cfg, "BiUpData 告警: "+rec.RuleName, "<p>"+text+"</p>"); err != nil {
+			utils.LogError("发送告警失败: %v", err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		payload := AlertWebhook{
+			Type:      "alert",
+			RuleID:    rec.RuleID,
+			Rule:      rec.RuleName,
+			Symbol:    rec.Symbol,
+			Interval:  rec.Interval,
+			Timestamp: rec.Timestamp,
+			Message:   rec.Message,
+			Time:      utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		}
+		if err := utils.PostWebhook(cfg.WebhookURL, payload); err != nil {
+			utils.LogError("发送告警失败: %v", err)
+		}
+	}
+	if cfg.TelegramEnabled() {
+		if err := utils.SendTelegram(cfg, text); err != nil {
+			utils.LogError("发送告警失败: %v", err)
+		}
+	}
+}
+
+// AlertRulesResponse 告警规则列表
+type AlertRulesResponse struct {
+	Enabled bool           `json:"enabled"` // 是否启用了告警求值（ALERTS_ENABLED）
+	Rules   []db.AlertRule `json:"rules"`
+	Count   int            `json:"count"`
+}
+
+// AlertHistoryResponse 告警触发记录
+type AlertHistoryResponse struct {
+	Alerts []db.AlertRecord `json:"alerts"`
+	Count  int              `json:"count"`
+}
+
+// alertRuleRequest 新增或修改告警规则的请求体
+type alertRuleRequest struct {
+	Name       string  `json:"name"`
+	Symbol     string  `json:"symbol"`
+	Interval   string  `json:"interval"`
+	Type       string  `json:"type"`
+	Direction  string  `json:"direction"`
+	Indicator  string  `json:"indicator"`
+	Period     int     `json:"period"`
+	Multiplier float64 `json:"multiplier"`
+	Window     string  `json:"window"`
+	Threshold  float64 `json:"threshold"`
+	Cooldown   int     `json:"cooldown"`
+	Enabled    *bool   `json:"enabled"`
+}
+
+// bindAlertRule 解析并校验告警规则请求体，出错时已返回响应
+func bindAlertRule(c *gin.Context) (*db.AlertRule, bool) {
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.Interval == "" || req.Type == "" {
+		badRequest(c, "缺少必要参数: name, interval, type")
+		return nil, false
+	}
+	if utf8.RuneCountInString(req.Name) > 255 {
+		badRequest(c, "name 长度不能超过255个字符")
+		return nil, false
+	}
+
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	if req.Symbol == "" {
+		req.Symbol = "*"
+	}
+	if len(req.Symbol) > 32 {
+		badRequest(c, "无效的symbol参数")
+		return nil, false
+	}
+	if !config.IsSupportedInterval(req.Interval) {
+		badRequest(c, "无效的interval参数")
+		return nil, false
+	}
+	if req.Cooldown < 0 {
+		badRequest(c, "cooldown 不能为负数")
+		return nil, false
+	}
+
+	req.Direction = strings.ToLower(req.Direction)
+	if req.Direction == "" {
+		req.Direction = "any"
+	}
+	req.Indicator = strings.ToLower(req.Indicator)
+
+	switch req.Type {
+	case alertTypeCross:
+		if req.Direction != "above" && req.Direction != "below" && req.Direction != "any" {
+			badRequest(c, "cross 规则的 direction 只能是 above、below 或 any")
+			return nil, false
+		}
+		if req.Indicator == "" {
+			req.Indicator = "sma"
+		}
+		if req.Indicator != "sma" && req.Indicator != "ema" {
+			badRequest(c, "indicator 只能是 sma 或 ema")
+			return nil, false
+		}
+		if req.Period < 1 || req.Period > maxAlertPeriod {
+			badRequest(c, fmt.Sprintf("period 必须在1到%d之间", maxAlertPeriod))
+			return nil, false
+		}
+		req.Multiplier, req.Window, req.Threshold = 0, "", 0
+
+	case alertTypeVolumeSpike:
+		if req.Period < 1 || req.Period > maxAlertPeriod {
+			badRequest(c, fmt.Sprintf("period 必须在1到%d之间", maxAlertPeriod))
+			return nil, false
+		}
+		if req.Multiplier <= 0 {
+			badRequest(c, "volume_spike 规则需要大于0的 multiplier")
+			return nil, false
+		}
+		req.Direction, req.Indicator, req.Window, req.Threshold = "any", "", "", 0
+
+	case alertTypeChange:
+		if req.Direction != "up" && req.Direction != "down" && req.Direction != "any" {
+			badRequest(c, "change 规则的 direction 只能是 up、down 或 any")
+			return nil, false
+		}
+		if req.Threshold <= 0 {
+			badRequest(c, "change 规则需要大于0的 threshold（百分比）")
+			return nil, false
+		}
+		if req.Window != "" {
+			windowMs, ok := parseIntervalMilliseconds(req.Window)
+			intervalMs := getIntervalMilliseconds(req.Interval)
+			if !ok || req.Interval == "1M" || windowMs < intervalMs || windowMs%intervalMs != 0 {
+				badRequest(c, "window 必须是 interval 的整数倍，如 interval=1m、window=15m")
+				return nil, false
+			}
+			if windowMs/intervalMs > maxAlertPeriod {
+				badRequest(c, fmt.Sprintf("window 不能超过%d根K线", maxAlertPeriod))
+				return nil, false
+			}
+		}
+		req.Indicator, req.Period, req.Multiplier = "", 0, 0
+
+	default:
+		badRequest(c, "type 只能是 cross、volume_spike 或 change")
+		return nil, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return &db.AlertRule{
+		Name:       req.Name,
+		Symbol:     req.Symbol,
+		Interval:   req.Interval,
+		Type:       req.Type,
+		Direction:  req.Direction,
+		Indicator:  req.Indicator,
+		Period:     req.Period,
+		Multiplier: req.Multiplier,
+		Window:     req.Window,
+		Threshold:  req.Threshold,
+		Cooldown:   req.Cooldown,
+		Enabled:    enabled,
+	}, true
+}
+
+// alertRuleID 解析路径中的规则ID，出错时已返回响应
+func alertRuleID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		badRequest(c, "无效的规则ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// listAlertRules 查询全部告警规则
+func listAlertRules(c *gin.Context) {
+	rules, err := db.ListAlertRules()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if rules == nil {
+		rules = []db.AlertRule{}
+	}
+	respondOK(c, AlertRulesResponse{
+		Enabled: appConfig != nil && appConfig.Alerts.Enabled,
+		Rules:   rules,
+		Count:   len(rules),
+	})
+}
+
+// createAlertRule 新增告警规则
+func createAlertRule(c *gin.Context) {
+	rule, ok := bindAlertRule(c)
+	if !ok {
+		return
+	}
+
+	id, err := db.CreateAlertRule(rule)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidateAlertRules()
+
+	created, err := db.GetAlertRule(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "新增告警规则 %d: %s", id, created.Name)
+	respondOK(c, created)
+}
+
+// getAlertRule 查询单个告警规则
+func getAlertRule(c *gin.Context) {
+	id, ok := alertRuleID(c)
+	if !ok {
+		return
+	}
+
+	rule, err := db.GetAlertRule(id)
+	if err == db.ErrAlertRuleNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "告警规则不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, rule)
+}
+
+// updateAlertRule 修改告警规则
+func updateAlertRule(c *gin.Context) {
+	id, ok := alertRuleID(c)
+	if !ok {
+		return
+	}
+	rule, ok := bindAlertRule(c)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	err := db.UpdateAlertRule(rule)
+	if err == db.ErrAlertRuleNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "告警规则不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidateAlertRules()
+
+	updated, err := db.GetAlertRule(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "修改告警规则 %d: %s", id, updated.Name)
+	respondOK(c, updated)
+}
+
+// deleteAlertRule 删除告警规则
+func deleteAlertRule(c *gin.Context) {
+	id, ok := alertRuleID(c)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteAlertRule(id)
+	if err == db.ErrAlertRuleNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "告警规则不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidateAlertRules()
+	logRequestInfo(c, "删除告警规则 %d", id)
+	respondMessage(c, "告警规则已删除", nil)
+}
+
+// getAlertHistory 按触发时间倒序查询告警记录
+func getAlertHistory(c *gin.Context) {
+	var ruleID int64
+	if v := c.Query("rule_id"); v != "" {
+		var err error
+		if ruleID, err = strconv.ParseInt(v, 10, 64); err != nil || ruleID <= 0 {
+			badRequest(c, "无效的rule_id参数")
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	alerts, err := db.ListAlertHistory(ruleID, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if alerts == nil {
+		alerts = []db.AlertRecord{}
+	}
+	respondOK(c, AlertHistoryResponse{Alerts: alerts, Count: len(alerts)})
+}
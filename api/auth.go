@@ -0,0 +1,463 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 公钥集缓存时间，令牌使用了未知的kid时提前刷新，但两次刷新至少间隔jwksMinRefresh
+const (
+	jwksCacheTTL   = time.Hour
+	jwksMinRefresh = time.Minute
+	jwksTimeout    = 10 * time.Second
+)
+
+// 认证信息在gin.Context中的键
+const (
+	authSubjectKey = "auth_subject"
+	authRoleKey    = "auth_role"
+)
+
+// errUnknownKey 令牌的kid不在公钥集中
+var errUnknownKey = errors.New("令牌使用了未知的签名密钥")
+
+// jwksCache 身份提供方的公钥集，按kid索引
+type jwksCache struct {
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	url       string // 发现得到的公钥集地址
+}
+
+var jwks = &jwksCache{}
+
+var authClient = &http.Client{Timeout: jwksTimeout}
+
+// authMiddleware 校验Authorization请求头中的Bearer JWT，并按请求所需的角色授权
+// GET/HEAD需要read，其他方法需要write，/api/v1/admin/下的接口需要admin
+func authMiddleware(cfg *config.AuthConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isPublicPath(cfg.PublicPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if header == "" || token == header {
+			authFailed(c, "missing", http.StatusUnauthorized, CodeUnauthorized, "缺少Bearer令牌")
+			return
+		}
+
+		claims, err := verifyJWT(cfg, token)
+		if err != nil {
+			logRequestWarning(c, "令牌校验失败: %v", err)
+			authFailed(c, "invalid", http.StatusUnauthorized, CodeUnauthorized, "令牌无效: "+err.Error())
+			return
+		}
+
+		role := roleFromClaims(cfg, claims)
+		required := requiredRole(c.Request.Method, c.Request.URL.Path)
+		subject, _ := claims["sub"].(string)
+		if roleRank(role) < roleRank(required) {
+			logRequestWarning(c, "%s（角色 %q）无权访问 %s %s，需要 %s", subject, role, c.Request.Method, c.Request.URL.Path, required)
+			authFailed(c, "forbidden", http.StatusForbidden, CodeForbidden, "权限不足，需要 "+required+" 角色")
+			return
+		}
+
+		c.Set(authSubjectKey, subject)
+		c.Set(authRoleKey, role)
+		c.Next()
+	}
+}
+
+// authFailed 记录认证失败并返回错误响应
+func authFailed(c *gin.Context, reason string, status, code int, message string) {
+	utils.IncCounter(utils.MetricName("biupdata_auth_failures_total", "reason", reason))
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	respondError(c, status, code, message)
+}
+
+// isPublicPath 判断路径是否为不需要认证的路径或其子路径
+func isPublicPath(public []string, path string) bool {
+	for _, p := range public {
+		p = strings.TrimRight(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredRole 请求需要的最低角色
+func requiredRole(method, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/v1/admin/") || path == "/api/v1/admin":
+		return "admin"
+	case method == http.MethodGet || method == http.MethodHead:
+		return "read"
+	default:
+		return "write"
+	}
+}
+
+// roleRank 角色的权限等级，无效角色为-1
+func roleRank(role string) int {
+	for i, r := range config.AuthRoles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// roleFromClaims 从令牌的角色claim中取权限最高的角色，claim可以是字符串、字符串列表或空格分隔的字符串（如scope）
+func roleFromClaims(cfg *config.AuthConfig, claims map[string]interface{}) string {
+	var value interface{} = claims
+	for _, part := range strings.Split(cfg.RoleClaim, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			value = nil
+			break
+		}
+		value = m[part]
+	}
+
+	var values []string
+	switch v := value.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := ""
+	for _, v := range values {
+		role := v
+		if len(cfg.RoleMapping) > 0 {
+			role = cfg.RoleMapping[v]
+		}
+		if roleRank(role) > roleRank(best) {
+			best = role
+		}
+	}
+	if best == "" {
+		return cfg.DefaultRole
+	}
+	return best
+}
+
+// verifyJWT 校验令牌的签名、签发方、受众和有效期，返回其中的claims
+func verifyJWT(cfg *config.AuthConfig, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("令牌格式不正确")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("解析令牌头失败: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("令牌签名格式不正确")
+	}
+
+	key, err := jwks.key(cfg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("解析令牌内容失败: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("签发方 %q 不匹配", iss)
+	}
+	if cfg.Audience != "" && !audienceContains(claims["aud"], cfg.Audience) {
+		return nil, errors.New("受众不匹配")
+	}
+
+	now := time.Now().Unix()
+	leeway := int64(cfg.LeewaySeconds)
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("令牌缺少exp")
+	}
+	if now > int64(exp)+leeway {
+		return nil, errors.New("令牌已过期")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf)-leeway {
+		return nil, errors.New("令牌尚未生效")
+	}
+	return claims, nil
+}
+
+// decodeSegment 解码JWT中Base64URL编码的JSON片段
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audienceContains 判断aud（字符串或字符串列表）是否包含指定受众
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature 按alg校验签名，支持RS256/384/512和ES256/384/512，不接受none和HMAC算法
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var h hash.Hash
+	var hashType crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, hashType = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, hashType = sha512.New384(), crypto.SHA384
+	case "RS512", "ES512":
+		h, hashType = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("不支持的签名算法 %q", alg)
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("签名算法 %s 与RSA密钥不匹配", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hashType, digest, signature); err != nil {
+			return errors.New("签名校验失败")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("签名算法 %s 与EC密钥不匹配", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("签名长度不正确")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("签名校验失败")
+		}
+	default:
+		return errors.New("不支持的密钥类型")
+	}
+	return nil
+}
+
+// key 返回kid对应的公钥，缓存过期或kid未知时重新获取公钥集
+func (j *jwksCache) key(cfg *config.AuthConfig, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if time.Since(j.fetchedAt) > jwksCacheTTL {
+		if err := j.refresh(cfg); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	// 身份提供方轮换了密钥
+	if time.Since(j.fetchedAt) > jwksMinRefresh {
+		if err := j.refresh(cfg); err != nil {
+			return nil, err
+		}
+		if key, ok := j.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	if j.keys == nil {
+		return nil, errors.New("公钥集不可用，请检查 AUTH_JWT_ISSUER 或 AUTH_JWT_JWKS_URL")
+	}
+	return nil, errUnknownKey
+}
+
+// lookup 按kid查找公钥，令牌没有kid且公钥集只有一个密钥时使用该密钥
+func (j *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh 获取公钥集，未配置地址时先通过OIDC发现文档获取jwks_uri；调用方需持有锁
+func (j *jwksCache) refresh(cfg *config.AuthConfig) error {
+	j.fetchedAt = time.Now()
+
+	url := cfg.JWKSURL
+	if url == "" {
+		url = j.url
+	}
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getAuthJSON(strings.TrimRight(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			utils.LogError("获取OIDC发现文档失败: %v", err)
+			return fmt.Errorf("获取OIDC发现文档失败: %v", err)
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("OIDC发现文档中没有jwks_uri")
+		}
+		j.url, url = discovery.JWKSURI, discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getAuthJSON(url, &set); err != nil {
+		utils.LogError("获取公钥集失败: %v", err)
+		return fmt.Errorf("获取公钥集失败: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = parseRSAKey(k.N, k.E)
+		case "EC":
+			key, err = parseECKey(k.Crv, k.X, k.Y)
+		default:
+			continue
+		}
+		if err != nil {
+			utils.LogWarning("忽略无效的公钥 %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	j.keys = keys
+	utils.LogInfo("已加载公钥集 %s，共 %d 个密钥", url, len(keys))
+	return nil
+}
+
+// getAuthJSON 请求身份提供方的JSON文档
+func getAuthJSON(url string, v interface{}) error {
+	resp, err := authClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// parseRSAKey 由JWK的n、e构造RSA公钥
+func parseRSAKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("无效的RSA公钥指数")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
+
+// parseECKey 由JWK的crv、x、y构造EC公钥
+func parseECKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("不支持的曲线 %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("公钥不在曲线上")
+	}
+	return key, nil
+}
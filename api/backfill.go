@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 试运行报告中最多列出的无效K线数量
+const dryRunMaxProblems = 20
+
+// DryRunReport 试运行的结果：从币安获取并校验K线，与数据库比较后统计将要写入的记录，不写入任何数据
+type DryRunReport struct {
+	Symbol      string     `json:"symbol"`
+	Interval    string     `json:"interval"`
+	StartTime   int64      `json:"start_time"` // 获取范围的开始时间（UTC毫秒）
+	EndTime     int64      `json:"end_time"`   // 获取范围的结束时间（UTC毫秒）
+	CreateTable bool       `json:"create_table"`
+	Fetched     int        `json:"fetched"`
+	Inserted    int        `json:"inserted"`  // 数据库中没有、将要插入的K线
+	Updated     int        `json:"updated"`   // 数据库中已有但数值不同、将要覆盖的K线
+	Unchanged   int        `json:"unchanged"` // 与数据库一致的K线
+	Skipped     int        `json:"skipped"`   // 配置了不保存未收盘K线时跳过的K线
+	Invalid     int        `json:"invalid"`   // 格式或数值无效、写入时会出错的K线
+	Problems    []string   `json:"problems"`
+	Gaps        []KlineGap `json:"gaps"` // 将被补上的缺口：位于已有数据范围内的插入
+	Elapsed     string     `json:"elapsed"`
+
+	firstStored int64     // 已有数据的第一根K线（UTC毫秒）
+	lastStored  int64     // 已有数据的最后一根K线（UTC毫秒）
+	gap         *KlineGap // 正在累计的缺口
+}
+
+// PreviewInterval 试运行单个交易对和时间间隔的更新：startUTC为0时与增量更新的范围相同，
+// 否则获取[startUTC, endUTC]范围（endUTC为0表示到当前时间）
+func PreviewInterval(symbol, interval string, startUTC, endUTC int64) (*DryRunReport, error) {
+	began := time.Now()
+	report := &DryRunReport{Symbol: symbol, Interval: interval, Problems: []string{}, Gaps: []KlineGap{}}
+
+	exists, err := db.KlineTableExists(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	report.CreateTable = !exists
+	if exists {
+		first, last, count, err := db.GetKlineTimeRange(symbol, interval)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			report.firstStored = utils.StoredTimestampToUTC(first)
+			report.lastStored = utils.StoredTimestampToUTC(last)
+		}
+	}
+
+	if startUTC == 0 {
+		if exists {
+			startUTC, err = defaultUpdater.syncStartTime(symbol, interval)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			startUTC = utils.StoredTimestampToUTC(defaultUpdater.initialStartTimestamp(symbol, interval))
+		}
+	}
+	report.StartTime = startUTC
+	report.EndTime = endUTC
+	if report.EndTime == 0 {
+		report.EndTime = time.Now().UnixMilli()
+	}
+
+	_, _, err = defaultUpdater.fetchKlinePages(context.Background(), symbol, interval, startUTC, endUTC, func(klines []db.Candle) (int, error) {
+		return len(klines), planKlinePage(report, klines, exists)
+	})
+	report.closeGap()
+	report.Elapsed = time.Since(began).Round(time.Millisecond).String()
+	return report, err
+}
+
+// planKlinePage 校验一页K线并与数据库比较，累计到report中
+func planKlinePage(report *DryRunReport, klines []db.Candle, exists bool) error {
+	report.Fetched += len(klines)
+
+	var valid []db.Candle
+	var openTimes []int64
+	skipOpen := appConfig != nil && appConfig.Binance.SkipOpenCandle
+	for _, kline := range klines {
+		openTime, err := validateKline(kline)
+		if err != nil {
+			report.Invalid++
+			if len(report.Problems) < dryRunMaxProblems {
+				report.Problems = append(report.Problems, err.Error())
+			}
+			continue
+		}
+		if skipOpen && !kline.Closed {
+			report.Skipped++
+			continue
+		}
+		valid = append(valid, kline)
+		openTimes = append(openTimes, openTime)
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+
+	stored := make(map[int64]db.Candle)
+	if exists {
+		rows, err := db.GetKlineData(report.Symbol, report.Interval, openTimes[0], openTimes[len(openTimes)-1], len(valid)+1)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			stored[utils.StoredTimestampToUTC(row.OpenTime)] = row
+		}
+	}
+
+	for i, kline := range valid {
+		openTime := openTimes[i]
+		row, ok := stored[openTime]
+		if !ok {
+			report.Inserted++
+			report.noteInsert(openTime)
+			continue
+		}
+		report.closeGap()
+
+		changed := false
+		for _, f := range verifyFields {
+			if !decimalEqual(f.value(row), f.value(kline)) {
+				changed = true
+				break
+			}
+		}
+		if changed {
+			report.Updated++
+		} else {
+			report.Unchanged++
+		}
+	}
+	return nil
+}
+
+// noteInsert 插入位于已有数据范围内时计入缺口，与上一根插入相邻时合并为同一个缺口
+func (r *DryRunReport) noteInsert(openTime int64) {
+	if r.lastStored == 0 || openTime <= r.firstStored || openTime >= r.lastStored {
+		r.closeGap()
+		return
+	}
+	if r.gap != nil && nextIntervalStart(r.Interval, r.gap.End) == openTime {
+		r.gap.End = openTime
+		r.gap.Missing++
+		return
+	}
+	r.closeGap()
+	r.gap = &KlineGap{Start: openTime, End: openTime, Missing: 1}
+}
+
+// closeGap 结束正在累计的缺口
+func (r *DryRunReport) closeGap() {
+	if r.gap == nil {
+		return
+	}
+	r.gap.StartDatetime = utils.TimestampToShanghai(r.gap.Start).Format("2006-01-02 15:04")
+	r.gap.EndDatetime = utils.TimestampToShanghai(r.gap.End).Format("2006-01-02 15:04")
+	r.Gaps = append(r.Gaps, *r.gap)
+	r.gap = nil
+}
+
+// validateKline 检查币安返回的K线能否写入：开盘时间和价格、成交量的格式，以及最高价、最低价与开盘、收盘价的关系
+func validateKline(kline db.Candle) (int64, error) {
+	label := utils.TimestampToShanghai(kline.OpenTime).Format("2006-01-02 15:04")
+
+	values := make(map[string]float64, len(verifyFields))
+	for _, f := range verifyFields {
+		s := f.value(kline)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("%s 的 %s 无效: %s", label, f.column, s)
+		}
+		values[f.column] = v
+	}
+
+	o, h, l, c := values["open_price"], values["high_price"], values["low_price"], values["close_price"]
+	if h < l || h < o || h < c || l > o || l > c {
+		return 0, fmt.Errorf("%s 的最高价、最低价与开盘、收盘价不符: O=%v H=%v L=%v C=%v", label, o, h, l, c)
+	}
+	return kline.OpenTime, nil
+}
+
+// BackfillRange 重新获取[startUTC, endUTC]范围内的K线并覆盖写入，用于补齐历史缺口；
+// 不推进同步水位，增量更新仍从原来的位置继续
+func BackfillRange(symbol, interval string, startUTC, endUTC int64) (int, error) {
+	lockKey := fmt.Sprintf("update:%s:%s", symbol, interval)
+	lockToken, locked, err := defaultUpdater.acquireLock(lockKey)
+	if err != nil {
+		return 0, fmt.Errorf("获取 %s %s 的分布式锁失败，请稍后重试: %v", symbol, interval, err)
+	}
+	if !locked {
+		return 0, fmt.Errorf("%s %s 正由其他实例更新，请稍后重试", symbol, interval)
+	}
+	defer defaultUpdater.releaseLock(lockKey, lockToken)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer defaultUpdater.keepLock(lockKey, lockToken, cancel)()
+	total, _, err := defaultUpdater.fetchKlinePages(ctx, symbol, interval, startUTC, endUTC, func(klines []db.Candle) (int, error) {
+		return ProcessKlineData(ctx, symbol, interval, klines)
+	})
+	if total > 0 {
+		materializeStats(symbol, interval, total)
+	}
+	return total, err
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 根据数据库写入情况调整并发数的间隔
+const backpressureCheckInterval = 5 * time.Second
+
+var (
+	bpLimit     int       // 当前允许同时执行的更新数，0表示尚未初始化
+	bpRunning   int       // 正在执行的更新数
+	bpWaiting   int       // 等待执行的更新数
+	bpThrottled bool      // 是否因数据库跟不上而降低了并发
+	bpReason    string    // 降低并发的原因
+	bpSince     time.Time // 开始降低并发的时间
+	bpMutex     sync.Mutex
+	bpCond      = sync.NewCond(&bpMutex)
+	bpOnce      sync.Once
+)
+
+// BackpressureStatus 背压状态
+type BackpressureStatus struct {
+	Throttled      bool    `json:"throttled"`        // 是否因数据库跟不上而降低了并发
+	Reason         string  `json:"reason,omitempty"` // 降低并发的原因
+	Since          string  `json:"since,omitempty"`
+	Limit          int     `json:"limit"`           // 当前允许同时执行的更新数
+	MaxConcurrency int     `json:"max_concurrency"` // 并发上限
+	Running        int     `json:"running"`
+	Waiting        int     `json:"waiting"`          // 等待执行的更新数
+	WriteLatencyMs float64 `json:"write_latency_ms"` // 批量写入的平均耗时
+	QueuePending   int     `json:"queue_pending"`    // 磁盘缓存队列中未写入数据库的K线数
+}
+
+// backpressureConfig 背压配置，未启用时返回nil
+func backpressureConfig() *config.BackpressureConfig {
+	if appConfig == nil || !appConfig.Backpressure.Enabled {
+		return nil
+	}
+	return &appConfig.Backpressure
+}
+
+// acquireUpdateSlot 等待执行一个交易对和时间间隔的更新，返回的函数在更新结束后调用；
+// 并发数达到当前上限时阻塞，避免数据库变慢时不断有更新同时拉取和写入
+func acquireUpdateSlot() func() {
+	cfg := backpressureConfig()
+	if cfg == nil {
+		return func() {}
+	}
+	bpOnce.Do(func() {
+		go func() {
+			defer utils.Recover("backpressure", nil)
+			for {
+				time.Sleep(backpressureCheckInterval)
+				adjustBackpressure()
+			}
+		}()
+	})
+
+	bpMutex.Lock()
+	if bpLimit == 0 {
+		bpLimit = cfg.MaxConcurrency
+	}
+	bpWaiting++
+	utils.SetGauge("biupdata_update_waiting", float64(bpWaiting))
+	for bpRunning >= bpLimit {
+		bpCond.Wait()
+	}
+	bpWaiting--
+	bpRunning++
+	utils.SetGauge("biupdata_update_waiting", float64(bpWaiting))
+	bpMutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			bpMutex.Lock()
+			bpRunning--
+			bpMutex.Unlock()
+			bpCond.Broadcast()
+		})
+	}
+}
+
+// adjustBackpressure 数据库写入变慢、等待的更新堆积或数据库不可用时并发数减半，恢复后逐步加回上限
+func adjustBackpressure() {
+	cfg := backpressureConfig()
+	if cfg == nil {
+		return
+	}
+	latency := db.BatchWriteLatency()
+	pending := db.QueuePending()
+	threshold := time.Duration(cfg.WriteLatencyMs) * time.Millisecond
+
+	bpMutex.Lock()
+	if bpLimit == 0 {
+		bpLimit = cfg.MaxConcurrency
+	}
+	var reasons []string
+	if latency > threshold {
+		reasons = append(reasons, fmt.Sprintf("批量写入平均耗时 %v 超过 %v", latency.Round(time.Millisecond), threshold))
+	}
+	if bpWaiting > cfg.QueueDepth {
+		reasons = append(reasons, fmt.Sprintf("%d 个更新等待执行，超过 %d", bpWaiting, cfg.QueueDepth))
+	}
+	if pending > 0 {
+		reasons = append(reasons, fmt.Sprintf("数据库不可用，缓存队列中有 %d 条K线", pending))
+	}
+
+	started, recovered := false, false
+	if len(reasons) > 0 {
+		if bpLimit > 1 {
+			bpLimit /= 2
+		}
+		bpReason = strings.Join(reasons, "；")
+		if !bpThrottled {
+			bpThrottled = true
+			bpSince = time.Now()
+			started = true
+		}
+	} else if latency <= threshold/2 && bpWaiting <= cfg.QueueDepth/2 {
+		if bpLimit < cfg.MaxConcurrency {
+			bpLimit++
+		}
+		if bpLimit > cfg.MaxConcurrency {
+			bpLimit = cfg.MaxConcurrency
+		}
+		if bpThrottled && bpLimit == cfg.MaxConcurrency {
+			bpThrottled = false
+			bpReason = ""
+			recovered = true
+		}
+	}
+	limit, reason, since, throttled := bpLimit, bpReason, bpSince, bpThrottled
+	bpMutex.Unlock()
+	// 上限提高后唤醒等待的更新
+	bpCond.Broadcast()
+
+	utils.SetGauge("biupdata_update_concurrency_limit", float64(limit))
+	if throttled {
+		utils.SetGauge("biupdata_backpressure_active", 1)
+	} else {
+		utils.SetGauge("biupdata_backpressure_active", 0)
+	}
+
+	if started {
+		utils.IncCounter("biupdata_backpressure_events_total")
+		utils.LogWarning("数据库写入跟不上，更新并发数降为 %d: %s", limit, reason)
+		go notifyBackpressure(true, fmt.Sprintf("数据库写入跟不上，更新并发数降为 %d: %s", limit, reason))
+	}
+	if recovered {
+		duration := time.Since(since).Round(time.Second)
+		utils.LogInfo("数据库写入已恢复，更新并发数恢复为 %d，降低并发持续了 %v", limit, duration)
+		go notifyBackpressure(false, fmt.Sprintf("数据库写入已恢复，更新并发数恢复为 %d，降低并发持续了 %v", limit, duration))
+	}
+}
+
+// BackpressureWebhook 开始和结束降低并发时发送到webhook的内容
+type BackpressureWebhook struct {
+	Type      string `json:"type"` // backpressure
+	Throttled bool   `json:"throttled"`
+	Limit     int    `json:"limit"`
+	Message   string `json:"message"`
+	Time      string `json:"time"`
+}
+
+// notifyBackpressure 通过配置的邮件和webhook发送背压开始或结束的通知
+func notifyBackpressure(throttled bool, msg string) {
+	defer utils.Recover("backpressure_notify", nil)
+	if appConfig == nil {
+		return
+	}
+	cfg := &appConfig.Notify
+
+	if cfg.EmailEnabled() {
+		subject := "BiUpData 数据库写入积压"
+		if !throttled {
+			subject = "BiUpData 数据库写入已恢复"
+		}
+		if err := utils.SendEmail(cfg, subject, "<p>"+msg+"</p>"); err != nil {
+			utils.LogError("发送背压通知失败: %v", err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		bpMutex.Lock()
+		limit := bpLimit
+		bpMutex.Unlock()
+		payload := BackpressureWebhook{
+			Type:      "backpressure",
+			Throttled: throttled,
+			Limit:     limit,
+			Message:   msg,
+			Time:      utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		}
+		if err := utils.PostWebhook(cfg.WebhookURL, payload); err != nil {
+			utils.LogError("发送背压通知失败: %v", err)
+		}
+	}
+}
+
+// currentBackpressureStatus 背压状态，未启用时返回nil
+func currentBackpressureStatus() *BackpressureStatus {
+	cfg := backpressureConfig()
+	if cfg == nil {
+		return nil
+	}
+
+	bpMutex.Lock()
+	status := &BackpressureStatus{
+		Throttled:      bpThrottled,
+		Reason:         bpReason,
+		Limit:          bpLimit,
+		MaxConcurrency: cfg.MaxConcurrency,
+		Running:        bpRunning,
+		Waiting:        bpWaiting,
+	}
+	if bpThrottled {
+		status.Since = utils.UTCToShanghai(bpSince).Format("2006-01-02 15:04:05")
+	}
+	bpMutex.Unlock()
+
+	if status.Limit == 0 {
+		status.Limit = cfg.MaxConcurrency
+	}
+	status.WriteLatencyMs = float64(db.BatchWriteLatency().Microseconds()) / 1000
+	status.QueuePending = db.QueuePending()
+	return status
+}
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 一次切分返回的K线数上限（训练集与测试集合计），超过时需要缩小区间
+const backtestMaxRows = 100000
+
+// 数据集哈希的格式版本，哈希的计算方式变化时递增，避免新旧哈希相同但内容不同
+const datasetHashVersion = "biupdata-dataset-v1"
+
+// 只含日期的切分边界，按配置的时区取当天零点
+var dateOnlyPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// 每根K线固定输出的特征列
+var baseFeatureColumns = []string{"open", "high", "low", "close", "volume"}
+
+// BacktestSplit 训练集或测试集，rows的每一行与timestamps对齐，列顺序见columns，数据不足的指标为null
+type BacktestSplit struct {
+	Start      int64        `json:"start"` // 区间开始（含，UTC毫秒）
+	End        int64        `json:"end"`   // 区间结束（不含，UTC毫秒）
+	Count      int          `json:"count"`
+	Timestamps []int64      `json:"timestamps"` // K线开盘时间（UTC毫秒）
+	Rows       [][]*float64 `json:"rows"`
+}
+
+// BacktestWindowResponse 按时间边界切分的训练集和测试集
+type BacktestWindowResponse struct {
+	Symbol      string        `json:"symbol"`
+	Interval    string        `json:"interval"`
+	DatasetID   int64         `json:"dataset_id,omitempty"` // 引用的数据集ID，为空时读取K线表
+	Columns     []string      `json:"columns"`
+	Warmup      int           `json:"warmup"` // 训练集之前用于指标预热的K线数，不在返回结果中
+	DropNA      bool          `json:"dropna"`
+	Train       BacktestSplit `json:"train"`
+	Test        BacktestSplit `json:"test"`
+	DatasetHash string        `json:"dataset_hash"` // 参数和原始K线的SHA-256，数据或参数不变时保持不变
+}
+
+// backtestDataset 一个切分数据集的原始K线和计算好的特征
+type backtestDataset struct {
+	symbol, interval string
+	trainStart       int64
+	split            int64
+	testEnd          int64
+	specs            []indicatorSpec
+	dropNA           bool
+	datasetID        int64 // 非0时从该数据集读取K线，否则读取K线表
+
+	warmup  []db.Candle // 训练集之前的预热K线，升序，OpenTime为UTC毫秒
+	raw     []db.Candle // [trainStart, testEnd)内已收盘的K线，升序，OpenTime为UTC毫秒
+	columns []string
+	candles []db.Candle  // 输出的K线，dropNA时去掉了特征不完整的行
+	rows    [][]*float64 // 与candles对齐
+}
+
+// errBacktestTooLarge 区间内K线过多
+var errBacktestTooLarge = fmt.Errorf("区间内的K线超过%d根，请缩小区间", backtestMaxRows)
+
+// parseBoundary 解析切分边界：YYYY-MM-DD（配置时区当天零点）、RFC3339时间或毫秒时间戳
+func parseBoundary(v string) (int64, error) {
+	if dateOnlyPattern.MatchString(v) {
+		t, err := utils.ParseShanghaiDate(v)
+		if err != nil {
+			return 0, err
+		}
+		return t.UnixMilli(), nil
+	}
+	return parseTimeParam(v)
+}
+
+// featureColumns 按请求的顺序列出特征列，重复的指标只保留一次
+func featureColumns(specs []indicatorSpec) []string {
+	columns := append([]string(nil), baseFeatureColumns...)
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		keys := []string{indicatorKey(spec)}
+		if spec.Name == "macd" {
+			keys = []string{"macd", "macd_signal", "macd_hist"}
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	return columns
+}
+
+// indicatorWarmup 指标收敛需要的预热K线数，与技术指标接口相同
+func indicatorWarmup(specs []indicatorSpec) int {
+	warmup := 0
+	for _, spec := range specs {
+		if w := spec.Period * 3; w > warmup {
+			warmup = w
+		}
+	}
+	return warmup
+}
+
+// loadBacktestDataset 读取预热K线和区间内已收盘的K线并计算特征，区间内K线超过上限时返回错误
+func loadBacktestDataset(ds *backtestDataset) error {
+	if ds.datasetID > 0 {
+		if err := ds.loadFromDataset(); err != nil {
+			return err
+		}
+		ds.computeFeatures()
+		return nil
+	}
+
+	if n := indicatorWarmup(ds.specs); n > 0 {
+		rows, err := db.QueryKlineData(ds.symbol, ds.interval, 0, ds.trainStart-1, n)
+		if err != nil {
+			return err
+		}
+		for i := len(rows) - 1; i >= 0; i-- {
+			k := rows[i]
+			k.OpenTime = utils.StoredTimestampToUTC(k.OpenTime)
+			ds.warmup = append(ds.warmup, k)
+		}
+	}
+
+	rows, err := db.QueryKlineRange(ds.symbol, ds.interval, ds.trainStart, ds.testEnd-1, backtestMaxRows+1)
+	if err != nil {
+		return err
+	}
+	if len(rows) > backtestMaxRows {
+		return errBacktestTooLarge
+	}
+	// 未收盘的K线还会变化，不放入数据集，保证同样的参数得到同样的数据
+	now := time.Now().UnixMilli()
+	for _, k := range rows {
+		k.OpenTime = utils.StoredTimestampToUTC(k.OpenTime)
+		if nextIntervalStart(ds.interval, k.OpenTime) > now {
+			break
+		}
+		ds.raw = append(ds.raw, k)
+	}
+
+	ds.computeFeatures()
+	return nil
+}
+
+// loadFromDataset 从数据集读取预热K线和区间内的K线，数据集中只有已收盘的K线
+func (ds *backtestDataset) loadFromDataset() error {
+	if n := indicatorWarmup(ds.specs); n > 0 {
+		rows, err := db.QueryDatasetBefore(ds.datasetID, ds.trainStart, n)
+		if err != nil {
+			return err
+		}
+		for i := len(rows) - 1; i >= 0; i-- {
+			ds.warmup = append(ds.warmup, rows[i])
+		}
+	}
+
+	rows, err := db.QueryDatasetRange(ds.datasetID, ds.trainStart, ds.testEnd-1, backtestMaxRows+1)
+	if err != nil {
+		return err
+	}
+	if len(rows) > backtestMaxRows {
+		return errBacktestTooLarge
+	}
+	ds.raw = rows
+	return nil
+}
+
+// computeFeatures 在预热K线和区间K线组成的连续序列上计算特征，只保留区间内的行
+func (ds *backtestDataset) computeFeatures() {
+	all := append(append([]db.Candle(nil), ds.warmup...), ds.raw...)
+	series := make([]ohlcv, len(all))
+	for i, k := range all {
+		series[i] = ohlcv{
+			Timestamp: k.OpenTime,
+			Open:      parseDecimal(k.Open),
+			High:      parseDecimal(k.High),
+			Low:       parseDecimal(k.Low),
+			Close:     parseDecimal(k.Close),
+			Volume:    parseDecimal(k.Volume),
+		}
+	}
+	indicators := computeIndicators(series, ds.specs)
+
+	ds.columns = featureColumns(ds.specs)
+	offset := len(ds.warmup)
+	var candles []db.Candle
+	var rows [][]*float64
+	for i := offset; i < len(series); i++ {
+		row := make([]*float64, len(ds.columns))
+		complete := true
+		for j, col := range ds.columns {
+			var v float64
+			switch col {
+			case "open":
+				v = series[i].Open
+			case "high":
+				v = series[i].High
+			case "low":
+				v = series[i].Low
+			case "close":
+				v = series[i].Close
+			case "volume":
+				v = series[i].Volume
+			default:
+				v = indicators[col][i]
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				complete = false
+				continue
+			}
+			row[j] = &v
+		}
+		if ds.dropNA && !complete {
+			continue
+		}
+		candles = append(candles, all[i])
+		rows = append(rows, row)
+	}
+	ds.candles = candles
+	ds.rows = rows
+}
+
+// hash 数据集哈希：参数、特征列和全部原始K线（含预热K线和dropNA去掉的行）按固定格式写入SHA-256，
+// 特征由这些输入确定性地计算得出，因此不参与哈希
+func (ds *backtestDataset) hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%d\n%d\n%d\n%t\n%s\n", datasetHashVersion, ds.symbol, ds.interval,
+		ds.trainStart, ds.split, ds.testEnd, ds.dropNA, strings.Join(ds.columns, ","))
+	for _, part := range [][]db.Candle{ds.warmup, ds.raw} {
+		fmt.Fprintf(h, "%d\n", len(part))
+		for _, k := range part {
+			fmt.Fprintf(h, "%d,%s,%s,%s,%s,%s\n", k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splits 按切分点把行分为训练集和测试集
+func (ds *backtestDataset) splits() (BacktestSplit, BacktestSplit) {
+	train := BacktestSplit{Start: ds.trainStart, End: ds.split, Timestamps: []int64{}, Rows: [][]*float64{}}
+	test := BacktestSplit{Start: ds.split, End: ds.testEnd, Timestamps: []int64{}, Rows: [][]*float64{}}
+	for i, k := range ds.candles {
+		part := &train
+		if k.OpenTime >= ds.split {
+			part = &test
+		}
+		part.Timestamps = append(part.Timestamps, k.OpenTime)
+		part.Rows = append(part.Rows, ds.rows[i])
+	}
+	train.Count = len(train.Rows)
+	test.Count = len(test.Rows)
+	return train, test
+}
+
+// getBacktestWindow 按train_start、split、test_end切分训练集和测试集，特征列含OHLCV和请求的指标；
+// 指定dataset时从该数据集读取K线，交易对和时间间隔取自数据集，结果不受之后修复K线的影响
+func getBacktestWindow(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+
+	var datasetID int64
+	if v := c.Query("dataset"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			badRequest(c, "无效的dataset参数")
+			return
+		}
+		dataset, ok := loadDataset(c, id)
+		if !ok {
+			return
+		}
+		if dataset.Status != "ready" {
+			respondError(c, http.StatusConflict, CodeConflict, "数据集尚未创建完成: "+v)
+			return
+		}
+		if (symbol != "" && symbol != dataset.Symbol) || (interval != "" && interval != dataset.Interval) {
+			badRequest(c, "symbol、interval与数据集不一致，数据集为 "+dataset.Symbol+" "+dataset.Interval)
+			return
+		}
+		datasetID = id
+		symbol = dataset.Symbol
+		interval = dataset.Interval
+	}
+
+	if symbol == "" || interval == "" || c.Query("train_start") == "" || c.Query("split") == "" || c.Query("test_end") == "" {
+		badRequest(c, "缺少必要参数: symbol, interval, train_start, split, test_end")
+		return
+	}
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	var bounds [3]int64
+	for i, name := range []string{"train_start", "split", "test_end"} {
+		v, err := parseBoundary(c.Query(name))
+		if err != nil {
+			badRequest(c, "无效的"+name+"参数，应为日期（如 2024-01-01）、RFC3339时间或毫秒时间戳")
+			return
+		}
+		bounds[i] = v
+	}
+	if bounds[0] <= 0 || !(bounds[0] < bounds[1] && bounds[1] < bounds[2]) {
+		badRequest(c, "需要满足 0 < train_start < split < test_end")
+		return
+	}
+
+	var specs []indicatorSpec
+	if raw := c.Query("indicators"); raw != "" {
+		var err error
+		if specs, err = parseIndicatorSpecs(raw); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	}
+
+	ds := &backtestDataset{
+		symbol:     symbol,
+		interval:   interval,
+		trainStart: bounds[0],
+		split:      bounds[1],
+		testEnd:    bounds[2],
+		specs:      specs,
+		dropNA:     c.Query("dropna") == "true",
+		datasetID:  datasetID,
+	}
+	if err := loadBacktestDataset(ds); err != nil {
+		if err == errBacktestTooLarge {
+			badRequest(c, err.Error())
+			return
+		}
+		internalError(c, err)
+		return
+	}
+
+	train, test := ds.splits()
+	respondOK(c, BacktestWindowResponse{
+		Symbol:      symbol,
+		Interval:    interval,
+		DatasetID:   datasetID,
+		Columns:     ds.columns,
+		Warmup:      len(ds.warmup),
+		DropNA:      ds.dropNA,
+		Train:       train,
+		Test:        test,
+		DatasetHash: ds.hash(),
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 同一时间只允许一个恢复任务
+var restoreMutex sync.Mutex
+
+// parseBackupFilter 解析symbols、intervals参数，时间间隔无效时返回错误响应
+func parseBackupFilter(c *gin.Context) (db.BackupFilter, bool) {
+	filter := db.NewBackupFilter(c.Query("symbols"), c.Query("intervals"))
+	for _, interval := range filter.Intervals {
+		if !config.IsSupportedInterval(interval) {
+			badRequest(c, "不支持的时间间隔: "+interval)
+			return filter, false
+		}
+	}
+	return filter, true
+}
+
+// downloadBackup 在一致性快照中导出选中的K线表，以tar.gz归档流式返回，不依赖mysqldump
+// 已开始输出后出错时归档被截断，缺少结尾的summary.json，恢复时会报告不完整
+func downloadBackup(c *gin.Context) {
+	filter, ok := parseBackupFilter(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", db.BackupFormatSQL)
+	if format != db.BackupFormatSQL && format != db.BackupFormatCSV {
+		badRequest(c, "format 只能是 sql 或 csv")
+		return
+	}
+
+	name := fmt.Sprintf("biupdata-backup-%s.tar.gz", utils.GetShanghaiNow().Format("20060102-150405"))
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Status(http.StatusOK)
+
+	manifest, err := db.WriteBackup(c.Writer, filter, format)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			if errors.Is(err, db.ErrNoBackupTables) {
+				respondError(c, http.StatusNotFound, CodeNotFound, err.Error())
+				return
+			}
+			internalError(c, err)
+			return
+		}
+		logRequestError(c, "备份中断: %v", err)
+		return
+	}
+	logRequestInfo(c, "备份了 %d 个K线表共 %d 条K线", len(manifest.Series), manifest.Rows)
+}
+
+// restoreBackup 从请求体中的备份归档恢复K线，symbols、intervals参数可只恢复其中一部分
+func restoreBackup(c *gin.Context) {
+	filter, ok := parseBackupFilter(c)
+	if !ok {
+		return
+	}
+	if !restoreMutex.TryLock() {
+		respondError(c, http.StatusConflict, CodeConflict, "已有恢复任务正在执行")
+		return
+	}
+	defer restoreMutex.Unlock()
+
+	result, err := db.RestoreBackup(c.Request.Body, filter)
+	if err != nil {
+		if result == nil {
+			badRequest(c, err.Error())
+			return
+		}
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "从备份恢复了 %d 个K线表共 %d 条K线，完整: %v", len(result.Series), result.Rows, result.Complete)
+	respondOK(c, result)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 批量查询K线时单次最多的交易对数量
+const batchMaxSymbols = 20
+
+// BatchKlineResponse 多个交易对按开盘时间对齐的K线，klines中每个交易对的序列与timestamps一一对应，缺失处为null
+type BatchKlineResponse struct {
+	Interval   string                  `json:"interval"`
+	Symbols    []string                `json:"symbols"`
+	Timestamps []int64                 `json:"timestamps"`
+	Klines     map[string][]*KlineItem `json:"klines"`
+	Count      int                     `json:"count"`
+}
+
+// BatchKlineRow 透视后的一行：同一开盘时间下各交易对的K线
+type BatchKlineRow struct {
+	Timestamp int64                 `json:"timestamp"`
+	Datetime  string                `json:"datetime"`
+	Klines    map[string]*KlineItem `json:"klines"`
+}
+
+// PivotKlineResponse 按开盘时间透视的批量K线
+type PivotKlineResponse struct {
+	Interval string          `json:"interval"`
+	Symbols  []string        `json:"symbols"`
+	Rows     []BatchKlineRow `json:"rows"`
+	Count    int             `json:"count"`
+}
+
+// getKlineBatch 一次查询多个交易对同一时间间隔的K线，按开盘时间对齐
+func getKlineBatch(c *gin.Context) {
+	interval := c.Query("interval")
+	if c.Query("symbols") == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbols, interval")
+		return
+	}
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	// 去重并保持请求中的顺序
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(c.Query("symbols"), ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s != "" && !seen[s] {
+			seen[s] = true
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) == 0 || len(symbols) > batchMaxSymbols {
+		badRequest(c, "symbols 数量必须在1到"+strconv.Itoa(batchMaxSymbols)+"之间")
+		return
+	}
+
+	startTime, endTime, limit, ok := parseKlineRange(c)
+	if !ok {
+		return
+	}
+
+	// 各交易对并行查询
+	results := make([][]KlineItem, len(symbols))
+	errs := make([]error, len(symbols))
+	var wg sync.WaitGroup
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func(i int, symbol string) {
+			defer wg.Done()
+			defer utils.Recover("batch_query", &errs[i])
+			results[i], errs[i] = GetKlineDataFromDB(symbol, interval, startTime, endTime, limit)
+		}(i, symbol)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			internalError(c, fmt.Errorf("查询 %s 失败: %w", symbols[i], err))
+			return
+		}
+	}
+
+	// 所有交易对开盘时间的并集，与 /api/v1/kline 一样按时间倒序
+	bySymbol := make([]map[int64]*KlineItem, len(symbols))
+	datetimes := make(map[int64]string)
+	for i := range symbols {
+		bySymbol[i] = make(map[int64]*KlineItem, len(results[i]))
+		for j := range results[i] {
+			item := &results[i][j]
+			bySymbol[i][item.Timestamp] = item
+			datetimes[item.Timestamp] = item.Datetime
+		}
+	}
+	timestamps := make([]int64, 0, len(datetimes))
+	for ts := range datetimes {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] > timestamps[j] })
+
+	if c.Query("pivot") == "true" {
+		rows := make([]BatchKlineRow, len(timestamps))
+		for i, ts := range timestamps {
+			rows[i] = BatchKlineRow{Timestamp: ts, Datetime: datetimes[ts], Klines: make(map[string]*KlineItem, len(symbols))}
+			for k, symbol := range symbols {
+				rows[i].Klines[symbol] = bySymbol[k][ts]
+			}
+		}
+		respondOK(c, PivotKlineResponse{
+			Interval: interval,
+			Symbols:  symbols,
+			Rows:     rows,
+			Count:    len(rows),
+		})
+		return
+	}
+
+	klines := make(map[string][]*KlineItem, len(symbols))
+	for k, symbol := range symbols {
+		aligned := make([]*KlineItem, len(timestamps))
+		for i, ts := range timestamps {
+			aligned[i] = bySymbol[k][ts]
+		}
+		klines[symbol] = aligned
+	}
+	respondOK(c, BatchKlineResponse{
+		Interval:   interval,
+		Symbols:    symbols,
+		Timestamps: timestamps,
+		Klines:     klines,
+		Count:      len(timestamps),
+	})
+}
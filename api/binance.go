@@ -1,11 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ganlian2020AI/biupdata/config"
@@ -13,72 +17,70 @@ import (
 	"github.com/ganlian2020AI/biupdata/utils"
 )
 
-// KlineData 币安K线数据结构
-type KlineData []interface{}
-
-// 每种时间间隔对应的更新频率（秒）
-var intervalUpdateFrequency = map[string]int{
+// 每种时间间隔默认的更新频率（秒）
+var baseUpdateFrequency = map[string]int{
 	"5m":  5 * 60,      // 5分钟
 	"30m": 30 * 60,     // 30分钟
 	"1h":  60 * 60,     // 1小时
 	"4h":  4 * 60 * 60, // 4小时
+
+	// 日线及以上周期较长，每小时刷新一次，保证收盘后能及时拿到最终数据
+	"1d": 60 * 60,
+	"3d": 60 * 60,
+	"1w": 60 * 60,
+	"1M": 60 * 60,
 }
 
+// 定时任务使用的更新频率（秒），数据量较大需要分页更新的时间间隔调整为10分钟
+var intervalUpdateFrequency = func() map[string]int {
+	m := make(map[string]int, len(baseUpdateFrequency))
+	for interval, seconds := range baseUpdateFrequency {
+		m[interval] = seconds
+	}
+	return m
+}()
+
+// 保护intervalUpdateFrequency，多个交易对会并发更新
+var frequencyMutex sync.Mutex
+
 // 全局配置
 var appConfig *config.Config
 
 // 设置配置
-func SetConfig(cfg *config.Config) {
+func SetConfig(cfg *config.Config) error {
 	appConfig = cfg
-}
-
-// 获取时间间隔对应的毫秒数
-func getIntervalMilliseconds(interval string) int64 {
-	switch interval {
-	case "5m":
-		return 5 * 60 * 1000
-	case "30m":
-		return 30 * 60 * 1000
-	case "1h":
-		return 60 * 60 * 1000
-	case "4h":
-		return 4 * 60 * 60 * 1000
-	default:
-		return 60 * 60 * 1000 // 默认1小时
+	initAPIEndpoints(cfg.Binance.BaseURLs, cfg.Binance.BaseURL)
+	if err := initProxyEndpoints(cfg.Binance.Proxies); err != nil {
+		return err
+	}
+	initNetworkRoutes(&cfg.Binance)
+	setWeightLimit(defaultWeightLimit)
+	if err := initReplay(&cfg.Binance); err != nil {
+		return err
 	}
+	if err := initHooks(cfg); err != nil {
+		return err
+	}
+	return validateRollupConfig()
 }
 
-// CheckBinanceConnection 检查币安API连接状态
+// CheckBinanceConnection 探测各网络线路的连接状态，自动模式下选择最快的可用线路
 func CheckBinanceConnection() bool {
 	if appConfig == nil {
 		utils.LogError("配置未初始化")
 		return false
 	}
-
-	// 使用获取BTC现价的API测试连接
-	url := fmt.Sprintf("%s/api/v3/ticker/price?symbol=%s", appConfig.Binance.BaseURL, appConfig.Binance.TestSymbol)
-	utils.LogInfo("测试币安API连接: %s", url)
-
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	resp, err := client.Get(url)
-	if err != nil {
-		utils.LogWarning("币安API连接失败: %v，将使用代理", err)
-		appConfig.Binance.UseProxy = true
-		return false
+	if replaying(defaultUpdater.Exchange) {
+		return true
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		utils.LogWarning("币安API返回非200状态码: %d，将使用代理", resp.StatusCode)
-		appConfig.Binance.UseProxy = true
+	if !probeRoutes() {
+		utils.LogWarning("币安API所有线路均不可用")
 		return false
 	}
 
-	utils.LogInfo("币安API连接正常")
-	appConfig.Binance.UseProxy = false
+	_, active, _ := getRouteStatus()
+	utils.LogInfo("币安API连接正常，当前线路: %s", active)
 	return true
 }
 
@@ -94,59 +96,134 @@ func GetBinanceURL(path string) string {
 	return path
 }
 
-// FetchKlineData 从币安获取K线数据
-func FetchKlineData(symbol string, interval string, startTime, endTime int64, limit int) ([]KlineData, error) {
-	// 构建URL
-	baseURL := "https://api.binance.com"
-	if appConfig != nil {
-		baseURL = appConfig.Binance.BaseURL
-	}
+// binanceError 币安API返回的错误信息
+type binanceError struct {
+	HTTPStatus int    `json:"-"`
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+}
 
-	url := fmt.Sprintf("%s/api/v3/klines?symbol=%s&interval=%s", baseURL, symbol, interval)
+func (e *binanceError) Error() string {
+	return fmt.Sprintf("币安API返回错误(HTTP %d, code %d): %s", e.HTTPStatus, e.Code, e.Msg)
+}
 
-	// 添加开始时间（如果有）
-	if startTime > 0 {
-		url += fmt.Sprintf("&startTime=%d", startTime)
-	}
+// 币安对不存在或已下架的交易对返回的错误码
+const binanceInvalidSymbolCode = -1121
 
-	// 添加结束时间（如果有）
-	if endTime > 0 {
-		url += fmt.Sprintf("&endTime=%d", endTime)
-	}
+// isInvalidSymbolError 判断错误是否为币安返回的无效交易对
+func isInvalidSymbolError(err error) bool {
+	var apiErr *binanceError
+	return errors.As(err, &apiErr) && apiErr.Code == binanceInvalidSymbolCode
+}
 
-	// 添加限制数量
-	if limit > 0 {
-		url += fmt.Sprintf("&limit=%d", limit)
-	}
+// binanceGet 通过默认更新流程的交易所实现请求币安API并返回响应内容，path包含查询参数，如 /api/v3/klines?symbol=BTCUSDT
+func binanceGet(path string) ([]byte, error) {
+	return defaultUpdater.Exchange.Get(path)
+}
 
-	// 根据连接状态决定是否使用代理
-	finalURL := url
-	if appConfig != nil && appConfig.Binance.UseProxy {
-		finalURL = appConfig.Binance.ProxyURL + url
-		utils.LogInfo("使用代理请求币安API: %s", finalURL)
-	} else {
-		utils.LogInfo("请求币安API: %s", url)
+// Get 请求币安API，网络错误或服务端错误（5xx）时依次尝试其他接入点
+func (binanceExchange) Get(path string) ([]byte, error) {
+	order := endpointOrder()
+	if len(order) == 0 {
+		body, _, err := binanceGetFrom(currentBaseURL(), path)
+		return body, err
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	var lastErr error
+	for _, idx := range order {
+		start := time.Now()
+		body, retryable, err := binanceGetFrom(endpointURL(idx), path)
+		if err == nil {
+			markEndpointSuccess(idx, time.Since(start))
+			return body, nil
+		}
+		if !retryable {
+			// 参数错误、限流等与接入点无关，换接入点也无济于事
+			markEndpointSuccess(idx, time.Since(start))
+			return nil, err
+		}
+
+		markEndpointFailure(idx, err)
+		lastErr = err
 	}
+	return nil, lastErr
+}
 
-	resp, err := client.Get(finalURL)
+// binanceGetFrom 向指定接入点发送请求，返回的bool表示错误是否值得换接入点重试
+func binanceGetFrom(baseURL, path string) ([]byte, bool, error) {
+	url := baseURL + path
+
+	resp, err := sendBinanceRequest(url)
 	if err != nil {
 		utils.LogError("请求币安API失败: %v", err)
-		return nil, err
+		return nil, true, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		utils.LogError("读取币安API响应失败: %v", err)
+		return nil, true, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = parseBinanceError(resp.StatusCode, body)
+		utils.LogError("%v", err)
+		return nil, resp.StatusCode >= 500, err
+	}
+
+	return body, false, nil
+}
+
+// parseBinanceError 解析非200响应中的错误码和错误信息
+func parseBinanceError(status int, body []byte) error {
+	apiErr := &binanceError{HTTPStatus: status}
+	if json.Unmarshal(body, apiErr) == nil && apiErr.Msg != "" {
+		return apiErr
+	}
+	return fmt.Errorf("币安API返回非200状态码: %d", status)
+}
+
+// FetchKlineData 通过默认更新流程从币安获取K线数据
+func FetchKlineData(ctx context.Context, symbol string, interval string, startTime, endTime int64, limit int) ([]db.Candle, error) {
+	return defaultUpdater.FetchKlineData(ctx, symbol, interval, startTime, endTime, limit)
+}
+
+// FetchKlineData 从交易所获取K线数据，请求和解析分别记录为ctx所在链路的span
+func (u *Updater) FetchKlineData(ctx context.Context, symbol string, interval string, startTime, endTime int64, limit int) ([]db.Candle, error) {
+	// 构建请求路径
+	path := fmt.Sprintf("/api/v3/klines?symbol=%s&interval=%s", symbol, interval)
+
+	// 添加开始时间（如果有）
+	if startTime > 0 {
+		path += fmt.Sprintf("&startTime=%d", startTime)
+	}
+
+	// 添加结束时间（如果有）
+	if endTime > 0 {
+		path += fmt.Sprintf("&endTime=%d", endTime)
+	}
+
+	// 添加限制数量
+	if limit > 0 {
+		path += fmt.Sprintf("&limit=%d", limit)
+	}
+
+	_, span := utils.StartSpan(ctx, "binance.get_klines", utils.SpanKindClient)
+	span.SetAttr("http.path", path)
+	body, err := u.Exchange.Get(path)
+	span.SetAttr("http.response_size", len(body))
+	span.End(err)
+	if err != nil {
 		return nil, err
 	}
 
-	var klines []KlineData
-	if err := json.Unmarshal(body, &klines); err != nil {
+	_, span = utils.StartSpan(ctx, "binance.parse_klines", utils.SpanKindInternal)
+	var klines []db.Candle
+	err = json.Unmarshal(body, &klines)
+	span.SetAttr("klines.count", len(klines))
+	span.End(err)
+	if err != nil {
 		utils.LogError("解析币安API响应失败: %v", err)
 		return nil, err
 	}
@@ -155,164 +232,578 @@ func FetchKlineData(symbol string, interval string, startTime, endTime int64, li
 	return klines, nil
 }
 
-// ProcessKlineData 处理并保存K线数据
-func ProcessKlineData(symbol string, interval string, klines []KlineData) (int, error) {
+// ProcessKlineData 通过默认更新流程处理K线数据并保存到数据库
+func ProcessKlineData(ctx context.Context, symbol string, interval string, klines []db.Candle) (int, error) {
+	return defaultUpdater.ProcessKlineData(ctx, symbol, interval, klines)
+}
+
+// ProcessKlineData 处理K线数据并在一个事务中保存到存储，任何一条写入失败时整批不生效并返回错误
+func (u *Updater) ProcessKlineData(ctx context.Context, symbol string, interval string, klines []db.Candle) (int, error) {
 	// 确保表存在
-	if err := db.CreateTableIfNotExists(symbol, interval); err != nil {
+	if err := u.Store.CreateTable(symbol, interval); err != nil {
 		return 0, err
 	}
 
-	successCount := 0
+	hooks := u.hooks()
+	klines, err := runFetchedHooks(hooks, symbol, interval, klines)
+	if err != nil {
+		utils.LogError("%v", err)
+		return 0, err
+	}
+
+	records := make([]db.KlineRecord, 0, len(klines))
+	stored := make([]db.Candle, 0, len(klines))
+	cfg := u.binanceConfig()
+	skipOpen := cfg != nil && cfg.SkipOpenCandle
 
 	for _, kline := range klines {
-		// 币安K线数据格式: [开盘时间, 开盘价, 最高价, 最低价, 收盘价, 成交量, 收盘时间, 成交额, 成交笔数, 主动买入成交量, 主动买入成交额, 忽略]
-		if len(kline) < 6 {
-			utils.LogWarning("K线数据格式不正确: %v", kline)
+		if skipOpen && !kline.Closed {
 			continue
 		}
-
-		// 转换数据类型
-		timestamp := int64(kline[0].(float64))
+		stored = append(stored, kline)
 
 		// 将UTC时间戳转换为上海时间戳（加8小时）
-		shanghaiTime := utils.TimestampToShanghai(timestamp)
+		shanghaiTime := utils.TimestampToShanghai(kline.OpenTime)
 		shanghaiTimestamp := utils.ShanghaiToTimestamp(shanghaiTime)
 
-		openPrice := kline[1].(string)
-		highPrice := kline[2].(string)
-		lowPrice := kline[3].(string)
-		closePrice := kline[4].(string)
-		volume := kline[5].(string)
-
-		// 保存到数据库（使用上海时间戳）
-		if err := db.SaveKlineData(symbol, interval, shanghaiTimestamp, openPrice, closePrice, highPrice, lowPrice, volume, ""); err != nil {
-			utils.LogError("保存K线数据失败: %v", err)
-			continue
-		}
+		records = append(records, db.KlineRecord{
+			Symbol:    symbol,
+			Interval:  interval,
+			Timestamp: shanghaiTimestamp,
+			Open:      kline.Open,
+			High:      kline.High,
+			Low:       kline.Low,
+			Close:     kline.Close,
+			Volume:    kline.Volume,
+			Note:      kline.Note, // 扩展可以为K线添加标注，为空时保留已有的标注
+		})
+	}
 
-		successCount++
+	// 保存到数据库（使用上海时间戳）
+	if err := u.Store.SaveKlines(ctx, symbol, interval, records); err != nil {
+		utils.LogError("保存K线数据失败: %v", err)
+		return 0, err
+	}
+	// 查询缓存只属于服务的默认存储
+	if u.shared && len(records) > 0 {
+		invalidateKlineCache(symbol, interval)
 	}
 
-	return successCount, nil
+	runStoredHooks(hooks, symbol, interval, stored)
+	return len(records), nil
 }
 
-// GetLastKlineTimestamp 获取最后一条K线数据的时间戳
+// GetLastKlineTimestamp 获取默认存储中最后一条K线数据的时间戳
 func GetLastKlineTimestamp(symbol, interval string) (int64, error) {
-	// 从数据库获取最后一条记录
-	data, err := db.GetKlineData(symbol, interval, 0, 0, 1)
+	return defaultUpdater.GetLastKlineTimestamp(symbol, interval)
+}
+
+// GetLastKlineTimestamp 获取最后一条K线数据的时间戳
+func (u *Updater) GetLastKlineTimestamp(symbol, interval string) (int64, error) {
+	// 从存储获取最后一条记录
+	timestamp, ok, err := u.Store.LastKlineTimestamp(symbol, interval)
 	if err != nil {
 		return 0, err
 	}
 
 	// 如果没有记录，返回默认起始时间
-	if len(data) == 0 {
-		defaultTime := utils.GetDefaultStartTime(interval)
-		return utils.ShanghaiToTimestamp(defaultTime), nil
+	if !ok {
+		return u.initialStartTimestamp(symbol, interval), nil
 	}
+	return timestamp, nil
+}
+
+// initialStartTimestamp 没有数据时首次回补的起始时间戳（与数据库中的时间戳相同，按上海时间存储）
+func (u *Updater) initialStartTimestamp(symbol, interval string) int64 {
+	cfg := u.binanceConfig()
+	defaultTime := defaultStartTime(cfg, symbol, interval)
+	start := utils.ShanghaiToTimestamp(defaultTime)
 
-	// 返回最后一条记录的时间戳
-	return data[0]["timestamp"].(int64), nil
+	// 上市晚于起始时间时从第一根K线开始，避免逐页请求上市前的空区间
+	if cfg != nil && cfg.DetectListing {
+		listing, err := u.fetchListingTime(symbol, interval)
+		if err != nil {
+			utils.LogWarning("查询 %s %s 的第一根K线失败，从默认起始时间开始: %v", symbol, interval, err)
+		} else if listing > 0 {
+			if stored := utils.ShanghaiToTimestamp(utils.TimestampToShanghai(listing)); stored > start {
+				utils.LogInfo("%s %s 的第一根K线为 %s，从该时间开始回补", symbol, interval, utils.TimestampToShanghai(listing).Format("2006-01-02 15:04:05"))
+				start = stored
+			}
+		}
+	}
+	return start
+}
+
+// fetchListingTime 用startTime=0&limit=1向币安查询第一根K线的开盘时间（UTC毫秒），没有K线时返回0；
+// 只在没有数据时查询，结果缓存在更新流程中
+func (u *Updater) fetchListingTime(symbol, interval string) (int64, error) {
+	u.init()
+	key := symbol + "|" + interval
+	u.listingMu.Lock()
+	listing, ok := u.listingTimes[key]
+	u.listingMu.Unlock()
+	if ok {
+		return listing, nil
+	}
+
+	// FetchKlineData在startTime为0时不传该参数，会返回最新的K线
+	body, err := u.Exchange.Get(fmt.Sprintf("/api/v3/klines?symbol=%s&interval=%s&startTime=0&limit=1", symbol, interval))
+	if err != nil {
+		return 0, err
+	}
+	var klines []db.Candle
+	if err := json.Unmarshal(body, &klines); err != nil {
+		return 0, fmt.Errorf("解析币安API响应失败: %v", err)
+	}
+	if len(klines) > 0 {
+		listing = klines[0].OpenTime
+	}
+
+	u.listingMu.Lock()
+	u.listingTimes[key] = listing
+	u.listingMu.Unlock()
+	return listing, nil
+}
+
+// defaultStartTime 返回没有数据时首次回补的起始时间：依次使用时间间隔、全局配置的起始日期和内置默认值，
+// 配置了交易对的起始日期（如上市日期）时不早于该日期，避免请求上市前不存在的数据
+func defaultStartTime(cfg *config.BinanceConfig, symbol, interval string) time.Time {
+	start := utils.GetDefaultStartTime(interval)
+	if cfg == nil {
+		return start
+	}
+
+	date := cfg.IntervalStartDates[interval]
+	if date == "" {
+		date = cfg.StartDate
+	}
+	// 日期已在加载配置时校验
+	if t, err := utils.ParseShanghaiDate(date); err == nil {
+		start = t
+	}
+	if t, err := utils.ParseShanghaiDate(cfg.SymbolStartDates[strings.ToUpper(symbol)]); err == nil && t.After(start) {
+		start = t
+	}
+	return start
 }
 
 // ShouldUpdateInterval 判断是否应该更新指定的时间间隔
 func ShouldUpdateInterval(interval string, lastUpdateTime time.Time) bool {
 	now := time.Now().UTC()
+	frequencyMutex.Lock()
 	frequency, exists := intervalUpdateFrequency[interval]
+	frequencyMutex.Unlock()
 
 	if !exists {
-		// 默认10分钟更新一次
-		frequency = 10 * 60
+		frequency = defaultUpdateFrequency(interval)
 	}
 
 	// 如果上次更新时间距离现在超过了更新频率，则需要更新
 	return now.Sub(lastUpdateTime).Seconds() >= float64(frequency)
 }
 
-// UpdateSymbolData 更新单个交易对的所有时间间隔数据
+// UpdateFrequency 时间间隔默认的更新频率，不包括定时任务运行中的调整
+func UpdateFrequency(interval string) time.Duration {
+	frequency, ok := baseUpdateFrequency[interval]
+	if !ok {
+		frequency = defaultUpdateFrequency(interval)
+	}
+	return time.Duration(frequency) * time.Second
+}
+
+// defaultUpdateFrequency 未单独配置的时间间隔按周期长度更新，最长1小时（秒）
+func defaultUpdateFrequency(interval string) int {
+	frequency := int(getIntervalMilliseconds(interval) / 1000)
+	if frequency > 60*60 {
+		frequency = 60 * 60
+	}
+	return frequency
+}
+
+// UpdateSymbolData 通过默认更新流程更新单个交易对的所有时间间隔数据
 func UpdateSymbolData(symbol string, intervals []string) (map[string]int, error) {
+	return defaultUpdater.UpdateSymbolData(symbol, intervals)
+}
+
+// UpdateSymbolData 更新单个交易对的所有时间间隔数据，返回成功更新的时间间隔及其记录数，有时间间隔失败时同时返回错误
+// 本实例正在更新的时间间隔合并到已有任务，不重复请求
+func (u *Updater) UpdateSymbolData(symbol string, intervals []string) (map[string]int, error) {
+	u.init()
+	return u.runUpdateJobs(symbol, u.jobs.claim(symbol, intervals, "scheduler"))
+}
+
+// runUpdateJobs 依次执行已登记的更新任务，合并到其他任务的时间间隔跳过
+func (u *Updater) runUpdateJobs(symbol string, jobs []claimedJob) (map[string]int, error) {
+	u.init()
 	result := make(map[string]int)
+	var failed []string
 
-	for _, interval := range intervals {
-		// 获取最后一条K线数据的时间戳
-		lastTimestamp, err := GetLastKlineTimestamp(symbol, interval)
+	// 提前返回时释放尚未执行的任务
+	defer func() {
+		for _, cj := range jobs {
+			if cj.owned {
+				u.jobs.skip(cj.job)
+			}
+		}
+	}()
+
+	for _, cj := range jobs {
+		interval := cj.job.Interval
+		if !cj.owned {
+			utils.LogInfo("%s %s 正在更新（任务 %s），合并本次更新", symbol, interval, cj.job.ID)
+			continue
+		}
+		// 数据库跟不上时限制同时执行的更新数，任务在等待期间保持pending
+		release := func() {}
+		if u.shared {
+			release = acquireUpdateSlot()
+		}
+		u.jobs.start(cj.job)
+
+		// 多实例部署时通过分布式锁避免重复采集同一交易对和时间间隔
+		lockKey := fmt.Sprintf("update:%s:%s", symbol, interval)
+		lockToken, locked, err := u.acquireLock(lockKey)
 		if err != nil {
-			utils.LogError("获取 %s %s 最后时间戳失败: %v", symbol, interval, err)
-			result[interval] = 0
+			// 无法确认其他实例是否正在更新时不更新，下次检查时重试
+			utils.LogWarning("获取 %s %s 的分布式锁失败: %v，跳过本次更新", symbol, interval, err)
+			utils.IncCounter(utils.MetricName("biupdata_update_failures_total", "symbol", symbol, "interval", interval))
+			u.jobs.finish(cj.job, 0, fmt.Errorf("获取分布式锁失败: %v", err))
+			release()
+			failed = append(failed, fmt.Sprintf("%s: 获取分布式锁失败: %v", interval, err))
+			continue
+		}
+		if !locked {
+			utils.LogInfo("%s %s 正由其他实例更新，跳过本次更新", symbol, interval)
+			u.jobs.skip(cj.job)
+			release()
 			continue
 		}
 
-		// 将上海时间戳转换回UTC时间戳（减8小时）
-		shanghaiTime := utils.TimestampToShanghai(lastTimestamp)
-		utcTime := utils.ShanghaiToUTC(shanghaiTime)
-		utcTimestamp := utcTime.UnixNano() / int64(time.Millisecond)
-
-		// 获取当前UTC时间戳
-		nowUTC := time.Now().UTC().UnixNano() / int64(time.Millisecond)
-
-		// 计算需要更新的数据量
-		intervalMs := getIntervalMilliseconds(interval)
-		neededBars := (nowUTC - utcTimestamp) / intervalMs
-
-		// 如果需要更新的数据量超过1000条，则分批更新
-		totalUpdated := 0
-		if neededBars > 1000 {
-			// 分批更新，每批1000条
-			for startTime := utcTimestamp; startTime < nowUTC; startTime += 1000 * intervalMs {
-				endTime := startTime + 1000*intervalMs
-				if endTime > nowUTC {
-					endTime = nowUTC
-				}
-
-				// 获取K线数据
-				klines, err := FetchKlineData(symbol, interval, startTime, endTime, 1000)
-				if err != nil {
-					utils.LogError("获取 %s %s K线数据失败: %v", symbol, interval, err)
-					continue
-				}
-
-				// 处理并保存数据
-				count, err := ProcessKlineData(symbol, interval, klines)
-				if err != nil {
-					utils.LogError("处理 %s %s K线数据失败: %v", symbol, interval, err)
-					continue
-				}
-
-				totalUpdated += count
-
-				// 避免API请求过于频繁
-				time.Sleep(100 * time.Millisecond)
+		lockCtx, cancel := context.WithCancel(context.Background())
+		stopKeeping := u.keepLock(lockKey, lockToken, cancel)
+		ctx, span := utils.StartSpan(lockCtx, "update_interval", utils.SpanKindInternal)
+		span.SetAttr("symbol", symbol)
+		span.SetAttr("interval", interval)
+		totalUpdated, err := u.safeUpdateInterval(ctx, symbol, interval)
+		span.SetAttr("klines.updated", totalUpdated)
+		span.End(err)
+		stopKeeping()
+		cancel()
+		u.releaseLock(lockKey, lockToken)
+		u.jobs.finish(cj.job, totalUpdated, err)
+		release()
+		if err != nil {
+			// 无效交易对错误可能是一次性的，本轮不再请求其余时间间隔，是否下架由元数据同步连续多次确认后决定
+			if isInvalidSymbolError(err) {
+				utils.LogWarning("%s %s 币安返回无效交易对，跳过本轮其余时间间隔，等待元数据同步确认是否下架", symbol, interval)
+				utils.IncCounter(utils.MetricName("biupdata_update_failures_total", "symbol", symbol, "interval", interval))
+				return result, fmt.Errorf("更新 %s 失败: %s: %v", symbol, interval, err)
 			}
 
-			// 更新频率调整为10分钟
-			intervalUpdateFrequency[interval] = 10 * 60
-			utils.LogInfo("由于 %s %s 数据量较大，更新频率已调整为10分钟", symbol, interval)
-		} else {
-			// 直接获取所有数据
-			klines, err := FetchKlineData(symbol, interval, utcTimestamp, 0, 1000)
-			if err != nil {
-				utils.LogError("获取 %s %s K线数据失败: %v", symbol, interval, err)
-				result[interval] = 0
+			// 失败的时间间隔不计入结果，下次检查时重试
+			utils.IncCounter(utils.MetricName("biupdata_update_failures_total", "symbol", symbol, "interval", interval))
+			failed = append(failed, fmt.Sprintf("%s: %v", interval, err))
+			if totalUpdated == 0 {
 				continue
 			}
+			utils.LogWarning("%s %s 部分更新，已保存 %d 条记录", symbol, interval, totalUpdated)
+		} else {
+			result[interval] = totalUpdated
+			utils.LogInfo("成功更新 %s %s 数据，共 %d 条记录", symbol, interval, totalUpdated)
+		}
+
+		if u.AfterUpdate != nil {
+			u.AfterUpdate(symbol, interval, totalUpdated)
+		}
+	}
+
+	if len(failed) > 0 {
+		return result, fmt.Errorf("更新 %s 失败: %s", symbol, strings.Join(failed, "; "))
+	}
+	return result, nil
+}
+
+// afterIntervalUpdate 默认更新流程在一个时间间隔更新后计算滚动统计，源时间间隔更新后同步刷新聚合生成的时间间隔；
+// 两者都读取MySQL中的K线，主存储为时序数据库时跳过
+func afterIntervalUpdate(symbol, interval string, updated int) {
+	if appConfig != nil && appConfig.Sinks.Primary != "" && appConfig.Sinks.Primary != "mysql" {
+		return
+	}
+	materializeStats(symbol, interval, updated)
+
+	if appConfig != nil && appConfig.Rollup.Enabled && interval == appConfig.Rollup.Source {
+		runRollups(symbol, updated)
+	}
+}
+
+// acquireLock 获取更新锁，没有设置Locker时不加锁
+func (u *Updater) acquireLock(key string) (string, bool, error) {
+	if u.Locker == nil {
+		return "", true, nil
+	}
+	return u.Locker.Acquire(key, u.lockTTL())
+}
+
+// keepLock 更新期间每隔锁过期时间的1/3延长一次锁，避免耗时较长的更新（如大段回补）在锁过期后与其他实例重复执行；
+// 锁已失效、或直到过期仍无法延长时调用cancel停止更新。返回的函数停止延长
+func (u *Updater) keepLock(key, token string, cancel context.CancelFunc) func() {
+	if u.Locker == nil || token == "" {
+		return func() {}
+	}
+	ttl := u.lockTTL()
+	done := make(chan struct{})
+	go func() {
+		defer utils.Recover("lock_keeper", nil)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		extended := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := u.Locker.Extend(key, token, ttl)
+			switch {
+			case err == nil && ok:
+				extended = time.Now()
+			case err == nil:
+				utils.LogWarning("分布式锁 %s 已失效，停止本次更新", key)
+				cancel()
+				return
+			case time.Since(extended) >= ttl:
+				utils.LogWarning("分布式锁 %s 直到过期仍无法延长: %v，停止本次更新", key, err)
+				cancel()
+				return
+			default:
+				utils.LogWarning("延长分布式锁 %s 失败，稍后重试: %v", key, err)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// releaseLock 释放更新锁
+func (u *Updater) releaseLock(key, token string) {
+	if u.Locker != nil {
+		u.Locker.Release(key, token)
+	}
+}
+
+// safeUpdateInterval 更新单个时间间隔，解析异常数据等导致的panic转换为错误，该时间间隔按失败处理
+func (u *Updater) safeUpdateInterval(ctx context.Context, symbol, interval string) (n int, err error) {
+	defer utils.Recover("update_interval", &err)
+	return u.updateInterval(ctx, symbol, interval)
+}
+
+// 未配置时分布式锁的过期时间
+const defaultLockTTL = 10 * time.Minute
+
+// 获取分布式锁过期时间
+func getLockTTL() time.Duration {
+	if appConfig == nil || appConfig.Redis.LockTTL <= 0 {
+		return defaultLockTTL
+	}
+	return time.Duration(appConfig.Redis.LockTTL) * time.Second
+}
+
+// updateInterval 更新单个交易对单个时间间隔的数据
+func (u *Updater) updateInterval(ctx context.Context, symbol, interval string) (int, error) {
+	// 从同步水位之后开始更新
+	utcTimestamp, err := u.syncStartTime(symbol, interval)
+	if err != nil {
+		utils.LogError("获取 %s %s 同步进度失败: %v", symbol, interval, err)
+		return 0, err
+	}
+
+	// 处理并保存数据，每页在一个事务中写入
+	totalUpdated, paged, err := u.fetchKlinePages(ctx, symbol, interval, utcTimestamp, 0, func(klines []db.Candle) (int, error) {
+		count, err := u.ProcessKlineData(ctx, symbol, interval, klines)
+		if err != nil {
+			utils.LogError("处理 %s %s K线数据失败: %v", symbol, interval, err)
+			return 0, err
+		}
+		u.advanceSyncWatermark(symbol, interval, klines)
+		return count, nil
+	})
+	if err != nil {
+		return totalUpdated, err
+	}
+
+	if paged && u.shared {
+		// 更新频率调整为10分钟
+		frequencyMutex.Lock()
+		intervalUpdateFrequency[interval] = 10 * 60
+		frequencyMutex.Unlock()
+		utils.LogInfo("由于 %s %s 数据量较大，更新频率已调整为10分钟", symbol, interval)
+	}
+
+	u.Store.MarkSyncSuccess(symbol, interval)
+	return totalUpdated, nil
+}
+
+// fetchKlinePages 从startUTC开始获取K线直到endUTC（0表示到当前时间，并包含尚未收盘的K线），每页交给handle处理，
+// 返回handle处理的记录总数，以及是否分页获取；handle出错时停止
+func (u *Updater) fetchKlinePages(ctx context.Context, symbol, interval string, startUTC, endUTC int64, handle func([]db.Candle) (int, error)) (int, bool, error) {
+	// 获取当前UTC时间戳
+	nowUTC := time.Now().UTC().UnixNano() / int64(time.Millisecond)
+	if endUTC <= 0 || endUTC > nowUTC {
+		endUTC = nowUTC
+	}
+
+	// 计算需要更新的数据量（按周期边界计算，自然月长度不固定）
+	neededBars := countIntervalBars(interval, startUTC, endUTC)
+
+	// 如果需要更新的数据量不超过一页，则直接获取所有数据
+	route := u.routeName()
+	size := u.pageSize(route)
+	if neededBars <= int64(size) {
+		fetchEnd := int64(0)
+		if endUTC < nowUTC {
+			fetchEnd = endUTC
+		}
+		klines, err := u.FetchKlineData(ctx, symbol, interval, startUTC, fetchEnd, size)
+		if err != nil {
+			if isPageSizeError(err) {
+				shrinkPageSize(route, "error")
+			}
+			utils.LogError("获取 %s %s K线数据失败: %v", symbol, interval, err)
+			return 0, false, err
+		}
+		if isTruncatedPage(interval, startUTC, endUTC, size, klines) {
+			shrinkPageSize(route, "truncated")
+		} else {
+			notePageComplete(route)
+		}
+
+		count, err := handle(klines)
+		return count, false, err
+	}
 
-			// 处理并保存数据
-			totalUpdated, err = ProcessKlineData(symbol, interval, klines)
-			if err != nil {
-				utils.LogError("处理 %s %s K线数据失败: %v", symbol, interval, err)
-				result[interval] = 0
+	// 分页更新，每页条数随线路状况调整；下一页紧接上一页最后一根K线，页被截断时不会留下缺口
+	total := 0
+	retries := 0
+	for startTime := startUTC; startTime < endUTC; {
+		route = u.routeName()
+		size = u.pageSize(route)
+		endTime := advanceIntervals(interval, startTime, size)
+		if endTime > endUTC {
+			endTime = endUTC
+		}
+
+		// 获取K线数据，缩小每页条数后仍失败时停止本次更新，下次从已保存的最后一条继续，避免跳过整页留下缺口
+		klines, err := u.FetchKlineData(ctx, symbol, interval, startTime, endTime, size)
+		if err != nil {
+			if isPageSizeError(err) && shrinkPageSize(route, "error") && retries < maxPageRetries {
+				retries++
+				utils.LogWarning("获取 %s %s K线数据失败，减小每页条数后重试(%d/%d): %v", symbol, interval, retries, maxPageRetries, err)
 				continue
 			}
+			utils.LogError("获取 %s %s K线数据失败: %v", symbol, interval, err)
+			return total, true, err
+		}
+		retries = 0
+		if isTruncatedPage(interval, startTime, endTime, size, klines) {
+			shrinkPageSize(route, "truncated")
+		} else {
+			notePageComplete(route)
+		}
+
+		count, err := handle(klines)
+		if err != nil {
+			return total, true, err
 		}
 
-		result[interval] = totalUpdated
-		utils.LogInfo("成功更新 %s %s 数据，共 %d 条记录", symbol, interval, totalUpdated)
+		total += count
+		startTime = nextPageStart(interval, startTime, size, klines)
+
+		// 避免API请求过于频繁，回放时不访问网络
+		if !replaying(u.Exchange) {
+			time.Sleep(100 * time.Millisecond)
+		}
 	}
 
-	return result, nil
+	return total, true, nil
 }
 
-// GetKlineDataFromDB 从数据库获取K线数据
-func GetKlineDataFromDB(symbol, interval string, startTime, endTime string, limit int) ([]map[string]interface{}, error) {
+// syncStartTime 增量更新的开始时间（UTC毫秒），从同步水位的下一根K线开始
+// 没有水位记录时（首次同步或升级前已有的数据）退回到从最新一根已存储的K线开始
+func (u *Updater) syncStartTime(symbol, interval string) (int64, error) {
+	state, err := u.Store.GetSyncState(symbol, interval)
+	if err != nil {
+		return 0, err
+	}
+	if state != nil {
+		return advanceIntervals(interval, state.Watermark, 1), nil
+	}
+
+	lastTimestamp, err := u.GetLastKlineTimestamp(symbol, interval)
+	if err != nil {
+		return 0, err
+	}
+	return utils.StoredTimestampToUTC(lastTimestamp), nil
+}
+
+// advanceSyncWatermark 一页写入成功后把水位推进到该页最后一根已收盘的K线
+// 未收盘的K线下次更新时还会变化，不计入水位
+func (u *Updater) advanceSyncWatermark(symbol, interval string, klines []db.Candle) {
+	for i := len(klines) - 1; i >= 0; i-- {
+		if klines[i].Closed {
+			u.Store.AdvanceSyncWatermark(symbol, interval, klines[i].OpenTime)
+			return
+		}
+	}
+}
+
+// KlineItem 接口返回的一根K线，价格和成交量保持数据库中的十进制字符串，避免浮点误差
+type KlineItem struct {
+	Timestamp  int64  `json:"timestamp"` // 毫秒
+	Datetime   string `json:"datetime"`
+	OpenPrice  string `json:"open_price"`
+	ClosePrice string `json:"close_price"`
+	HighPrice  string `json:"high_price"`
+	LowPrice   string `json:"low_price"`
+	Volume     string `json:"volume"`
+	Note       string `json:"note"`
+}
+
+// toKlineItems 将数据库查询结果转换为接口返回的K线
+func toKlineItems(candles []db.Candle) []KlineItem {
+	items := make([]KlineItem, 0, len(candles))
+	for _, k := range candles {
+		items = append(items, candleToKlineItem(k))
+	}
+	return items
+}
+
+// candleToKlineItem 将数据库读出的K线转换为接口返回的K线
+func candleToKlineItem(k db.Candle) KlineItem {
+	return KlineItem{
+		Timestamp:  k.OpenTime,
+		Datetime:   time.UnixMilli(k.OpenTime).UTC().Format("2006-01-02 15:04"),
+		OpenPrice:  k.Open,
+		ClosePrice: k.Close,
+		HighPrice:  k.High,
+		LowPrice:   k.Low,
+		Volume:     k.Volume,
+		Note:       k.Note,
+	}
+}
+
+// 未配置时K线查询单次返回的最大条数
+const defaultMaxQueryLimit = 1000
+
+// maxQueryLimit K线查询单次返回的最大条数，由API_MAX_QUERY_LIMIT配置
+func maxQueryLimit() int {
+	if appConfig == nil {
+		return defaultMaxQueryLimit
+	}
+	return appConfig.API.MaxQueryLimit
+}
+
+// GetKlineDataFromDB 从数据库获取K线数据，limit无效或超过API_MAX_QUERY_LIMIT时按最大条数返回
+func GetKlineDataFromDB(symbol, interval string, startTime, endTime string, limit int) ([]KlineItem, error) {
 	var startTimestamp, endTimestamp int64
 	var err error
 
@@ -334,10 +825,43 @@ func GetKlineDataFromDB(symbol, interval string, startTime, endTime string, limi
 	}
 
 	// 限制查询记录数量
-	if limit <= 0 || limit > 1000 {
-		limit = 1000
+	if max := maxQueryLimit(); limit <= 0 || limit > max {
+		limit = max
+	}
+	return queryKlineItems(symbol, interval, startTimestamp, endTimestamp, limit)
+}
+
+// klineCachePrefix 交易对和时间间隔的K线查询缓存键前缀
+func klineCachePrefix(symbol, interval string) string {
+	return fmt.Sprintf("kline:%s:%s:", strings.ToUpper(symbol), interval)
+}
+
+// invalidateKlineCache 删除交易对和时间间隔的K线查询缓存，写入或修改K线后调用，下一次查询读到新数据
+func invalidateKlineCache(symbol, interval string) {
+	if appConfig == nil || appConfig.Redis.CacheTTL <= 0 {
+		return
+	}
+	db.CacheDeletePattern(klineCachePrefix(symbol, interval) + "*")
+}
+
+// queryKlineItems 查询K线，不限制limit上限，优先读取Redis缓存
+func queryKlineItems(symbol, interval string, startTimestamp, endTimestamp int64, limit int) ([]KlineItem, error) {
+	// 优先读取Redis缓存
+	cacheKey := klineCachePrefix(symbol, interval) + fmt.Sprintf("%d:%d:%d", startTimestamp, endTimestamp, limit)
+	var cached []KlineItem
+	if db.CacheGet(cacheKey, &cached) {
+		return cached, nil
 	}
 
 	// 从数据库获取数据
-	return db.GetKlineData(symbol, interval, startTimestamp, endTimestamp, limit)
+	rows, err := db.QueryKlineData(symbol, interval, startTimestamp, endTimestamp, limit)
+	if err != nil {
+		return nil, err
+	}
+	data := toKlineItems(rows)
+
+	if appConfig != nil && appConfig.Redis.CacheTTL > 0 {
+		db.CacheSet(cacheKey, data, time.Duration(appConfig.Redis.CacheTTL)*time.Second)
+	}
+	return data, nil
 }
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 清理过期快照的最小间隔，避免每次采集都执行DELETE
+const bookTickerPruneInterval = time.Hour
+
+var (
+	bookTickerPruneMutex sync.Mutex
+	lastBookTickerPrune  time.Time
+)
+
+// BookTickerItem 一条最优买卖价快照
+type BookTickerItem struct {
+	Symbol    string  `json:"symbol"`
+	Timestamp int64   `json:"timestamp"` // 采集时间（毫秒）
+	Datetime  string  `json:"datetime"`
+	BidPrice  string  `json:"bid_price"`
+	BidQty    string  `json:"bid_qty"`
+	AskPrice  string  `json:"ask_price"`
+	AskQty    string  `json:"ask_qty"`
+	Spread    string  `json:"spread"`     // 卖一价 - 买一价
+	SpreadBps float64 `json:"spread_bps"` // 价差相对中间价的基点数
+}
+
+// BookTickerResponse 最优买卖价查询结果
+type BookTickerResponse struct {
+	Items []BookTickerItem `json:"items"`
+	Count int              `json:"count"`
+}
+
+// bookTickerSymbols 返回需要采集最优买卖价的交易对，未配置BOOKTICKER_SYMBOLS时使用采集K线的交易对
+func bookTickerSymbols(cfg *config.Config) []string {
+	symbols := cfg.BookTicker.Symbols
+	if len(symbols) == 0 {
+		updateMutex.Lock()
+		symbols = cfg.Binance.CurrentSymbols()
+		updateMutex.Unlock()
+	}
+
+	result := make([]string, len(symbols))
+	for i, s := range symbols {
+		result[i] = strings.ToUpper(s)
+	}
+	return result
+}
+
+// FetchBookTickers 一次请求获取多个交易对的最优买卖价，采集时间取本地当前时间
+func FetchBookTickers(symbols []string) ([]db.BookTicker, error) {
+	encoded, err := json.Marshal(symbols)
+	if err != nil {
+		return nil, err
+	}
+	body, err := binanceGet("/api/v3/ticker/bookTicker?symbols=" + url.QueryEscape(string(encoded)))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []struct {
+		Symbol   string `json:"symbol"`
+		BidPrice string `json:"bidPrice"`
+		BidQty   string `json:"bidQty"`
+		AskPrice string `json:"askPrice"`
+		AskQty   string `json:"askQty"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析最优买卖价失败: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	tickers := make([]db.BookTicker, len(resp))
+	for i, t := range resp {
+		tickers[i] = db.BookTicker{
+			Symbol:    t.Symbol,
+			Timestamp: now,
+			BidPrice:  t.BidPrice,
+			BidQty:    t.BidQty,
+			AskPrice:  t.AskPrice,
+			AskQty:    t.AskQty,
+		}
+	}
+	return tickers, nil
+}
+
+// CollectBookTickers 采集并保存一次最优买卖价快照，更新价差指标，并按保留天数定期清理旧快照
+func CollectBookTickers(cfg *config.Config) error {
+	symbols := bookTickerSymbols(cfg)
+	if len(symbols) == 0 {
+		return nil
+	}
+
+	tickers, err := FetchBookTickers(symbols)
+	if err != nil {
+		return err
+	}
+	if err := db.SaveBookTickers(tickers); err != nil {
+		return err
+	}
+	for _, t := range tickers {
+		_, bps := bookSpread(t)
+		utils.SetGauge(utils.MetricName("biupdata_book_spread_bps", "symbol", t.Symbol), bps)
+	}
+
+	pruneBookTickers(cfg)
+	return nil
+}
+
+// pruneBookTickers 距上次清理超过bookTickerPruneInterval时删除超出保留天数的快照
+func pruneBookTickers(cfg *config.Config) {
+	if cfg.BookTicker.RetentionDays <= 0 {
+		return
+	}
+
+	bookTickerPruneMutex.Lock()
+	if time.Since(lastBookTickerPrune) < bookTickerPruneInterval {
+		bookTickerPruneMutex.Unlock()
+		return
+	}
+	lastBookTickerPrune = time.Now()
+	bookTickerPruneMutex.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -cfg.BookTicker.RetentionDays).UnixMilli()
+	n, err := db.DeleteBookTickersBefore(cutoff)
+	if err != nil {
+		utils.LogError("%v", err)
+		return
+	}
+	if n > 0 {
+		utils.LogInfo("已清理 %d 条超过 %d 天的最优买卖价快照", n, cfg.BookTicker.RetentionDays)
+	}
+}
+
+// bookSpread 计算价差和相对中间价的基点数，价格无效时基点数为0
+func bookSpread(t db.BookTicker) (float64, float64) {
+	bid, err1 := strconv.ParseFloat(t.BidPrice, 64)
+	ask, err2 := strconv.ParseFloat(t.AskPrice, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+
+	spread := ask - bid
+	mid := (ask + bid) / 2
+	if mid <= 0 {
+		return spread, 0
+	}
+	return spread, spread / mid * 10000
+}
+
+// toBookTickerItem 把数据库中的快照转换为响应格式
+func toBookTickerItem(t db.BookTicker) BookTickerItem {
+	spread, bps := bookSpread(t)
+	return BookTickerItem{
+		Symbol:    t.Symbol,
+		Timestamp: t.Timestamp,
+		Datetime:  utils.TimestampToShanghai(t.Timestamp).Format("2006-01-02 15:04:05.000"),
+		BidPrice:  t.BidPrice,
+		BidQty:    t.BidQty,
+		AskPrice:  t.AskPrice,
+		AskQty:    t.AskQty,
+		Spread:    formatFloat(spread),
+		SpreadBps: math.Round(bps*100) / 100,
+	}
+}
+
+// getBookTicker 查询最优买卖价快照：指定symbol时按时间倒序返回区间内的快照，否则返回每个交易对最新的一条
+func getBookTicker(c *gin.Context) {
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return
+	}
+	if !appConfig.BookTicker.Enabled {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未启用最优买卖价采集，请设置 BOOKTICKER_ENABLED=true")
+		return
+	}
+
+	var startTime, endTime int64
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > maxQueryLimit() {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	resp := BookTickerResponse{Items: []BookTickerItem{}}
+	symbol := strings.ToUpper(c.Query("symbol"))
+	if symbol != "" {
+		tickers, err := db.ListBookTickers(symbol, startTime, endTime, limit)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		for _, t := range tickers {
+			resp.Items = append(resp.Items, toBookTickerItem(t))
+		}
+	} else {
+		for _, s := range bookTickerSymbols(appConfig) {
+			tickers, err := db.ListBookTickers(s, 0, 0, 1)
+			if err != nil {
+				internalError(c, err)
+				return
+			}
+			if len(tickers) > 0 {
+				resp.Items = append(resp.Items, toBookTickerItem(tickers[0]))
+			}
+		}
+	}
+
+	resp.Count = len(resp.Items)
+	respondOK(c, resp)
+}
+
+// AddBookTickerTask 添加定期采集最优买卖价的定时任务，未启用BOOKTICKER_ENABLED时不添加
+func AddBookTickerTask(cfg *config.Config) error {
+	if !cfg.BookTicker.Enabled {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	if err := db.CreateBookTickerTableIfNotExists(); err != nil {
+		return err
+	}
+
+	err := addScheduledTask("bookticker", cfg.Cron.BookTickerSchedule, func() error {
+		err := CollectBookTickers(cfg)
+		if err != nil {
+			utils.LogError("采集最优买卖价失败: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("添加最优买卖价采集任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加最优买卖价采集任务，cron表达式: %s", cfg.Cron.BookTickerSchedule)
+	return nil
+}
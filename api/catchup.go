@@ -0,0 +1,346 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// CatchupItem 一个需要追赶的交易对/时间间隔
+type CatchupItem struct {
+	Symbol     string `json:"symbol"`
+	Interval   string `json:"interval"`
+	From       string `json:"from"` // 缺失数据的起点（上海时间）
+	BehindBars int64  `json:"behind_bars"`
+	Deep       bool   `json:"deep"`     // 落后超过CATCHUP_DEEP_BARS根，排在小量追赶之后
+	Priority   int    `json:"priority"` // 同类项中优先级高的先追赶，默认为时间间隔权重
+	Updated    int    `json:"updated"`
+	Status     string `json:"status"` // pending、running、done、skipped、failed
+	Error      string `json:"error,omitempty"`
+	fromUTC    int64
+}
+
+// CatchupProgress 启动追赶进度
+type CatchupProgress struct {
+	Running    bool          `json:"running"`
+	StartedAt  string        `json:"started_at,omitempty"`
+	FinishedAt string        `json:"finished_at,omitempty"`
+	Total      int           `json:"total"`
+	Done       int           `json:"done"`
+	Failed     int           `json:"failed"`
+	Items      []CatchupItem `json:"items"`
+}
+
+var (
+	catchupMutex    sync.Mutex
+	catchupProgress = CatchupProgress{Items: []CatchupItem{}}
+)
+
+// StartSchedulerWithCatchup 启用启动追赶时先在后台补齐落后的数据，完成后再启动定时任务
+func StartSchedulerWithCatchup() {
+	if appConfig == nil || !appConfig.Catchup.Enabled {
+		StartScheduler()
+		return
+	}
+
+	catchupMutex.Lock()
+	if catchupProgress.Running {
+		// 正在追赶的流程结束后会启动定时任务
+		catchupMutex.Unlock()
+		return
+	}
+	catchupProgress = CatchupProgress{Running: true, Items: []CatchupItem{}}
+	catchupMutex.Unlock()
+
+	go func() {
+		// 小量追赶完成后即启动定时任务，深度回补在后台继续
+		var once sync.Once
+		startScheduler := func() {
+			once.Do(func() {
+				// 追赶期间可能已被手动停止、手动启动或失去主节点身份
+				if SchedulerEnabled() && IsLeader() && !IsSchedulerRunning() {
+					StartScheduler()
+				}
+			})
+		}
+
+		utils.Safe("catchup", func() { runCatchup(startScheduler) })
+		startScheduler()
+	}()
+}
+
+// findCatchupItems 找出落后超过CATCHUP_MIN_BARS根K线的交易对/时间间隔，按追赶顺序排列
+func findCatchupItems() []CatchupItem {
+	updateMutex.Lock()
+	symbols := appConfig.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+
+	nowUTC := time.Now().UnixMilli()
+	var items []CatchupItem
+	for _, symbol := range symbols {
+		for _, interval := range appConfig.Binance.IntervalsFor(symbol) {
+			// 聚合生成的时间间隔随源时间间隔一起更新
+			if isRollupTarget(interval) {
+				continue
+			}
+			updateMutex.Lock()
+			paused := isPaused(symbol, interval)
+			updateMutex.Unlock()
+			if paused {
+				continue
+			}
+
+			// 从同步水位之后开始，空表从默认起始时间开始
+			fromUTC, err := defaultUpdater.syncStartTime(symbol, interval)
+			if err != nil {
+				utils.LogWarning("追赶检查 %s %s 失败: %v", symbol, interval, err)
+				continue
+			}
+			behind := countBarsBetween(interval, fromUTC, intervalStart(interval, nowUTC))
+			if behind < int64(appConfig.Catchup.MinBars) {
+				continue
+			}
+
+			items = append(items, CatchupItem{
+				Symbol:     symbol,
+				Interval:   interval,
+				From:       utils.TimestampToShanghai(fromUTC).Format("2006-01-02 15:04:05"),
+				BehindBars: behind,
+				Deep:       behind > int64(appConfig.Catchup.DeepBars),
+				Priority:   appConfig.Catchup.IntervalWeights[interval],
+				Status:     "pending",
+				fromUTC:    fromUTC,
+			})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return catchupBefore(&items[i], &items[j]) })
+	return items
+}
+
+// catchupBefore 追赶顺序：小量追赶在深度回补之前，同类中优先级高的在前，再按缺口起点从早到晚
+func catchupBefore(a, b *CatchupItem) bool {
+	if a.Deep != b.Deep {
+		return !a.Deep
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.fromUTC < b.fromUTC
+}
+
+// nextCatchupItem 取出下一项等待追赶的项并标记为执行中，每次按当前优先级选择，调整优先级后立即生效
+func nextCatchupItem() (int, bool) {
+	catchupMutex.Lock()
+	defer catchupMutex.Unlock()
+
+	next := -1
+	for i := range catchupProgress.Items {
+		item := &catchupProgress.Items[i]
+		if item.Status != "pending" {
+			continue
+		}
+		if next < 0 || catchupBefore(item, &catchupProgress.Items[next]) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return 0, false
+	}
+	catchupProgress.Items[next].Status = "running"
+	return next, true
+}
+
+// realtimeCatchupDone 小量追赶是否已全部结束
+func realtimeCatchupDone() bool {
+	catchupMutex.Lock()
+	defer catchupMutex.Unlock()
+
+	for _, item := range catchupProgress.Items {
+		if !item.Deep && (item.Status == "pending" || item.Status == "running") {
+			return false
+		}
+	}
+	return true
+}
+
+// catchupQueued 该交易对/时间间隔是否还在追赶队列中等待，定时任务跳过这些项，由追赶按优先级处理
+func catchupQueued(symbol, interval string) bool {
+	catchupMutex.Lock()
+	defer catchupMutex.Unlock()
+
+	if !catchupProgress.Running {
+		return false
+	}
+	for _, item := range catchupProgress.Items {
+		if item.Symbol == symbol && item.Interval == interval {
+			return item.Status == "pending"
+		}
+	}
+	return false
+}
+
+// runCatchup 按优先级并发追赶，并发数由CATCHUP_CONCURRENCY限制，小量追赶全部结束时调用onRealtimeDone
+func runCatchup(onRealtimeDone func()) {
+	start := time.Now()
+	items := findCatchupItems()
+
+	catchupMutex.Lock()
+	catchupProgress.StartedAt = utils.UTCToShanghai(start).Format("2006-01-02 15:04:05")
+	catchupProgress.Total = len(items)
+	catchupProgress.Items = items
+	catchupMutex.Unlock()
+
+	if len(items) > 0 {
+		utils.LogInfo("启动追赶: %d 个交易对/时间间隔落后，并发数 %d", len(items), appConfig.Catchup.Concurrency)
+	}
+	utils.SetGauge("biupdata_catchup_remaining", float64(len(items)))
+
+	if realtimeCatchupDone() {
+		onRealtimeDone()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < appConfig.Catchup.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i, ok := nextCatchupItem()
+				if !ok {
+					return
+				}
+				catchupOne(i)
+				if realtimeCatchupDone() {
+					onRealtimeDone()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	catchupMutex.Lock()
+	catchupProgress.Running = false
+	catchupProgress.FinishedAt = utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	done, failed := catchupProgress.Done, catchupProgress.Failed
+	catchupMutex.Unlock()
+
+	if len(items) > 0 {
+		utils.LogInfo("启动追赶完成，成功 %d 个，失败 %d 个，耗时 %v", done, failed, time.Since(start).Round(time.Second))
+	}
+}
+
+// catchupOne 追赶第i项并更新进度
+func catchupOne(i int) {
+	catchupMutex.Lock()
+	item := catchupProgress.Items[i]
+	catchupMutex.Unlock()
+
+	results, err := func() (results map[string]int, err error) {
+		// panic时该项按失败处理，其余项继续追赶
+		defer utils.Recover("catchup", &err)
+		return defaultUpdater.runUpdateJobs(item.Symbol, claimUpdateJobs(item.Symbol, []string{item.Interval}, "catchup"))
+	}()
+	count, ok := results[item.Interval]
+
+	// 记录更新时间，定时任务不会立即重复更新
+	if ok {
+		updateMutex.Lock()
+		if containsSymbol(appConfig.Binance.CurrentSymbols(), item.Symbol) {
+			if _, exists := lastUpdateTime[item.Symbol]; !exists {
+				lastUpdateTime[item.Symbol] = make(map[string]time.Time)
+			}
+			lastUpdateTime[item.Symbol][item.Interval] = time.Now().UTC()
+		}
+		updateMutex.Unlock()
+	}
+
+	catchupMutex.Lock()
+	p := &catchupProgress.Items[i]
+	p.Updated = count
+	if ok {
+		p.Status = "done"
+		catchupProgress.Done++
+	} else if err == nil {
+		// 正由其他实例或本实例的其他任务更新，或交易对已下架
+		p.Status = "skipped"
+		catchupProgress.Done++
+	} else {
+		p.Status = "failed"
+		p.Error = err.Error()
+		catchupProgress.Failed++
+	}
+	finished := catchupProgress.Done + catchupProgress.Failed
+	total := catchupProgress.Total
+	catchupMutex.Unlock()
+
+	utils.SetGauge("biupdata_catchup_remaining", float64(total-finished))
+	utils.LogInfo("追赶进度 %d/%d: %s %s 落后 %d 根，已写入 %d 条", finished, total, item.Symbol, item.Interval, item.BehindBars, count)
+}
+
+// getCatchupProgress 查询启动追赶进度
+func getCatchupProgress(c *gin.Context) {
+	catchupMutex.Lock()
+	progress := catchupProgress
+	progress.Items = append([]CatchupItem{}, catchupProgress.Items...)
+	catchupMutex.Unlock()
+
+	respondOK(c, progress)
+}
+
+// CatchupReprioritized 调整优先级的结果
+type CatchupReprioritized struct {
+	Updated int `json:"updated"`
+}
+
+// setCatchupPriority 调整等待中的追赶项的优先级，按交易对、时间间隔或两者匹配
+func setCatchupPriority(c *gin.Context) {
+	var req struct {
+		Symbol   string `json:"symbol"`
+		Interval string `json:"interval"`
+		Priority *int   `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return
+	}
+	if req.Symbol == "" && req.Interval == "" {
+		badRequest(c, "symbol 和 interval 至少需要一个")
+		return
+	}
+	if req.Priority == nil {
+		badRequest(c, "缺少必要参数: priority")
+		return
+	}
+	symbol := strings.ToUpper(req.Symbol)
+
+	catchupMutex.Lock()
+	running := catchupProgress.Running
+	updated := 0
+	for i := range catchupProgress.Items {
+		item := &catchupProgress.Items[i]
+		if item.Status != "pending" || (symbol != "" && item.Symbol != symbol) || (req.Interval != "" && item.Interval != req.Interval) {
+			continue
+		}
+		item.Priority = *req.Priority
+		updated++
+	}
+	catchupMutex.Unlock()
+
+	if !running {
+		respondError(c, http.StatusConflict, CodeConflict, "当前没有正在进行的追赶")
+		return
+	}
+	if updated == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "没有匹配的等待中的追赶项")
+		return
+	}
+
+	logRequestInfo(c, "调整追赶优先级 %s %s -> %d，共 %d 项", symbol, req.Interval, *req.Priority, updated)
+	respondOK(c, CatchupReprioritized{Updated: updated})
+}
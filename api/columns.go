@@ -0,0 +1,57 @@
+package api
+
+// KlineColumnsResponse 按列返回的K线，各列与timestamps一一对齐，顺序与klines相同（按时间倒序）
+// 没有标注时省略note列
+type KlineColumnsResponse struct {
+	Symbol      string           `json:"symbol"`
+	Interval    string           `json:"interval"`
+	Timestamps  []int64          `json:"timestamps"`
+	Open        []string         `json:"open"`
+	High        []string         `json:"high"`
+	Low         []string         `json:"low"`
+	Close       []string         `json:"close"`
+	Volume      []string         `json:"volume"`
+	Note        []string         `json:"note,omitempty"`
+	Count       int              `json:"count"`
+	Events      []KlineEvent     `json:"events,omitempty"`
+	Quote       *QuoteConversion `json:"quote,omitempty"`
+	Transform   *TransformInfo   `json:"transform,omitempty"`
+	Downsampled *DownsampleInfo  `json:"downsampled,omitempty"`
+}
+
+// toKlineColumns 将K线转换为按列返回的结构
+func toKlineColumns(symbol, interval string, klines []KlineItem) KlineColumnsResponse {
+	n := len(klines)
+	resp := KlineColumnsResponse{
+		Symbol:     symbol,
+		Interval:   interval,
+		Timestamps: make([]int64, n),
+		Open:       make([]string, n),
+		High:       make([]string, n),
+		Low:        make([]string, n),
+		Close:      make([]string, n),
+		Volume:     make([]string, n),
+		Count:      n,
+	}
+
+	hasNote := false
+	for i, k := range klines {
+		resp.Timestamps[i] = k.Timestamp
+		resp.Open[i] = k.OpenPrice
+		resp.High[i] = k.HighPrice
+		resp.Low[i] = k.LowPrice
+		resp.Close[i] = k.ClosePrice
+		resp.Volume[i] = k.Volume
+		if k.Note != "" {
+			hasNote = true
+		}
+	}
+
+	if hasNote {
+		resp.Note = make([]string, n)
+		for i, k := range klines {
+			resp.Note[i] = k.Note
+		}
+	}
+	return resp
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// 压缩后收益明显的响应类型
+var compressibleTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"text/csv",
+	"text/plain",
+	"text/html",
+	"text/css",
+	"text/javascript",
+}
+
+// encoder gzip和brotli共用的压缩流操作
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// 各编码的压缩器池，brotli使用默认质量（6），压缩率明显高于gzip且速度相近
+var encoderPools = map[string]*sync.Pool{
+	"br": {
+		New: func() interface{} { return brotli.NewWriter(nil) },
+	},
+	"gzip": {
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	},
+}
+
+// compressWriter 缓冲响应开头的minSize字节，据此决定是否压缩，小响应原样返回
+type compressWriter struct {
+	gin.ResponseWriter
+	minSize  int
+	encoding string // 协商出的编码：br 或 gzip
+	buf      []byte
+	decided  bool
+	enc      encoder
+	written  int
+}
+
+// compressionMiddleware 按Accept-Encoding协商brotli或gzip压缩JSON、CSV等文本响应
+func compressionMiddleware(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 是否压缩取决于Accept-Encoding，未压缩的响应同样需要声明，避免缓存把它返回给接受压缩的客户端
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, minSize: minSize, encoding: encoding}
+		c.Writer = w
+		defer w.finish()
+		c.Next()
+	}
+}
+
+// negotiateEncoding 按q值选择客户端接受的编码，q相同时优先brotli，q=0表示明确拒绝；都不接受时返回空
+func negotiateEncoding(header string) string {
+	quality := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch coding {
+		case "br", "gzip":
+			quality[coding] = q
+		case "*":
+			wildcard = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		q, ok := quality[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Write 缓冲到minSize字节后决定是否压缩
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.writeBody(data)
+	}
+
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// WriteString 写入字符串
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 流式响应需要立即发出已缓冲的数据
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.minSize)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Written 缓冲中的数据也视为已写入，避免后续处理重复写响应
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.written > 0 || w.ResponseWriter.Written()
+}
+
+// Size 返回写入的未压缩字节数
+func (w *compressWriter) Size() int {
+	if !w.decided {
+		return len(w.buf)
+	}
+	return w.written
+}
+
+// decide 根据状态码、响应类型和已缓冲的大小决定是否压缩，并写出缓冲的数据
+func (w *compressWriter) decide(largeEnough bool) error {
+	w.decided = true
+	header := w.Header()
+	if largeEnough && w.compressible() {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		enc := encoderPools[w.encoding].Get().(encoder)
+		enc.Reset(w.ResponseWriter)
+		w.enc = enc
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.writeBody(buf)
+	return err
+}
+
+// compressible 响应是否适合压缩：有响应体、未被编码且为文本类型
+func (w *compressWriter) compressible() bool {
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBody 写入响应体，启用压缩时经过压缩器
+func (w *compressWriter) writeBody(data []byte) (int, error) {
+	w.written += len(data)
+	if w.enc != nil {
+		return w.enc.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// finish 请求处理完成后写出剩余缓冲并结束压缩流
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		encoderPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"gzip;q=0", ""},
+		{"*", "br"},
+		{"br;q=0, *;q=0.1", "gzip"},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionMiddlewareBrotli(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := strings.Repeat(`{"open":"61000.50","close":"61010.25"},`, 100)
+	router := gin.New()
+	router.Use(compressionMiddleware(1024))
+	router.GET("/large", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", []byte(body)) })
+	router.GET("/small", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", []byte("{}")) })
+
+	req := httptest.NewRequest(http.MethodGet, "/large", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+	decoded, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+	if err != nil || string(decoded) != body {
+		t.Fatalf("decoded %d bytes, err %v", len(decoded), err)
+	}
+
+	// 未压缩的响应同样声明Vary
+	for _, encoding := range []string{"br", ""} {
+		req = httptest.NewRequest(http.MethodGet, "/small", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("Accept-Encoding %q: headers %v", encoding, w.Header())
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 管理页面的静态文件随程序一起编译，部署时只需要一个可执行文件
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// 缺口检查默认回看的K线数量和单次最多检查的K线数量
+const (
+	gapDefaultBars = 1000
+	gapMaxBars     = 100000
+)
+
+// registerDashboard 注册管理页面，/logs/view 保留为旧地址并跳转到管理页面的日志页
+func registerDashboard() {
+	sub, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		utils.LogError("加载管理页面失败: %v", err)
+		return
+	}
+	router.StaticFS("/dashboard", http.FS(sub))
+
+	router.GET("/logs/view", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, "/dashboard/#logs")
+	})
+}
+
+// FreshnessItem 一个交易对和时间间隔的数据新鲜度
+type FreshnessItem struct {
+	Symbol        string `json:"symbol"`
+	Interval      string `json:"interval"`
+	Count         int64  `json:"count"`
+	LastTimestamp int64  `json:"last_timestamp"` // 最新一根K线的开盘时间（毫秒），没有数据时为0
+	LastDatetime  string `json:"last_datetime"`
+	BehindBars    int64  `json:"behind_bars"` // 最新一根K线之后已收盘但尚未入库的K线数量
+	Stale         bool   `json:"stale"`
+	Paused        bool   `json:"paused"`                // 已手动暂停定时更新
+	LastUpdate    string `json:"last_update,omitempty"` // 定时任务最近一次成功更新的时间
+	Watermark     int64  `json:"watermark,omitempty"`   // 同步水位：最后一根完整写入的K线开盘时间（毫秒）
+	LastSync      string `json:"last_sync,omitempty"`   // 最近一次同步成功的时间，重启后仍保留
+	Error         string `json:"error,omitempty"`
+}
+
+// FreshnessResponse 全部交易对的数据新鲜度
+type FreshnessResponse struct {
+	Items []FreshnessItem `json:"items"`
+	Count int             `json:"count"`
+	Stale int             `json:"stale"`
+}
+
+// getFreshness 查询每个交易对和时间间隔的最新K线及落后的K线数量
+func getFreshness(c *gin.Context) {
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return
+	}
+
+	updateMutex.Lock()
+	symbols := appConfig.Binance.CurrentSymbols()
+	lastUpdates := make(map[string]time.Time)
+	for symbol, intervals := range lastUpdateTime {
+		for interval, t := range intervals {
+			lastUpdates[symbol+" "+interval] = t
+		}
+	}
+	paused := make(map[string]bool)
+	for _, symbol := range symbols {
+		for _, interval := range appConfig.Binance.IntervalsFor(symbol) {
+			if isPaused(symbol, interval) {
+				paused[symbol+" "+interval] = true
+			}
+		}
+	}
+	updateMutex.Unlock()
+
+	// 读取失败时只是不显示水位
+	syncStates := make(map[string]db.SyncState)
+	if states, err := db.ListSyncStates(); err == nil {
+		for _, st := range states {
+			syncStates[st.Symbol+" "+st.Interval] = st
+		}
+	}
+
+	nowUTC := time.Now().UnixMilli()
+	resp := FreshnessResponse{Items: []FreshnessItem{}}
+	for _, symbol := range symbols {
+		for _, interval := range appConfig.Binance.IntervalsFor(symbol) {
+			item := FreshnessItem{Symbol: symbol, Interval: interval, Paused: paused[symbol+" "+interval]}
+			if t, ok := lastUpdates[symbol+" "+interval]; ok {
+				item.LastUpdate = utils.UTCToShanghai(t).Format("2006-01-02 15:04:05")
+			}
+			if st, ok := syncStates[symbol+" "+interval]; ok {
+				item.Watermark = st.Watermark
+				if !st.LastSuccess.IsZero() {
+					item.LastSync = st.LastSuccess.Format("2006-01-02 15:04:05")
+				}
+			}
+
+			_, last, count, err := db.GetKlineTimeRange(symbol, interval)
+			if err != nil {
+				item.Error = err.Error()
+				item.Stale = true
+			} else if count == 0 {
+				item.Stale = true
+			} else {
+				lastUTC := utils.StoredTimestampToUTC(last)
+				item.Count = count
+				item.LastTimestamp = lastUTC
+				item.LastDatetime = utils.TimestampToShanghai(lastUTC).Format("2006-01-02 15:04")
+				item.BehindBars = countBarsBetween(interval, nextIntervalStart(interval, lastUTC), intervalStart(interval, nowUTC))
+				// 落后一根以内属于正常的更新间隔
+				item.Stale = item.BehindBars > 1
+			}
+
+			if item.Stale {
+				resp.Stale++
+			}
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	resp.Count = len(resp.Items)
+	respondOK(c, resp)
+}
+
+// KlineGap 一段连续缺失的K线
+type KlineGap struct {
+	Start         int64  `json:"start"` // 第一根缺失K线的开盘时间（毫秒）
+	End           int64  `json:"end"`   // 最后一根缺失K线的开盘时间（毫秒）
+	StartDatetime string `json:"start_datetime"`
+	EndDatetime   string `json:"end_datetime"`
+	Missing       int64  `json:"missing"`
+}
+
+// GapReport 一个交易对和时间间隔在指定区间内的缺口检查结果
+type GapReport struct {
+	Symbol    string     `json:"symbol"`
+	Interval  string     `json:"interval"`
+	StartTime int64      `json:"start_time"`
+	EndTime   int64      `json:"end_time"`
+	Expected  int64      `json:"expected"`
+	Present   int64      `json:"present"`
+	Missing   int64      `json:"missing"`
+	Gaps      []KlineGap `json:"gaps"`
+}
+
+// getGaps 检查指定区间内缺失的K线，默认检查最近1000根已收盘的K线
+func getGaps(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	// 只检查已收盘的K线
+	endUTC := intervalStart(interval, time.Now().UnixMilli()) - 1
+	if v := c.Query("end_time"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+		if t < endUTC {
+			endUTC = t
+		}
+	}
+
+	var startUTC int64
+	if v := c.Query("start_time"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+		startUTC = intervalStart(interval, t)
+	} else {
+		// 默认回看1000根，但不早于表中第一根K线，避免把上市前的时间算作缺口
+		startUTC = advanceIntervals(interval, intervalStart(interval, endUTC), -(gapDefaultBars - 1))
+		first, _, count, err := db.GetKlineTimeRange(symbol, interval)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		if count > 0 {
+			if firstUTC := utils.StoredTimestampToUTC(first); firstUTC > startUTC {
+				startUTC = firstUTC
+			}
+		}
+	}
+
+	if endUTC < startUTC {
+		badRequest(c, "结束时间早于开始时间")
+		return
+	}
+	if countBarsBetween(interval, startUTC, endUTC) > gapMaxBars {
+		badRequest(c, "检查区间过大，单次最多检查 "+strconv.Itoa(gapMaxBars)+" 根K线")
+		return
+	}
+
+	report, err := findGaps(symbol, interval, startUTC, endUTC)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, report)
+}
+
+// findGaps 对比[startUTC, endUTC]区间内应有的周期边界与数据库中的开盘时间，找出连续缺失的K线
+// startUTC需为周期边界，区间内的K线数量不超过gapMaxBars
+func findGaps(symbol, interval string, startUTC, endUTC int64) (GapReport, error) {
+	timestamps, err := db.GetKlineTimestamps(symbol, interval, startUTC, endUTC, gapMaxBars+1)
+	if err != nil {
+		return GapReport{}, err
+	}
+
+	report := GapReport{
+		Symbol:    symbol,
+		Interval:  interval,
+		StartTime: startUTC,
+		EndTime:   endUTC,
+		Present:   int64(len(timestamps)),
+		Gaps:      []KlineGap{},
+	}
+
+	i := 0
+	var gap *KlineGap
+	for expected := startUTC; expected <= endUTC; expected = nextIntervalStart(interval, expected) {
+		report.Expected++
+
+		// 跳过不在周期边界上的记录（通常是时区换算错位写入的数据）
+		for i < len(timestamps) && timestamps[i] < expected {
+			i++
+		}
+		if i < len(timestamps) && timestamps[i] == expected {
+			i++
+			gap = nil
+			continue
+		}
+
+		report.Missing++
+		if gap == nil {
+			report.Gaps = append(report.Gaps, KlineGap{Start: expected})
+			gap = &report.Gaps[len(report.Gaps)-1]
+		}
+		gap.End = expected
+		gap.Missing++
+	}
+
+	for i := range report.Gaps {
+		report.Gaps[i].StartDatetime = utils.TimestampToShanghai(report.Gaps[i].Start).Format("2006-01-02 15:04")
+		report.Gaps[i].EndDatetime = utils.TimestampToShanghai(report.Gaps[i].End).Format("2006-01-02 15:04")
+	}
+	return report, nil
+}
+
+// countBarsBetween 计算开盘时间在[fromStart, toStart)区间内的K线数量，两端均为周期边界
+func countBarsBetween(interval string, fromStart, toStart int64) int64 {
+	if toStart <= fromStart {
+		return 0
+	}
+	if interval == "1M" {
+		from := time.UnixMilli(fromStart).UTC()
+		to := time.UnixMilli(toStart).UTC()
+		return int64((to.Year()-from.Year())*12 + int(to.Month()-from.Month()))
+	}
+	return (toStart - fromStart) / getIntervalMilliseconds(interval)
+}
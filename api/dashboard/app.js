@@ -0,0 +1,427 @@
+// BiUpData 管理页面，所有数据来自 /api/v1 接口，接口返回 {code, message, data, request_id}
+
+let logsTimer = null;
+let seriesLoaded = false;
+
+// 调用接口，code不为0时抛出带请求ID的错误
+async function api(path, options) {
+    const response = await fetch(path, options);
+    const body = await response.json();
+    if (body.code !== 0) {
+        throw new Error(body.message + '（请求ID: ' + body.request_id + '）');
+    }
+    return body.data;
+}
+
+function postJSON(path, payload) {
+    return api(path, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(payload || {})
+    });
+}
+
+function toast(message) {
+    const el = document.getElementById('toast');
+    el.textContent = message;
+    el.style.display = 'block';
+    clearTimeout(el.timer);
+    el.timer = setTimeout(() => { el.style.display = 'none'; }, 4000);
+}
+
+function escapeHTML(s) {
+    return String(s).replace(/[&<>"']/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c]));
+}
+
+// ---------- 页签 ----------
+
+function showTab() {
+    const name = (location.hash || '#overview').substring(1);
+    document.querySelectorAll('.tab').forEach(el => el.classList.toggle('active', el.id === 'tab-' + name));
+    document.querySelectorAll('nav a').forEach(el => el.classList.toggle('active', el.dataset.tab === name));
+
+    clearInterval(logsTimer);
+    if (name === 'overview') {
+        refreshOverview();
+    } else if (name === 'logs') {
+        refreshLogs();
+        loadLogFiles();
+        if (document.getElementById('logsAutoRefresh').checked) {
+            logsTimer = setInterval(refreshLogs, 10000);
+        }
+    } else if (!seriesLoaded) {
+        loadSeriesOptions();
+    }
+}
+
+// ---------- 概览 ----------
+
+async function refreshOverview() {
+    refreshScheduler();
+    refreshNetwork();
+    refreshFreshness();
+}
+
+async function refreshScheduler() {
+    try {
+        const data = await api('/api/v1/scheduler');
+        let text = data.running ? '<span class="ok">运行中</span>' : '<span class="stale">已停止</span>';
+        if (data.ha_enabled) {
+            text += '，' + (data.leader ? '主节点' : '备用节点');
+        }
+        document.getElementById('schedulerStatus').innerHTML = text;
+        document.getElementById('taskTable').innerHTML = data.tasks.map(task => {
+            const status = task.running ? '<span class="ok">运行中</span>'
+                : task.last_error ? '<span class="stale">' + escapeHTML(task.last_error) + '</span>'
+                : task.last_run ? '<span class="ok">成功</span>' : '-';
+            return '<tr>' +
+                '<td>' + escapeHTML(task.name) + '</td>' +
+                '<td>' + escapeHTML(task.schedule) + '</td>' +
+                '<td>' + (task.last_run || '-') + '</td>' +
+                '<td>' + (task.last_run ? task.last_duration_ms + ' ms' : '-') + '</td>' +
+                '<td>' + (task.next_run || '-') + '</td>' +
+                '<td>' + task.run_count + '</td>' +
+                '<td>' + status + '</td>' +
+                '</tr>';
+        }).join('');
+    } catch (e) {
+        document.getElementById('schedulerStatus').textContent = '获取失败: ' + e.message;
+    }
+}
+
+async function refreshNetwork() {
+    try {
+        const data = await api('/api/v1/network');
+        document.getElementById('networkStatus').textContent =
+            data.route + (data.mode === 'auto' ? '（自动选择）' : '（手动固定）') + '，接入点 ' + data.base_url;
+    } catch (e) {
+        document.getElementById('networkStatus').textContent = '获取失败: ' + e.message;
+    }
+}
+
+async function refreshFreshness() {
+    const tbody = document.getElementById('freshnessTable');
+    try {
+        const data = await api('/api/v1/freshness');
+        document.getElementById('freshnessSummary').textContent = '共 ' + data.count + ' 项，落后 ' + data.stale + ' 项';
+        tbody.innerHTML = data.items.map(item => {
+            const status = item.error ? '<span class="stale">' + escapeHTML(item.error) + '</span>'
+                : item.stale ? '<span class="stale">落后</span>' : '<span class="ok">正常</span>';
+            const paused = item.paused ? ' <span class="muted">已暂停</span>' : '';
+            return '<tr>' +
+                '<td>' + escapeHTML(item.symbol) + '</td>' +
+                '<td>' + escapeHTML(item.interval) + '</td>' +
+                '<td>' + item.count + '</td>' +
+                '<td>' + (item.last_datetime || '无数据') + '</td>' +
+                '<td>' + item.behind_bars + '</td>' +
+                '<td>' + (item.last_update || '-') + '</td>' +
+                '<td>' + status + paused + '</td>' +
+                '</tr>';
+        }).join('');
+        fillSeriesOptions(data.items);
+    } catch (e) {
+        tbody.innerHTML = '<tr><td colspan="7">获取失败: ' + escapeHTML(e.message) + '</td></tr>';
+    }
+}
+
+// ---------- 交易对和时间间隔下拉框 ----------
+
+async function loadSeriesOptions() {
+    try {
+        const data = await api('/api/v1/freshness');
+        fillSeriesOptions(data.items);
+    } catch (e) {
+        toast('获取交易对列表失败: ' + e.message);
+    }
+}
+
+function fillSeriesOptions(items) {
+    const symbols = [...new Set(items.map(i => i.symbol))];
+    const intervals = [...new Set(items.map(i => i.interval))];
+    ['chartSymbol', 'gapSymbol'].forEach(id => fillSelect(id, symbols));
+    ['chartInterval', 'gapInterval'].forEach(id => fillSelect(id, intervals));
+    seriesLoaded = true;
+}
+
+function fillSelect(id, values) {
+    const select = document.getElementById(id);
+    const current = select.value;
+    select.innerHTML = values.map(v => '<option>' + escapeHTML(v) + '</option>').join('');
+    if (values.includes(current)) {
+        select.value = current;
+    }
+}
+
+// ---------- K线图 ----------
+
+async function loadChart() {
+    const symbol = document.getElementById('chartSymbol').value;
+    const interval = document.getElementById('chartInterval').value;
+    const limit = document.getElementById('chartLimit').value;
+    if (!symbol || !interval) {
+        return;
+    }
+
+    try {
+        const data = await api('/api/v1/kline?symbol=' + encodeURIComponent(symbol) +
+            '&interval=' + encodeURIComponent(interval) + '&limit=' + limit);
+        // 接口按时间倒序返回
+        const candles = data.klines.slice().reverse().map(k => ({
+            datetime: k.datetime,
+            open: parseFloat(k.open_price),
+            high: parseFloat(k.high_price),
+            low: parseFloat(k.low_price),
+            close: parseFloat(k.close_price),
+            volume: parseFloat(k.volume)
+        }));
+        drawChart(candles);
+
+        const info = candles.length ? candles[0].datetime + ' ~ ' + candles[candles.length - 1].datetime +
+            '，最新收盘 ' + candles[candles.length - 1].close : '无数据';
+        document.getElementById('chartInfo').textContent = info;
+    } catch (e) {
+        toast('加载K线失败: ' + e.message);
+    }
+}
+
+// drawChart 在画布上绘制蜡烛图，下方20%绘制成交量
+function drawChart(candles) {
+    const canvas = document.getElementById('chartCanvas');
+    const ctx = canvas.getContext('2d');
+    const width = canvas.width;
+    const height = canvas.height;
+    const padLeft = 10, padRight = 80, padTop = 10, padBottom = 24;
+    const priceHeight = (height - padTop - padBottom) * 0.78;
+    const volumeTop = padTop + priceHeight + 10;
+    const volumeHeight = height - padBottom - volumeTop;
+
+    ctx.clearRect(0, 0, width, height);
+    if (candles.length === 0) {
+        ctx.fillStyle = '#888';
+        ctx.fillText('无数据', width / 2, height / 2);
+        return;
+    }
+
+    const high = Math.max(...candles.map(c => c.high));
+    const low = Math.min(...candles.map(c => c.low));
+    const maxVolume = Math.max(...candles.map(c => c.volume)) || 1;
+    const range = high - low || 1;
+    const step = (width - padLeft - padRight) / candles.length;
+    const bodyWidth = Math.max(1, step * 0.7);
+    const y = price => padTop + (high - price) / range * priceHeight;
+
+    // 价格刻度
+    ctx.font = '12px Arial';
+    ctx.strokeStyle = '#eee';
+    ctx.fillStyle = '#666';
+    for (let i = 0; i <= 4; i++) {
+        const price = low + range * i / 4;
+        const py = y(price);
+        ctx.beginPath();
+        ctx.moveTo(padLeft, py);
+        ctx.lineTo(width - padRight, py);
+        ctx.stroke();
+        ctx.fillText(price.toPrecision(6), width - padRight + 6, py + 4);
+    }
+
+    candles.forEach((c, i) => {
+        const x = padLeft + i * step + step / 2;
+        const color = c.close >= c.open ? '#26a69a' : '#ef5350';
+        ctx.strokeStyle = color;
+        ctx.fillStyle = color;
+
+        ctx.beginPath();
+        ctx.moveTo(x, y(c.high));
+        ctx.lineTo(x, y(c.low));
+        ctx.stroke();
+
+        const top = y(Math.max(c.open, c.close));
+        const bodyHeight = Math.max(1, Math.abs(y(c.open) - y(c.close)));
+        ctx.fillRect(x - bodyWidth / 2, top, bodyWidth, bodyHeight);
+
+        const vh = c.volume / maxVolume * volumeHeight;
+        ctx.globalAlpha = 0.5;
+        ctx.fillRect(x - bodyWidth / 2, volumeTop + volumeHeight - vh, bodyWidth, vh);
+        ctx.globalAlpha = 1;
+    });
+
+    // 时间刻度
+    ctx.fillStyle = '#666';
+    const labels = Math.min(6, candles.length);
+    for (let i = 0; i < labels; i++) {
+        const idx = Math.floor(i * (candles.length - 1) / Math.max(1, labels - 1));
+        const x = padLeft + idx * step;
+        ctx.fillText(candles[idx].datetime, Math.min(x, width - padRight - 100), height - 6);
+    }
+}
+
+// ---------- 缺口检查 ----------
+
+async function checkGaps() {
+    const symbol = document.getElementById('gapSymbol').value;
+    const interval = document.getElementById('gapInterval').value;
+    const start = document.getElementById('gapStart').value;
+    const end = document.getElementById('gapEnd').value;
+    if (!symbol || !interval) {
+        return;
+    }
+
+    // datetime-local按浏览器本地时区解析
+    let url = '/api/v1/gaps?symbol=' + encodeURIComponent(symbol) + '&interval=' + encodeURIComponent(interval);
+    if (start) {
+        url += '&start_time=' + new Date(start).getTime();
+    }
+    if (end) {
+        url += '&end_time=' + new Date(end).getTime();
+    }
+
+    const tbody = document.getElementById('gapTable');
+    try {
+        const data = await api(url);
+        document.getElementById('gapSummary').textContent =
+            '应有 ' + data.expected + ' 根，已有 ' + data.present + ' 根，缺失 ' + data.missing + ' 根，共 ' + data.gaps.length + ' 段';
+        tbody.innerHTML = data.gaps.length === 0 ? '<tr><td colspan="3" class="ok">没有缺口</td></tr>'
+            : data.gaps.map(g => '<tr><td>' + g.start_datetime + '</td><td>' + g.end_datetime + '</td><td>' + g.missing + '</td></tr>').join('');
+    } catch (e) {
+        tbody.innerHTML = '<tr><td colspan="3">检查失败: ' + escapeHTML(e.message) + '</td></tr>';
+    }
+}
+
+// ---------- 日志 ----------
+
+async function refreshLogs() {
+    const container = document.getElementById('logsContainer');
+    try {
+        let url = '/logs?limit=1000';
+        const level = document.getElementById('logsLevel').value;
+        const query = document.getElementById('logsQuery').value;
+        if (level) {
+            url += '&level=' + encodeURIComponent(level);
+        }
+        if (query) {
+            url += '&q=' + encodeURIComponent(query);
+        }
+        const data = await api(url);
+        container.innerHTML = '';
+        if (data.logs.length === 0) {
+            container.innerHTML = "<div class='log-entry'>暂无日志记录</div>";
+            return;
+        }
+        renderLogLines(container, data.logs);
+    } catch (e) {
+        container.textContent = '获取日志失败: ' + e.message;
+    }
+}
+
+// 倒序显示日志，最新的在顶部
+function renderLogLines(container, lines) {
+    for (let i = lines.length - 1; i >= 0; i--) {
+        const log = lines[i];
+        let logClass = 'info';
+        if (log.includes('[ERROR]')) {
+            logClass = 'error';
+        } else if (log.includes('[WARNING]')) {
+            logClass = 'warning';
+        }
+        const entry = document.createElement('div');
+        entry.className = 'log-entry ' + logClass;
+        entry.textContent = log;
+        container.appendChild(entry);
+    }
+}
+
+// 列出磁盘上的日志文件，包括轮转出的旧文件
+async function loadLogFiles() {
+    const select = document.getElementById('logFile');
+    try {
+        const data = await api('/logs/files');
+        const selected = select.value;
+        select.innerHTML = '';
+        for (const file of data.files) {
+            const option = document.createElement('option');
+            option.value = file.name;
+            option.textContent = file.name + '（' + file.mod_time + '，' + Math.ceil(file.size / 1024) + 'KB）';
+            select.appendChild(option);
+        }
+        if (selected) {
+            select.value = selected;
+        }
+    } catch (e) {
+        toast('获取日志文件失败: ' + e.message);
+    }
+}
+
+// 查看日志文件的最后500行，程序重启后可以查看重启前的日志
+async function loadLogFile() {
+    const name = document.getElementById('logFile').value;
+    const container = document.getElementById('logFileContainer');
+    if (!name) {
+        return;
+    }
+    try {
+        const data = await api('/logs/files/' + encodeURIComponent(name) + '?tail=500');
+        container.innerHTML = '';
+        renderLogLines(container, data.content.split('\n').filter(line => line !== ''));
+    } catch (e) {
+        container.textContent = '读取日志文件失败: ' + e.message;
+    }
+}
+
+// ---------- 事件绑定 ----------
+
+document.addEventListener('DOMContentLoaded', function() {
+    document.getElementById('schedulerStart').addEventListener('click', async () => {
+        try {
+            await postJSON('/api/v1/scheduler/start');
+            toast('定时任务已启动');
+        } catch (e) {
+            toast('启动失败: ' + e.message);
+        }
+        refreshScheduler();
+    });
+
+    document.getElementById('schedulerStop').addEventListener('click', async () => {
+        if (!confirm('确定要停止定时任务吗？')) {
+            return;
+        }
+        try {
+            await postJSON('/api/v1/scheduler/stop');
+            toast('定时任务已停止');
+        } catch (e) {
+            toast('停止失败: ' + e.message);
+        }
+        refreshScheduler();
+    });
+
+    document.getElementById('networkTest').addEventListener('click', async () => {
+        try {
+            const data = await postJSON('/api/v1/network/test');
+            toast(data.connected ? '探测完成，当前线路 ' + data.route : '所有线路均不可用');
+        } catch (e) {
+            toast('探测失败: ' + e.message);
+        }
+        refreshNetwork();
+    });
+
+    document.getElementById('updateTrigger').addEventListener('click', async () => {
+        const symbol = document.getElementById('updateSymbol').value.trim().toUpperCase();
+        const intervals = document.getElementById('updateIntervals').value.split(',').map(s => s.trim()).filter(s => s);
+        try {
+            await postJSON('/api/v1/update', {symbol: symbol, intervals: intervals});
+            toast('已触发 ' + symbol + ' 更新');
+        } catch (e) {
+            toast('触发失败: ' + e.message);
+        }
+    });
+
+    document.getElementById('chartLoad').addEventListener('click', loadChart);
+    document.getElementById('gapCheck').addEventListener('click', checkGaps);
+    document.getElementById('logsRefresh').addEventListener('click', refreshLogs);
+    document.getElementById('logsLevel').addEventListener('change', refreshLogs);
+    document.getElementById('logsQuery').addEventListener('change', refreshLogs);
+    document.getElementById('logFileLoad').addEventListener('click', loadLogFile);
+    document.getElementById('logsAutoRefresh').addEventListener('change', showTab);
+
+    window.addEventListener('hashchange', showTab);
+    showTab();
+});
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/gin-gonic/gin"
+)
+
+// 一个数据集最多包含的K线数
+const datasetMaxRows = 1000000
+
+// 数据集名称只允许字母、数字、下划线、点和短横线
+var datasetNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// datasetRequest 创建数据集的请求
+type datasetRequest struct {
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Interval    string `json:"interval"`
+	Start       string `json:"start"` // 区间开始（含），日期、RFC3339时间或毫秒时间戳
+	End         string `json:"end"`   // 区间结束（不含），为空时到最后一根已收盘的K线
+	Description string `json:"description"`
+}
+
+// DatasetResponse 数据集信息，verify=true时附带校验结果
+type DatasetResponse struct {
+	db.Dataset
+	Verified *bool `json:"verified,omitempty"` // 重新计算的校验和与创建时一致
+}
+
+// DatasetKlinesResponse 数据集中的K线
+type DatasetKlinesResponse struct {
+	DatasetID int64       `json:"dataset_id"`
+	Symbol    string      `json:"symbol"`
+	Interval  string      `json:"interval"`
+	Count     int         `json:"count"`
+	Data      []db.Candle `json:"data"` // open_time为UTC毫秒
+}
+
+// datasetID 解析路径中的数据集ID，出错时已返回响应
+func datasetID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		badRequest(c, "无效的数据集ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// loadDataset 按ID查询数据集，不存在时已返回404
+func loadDataset(c *gin.Context, id int64) (*db.Dataset, bool) {
+	dataset, err := db.GetDataset(id)
+	if err == db.ErrDatasetNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "数据集不存在: "+strconv.FormatInt(id, 10))
+		return nil, false
+	}
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	return dataset, true
+}
+
+// createDataset 把一个交易对和时间间隔在[start, end)内已收盘的K线复制为命名数据集，
+// 之后回补或修复K线不会改变数据集的内容
+func createDataset(c *gin.Context) {
+	var req datasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return
+	}
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	if req.Name == "" || req.Symbol == "" || req.Interval == "" || req.Start == "" {
+		badRequest(c, "缺少必要参数: name, symbol, interval, start")
+		return
+	}
+	if !datasetNamePattern.MatchString(req.Name) {
+		badRequest(c, "name 只能包含字母、数字、下划线、点和短横线，最长128个字符")
+		return
+	}
+	if !config.IsSupportedInterval(req.Interval) {
+		badRequest(c, "不支持的时间间隔: "+req.Interval)
+		return
+	}
+
+	start, err := parseBoundary(req.Start)
+	if err != nil || start <= 0 {
+		badRequest(c, "无效的start参数，应为日期（如 2024-01-01）、RFC3339时间或毫秒时间戳")
+		return
+	}
+	// 未收盘的K线还会变化，不放入数据集
+	end := intervalStart(req.Interval, time.Now().UnixMilli())
+	if req.End != "" {
+		v, err := parseBoundary(req.End)
+		if err != nil {
+			badRequest(c, "无效的end参数，应为日期（如 2024-01-01）、RFC3339时间或毫秒时间戳")
+			return
+		}
+		if v < end {
+			end = v
+		}
+	}
+	if start >= end {
+		badRequest(c, "需要满足 start < end，且区间内有已收盘的K线")
+		return
+	}
+
+	dataset, err := db.CreateDataset(c.Request.Context(), &db.Dataset{
+		Name:        req.Name,
+		Symbol:      req.Symbol,
+		Interval:    req.Interval,
+		Description: req.Description,
+	}, start, end-1, datasetMaxRows)
+	switch err {
+	case nil:
+	case db.ErrDatasetExists:
+		respondError(c, http.StatusConflict, CodeConflict, "数据集名称已存在: "+req.Name)
+		return
+	case db.ErrDatasetEmpty:
+		badRequest(c, "区间内没有 "+req.Symbol+" "+req.Interval+" 的K线")
+		return
+	case db.ErrDatasetTooLarge:
+		badRequest(c, "区间内的K线超过"+strconv.Itoa(datasetMaxRows)+"根，请缩小区间")
+		return
+	default:
+		internalError(c, err)
+		return
+	}
+
+	logRequestInfo(c, "创建数据集 %d %s: %s %s，%d 根K线，校验和 %s",
+		dataset.ID, dataset.Name, dataset.Symbol, dataset.Interval, dataset.Rows, dataset.Checksum)
+	respondOK(c, DatasetResponse{Dataset: *dataset})
+}
+
+// listDatasets 按创建时间倒序列出数据集
+func listDatasets(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	datasets, err := db.ListDatasets(strings.ToUpper(c.Query("symbol")), limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if datasets == nil {
+		datasets = []db.Dataset{}
+	}
+	respondOK(c, datasets)
+}
+
+// getDataset 查询数据集，verify=true时重新计算全部行的校验和并与创建时的比较
+func getDataset(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+	dataset, ok := loadDataset(c, id)
+	if !ok {
+		return
+	}
+
+	resp := DatasetResponse{Dataset: *dataset}
+	if c.Query("verify") == "true" && dataset.Status == "ready" {
+		checksum, rows, err := db.DatasetChecksum(c.Request.Context(), id)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		verified := checksum == dataset.Checksum && rows == dataset.Rows
+		if !verified {
+			logRequestWarning(c, "数据集 %d 校验失败: 记录 %s（%d 行），实际 %s（%d 行）",
+				id, dataset.Checksum, dataset.Rows, checksum, rows)
+		}
+		resp.Verified = &verified
+	}
+	respondOK(c, resp)
+}
+
+// getDatasetKlines 按时间升序读取数据集中的K线
+func getDatasetKlines(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+
+	var startTime, endTime int64
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = parseTimeParam(v); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = parseTimeParam(v); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit <= 0 || limit > 10000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	dataset, ok := loadDataset(c, id)
+	if !ok {
+		return
+	}
+	klines, err := db.QueryDatasetRange(id, startTime, endTime, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if klines == nil {
+		klines = []db.Candle{}
+	}
+	respondOK(c, DatasetKlinesResponse{
+		DatasetID: id,
+		Symbol:    dataset.Symbol,
+		Interval:  dataset.Interval,
+		Count:     len(klines),
+		Data:      klines,
+	})
+}
+
+// deleteDataset 删除数据集，引用它的回测将无法再复现
+func deleteDataset(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteDataset(id)
+	if err == db.ErrDatasetNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "数据集不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "删除数据集 %d", id)
+	respondMessage(c, "数据集已删除", nil)
+}
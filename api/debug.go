@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 返回最近多少次GC的停顿时间
+const recentGCPauses = 20
+
+var processStart = time.Now()
+
+// DebugStats 运行时诊断信息
+type DebugStats struct {
+	Uptime     string `json:"uptime"`
+	Goroutines int    `json:"goroutines"`
+	NumCPU     int    `json:"num_cpu"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GoVersion  string `json:"go_version"`
+
+	Memory DebugMemStats `json:"memory"`
+	GC     DebugGCStats  `json:"gc"`
+}
+
+// DebugMemStats 内存统计，单位为字节
+type DebugMemStats struct {
+	Alloc        uint64 `json:"alloc"`         // 当前堆上存活对象占用
+	TotalAlloc   uint64 `json:"total_alloc"`   // 累计分配
+	Sys          uint64 `json:"sys"`           // 从操作系统获取的内存
+	HeapAlloc    uint64 `json:"heap_alloc"`    // 堆已分配
+	HeapInuse    uint64 `json:"heap_inuse"`    // 堆正在使用的span
+	HeapIdle     uint64 `json:"heap_idle"`     // 堆空闲的span
+	HeapReleased uint64 `json:"heap_released"` // 已归还操作系统的内存
+	HeapObjects  uint64 `json:"heap_objects"`  // 堆上存活对象数
+	StackInuse   uint64 `json:"stack_inuse"`   // goroutine栈占用
+	Mallocs      uint64 `json:"mallocs"`       // 累计分配次数
+	Frees        uint64 `json:"frees"`         // 累计释放次数
+}
+
+// DebugGCStats GC统计
+type DebugGCStats struct {
+	NumGC         int64      `json:"num_gc"`
+	LastGC        *time.Time `json:"last_gc,omitempty"`
+	NextGC        uint64     `json:"next_gc"`         // 下次GC触发时的堆大小（字节）
+	PauseTotalMs  float64    `json:"pause_total_ms"`  // 累计停顿时间
+	RecentPauseMs []float64  `json:"recent_pause_ms"` // 最近的停顿时间，最新的在前
+	CPUFraction   float64    `json:"gc_cpu_fraction"` // GC占用的CPU时间比例
+}
+
+// StartDebugServer 在单独的地址上提供pprof和运行时诊断，未配置API_DEBUG_ADDR时不启动
+// pprof可以获取内存和goroutine的完整快照，不能暴露在对外的API端口上，应只监听本机或内网地址
+func StartDebugServer(cfg *config.APIConfig) {
+	if cfg.DebugAddr == "" {
+		return
+	}
+
+	engine := gin.New()
+	engine.Use(gin.Recovery(), requestIDMiddleware())
+	engine.GET("/debug/stats", getDebugStats)
+
+	engine.GET("/debug/pprof/", gin.WrapF(pprof.Index))
+	engine.GET("/debug/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	engine.GET("/debug/pprof/profile", gin.WrapF(pprof.Profile))
+	engine.GET("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
+	engine.POST("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
+	engine.GET("/debug/pprof/trace", gin.WrapF(pprof.Trace))
+	// heap、goroutine、allocs等由pprof.Index按名称处理
+	engine.GET("/debug/pprof/:name", gin.WrapF(pprof.Index))
+
+	server := &http.Server{
+		Addr:    cfg.DebugAddr,
+		Handler: engine,
+		// CPU profile和trace会按seconds参数持续采集，不设置WriteTimeout
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		utils.LogInfo("启动诊断服务，监听地址: %s", cfg.DebugAddr)
+		if err := server.ListenAndServe(); err != nil {
+			utils.LogError("诊断服务异常退出: %v", err)
+		}
+	}()
+}
+
+// getDebugStats 返回goroutine数量、内存和GC统计
+func getDebugStats(c *gin.Context) {
+	respondOK(c, collectDebugStats())
+}
+
+// collectDebugStats 采集运行时统计，ReadMemStats会短暂停止所有goroutine，不宜高频调用
+func collectDebugStats() DebugStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var gc debug.GCStats
+	gc.Pause = make([]time.Duration, 0, recentGCPauses)
+	debug.ReadGCStats(&gc)
+
+	pauses := gc.Pause
+	if len(pauses) > recentGCPauses {
+		pauses = pauses[:recentGCPauses]
+	}
+	recent := make([]float64, len(pauses))
+	for i, p := range pauses {
+		recent[i] = durationMs(p)
+	}
+
+	stats := DebugStats{
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		GoVersion:  runtime.Version(),
+		Memory: DebugMemStats{
+			Alloc:        m.Alloc,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapIdle:     m.HeapIdle,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			Mallocs:      m.Mallocs,
+			Frees:        m.Frees,
+		},
+		GC: DebugGCStats{
+			NumGC:         gc.NumGC,
+			NextGC:        m.NextGC,
+			PauseTotalMs:  durationMs(gc.PauseTotal),
+			RecentPauseMs: recent,
+			CPUFraction:   m.GCCPUFraction,
+		},
+	}
+	if gc.NumGC > 0 {
+		stats.GC.LastGC = &gc.LastGC
+	}
+	return stats
+}
+
+// durationMs 转换为毫秒，保留小数
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
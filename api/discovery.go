@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ticker24h 币安24小时行情（只保留用到的字段）
+type ticker24h struct {
+	Symbol      string `json:"symbol"`
+	QuoteVolume string `json:"quoteVolume"`
+}
+
+// 配置文件中静态指定的交易对，自动发现时始终保留
+var pinnedSymbols []string
+
+// FetchTopSymbols 按24小时成交额获取指定计价货币的前N个交易对
+func FetchTopSymbols(quoteAsset string, topN int) ([]string, error) {
+	body, err := binanceGet("/api/v3/ticker/24hr")
+	if err != nil {
+		return nil, err
+	}
+
+	var tickers []ticker24h
+	if err := json.Unmarshal(body, &tickers); err != nil {
+		utils.LogError("解析币安24小时行情失败: %v", err)
+		return nil, err
+	}
+
+	type candidate struct {
+		symbol string
+		volume float64
+	}
+	var candidates []candidate
+	for _, t := range tickers {
+		// 优先使用交易对元数据判断计价货币和状态，没有元数据时按后缀判断
+		if info, ok := GetExchangeSymbol(t.Symbol); ok {
+			if info.QuoteAsset != quoteAsset || info.Status != "TRADING" {
+				continue
+			}
+		} else if !strings.HasSuffix(t.Symbol, quoteAsset) {
+			continue
+		}
+
+		volume, err := strconv.ParseFloat(t.QuoteVolume, 64)
+		if err != nil || volume <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{t.Symbol, volume})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].volume > candidates[j].volume
+	})
+
+	if len(candidates) > topN {
+		candidates = candidates[:topN]
+	}
+
+	result := make([]string, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.symbol
+	}
+	return result, nil
+}
+
+// RefreshDiscoveredSymbols 重新计算自动发现的交易对集合并更新采集范围
+func RefreshDiscoveredSymbols(cfg *config.Config) error {
+	if !cfg.Binance.AutoDiscover {
+		return nil
+	}
+
+	top, err := FetchTopSymbols(cfg.Binance.AutoDiscoverQuote, cfg.Binance.AutoDiscoverTopN)
+	if err != nil {
+		return err
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	if pinnedSymbols == nil {
+		pinnedSymbols = cfg.Binance.CurrentSymbols()
+	}
+
+	// 合并静态配置和自动发现的交易对，保持顺序并去重
+	seen := make(map[string]bool)
+	var universe []string
+	for _, symbol := range append(append([]string{}, pinnedSymbols...), top...) {
+		symbol = strings.ToUpper(symbol)
+		if !seen[symbol] {
+			seen[symbol] = true
+			universe = append(universe, symbol)
+		}
+	}
+
+	previous := make(map[string]bool)
+	for _, symbol := range cfg.Binance.CurrentSymbols() {
+		previous[strings.ToUpper(symbol)] = true
+	}
+
+	var added, removed []string
+	for _, symbol := range universe {
+		if !previous[symbol] {
+			added = append(added, symbol)
+		}
+	}
+	for symbol := range previous {
+		if !seen[symbol] {
+			removed = append(removed, symbol)
+		}
+	}
+
+	// 为新加入的交易对建表
+	if err := db.InitAllTables(added, cfg.Binance.IntervalsFor); err != nil {
+		return err
+	}
+
+	// 移出的交易对停止采集，已有数据表保留
+	for _, symbol := range removed {
+		delete(lastUpdateTime, symbol)
+	}
+
+	cfg.Binance.SetSymbols(universe)
+	utils.LogInfo("自动发现交易对完成，共 %d 个，新增: %v，移除: %v（数据表保留）", len(universe), added, removed)
+	return nil
+}
+
+// AddDiscoveryTask 添加定期刷新自动发现交易对的定时任务
+func AddDiscoveryTask(cfg *config.Config) error {
+	if !cfg.Binance.AutoDiscover {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("discovery", cfg.Cron.DiscoverySchedule, func() error {
+		err := RefreshDiscoveredSymbols(cfg)
+		if err != nil {
+			utils.LogError("刷新自动发现交易对失败: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("添加自动发现任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加自动发现交易对任务，cron表达式: %s", cfg.Cron.DiscoverySchedule)
+	return nil
+}
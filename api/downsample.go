@@ -0,0 +1,154 @@
+package api
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 降采样时单次最多读取的原始K线数量
+const downsampleMaxSource = 100000
+
+// 降采样方法
+const (
+	downsampleBucket = "bucket" // 按数量均分为若干组，每组合并为一根K线
+	downsampleLTTB   = "lttb"   // Largest-Triangle-Three-Buckets，按收盘价选取视觉上最有代表性的K线
+)
+
+// DownsampleInfo 降采样说明，原始K线不超过max_points时不降采样，也不返回该字段
+type DownsampleInfo struct {
+	Method      string `json:"method"`
+	MaxPoints   int    `json:"max_points"`
+	SourceCount int    `json:"source_count"` // 区间内读取的原始K线数量
+}
+
+// parseDownsample 解析max_points和downsample参数，未指定max_points时返回0，出错时已返回响应
+func parseDownsample(c *gin.Context) (int, string, bool) {
+	v := c.Query("max_points")
+	if v == "" {
+		return 0, "", true
+	}
+	maxPoints, err := strconv.Atoi(v)
+	if err != nil || maxPoints < 2 || maxPoints > maxQueryLimit() {
+		badRequest(c, "无效的max_points参数，应在2到"+strconv.Itoa(maxQueryLimit())+"之间")
+		return 0, "", false
+	}
+
+	method := c.DefaultQuery("downsample", downsampleBucket)
+	if method != downsampleBucket && method != downsampleLTTB {
+		badRequest(c, "无效的downsample参数，可选 bucket、lttb")
+		return 0, "", false
+	}
+	return maxPoints, method, true
+}
+
+// downsampleKlines 将按时间倒序的K线降采样到不超过maxPoints根，返回结果同样按时间倒序
+func downsampleKlines(klines []KlineItem, maxPoints int, method string) []KlineItem {
+	if len(klines) <= maxPoints {
+		return klines
+	}
+
+	// 转为升序计算
+	asc := make([]KlineItem, len(klines))
+	for i, k := range klines {
+		asc[len(klines)-1-i] = k
+	}
+
+	var result []KlineItem
+	if method == downsampleLTTB {
+		result = lttbKlines(asc, maxPoints)
+	} else {
+		result = bucketKlines(asc, maxPoints)
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// bucketKlines 将升序K线按数量均分为n组，每组合并为一根：开盘取首根、收盘取末根、最高最低取极值、成交量求和
+func bucketKlines(klines []KlineItem, n int) []KlineItem {
+	result := make([]KlineItem, 0, n)
+	for b := 0; b < n; b++ {
+		from := b * len(klines) / n
+		to := (b + 1) * len(klines) / n
+		if from >= to {
+			continue
+		}
+
+		agg := KlineItem{
+			Timestamp:  klines[from].Timestamp,
+			Datetime:   klines[from].Datetime,
+			OpenPrice:  klines[from].OpenPrice,
+			ClosePrice: klines[to-1].ClosePrice,
+			HighPrice:  klines[from].HighPrice,
+			LowPrice:   klines[from].LowPrice,
+		}
+		high := parseDecimal(agg.HighPrice)
+		low := parseDecimal(agg.LowPrice)
+		var volume float64
+		for _, k := range klines[from:to] {
+			if h := parseDecimal(k.HighPrice); h > high {
+				high, agg.HighPrice = h, k.HighPrice
+			}
+			if l := parseDecimal(k.LowPrice); l < low {
+				low, agg.LowPrice = l, k.LowPrice
+			}
+			volume += parseDecimal(k.Volume)
+		}
+		agg.Volume = formatFloat(volume)
+		result = append(result, agg)
+	}
+	return result
+}
+
+// lttbKlines 按收盘价用LTTB算法从升序K线中选取n根，保留首末两根，选中的K线原样返回
+func lttbKlines(klines []KlineItem, n int) []KlineItem {
+	closes := make([]float64, len(klines))
+	for i, k := range klines {
+		closes[i] = parseDecimal(k.ClosePrice)
+	}
+
+	result := make([]KlineItem, 0, n)
+	result = append(result, klines[0])
+
+	// 除首末两根外，其余K线均分为n-2组，每组选取与前一个选中点、下一组平均点构成三角形面积最大的一根
+	every := float64(len(klines)-2) / float64(n-2)
+	selected := 0
+	for b := 0; b < n-2; b++ {
+		from := int(float64(b)*every) + 1
+		to := int(float64(b+1)*every) + 1
+
+		// 下一组的平均点，最后一组使用末根K线
+		nextFrom, nextTo := to, int(float64(b+2)*every)+1
+		if nextTo > len(klines)-1 {
+			nextTo = len(klines) - 1
+		}
+		if nextFrom >= nextTo {
+			nextFrom, nextTo = len(klines)-1, len(klines)
+		}
+		var avgX, avgY float64
+		for i := nextFrom; i < nextTo; i++ {
+			avgX += float64(klines[i].Timestamp)
+			avgY += closes[i]
+		}
+		avgX /= float64(nextTo - nextFrom)
+		avgY /= float64(nextTo - nextFrom)
+
+		ax, ay := float64(klines[selected].Timestamp), closes[selected]
+		maxArea := -1.0
+		best := from
+		for i := from; i < to; i++ {
+			area := math.Abs((ax-avgX)*(closes[i]-ay) - (ax-float64(klines[i].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea, best = area, i
+			}
+		}
+		result = append(result, klines[best])
+		selected = best
+	}
+
+	return append(result, klines[len(klines)-1])
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 连续失败（或响应过慢）达到该次数后切换到下一个接入点
+const endpointMaxFailures = 3
+
+// 被判定为不健康的接入点在该时间后重新参与轮换
+const endpointCooldown = 60 * time.Second
+
+// apiEndpoint 币安API接入点及其健康状态
+type apiEndpoint struct {
+	URL           string    `json:"url"`
+	Healthy       bool      `json:"healthy"`
+	Active        bool      `json:"active"`
+	Failures      int       `json:"consecutive_failures"`
+	TotalRequests int64     `json:"total_requests"`
+	TotalFailures int64     `json:"total_failures"`
+	LatencyMs     float64   `json:"latency_ms"` // 成功请求耗时的指数移动平均
+	LastError     string    `json:"last_error,omitempty"`
+	LastFailure   time.Time `json:"last_failure"`
+	LastSuccess   time.Time `json:"last_success"`
+}
+
+var (
+	apiEndpoints   []*apiEndpoint
+	activeEndpoint int
+	endpointMu     sync.Mutex
+)
+
+// initAPIEndpoints 初始化接入点列表，未配置时只使用BINANCE_BASE_URL
+func initAPIEndpoints(baseURLs []string, defaultURL string) {
+	if len(baseURLs) == 0 {
+		baseURLs = []string{defaultURL}
+	}
+
+	var endpoints []*apiEndpoint
+	for _, u := range baseURLs {
+		endpoints = append(endpoints, &apiEndpoint{
+			URL:     strings.TrimRight(u, "/"),
+			Healthy: true,
+		})
+	}
+
+	endpointMu.Lock()
+	apiEndpoints = endpoints
+	activeEndpoint = 0
+	endpointMu.Unlock()
+}
+
+// endpointOrder 返回本次请求依次尝试的接入点下标，当前接入点优先，不健康的接入点排在最后
+func endpointOrder() []int {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+
+	var healthy, unhealthy []int
+	for i := 0; i < len(apiEndpoints); i++ {
+		idx := (activeEndpoint + i) % len(apiEndpoints)
+		ep := apiEndpoints[idx]
+		if !ep.Healthy && time.Since(ep.LastFailure) >= endpointCooldown {
+			ep.Healthy = true
+			ep.Failures = 0
+		}
+		if ep.Healthy {
+			healthy = append(healthy, idx)
+		} else {
+			unhealthy = append(unhealthy, idx)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// endpointURL 获取接入点地址
+func endpointURL(idx int) string {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+	return apiEndpoints[idx].URL
+}
+
+// currentBaseURL 获取当前使用的接入点地址
+func currentBaseURL() string {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+
+	if len(apiEndpoints) == 0 {
+		if appConfig != nil {
+			return appConfig.Binance.BaseURL
+		}
+		return "https://api.binance.com"
+	}
+	return apiEndpoints[activeEndpoint].URL
+}
+
+// markEndpointFailure 记录接入点请求失败，连续失败过多时标记为不健康并切换
+func markEndpointFailure(idx int, err error) {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+
+	ep := apiEndpoints[idx]
+	ep.TotalRequests++
+	ep.TotalFailures++
+	ep.Failures++
+	ep.LastError = err.Error()
+	ep.LastFailure = time.Now()
+	utils.IncCounter(utils.MetricName("biupdata_endpoint_failures_total", "endpoint", ep.URL))
+
+	if ep.Failures >= endpointMaxFailures && ep.Healthy {
+		ep.Healthy = false
+		utils.LogWarning("币安接入点 %s 连续失败 %d 次，暂时停用", ep.URL, ep.Failures)
+	}
+	if idx == activeEndpoint {
+		switchEndpointLocked()
+	}
+}
+
+// markEndpointSuccess 记录接入点请求成功，响应持续过慢时切换到其他接入点
+func markEndpointSuccess(idx int, latency time.Duration) {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+
+	ep := apiEndpoints[idx]
+	ms := float64(latency.Milliseconds())
+	if ep.LatencyMs == 0 {
+		ep.LatencyMs = ms
+	} else {
+		ep.LatencyMs = ep.LatencyMs*0.8 + ms*0.2
+	}
+	ep.TotalRequests++
+	ep.LastSuccess = time.Now()
+	ep.Healthy = true
+	utils.SetGauge(utils.MetricName("biupdata_endpoint_latency_ms", "endpoint", ep.URL), ep.LatencyMs)
+
+	slow := appConfig != nil && appConfig.Binance.SlowEndpointMs > 0 && ms > float64(appConfig.Binance.SlowEndpointMs)
+	if !slow {
+		ep.Failures = 0
+		if idx != activeEndpoint && !apiEndpoints[activeEndpoint].Healthy {
+			activeEndpoint = idx
+		}
+		return
+	}
+
+	ep.Failures++
+	if ep.Failures >= endpointMaxFailures && idx == activeEndpoint {
+		utils.LogWarning("币安接入点 %s 响应过慢（平均 %.0fms），尝试切换", ep.URL, ep.LatencyMs)
+		ep.Failures = 0
+		switchEndpointLocked()
+	}
+}
+
+// switchEndpointLocked 切换到下一个健康的接入点，调用方需持有endpointMu
+func switchEndpointLocked() {
+	for i := 1; i < len(apiEndpoints); i++ {
+		idx := (activeEndpoint + i) % len(apiEndpoints)
+		if apiEndpoints[idx].Healthy {
+			utils.LogInfo("币安接入点从 %s 切换到 %s", apiEndpoints[activeEndpoint].URL, apiEndpoints[idx].URL)
+			activeEndpoint = idx
+			return
+		}
+	}
+}
+
+// getEndpointStatus 获取各接入点的健康状态快照
+func getEndpointStatus() []apiEndpoint {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+
+	result := make([]apiEndpoint, len(apiEndpoints))
+	for i, ep := range apiEndpoints {
+		result[i] = *ep
+		result[i].Active = i == activeEndpoint
+	}
+	return result
+}
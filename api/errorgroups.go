@@ -0,0 +1,63 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorGroupsResponse 按格式字符串聚合的错误和警告
+type ErrorGroupsResponse struct {
+	Groups []utils.ErrorGroup `json:"groups"`
+	Count  int                `json:"count"`
+	Total  int64              `json:"total"` // 匹配分组的总出现次数
+}
+
+// ErrorGroupsCleared 清空错误聚合的结果
+type ErrorGroupsCleared struct {
+	Cleared int `json:"cleared"`
+}
+
+// getErrorGroups 查询聚合后的错误和警告，默认按出现次数倒序，反复出现的同一个错误只占一行
+func getErrorGroups(c *gin.Context) {
+	q := utils.ErrorGroupQuery{Sort: c.DefaultQuery("sort", "count")}
+
+	if v := c.Query("level"); v != "" {
+		q.Level = strings.ToUpper(v)
+		if q.Level != "ERROR" && q.Level != "WARNING" {
+			badRequest(c, "无效的level参数，可选 error、warning")
+			return
+		}
+	}
+	if q.Sort != "count" && q.Sort != "recent" {
+		badRequest(c, "无效的sort参数，可选 count、recent")
+		return
+	}
+	if v := c.Query("since"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(c, "无效的since参数")
+			return
+		}
+		q.Since = time.UnixMilli(ms)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+	q.Limit = limit
+
+	groups, total := utils.GetErrorGroups(q)
+	respondOK(c, ErrorGroupsResponse{Groups: groups, Count: len(groups), Total: total})
+}
+
+// resetErrorGroups 清空错误聚合，用于问题修复后重新统计
+func resetErrorGroups(c *gin.Context) {
+	n := utils.ResetErrorGroups()
+	logRequestInfo(c, "清空错误聚合，共 %d 个分组", n)
+	respondMessage(c, "错误聚合已清空", ErrorGroupsCleared{Cleared: n})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// klineETag 由最新一根K线的时间戳和响应内容摘要生成ETag
+// 只用时间戳无法发现未收盘K线的价格变化和标注修改，因此同时计算内容摘要
+func klineETag(latest int64, resp interface{}) string {
+	h := fnv.New64a()
+	json.NewEncoder(h).Encode(resp)
+	return fmt.Sprintf(`W/"%d-%x"`, latest, h.Sum64())
+}
+
+// respondCached 写入ETag和Last-Modified响应头，客户端缓存仍然有效时返回304，否则返回数据
+// latest为最新一根K线的timestamp（与klines中的口径一致），没有数据时为0
+func respondCached(c *gin.Context, interval string, latest int64, resp interface{}) {
+	etag := klineETag(latest, resp)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+
+	lastModified := klineLastModified(interval, latest)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	if notModified(c, etag, lastModified) {
+		utils.IncCounter("biupdata_http_not_modified_total")
+		c.Status(http.StatusNotModified)
+		return
+	}
+	respondOK(c, resp)
+}
+
+// klineLastModified 按最新一根K线的收盘时间作为Last-Modified；未收盘的K线在开盘时间不变的情况下仍会更新，
+// 此时返回零值，不写Last-Modified，If-Modified-Since不生效，只按ETag判断
+func klineLastModified(interval string, latest int64) time.Time {
+	if latest <= 0 {
+		return time.Time{}
+	}
+	closeTime := nextIntervalStart(interval, utils.StoredTimestampToUTC(latest)) - 1
+	if closeTime >= time.Now().UnixMilli() {
+		return time.Time{}
+	}
+	return time.UnixMilli(closeTime).UTC()
+}
+
+// notModified 按If-None-Match或If-Modified-Since判断客户端缓存是否仍然有效，同时存在时以If-None-Match为准
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
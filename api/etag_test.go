@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// storedTimestamp 把UTC毫秒转换为从数据库读出的口径（配置时区的本地时间按UTC解析）
+func storedTimestamp(timestamp int64) int64 {
+	wall := utils.TimestampToShanghai(timestamp)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC).UnixMilli()
+}
+
+func TestKlineLastModified(t *testing.T) {
+	openTime := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	current := time.Now().UTC().Truncate(time.Hour)
+
+	tests := []struct {
+		name   string
+		latest int64
+		want   time.Time
+	}{
+		{"no data", 0, time.Time{}},
+		{"closed candle uses close time", storedTimestamp(openTime.UnixMilli()), openTime.Add(time.Hour - time.Millisecond)},
+		{"open candle has no last modified", storedTimestamp(current.UnixMilli()), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := klineLastModified("1h", tt.latest); !got.Equal(tt.want) {
+			t.Errorf("%s: klineLastModified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRespondCachedConditional(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	openTime := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	closed := storedTimestamp(openTime.UnixMilli())
+	open := storedTimestamp(time.Now().UTC().Truncate(time.Hour).UnixMilli())
+	resp := gin.H{"klines": []int{1, 2, 3}}
+	lastModified := openTime.Add(time.Hour - time.Millisecond).Format(http.TimeFormat)
+
+	tests := []struct {
+		name             string
+		latest           int64
+		header           map[string]string
+		wantStatus       int
+		wantLastModified string
+	}{
+		{"no conditional headers", closed, nil, http.StatusOK, lastModified},
+		{"if-modified-since at close time", closed, map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, lastModified},
+		{"if-modified-since before close time", closed, map[string]string{"If-Modified-Since": openTime.Format(http.TimeFormat)}, http.StatusOK, lastModified},
+		{"open candle ignores if-modified-since", open, map[string]string{"If-Modified-Since": time.Now().UTC().Format(http.TimeFormat)}, http.StatusOK, ""},
+		{"matching etag", closed, map[string]string{"If-None-Match": klineETag(closed, resp)}, http.StatusNotModified, lastModified},
+		{"if-none-match takes precedence", closed, map[string]string{"If-None-Match": `W/"0-0"`, "If-Modified-Since": lastModified}, http.StatusOK, lastModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/kline", func(c *gin.Context) { respondCached(c, "1h", tt.latest, resp) })
+
+			req := httptest.NewRequest(http.MethodGet, "/kline", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Last-Modified"); got != tt.wantLastModified {
+				t.Fatalf("Last-Modified = %q, want %q", got, tt.wantLastModified)
+			}
+		})
+	}
+}
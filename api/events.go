@@ -0,0 +1,249 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 随K线一起返回的事件数量上限
+const klineEventsLimit = 1000
+
+// EventsResponse 市场事件列表
+type EventsResponse struct {
+	Events []db.MarketEvent `json:"events"`
+	Count  int              `json:"count"`
+}
+
+// KlineEvent 随K线返回的事件，kline_timestamp为事件所在K线的timestamp，便于图表标注
+type KlineEvent struct {
+	db.MarketEvent
+	KlineTimestamp int64 `json:"kline_timestamp"`
+}
+
+// eventRequest 新增或修改事件的请求体
+type eventRequest struct {
+	Symbol      string `json:"symbol"`
+	Category    string `json:"category"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// bindEvent 解析并校验事件请求体，出错时已返回响应
+func bindEvent(c *gin.Context) (*db.MarketEvent, bool) {
+	var req eventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return nil, false
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || req.Timestamp <= 0 {
+		badRequest(c, "缺少必要参数: title, timestamp")
+		return nil, false
+	}
+	if utf8.RuneCountInString(req.Title) > 255 {
+		badRequest(c, "title 长度不能超过255个字符")
+		return nil, false
+	}
+
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	if req.Category == "" {
+		req.Category = "other"
+	}
+	if len(req.Category) > 32 {
+		badRequest(c, "category 长度不能超过32个字符")
+		return nil, false
+	}
+
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	if len(req.Symbol) > 32 {
+		badRequest(c, "无效的symbol参数")
+		return nil, false
+	}
+
+	return &db.MarketEvent{
+		Symbol:      req.Symbol,
+		Category:    req.Category,
+		Title:       req.Title,
+		Description: req.Description,
+		Timestamp:   req.Timestamp,
+	}, true
+}
+
+// eventID 解析路径中的事件ID，出错时已返回响应
+func eventID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		badRequest(c, "无效的事件ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// createEvent 新增市场事件
+func createEvent(c *gin.Context) {
+	event, ok := bindEvent(c)
+	if !ok {
+		return
+	}
+
+	id, err := db.CreateEvent(event)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	created, err := db.GetEvent(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "新增市场事件 %d: %s", id, created.Title)
+	respondOK(c, created)
+}
+
+// listEvents 按时间范围查询市场事件
+func listEvents(c *gin.Context) {
+	var startTime, endTime int64
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	events, err := db.ListEvents(strings.ToUpper(c.Query("symbol")), startTime, endTime, strings.ToLower(c.Query("category")), limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if events == nil {
+		events = []db.MarketEvent{}
+	}
+	respondOK(c, EventsResponse{Events: events, Count: len(events)})
+}
+
+// getEvent 查询单个市场事件
+func getEvent(c *gin.Context) {
+	id, ok := eventID(c)
+	if !ok {
+		return
+	}
+
+	event, err := db.GetEvent(id)
+	if err == db.ErrEventNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "事件不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, event)
+}
+
+// updateEvent 修改市场事件
+func updateEvent(c *gin.Context) {
+	id, ok := eventID(c)
+	if !ok {
+		return
+	}
+	event, ok := bindEvent(c)
+	if !ok {
+		return
+	}
+	event.ID = id
+
+	err := db.UpdateEvent(event)
+	if err == db.ErrEventNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "事件不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	updated, err := db.GetEvent(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "修改市场事件 %d: %s", id, updated.Title)
+	respondOK(c, updated)
+}
+
+// deleteEvent 删除市场事件
+func deleteEvent(c *gin.Context) {
+	id, ok := eventID(c)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteEvent(id)
+	if err == db.ErrEventNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "事件不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "删除市场事件 %d", id)
+	respondMessage(c, "事件已删除", nil)
+}
+
+// klineEvents 查询K线覆盖时间内的事件，并标出每个事件落在哪根K线上，klines按时间倒序
+func klineEvents(symbol, interval string, klines []KlineItem) ([]KlineEvent, error) {
+	if len(klines) == 0 {
+		return []KlineEvent{}, nil
+	}
+
+	// 升序排列各K线开盘时间（UTC），用于定位事件
+	opens := make([]int64, len(klines))
+	for i := range klines {
+		opens[len(klines)-1-i] = utils.StoredTimestampToUTC(klines[i].Timestamp)
+	}
+	start := opens[0]
+	end := nextIntervalStart(interval, opens[len(opens)-1]) - 1
+
+	events, err := db.ListEvents(strings.ToUpper(symbol), start, end, "", klineEventsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]KlineEvent, 0, len(events))
+	for _, e := range events {
+		// 最后一根开盘时间不晚于事件时间的K线
+		idx := sort.Search(len(opens), func(i int) bool { return opens[i] > e.Timestamp }) - 1
+		if idx < 0 {
+			continue
+		}
+		result = append(result, KlineEvent{
+			MarketEvent:    e,
+			KlineTimestamp: klines[len(klines)-1-idx].Timestamp,
+		})
+	}
+	return result, nil
+}
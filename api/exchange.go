@@ -0,0 +1,181 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// Exchange 请求交易所REST接口，K线更新、元数据同步等都通过它访问币安，可以替换为测试用的实现
+type Exchange interface {
+	// Get 请求接口并返回响应内容，path包含查询参数，如 /api/v3/klines?symbol=BTCUSDT；
+	// 非200响应返回错误，币安的错误码和错误信息解析为 *binanceError
+	Get(path string) ([]byte, error)
+}
+
+// binanceExchange 默认实现：经过网络线路选择、多接入点故障切换和请求权重统计访问币安
+type binanceExchange struct{}
+
+// Locker 多实例部署时避免重复更新同一交易对和时间间隔的互斥锁
+type Locker interface {
+	// Acquire 获取锁，返回释放时使用的令牌；已被其他实例持有时ok为false，无法确认时返回错误（不更新）
+	Acquire(key string, ttl time.Duration) (token string, ok bool, err error)
+	// Extend 更新期间定期调用，把仍由token持有的锁的过期时间重置为ttl，锁已失效时返回false（停止更新）
+	Extend(key, token string, ttl time.Duration) (bool, error)
+	// Release 释放Acquire获取的锁
+	Release(key, token string)
+}
+
+// redisLocker 默认实现：Redis分布式锁，未配置Redis时不加锁
+type redisLocker struct{}
+
+func (redisLocker) Acquire(key string, ttl time.Duration) (string, bool, error) {
+	return db.AcquireLock(key, ttl)
+}
+
+func (redisLocker) Extend(key, token string, ttl time.Duration) (bool, error) {
+	return db.ExtendLock(key, token, ttl)
+}
+
+func (redisLocker) Release(key, token string) {
+	db.ReleaseLock(key, token)
+}
+
+// Updater K线更新流程：从交易所获取K线，写入存储并推进同步水位。依赖都通过字段传入，
+// 使用 binancetest 和 db.MemoryStore 即可在没有网络和MySQL的环境中运行完整的更新流程
+type Updater struct {
+	Exchange Exchange       // 访问交易所
+	Store    db.UpdateStore // 写入K线和同步进度
+	Locker   Locker         // 为nil时不加锁
+	// Config 起始日期、上市时间检测、每页条数和是否跳过未收盘K线，为nil时使用默认值
+	Config *config.BinanceConfig
+	// Hooks 依次执行的K线处理扩展
+	Hooks []CandleHook
+	// LockTTL 更新锁的过期时间，为0时10分钟
+	LockTTL time.Duration
+	// AfterUpdate 一个时间间隔更新后调用，如计算滚动统计、聚合生成其他时间间隔；为nil时跳过
+	AfterUpdate func(symbol, interval string, updated int)
+
+	// shared 默认更新流程：配置、扩展、锁过期时间取自SetConfig和扩展登记，与回补、重建共用任务列表，
+	// 并参与背压、线路每页条数和更新频率调整；其他更新流程只使用上面的字段，互不影响
+	shared   bool
+	initOnce sync.Once
+	jobs     *updateJobRegistry
+
+	listingMu    sync.Mutex
+	listingTimes map[string]int64 // 各交易对和时间间隔第一根K线的开盘时间（UTC毫秒），0表示交易所没有该K线
+}
+
+// NewUpdater 创建通过ex获取K线并写入st的更新流程，不加锁，更新后不做额外处理
+func NewUpdater(ex Exchange, st db.UpdateStore) *Updater {
+	return &Updater{Exchange: ex, Store: st}
+}
+
+// defaultUpdater 定时任务、HTTP接口和命令行使用的更新流程：访问币安、写入MySQL、Redis分布式锁，
+// 更新后计算滚动统计和聚合；其他访问币安的请求（元数据同步、深度等）也使用它的 Exchange
+var defaultUpdater = &Updater{
+	Exchange: binanceExchange{},
+	Store:    db.MySQLStore(),
+	Locker:   redisLocker{},
+	shared:   true,
+	jobs:     sharedUpdateJobs,
+}
+
+// init 初始化任务列表和上市时间缓存，Updater可以直接用结构体字面量创建
+func (u *Updater) init() {
+	u.initOnce.Do(func() {
+		if u.jobs == nil {
+			u.jobs = newUpdateJobRegistry()
+		}
+		u.listingTimes = make(map[string]int64)
+	})
+}
+
+// binanceConfig 采集配置，默认更新流程使用SetConfig设置的配置
+func (u *Updater) binanceConfig() *config.BinanceConfig {
+	if !u.shared {
+		return u.Config
+	}
+	if appConfig == nil {
+		return nil
+	}
+	return &appConfig.Binance
+}
+
+// hooks 启用的K线处理扩展，默认更新流程使用配置和AddHook启用的扩展
+func (u *Updater) hooks() []CandleHook {
+	if u.shared {
+		return activeHooks()
+	}
+	return u.Hooks
+}
+
+// lockTTL 更新锁的过期时间
+func (u *Updater) lockTTL() time.Duration {
+	if u.shared {
+		return getLockTTL()
+	}
+	if u.LockTTL <= 0 {
+		return defaultLockTTL
+	}
+	return u.LockTTL
+}
+
+func init() {
+	// 滚动统计和聚合会再次写入K线，在init中设置以避免初始化循环
+	defaultUpdater.AfterUpdate = afterIntervalUpdate
+}
+
+// SetExchange 替换默认更新流程和其他请求访问交易所的实现，传入nil时恢复为币安，需在启动定时任务和HTTP服务之前调用
+func SetExchange(e Exchange) {
+	if e == nil {
+		e = binanceExchange{}
+	}
+	defaultUpdater.Exchange = e
+}
+
+// SetStore 替换默认更新流程使用的存储，传入nil时恢复为MySQL，需在启动定时任务和HTTP服务之前调用
+func SetStore(s db.UpdateStore) {
+	if s == nil {
+		s = db.MySQLStore()
+	}
+	defaultUpdater.Store = s
+}
+
+// HTTPExchange 直接请求BaseURL的交易所实现，不经过线路选择和故障切换，用于连接 binancetest 等模拟服务器
+type HTTPExchange struct {
+	BaseURL string
+	Client  *http.Client // 为空时使用10秒超时的客户端
+}
+
+// NewHTTPExchange 创建请求baseURL的交易所实现
+func NewHTTPExchange(baseURL string) *HTTPExchange {
+	return &HTTPExchange{BaseURL: strings.TrimRight(baseURL, "/"), Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Get 发送GET请求
+func (e *HTTPExchange) Get(path string) ([]byte, error) {
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(e.BaseURL + path)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseBinanceError(resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// exchangeInfoResponse 币安exchangeInfo接口的返回结构（只保留用到的字段）
+type exchangeInfoResponse struct {
+	RateLimits []struct {
+		RateLimitType string `json:"rateLimitType"`
+		Interval      string `json:"interval"`
+		IntervalNum   int    `json:"intervalNum"`
+		Limit         int    `json:"limit"`
+	} `json:"rateLimits"`
+	Symbols []struct {
+		Symbol     string                   `json:"symbol"`
+		Status     string                   `json:"status"`
+		BaseAsset  string                   `json:"baseAsset"`
+		QuoteAsset string                   `json:"quoteAsset"`
+		Filters    []map[string]interface{} `json:"filters"`
+	} `json:"symbols"`
+}
+
+// 交易对连续多少次同步不在exchangeInfo中才视为下架
+const delistedAfterMissing = 3
+
+var (
+	exchangeSymbols   map[string]*db.SymbolInfo // 最近一次同步的全部交易对元数据
+	exchangeSymbolsMu sync.RWMutex
+
+	missingSymbols   = make(map[string]int) // 交易对 -> 连续不在exchangeInfo中的次数
+	missingSymbolsMu sync.Mutex
+)
+
+// FetchExchangeInfo 获取币安全部交易对的元数据
+func FetchExchangeInfo() (map[string]*db.SymbolInfo, error) {
+	body, err := binanceGet("/api/v3/exchangeInfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var resp exchangeInfoResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		utils.LogError("解析币安exchangeInfo失败: %v", err)
+		return nil, err
+	}
+
+	for _, limit := range resp.RateLimits {
+		if limit.RateLimitType == "REQUEST_WEIGHT" && limit.Interval == "MINUTE" && limit.IntervalNum == 1 {
+			setWeightLimit(limit.Limit)
+		}
+	}
+
+	result := make(map[string]*db.SymbolInfo, len(resp.Symbols))
+	for _, s := range resp.Symbols {
+		info := &db.SymbolInfo{
+			Symbol:     s.Symbol,
+			Status:     s.Status,
+			BaseAsset:  s.BaseAsset,
+			QuoteAsset: s.QuoteAsset,
+		}
+
+		for _, filter := range s.Filters {
+			switch filter["filterType"] {
+			case "PRICE_FILTER":
+				info.TickSize, _ = filter["tickSize"].(string)
+			case "LOT_SIZE":
+				info.StepSize, _ = filter["stepSize"].(string)
+				info.MinQty, _ = filter["minQty"].(string)
+			case "NOTIONAL", "MIN_NOTIONAL":
+				info.MinNotional, _ = filter["minNotional"].(string)
+			}
+		}
+		result[s.Symbol] = info
+	}
+
+	return result, nil
+}
+
+// SyncExchangeInfo 同步交易对元数据并校验配置的交易对，不可用的交易对停止采集并返回；
+// 已停止采集的交易对恢复为TRADING时重新开始采集
+func SyncExchangeInfo(cfg *config.Config) ([]string, error) {
+	infos, err := FetchExchangeInfo()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, errors.New("币安exchangeInfo没有返回任何交易对")
+	}
+
+	// 不完整的响应不作为交易对缺失的依据，也不替换上次同步的元数据
+	exchangeSymbolsMu.Lock()
+	previous := len(exchangeSymbols)
+	complete := exchangeInfoComplete(len(infos), previous)
+	if complete {
+		exchangeSymbols = infos
+	}
+	exchangeSymbolsMu.Unlock()
+	if !complete {
+		utils.LogWarning("币安exchangeInfo只返回了 %d 个交易对（上次 %d 个），本次不判断缺失的交易对", len(infos), previous)
+	}
+
+	if err := db.CreateSymbolsTableIfNotExists(); err != nil {
+		return nil, err
+	}
+
+	check := checkConfiguredSymbols(cfg.Binance.CurrentSymbols(), infos, complete, knownSymbol, db.SaveSymbolInfo)
+
+	// 已停止采集的交易对恢复交易时清除停止采集标记
+	var resumed []string
+	for _, symbol := range retiredSymbolList() {
+		info, exists := infos[symbol]
+		if !exists || info.Status != "TRADING" {
+			continue
+		}
+		if err := db.SaveSymbolInfo(info); err != nil {
+			continue
+		}
+		countMissingSymbol(symbol, false)
+		resumed = append(resumed, symbol)
+	}
+
+	utils.LogInfo("交易对元数据同步完成，币安共 %d 个交易对，配置的交易对中 %d 个不可用，%d 个恢复交易", len(infos), len(check.invalid), len(resumed))
+
+	// 不存在的交易对移出采集范围，下架或暂停交易的交易对停止采集，恢复交易的重新采集
+	dropUnknownSymbols(cfg, check.unknown)
+	retireSymbols(cfg, check.retired)
+	unretireSymbols(cfg, resumed)
+	return check.invalid, nil
+}
+
+// exchangeInfoComplete 返回count个交易对的响应是否完整：交易对数量不少于上次同步（previous个）的一半，
+// 币安偶尔返回部分列表，据此判断缺失会误停采集
+func exchangeInfoComplete(count, previous int) bool {
+	return count > 0 && count*2 >= previous
+}
+
+// symbolCheck 一次同步对配置的交易对的校验结果
+type symbolCheck struct {
+	invalid []string          // 本次不可用的交易对
+	unknown []string          // 从未同步到元数据且连续多次不在exchangeInfo中，移出采集范围
+	retired map[string]string // 交易对 -> 停止采集的原因（下架或币安状态）
+}
+
+// checkConfiguredSymbols 校验配置的交易对并保存本次返回的元数据。不在exchangeInfo中的交易对只在响应完整时计入连续缺失次数，
+// 连续 delistedAfterMissing 次后才处理：从未同步到元数据的（known返回false，通常是配置写错）移出采集范围，其余按下架停止采集
+func checkConfiguredSymbols(symbols []string, infos map[string]*db.SymbolInfo, complete bool,
+	known func(string) bool, save func(*db.SymbolInfo) error) symbolCheck {
+	check := symbolCheck{retired: make(map[string]string)}
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		info, exists := infos[symbol]
+		if !exists {
+			check.invalid = append(check.invalid, symbol)
+			if !complete {
+				continue
+			}
+			n := countMissingSymbol(symbol, true)
+			if n < delistedAfterMissing {
+				utils.LogWarning("交易对 %s 不在本次exchangeInfo中（连续 %d 次），连续 %d 次后停止采集", symbol, n, delistedAfterMissing)
+			} else if !known(symbol) {
+				check.unknown = append(check.unknown, symbol)
+			} else {
+				utils.LogError("交易对 %s 连续 %d 次不在币安exchangeInfo中，请检查 BINANCE_SYMBOLS 配置", symbol, n)
+				check.retired[symbol] = "DELISTED"
+			}
+			continue
+		}
+		countMissingSymbol(symbol, false)
+
+		if err := save(info); err != nil {
+			continue
+		}
+
+		if info.Status != "TRADING" {
+			utils.LogWarning("交易对 %s 当前状态为 %s，不是 TRADING", symbol, info.Status)
+			check.invalid = append(check.invalid, symbol)
+			check.retired[symbol] = info.Status
+		}
+	}
+	return check
+}
+
+// knownSymbol 交易对是否曾同步到元数据（symbols表中有记录），查询失败时按已知处理（停止采集而不是移出采集范围）
+func knownSymbol(symbol string) bool {
+	infos, err := db.GetSymbolInfos(symbol)
+	if err != nil {
+		return true
+	}
+	return len(infos) > 0
+}
+
+// countMissingSymbol 记录交易对是否不在本次exchangeInfo中，返回连续不在的次数，出现时清零
+func countMissingSymbol(symbol string, missing bool) int {
+	missingSymbolsMu.Lock()
+	defer missingSymbolsMu.Unlock()
+	if !missing {
+		delete(missingSymbols, symbol)
+		return 0
+	}
+	missingSymbols[symbol]++
+	return missingSymbols[symbol]
+}
+
+// GetExchangeSymbol 获取最近一次同步的交易对元数据
+func GetExchangeSymbol(symbol string) (*db.SymbolInfo, bool) {
+	exchangeSymbolsMu.RLock()
+	defer exchangeSymbolsMu.RUnlock()
+
+	info, ok := exchangeSymbols[strings.ToUpper(symbol)]
+	return info, ok
+}
+
+// AddExchangeInfoTask 添加每日同步交易对元数据的定时任务
+func AddExchangeInfoTask(cfg *config.Config) error {
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("exchange_info", cfg.Cron.ExchangeInfoSchedule, func() error {
+		_, err := SyncExchangeInfo(cfg)
+		if err != nil {
+			utils.LogError("定时同步交易对元数据失败: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("添加交易对元数据同步任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加交易对元数据同步任务，cron表达式: %s", cfg.Cron.ExchangeInfoSchedule)
+	return nil
+}
+
+// SymbolsResponse 交易对元数据查询结果
+type SymbolsResponse struct {
+	Symbols []db.SymbolInfo `json:"symbols"`
+	Count   int             `json:"count"`
+}
+
+// getSymbols 查询交易对元数据处理函数
+func getSymbols(c *gin.Context) {
+	symbols, err := db.GetSymbolInfos(strings.ToUpper(c.Query("symbol")))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	respondOK(c, SymbolsResponse{
+		Symbols: symbols,
+		Count:   len(symbols),
+	})
+}
+
+// SymbolRegistryResponse 交易对标识登记查询结果
+type SymbolRegistryResponse struct {
+	Symbols []db.SymbolIdentifier `json:"symbols"`
+	Count   int                   `json:"count"`
+}
+
+// getSymbolRegistry 查询各交易对在表名中使用的标识
+func getSymbolRegistry(c *gin.Context) {
+	symbols, err := db.GetSymbolRegistry()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	respondOK(c, SymbolRegistryResponse{
+		Symbols: symbols,
+		Count:   len(symbols),
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+func TestExchangeInfoComplete(t *testing.T) {
+	tests := []struct {
+		count, previous int
+		want            bool
+	}{
+		{0, 0, false},
+		{0, 2000, false},
+		{1, 0, true},
+		{2000, 2000, true},
+		{1000, 2000, true},
+		{999, 2000, false},
+	}
+	for _, tt := range tests {
+		if got := exchangeInfoComplete(tt.count, tt.previous); got != tt.want {
+			t.Errorf("exchangeInfoComplete(%d, %d) = %v, want %v", tt.count, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestCheckConfiguredSymbols(t *testing.T) {
+	symbols := []string{"btcusdt", "ETHUSDT", "TYPOUSDT", "OLDUSDT", "HALTUSDT"}
+	infos := map[string]*db.SymbolInfo{
+		"BTCUSDT":  {Symbol: "BTCUSDT", Status: "TRADING"},
+		"ETHUSDT":  {Symbol: "ETHUSDT", Status: "TRADING"},
+		"HALTUSDT": {Symbol: "HALTUSDT", Status: "BREAK"},
+	}
+	// OLDUSDT曾同步到元数据（已下架），TYPOUSDT从未存在
+	known := func(symbol string) bool { return symbol != "TYPOUSDT" }
+
+	tests := []struct {
+		name        string
+		complete    bool
+		wantUnknown []string
+		wantRetired map[string]string
+	}{
+		{"first complete sync only counts", true, nil, map[string]string{"HALTUSDT": "BREAK"}},
+		{"incomplete sync is not evidence", false, nil, map[string]string{"HALTUSDT": "BREAK"}},
+		{"second complete sync", true, nil, map[string]string{"HALTUSDT": "BREAK"}},
+		{"third complete sync acts", true, []string{"TYPOUSDT"}, map[string]string{"HALTUSDT": "BREAK", "OLDUSDT": "DELISTED"}},
+	}
+
+	resetMissing := func() {
+		missingSymbolsMu.Lock()
+		missingSymbols = make(map[string]int)
+		missingSymbolsMu.Unlock()
+	}
+	resetMissing()
+	t.Cleanup(resetMissing)
+
+	var saved []string
+	save := func(info *db.SymbolInfo) error {
+		saved = append(saved, info.Symbol)
+		return nil
+	}
+	for _, tt := range tests {
+		saved = nil
+		check := checkConfiguredSymbols(symbols, infos, tt.complete, known, save)
+		if want := []string{"TYPOUSDT", "OLDUSDT", "HALTUSDT"}; !reflect.DeepEqual(check.invalid, want) {
+			t.Fatalf("%s: invalid = %v, want %v", tt.name, check.invalid, want)
+		}
+		if !reflect.DeepEqual(check.unknown, tt.wantUnknown) {
+			t.Fatalf("%s: unknown = %v, want %v", tt.name, check.unknown, tt.wantUnknown)
+		}
+		if !reflect.DeepEqual(check.retired, tt.wantRetired) {
+			t.Fatalf("%s: retired = %v, want %v", tt.name, check.retired, tt.wantRetired)
+		}
+		if want := []string{"BTCUSDT", "ETHUSDT", "HALTUSDT"}; !reflect.DeepEqual(saved, want) {
+			t.Fatalf("%s: saved %v, want %v", tt.name, saved, want)
+		}
+	}
+
+	// 重新出现后缺失次数清零
+	infos["OLDUSDT"] = &db.SymbolInfo{Symbol: "OLDUSDT", Status: "TRADING"}
+	checkConfiguredSymbols(symbols, infos, true, known, save)
+	delete(infos, "OLDUSDT")
+	if check := checkConfiguredSymbols(symbols, infos, true, known, save); check.retired["OLDUSDT"] != "" {
+		t.Fatalf("OLDUSDT retired after reappearing: %v", check.retired)
+	}
+}
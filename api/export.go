@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 导出时每输出该条数的K线刷新一次响应
+const exportFlushRows = 1000
+
+// exportKlines 按时间升序流式导出K线，每行一个JSON对象（NDJSON），不受API_MAX_QUERY_LIMIT限制，
+// 逐行从数据库读取并输出，内存占用与导出的条数无关
+// 中途出错时最后一行为{"error": "..."}，客户端可据此判断数据不完整
+func exportKlines(c *gin.Context) {
+	symbol := c.Query("symbol")
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	var startTime, endTime int64
+	var limit int
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			badRequest(c, "无效的limit参数")
+			return
+		}
+	}
+
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+			c.Status(http.StatusOK)
+		}
+	}
+
+	total := 0
+	var writeErr error
+	err = db.StreamKlineRange(c.Request.Context(), symbol, interval, startTime, endTime, limit, func(k db.Candle) error {
+		start()
+		item := candleToKlineItem(k)
+		if writeErr = enc.Encode(&item); writeErr != nil {
+			return writeErr
+		}
+		total++
+		if total%exportFlushRows == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	switch {
+	case writeErr != nil || c.Request.Context().Err() != nil:
+		// 客户端已断开
+		logRequestInfo(c, "导出 %s %s 时客户端断开，已输出 %d 条", symbol, interval, total)
+		return
+	case err != nil && !started:
+		// 还未开始输出，可以返回正常的错误响应
+		internalError(c, err)
+		return
+	case err != nil:
+		logRequestError(c, "导出 %s %s 中断，已输出 %d 条: %v", symbol, interval, total, err)
+		enc.Encode(gin.H{"error": err.Error()})
+		return
+	}
+	start()
+	c.Writer.Flush()
+
+	utils.AddCounter("biupdata_export_klines_total", float64(total))
+	logRequestInfo(c, "导出 %s %s 共 %d 条K线", symbol, interval, total)
+}
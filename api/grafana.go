@@ -0,0 +1,309 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Grafana SimpleJSON数据源协议，数据源地址为 /api/v1/grafana
+// 协议规定了请求和响应格式，这组接口不使用统一响应结构
+// 查询目标的格式为 交易对:时间间隔:字段，如 BTCUSDT:5m:close，时间间隔为auto时按面板的时间精度自动选择
+
+const (
+	grafanaDefaultPoints = 1000  // 请求未指定maxDataPoints时返回的最多数据点
+	grafanaMaxPoints     = 10000 // 单个目标最多返回的数据点
+	grafanaMaxEvents     = 1000  // 单次最多返回的注释数量
+	grafanaAutoInterval  = "auto"
+)
+
+// 可查询的字段
+var grafanaFields = []string{"open", "high", "low", "close", "volume"}
+
+// grafanaRange 查询的时间范围
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaTarget 面板中的一个查询
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type"` // timeserie 或 table
+	Hide   bool   `json:"hide"`
+}
+
+// grafanaQueryRequest /query 请求体
+type grafanaQueryRequest struct {
+	Range         grafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int             `json:"maxDataPoints"`
+	Targets       []grafanaTarget `json:"targets"`
+}
+
+// grafanaTimeserie 时间序列结果，datapoints为[值, UTC毫秒]
+type grafanaTimeserie struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaColumn 表格的列
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// grafanaTable 表格结果
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// grafanaAnnotationRequest /annotations 请求体，annotation.query 为 交易对 或 交易对:分类，留空返回全部事件
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// grafanaAnnotation 注释，time为UTC毫秒
+type grafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+	Tags       []string    `json:"tags"`
+}
+
+// grafanaError 返回错误，Grafana在面板上显示message
+func grafanaError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"message": message})
+}
+
+// getGrafanaTest 测试数据源连接
+func getGrafanaTest(c *gin.Context) {
+	c.String(http.StatusOK, "OK")
+}
+
+// grafanaSearch 返回可查询的目标，按请求中的target过滤
+func grafanaSearch(c *gin.Context) {
+	if appConfig == nil {
+		grafanaError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	var req struct {
+		Target string `json:"target"`
+	}
+	// 请求体为空时返回全部目标
+	c.ShouldBindJSON(&req)
+	filter := strings.ToUpper(req.Target)
+
+	updateMutex.Lock()
+	symbols := appConfig.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+	targets := []string{}
+	for _, symbol := range symbols {
+		for _, interval := range append([]string{grafanaAutoInterval}, appConfig.Binance.IntervalsFor(symbol)...) {
+			for _, field := range grafanaFields {
+				target := symbol + ":" + interval + ":" + field
+				if filter == "" || strings.Contains(strings.ToUpper(target), filter) {
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	c.JSON(http.StatusOK, targets)
+}
+
+// grafanaQuery 按目标返回时间序列或表格
+func grafanaQuery(c *gin.Context) {
+	if appConfig == nil {
+		grafanaError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	var req grafanaQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		grafanaError(c, http.StatusBadRequest, "无效的请求体: "+err.Error())
+		return
+	}
+	if req.Range.To.Before(req.Range.From) {
+		grafanaError(c, http.StatusBadRequest, "无效的时间范围")
+		return
+	}
+
+	limit := req.MaxDataPoints
+	if limit <= 0 {
+		limit = grafanaDefaultPoints
+	}
+	if limit > grafanaMaxPoints {
+		limit = grafanaMaxPoints
+	}
+
+	results := []interface{}{}
+	for _, t := range req.Targets {
+		if t.Hide || t.Target == "" {
+			continue
+		}
+
+		symbol, interval, field, err := parseGrafanaTarget(t.Target, req.IntervalMs)
+		if err != nil {
+			grafanaError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		series, err := querySeries(symbol, interval, req.Range.From.UnixMilli(), req.Range.To.UnixMilli(), limit)
+		if errors.Is(err, db.ErrQueryTimeout) {
+			grafanaError(c, http.StatusGatewayTimeout, "数据库查询超时，请缩小查询范围后重试")
+			return
+		}
+		if err != nil {
+			grafanaError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if t.Type == "table" {
+			results = append(results, grafanaOHLCVTable(series))
+		} else {
+			results = append(results, grafanaSeries(t.Target, field, series))
+		}
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// parseGrafanaTarget 解析 交易对:时间间隔:字段，字段省略时为close
+func parseGrafanaTarget(target string, intervalMs int64) (string, string, string, error) {
+	parts := strings.Split(target, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", "", "", fmt.Errorf("无效的查询目标 %s，格式应为 交易对:时间间隔:字段，如 BTCUSDT:5m:close", target)
+	}
+
+	symbol := strings.ToUpper(parts[0])
+	if !udfSymbolConfigured(symbol) {
+		return "", "", "", fmt.Errorf("交易对 %s 不在采集范围内", symbol)
+	}
+
+	interval := parts[1]
+	if interval == grafanaAutoInterval {
+		interval = grafanaAutoSelect(symbol, intervalMs)
+	} else if !containsSymbol(appConfig.Binance.IntervalsFor(symbol), interval) {
+		return "", "", "", fmt.Errorf("时间间隔 %s 不在采集范围内", interval)
+	}
+
+	field := "close"
+	if len(parts) == 3 {
+		field = strings.ToLower(parts[2])
+		if !containsSymbol(grafanaFields, field) {
+			return "", "", "", fmt.Errorf("无效的字段 %s，可选值: %s", parts[2], strings.Join(grafanaFields, ", "))
+		}
+	}
+	return symbol, interval, field, nil
+}
+
+// grafanaAutoSelect 在交易对采集的时间间隔中选择不超过面板时间精度的最大时间间隔，都超过时使用最小的时间间隔
+func grafanaAutoSelect(symbol string, intervalMs int64) string {
+	intervals := append([]string{}, appConfig.Binance.IntervalsFor(symbol)...)
+	sort.SliceStable(intervals, func(i, j int) bool {
+		return getIntervalMilliseconds(intervals[i]) < getIntervalMilliseconds(intervals[j])
+	})
+
+	selected := intervals[0]
+	for _, interval := range intervals {
+		if getIntervalMilliseconds(interval) <= intervalMs {
+			selected = interval
+		}
+	}
+	return selected
+}
+
+// grafanaSeries 提取一个字段的时间序列
+func grafanaSeries(target, field string, series []ohlcv) grafanaTimeserie {
+	points := make([][2]float64, len(series))
+	for i, k := range series {
+		var v float64
+		switch field {
+		case "open":
+			v = k.Open
+		case "high":
+			v = k.High
+		case "low":
+			v = k.Low
+		case "volume":
+			v = k.Volume
+		default:
+			v = k.Close
+		}
+		points[i] = [2]float64{v, float64(utils.StoredTimestampToUTC(k.Timestamp))}
+	}
+	return grafanaTimeserie{Target: target, Datapoints: points}
+}
+
+// grafanaOHLCVTable 以表格返回完整的K线，可用于Candlestick面板
+func grafanaOHLCVTable(series []ohlcv) grafanaTable {
+	table := grafanaTable{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{Text: "time", Type: "time"},
+			{Text: "open", Type: "number"},
+			{Text: "high", Type: "number"},
+			{Text: "low", Type: "number"},
+			{Text: "close", Type: "number"},
+			{Text: "volume", Type: "number"},
+		},
+		Rows: make([][]interface{}, len(series)),
+	}
+	for i, k := range series {
+		table.Rows[i] = []interface{}{utils.StoredTimestampToUTC(k.Timestamp), k.Open, k.High, k.Low, k.Close, k.Volume}
+	}
+	return table
+}
+
+// grafanaAnnotations 以市场事件作为注释
+func grafanaAnnotations(c *gin.Context) {
+	var req grafanaAnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		grafanaError(c, http.StatusBadRequest, "无效的请求体: "+err.Error())
+		return
+	}
+
+	var symbol, category string
+	if q := strings.TrimSpace(req.Annotation.Query); q != "" {
+		symbol, category, _ = strings.Cut(q, ":")
+		symbol = strings.ToUpper(symbol)
+		category = strings.ToLower(category)
+	}
+
+	events, err := db.ListEvents(symbol, req.Range.From.UnixMilli(), req.Range.To.UnixMilli(), category, grafanaMaxEvents)
+	if err != nil {
+		grafanaError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]grafanaAnnotation, len(events))
+	for i, e := range events {
+		tags := []string{e.Category}
+		if e.Symbol != "" {
+			tags = append(tags, e.Symbol)
+		}
+		result[i] = grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       e.Timestamp,
+			Title:      e.Title,
+			Text:       e.Description,
+			Tags:       tags,
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
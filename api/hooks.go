@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"plugin"
+	"sort"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// CandleHook K线处理流程的扩展点，用于附加自定义的加工（如计算指标写入其他表）或输出（如转发到自定义存储）
+type CandleHook interface {
+	Name() string
+	// OnCandleFetched 从币安获取一页K线后、写入存储之前调用，可以修改或过滤K线，返回的K线依次交给下一个扩展；
+	// 返回错误时整页不写入，按更新失败处理
+	OnCandleFetched(symbol, interval string, klines []db.Candle) ([]db.Candle, error)
+	// OnCandleStored 一页K线写入存储成功后调用，klines为实际写入的K线（UTC开盘时间），返回的错误只记录日志
+	OnCandleStored(symbol, interval string, klines []db.Candle) error
+}
+
+var (
+	hookMutex     sync.RWMutex
+	hookFactories = make(map[string]func(cfg *config.Config) (CandleHook, error))
+	addedHooks    []CandleHook // AddHook启用的扩展
+	hooks         []CandleHook // 配置启用的扩展在前，AddHook启用的在后
+)
+
+// RegisterHook 登记一个可以在配置中按名称启用的扩展，一般在init中调用；Go插件在加载时同样通过它登记
+func RegisterHook(name string, factory func(cfg *config.Config) (CandleHook, error)) {
+	hookMutex.Lock()
+	defer hookMutex.Unlock()
+	hookFactories[name] = factory
+}
+
+// AddHook 直接启用一个扩展，供嵌入biupdata的程序使用，需在启动定时任务之前调用
+func AddHook(h CandleHook) {
+	hookMutex.Lock()
+	defer hookMutex.Unlock()
+	addedHooks = append(addedHooks, h)
+	hooks = append(hooks, h)
+}
+
+// ResetHooks 移除AddHook启用的扩展
+func ResetHooks() {
+	hookMutex.Lock()
+	defer hookMutex.Unlock()
+	hooks = append([]CandleHook(nil), hooks[:len(hooks)-len(addedHooks)]...)
+	addedHooks = nil
+}
+
+// RegisteredHooks 返回已登记的扩展名称
+func RegisteredHooks() []string {
+	hookMutex.RLock()
+	defer hookMutex.RUnlock()
+	names := make([]string, 0, len(hookFactories))
+	for name := range hookFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initHooks 加载配置的Go插件，再按配置的顺序启用扩展，替换之前按配置启用的扩展；插件在init中调用RegisterHook登记
+func initHooks(cfg *config.Config) error {
+	var configured []CandleHook
+	// 脚本在其他扩展之前执行，被脚本丢弃的K线不会交给其他扩展
+	scripts, err := initScripts(&cfg.Scripts)
+	if err != nil {
+		return err
+	}
+	if scripts != nil {
+		configured = append(configured, scripts)
+	}
+
+	for _, path := range cfg.Hooks.Plugins {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("加载插件 %s 失败: %v", path, err)
+		}
+		utils.LogInfo("已加载插件: %s", path)
+	}
+
+	for _, name := range cfg.Hooks.Enabled {
+		hookMutex.RLock()
+		factory, ok := hookFactories[name]
+		hookMutex.RUnlock()
+		if !ok {
+			return fmt.Errorf("未知的扩展: %s（已登记: %v）", name, RegisteredHooks())
+		}
+		h, err := factory(cfg)
+		if err != nil {
+			return fmt.Errorf("初始化扩展 %s 失败: %v", name, err)
+		}
+		configured = append(configured, h)
+		utils.LogInfo("已启用扩展: %s", name)
+	}
+
+	// 模拟持仓和告警排在配置启用的扩展之后，估值和求值时K线已写入
+	if paper := initPaper(&cfg.Paper); paper != nil {
+		configured = append(configured, paper)
+		utils.LogInfo("已启用模拟持仓")
+	}
+	if alerts := initAlerts(&cfg.Alerts); alerts != nil {
+		configured = append(configured, alerts)
+		utils.LogInfo("已启用行情告警")
+	}
+
+	hookMutex.Lock()
+	hooks = append(configured, addedHooks...)
+	hookMutex.Unlock()
+	return nil
+}
+
+// activeHooks 返回已启用的扩展
+func activeHooks() []CandleHook {
+	hookMutex.RLock()
+	defer hookMutex.RUnlock()
+	return hooks
+}
+
+// runFetchedHooks 依次把一页K线交给各扩展加工，扩展修改的是副本，调用方的K线保持不变
+func runFetchedHooks(hooks []CandleHook, symbol, interval string, klines []db.Candle) ([]db.Candle, error) {
+	if len(hooks) == 0 {
+		return klines, nil
+	}
+	klines = append([]db.Candle(nil), klines...)
+	for _, h := range hooks {
+		var err error
+		if klines, err = h.OnCandleFetched(symbol, interval, klines); err != nil {
+			utils.IncCounter(utils.MetricName("biupdata_hook_failures_total", "hook", h.Name()))
+			return nil, fmt.Errorf("扩展 %s 处理 %s %s 失败: %v", h.Name(), symbol, interval, err)
+		}
+	}
+	return klines, nil
+}
+
+// runStoredHooks 通知各扩展K线已写入，失败只记录日志，不影响已完成的写入
+func runStoredHooks(hooks []CandleHook, symbol, interval string, klines []db.Candle) {
+	if len(klines) == 0 {
+		return
+	}
+	for _, h := range hooks {
+		if err := h.OnCandleStored(symbol, interval, klines); err != nil {
+			utils.IncCounter(utils.MetricName("biupdata_hook_failures_total", "hook", h.Name()))
+			utils.LogError("扩展 %s 处理已写入的 %s %s K线失败: %v", h.Name(), symbol, interval, err)
+		}
+	}
+}
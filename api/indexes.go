@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/gin-gonic/gin"
+)
+
+// IndexesResponse K线表索引检查结果
+type IndexesResponse struct {
+	Tables  []db.IndexReport `json:"tables"`
+	Count   int              `json:"count"`
+	Missing int              `json:"missing"` // 缺少索引的表数量
+	Failing int              `json:"failing"` // 索引缺失或执行计划不符合预期的表数量
+}
+
+// getIndexes 检查各K线表的索引，并对示例查询执行EXPLAIN，可按symbol、interval过滤
+func getIndexes(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+	if interval != "" && !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	series, err := db.ListKlineSeries()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	resp := IndexesResponse{Tables: []db.IndexReport{}}
+	for _, s := range series {
+		if (symbol != "" && s.Symbol != symbol) || (interval != "" && !strings.EqualFold(s.Interval, interval)) {
+			continue
+		}
+		report := db.CheckKlineIndexes(s.Symbol, s.Interval)
+		if len(report.Missing) > 0 {
+			resp.Missing++
+		}
+		if !report.OK {
+			resp.Failing++
+		}
+		resp.Tables = append(resp.Tables, report)
+	}
+	resp.Count = len(resp.Tables)
+
+	logRequestInfo(c, "检查了 %d 个K线表的索引，缺少索引 %d 个，执行计划不符合预期 %d 个", resp.Count, resp.Missing, resp.Failing)
+	respondOK(c, resp)
+}
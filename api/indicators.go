@@ -0,0 +1,411 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// indicatorSpec 指标请求参数，如 sma:20
+type indicatorSpec struct {
+	Name   string
+	Period int
+}
+
+// 各指标的默认周期
+var defaultIndicatorPeriods = map[string]int{
+	"sma":  20,
+	"ema":  20,
+	"rsi":  14,
+	"macd": 26,
+
+	"vwap":       20,
+	"volatility": 20,
+	"atr":        14,
+}
+
+// parseIndicatorSpecs 解析形如 sma:20,ema:50,rsi:14,macd 的指标列表
+func parseIndicatorSpecs(raw string) ([]indicatorSpec, error) {
+	var specs []indicatorSpec
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(strings.ToLower(item))
+		if item == "" {
+			continue
+		}
+
+		name, periodStr, hasPeriod := strings.Cut(item, ":")
+		period, known := defaultIndicatorPeriods[name]
+		if !known {
+			return nil, fmt.Errorf("不支持的指标: %s", name)
+		}
+
+		if hasPeriod && name != "macd" {
+			p, err := strconv.Atoi(periodStr)
+			if err != nil || p <= 0 || p > 500 {
+				return nil, fmt.Errorf("无效的指标周期: %s", item)
+			}
+			period = p
+		}
+
+		specs = append(specs, indicatorSpec{Name: name, Period: period})
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("缺少必要参数: indicators")
+	}
+	return specs, nil
+}
+
+// computeSMA 简单移动平均，前period-1个值为NaN
+func computeSMA(values []float64, period int) []float64 {
+	result := make([]float64, len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			result[i] = sum / float64(period)
+		} else {
+			result[i] = math.NaN()
+		}
+	}
+	return result
+}
+
+// computeEMA 指数移动平均，以前period个值的SMA作为初始值
+func computeEMA(values []float64, period int) []float64 {
+	result := make([]float64, len(values))
+	k := 2.0 / float64(period+1)
+	prev := math.NaN()
+
+	for i, v := range values {
+		switch {
+		case math.IsNaN(v):
+			result[i] = math.NaN()
+			continue
+		case math.IsNaN(prev):
+			// 找到足够的有效值后用SMA起步
+			start := i - period + 1
+			if start < 0 || math.IsNaN(values[start]) {
+				result[i] = math.NaN()
+				continue
+			}
+			sum := 0.0
+			for _, x := range values[start : i+1] {
+				sum += x
+			}
+			prev = sum / float64(period)
+		default:
+			prev = (v-prev)*k + prev
+		}
+		result[i] = prev
+	}
+	return result
+}
+
+// computeRSI 相对强弱指数（Wilder平滑）
+func computeRSI(values []float64, period int) []float64 {
+	result := make([]float64, len(values))
+	for i := range result {
+		result[i] = math.NaN()
+	}
+	if len(values) <= period {
+		return result
+	}
+
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		change := values[i] - values[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	avgGain := gain / float64(period)
+	avgLoss := loss / float64(period)
+	result[period] = rsiValue(avgGain, avgLoss)
+
+	for i := period + 1; i < len(values); i++ {
+		change := values[i] - values[i-1]
+		g, l := 0.0, 0.0
+		if change > 0 {
+			g = change
+		} else {
+			l = -change
+		}
+		avgGain = (avgGain*float64(period-1) + g) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + l) / float64(period)
+		result[i] = rsiValue(avgGain, avgLoss)
+	}
+	return result
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		return 100
+	}
+	rs := avgGain / avgLoss
+	return 100 - 100/(1+rs)
+}
+
+// computeMACD 计算MACD(12,26,9)，返回MACD线、信号线和柱状图
+func computeMACD(values []float64) ([]float64, []float64, []float64) {
+	fast := computeEMA(values, 12)
+	slow := computeEMA(values, 26)
+
+	macd := make([]float64, len(values))
+	for i := range values {
+		macd[i] = fast[i] - slow[i]
+	}
+
+	signal := computeEMA(macd, 9)
+	hist := make([]float64, len(values))
+	for i := range values {
+		hist[i] = macd[i] - signal[i]
+	}
+	return macd, signal, hist
+}
+
+// computeVWAP 滚动成交量加权均价，单根K线的成交均价以典型价格(H+L+C)/3近似
+func computeVWAP(series []ohlcv, period int) []float64 {
+	result := make([]float64, len(series))
+	var pv, vol float64
+	for i, c := range series {
+		pv += (c.High + c.Low + c.Close) / 3 * c.Volume
+		vol += c.Volume
+		if i >= period {
+			old := series[i-period]
+			pv -= (old.High + old.Low + old.Close) / 3 * old.Volume
+			vol -= old.Volume
+		}
+		if i < period-1 || vol <= 0 {
+			result[i] = math.NaN()
+			continue
+		}
+		result[i] = pv / vol
+	}
+	return result
+}
+
+// computeVolatility 滚动波动率，即最近period个收益率的样本标准差
+func computeVolatility(values []float64, period int) []float64 {
+	result := make([]float64, len(values))
+	for i := range values {
+		if i < period || period < 2 {
+			result[i] = math.NaN()
+			continue
+		}
+
+		returns := make([]float64, 0, period)
+		for j := i - period + 1; j <= i; j++ {
+			if values[j-1] == 0 {
+				continue
+			}
+			returns = append(returns, values[j]/values[j-1]-1)
+		}
+		result[i] = stddev(returns)
+	}
+	return result
+}
+
+// stddev 样本标准差，样本不足两个时返回NaN
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return math.NaN()
+	}
+
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// computeATR 平均真实波幅（Wilder平滑）
+func computeATR(series []ohlcv, period int) []float64 {
+	result := make([]float64, len(series))
+	for i := range result {
+		result[i] = math.NaN()
+	}
+	if len(series) <= period {
+		return result
+	}
+
+	trueRange := func(i int) float64 {
+		c, prevClose := series[i], series[i-1].Close
+		return math.Max(c.High-c.Low, math.Max(math.Abs(c.High-prevClose), math.Abs(c.Low-prevClose)))
+	}
+
+	sum := 0.0
+	for i := 1; i <= period; i++ {
+		sum += trueRange(i)
+	}
+	atr := sum / float64(period)
+	result[period] = atr
+
+	for i := period + 1; i < len(series); i++ {
+		atr = (atr*float64(period-1) + trueRange(i)) / float64(period)
+		result[i] = atr
+	}
+	return result
+}
+
+// computeIndicators 对按时间升序的序列计算各指标，键为“名称:周期”，macd展开为macd、macd_signal、macd_hist
+func computeIndicators(series []ohlcv, specs []indicatorSpec) map[string][]float64 {
+	closes := seriesCloses(series)
+	results := make(map[string][]float64)
+	for _, spec := range specs {
+		key := indicatorKey(spec)
+		switch spec.Name {
+		case "sma":
+			results[key] = computeSMA(closes, spec.Period)
+		case "ema":
+			results[key] = computeEMA(closes, spec.Period)
+		case "rsi":
+			results[key] = computeRSI(closes, spec.Period)
+		case "vwap":
+			results[key] = computeVWAP(series, spec.Period)
+		case "volatility":
+			results[key] = computeVolatility(closes, spec.Period)
+		case "atr":
+			results[key] = computeATR(series, spec.Period)
+		case "macd":
+			macd, signal, hist := computeMACD(closes)
+			results["macd"] = macd
+			results["macd_signal"] = signal
+			results["macd_hist"] = hist
+		}
+	}
+	return results
+}
+
+// indicatorKey 指标结果的键
+func indicatorKey(spec indicatorSpec) string {
+	if spec.Name == "macd" {
+		return "macd"
+	}
+	return fmt.Sprintf("%s:%d", spec.Name, spec.Period)
+}
+
+// toNullable 将NaN转换为nil，便于JSON输出null
+func toNullable(values []float64) []*float64 {
+	result := make([]*float64, len(values))
+	for i := range values {
+		if math.IsNaN(values[i]) || math.IsInf(values[i], 0) {
+			continue
+		}
+		v := values[i]
+		result[i] = &v
+	}
+	return result
+}
+
+// IndicatorsResponse 技术指标计算结果，各指标序列与timestamps一一对齐，数据不足处为null
+type IndicatorsResponse struct {
+	Symbol     string                `json:"symbol"`
+	Interval   string                `json:"interval"`
+	Timestamps []int64               `json:"timestamps"`
+	Close      []string              `json:"close"`
+	Indicators map[string][]*float64 `json:"indicators"`
+	Count      int                   `json:"count"`
+}
+
+// getIndicators 计算技术指标处理函数
+func getIndicators(c *gin.Context) {
+	symbol := c.Query("symbol")
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	specs, err := parseIndicatorSpecs(c.Query("indicators"))
+	if err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	var startTime, endTime int64
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+
+	// 多取一段数据用于指标预热，保证返回区间内的值都已收敛
+	warmup := 0
+	for _, spec := range specs {
+		if w := spec.Period * 3; w > warmup {
+			warmup = w
+		}
+	}
+
+	series, err := querySeries(symbol, interval, 0, endTime, limit+warmup)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	closes := seriesCloses(series)
+	results := computeIndicators(series, specs)
+
+	// 截取请求的区间，序列的时间戳是数据库存储口径，换算为UTC后与start_time比较
+	from := 0
+	for from < len(series) && startTime > 0 && utils.StoredTimestampToUTC(series[from].Timestamp) < startTime {
+		from++
+	}
+	if len(series)-from > limit {
+		from = len(series) - limit
+	}
+
+	indicators := make(map[string][]*float64, len(results))
+	for key, values := range results {
+		indicators[key] = toNullable(values[from:])
+	}
+
+	closeStrings := make([]string, 0, len(series)-from)
+	for _, v := range closes[from:] {
+		closeStrings = append(closeStrings, formatFloat(v))
+	}
+
+	respondOK(c, IndicatorsResponse{
+		Symbol:     symbol,
+		Interval:   interval,
+		Timestamps: seriesTimestamps(series[from:]),
+		Close:      closeStrings,
+		Indicators: indicators,
+		Count:      len(series) - from,
+	})
+}
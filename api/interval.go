@@ -0,0 +1,108 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 时间间隔单位对应的毫秒数，1M（自然月）长度不固定，单独处理
+var intervalUnitMilliseconds = map[byte]int64{
+	's': 1000,
+	'm': 60 * 1000,
+	'h': 60 * 60 * 1000,
+	'd': 24 * 60 * 60 * 1000,
+	'w': 7 * 24 * 60 * 60 * 1000,
+}
+
+// 1970-01-01是星期四，币安周K线从星期一00:00(UTC)开始，需要偏移4天对齐
+const weekAlignOffset = 4 * 24 * 60 * 60 * 1000
+
+// 获取时间间隔对应的毫秒数，1M按30天估算，仅用于频率和数量估计
+func getIntervalMilliseconds(interval string) int64 {
+	if interval == "1M" {
+		return 30 * 24 * 60 * 60 * 1000
+	}
+
+	ms, ok := parseIntervalMilliseconds(interval)
+	if !ok {
+		// 配置加载时已校验时间间隔，这里只可能是调用方传入了未校验的值
+		utils.LogWarning("无法解析时间间隔 %s，按1小时处理", interval)
+		return 60 * 60 * 1000
+	}
+	return ms
+}
+
+// parseIntervalMilliseconds 按“数字+单位”解析固定长度时间间隔，如 15m、4h、1w
+func parseIntervalMilliseconds(interval string) (int64, bool) {
+	if len(interval) < 2 {
+		return 0, false
+	}
+
+	unit, ok := intervalUnitMilliseconds[interval[len(interval)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return int64(n) * unit, true
+}
+
+// intervalStart 获取UTC时间戳（毫秒）所在K线周期的开始时间，与币安的周期边界一致
+func intervalStart(interval string, utcMs int64) int64 {
+	switch interval {
+	case "1M":
+		t := time.UnixMilli(utcMs).UTC()
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	case "1w":
+		ms := getIntervalMilliseconds("1w")
+		return utcMs - floorMod(utcMs-weekAlignOffset, ms)
+	default:
+		ms := getIntervalMilliseconds(interval)
+		return utcMs - floorMod(utcMs, ms)
+	}
+}
+
+// nextIntervalStart 获取下一个K线周期的开始时间
+func nextIntervalStart(interval string, utcMs int64) int64 {
+	start := intervalStart(interval, utcMs)
+	if interval == "1M" {
+		return time.UnixMilli(start).UTC().AddDate(0, 1, 0).UnixMilli()
+	}
+	return start + getIntervalMilliseconds(interval)
+}
+
+// advanceIntervals 从utcMs开始向后推进n个K线周期
+func advanceIntervals(interval string, utcMs int64, n int) int64 {
+	if interval == "1M" {
+		return time.UnixMilli(utcMs).UTC().AddDate(0, n, 0).UnixMilli()
+	}
+	return utcMs + int64(n)*getIntervalMilliseconds(interval)
+}
+
+// countIntervalBars 计算[fromMs, toMs)区间内包含的K线数量
+func countIntervalBars(interval string, fromMs, toMs int64) int64 {
+	if toMs <= fromMs {
+		return 0
+	}
+
+	if interval == "1M" {
+		from := time.UnixMilli(fromMs).UTC()
+		to := time.UnixMilli(toMs).UTC()
+		return int64((to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1)
+	}
+	return (toMs - fromMs) / getIntervalMilliseconds(interval)
+}
+
+// floorMod 向下取模，保证负数时间戳也能正确对齐
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	haEnabled  bool
+	isLeader   bool
+	leaderMu   sync.Mutex
+	leaderStop chan struct{}
+)
+
+// StartLeaderElection 启动主节点选举，只有主节点运行定时任务和低延迟模式的WebSocket订阅、接受手动更新，其他实例只提供查询
+func StartLeaderElection(cfg *config.HAConfig) {
+	haEnabled = true
+	leaderStop = make(chan struct{})
+
+	interval := time.Duration(cfg.CheckInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			utils.Safe("leader_election", func() { checkLeadership(cfg.LockName) })
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(leaderStop)
+
+	utils.LogInfo("已启用主节点选举，锁名称: %s，检查间隔: %v", cfg.LockName, interval)
+}
+
+// StopLeaderElection 停止选举并主动释放主节点锁，便于其他实例尽快接管
+func StopLeaderElection(cfg *config.HAConfig) {
+	if leaderStop == nil {
+		return
+	}
+	close(leaderStop)
+	leaderStop = nil
+
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	if isLeader {
+		StopScheduler()
+		StopSLAStreams()
+		db.ReleaseLeaderLock(cfg.LockName)
+		isLeader = false
+		utils.LogInfo("已释放主节点锁")
+	}
+}
+
+// IsLeader 当前实例是否为主节点，未启用高可用时总是返回true
+func IsLeader() bool {
+	if !haEnabled {
+		return true
+	}
+
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+	return isLeader
+}
+
+// requireLeader 从节点拒绝会写入K线的请求并返回409，避免多个实例同时写入
+func requireLeader(c *gin.Context) bool {
+	if IsLeader() {
+		return true
+	}
+	respondError(c, http.StatusConflict, CodeConflict, "当前实例不是主节点，请向主节点发送该请求")
+	return false
+}
+
+// checkLeadership 主节点续约或从节点尝试接管
+func checkLeadership(lockName string) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	if isLeader {
+		if err := db.CheckLeaderLock(lockName); err != nil {
+			utils.LogError("主节点锁丢失: %v，停止定时任务和WebSocket订阅并转为从节点", err)
+			isLeader = false
+			StopScheduler()
+			StopSLAStreams()
+		}
+		return
+	}
+
+	acquired, err := db.TryAcquireLeaderLock(lockName)
+	if err != nil {
+		utils.LogWarning("竞选主节点失败: %v", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	isLeader = true
+	if appConfig != nil {
+		StartSLAStreams(appConfig)
+	}
+
+	// 读取最新的期望状态，其他实例上的手动停止和暂停同样生效
+	if err := RestoreSchedulerState(); err != nil {
+		utils.LogWarning("恢复定时任务状态失败: %v", err)
+	}
+	if !SchedulerEnabled() {
+		utils.LogInfo("当前实例已成为主节点，定时任务已被手动停止，保持停止状态")
+		return
+	}
+	utils.LogInfo("当前实例已成为主节点，启动定时任务")
+	StartSchedulerWithCatchup()
+}
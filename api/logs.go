@@ -0,0 +1,191 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 日志查询单次返回的最大条数
+const maxLogsLimit = 10000
+
+// 读取日志文件时单次返回的最大字节数和行数
+const (
+	maxLogChunkSize = 1024 * 1024
+	maxLogTailLines = 10000
+)
+
+// LogsResponse 内存中的最近日志
+type LogsResponse struct {
+	Logs    []string `json:"logs"`     // 按时间顺序
+	Total   int      `json:"total"`    // 匹配条件的全部记录数
+	Offset  int      `json:"offset"`   // 跳过的最新记录数
+	HasMore bool     `json:"has_more"` // 是否还有更早的匹配记录
+}
+
+// LogEntriesResponse 结构化的最近日志（format=json）
+type LogEntriesResponse struct {
+	Entries []utils.LogEntry `json:"entries"` // 按时间顺序
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	HasMore bool             `json:"has_more"`
+}
+
+// getLogs 按级别、内容和时间范围查询内存中的最近日志，返回最新的limit条，offset用于向更早的记录翻页；
+// format=json时返回带级别、时间和字段的结构化记录，默认返回文本行
+func getLogs(c *gin.Context) {
+	q := utils.LogQuery{Contains: c.Query("q")}
+
+	format := c.DefaultQuery("format", "text")
+	if format != "text" && format != "json" {
+		badRequest(c, "无效的format参数，可选 text、json")
+		return
+	}
+
+	if v := c.Query("level"); v != "" {
+		if !utils.IsLogLevel(v) {
+			badRequest(c, "无效的level参数，可选 info、warning、error")
+			return
+		}
+		q.Level = v
+	}
+	if v := c.Query("regex"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			badRequest(c, "无效的regex参数: "+err.Error())
+			return
+		}
+		q.Pattern = re
+	}
+	if v := c.Query("start_time"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+		q.Start = time.UnixMilli(ms)
+	}
+	if v := c.Query("end_time"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+		q.End = time.UnixMilli(ms)
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && q.End.Before(q.Start) {
+		badRequest(c, "end_time 不能早于 start_time")
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit <= 0 || limit > maxLogsLimit {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		badRequest(c, "无效的offset参数")
+		return
+	}
+	q.Limit = limit
+	q.Offset = offset
+
+	entries, total := utils.SearchLogs(q)
+	hasMore := offset+len(entries) < total
+	if format == "json" {
+		respondOK(c, LogEntriesResponse{Entries: entries, Total: total, Offset: offset, HasMore: hasMore})
+		return
+	}
+
+	logs := make([]string, len(entries))
+	for i, entry := range entries {
+		logs[i] = entry.String()
+	}
+	respondOK(c, LogsResponse{Logs: logs, Total: total, Offset: offset, HasMore: hasMore})
+}
+
+// LogFilesResponse 日志目录中的日志文件
+type LogFilesResponse struct {
+	Files []utils.LogFile `json:"files"`
+	Count int             `json:"count"`
+}
+
+// getLogFiles 列出当前日志文件和lumberjack轮转出的旧文件，程序重启或崩溃后可以查看之前的日志
+func getLogFiles(c *gin.Context) {
+	files, err := utils.ListLogFiles()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, LogFilesResponse{Files: files, Count: len(files)})
+}
+
+// getLogFile 读取单个日志文件：tail返回最后若干行，否则返回从offset开始的length个字节（offset为负数时从末尾倒数）；
+// raw=1时返回文件原始内容，支持Range请求头
+func getLogFile(c *gin.Context) {
+	name := c.Param("name")
+	if c.Query("raw") == "1" {
+		path, err := utils.LogFilePath(name)
+		if err != nil {
+			respondError(c, http.StatusNotFound, CodeNotFound, "日志文件不存在: "+name)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		if strings.HasSuffix(name, ".gz") {
+			c.Header("Content-Type", "application/gzip")
+		} else {
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+		}
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+		return
+	}
+
+	var chunk *utils.LogChunk
+	var err error
+	if v := c.Query("tail"); v != "" {
+		lines, perr := strconv.Atoi(v)
+		if perr != nil || lines <= 0 || lines > maxLogTailLines {
+			badRequest(c, "无效的tail参数")
+			return
+		}
+		chunk, err = utils.TailLogFile(name, lines)
+	} else {
+		offset, perr := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+		if perr != nil {
+			badRequest(c, "无效的offset参数")
+			return
+		}
+		length, perr := strconv.Atoi(c.DefaultQuery("length", "65536"))
+		if perr != nil || length <= 0 || length > maxLogChunkSize {
+			badRequest(c, "无效的length参数")
+			return
+		}
+		chunk, err = utils.ReadLogFile(name, offset, length)
+	}
+	if err == utils.ErrLogFileNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "日志文件不存在: "+name)
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, chunk)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 检查维护窗口开始和结束的间隔
+const maintenanceCheckInterval = 15 * time.Second
+
+var (
+	maintenanceOnce   sync.Once
+	maintenanceActive bool      // 上一次检查时是否在维护窗口内，由维护窗口检查协程维护
+	maintenanceSince  time.Time // 本次维护窗口的开始时间
+	maintenanceMutex  sync.Mutex
+)
+
+// MaintenanceStatus 维护窗口状态
+type MaintenanceStatus struct {
+	Windows     []string `json:"windows"`
+	Active      bool     `json:"active"`
+	ActiveSince string   `json:"active_since,omitempty"`
+	NextStart   string   `json:"next_start,omitempty"`
+	NextEnd     string   `json:"next_end,omitempty"` // 当前或下一个维护窗口的结束时间
+	MissedTasks []string `json:"missed_tasks"`       // 窗口内错过运行、结束后补跑的任务
+}
+
+// maintenanceWindows 配置的维护窗口
+func maintenanceWindows() []config.MaintenanceWindow {
+	if appConfig == nil {
+		return nil
+	}
+	return appConfig.Cron.MaintenanceWindows
+}
+
+// inMaintenance 当前是否在维护窗口内，窗口内定时任务暂停写入
+func inMaintenance() bool {
+	now := utils.GetShanghaiNow()
+	for _, w := range maintenanceWindows() {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// startMaintenanceWatcher 配置了维护窗口时启动后台检查：进入和离开窗口时记录日志，离开后补跑窗口内错过的任务
+func startMaintenanceWatcher() {
+	if len(maintenanceWindows()) == 0 {
+		return
+	}
+	maintenanceOnce.Do(func() {
+		go func() {
+			defer utils.Recover("maintenance_watcher", nil)
+			for {
+				checkMaintenance()
+				time.Sleep(maintenanceCheckInterval)
+			}
+		}()
+	})
+}
+
+// checkMaintenance 检查是否进入或离开维护窗口
+func checkMaintenance() {
+	active := inMaintenance()
+
+	maintenanceMutex.Lock()
+	changed := active != maintenanceActive
+	maintenanceActive = active
+	if changed && active {
+		maintenanceSince = time.Now()
+	}
+	maintenanceMutex.Unlock()
+
+	if active {
+		utils.SetGauge("biupdata_maintenance_active", 1)
+	} else {
+		utils.SetGauge("biupdata_maintenance_active", 0)
+	}
+	if !changed {
+		return
+	}
+	if active {
+		utils.LogInfo("进入维护窗口，暂停定时任务")
+		return
+	}
+
+	missed := missedTasks()
+	utils.LogInfo("维护窗口结束，补跑错过的定时任务: %v", taskNames(missed))
+	if !IsSchedulerRunning() {
+		return
+	}
+	// 更新任务按同步进度增量获取，窗口内错过的K线在补跑时一并写入
+	for _, task := range missed {
+		go task.execute()
+	}
+}
+
+// missedTasks 维护窗口内错过运行的任务
+func missedTasks() []*scheduledTask {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+
+	var missed []*scheduledTask
+	for _, task := range tasks {
+		if task.missed {
+			missed = append(missed, task)
+		}
+	}
+	return missed
+}
+
+// taskNames 任务名称列表
+func taskNames(list []*scheduledTask) []string {
+	names := make([]string, len(list))
+	for i, task := range list {
+		names[i] = task.name
+	}
+	return names
+}
+
+// currentMaintenanceStatus 维护窗口状态，未配置维护窗口时返回nil
+func currentMaintenanceStatus() *MaintenanceStatus {
+	windows := maintenanceWindows()
+	if len(windows) == 0 {
+		return nil
+	}
+
+	status := &MaintenanceStatus{Windows: make([]string, len(windows)), Active: inMaintenance()}
+	for i, w := range windows {
+		status.Windows[i] = w.Spec
+	}
+	status.MissedTasks = taskNames(missedTasks())
+
+	maintenanceMutex.Lock()
+	if status.Active && maintenanceActive {
+		status.ActiveSince = utils.UTCToShanghai(maintenanceSince).Format("2006-01-02 15:04:05")
+	}
+	maintenanceMutex.Unlock()
+
+	// 按分钟向后查找窗口的开始和结束，最多一周
+	now := utils.GetShanghaiNow().Truncate(time.Minute)
+	inWindow := status.Active
+	for t := now.Add(time.Minute); t.Before(now.Add(8 * 24 * time.Hour)); t = t.Add(time.Minute) {
+		contains := false
+		for _, w := range windows {
+			if w.Contains(t) {
+				contains = true
+				break
+			}
+		}
+		if !inWindow && contains {
+			status.NextStart = t.Format("2006-01-02 15:04")
+			inWindow = true
+		} else if inWindow && !contains {
+			status.NextEnd = t.Format("2006-01-02 15:04")
+			break
+		}
+	}
+	return status
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 单条标注的最大长度（字符）
+const maxNoteLength = 1000
+
+// NoteUpdated 标注修改结果
+type NoteUpdated struct {
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	Timestamp int64  `json:"timestamp"`
+	OldNote   string `json:"old_note"`
+	Note      string `json:"note"`
+	Author    string `json:"author"`
+}
+
+// NoteAuditResponse 标注修改记录
+type NoteAuditResponse struct {
+	Audits []db.NoteAudit `json:"audits"`
+	Count  int            `json:"count"`
+}
+
+// setKlineNote 为指定K线添加或修改标注，空字符串表示清除
+func setKlineNote(c *gin.Context) {
+	var req struct {
+		Symbol    string `json:"symbol"`
+		Interval  string `json:"interval"`
+		Timestamp int64  `json:"timestamp"`
+		Note      string `json:"note"`
+		Author    string `json:"author"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return
+	}
+
+	req.Symbol = strings.ToUpper(req.Symbol)
+	if req.Symbol == "" || req.Interval == "" || req.Timestamp <= 0 {
+		badRequest(c, "缺少必要参数: symbol, interval, timestamp")
+		return
+	}
+	if !config.IsSupportedInterval(req.Interval) {
+		badRequest(c, "不支持的时间间隔: "+req.Interval)
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > maxNoteLength {
+		badRequest(c, "标注长度不能超过"+strconv.Itoa(maxNoteLength)+"个字符")
+		return
+	}
+
+	req.Author = strings.TrimSpace(req.Author)
+	if req.Author == "" {
+		req.Author = "anonymous"
+	}
+	if utf8.RuneCountInString(req.Author) > 64 {
+		badRequest(c, "author 长度不能超过64个字符")
+		return
+	}
+
+	oldNote, err := db.SetKlineNote(req.Symbol, req.Interval, req.Timestamp, req.Note, req.Author, c.ClientIP(), requestID(c))
+	if err == db.ErrKlineNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "K线不存在: "+strconv.FormatInt(req.Timestamp, 10))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	// 清除该交易对的K线查询缓存，避免返回修改前的标注
+	invalidateKlineCache(req.Symbol, req.Interval)
+
+	logRequestInfo(c, "%s 修改了 %s %s %d 的标注", req.Author, req.Symbol, req.Interval, req.Timestamp)
+	utils.IncCounter("biupdata_kline_note_updates_total")
+
+	respondOK(c, NoteUpdated{
+		Symbol:    req.Symbol,
+		Interval:  req.Interval,
+		Timestamp: req.Timestamp,
+		OldNote:   oldNote,
+		Note:      req.Note,
+		Author:    req.Author,
+	})
+}
+
+// getNoteAudits 查询标注修改记录
+func getNoteAudits(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+	if interval != "" && !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	audits, err := db.GetNoteAudits(symbol, interval, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if audits == nil {
+		audits = []db.NoteAudit{}
+	}
+
+	respondOK(c, NoteAuditResponse{
+		Audits: audits,
+		Count:  len(audits),
+	})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 未配置时K线分页的每页条数，即币安单次请求的上限
+const defaultPageSize = 1000
+
+// 连续这么多页完整返回后，每页条数翻倍恢复，直到配置值
+const pageSizeRecoverPages = 10
+
+// 单页请求失败后缩小每页条数重试的最多次数
+const maxPageRetries = 3
+
+// pageSizeState 一条线路当前的每页条数
+type pageSizeState struct {
+	size      int
+	successes int // 连续完整返回的页数
+}
+
+var (
+	pageSizes   = make(map[string]*pageSizeState)
+	pageSizesMu sync.Mutex
+)
+
+// configuredPageSize 配置的每页条数和下限
+func configuredPageSize() (int, int) {
+	if appConfig == nil {
+		return pageSizeLimits(nil)
+	}
+	return pageSizeLimits(&appConfig.Binance)
+}
+
+// pageSizeLimits 配置的每页条数和自动减小时的下限，未配置时为默认值
+func pageSizeLimits(cfg *config.BinanceConfig) (int, int) {
+	if cfg == nil || cfg.PageSize <= 0 {
+		return defaultPageSize, defaultPageSize
+	}
+	min := cfg.MinPageSize
+	if min <= 0 || min > cfg.PageSize {
+		min = cfg.PageSize
+	}
+	return cfg.PageSize, min
+}
+
+// routeName 更新流程使用的网络线路，只有默认更新流程经过线路选择
+func (u *Updater) routeName() string {
+	if !u.shared {
+		return ""
+	}
+	return currentRouteName()
+}
+
+// pageSize 更新流程在线路上的每页条数，默认更新流程按线路状况调整，其他更新流程使用配置值
+func (u *Updater) pageSize(route string) int {
+	if !u.shared {
+		size, _ := pageSizeLimits(u.Config)
+		return size
+	}
+	return pageSizeFor(route)
+}
+
+// currentRouteName 当前使用的网络线路
+func currentRouteName() string {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	return activeRoute
+}
+
+// adaptivePageRoute 是否按结果调整该线路的每页条数，直接连接始终使用配置值
+func adaptivePageRoute(route string) bool {
+	return route != "" && route != routeDirect
+}
+
+// pageSizeFor 获取线路当前的每页条数
+func pageSizeFor(route string) int {
+	size, _ := configuredPageSize()
+	if !adaptivePageRoute(route) {
+		return size
+	}
+
+	pageSizesMu.Lock()
+	defer pageSizesMu.Unlock()
+
+	if st, ok := pageSizes[route]; ok && st.size < size {
+		return st.size
+	}
+	return size
+}
+
+// shrinkPageSize 线路出错或返回不完整的页时将每页条数减半，返回是否确实缩小
+func shrinkPageSize(route, reason string) bool {
+	if !adaptivePageRoute(route) {
+		return false
+	}
+	size, min := configuredPageSize()
+
+	pageSizesMu.Lock()
+	defer pageSizesMu.Unlock()
+
+	st, ok := pageSizes[route]
+	if !ok || st.size > size {
+		st = &pageSizeState{size: size}
+		pageSizes[route] = st
+	}
+	st.successes = 0
+	if st.size <= min {
+		return false
+	}
+
+	old := st.size
+	st.size /= 2
+	if st.size < min {
+		st.size = min
+	}
+	utils.IncCounter(utils.MetricName("biupdata_binance_page_shrinks_total", "route", route, "reason", reason))
+	utils.SetGauge(utils.MetricName("biupdata_binance_page_size", "route", route), float64(st.size))
+	utils.LogWarning("线路 %s %s，K线每页条数从 %d 减小为 %d", route, pageShrinkReasons[reason], old, st.size)
+	return true
+}
+
+// 缩小每页条数的原因
+var pageShrinkReasons = map[string]string{
+	"error":     "请求失败",
+	"truncated": "返回的K线不完整",
+}
+
+// notePageComplete 记录一页完整返回，连续完整若干页后逐步恢复每页条数
+func notePageComplete(route string) {
+	if !adaptivePageRoute(route) {
+		return
+	}
+	size, _ := configuredPageSize()
+
+	pageSizesMu.Lock()
+	defer pageSizesMu.Unlock()
+
+	st, ok := pageSizes[route]
+	if !ok || st.size >= size {
+		return
+	}
+	st.successes++
+	if st.successes < pageSizeRecoverPages {
+		return
+	}
+
+	st.successes = 0
+	st.size *= 2
+	if st.size > size {
+		st.size = size
+	}
+	utils.SetGauge(utils.MetricName("biupdata_binance_page_size", "route", route), float64(st.size))
+	utils.LogInfo("线路 %s 连续 %d 页返回完整，K线每页条数恢复为 %d", route, pageSizeRecoverPages, st.size)
+}
+
+// isPageSizeError 判断请求失败是否可能与每页条数有关：网络错误、超时、限流和服务端错误，参数错误等除外
+func isPageSizeError(err error) bool {
+	var apiErr *binanceError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.HTTPStatus >= 500 || apiErr.HTTPStatus == http.StatusTooManyRequests || apiErr.HTTPStatus == 418
+}
+
+// isTruncatedPage 判断返回的页是否被截断：从请求的开始时间起有数据，但条数少于区间内应有的K线数
+// 区间开头没有数据（交易对上市前）或空页不视为截断
+func isTruncatedPage(interval string, startTime, endTime int64, limit int, klines []db.Candle) bool {
+	if len(klines) == 0 || klines[0].OpenTime > startTime {
+		return false
+	}
+
+	expected := countIntervalBars(interval, startTime, endTime)
+	if expected > int64(limit) {
+		expected = int64(limit)
+	}
+	return int64(len(klines)) < expected
+}
+
+// nextPageStart 下一页的开始时间：紧接本页最后一根K线，空页时按页长跳过
+func nextPageStart(interval string, startTime int64, size int, klines []db.Candle) int64 {
+	if len(klines) > 0 {
+		if last := klines[len(klines)-1].OpenTime; last >= startTime {
+			return advanceIntervals(interval, last, 1)
+		}
+	}
+	return advanceIntervals(interval, startTime, size)
+}
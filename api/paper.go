@@ -0,0 +1,401 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 有未平仓持仓的交易对和时间间隔缓存的有效期，通过接口开仓、平仓时立即失效
+const paperSeriesTTL = time.Minute
+
+// paperTracker 每次写入K线后用最新一根K线的收盘价为对应的未平仓模拟持仓估值，作为扩展挂在K线处理流程上
+type paperTracker struct {
+	mu       sync.Mutex
+	series   map[string]bool // 交易对/时间间隔 -> 是否有未平仓持仓
+	loadedAt time.Time
+}
+
+var (
+	paperMutex  sync.Mutex
+	activePaper *paperTracker
+)
+
+// initPaper 启用模拟持仓时返回对应的扩展，未启用时返回nil
+func initPaper(cfg *config.PaperConfig) *paperTracker {
+	paperMutex.Lock()
+	defer paperMutex.Unlock()
+	activePaper = nil
+	if !cfg.Enabled {
+		return nil
+	}
+	activePaper = &paperTracker{}
+	return activePaper
+}
+
+// InitPaperTrading 启用模拟持仓时创建持仓表
+func InitPaperTrading(cfg *config.Config) error {
+	if !cfg.Paper.Enabled {
+		return nil
+	}
+	return db.CreatePaperTableIfNotExists()
+}
+
+// invalidatePaperSeries 开仓、平仓后让下一次写入重新读取有未平仓持仓的序列
+func invalidatePaperSeries() {
+	paperMutex.Lock()
+	t := activePaper
+	paperMutex.Unlock()
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.loadedAt = time.Time{}
+	t.mu.Unlock()
+}
+
+// Name 扩展名称
+func (t *paperTracker) Name() string {
+	return "paper"
+}
+
+// OnCandleFetched 估值只在写入后进行
+func (t *paperTracker) OnCandleFetched(symbol, interval string, klines []db.Candle) ([]db.Candle, error) {
+	return klines, nil
+}
+
+// OnCandleStored 用本次写入的最新一根K线（含未收盘的）的收盘价为未平仓持仓估值
+func (t *paperTracker) OnCandleStored(symbol, interval string, klines []db.Candle) error {
+	last := klines[len(klines)-1]
+	open, err := t.hasOpenPositions(symbol, interval)
+	if err != nil || !open {
+		return err
+	}
+	_, err = db.MarkPaperPositions(symbol, interval, last.Close, last.OpenTime)
+	return err
+}
+
+// hasOpenPositions 该交易对和时间间隔是否有未平仓持仓，缓存过期时重新读取
+func (t *paperTracker) hasOpenPositions(symbol, interval string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.loadedAt) > paperSeriesTTL {
+		series, err := db.OpenPaperSeries()
+		if err != nil {
+			return false, err
+		}
+		t.series = series
+		t.loadedAt = time.Now()
+	}
+	return t.series[symbol+"/"+interval], nil
+}
+
+// PaperPositionItem 模拟持仓及其盈亏，未平仓时按最近一次估值计算浮动盈亏，已平仓时为实现盈亏
+type PaperPositionItem struct {
+	db.PaperPosition
+	PnL        string  `json:"pnl"`
+	PnLPercent float64 `json:"pnl_percent"` // 相对开仓价的收益率（%），空头方向已取反
+}
+
+// PaperPositionsResponse 模拟持仓列表及汇总
+type PaperPositionsResponse struct {
+	Positions     []PaperPositionItem `json:"positions"`
+	Count         int                 `json:"count"`
+	UnrealizedPnL string              `json:"unrealized_pnl"` // 列表中未平仓持仓的浮动盈亏合计
+	RealizedPnL   string              `json:"realized_pnl"`   // 列表中已平仓持仓的实现盈亏合计
+}
+
+// paperPositionRequest 开仓请求体
+type paperPositionRequest struct {
+	Symbol     string `json:"symbol"`
+	Interval   string `json:"interval"`
+	Side       string `json:"side"`
+	Quantity   string `json:"quantity"`
+	EntryPrice string `json:"entry_price"`
+	Note       string `json:"note"`
+}
+
+// closePaperRequest 平仓请求体
+type closePaperRequest struct {
+	Price string `json:"price"`
+}
+
+// paperPnL 计算持仓盈亏，未平仓时使用估值价格，已平仓时使用平仓价格
+func paperPnL(p db.PaperPosition) (float64, float64) {
+	price := p.MarkPrice
+	if p.Status == "closed" {
+		price = p.ExitPrice
+	}
+	entry := parseDecimal(p.EntryPrice)
+	if entry <= 0 || price == "" {
+		return 0, 0
+	}
+
+	diff := parseDecimal(price) - entry
+	if p.Side == "short" {
+		diff = -diff
+	}
+	return diff * parseDecimal(p.Quantity), math.Round(diff/entry*10000) / 100
+}
+
+// toPaperPositionItem 附加盈亏
+func toPaperPositionItem(p db.PaperPosition) PaperPositionItem {
+	pnl, pct := paperPnL(p)
+	return PaperPositionItem{PaperPosition: p, PnL: formatFloat(pnl), PnLPercent: pct}
+}
+
+// paperEnabled 未启用模拟持仓时返回错误响应
+func paperEnabled(c *gin.Context) bool {
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return false
+	}
+	if !appConfig.Paper.Enabled {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未启用模拟持仓，请设置 PAPER_ENABLED=true")
+		return false
+	}
+	return true
+}
+
+// paperPositionID 解析路径中的持仓ID，出错时已返回响应
+func paperPositionID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		badRequest(c, "无效的持仓ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// positiveDecimal 解析大于0的十进制数
+func positiveDecimal(s string) bool {
+	v, err := strconv.ParseFloat(s, 64)
+	return err == nil && v > 0 && !math.IsInf(v, 0)
+}
+
+// openPaperPosition 开仓，未指定开仓价时使用已存储的最新一根K线的收盘价
+func openPaperPosition(c *gin.Context) {
+	if !paperEnabled(c) {
+		return
+	}
+
+	var req paperPositionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return
+	}
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	req.Side = strings.ToLower(req.Side)
+	if req.Side == "" {
+		req.Side = "long"
+	}
+	if req.Symbol == "" || req.Interval == "" || req.Quantity == "" {
+		badRequest(c, "缺少必要参数: symbol, interval, quantity")
+		return
+	}
+	if !config.IsSupportedInterval(req.Interval) {
+		badRequest(c, "无效的interval参数")
+		return
+	}
+	if req.Side != "long" && req.Side != "short" {
+		badRequest(c, "side 只能是 long 或 short")
+		return
+	}
+	if !positiveDecimal(req.Quantity) {
+		badRequest(c, "quantity 必须是大于0的数")
+		return
+	}
+	if req.EntryPrice != "" && !positiveDecimal(req.EntryPrice) {
+		badRequest(c, "entry_price 必须是大于0的数")
+		return
+	}
+
+	latest, err := db.GetKlineData(req.Symbol, req.Interval, 0, 0, 1)
+	if err != nil || len(latest) == 0 {
+		badRequest(c, "没有 "+req.Symbol+" "+req.Interval+" 的K线数据，无法估值")
+		return
+	}
+	if req.EntryPrice == "" {
+		req.EntryPrice = latest[0].Close
+	}
+
+	position := &db.PaperPosition{
+		Symbol:     req.Symbol,
+		Interval:   req.Interval,
+		Side:       req.Side,
+		Quantity:   req.Quantity,
+		EntryPrice: req.EntryPrice,
+		EntryTime:  time.Now().UnixMilli(),
+		MarkPrice:  latest[0].Close,
+		MarkTime:   utils.StoredTimestampToUTC(latest[0].OpenTime),
+		Note:       req.Note,
+	}
+	id, err := db.OpenPaperPosition(position)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidatePaperSeries()
+
+	created, err := db.GetPaperPosition(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	logRequestInfo(c, "模拟开仓 %d: %s %s %s @ %s", id, created.Symbol, created.Side, created.Quantity, created.EntryPrice)
+	respondOK(c, toPaperPositionItem(*created))
+}
+
+// listPaperPositions 查询模拟持仓及盈亏汇总
+func listPaperPositions(c *gin.Context) {
+	if !paperEnabled(c) {
+		return
+	}
+
+	status := c.DefaultQuery("status", "open")
+	switch status {
+	case "open", "closed":
+	case "all":
+		status = ""
+	default:
+		badRequest(c, "status 只能是 open、closed 或 all")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	positions, err := db.ListPaperPositions(status, strings.ToUpper(c.Query("symbol")), limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	resp := PaperPositionsResponse{Positions: make([]PaperPositionItem, 0, len(positions))}
+	var unrealized, realized float64
+	for _, p := range positions {
+		pnl, _ := paperPnL(p)
+		if p.Status == "closed" {
+			realized += pnl
+		} else {
+			unrealized += pnl
+		}
+		resp.Positions = append(resp.Positions, toPaperPositionItem(p))
+	}
+	resp.Count = len(resp.Positions)
+	resp.UnrealizedPnL = formatFloat(unrealized)
+	resp.RealizedPnL = formatFloat(realized)
+	respondOK(c, resp)
+}
+
+// getPaperPosition 查询单个模拟持仓
+func getPaperPosition(c *gin.Context) {
+	if !paperEnabled(c) {
+		return
+	}
+	id, ok := paperPositionID(c)
+	if !ok {
+		return
+	}
+
+	position, err := db.GetPaperPosition(id)
+	if err == db.ErrPaperPositionNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "模拟持仓不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	respondOK(c, toPaperPositionItem(*position))
+}
+
+// closePaperPosition 平仓，未指定价格时按最近一次估值的价格平仓
+func closePaperPosition(c *gin.Context) {
+	if !paperEnabled(c) {
+		return
+	}
+	id, ok := paperPositionID(c)
+	if !ok {
+		return
+	}
+
+	var req closePaperRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			badRequest(c, "无效的请求参数")
+			return
+		}
+	}
+	if req.Price != "" && !positiveDecimal(req.Price) {
+		badRequest(c, "price 必须是大于0的数")
+		return
+	}
+
+	position, err := db.GetPaperPosition(id)
+	if err == db.ErrPaperPositionNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "模拟持仓不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if req.Price == "" {
+		req.Price = position.MarkPrice
+	}
+
+	err = db.ClosePaperPosition(id, req.Price, time.Now().UnixMilli())
+	if err == db.ErrPaperPositionClosed {
+		respondError(c, http.StatusConflict, CodeConflict, "模拟持仓已平仓: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidatePaperSeries()
+
+	closed, err := db.GetPaperPosition(id)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	item := toPaperPositionItem(*closed)
+	logRequestInfo(c, "模拟平仓 %d: %s @ %s，盈亏 %s", id, closed.Symbol, closed.ExitPrice, item.PnL)
+	respondOK(c, item)
+}
+
+// deletePaperPosition 删除模拟持仓
+func deletePaperPosition(c *gin.Context) {
+	if !paperEnabled(c) {
+		return
+	}
+	id, ok := paperPositionID(c)
+	if !ok {
+		return
+	}
+
+	err := db.DeletePaperPosition(id)
+	if err == db.ErrPaperPositionNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "模拟持仓不存在: "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	invalidatePaperSeries()
+	logRequestInfo(c, "删除模拟持仓 %d", id)
+	respondMessage(c, "模拟持仓已删除", nil)
+}
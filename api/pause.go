@@ -0,0 +1,135 @@
+package api
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// PausedSeries 暂停定时更新的交易对/时间间隔，Symbol或Interval为空表示该维度全部
+type PausedSeries struct {
+	Symbol   string `json:"symbol,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Since    string `json:"since"` // 暂停时间（上海时间）
+}
+
+// 已暂停的交易对/时间间隔，由updateMutex保护
+var pausedSeries = make(map[string]PausedSeries)
+
+// pauseKey 暂停记录的键
+func pauseKey(symbol, interval string) string {
+	return symbol + ":" + interval
+}
+
+// isPaused 交易对的时间间隔是否已暂停定时更新，调用方需持有updateMutex
+func isPaused(symbol, interval string) bool {
+	if len(pausedSeries) == 0 {
+		return false
+	}
+	for _, key := range []string{pauseKey(symbol, interval), pauseKey(symbol, ""), pauseKey("", interval)} {
+		if _, ok := pausedSeries[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pausedList 按交易对和时间间隔排序的暂停记录，调用方需持有updateMutex
+func pausedList() []PausedSeries {
+	result := make([]PausedSeries, 0, len(pausedSeries))
+	for _, p := range pausedSeries {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Symbol != result[j].Symbol {
+			return result[i].Symbol < result[j].Symbol
+		}
+		return result[i].Interval < result[j].Interval
+	})
+	return result
+}
+
+// PausedResponse 当前暂停的交易对/时间间隔
+type PausedResponse struct {
+	Paused []PausedSeries `json:"paused"`
+}
+
+// bindPauseRequest 解析暂停/恢复请求，symbol和interval至少指定一个，出错时已返回响应
+func bindPauseRequest(c *gin.Context) (string, string, bool) {
+	var req struct {
+		Symbol   string `json:"symbol"`
+		Interval string `json:"interval"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return "", "", false
+	}
+
+	symbol := strings.ToUpper(strings.TrimSpace(req.Symbol))
+	interval := strings.TrimSpace(req.Interval)
+	if symbol == "" && interval == "" {
+		badRequest(c, "symbol 和 interval 至少指定一个")
+		return "", "", false
+	}
+	if interval != "" && !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return "", "", false
+	}
+	return symbol, interval, true
+}
+
+// pauseSeries 暂停交易对或时间间隔的定时更新，调度器其余任务照常运行
+func pauseSeries(c *gin.Context) {
+	symbol, interval, ok := bindPauseRequest(c)
+	if !ok {
+		return
+	}
+
+	updateMutex.Lock()
+	key := pauseKey(symbol, interval)
+	if _, exists := pausedSeries[key]; !exists {
+		pausedSeries[key] = PausedSeries{
+			Symbol:   symbol,
+			Interval: interval,
+			Since:    utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		}
+	}
+	paused := pausedList()
+	updateMutex.Unlock()
+
+	if err := savePausedSeries(paused); err != nil {
+		logRequestError(c, "保存暂停记录失败: %v", err)
+	}
+	utils.SetGauge("biupdata_paused_series", float64(len(paused)))
+	logRequestInfo(c, "暂停定时更新 symbol=%s interval=%s", symbol, interval)
+	respondMessage(c, "已暂停定时更新", PausedResponse{Paused: paused})
+}
+
+// resumeSeries 恢复交易对或时间间隔的定时更新，参数需与暂停时一致
+func resumeSeries(c *gin.Context) {
+	symbol, interval, ok := bindPauseRequest(c)
+	if !ok {
+		return
+	}
+
+	updateMutex.Lock()
+	key := pauseKey(symbol, interval)
+	_, exists := pausedSeries[key]
+	delete(pausedSeries, key)
+	paused := pausedList()
+	updateMutex.Unlock()
+
+	if !exists {
+		respondMessage(c, "未找到对应的暂停记录", PausedResponse{Paused: paused})
+		return
+	}
+	if err := savePausedSeries(paused); err != nil {
+		logRequestError(c, "保存暂停记录失败: %v", err)
+	}
+	utils.SetGauge("biupdata_paused_series", float64(len(paused)))
+	logRequestInfo(c, "恢复定时更新 symbol=%s interval=%s", symbol, interval)
+	respondMessage(c, "已恢复定时更新", PausedResponse{Paused: paused})
+}
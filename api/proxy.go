@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// proxyEndpoint 通过http.Transport转发请求的标准代理（HTTP/HTTPS/SOCKS5），每个代理是一条独立的网络线路
+type proxyEndpoint struct {
+	Name        string    `json:"name"` // 隐藏密码后的代理地址
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure"`
+	LastSuccess time.Time `json:"last_success"`
+
+	client *http.Client
+}
+
+var (
+	proxyEndpoints []*proxyEndpoint
+	proxyMu        sync.Mutex
+)
+
+// initProxyEndpoints 解析配置的代理地址，支持 http://、https://、socks5://，可带 user:pass@ 认证信息
+func initProxyEndpoints(proxies []string) error {
+	var endpoints []*proxyEndpoint
+	for _, raw := range proxies {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("无效的代理地址 %s: %v", raw, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("不支持的代理协议 %s，仅支持 http、https、socks5", u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("代理地址缺少主机: %s", u.Redacted())
+		}
+
+		endpoints = append(endpoints, &proxyEndpoint{
+			Name:   u.Redacted(),
+			client: utils.NewHTTPClient(http.ProxyURL(u)),
+		})
+	}
+
+	proxyMu.Lock()
+	proxyEndpoints = endpoints
+	proxyMu.Unlock()
+
+	if len(endpoints) > 0 {
+		utils.LogInfo("已配置 %d 个代理", len(endpoints))
+	}
+	return nil
+}
+
+// proxyClient 获取指定代理的HTTP客户端
+func proxyClient(idx int) *http.Client {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	return proxyEndpoints[idx].client
+}
+
+// markProxyResult 记录代理请求结果
+func markProxyResult(idx int, err error) {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+
+	if idx >= len(proxyEndpoints) {
+		return
+	}
+	ep := proxyEndpoints[idx]
+	if err != nil {
+		ep.Failures++
+		ep.LastError = err.Error()
+		ep.LastFailure = time.Now()
+		utils.IncCounter(utils.MetricName("biupdata_proxy_failures_total", "proxy", ep.Name))
+		return
+	}
+
+	ep.Failures = 0
+	ep.LastSuccess = time.Now()
+}
+
+// getProxyStatus 获取各代理的状态快照
+func getProxyStatus() []proxyEndpoint {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+
+	result := make([]proxyEndpoint, len(proxyEndpoints))
+	for i, ep := range proxyEndpoints {
+		result[i] = *ep
+	}
+	return result
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 查找最近一个缺口时最多向前检查的窗口数，每个窗口gapMaxBars根
+const qualityMaxGapWindows = 20
+
+// QualityItem 一个交易对和时间间隔的数据质量
+type QualityItem struct {
+	Symbol         string    `json:"symbol"`
+	Interval       string    `json:"interval"`
+	FirstTimestamp int64     `json:"first_timestamp"` // 第一根K线的开盘时间（毫秒），没有数据时为0
+	FirstDatetime  string    `json:"first_datetime,omitempty"`
+	Expected       int64     `json:"expected"` // 第一根K线到最近一根已收盘K线应有的数量
+	Present        int64     `json:"present"`
+	Missing        int64     `json:"missing"`
+	Completeness   float64   `json:"completeness"` // present / expected，0到1
+	LastGap        *KlineGap `json:"last_gap"`     // 最近的一段缺口，没有缺口时为null
+	Anomalies      int64     `json:"anomalies"`    // 价格关系不成立的K线数量
+	Score          float64   `json:"score"`        // 综合评分0到100：完整度扣除异常K线的比例
+	Error          string    `json:"error,omitempty"`
+}
+
+// QualityResponse 数据质量检查结果
+type QualityResponse struct {
+	Items []QualityItem `json:"items"`
+	Count int           `json:"count"`
+}
+
+// getQuality 计算每个交易对和时间间隔的完整度、最近的缺口和异常K线数量，可按symbol、interval过滤
+func getQuality(c *gin.Context) {
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return
+	}
+
+	symbol := strings.ToUpper(c.Query("symbol"))
+	interval := c.Query("interval")
+	if interval != "" && !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	updateMutex.Lock()
+	symbols := appConfig.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+
+	if symbol != "" {
+		if !containsSymbol(symbols, symbol) {
+			badRequest(c, "交易对 "+symbol+" 不在采集范围内")
+			return
+		}
+		symbols = []string{symbol}
+	}
+	if interval != "" && !containsSymbol(appConfig.Binance.AllIntervals(), interval) {
+		badRequest(c, "时间间隔 "+interval+" 不在采集范围内")
+		return
+	}
+
+	resp := QualityResponse{Items: []QualityItem{}}
+	for _, s := range symbols {
+		for _, iv := range appConfig.Binance.IntervalsFor(s) {
+			if interval != "" && iv != interval {
+				continue
+			}
+			item, err := klineQuality(s, iv)
+			if err != nil {
+				item.Error = err.Error()
+			}
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	resp.Count = len(resp.Items)
+	respondOK(c, resp)
+}
+
+// klineQuality 计算一个交易对和时间间隔的数据质量，统计范围为第一根K线到最近一根已收盘的K线
+func klineQuality(symbol, interval string) (QualityItem, error) {
+	item := QualityItem{Symbol: symbol, Interval: interval}
+
+	endUTC := intervalStart(interval, time.Now().UnixMilli()) - 1
+	agg, err := db.GetKlineAggregate(symbol, interval, 0, endUTC)
+	if err != nil {
+		return item, err
+	}
+	if agg.Count == 0 {
+		return item, nil
+	}
+
+	item.FirstTimestamp = agg.FirstTime
+	item.FirstDatetime = utils.TimestampToShanghai(agg.FirstTime).Format("2006-01-02 15:04")
+	item.Present = agg.Count
+	item.Expected = countIntervalBars(interval, agg.FirstTime, endUTC+1)
+	if item.Expected < item.Present {
+		// 不在周期边界上的记录会使实际数量多于应有数量
+		item.Expected = item.Present
+	}
+	item.Missing = item.Expected - item.Present
+	item.Completeness = roundRatio(float64(item.Present) / float64(item.Expected))
+
+	item.Anomalies, err = db.CountKlineAnomalies(symbol, interval)
+	if err != nil {
+		return item, err
+	}
+	item.Score = math.Round(item.Completeness*(1-float64(item.Anomalies)/float64(item.Present))*10000) / 100
+
+	if item.Missing > 0 {
+		item.LastGap, err = findLastGap(symbol, interval, agg.FirstTime, endUTC)
+		if err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// findLastGap 从最近一根已收盘的K线向前按窗口查找最近的一段缺口
+func findLastGap(symbol, interval string, firstUTC, endUTC int64) (*KlineGap, error) {
+	windowEnd := endUTC
+	for i := 0; i < qualityMaxGapWindows && windowEnd >= firstUTC; i++ {
+		windowStart := advanceIntervals(interval, intervalStart(interval, windowEnd), -(gapMaxBars - 1))
+		if windowStart < firstUTC {
+			windowStart = firstUTC
+		}
+
+		report, err := findGaps(symbol, interval, windowStart, windowEnd)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(report.Gaps); n > 0 {
+			gap := report.Gaps[n-1]
+			return &gap, nil
+		}
+		windowEnd = windowStart - 1
+	}
+	return nil, nil
+}
+
+// roundRatio 比例保留4位小数
+func roundRatio(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/gin-gonic/gin"
+)
+
+// 换算路径最多经过的步数
+const quoteMaxSteps = 3
+
+// quoteUSD 代表美元的虚拟计价货币，与配置的稳定币等值
+const quoteUSD = "USD"
+
+// 换算步骤的运算方式
+const (
+	quoteOpMultiply = "multiply" // 乘以换算交易对的价格（from为其基础资产）
+	quoteOpDivide   = "divide"   // 除以换算交易对的价格（from为其计价资产）
+	quoteOpPar      = "par"      // 稳定币之间按1:1换算
+)
+
+// errNoQuotePath 找不到换算路径或缺少交易对元数据
+var errNoQuotePath = errors.New("无法换算计价货币")
+
+// QuoteStep 换算路径中的一步
+type QuoteStep struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Symbol    string `json:"symbol,omitempty"` // 使用的换算交易对，按1:1换算时为空
+	Operation string `json:"operation"`
+}
+
+// QuoteConversion 计价货币换算说明
+type QuoteConversion struct {
+	From    string      `json:"from"` // 交易对原本的计价资产
+	To      string      `json:"to"`
+	Path    []QuoteStep `json:"path"`
+	Dropped int         `json:"dropped"` // 换算交易对缺少同一时间的K线而被去掉的K线数量
+}
+
+// parseQuote 解析quote参数并查找换算路径，未指定quote时返回nil，出错时已返回响应
+func parseQuote(c *gin.Context, symbol string) (*QuoteConversion, bool) {
+	quote := strings.ToUpper(c.Query("quote"))
+	if quote == "" {
+		return nil, true
+	}
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return nil, false
+	}
+
+	conv, err := resolveQuotePath(strings.ToUpper(symbol), quote)
+	if err != nil {
+		if errors.Is(err, errNoQuotePath) {
+			badRequest(c, err.Error())
+		} else {
+			internalError(c, err)
+		}
+		return nil, false
+	}
+	return conv, true
+}
+
+// isStablecoin 判断资产是否视为与美元等值
+func isStablecoin(asset string) bool {
+	if appConfig == nil || len(appConfig.Quote.Stablecoins) == 0 {
+		return false
+	}
+	if asset == quoteUSD {
+		return true
+	}
+	for _, s := range appConfig.Quote.Stablecoins {
+		if strings.EqualFold(s, asset) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveQuotePath 在采集的交易对之间按广度优先查找从symbol的计价资产到target的最短换算路径，
+// 从同一资产出发时优先使用真实价格而不是稳定币1:1换算
+func resolveQuotePath(symbol, target string) (*QuoteConversion, error) {
+	infos, err := db.GetSymbolInfos("")
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]db.SymbolInfo, len(infos))
+	for _, info := range infos {
+		byName[info.Symbol] = info
+	}
+
+	src, ok := byName[symbol]
+	if !ok {
+		return nil, fmt.Errorf("%w：缺少交易对 %s 的元数据，请等待交易对元数据同步完成", errNoQuotePath, symbol)
+	}
+	conv := &QuoteConversion{From: src.QuoteAsset, To: target, Path: []QuoteStep{}}
+	if src.QuoteAsset == target {
+		return conv, nil
+	}
+
+	// 只能使用正在采集的交易对换算，其K线与被换算的K线时间间隔相同
+	updateMutex.Lock()
+	collected := appConfig.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+	sort.Strings(collected)
+
+	stablecoins := append([]string{quoteUSD}, appConfig.Quote.Stablecoins...)
+
+	edges := func(asset string) []QuoteStep {
+		var steps []QuoteStep
+		for _, name := range collected {
+			info, ok := byName[strings.ToUpper(name)]
+			if !ok || info.Retired {
+				continue
+			}
+			if info.BaseAsset == asset {
+				steps = append(steps, QuoteStep{From: asset, To: info.QuoteAsset, Symbol: info.Symbol, Operation: quoteOpMultiply})
+			} else if info.QuoteAsset == asset {
+				steps = append(steps, QuoteStep{From: asset, To: info.BaseAsset, Symbol: info.Symbol, Operation: quoteOpDivide})
+			}
+		}
+		if isStablecoin(asset) {
+			for _, s := range stablecoins {
+				s = strings.ToUpper(s)
+				if s != asset {
+					steps = append(steps, QuoteStep{From: asset, To: s, Operation: quoteOpPar})
+				}
+			}
+		}
+		return steps
+	}
+
+	paths := map[string][]QuoteStep{src.QuoteAsset: {}}
+	frontier := []string{src.QuoteAsset}
+	for depth := 0; depth < quoteMaxSteps && len(frontier) > 0; depth++ {
+		var next []string
+		for _, asset := range frontier {
+			for _, step := range edges(asset) {
+				if _, seen := paths[step.To]; seen {
+					continue
+				}
+				path := append(append([]QuoteStep{}, paths[asset]...), step)
+				if step.To == target {
+					conv.Path = path
+					return conv, nil
+				}
+				paths[step.To] = path
+				next = append(next, step.To)
+			}
+		}
+		frontier = next
+	}
+
+	return nil, fmt.Errorf("%w：在采集的交易对中找不到从 %s 到 %s 的换算路径（最多%d步）", errNoQuotePath, src.QuoteAsset, target, quoteMaxSteps)
+}
+
+// convertQuote 按换算路径把按时间倒序的K线价格换算为目标计价货币，成交量（基础资产数量）不变。
+// 开盘、收盘价乘除换算交易对同一根K线的开盘、收盘价；最高、最低价取区间的上下界近似：
+// 乘法时乘以换算K线的最高、最低价，除法时除以换算K线的最低、最高价。
+// 换算交易对缺少同一时间的K线时去掉该K线，数量记在conv.Dropped中
+func convertQuote(klines []KlineItem, interval string, conv *QuoteConversion) ([]KlineItem, error) {
+	if len(conv.Path) == 0 || len(klines) == 0 {
+		return klines, nil
+	}
+
+	start, end := klines[len(klines)-1].Timestamp, klines[0].Timestamp
+	limit := int(countBarsBetween(interval, start, end)) + 1
+	series := make([]map[int64]KlineItem, len(conv.Path))
+	for i, step := range conv.Path {
+		if step.Symbol == "" {
+			continue
+		}
+		items, err := queryKlineItems(step.Symbol, interval, start, end, limit)
+		if err != nil {
+			return nil, fmt.Errorf("查询换算交易对 %s 失败: %w", step.Symbol, err)
+		}
+		series[i] = make(map[int64]KlineItem, len(items))
+		for _, k := range items {
+			series[i][k.Timestamp] = k
+		}
+	}
+
+	result := make([]KlineItem, 0, len(klines))
+	for _, k := range klines {
+		o, h, l, c := parseDecimal(k.OpenPrice), parseDecimal(k.HighPrice), parseDecimal(k.LowPrice), parseDecimal(k.ClosePrice)
+
+		ok := true
+		for i, step := range conv.Path {
+			if step.Operation == quoteOpPar {
+				continue
+			}
+			ref, found := series[i][k.Timestamp]
+			if !found {
+				ok = false
+				break
+			}
+			ro, rh, rl, rc := parseDecimal(ref.OpenPrice), parseDecimal(ref.HighPrice), parseDecimal(ref.LowPrice), parseDecimal(ref.ClosePrice)
+			if step.Operation == quoteOpMultiply {
+				o, h, l, c = o*ro, h*rh, l*rl, c*rc
+				continue
+			}
+			if ro <= 0 || rh <= 0 || rl <= 0 || rc <= 0 {
+				ok = false
+				break
+			}
+			o, h, l, c = o/ro, h/rl, l/rh, c/rc
+		}
+		if !ok {
+			conv.Dropped++
+			continue
+		}
+
+		k.OpenPrice = formatFloat(o)
+		k.HighPrice = formatFloat(h)
+		k.LowPrice = formatFloat(l)
+		k.ClosePrice = formatFloat(c)
+		result = append(result, k)
+	}
+	return result, nil
+}
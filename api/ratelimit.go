@@ -0,0 +1,138 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// tokenBucket 令牌桶，按固定速率补充令牌，最多累积burst个
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter 按客户端标识分别限流
+type rateLimiter struct {
+	rps     float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rps:     rps,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow 尝试消耗一个令牌，失败时返回需要等待的时间
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	// 按流逝时间补充令牌
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*l.rps)
+	bucket.lastSeen = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rps * float64(time.Second))
+	return false, wait
+}
+
+// cleanup 清理长时间未访问且令牌已补满的桶，避免内存无限增长
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fullAfter := time.Duration(l.burst / l.rps * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > fullAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// startLimiterCleanup 定期清理限流器中不再需要的桶
+func startLimiterCleanup(l *rateLimiter) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			utils.Safe("ratelimit_cleanup", func() { l.cleanup(now) })
+		}
+	}()
+}
+
+// ipRateLimitMiddleware 按客户端IP限流，所有请求都经过，超限返回429
+func ipRateLimitMiddleware(cfg *config.APIConfig) gin.HandlerFunc {
+	limiter := newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	startLimiterCleanup(limiter)
+	return rateLimitMiddleware(limiter, func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	})
+}
+
+// subjectRateLimitMiddleware 按认证通过的令牌主体（JWT的sub）限流，须放在认证之后；
+// 未认证的请求（公开路径）只受IP限流，客户端无法通过伪造标识绕过IP限流
+func subjectRateLimitMiddleware(cfg *config.APIConfig) gin.HandlerFunc {
+	limiter := newRateLimiter(cfg.RateLimitKeyRPS, cfg.RateLimitKeyBurst)
+	startLimiterCleanup(limiter)
+	return rateLimitMiddleware(limiter, func(c *gin.Context) string {
+		if subject := c.GetString(authSubjectKey); subject != "" {
+			return "sub:" + subject
+		}
+		return ""
+	})
+}
+
+// rateLimitMiddleware 按keyFn返回的客户端标识限流，标识为空时不限流，超限返回429
+func rateLimitMiddleware(limiter *rateLimiter, keyFn func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 健康检查不限流，避免负载均衡误判
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+		key := keyFn(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		allowed, wait := limiter.allow(key, time.Now())
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			utils.IncCounter("biupdata_http_rate_limited_total")
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			respondError(c, http.StatusTooManyRequests, CodeRateLimited, "请求过于频繁，请稍后重试")
+			return
+		}
+
+		c.Next()
+	}
+}
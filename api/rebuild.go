@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 保留的重建任务数量
+const maxRebuildJobs = 50
+
+// 重建前原表的处理方式
+const (
+	rebuildModeRename   = "rename"   // 重命名保留原表
+	rebuildModeTruncate = "truncate" // 直接清空原表
+)
+
+// RebuildJob 重建一个交易对单个时间间隔数据的任务
+type RebuildJob struct {
+	ID          string  `json:"id"`
+	Symbol      string  `json:"symbol"`
+	Interval    string  `json:"interval"`
+	Mode        string  `json:"mode"`
+	StartTime   int64   `json:"start_time"` // 重新回补的开始时间（UTC毫秒）
+	Status      string  `json:"status"`     // running、done、failed
+	BackupTable string  `json:"backup_table,omitempty"`
+	Expected    int64   `json:"expected"` // 开始时估算的K线数量
+	Written     int     `json:"written"`
+	Progress    float64 `json:"progress"`          // 0-100
+	Current     string  `json:"current,omitempty"` // 已写入的最后一根K线（上海时间）
+	Error       string  `json:"error,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	FinishedAt  string  `json:"finished_at,omitempty"`
+}
+
+var (
+	rebuildJobs    = make(map[string]*RebuildJob)
+	rebuildJobList []string // 任务ID，按创建顺序
+	rebuildMutex   sync.Mutex
+)
+
+// RebuildRequest 重建请求
+type RebuildRequest struct {
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	StartDate string `json:"start_date"` // YYYY-MM-DD，留空时与首次回补相同
+	Mode      string `json:"mode"`       // rename（默认）或 truncate
+}
+
+// RebuildJobList 重建任务列表
+type RebuildJobList struct {
+	Jobs  []RebuildJob `json:"jobs"`
+	Count int          `json:"count"`
+}
+
+// startRebuild 清空（或重命名保留）交易对单个时间间隔的数据表，并在后台从指定日期重新回补，
+// 用于修复已知被写坏的数据，如时区换算错误写入的错位时间戳
+func startRebuild(c *gin.Context) {
+	var req RebuildRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "无效的请求参数")
+		return
+	}
+	req.Symbol = strings.ToUpper(req.Symbol)
+	if req.Symbol == "" || req.Interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+	if !config.IsSupportedInterval(req.Interval) {
+		badRequest(c, "不支持的时间间隔: "+req.Interval)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = rebuildModeRename
+	}
+	if req.Mode != rebuildModeRename && req.Mode != rebuildModeTruncate {
+		badRequest(c, "无效的mode参数，可选 rename、truncate")
+		return
+	}
+	if !requireLeader(c) {
+		return
+	}
+
+	var startUTC int64
+	if req.StartDate != "" {
+		t, err := utils.ParseShanghaiDate(req.StartDate)
+		if err != nil {
+			badRequest(c, "无效的start_date参数，格式应为 YYYY-MM-DD")
+			return
+		}
+		startUTC = intervalStart(req.Interval, t.UnixMilli())
+	}
+
+	// 登记为更新任务，重建期间定时任务和手动更新会跳过或合并到该任务，不会同时写入
+	claimed := claimUpdateJobs(req.Symbol, []string{req.Interval}, "rebuild")[0]
+	if !claimed.owned {
+		respondError(c, http.StatusConflict, CodeConflict, fmt.Sprintf("%s %s 正在更新（任务 %s），请稍后重试", req.Symbol, req.Interval, claimed.job.ID))
+		return
+	}
+	lockKey := fmt.Sprintf("update:%s:%s", req.Symbol, req.Interval)
+	lockToken, locked, err := defaultUpdater.acquireLock(lockKey)
+	if err != nil {
+		skipUpdateJob(claimed.job)
+		respondError(c, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("获取 %s %s 的分布式锁失败，请稍后重试: %v", req.Symbol, req.Interval, err))
+		return
+	}
+	if !locked {
+		skipUpdateJob(claimed.job)
+		respondError(c, http.StatusConflict, CodeConflict, fmt.Sprintf("%s %s 正由其他实例更新，请稍后重试", req.Symbol, req.Interval))
+		return
+	}
+	startUpdateJob(claimed.job)
+
+	job := &RebuildJob{
+		ID:        claimed.job.ID,
+		Symbol:    req.Symbol,
+		Interval:  req.Interval,
+		Mode:      req.Mode,
+		Status:    "running",
+		CreatedAt: utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+	}
+	rebuildMutex.Lock()
+	rebuildJobs[job.ID] = job
+	rebuildJobList = append(rebuildJobList, job.ID)
+	if len(rebuildJobList) > maxRebuildJobs {
+		delete(rebuildJobs, rebuildJobList[0])
+		rebuildJobList = rebuildJobList[1:]
+	}
+	rebuildMutex.Unlock()
+
+	created := *job
+
+	logRequestInfo(c, "重建 %s %s 数据（%s），任务 %s", req.Symbol, req.Interval, req.Mode, job.ID)
+	go func() {
+		defer defaultUpdater.releaseLock(lockKey, lockToken)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stopKeeping := defaultUpdater.keepLock(lockKey, lockToken, cancel)
+		written, err := runRebuild(ctx, job, startUTC)
+		stopKeeping()
+		finishUpdateJob(claimed.job, written, err)
+	}()
+
+	respondMessage(c, "重建任务已创建", created)
+}
+
+// runRebuild 执行重建：处理原表后从startUTC（为0时与首次回补相同）回补到当前时间，返回写入的记录数；ctx取消时停止回补
+func runRebuild(ctx context.Context, job *RebuildJob, startUTC int64) (written int, err error) {
+	defer func() {
+		rebuildMutex.Lock()
+		job.FinishedAt = utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+		if err != nil {
+			job.Status = "failed"
+			job.Error = err.Error()
+		} else {
+			job.Status = "done"
+			job.Progress = 100
+		}
+		rebuildMutex.Unlock()
+		if err != nil {
+			utils.LogError("重建 %s %s 数据失败（已写入 %d 条）: %v", job.Symbol, job.Interval, written, err)
+		} else {
+			utils.LogInfo("重建 %s %s 数据完成，共写入 %d 条", job.Symbol, job.Interval, written)
+		}
+	}()
+	defer utils.Recover("rebuild", &err)
+
+	backup, err := db.ResetKlineTable(job.Symbol, job.Interval, job.Mode == rebuildModeRename)
+	rebuildMutex.Lock()
+	job.BackupTable = backup
+	rebuildMutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if startUTC == 0 {
+		startUTC = utils.StoredTimestampToUTC(defaultUpdater.initialStartTimestamp(job.Symbol, job.Interval))
+	}
+	expected := countIntervalBars(job.Interval, startUTC, time.Now().UnixMilli()) + 1
+	rebuildMutex.Lock()
+	job.StartTime = startUTC
+	job.Expected = expected
+	rebuildMutex.Unlock()
+
+	written, _, err = defaultUpdater.fetchKlinePages(ctx, job.Symbol, job.Interval, startUTC, 0, func(klines []db.Candle) (int, error) {
+		count, err := ProcessKlineData(ctx, job.Symbol, job.Interval, klines)
+		if err != nil {
+			return 0, err
+		}
+		defaultUpdater.advanceSyncWatermark(job.Symbol, job.Interval, klines)
+
+		rebuildMutex.Lock()
+		job.Written += count
+		if expected > 0 {
+			job.Progress = float64(int(float64(job.Written)/float64(expected)*1000)) / 10
+			if job.Progress > 99.9 {
+				job.Progress = 99.9
+			}
+		}
+		if len(klines) > 0 {
+			job.Current = utils.TimestampToShanghai(klines[len(klines)-1].OpenTime).Format("2006-01-02 15:04")
+		}
+		rebuildMutex.Unlock()
+		return count, nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	db.MarkSyncSuccess(job.Symbol, job.Interval)
+	materializeStats(job.Symbol, job.Interval, written)
+	if appConfig != nil && appConfig.Rollup.Enabled && job.Interval == appConfig.Rollup.Source {
+		runRollups(job.Symbol, written)
+	}
+	return written, nil
+}
+
+// getRebuildJobs 查询最近的重建任务，按创建时间倒序
+func getRebuildJobs(c *gin.Context) {
+	rebuildMutex.Lock()
+	jobs := make([]RebuildJob, 0, len(rebuildJobs))
+	for _, job := range rebuildJobs {
+		jobs = append(jobs, *job)
+	}
+	rebuildMutex.Unlock()
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt > jobs[j].CreatedAt })
+	respondOK(c, RebuildJobList{Jobs: jobs, Count: len(jobs)})
+}
+
+// getRebuildJob 按ID查询重建任务
+func getRebuildJob(c *gin.Context) {
+	rebuildMutex.Lock()
+	job, ok := rebuildJobs[c.Param("id")]
+	var result RebuildJob
+	if ok {
+		result = *job
+	}
+	rebuildMutex.Unlock()
+
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "重建任务不存在或已过期")
+		return
+	}
+	respondOK(c, result)
+}
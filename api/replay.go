@@ -0,0 +1,314 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 回放数据中K线接口的路径
+const klinesPath = "/api/v3/klines"
+
+// Fixture 录制的一个币安响应，每个文件一条
+type Fixture struct {
+	Path       string          `json:"path"`   // 请求路径和查询参数
+	Status     int             `json:"status"` // HTTP状态码
+	RecordedAt string          `json:"recorded_at"`
+	Body       json.RawMessage `json:"body"` // 原始响应内容
+}
+
+// replayCandle 回放数据中的一根K线，保留原始JSON
+type replayCandle struct {
+	openTime int64
+	raw      json.RawMessage
+}
+
+// replayExchange 从录制的响应读取数据的交易所实现，不访问网络；
+// K线按交易对和时间间隔合并后按请求的时间范围返回，其他接口按路径匹配
+type replayExchange struct {
+	candles   map[string][]replayCandle // 按 交易对 时间间隔，按开盘时间升序
+	responses map[string]Fixture        // 按完整路径和不含查询参数的路径
+}
+
+// initReplay 按配置切换为回放或录制模式
+func initReplay(cfg *config.BinanceConfig) error {
+	if cfg.ReplayDir != "" {
+		ex, err := newReplayExchange(cfg.ReplayDir)
+		if err != nil {
+			return err
+		}
+		SetExchange(ex)
+		return nil
+	}
+	if cfg.RecordDir != "" {
+		if err := os.MkdirAll(cfg.RecordDir, 0755); err != nil {
+			return fmt.Errorf("创建录制目录失败: %v", err)
+		}
+		SetExchange(&recordingExchange{next: binanceExchange{}, dir: cfg.RecordDir})
+		utils.LogInfo("已启用币安响应录制，目录: %s", cfg.RecordDir)
+	}
+	return nil
+}
+
+// replaying 交易所实现是否为回放数据
+func replaying(ex Exchange) bool {
+	_, ok := ex.(*replayExchange)
+	return ok
+}
+
+// newReplayExchange 读取目录（含子目录）中的全部 .json 文件：
+// 录制的 Fixture，或直接保存的币安K线数组（文件名以 交易对_时间间隔 开头，如 BTCUSDT_1h.json、BTCUSDT_1h_2024-01.json）；
+// 按文件名排序读取，同一根K线以后读取的为准
+func newReplayExchange(dir string) (*replayExchange, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取回放目录失败: %v", err)
+	}
+	sort.Strings(files)
+
+	r := &replayExchange{responses: make(map[string]Fixture)}
+	merged := make(map[string]map[int64]json.RawMessage)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+
+		// 直接保存的K线数组
+		if bytes.HasPrefix(data, []byte("[")) {
+			symbol, interval, ok := parseFixtureName(filepath.Base(file))
+			if !ok {
+				return nil, fmt.Errorf("回放文件 %s 是K线数组，文件名应以 交易对_时间间隔 开头", file)
+			}
+			if err := addReplayCandles(merged, symbol, interval, data); err != nil {
+				return nil, fmt.Errorf("解析回放文件 %s 失败: %v", file, err)
+			}
+			continue
+		}
+
+		var f Fixture
+		if err := json.Unmarshal(data, &f); err != nil || f.Path == "" {
+			return nil, fmt.Errorf("解析回放文件 %s 失败: 不是录制的响应或K线数组", file)
+		}
+		u, err := url.Parse(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("回放文件 %s 的路径无效: %v", file, err)
+		}
+		if u.Path == klinesPath && f.Status == 200 {
+			q := u.Query()
+			if err := addReplayCandles(merged, q.Get("symbol"), q.Get("interval"), f.Body); err != nil {
+				return nil, fmt.Errorf("解析回放文件 %s 失败: %v", file, err)
+			}
+			continue
+		}
+		r.responses[f.Path] = f
+		r.responses[u.Path] = f
+	}
+
+	r.candles = make(map[string][]replayCandle, len(merged))
+	total := 0
+	for key, byTime := range merged {
+		list := make([]replayCandle, 0, len(byTime))
+		for openTime, raw := range byTime {
+			list = append(list, replayCandle{openTime: openTime, raw: raw})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].openTime < list[j].openTime })
+		r.candles[key] = list
+		total += len(list)
+	}
+
+	utils.LogInfo("回放模式：从 %s 读取了 %d 个文件，%d 个K线序列共 %d 根K线，不访问币安", dir, len(files), len(r.candles), total)
+	return r, nil
+}
+
+// parseFixtureName 从文件名解析交易对和时间间隔
+func parseFixtureName(name string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".json"), "_")
+	if len(parts) < 2 || !config.IsSupportedInterval(parts[1]) {
+		return "", "", false
+	}
+	return strings.ToUpper(parts[0]), parts[1], true
+}
+
+// addReplayCandles 把K线数组按开盘时间合并到序列中
+func addReplayCandles(merged map[string]map[int64]json.RawMessage, symbol, interval string, data []byte) error {
+	var candles []json.RawMessage
+	if err := json.Unmarshal(data, &candles); err != nil {
+		return err
+	}
+	key := updateJobKey(symbol, interval)
+	if merged[key] == nil {
+		merged[key] = make(map[int64]json.RawMessage)
+	}
+	for _, raw := range candles {
+		var fields []json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil || len(fields) == 0 {
+			return fmt.Errorf("无效的K线: %s", raw)
+		}
+		openTime, err := strconv.ParseInt(string(fields[0]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("无效的开盘时间: %s", fields[0])
+		}
+		merged[key][openTime] = raw
+	}
+	return nil
+}
+
+// Get 返回录制的响应；K线按startTime、endTime、limit从合并后的序列中选取，与币安的语义相同
+func (r *replayExchange) Get(path string) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == klinesPath {
+		return r.klines(u.Query())
+	}
+
+	f, ok := r.responses[path]
+	if !ok {
+		f, ok = r.responses[u.Path]
+	}
+	if !ok {
+		utils.IncCounter("biupdata_replay_misses_total")
+		return nil, fmt.Errorf("回放数据中没有 %s 的响应", u.Path)
+	}
+	if f.Status != 200 {
+		return nil, parseBinanceError(f.Status, f.Body)
+	}
+	return f.Body, nil
+}
+
+// klines 从回放数据中选取K线
+func (r *replayExchange) klines(q url.Values) ([]byte, error) {
+	list, ok := r.candles[updateJobKey(q.Get("symbol"), q.Get("interval"))]
+	if !ok {
+		// 不返回无效交易对错误，避免缺少回放数据的交易对被当作下架处理
+		utils.IncCounter("biupdata_replay_misses_total")
+		return nil, fmt.Errorf("回放数据中没有 %s %s 的K线", q.Get("symbol"), q.Get("interval"))
+	}
+
+	limit := 500
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	start, hasStart := int64(0), q.Get("startTime") != ""
+	if hasStart {
+		start, _ = strconv.ParseInt(q.Get("startTime"), 10, 64)
+	}
+	end, hasEnd := int64(0), q.Get("endTime") != ""
+	if hasEnd {
+		end, _ = strconv.ParseInt(q.Get("endTime"), 10, 64)
+	}
+
+	var selected []replayCandle
+	if hasStart {
+		i := sort.Search(len(list), func(i int) bool { return list[i].openTime >= start })
+		for ; i < len(list) && len(selected) < limit; i++ {
+			if hasEnd && list[i].openTime > end {
+				break
+			}
+			selected = append(selected, list[i])
+		}
+	} else {
+		j := len(list)
+		if hasEnd {
+			j = sort.Search(len(list), func(i int) bool { return list[i].openTime > end })
+		}
+		i := j - limit
+		if i < 0 {
+			i = 0
+		}
+		selected = list[i:j]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, c := range selected {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(c.raw)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// recordingExchange 把每个币安响应保存为 Fixture 文件的交易所实现，签名的账户接口不录制
+type recordingExchange struct {
+	next Exchange
+	dir  string
+	seq  uint64
+}
+
+// Get 请求币安并录制响应，网络错误不录制
+func (r *recordingExchange) Get(path string) ([]byte, error) {
+	body, err := r.next.Get(path)
+
+	var apiErr *binanceError
+	switch {
+	case err == nil:
+		r.save(path, 200, body)
+	case errors.As(err, &apiErr):
+		data, _ := json.Marshal(apiErr)
+		r.save(path, apiErr.HTTPStatus, data)
+	}
+	return body, err
+}
+
+// save 写入一个录制文件，文件名为 时间_序号_接口[_交易对_时间间隔].json，按文件名排序即为录制顺序
+func (r *recordingExchange) save(path string, status int, body []byte) {
+	u, err := url.Parse(path)
+	if err != nil || u.Query().Get("signature") != "" {
+		return
+	}
+	if !json.Valid(body) {
+		return
+	}
+
+	name := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if q := u.Query(); q.Get("symbol") != "" {
+		name += "_" + strings.ToUpper(q.Get("symbol"))
+		if q.Get("interval") != "" {
+			name += "_" + q.Get("interval")
+		}
+	}
+	seq := atomic.AddUint64(&r.seq, 1)
+	file := fmt.Sprintf("%s_%06d_%s.json", utils.GetShanghaiNow().Format("20060102-150405"), seq, name)
+
+	data, err := json.MarshalIndent(Fixture{
+		Path:       path,
+		Status:     status,
+		RecordedAt: utils.GetShanghaiNow().Format("2006-01-02 15:04:05.000"),
+		Body:       body,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.dir, file), data, 0644); err != nil {
+		utils.LogWarning("录制币安响应失败: %v", err)
+		return
+	}
+	utils.IncCounter("biupdata_replay_recorded_total")
+}
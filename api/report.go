@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DailyReport 某一天（配置时区的自然日）各交易对的行情和采集情况
+type DailyReport struct {
+	Date         string              `json:"date"`
+	StartTime    int64               `json:"start_time"` // 当天开始时间（UTC毫秒）
+	EndTime      int64               `json:"end_time"`   // 当天结束时间（UTC毫秒，不含）
+	GeneratedAt  string              `json:"generated_at"`
+	Symbols      []SymbolDailyReport `json:"symbols"`
+	TotalRows    int64               `json:"total_rows"`
+	TotalMissing int64               `json:"total_missing"`
+}
+
+// SymbolDailyReport 单个交易对当天的OHLCV和采集情况，OHLCV由采集的最小时间间隔计算
+type SymbolDailyReport struct {
+	Symbol        string                `json:"symbol"`
+	Source        string                `json:"source,omitempty"` // 计算OHLCV所用的时间间隔，当天没有数据时为空
+	Open          string                `json:"open,omitempty"`
+	High          string                `json:"high,omitempty"`
+	Low           string                `json:"low,omitempty"`
+	Close         string                `json:"close,omitempty"`
+	Volume        string                `json:"volume,omitempty"`
+	ChangePercent float64               `json:"change_percent"` // 收盘相对开盘的涨跌幅（%）
+	Rows          int64                 `json:"rows"`
+	Missing       int64                 `json:"missing"`
+	Intervals     []IntervalDailyReport `json:"intervals"`
+	Error         string                `json:"error,omitempty"`
+}
+
+// IntervalDailyReport 单个时间间隔当天的采集情况
+type IntervalDailyReport struct {
+	Interval string `json:"interval"`
+	Expected int64  `json:"expected"`
+	Rows     int64  `json:"rows"`
+	Missing  int64  `json:"missing"`
+	Gaps     int    `json:"gaps"` // 连续缺失的段数
+}
+
+// ReportWebhook 发送到webhook的每日报告
+type ReportWebhook struct {
+	Type   string       `json:"type"`
+	Report *DailyReport `json:"report"`
+}
+
+// ReportSent 手动发送报告的结果
+type ReportSent struct {
+	Date string `json:"date"`
+}
+
+// GenerateDailyReport 生成指定日期（配置时区）的报告
+func GenerateDailyReport(cfg *config.Config, day time.Time) *DailyReport {
+	loc := utils.GetShanghaiNow().Location()
+	dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	startUTC := dayStart.UnixMilli()
+	endUTC := dayStart.AddDate(0, 0, 1).UnixMilli()
+
+	updateMutex.Lock()
+	symbols := cfg.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+
+	report := &DailyReport{
+		Date:        dayStart.Format("2006-01-02"),
+		StartTime:   startUTC,
+		EndTime:     endUTC,
+		GeneratedAt: utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		Symbols:     make([]SymbolDailyReport, 0, len(symbols)),
+	}
+	for _, symbol := range symbols {
+		// 从小到大排列，OHLCV使用最小的时间间隔计算
+		intervals := append([]string{}, cfg.Binance.IntervalsFor(symbol)...)
+		sort.SliceStable(intervals, func(i, j int) bool {
+			return getIntervalMilliseconds(intervals[i]) < getIntervalMilliseconds(intervals[j])
+		})
+
+		item := symbolDailyReport(symbol, intervals, startUTC, endUTC)
+		report.TotalRows += item.Rows
+		report.TotalMissing += item.Missing
+		report.Symbols = append(report.Symbols, item)
+	}
+	return report
+}
+
+// symbolDailyReport 统计单个交易对在[startUTC, endUTC)内的行情和采集情况
+func symbolDailyReport(symbol string, intervals []string, startUTC, endUTC int64) SymbolDailyReport {
+	item := SymbolDailyReport{Symbol: symbol, Intervals: []IntervalDailyReport{}}
+
+	var errs []string
+	for _, interval := range intervals {
+		// 第一根开盘时间不早于当天开始的K线
+		first := intervalStart(interval, startUTC)
+		if first < startUTC {
+			first = nextIntervalStart(interval, first)
+		}
+		if first >= endUTC {
+			// 周线、月线等当天没有开盘的K线
+			continue
+		}
+
+		gaps, err := findGaps(symbol, interval, first, endUTC-1)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", interval, err))
+			continue
+		}
+		item.Intervals = append(item.Intervals, IntervalDailyReport{
+			Interval: interval,
+			Expected: gaps.Expected,
+			Rows:     gaps.Present,
+			Missing:  gaps.Missing,
+			Gaps:     len(gaps.Gaps),
+		})
+		item.Rows += gaps.Present
+		item.Missing += gaps.Missing
+
+		if item.Source == "" && gaps.Present > 0 {
+			if err := fillDailyOHLCV(&item, interval, first, endUTC-1); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", interval, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		item.Error = strings.Join(errs, "; ")
+	}
+	return item
+}
+
+// fillDailyOHLCV 用一个时间间隔的K线计算当天的开高低收和成交量
+func fillDailyOHLCV(item *SymbolDailyReport, interval string, startUTC, endUTC int64) error {
+	agg, err := db.GetKlineAggregate(item.Symbol, interval, startUTC, endUTC)
+	if err != nil {
+		return err
+	}
+	first, err := db.QueryKlineRange(item.Symbol, interval, startUTC, endUTC, 1)
+	if err != nil {
+		return err
+	}
+	last, err := db.QueryKlineData(item.Symbol, interval, startUTC, endUTC, 1)
+	if err != nil {
+		return err
+	}
+	if agg.Count == 0 || len(first) == 0 || len(last) == 0 {
+		return nil
+	}
+
+	item.Source = interval
+	item.Open = first[0].Open
+	item.Close = last[0].Close
+	item.High = agg.High
+	item.Low = agg.Low
+	item.Volume = agg.Volume
+
+	open, err1 := strconv.ParseFloat(item.Open, 64)
+	closePrice, err2 := strconv.ParseFloat(item.Close, 64)
+	if err1 == nil && err2 == nil && open > 0 {
+		item.ChangePercent = (closePrice - open) / open * 100
+	}
+	return nil
+}
+
+// dailyReportTemplate 邮件正文
+var dailyReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v float64) string { return fmt.Sprintf("%+.2f%%", v) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>BiUpData 每日报告 {{.Date}}</title></head>
+<body style="font-family: sans-serif; font-size: 14px;">
+<h2>BiUpData 每日报告 {{.Date}}</h2>
+<p>共采集 {{.TotalRows}} 根K线，缺失 {{.TotalMissing}} 根。生成时间：{{.GeneratedAt}}</p>
+<table border="1" cellpadding="6" cellspacing="0" style="border-collapse: collapse;">
+<tr style="background: #f0f0f0;"><th>交易对</th><th>开盘</th><th>最高</th><th>最低</th><th>收盘</th><th>涨跌幅</th><th>成交量</th><th>采集</th><th>缺失</th></tr>
+{{range .Symbols}}<tr>
+<td>{{.Symbol}}</td>
+{{if .Source}}<td>{{.Open}}</td><td>{{.High}}</td><td>{{.Low}}</td><td>{{.Close}}</td>
+<td style="color: {{if lt .ChangePercent 0.0}}#c0392b{{else}}#27ae60{{end}};">{{pct .ChangePercent}}</td><td>{{.Volume}}</td>
+{{else}}<td colspan="6">当天没有数据</td>{{end}}
+<td>{{.Rows}}</td>
+<td{{if .Missing}} style="color: #c0392b;"{{end}}>{{.Missing}}</td>
+</tr>{{end}}
+</table>
+{{range .Symbols}}{{if or .Missing .Error}}
+<h3>{{.Symbol}}</h3>
+<ul>
+{{range .Intervals}}{{if .Missing}}<li>{{.Interval}}：应有 {{.Expected}} 根，缺失 {{.Missing}} 根（{{.Gaps}} 段）</li>{{end}}{{end}}
+{{if .Error}}<li>统计失败：{{.Error}}</li>{{end}}
+</ul>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// renderDailyReport 生成报告的HTML
+func renderDailyReport(report *DailyReport) (string, error) {
+	var buf bytes.Buffer
+	if err := dailyReportTemplate.Execute(&buf, report); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SendDailyReport 生成指定日期的报告并通过配置的邮件和webhook发送
+func SendDailyReport(cfg *config.Config, day time.Time) error {
+	report := GenerateDailyReport(cfg, day)
+
+	var errs []string
+	if cfg.Notify.EmailEnabled() {
+		html, err := renderDailyReport(report)
+		if err == nil {
+			err = utils.SendEmail(&cfg.Notify, "BiUpData 每日报告 "+report.Date, html)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if cfg.Notify.WebhookURL != "" {
+		payload := ReportWebhook{Type: "daily_report", Report: report}
+		if err := utils.PostWebhook(cfg.Notify.WebhookURL, payload); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("发送 %s 每日报告失败: %s", report.Date, strings.Join(errs, "; "))
+	}
+	utils.LogInfo("已发送 %s 每日报告，%d 个交易对，缺失 %d 根K线", report.Date, len(report.Symbols), report.TotalMissing)
+	return nil
+}
+
+// AddReportTask 添加每日报告定时任务，未启用REPORT_ENABLED时不添加
+func AddReportTask(cfg *config.Config) error {
+	if !cfg.Report.Enabled {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("report", cfg.Cron.ReportSchedule, func() error {
+		// 报告前一个完整的自然日
+		err := SendDailyReport(cfg, utils.GetShanghaiNow().AddDate(0, 0, -1))
+		if err != nil {
+			utils.LogError("%v", err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("添加每日报告任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加每日报告任务，cron表达式: %s", cfg.Cron.ReportSchedule)
+	return nil
+}
+
+// reportDate 解析date参数（配置时区的YYYY-MM-DD），默认为前一天，出错时已返回响应
+func reportDate(c *gin.Context) (time.Time, bool) {
+	now := utils.GetShanghaiNow()
+	v := c.Query("date")
+	if v == "" {
+		return now.AddDate(0, 0, -1), true
+	}
+	day, err := time.ParseInLocation("2006-01-02", v, now.Location())
+	if err != nil {
+		badRequest(c, "无效的date参数，格式应为 2006-01-02")
+		return time.Time{}, false
+	}
+	if day.After(now) {
+		badRequest(c, "date不能晚于今天")
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// getDailyReport 预览每日报告，format=html时返回邮件正文
+func getDailyReport(c *gin.Context) {
+	day, ok := reportDate(c)
+	if !ok {
+		return
+	}
+
+	report := GenerateDailyReport(appConfig, day)
+	if c.Query("format") != "html" {
+		respondOK(c, report)
+		return
+	}
+
+	html, err := renderDailyReport(report)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// sendDailyReport 立即发送指定日期的报告
+func sendDailyReport(c *gin.Context) {
+	if !appConfig.Notify.EmailEnabled() && appConfig.Notify.WebhookURL == "" {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未配置邮件或webhook通知")
+		return
+	}
+	day, ok := reportDate(c)
+	if !ok {
+		return
+	}
+
+	if err := SendDailyReport(appConfig, day); err != nil {
+		logRequestError(c, "%v", err)
+		internalError(c, err)
+		return
+	}
+	respondMessage(c, "每日报告已发送", ReportSent{Date: day.Format("2006-01-02")})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 响应码：0表示成功，其余为“HTTP状态码+两位序号”，同一类错误的响应码保持不变，客户端可据此判断
+const (
+	CodeOK           = 0
+	CodeInvalidParam = 40001 // 参数缺失或无效
+	CodeNotEnabled   = 40002 // 功能未启用
+	CodeUnauthorized = 40101 // 缺少令牌或令牌无效
+	CodeForbidden    = 40301 // 角色权限不足
+	CodeNotFound     = 40401
+	CodeConflict     = 40901 // 当前状态不允许该操作
+	CodeRateLimited  = 42901
+	CodeInternal     = 50001
+	CodeTimeout      = 50401 // 数据库查询超过DB_QUERY_TIMEOUT
+)
+
+// 请求ID的请求头和响应头，客户端传入时沿用，否则由服务端生成
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey 请求ID在gin.Context中的键
+const requestIDKey = "request_id"
+
+// 客户端传入的请求ID只接受字母、数字、-、_，避免注入日志
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errConfigNotInitialized = errors.New("配置未初始化")
+
+// Response 所有JSON接口统一的响应结构，data的结构由各接口决定，价格为字符串，时间戳为毫秒整数
+type Response struct {
+	Code      int         `json:"code"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id"`
+}
+
+// requestIDMiddleware 为每个请求分配请求ID并写入响应头
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// newRequestID 生成16位十六进制随机请求ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID 获取当前请求的请求ID
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// respondOK 返回成功响应
+func respondOK(c *gin.Context, data interface{}) {
+	respondMessage(c, "ok", data)
+}
+
+// respondMessage 返回带说明的成功响应
+func respondMessage(c *gin.Context, message string, data interface{}) {
+	c.JSON(http.StatusOK, Response{
+		Code:      CodeOK,
+		Message:   message,
+		Data:      data,
+		RequestID: requestID(c),
+	})
+}
+
+// respondError 返回错误响应并终止后续处理，服务端错误同时记录带请求ID的日志
+func respondError(c *gin.Context, status, code int, message string) {
+	if status >= http.StatusInternalServerError {
+		logRequestError(c, "%s %s 失败: %s", c.Request.Method, c.Request.URL.Path, message)
+	}
+	c.AbortWithStatusJSON(status, Response{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(c),
+	})
+}
+
+// badRequest 返回参数错误
+func badRequest(c *gin.Context, message string) {
+	respondError(c, http.StatusBadRequest, CodeInvalidParam, message)
+}
+
+// internalError 返回服务端错误，数据库查询超时返回504
+func internalError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrQueryTimeout) {
+		respondError(c, http.StatusGatewayTimeout, CodeTimeout, "数据库查询超时，请缩小查询范围或减少limit后重试")
+		return
+	}
+	respondError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// logRequestError 记录带请求ID字段的错误日志，便于按请求ID关联响应和日志
+func logRequestError(c *gin.Context, format string, args ...interface{}) {
+	utils.LogWithFields(utils.LevelError, requestFields(c), format, args...)
+}
+
+// logRequestInfo 记录带请求ID字段的信息日志
+func logRequestInfo(c *gin.Context, format string, args ...interface{}) {
+	utils.LogWithFields(utils.LevelInfo, requestFields(c), format, args...)
+}
+
+// logRequestWarning 记录带请求ID字段的警告日志
+func logRequestWarning(c *gin.Context, format string, args ...interface{}) {
+	utils.LogWithFields(utils.LevelWarning, requestFields(c), format, args...)
+}
+
+// requestFields 当前请求的日志字段
+func requestFields(c *gin.Context) utils.Fields {
+	return utils.Fields{"request_id": requestID(c)}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RetentionResult 单个交易对和时间间隔的清理结果
+type RetentionResult struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	KeepDays int    `json:"keep_days"`
+	Cutoff   string `json:"cutoff"` // 早于该时间（上海时间）的数据过期
+	Expired  int64  `json:"expired"`
+	Deleted  int64  `json:"deleted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunRetention 按保留策略清理过期数据，dryRun为true时只统计不删除
+func RunRetention(cfg *config.Config, dryRun bool) []RetentionResult {
+	var results []RetentionResult
+	var totalExpired, totalDeleted int64
+
+	for _, interval := range cfg.Binance.AllIntervals() {
+		days, ok := cfg.Retention.Policies[interval]
+		if !ok {
+			continue
+		}
+		cutoffUTC := time.Now().AddDate(0, 0, -days).UnixMilli()
+
+		for _, symbol := range cfg.Binance.CurrentSymbols() {
+			if !containsSymbol(cfg.Binance.IntervalsFor(symbol), interval) {
+				continue
+			}
+			result := RetentionResult{
+				Symbol:   symbol,
+				Interval: interval,
+				KeepDays: days,
+				Cutoff:   utils.TimestampToShanghai(cutoffUTC).Format("2006-01-02 15:04:05"),
+			}
+
+			expired, err := db.CountKlinesBefore(symbol, interval, cutoffUTC)
+			if err != nil {
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+			result.Expired = expired
+			totalExpired += expired
+			utils.SetGauge(utils.MetricName("biupdata_retention_expired_rows", "symbol", symbol, "interval", interval), float64(expired))
+
+			if !dryRun && expired > 0 {
+				deleted, err := db.DeleteKlinesBefore(symbol, interval, cutoffUTC)
+				result.Deleted = deleted
+				totalDeleted += deleted
+				utils.AddCounter(utils.MetricName("biupdata_retention_deleted_rows_total", "interval", interval), float64(deleted))
+				if err != nil {
+					result.Error = err.Error()
+				} else {
+					utils.LogInfo("已清理 %s %s 早于 %s 的数据，共 %d 条", symbol, interval, result.Cutoff, deleted)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+
+	if dryRun {
+		utils.LogInfo("数据保留检查（试运行）完成，共 %d 条过期数据", totalExpired)
+	} else {
+		utils.LogInfo("数据保留清理完成，过期 %d 条，删除 %d 条", totalExpired, totalDeleted)
+	}
+	return results
+}
+
+// AddRetentionTask 添加每日清理过期数据的定时任务
+func AddRetentionTask(cfg *config.Config) error {
+	if len(cfg.Retention.Policies) == 0 {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("retention", cfg.Cron.RetentionSchedule, func() error {
+		failed := 0
+		for _, result := range RunRetention(cfg, cfg.Retention.DryRun) {
+			if result.Error != "" {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d 个表清理失败", failed)
+		}
+		return nil
+	})
+	if err != nil {
+		utils.LogError("添加数据清理任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加数据清理任务，cron表达式: %s，试运行: %v", cfg.Cron.RetentionSchedule, cfg.Retention.DryRun)
+	return nil
+}
+
+// AddPartitionTask 添加每日补齐未来月份分区的定时任务，未启用按月分区时不添加
+func AddPartitionTask(cfg *config.Config) error {
+	if !db.PartitioningEnabled() {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("partition", cfg.Cron.PartitionSchedule, func() error {
+		if failed := db.MaintainPartitions(cfg.Binance.CurrentSymbols(), cfg.Binance.IntervalsFor); failed > 0 {
+			return fmt.Errorf("%d 个表维护分区失败", failed)
+		}
+		return nil
+	})
+	if err != nil {
+		utils.LogError("添加分区维护任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加分区维护任务，cron表达式: %s", cfg.Cron.PartitionSchedule)
+	return nil
+}
+
+// RetentionReport 保留策略及各表过期数据统计
+type RetentionReport struct {
+	Policies map[string]int    `json:"policies"`
+	DryRun   bool              `json:"dry_run"`
+	Results  []RetentionResult `json:"results"`
+}
+
+// getRetentionReport 按当前保留策略统计各表的过期数据（试运行，不删除）
+func getRetentionReport(c *gin.Context) {
+	if appConfig == nil {
+		internalError(c, errConfigNotInitialized)
+		return
+	}
+
+	results := RunRetention(appConfig, true)
+	respondOK(c, RetentionReport{
+		Policies: appConfig.Retention.Policies,
+		DryRun:   appConfig.Retention.DryRun,
+		Results:  results,
+	})
+}
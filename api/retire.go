@@ -0,0 +1,161 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// retiredSymbols 本次运行中从采集范围移出的已停止采集的交易对，值为是否属于静态配置的交易对（而非自动发现），
+// 重新上架后据此恢复采集，由updateMutex保护
+var retiredSymbols = make(map[string]bool)
+
+// retireSymbols 将下架或暂停交易的交易对移出采集范围，statuses为交易对到币安状态的映射
+func retireSymbols(cfg *config.Config, statuses map[string]string) {
+	if len(statuses) == 0 {
+		return
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	for symbol, status := range statuses {
+		symbol = strings.ToUpper(symbol)
+		symbols := cfg.Binance.CurrentSymbols()
+		if !containsSymbol(symbols, symbol) {
+			continue
+		}
+
+		if err := db.RetireSymbol(symbol, status); err != nil {
+			continue
+		}
+
+		cfg.Binance.SetSymbols(removeSymbol(symbols, symbol))
+		retiredSymbols[symbol] = pinnedSymbols == nil || containsSymbol(pinnedSymbols, symbol)
+		pinnedSymbols = removeSymbol(pinnedSymbols, symbol)
+		delete(lastUpdateTime, symbol)
+		utils.IncCounter(utils.MetricName("biupdata_symbols_retired_total", "status", status))
+		utils.LogWarning("交易对 %s 状态为 %s，已停止采集", symbol, status)
+
+		if cfg.Binance.ArchiveRetired {
+			if err := db.ArchiveSymbolTables(symbol, cfg.Binance.IntervalsFor(symbol)); err != nil {
+				utils.LogError("归档交易对 %s 数据失败: %v", symbol, err)
+			}
+		}
+	}
+}
+
+// retiredSymbolList 本次运行中已停止采集的交易对
+func retiredSymbolList() []string {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	result := make([]string, 0, len(retiredSymbols))
+	for symbol := range retiredSymbols {
+		result = append(result, symbol)
+	}
+	return result
+}
+
+// unretireSymbols 重新上架（状态恢复为TRADING）的交易对恢复采集：建表（已归档时新建空表并从头回补）并加回采集范围
+func unretireSymbols(cfg *config.Config, symbols []string) {
+	if len(symbols) == 0 {
+		return
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	for _, symbol := range symbols {
+		pinned, ok := retiredSymbols[symbol]
+		if !ok {
+			continue
+		}
+		if err := db.InitAllTables([]string{symbol}, cfg.Binance.IntervalsFor); err != nil {
+			utils.LogError("交易对 %s 恢复采集时建表失败: %v", symbol, err)
+			continue
+		}
+
+		current := cfg.Binance.CurrentSymbols()
+		if !containsSymbol(current, symbol) {
+			cfg.Binance.SetSymbols(append(current, symbol))
+		}
+		if pinned && pinnedSymbols != nil && !containsSymbol(pinnedSymbols, symbol) {
+			pinnedSymbols = append(pinnedSymbols, symbol)
+		}
+		delete(retiredSymbols, symbol)
+		utils.IncCounter("biupdata_symbols_unretired_total")
+		utils.LogInfo("交易对 %s 已恢复交易，重新开始采集", symbol)
+	}
+}
+
+// dropUnknownSymbols 从采集范围中去掉连续多次完整同步中都不存在、且从未同步到元数据的交易对（通常是配置写错），
+// 不记录停止采集标记，修正配置后重启即可采集
+func dropUnknownSymbols(cfg *config.Config, symbols []string) {
+	if len(symbols) == 0 {
+		return
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	current := cfg.Binance.CurrentSymbols()
+	for _, symbol := range symbols {
+		current = removeSymbol(current, symbol)
+		if pinnedSymbols != nil {
+			pinnedSymbols = removeSymbol(pinnedSymbols, symbol)
+		}
+		delete(lastUpdateTime, symbol)
+	}
+	cfg.Binance.SetSymbols(current)
+	utils.AddCounter("biupdata_symbols_unknown_total", float64(len(symbols)))
+	utils.LogError("以下交易对在币安不存在，已从采集范围中移除，请检查 BINANCE_SYMBOLS 配置: %v", symbols)
+}
+
+// ApplyRetiredSymbols 从采集范围中去掉之前已停止采集的交易对，需在建表前调用
+func ApplyRetiredSymbols(cfg *config.Config) error {
+	if err := db.CreateSymbolsTableIfNotExists(); err != nil {
+		return err
+	}
+
+	retired, err := db.GetRetiredSymbols()
+	if err != nil {
+		utils.LogError("查询已停止采集的交易对失败: %v", err)
+		return err
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	for _, symbol := range retired {
+		symbols := cfg.Binance.CurrentSymbols()
+		if containsSymbol(symbols, symbol) {
+			cfg.Binance.SetSymbols(removeSymbol(symbols, symbol))
+			retiredSymbols[strings.ToUpper(symbol)] = true
+			utils.LogInfo("交易对 %s 已停止采集，跳过", symbol)
+		}
+	}
+	return nil
+}
+
+// containsSymbol 判断交易对列表中是否包含指定交易对（不区分大小写）
+func containsSymbol(symbols []string, symbol string) bool {
+	for _, s := range symbols {
+		if strings.EqualFold(s, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeSymbol 返回去掉指定交易对后的新列表（不区分大小写）
+func removeSymbol(symbols []string, symbol string) []string {
+	var result []string
+	for _, s := range symbols {
+		if !strings.EqualFold(s, symbol) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ReturnsResponse 收益率序列，各序列与timestamps一一对齐，无法计算处为null
+type ReturnsResponse struct {
+	Symbol     string     `json:"symbol"`
+	Interval   string     `json:"interval"`
+	Timestamps []int64    `json:"timestamps"`
+	Close      []string   `json:"close"`
+	Returns    []*float64 `json:"returns"`
+	Cumulative []*float64 `json:"cumulative"`
+	LogReturns []*float64 `json:"log_returns,omitempty"`
+	Count      int        `json:"count"`
+}
+
+// computeReturns 计算相邻收盘价的简单收益率，base为区间前一根K线的收盘价，没有时为NaN
+func computeReturns(base float64, closes []float64) []float64 {
+	result := make([]float64, len(closes))
+	prev := base
+	for i, v := range closes {
+		if prev > 0 {
+			result[i] = v/prev - 1
+		} else {
+			result[i] = math.NaN()
+		}
+		prev = v
+	}
+	return result
+}
+
+// computeLogReturns 计算相邻收盘价的对数收益率
+func computeLogReturns(base float64, closes []float64) []float64 {
+	result := make([]float64, len(closes))
+	prev := base
+	for i, v := range closes {
+		if prev > 0 && v > 0 {
+			result[i] = math.Log(v / prev)
+		} else {
+			result[i] = math.NaN()
+		}
+		prev = v
+	}
+	return result
+}
+
+// computeCumulative 计算相对base的累计收益率，base无效时以第一根K线的收盘价为基准
+func computeCumulative(base float64, closes []float64) []float64 {
+	result := make([]float64, len(closes))
+	if base <= 0 && len(closes) > 0 {
+		base = closes[0]
+	}
+	for i, v := range closes {
+		if base > 0 {
+			result[i] = v/base - 1
+		} else {
+			result[i] = math.NaN()
+		}
+	}
+	return result
+}
+
+// getReturns 收益率序列处理函数
+func getReturns(c *gin.Context) {
+	symbol := c.Query("symbol")
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	var startTime, endTime int64
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+
+	series, err := querySeries(symbol, interval, startTime, endTime, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	// 区间前一根K线的收盘价作为基准，使第一根K线也有收益率
+	base := 0.0
+	if len(series) > 0 {
+		firstUTC := utils.StoredTimestampToUTC(series[0].Timestamp)
+		if firstUTC > 0 {
+			prev, err := querySeries(symbol, interval, 0, firstUTC-1, 1)
+			if err != nil {
+				internalError(c, err)
+				return
+			}
+			if len(prev) > 0 {
+				base = prev[0].Close
+			}
+		}
+	}
+
+	closes := seriesCloses(series)
+	closeStrings := make([]string, 0, len(closes))
+	for _, v := range closes {
+		closeStrings = append(closeStrings, formatFloat(v))
+	}
+
+	resp := ReturnsResponse{
+		Symbol:     symbol,
+		Interval:   interval,
+		Timestamps: seriesTimestamps(series),
+		Close:      closeStrings,
+		Returns:    toNullable(computeReturns(base, closes)),
+		Cumulative: toNullable(computeCumulative(base, closes)),
+		Count:      len(series),
+	}
+	if c.Query("log") == "true" {
+		resp.LogReturns = toNullable(computeLogReturns(base, closes))
+	}
+	respondOK(c, resp)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// materializeStats 在数据更新后计算最近updated根K线的滚动统计并写入伴生表
+func materializeStats(symbol, interval string, updated int) {
+	if appConfig == nil || !appConfig.Stats.Materialize || updated <= 0 {
+		return
+	}
+
+	window := appConfig.Stats.Window
+	atrPeriod := appConfig.Stats.ATRPeriod
+
+	// 额外读取一个窗口的历史数据，保证新K线的统计值可以计算
+	warmup := window
+	if atrPeriod*3 > warmup {
+		warmup = atrPeriod * 3
+	}
+	series, err := loadSeries(symbol, interval, 0, 0, updated+warmup+1)
+	if err != nil {
+		utils.LogError("读取 %s %s 数据计算滚动统计失败: %v", symbol, interval, err)
+		return
+	}
+
+	vwap := toNullable(computeVWAP(series, window))
+	volatility := toNullable(computeVolatility(seriesCloses(series), window))
+	atr := toNullable(computeATR(series, atrPeriod))
+
+	from := len(series) - updated
+	if from < 0 {
+		from = 0
+	}
+
+	stats := make([]db.KlineStats, 0, len(series)-from)
+	for i := from; i < len(series); i++ {
+		stats = append(stats, db.KlineStats{
+			Timestamp:  series[i].Timestamp,
+			VWAP:       vwap[i],
+			Volatility: volatility[i],
+			ATR:        atr[i],
+		})
+	}
+
+	if err := db.CreateStatsTableIfNotExists(symbol, interval); err != nil {
+		return
+	}
+	if err := db.SaveKlineStats(symbol, interval, stats); err != nil {
+		return
+	}
+	utils.LogInfo("已更新 %s %s 滚动统计，共 %d 条记录", symbol, interval, len(stats))
+}
+
+// RollingResponse 已物化的滚动统计查询结果
+type RollingResponse struct {
+	Symbol    string                   `json:"symbol"`
+	Interval  string                   `json:"interval"`
+	Window    int                      `json:"window"`
+	ATRPeriod int                      `json:"atr_period"`
+	Stats     []map[string]interface{} `json:"stats"`
+	Count     int                      `json:"count"`
+}
+
+// getRollingStats 查询已物化的滚动统计（VWAP、波动率、ATR）
+func getRollingStats(c *gin.Context) {
+	if appConfig == nil || !appConfig.Stats.Materialize {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未启用滚动统计物化，请设置 STATS_MATERIALIZE=true 或使用 /api/v1/indicators 实时计算")
+		return
+	}
+
+	symbol := c.Query("symbol")
+	interval := c.Query("interval")
+	if symbol == "" || interval == "" {
+		badRequest(c, "缺少必要参数: symbol, interval")
+		return
+	}
+
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	var startTime, endTime int64
+	var err error
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数")
+			return
+		}
+	}
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数")
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		badRequest(c, "无效的limit参数")
+		return
+	}
+
+	data, err := db.GetKlineStats(symbol, interval, startTime, endTime, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	respondOK(c, RollingResponse{
+		Symbol:    symbol,
+		Interval:  interval,
+		Window:    appConfig.Stats.Window,
+		ATRPeriod: appConfig.Stats.ATRPeriod,
+		Stats:     data,
+		Count:     len(data),
+	})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 支持由低级别K线聚合生成的时间间隔，周期边界与币安一致
+var rollupTargetIntervals = map[string]bool{
+	"15m": true, "30m": true,
+	"1h": true, "2h": true, "4h": true, "6h": true, "8h": true, "12h": true,
+	"1d": true, "3d": true, "1w": true, "1M": true,
+}
+
+// 可作为聚合源的时间间隔，需能整除一天
+var rollupSourceIntervals = map[string]bool{
+	"1m": true, "3m": true, "5m": true, "15m": true, "30m": true, "1h": true,
+}
+
+// 每批处理的源K线数量上限
+const rollupBatchSize = 5000
+
+// isRollupTarget 判断时间间隔是否由聚合生成而不是从币安获取
+func isRollupTarget(interval string) bool {
+	if appConfig == nil || !appConfig.Rollup.Enabled {
+		return false
+	}
+	for _, target := range appConfig.Rollup.Targets {
+		if target == interval {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRollupConfig 检查聚合配置是否可用
+func validateRollupConfig() error {
+	if appConfig == nil || !appConfig.Rollup.Enabled {
+		return nil
+	}
+
+	source := appConfig.Rollup.Source
+	if !rollupSourceIntervals[source] {
+		return fmt.Errorf("不支持的聚合源时间间隔: %s", source)
+	}
+
+	for _, target := range appConfig.Rollup.Targets {
+		if !rollupTargetIntervals[target] {
+			return fmt.Errorf("不支持的聚合目标时间间隔: %s", target)
+		}
+		// 1w、1M、3d均由整天组成，固定长度的目标需是源时间间隔的整数倍
+		targetMs := getIntervalMilliseconds(target)
+		sourceMs := getIntervalMilliseconds(source)
+		if target != "1M" && (targetMs <= sourceMs || targetMs%sourceMs != 0) {
+			return fmt.Errorf("聚合目标 %s 必须是源时间间隔 %s 的整数倍", target, source)
+		}
+	}
+	return nil
+}
+
+// runRollups 源时间间隔更新后，重新聚合受影响区间的所有目标时间间隔
+func runRollups(symbol string, updated int) {
+	if appConfig == nil || !appConfig.Rollup.Enabled || updated <= 0 {
+		return
+	}
+
+	source := appConfig.Rollup.Source
+	sourceMs := getIntervalMilliseconds(source)
+
+	// 找到本次更新的最早一根源K线
+	recent, err := loadSeries(symbol, source, 0, 0, updated)
+	if err != nil || len(recent) == 0 {
+		utils.LogError("读取 %s %s 数据进行聚合失败: %v", symbol, source, err)
+		return
+	}
+	fromUTC := utils.StoredTimestampToUTC(recent[0].Timestamp)
+
+	for _, target := range appConfig.Rollup.Targets {
+		count, err := rollupInterval(symbol, source, target, sourceMs, fromUTC)
+		if err != nil {
+			utils.LogError("聚合 %s %s -> %s 失败: %v", symbol, source, target, err)
+			continue
+		}
+		utils.LogInfo("已由 %s %s 聚合生成 %s 数据，共 %d 条记录", symbol, source, target, count)
+	}
+}
+
+// rollupInterval 从fromUTC所在的目标周期开始，将源K线聚合为目标K线
+func rollupInterval(symbol, source, target string, sourceMs, fromUTC int64) (int, error) {
+	if err := db.CreateTableIfNotExists(symbol, target); err != nil {
+		return 0, err
+	}
+
+	nowUTC := time.Now().UnixMilli()
+
+	// 每批处理若干个完整的目标周期
+	bucketsPerBatch := int(rollupBatchSize * sourceMs / getIntervalMilliseconds(target))
+	if bucketsPerBatch < 1 {
+		bucketsPerBatch = 1
+	}
+
+	saved := 0
+	for start := intervalStart(target, fromUTC); start <= nowUTC; {
+		end := start
+		for i := 0; i < bucketsPerBatch; i++ {
+			end = nextIntervalStart(target, end)
+		}
+
+		series, err := loadSeries(symbol, source, start, end-1, int(countIntervalBars(source, start, end))+1)
+		if err != nil {
+			return saved, err
+		}
+
+		count, err := saveRollupBuckets(symbol, target, sourceMs, nowUTC, series)
+		saved += count
+		if err != nil {
+			return saved, err
+		}
+		start = end
+	}
+	return saved, nil
+}
+
+// saveRollupBuckets 按目标周期分组聚合并在一个事务中保存，开盘取首根、收盘取末根、最高最低取极值、成交量求和
+func saveRollupBuckets(symbol, target string, sourceMs, nowUTC int64, series []ohlcv) (int, error) {
+	var records []db.KlineRecord
+	for i := 0; i < len(series); {
+		bucket := intervalStart(target, utils.StoredTimestampToUTC(series[i].Timestamp))
+		bucketEnd := nextIntervalStart(target, bucket)
+
+		agg := series[i]
+		n := int64(1)
+		j := i + 1
+		for ; j < len(series); j++ {
+			if utils.StoredTimestampToUTC(series[j].Timestamp) >= bucketEnd {
+				break
+			}
+			agg.High = math.Max(agg.High, series[j].High)
+			agg.Low = math.Min(agg.Low, series[j].Low)
+			agg.Close = series[j].Close
+			agg.Volume += series[j].Volume
+			n++
+		}
+		i = j
+
+		// 配置不保存未收盘的K线时，尚未结束的周期等结束后再聚合
+		if bucketEnd > nowUTC && appConfig != nil && appConfig.Binance.SkipOpenCandle {
+			continue
+		}
+
+		// 已结束的周期缺少源K线时聚合结果不完整，记录警告但仍然保存
+		if expected := (bucketEnd - bucket) / sourceMs; n < expected && bucketEnd <= nowUTC {
+			utils.LogWarning("%s %s 周期 %s 只有 %d/%d 根源K线", symbol, target,
+				utils.TimestampToShanghai(bucket).Format("2006-01-02 15:04"), n, expected)
+		}
+
+		records = append(records, db.KlineRecord{
+			Symbol:    symbol,
+			Interval:  target,
+			Timestamp: bucket,
+			Open:      formatFloat(agg.Open),
+			Close:     formatFloat(agg.Close),
+			High:      formatFloat(agg.High),
+			Low:       formatFloat(agg.Low),
+			Volume:    formatFloat(agg.Volume),
+		})
+	}
+
+	if err := db.SaveKlineBatch(context.Background(), symbol, target, records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// formatFloat 将浮点数格式化为数据库可接受的十进制字符串
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 8, 64)
+}
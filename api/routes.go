@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 网络线路名称
+const (
+	routeDirect = "direct" // 直接连接
+	routeRelay  = "relay"  // URL前缀中转（BINANCE_PROXY_URL）
+	routeAuto   = "auto"   // 按探测结果自动选择
+)
+
+// 保留的探测记录轮数
+const maxProbeHistory = 100
+
+// 新线路比当前线路快超过该比例时才切换，避免在延迟接近的线路间频繁切换
+const routeSwitchRatio = 0.8
+
+// networkRoute 访问币安API的一条线路：直接连接、URL前缀中转或某个标准代理
+type networkRoute struct {
+	Name     string
+	client   *http.Client
+	prefix   string // URL前缀中转地址
+	proxyIdx int    // 标准代理下标，-1表示不是代理线路
+}
+
+// routeProbe 单条线路的一次探测结果
+type routeProbe struct {
+	Route     string `json:"route"`
+	Success   bool   `json:"success"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// probeRound 一轮探测的结果
+type probeRound struct {
+	Time     time.Time    `json:"time"`
+	Results  []routeProbe `json:"results"`
+	Selected string       `json:"selected"`
+}
+
+var (
+	networkRoutes []*networkRoute
+	activeRoute   string
+	routeMode     = routeAuto // auto 或固定使用的线路名称
+	lastProbe     map[string]routeProbe
+	probeHistory  []probeRound
+	routeMu       sync.Mutex
+	probeStop     chan struct{}
+)
+
+// initNetworkRoutes 根据配置生成全部线路，需在初始化标准代理之后调用
+func initNetworkRoutes(cfg *config.BinanceConfig) {
+	routes := []*networkRoute{{
+		Name:     routeDirect,
+		client:   utils.HTTPClient(),
+		proxyIdx: -1,
+	}}
+	if cfg.ProxyURL != "" {
+		routes = append(routes, &networkRoute{
+			Name:     routeRelay,
+			client:   utils.HTTPClient(),
+			prefix:   cfg.ProxyURL,
+			proxyIdx: -1,
+		})
+	}
+	for i, ep := range getProxyStatus() {
+		routes = append(routes, &networkRoute{
+			Name:     "proxy:" + ep.Name,
+			client:   proxyClient(i),
+			proxyIdx: i,
+		})
+	}
+
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	networkRoutes = routes
+	lastProbe = make(map[string]routeProbe)
+	activeRoute = routeDirect
+	if cfg.UseProxy && len(routes) > 1 {
+		activeRoute = routes[1].Name
+	}
+	syncUseProxyLocked()
+}
+
+// do 通过该线路发送请求
+func (r *networkRoute) do(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.prefix+url, nil)
+	if err != nil {
+		return nil, err
+	}
+	setBinanceHeaders(req)
+
+	resp, err := r.client.Do(req)
+	if r.proxyIdx >= 0 {
+		markProxyResult(r.proxyIdx, err)
+	}
+	if err == nil {
+		recordWeight(r.Name, resp)
+	}
+	return resp, err
+}
+
+// setBinanceHeaders 设置User-Agent，配置了API密钥时附加X-MBX-APIKEY
+func setBinanceHeaders(req *http.Request) {
+	if appConfig == nil {
+		return
+	}
+	if ua := appConfig.Binance.UserAgent; ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+	if key := appConfig.Binance.APIKey; key != "" {
+		req.Header.Set("X-MBX-APIKEY", key)
+	}
+}
+
+// routeOrder 返回本次请求依次尝试的线路：当前线路优先，自动模式下其余最近探测成功的线路按延迟排在后面
+func routeOrder() []*networkRoute {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	var active *networkRoute
+	var others []*networkRoute
+	for _, r := range networkRoutes {
+		if r.Name == activeRoute {
+			active = r
+		} else if routeMode == routeAuto && lastProbe[r.Name].Success {
+			others = append(others, r)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return lastProbe[others[i].Name].LatencyMs < lastProbe[others[j].Name].LatencyMs
+	})
+
+	if active == nil {
+		return others
+	}
+	return append([]*networkRoute{active}, others...)
+}
+
+// sendBinanceRequest 通过当前线路发送请求，自动模式下当前线路连接失败时改用其他可用线路
+func sendBinanceRequest(url string) (*http.Response, error) {
+	order := routeOrder()
+	if len(order) == 0 {
+		return nil, fmt.Errorf("没有可用的网络线路")
+	}
+
+	var lastErr error
+	for i, r := range order {
+		utils.LogInfo("通过 %s 请求币安API: %s", r.Name, redactURL(url))
+		resp, err := r.do(context.Background(), url)
+		err = redactError(err)
+		if err == nil {
+			if i > 0 {
+				setActiveRoute(r.Name, "当前线路请求失败")
+			}
+			return resp, nil
+		}
+		utils.LogWarning("通过 %s 请求币安API失败: %v", r.Name, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// probeRoutes 探测全部线路的延迟和可用性，自动模式下选择最快的可用线路，返回是否有线路可用
+func probeRoutes() bool {
+	routeMu.Lock()
+	routes := networkRoutes
+	routeMu.Unlock()
+
+	if len(routes) == 0 || appConfig == nil {
+		return false
+	}
+
+	url := fmt.Sprintf("%s/api/v3/ticker/price?symbol=%s", currentBaseURL(), appConfig.Binance.TestSymbol)
+	utils.LogInfo("探测币安API线路: %s", url)
+
+	// 各线路并行探测
+	results := make([]routeProbe, len(routes))
+	var wg sync.WaitGroup
+	for i, r := range routes {
+		wg.Add(1)
+		go func(i int, r *networkRoute) {
+			defer wg.Done()
+			defer utils.Recover("route_probe", nil)
+			results[i] = probeRoute(r, url)
+		}(i, r)
+	}
+	wg.Wait()
+
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	anyOK := false
+	for _, p := range results {
+		lastProbe[p.Route] = p
+		anyOK = anyOK || p.Success
+		if p.Success {
+			utils.SetGauge(utils.MetricName("biupdata_route_latency_ms", "route", p.Route), float64(p.LatencyMs))
+		} else {
+			utils.IncCounter(utils.MetricName("biupdata_route_probe_failures_total", "route", p.Route))
+		}
+	}
+
+	if routeMode == routeAuto {
+		selectFastestRouteLocked(results)
+	}
+
+	probeHistory = append(probeHistory, probeRound{
+		Time:     utils.GetShanghaiNow(),
+		Results:  results,
+		Selected: activeRoute,
+	})
+	if len(probeHistory) > maxProbeHistory {
+		probeHistory = probeHistory[len(probeHistory)-maxProbeHistory:]
+	}
+	return anyOK
+}
+
+// probeRoute 探测单条线路
+func probeRoute(r *networkRoute, url string) routeProbe {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := r.do(ctx, url)
+	result := routeProbe{Route: r.Name, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// selectFastestRouteLocked 按探测结果选择最快的可用线路，调用方需持有routeMu
+func selectFastestRouteLocked(results []routeProbe) {
+	var best *routeProbe
+	for i := range results {
+		if results[i].Success && (best == nil || results[i].LatencyMs < best.LatencyMs) {
+			best = &results[i]
+		}
+	}
+	if best == nil || best.Route == activeRoute {
+		return
+	}
+
+	current, ok := lastProbe[activeRoute]
+	if ok && current.Success && float64(best.LatencyMs) >= float64(current.LatencyMs)*routeSwitchRatio {
+		return
+	}
+
+	utils.LogInfo("网络线路从 %s 切换到 %s（延迟 %dms）", activeRoute, best.Route, best.LatencyMs)
+	activeRoute = best.Route
+	syncUseProxyLocked()
+}
+
+// setActiveRoute 切换当前线路
+func setActiveRoute(name, reason string) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	if activeRoute != name {
+		utils.LogInfo("网络线路从 %s 切换到 %s：%s", activeRoute, name, reason)
+		activeRoute = name
+		syncUseProxyLocked()
+	}
+}
+
+// setRouteMode 设置线路选择模式，mode为auto或某条线路的名称
+func setRouteMode(mode string) error {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	if mode == routeAuto {
+		routeMode = routeAuto
+		return nil
+	}
+
+	for _, r := range networkRoutes {
+		if r.Name == mode {
+			routeMode = mode
+			activeRoute = mode
+			syncUseProxyLocked()
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的网络线路: %s", mode)
+}
+
+// firstIndirectRoute 获取第一条非直接连接的线路名称
+func firstIndirectRoute() (string, bool) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	for _, r := range networkRoutes {
+		if r.Name != routeDirect {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// syncUseProxyLocked 同步旧的代理模式标记，调用方需持有routeMu
+func syncUseProxyLocked() {
+	if appConfig != nil {
+		appConfig.Binance.UseProxy = activeRoute != routeDirect
+	}
+}
+
+// getRouteStatus 获取线路选择模式、当前线路和各线路最近一次探测结果
+func getRouteStatus() (string, string, []routeProbe) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	routes := make([]routeProbe, 0, len(networkRoutes))
+	for _, r := range networkRoutes {
+		p, ok := lastProbe[r.Name]
+		if !ok {
+			p = routeProbe{Route: r.Name}
+		}
+		routes = append(routes, p)
+	}
+	return routeMode, activeRoute, routes
+}
+
+// getProbeHistory 获取最近limit轮探测记录，按时间倒序
+func getProbeHistory(limit int) []probeRound {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	if limit <= 0 || limit > len(probeHistory) {
+		limit = len(probeHistory)
+	}
+	result := make([]probeRound, 0, limit)
+	for i := len(probeHistory) - 1; i >= len(probeHistory)-limit; i-- {
+		result = append(result, probeHistory[i])
+	}
+	return result
+}
+
+// StartNetworkProbe 启动定期线路探测
+func StartNetworkProbe(cfg *config.BinanceConfig) {
+	if cfg.ProbeInterval <= 0 || probeStop != nil || replaying(defaultUpdater.Exchange) {
+		return
+	}
+	probeStop = make(chan struct{})
+	interval := time.Duration(cfg.ProbeInterval) * time.Second
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				utils.Safe("route_probe", func() { probeRoutes() })
+			}
+		}
+	}(probeStop)
+
+	utils.LogInfo("已启动网络线路探测，间隔: %v", interval)
+}
+
+// StopNetworkProbe 停止定期线路探测
+func StopNetworkProbe() {
+	if probeStop == nil {
+		return
+	}
+	close(probeStop)
+	probeStop = nil
+}
+
+// networkProbeRunning 是否在定期探测线路
+func networkProbeRunning() bool {
+	return probeStop != nil
+}
@@ -1,30 +1,42 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
 	"github.com/ganlian2020AI/biupdata/utils"
 	"github.com/robfig/cron/v3"
 )
 
+// 每个定时任务保留的运行记录数量
+const taskHistorySize = 20
+
 var (
 	scheduler          *cron.Cron
-	jobIDs             map[string]cron.EntryID
+	tasks              []*scheduledTask // 已注册的定时任务，按注册顺序
+	taskMutex          sync.Mutex
 	updateMutex        sync.Mutex
 	lastUpdateTime     map[string]map[string]time.Time // 记录每个交易对和时间间隔的最后更新时间
 	lastConnCheck      time.Time                       // 上次连接检查时间
 	isSchedulerRunning bool                            // 定时器是否正在运行
+	schedulerEnabled   = true                          // 操作员期望的运行状态，手动停止后为false，由updateMutex保护
+	delayedSymbols     = make(map[string]bool)         // 已安排、正在等待相位偏移的交易对，由updateMutex保护
 )
 
 // InitScheduler 初始化定时任务调度器
 func InitScheduler() {
 	scheduler = cron.New(cron.WithSeconds())
-	jobIDs = make(map[string]cron.EntryID)
+	tasks = nil
 	lastUpdateTime = make(map[string]map[string]time.Time)
+	delayedSymbols = make(map[string]bool)
 	lastConnCheck = time.Time{} // 初始化为零值，确保首次运行时会检查连接
-	isSchedulerRunning = true   // 默认为启动状态
+	isSchedulerRunning = false  // 调用StartScheduler后才开始运行
 }
 
 // StartScheduler 启动定时任务调度器
@@ -33,6 +45,7 @@ func StartScheduler() {
 		scheduler.Start()
 		isSchedulerRunning = true
 		utils.LogInfo("定时任务调度器已启动")
+		startMaintenanceWatcher()
 	}
 }
 
@@ -50,6 +63,211 @@ func IsSchedulerRunning() bool {
 	return isSchedulerRunning
 }
 
+// TaskRun 定时任务的一次运行记录
+type TaskRun struct {
+	StartedAt  string `json:"started_at"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// scheduledTask 已注册的定时任务及最近的运行记录
+type scheduledTask struct {
+	name     string
+	spec     string
+	run      func() error
+	entryID  cron.EntryID
+	running  bool
+	missed   bool // 维护窗口内错过了运行，窗口结束后补跑
+	runCount int64
+	history  []TaskRun // 环形缓冲，最多taskHistorySize条
+	next     int       // 下一条记录写入的位置
+}
+
+// record 保存一次运行记录
+func (t *scheduledTask) record(run TaskRun) {
+	if len(t.history) < taskHistorySize {
+		t.history = append(t.history, run)
+	} else {
+		t.history[t.next] = run
+	}
+	t.next = (t.next + 1) % taskHistorySize
+	t.runCount++
+}
+
+// recentRuns 按时间倒序返回运行记录
+func (t *scheduledTask) recentRuns() []TaskRun {
+	result := make([]TaskRun, 0, len(t.history))
+	for i := 1; i <= len(t.history); i++ {
+		result = append(result, t.history[(t.next-i+len(t.history))%len(t.history)])
+	}
+	return result
+}
+
+// addScheduledTask 注册定时任务，记录每次运行的开始时间、耗时和错误；维护窗口内跳过，窗口结束后补跑一次
+func addScheduledTask(name, spec string, run func() error) error {
+	task := &scheduledTask{name: name, spec: spec, run: run}
+
+	id, err := scheduler.AddFunc(spec, func() {
+		if inMaintenance() {
+			taskMutex.Lock()
+			task.missed = true
+			taskMutex.Unlock()
+			return
+		}
+		task.execute()
+	})
+	if err != nil {
+		return err
+	}
+
+	task.entryID = id
+	taskMutex.Lock()
+	tasks = append(tasks, task)
+	taskMutex.Unlock()
+	return nil
+}
+
+// execute 运行一次定时任务并记录结果
+func (t *scheduledTask) execute() {
+	taskMutex.Lock()
+	t.running = true
+	t.missed = false
+	taskMutex.Unlock()
+
+	start := time.Now()
+	err := func() (err error) {
+		// panic时按失败记录，不影响后续调度
+		defer utils.Recover("task:"+t.name, &err)
+		return t.run()
+	}()
+	duration := time.Since(start)
+
+	result := TaskRun{
+		StartedAt:  utils.UTCToShanghai(start).Format("2006-01-02 15:04:05"),
+		DurationMs: duration.Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		utils.IncCounter(utils.MetricName("biupdata_task_failures_total", "task", t.name))
+	}
+	utils.SetGauge(utils.MetricName("biupdata_task_last_duration_seconds", "task", t.name), duration.Seconds())
+
+	taskMutex.Lock()
+	t.running = false
+	t.record(result)
+	taskMutex.Unlock()
+}
+
+// TaskStatus 定时任务的调度信息及最近运行情况
+type TaskStatus struct {
+	Name           string    `json:"name"`
+	Schedule       string    `json:"schedule"`
+	Running        bool      `json:"running"`
+	NextRun        string    `json:"next_run,omitempty"` // 调度器未运行时为空
+	LastRun        string    `json:"last_run,omitempty"`
+	LastDurationMs int64     `json:"last_duration_ms"`
+	LastError      string    `json:"last_error,omitempty"`
+	RunCount       int64     `json:"run_count"`
+	Missed         bool      `json:"missed"` // 维护窗口内错过了运行，等待窗口结束后补跑
+	History        []TaskRun `json:"history"`
+}
+
+// taskStatuses 获取所有定时任务的状态
+func taskStatuses() []TaskStatus {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+
+	result := make([]TaskStatus, 0, len(tasks))
+	for _, task := range tasks {
+		status := TaskStatus{
+			Name:     task.name,
+			Schedule: task.spec,
+			Running:  task.running,
+			RunCount: task.runCount,
+			Missed:   task.missed,
+			History:  task.recentRuns(),
+		}
+		if len(status.History) > 0 {
+			last := status.History[0]
+			status.LastRun = last.StartedAt
+			status.LastDurationMs = last.DurationMs
+			status.LastError = last.Error
+		}
+		if next := scheduler.Entry(task.entryID).Next; isSchedulerRunning && !next.IsZero() {
+			status.NextRun = utils.UTCToShanghai(next).Format("2006-01-02 15:04:05")
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// 运行时设置中保存定时任务状态的键
+const (
+	settingSchedulerEnabled = "scheduler.enabled"
+	settingPausedSeries     = "scheduler.paused"
+)
+
+// RestoreSchedulerState 从数据库恢复操作员设置的定时任务状态和暂停记录，使手动停止在重启后仍然有效
+func RestoreSchedulerState() error {
+	value, ok, err := db.GetSetting(settingSchedulerEnabled)
+	if err != nil {
+		return err
+	}
+	enabled := !ok || value != "false"
+
+	var paused []PausedSeries
+	value, ok, err = db.GetSetting(settingPausedSeries)
+	if err != nil {
+		return err
+	}
+	if ok && value != "" {
+		if err := json.Unmarshal([]byte(value), &paused); err != nil {
+			return fmt.Errorf("解析暂停记录失败: %v", err)
+		}
+	}
+
+	updateMutex.Lock()
+	schedulerEnabled = enabled
+	pausedSeries = make(map[string]PausedSeries, len(paused))
+	for _, p := range paused {
+		pausedSeries[pauseKey(p.Symbol, p.Interval)] = p
+	}
+	updateMutex.Unlock()
+
+	utils.SetGauge("biupdata_paused_series", float64(len(paused)))
+	if !enabled {
+		utils.LogInfo("定时任务已被手动停止，保持停止状态")
+	}
+	if len(paused) > 0 {
+		utils.LogInfo("已恢复 %d 条暂停记录", len(paused))
+	}
+	return nil
+}
+
+// SchedulerEnabled 操作员是否期望定时任务运行
+func SchedulerEnabled() bool {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	return schedulerEnabled
+}
+
+// setSchedulerEnabled 记录并持久化操作员期望的运行状态
+func setSchedulerEnabled(enabled bool) error {
+	updateMutex.Lock()
+	schedulerEnabled = enabled
+	updateMutex.Unlock()
+	return db.SaveSetting(settingSchedulerEnabled, strconv.FormatBool(enabled))
+}
+
+// savePausedSeries 持久化暂停记录
+func savePausedSeries(paused []PausedSeries) error {
+	data, err := json.Marshal(paused)
+	if err != nil {
+		return err
+	}
+	return db.SaveSetting(settingPausedSeries, string(data))
+}
+
 // AddUpdateTask 添加数据更新定时任务
 func AddUpdateTask(cfg *config.Config) error {
 	if scheduler == nil {
@@ -58,8 +276,10 @@ func AddUpdateTask(cfg *config.Config) error {
 
 	// 使用配置文件中的cron表达式
 	utils.LogInfo("使用cron表达式: %s", cfg.Cron.UpdateSchedule)
-	_, err := scheduler.AddFunc(cfg.Cron.UpdateSchedule, func() {
+	err := addScheduledTask("update", cfg.Cron.UpdateSchedule, func() error {
+		// 各交易对的更新在后台进行，失败时由下一次检查重试
 		checkAndUpdateData(cfg)
+		return nil
 	})
 
 	if err != nil {
@@ -76,15 +296,20 @@ func checkAndUpdateData(cfg *config.Config) {
 	updateMutex.Lock()
 	defer updateMutex.Unlock()
 
-	// 每10分钟检查一次网络连接状态
-	if time.Since(lastConnCheck) > 10*time.Minute {
+	// 未启用定期线路探测时，每10分钟检查一次网络连接状态
+	if !networkProbeRunning() && time.Since(lastConnCheck) > 10*time.Minute {
 		utils.LogInfo("定期检查币安API连接状态...")
 		CheckBinanceConnection()
 		lastConnCheck = time.Now()
 	}
 
 	// 遍历所有交易对
-	for _, symbol := range cfg.Binance.Symbols {
+	for _, symbol := range cfg.Binance.CurrentSymbols() {
+		// 上一轮安排的更新还在等待相位偏移
+		if delayedSymbols[symbol] {
+			continue
+		}
+
 		// 确保该交易对的时间记录存在
 		if _, exists := lastUpdateTime[symbol]; !exists {
 			lastUpdateTime[symbol] = make(map[string]time.Time)
@@ -94,7 +319,22 @@ func checkAndUpdateData(cfg *config.Config) {
 		var intervalsToUpdate []string
 
 		// 检查每个时间间隔是否需要更新
-		for _, interval := range cfg.Binance.Intervals {
+		for _, interval := range cfg.Binance.IntervalsFor(symbol) {
+			// 聚合生成的时间间隔随源时间间隔一起更新
+			if isRollupTarget(interval) {
+				continue
+			}
+
+			// 手动暂停的交易对/时间间隔
+			if isPaused(symbol, interval) {
+				continue
+			}
+
+			// 还在追赶队列中等待的项由追赶按优先级处理
+			if catchupQueued(symbol, interval) {
+				continue
+			}
+
 			lastUpdate, exists := lastUpdateTime[symbol][interval]
 
 			// 如果没有更新记录或者已经到了更新时间
@@ -105,20 +345,46 @@ func checkAndUpdateData(cfg *config.Config) {
 
 		// 如果有需要更新的时间间隔
 		if len(intervalsToUpdate) > 0 {
-			utils.LogInfo("开始更新 %s 的数据，时间间隔: %v", symbol, intervalsToUpdate)
+			// 按交易对的固定相位错开开始时间，避免所有交易对在同一秒请求币安和写入数据库
+			delay := updatePhase(symbol, cfg.Cron.UpdateSpread)
+			if delay > 0 {
+				delayedSymbols[symbol] = true
+				utils.LogInfo("%v 后开始更新 %s 的数据，时间间隔: %v", delay, symbol, intervalsToUpdate)
+			} else {
+				utils.LogInfo("开始更新 %s 的数据，时间间隔: %v", symbol, intervalsToUpdate)
+			}
 
 			// 异步更新数据
 			go func(s string, intervals []string) {
+				defer utils.Recover("scheduled_update", nil)
+
+				if delay > 0 {
+					time.Sleep(delay)
+					updateMutex.Lock()
+					delete(delayedSymbols, s)
+					updateMutex.Unlock()
+
+					// 等待期间调度器被停止或进入维护窗口，下一次检查时重新安排
+					if !IsSchedulerRunning() || inMaintenance() {
+						return
+					}
+				}
+
+				// 部分时间间隔失败时仍记录成功的时间间隔，失败的下次检查时重试
 				results, err := UpdateSymbolData(s, intervals)
 				if err != nil {
 					utils.LogError("更新 %s 数据失败: %v", s, err)
-					return
 				}
 
 				// 更新最后更新时间
 				updateMutex.Lock()
 				defer updateMutex.Unlock()
 
+				// 更新期间交易对可能已被移出采集范围
+				if _, exists := lastUpdateTime[s]; !exists {
+					return
+				}
+
 				for interval, count := range results {
 					lastUpdateTime[s][interval] = time.Now().UTC()
 					utils.LogInfo("定时任务: %s %s 数据更新完成，共 %d 条记录", s, interval, count)
@@ -127,3 +393,14 @@ func checkAndUpdateData(cfg *config.Config) {
 		}
 	}
 }
+
+// updatePhase 交易对在错开窗口中的固定相位：由交易对名称的哈希决定，每次调度都相同，
+// 各交易对大致均匀地分布在[0, spread)秒内
+func updatePhase(symbol string, spread int) time.Duration {
+	if spread <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return time.Duration(h.Sum32()%uint32(spread*1000)) * time.Millisecond
+}
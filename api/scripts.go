@@ -0,0 +1,380 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// 对所有交易对生效的脚本文件名，先于交易对自己的脚本执行
+const allSymbolsScript = "_all.lua"
+
+// 加载脚本和单次调用transform的执行期限，避免脚本死循环卡住更新和热加载
+const scriptCallTimeout = time.Second
+
+// luaScript 一个已编译的脚本，Lua虚拟机不能并发使用，调用时加锁
+type luaScript struct {
+	file   string
+	mu     sync.Mutex
+	state  *lua.LState
+	fn     *lua.LFunction
+	closed bool // 已被新版本替换或删除，正在执行的更新不再使用
+}
+
+// ScriptStatus 脚本目录中一个脚本的加载状态
+type ScriptStatus struct {
+	File     string `json:"file"`
+	Symbol   string `json:"symbol"` // 生效的交易对，*表示全部
+	LoadedAt string `json:"loaded_at,omitempty"`
+	Error    string `json:"error,omitempty"` // 最近一次加载失败的原因，失败时继续使用上次加载成功的版本
+}
+
+// scriptHook 在写入前用脚本目录中的Lua脚本加工K线，按文件修改时间热加载
+type scriptHook struct {
+	dir      string
+	interval time.Duration
+
+	mu        sync.Mutex
+	scripts   map[string]*luaScript // 生效的交易对（大写，*表示全部） -> 脚本
+	attempted map[string]time.Time  // 文件名 -> 最近一次尝试加载时的修改时间，加载失败的文件修改后才重试
+	statuses  map[string]ScriptStatus
+	checkedAt time.Time
+}
+
+var (
+	scriptMutex  sync.Mutex
+	activeScript *scriptHook
+)
+
+// initScripts 配置了脚本目录时加载全部脚本并返回对应的扩展，未配置时返回nil
+func initScripts(cfg *config.ScriptsConfig) (*scriptHook, error) {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	if activeScript != nil {
+		activeScript.close()
+		activeScript = nil
+	}
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(cfg.Dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("脚本目录 %s 不存在", cfg.Dir)
+	}
+
+	h := &scriptHook{
+		dir:       cfg.Dir,
+		interval:  time.Duration(cfg.ReloadInterval) * time.Second,
+		scripts:   make(map[string]*luaScript),
+		attempted: make(map[string]time.Time),
+		statuses:  make(map[string]ScriptStatus),
+	}
+	h.reload(true)
+	activeScript = h
+	utils.LogInfo("已加载脚本目录 %s，共 %d 个脚本", cfg.Dir, len(h.scripts))
+	return h, nil
+}
+
+// Name 扩展名称
+func (h *scriptHook) Name() string {
+	return "scripts"
+}
+
+// OnCandleFetched 依次执行_all.lua和交易对的脚本，脚本返回nil或false的K线不写入
+func (h *scriptHook) OnCandleFetched(symbol, interval string, klines []db.Candle) ([]db.Candle, error) {
+	h.reload(false)
+
+	h.mu.Lock()
+	var scripts []*luaScript
+	for _, key := range []string{"*", strings.ToUpper(symbol)} {
+		if s := h.scripts[key]; s != nil {
+			scripts = append(scripts, s)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range scripts {
+		var err error
+		if klines, err = s.apply(symbol, interval, klines); err != nil {
+			return nil, fmt.Errorf("脚本 %s: %v", s.file, err)
+		}
+	}
+	return klines, nil
+}
+
+// OnCandleStored 脚本只在写入前执行
+func (h *scriptHook) OnCandleStored(symbol, interval string, klines []db.Candle) error {
+	return nil
+}
+
+// reload 距上次检查超过热加载间隔（或force）时重新扫描目录，加载新增和修改过的脚本，移除已删除的脚本；
+// 交易对不区分大小写，多个文件对应同一交易对时（如 btcusdt.lua 和 BTCUSDT.lua）只加载按文件名排序的第一个
+func (h *scriptHook) reload(force bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !force && (h.interval <= 0 || time.Since(h.checkedAt) < h.interval) {
+		return
+	}
+	h.checkedAt = time.Now()
+
+	files, err := filepath.Glob(filepath.Join(h.dir, "*.lua"))
+	if err != nil {
+		utils.LogError("扫描脚本目录 %s 失败: %v", h.dir, err)
+		return
+	}
+
+	seen := make(map[string]bool, len(files))
+	owners := make(map[string]string, len(files)) // 交易对 -> 本次扫描中对应的文件
+	for _, path := range files {
+		name := filepath.Base(path)
+		seen[name] = true
+		key := scriptSymbol(name)
+		if owner, ok := owners[key]; ok {
+			// 不记录尝试时间，先加载的文件删除后下次扫描即可加载
+			delete(h.attempted, name)
+			h.statuses[name] = ScriptStatus{File: name, Symbol: key, Error: fmt.Sprintf("与 %s 对应同一交易对，已忽略", owner)}
+			continue
+		}
+		owners[key] = name
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last, ok := h.attempted[name]; ok && last.Equal(info.ModTime()) {
+			continue
+		}
+		h.attempted[name] = info.ModTime()
+
+		status := ScriptStatus{File: name, Symbol: key}
+		s, err := compileScript(path)
+		if err != nil {
+			utils.LogError("加载脚本 %s 失败: %v", name, err)
+			status.Error = err.Error()
+			if old, ok := h.statuses[name]; ok {
+				status.LoadedAt = old.LoadedAt
+			}
+			h.statuses[name] = status
+			continue
+		}
+		if old := h.scripts[key]; old != nil {
+			old.close()
+			utils.LogInfo("已重新加载脚本 %s", name)
+		}
+		h.scripts[key] = s
+		status.LoadedAt = utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+		h.statuses[name] = status
+	}
+
+	for name := range h.statuses {
+		if seen[name] {
+			continue
+		}
+		key := scriptSymbol(name)
+		if s := h.scripts[key]; s != nil && s.file == name {
+			s.close()
+			delete(h.scripts, key)
+			utils.LogInfo("脚本 %s 已删除，停止执行", name)
+		}
+		delete(h.attempted, name)
+		delete(h.statuses, name)
+	}
+}
+
+// status 返回各脚本的加载状态，按文件名排序
+func (h *scriptHook) status() []ScriptStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]ScriptStatus, 0, len(h.statuses))
+	for _, st := range h.statuses {
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].File < result[j].File })
+	return result
+}
+
+// close 释放全部脚本
+func (h *scriptHook) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.scripts {
+		s.close()
+	}
+	h.scripts = nil
+}
+
+// scriptSymbol 脚本文件名对应的交易对（大写），_all.lua 为*
+func scriptSymbol(name string) string {
+	if strings.EqualFold(name, allSymbolsScript) {
+		return "*"
+	}
+	return strings.ToUpper(strings.TrimSuffix(name, ".lua"))
+}
+
+// compileScript 在只开放基础库、string、table、math的虚拟机中执行脚本，脚本需定义全局函数transform
+func compileScript(path string) (*luaScript, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := L.CallByParam(lua.P{Fn: L.NewFunction(lib.open), NRet: 0, Protect: true}, lua.LString(lib.name)); err != nil {
+			L.Close()
+			return nil, err
+		}
+	}
+	// 不允许脚本读取其他文件
+	for _, name := range []string{"dofile", "loadfile", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	// 热加载时持有scriptHook的锁，脚本顶层代码死循环会卡住所有使用脚本的更新
+	ctx, cancel := context.WithTimeout(context.Background(), scriptCallTimeout)
+	L.SetContext(ctx)
+	err := L.DoFile(path)
+	L.RemoveContext()
+	cancel()
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	fn, ok := L.GetGlobal("transform").(*lua.LFunction)
+	if !ok {
+		L.Close()
+		return nil, errors.New("没有定义函数 transform(candle)")
+	}
+	return &luaScript{file: filepath.Base(path), state: L, fn: fn}, nil
+}
+
+// apply 对每根K线调用transform：返回表时使用返回的K线，返回true时保持不变，返回nil或false时丢弃
+func (s *luaScript) apply(symbol, interval string, klines []db.Candle) ([]db.Candle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return klines, nil
+	}
+	L := s.state
+
+	result := make([]db.Candle, 0, len(klines))
+	for _, k := range klines {
+		ctx, cancel := context.WithTimeout(context.Background(), scriptCallTimeout)
+		L.SetContext(ctx)
+		err := L.CallByParam(lua.P{Fn: s.fn, NRet: 1, Protect: true}, candleToLua(L, symbol, interval, k))
+		L.RemoveContext()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		ret := L.Get(-1)
+		L.Pop(1)
+		switch v := ret.(type) {
+		case *lua.LTable:
+			k = candleFromLua(v, k)
+		case lua.LBool:
+			if !bool(v) {
+				continue
+			}
+		default:
+			if ret == lua.LNil {
+				continue
+			}
+			return nil, fmt.Errorf("transform 返回了不支持的类型 %s", ret.Type())
+		}
+		result = append(result, k)
+	}
+	return result, nil
+}
+
+// close 关闭虚拟机
+func (s *luaScript) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.state.Close()
+}
+
+// candleToLua 把K线转换为脚本中的表，价格和成交量保持十进制字符串，可用tonumber转换
+func candleToLua(L *lua.LState, symbol, interval string, k db.Candle) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("symbol", lua.LString(symbol))
+	t.RawSetString("interval", lua.LString(interval))
+	t.RawSetString("open_time", lua.LNumber(k.OpenTime))
+	t.RawSetString("open", lua.LString(k.Open))
+	t.RawSetString("high", lua.LString(k.High))
+	t.RawSetString("low", lua.LString(k.Low))
+	t.RawSetString("close", lua.LString(k.Close))
+	t.RawSetString("volume", lua.LString(k.Volume))
+	t.RawSetString("closed", lua.LBool(k.Closed))
+	t.RawSetString("note", lua.LString(k.Note))
+	return t
+}
+
+// candleFromLua 读取脚本返回的表，开盘时间和收盘状态不允许修改，价格可以是字符串或数字
+func candleFromLua(t *lua.LTable, k db.Candle) db.Candle {
+	for key, dest := range map[string]*string{
+		"open":   &k.Open,
+		"high":   &k.High,
+		"low":    &k.Low,
+		"close":  &k.Close,
+		"volume": &k.Volume,
+		"note":   &k.Note,
+	} {
+		switch v := t.RawGetString(key).(type) {
+		case lua.LString:
+			*dest = string(v)
+		case lua.LNumber:
+			*dest = v.String()
+		}
+	}
+	return k
+}
+
+// ScriptsResponse 脚本加载状态
+type ScriptsResponse struct {
+	Dir     string         `json:"dir"`
+	Scripts []ScriptStatus `json:"scripts"`
+}
+
+// getScripts 返回脚本目录中各脚本的加载状态
+func getScripts(c *gin.Context) {
+	scriptMutex.Lock()
+	h := activeScript
+	scriptMutex.Unlock()
+	if h == nil {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未配置脚本目录，请设置 SCRIPTS_DIR")
+		return
+	}
+	respondOK(c, ScriptsResponse{Dir: h.dir, Scripts: h.status()})
+}
+
+// reloadScripts 立即重新扫描脚本目录
+func reloadScripts(c *gin.Context) {
+	scriptMutex.Lock()
+	h := activeScript
+	scriptMutex.Unlock()
+	if h == nil {
+		respondError(c, http.StatusBadRequest, CodeNotEnabled, "未配置脚本目录，请设置 SCRIPTS_DIR")
+		return
+	}
+	h.reload(true)
+	logRequestInfo(c, "重新加载了脚本目录 %s", h.dir)
+	respondOK(c, ScriptsResponse{Dir: h.dir, Scripts: h.status()})
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// newTestScripts 在临时目录写入脚本并加载
+func newTestScripts(t *testing.T, files map[string]string) *scriptHook {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := initScripts(&config.ScriptsConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("initScripts: %v", err)
+	}
+	t.Cleanup(func() { initScripts(&config.ScriptsConfig{}) })
+	return h
+}
+
+func noteScript(note string) string {
+	return `function transform(c) c.note = (c.note or "") .. "` + note + `" return c end`
+}
+
+func TestScriptLoading(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantNote  string
+		wantError map[string]string // 文件名 -> 加载状态中错误信息包含的内容
+	}{
+		{
+			name:     "lowercase file name applies to symbol",
+			files:    map[string]string{"btcusdt.lua": noteScript("lower")},
+			wantNote: "lower",
+		},
+		{
+			name:     "all symbols script runs first",
+			files:    map[string]string{"BTCUSDT.lua": noteScript("symbol"), "_all.lua": noteScript("all,")},
+			wantNote: "all,symbol",
+		},
+		{
+			name:      "duplicate symbol keeps first file",
+			files:     map[string]string{"BTCUSDT.lua": noteScript("upper"), "btcusdt.lua": noteScript("lower")},
+			wantNote:  "upper",
+			wantError: map[string]string{"btcusdt.lua": "同一交易对"},
+		},
+		{
+			name:      "missing transform",
+			files:     map[string]string{"BTCUSDT.lua": "x = 1"},
+			wantError: map[string]string{"BTCUSDT.lua": "transform"},
+		},
+		{
+			name:      "endless top-level code times out",
+			files:     map[string]string{"BTCUSDT.lua": "while true do end"},
+			wantError: map[string]string{"BTCUSDT.lua": "context deadline exceeded"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			h := newTestScripts(t, tt.files)
+			if elapsed := time.Since(start); elapsed > 3*scriptCallTimeout {
+				t.Fatalf("loading took %v", elapsed)
+			}
+
+			for _, st := range h.status() {
+				want := tt.wantError[st.File]
+				if want == "" && st.Error != "" || !strings.Contains(st.Error, want) {
+					t.Fatalf("%s: status error %q, want %q", st.File, st.Error, want)
+				}
+			}
+
+			klines, err := h.OnCandleFetched("btcusdt", "1h", []db.Candle{{OpenTime: 1, Close: "1"}})
+			if err != nil {
+				t.Fatalf("OnCandleFetched: %v", err)
+			}
+			if len(klines) != 1 || klines[0].Note != tt.wantNote {
+				t.Fatalf("klines = %+v, want note %q", klines, tt.wantNote)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// ohlcv 用于计算的数值型K线
+type ohlcv struct {
+	Timestamp int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
+}
+
+// loadSeries 从主库读取K线并按时间升序转换为数值序列，用于需要写回结果的计算
+func loadSeries(symbol, interval string, startTime, endTime int64, limit int) ([]ohlcv, error) {
+	rows, err := db.GetKlineData(symbol, interval, startTime, endTime, limit)
+	if err != nil {
+		return nil, err
+	}
+	return toSeries(rows), nil
+}
+
+// querySeries 从只读连接读取K线并按时间升序转换为数值序列，用于查询接口
+func querySeries(symbol, interval string, startTime, endTime int64, limit int) ([]ohlcv, error) {
+	rows, err := db.QueryKlineData(symbol, interval, startTime, endTime, limit)
+	if err != nil {
+		return nil, err
+	}
+	return toSeries(rows), nil
+}
+
+// toSeries 将数据库返回的K线转换为数值序列
+func toSeries(rows []db.Candle) []ohlcv {
+	// 数据库按时间倒序返回，计算需要升序
+	series := make([]ohlcv, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		series = append(series, ohlcv{
+			Timestamp: row.OpenTime,
+			Open:      parseDecimal(row.Open),
+			High:      parseDecimal(row.High),
+			Low:       parseDecimal(row.Low),
+			Close:     parseDecimal(row.Close),
+			Volume:    parseDecimal(row.Volume),
+		})
+	}
+	return series
+}
+
+// parseDecimal 将数据库中的十进制字符串转换为浮点数，无法解析时返回0
+func parseDecimal(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// seriesTimestamps 提取序列的时间戳
+func seriesTimestamps(series []ohlcv) []int64 {
+	result := make([]int64, len(series))
+	for i, c := range series {
+		result[i] = c.Timestamp
+	}
+	return result
+}
+
+// seriesCloses 提取序列的收盘价
+func seriesCloses(series []ohlcv) []float64 {
+	result := make([]float64, len(series))
+	for i, c := range series {
+		result[i] = c.Close
+	}
+	return result
+}
@@ -1,11 +1,12 @@
 package api
 
 import (
+	"io"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
 	"github.com/ganlian2020AI/biupdata/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -18,13 +19,15 @@ func InitServer(cfg *config.APIConfig) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 
 	// 创建路由
-	router = gin.Default()
+	router = gin.New()
+	router.Use(gin.Recovery())
 
 	// 允许跨域
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, If-None-Match, If-Modified-Since")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Last-Modified")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -35,36 +38,87 @@ func InitServer(cfg *config.APIConfig) *gin.Engine {
 		c.Next()
 	})
 
+	// 请求ID，写入响应和日志
+	router.Use(requestIDMiddleware())
+
+	// 访问日志
+	if cfg.AccessLog {
+		router.Use(accessLogMiddleware(cfg))
+	}
+
+	// 响应压缩
+	if cfg.Compression {
+		router.Use(compressionMiddleware(cfg.CompressionMinSize))
+	}
+
+	// 按IP限流，所有请求都经过
+	if cfg.RateLimitRPS > 0 {
+		router.Use(ipRateLimitMiddleware(cfg))
+	}
+
+	// JWT认证，认证通过后再按令牌主体限流
+	if appConfig != nil && appConfig.Auth.JWTEnabled {
+		router.Use(authMiddleware(&appConfig.Auth))
+		if cfg.RateLimitKeyRPS > 0 {
+			router.Use(subjectRateLimitMiddleware(cfg))
+		}
+	}
+
 	// 注册路由
 	registerRoutes()
 
+	router.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, CodeNotFound, "接口不存在: "+c.Request.URL.Path)
+	})
+
 	return router
 }
 
 // StartServer 启动HTTP服务器
 func StartServer(cfg *config.APIConfig) error {
-	utils.LogInfo("启动HTTP服务器，监听端口: %s", cfg.Port)
-	return router.Run(":" + cfg.Port)
+	if !cfg.TLSEnabled() {
+		utils.LogInfo("启动HTTP服务器，监听端口: %s", cfg.Port)
+		return router.Run(":" + cfg.Port)
+	}
+
+	certFile, keyFile, err := resolveTLSFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.TLSRedirectPort != "" {
+		startHTTPSRedirect(cfg)
+	}
+
+	utils.LogInfo("启动HTTPS服务器，监听端口: %s，证书: %s", cfg.Port, certFile)
+	return router.RunTLS(":"+cfg.Port, certFile, keyFile)
+}
+
+// HealthStatus 健康检查结果
+type HealthStatus struct {
+	Status   string             `json:"status"`
+	Build    utils.BuildInfo    `json:"build"`
+	Replicas []db.ReplicaStatus `json:"replicas,omitempty"` // 配置了只读从库时返回各从库状态
+	Routes   []db.RouteStatus   `json:"routes,omitempty"`   // 配置了按交易对路由的数据库时返回各数据库状态
 }
 
 // 注册API路由
 func registerRoutes() {
 	// 健康检查
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-		})
+		respondOK(c, HealthStatus{Status: "ok", Build: utils.GetBuildInfo(), Replicas: db.GetReplicaStatuses(), Routes: db.GetRouteStatuses()})
 	})
 
 	// 获取日志
-	router.GET("/logs", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"logs": utils.GetLogBuffer(),
-		})
-	})
+	router.GET("/logs", getLogs)
+	router.GET("/logs/files", getLogFiles)
+	router.GET("/logs/files/:name", getLogFile)
+
+	// 管理页面
+	registerDashboard()
 
-	// 添加HTML日志页面
-	router.GET("/logs/view", viewLogs)
+	// Prometheus格式的运行指标
+	router.GET("/metrics", getMetrics)
 
 	// 币安数据API
 	v1 := router.Group("/api/v1")
@@ -72,8 +126,70 @@ func registerRoutes() {
 		// 获取K线数据
 		v1.GET("/kline", getKlineData)
 
+		// 批量获取多个交易对的K线
+		v1.GET("/kline/batch", getKlineBatch)
+
+		// 区间聚合统计
+		v1.GET("/kline/stats", getKlineAggregate)
+
+		// 按时间升序分块导出大量K线
+		v1.GET("/kline/export", exportKlines)
+
+		// K线标注及修改记录
+		v1.PUT("/kline/note", setKlineNote)
+		v1.GET("/kline/note/audit", getNoteAudits)
+
+		// 市场事件
+		v1.GET("/events", listEvents)
+		v1.POST("/events", createEvent)
+		v1.GET("/events/:id", getEvent)
+		v1.PUT("/events/:id", updateEvent)
+		v1.DELETE("/events/:id", deleteEvent)
+
+		// 行情告警规则及触发记录
+		v1.GET("/alerts/rules", listAlertRules)
+		v1.POST("/alerts/rules", createAlertRule)
+		v1.GET("/alerts/rules/:id", getAlertRule)
+		v1.PUT("/alerts/rules/:id", updateAlertRule)
+		v1.DELETE("/alerts/rules/:id", deleteAlertRule)
+		v1.GET("/alerts/history", getAlertHistory)
+
+		// 随K线估值的模拟持仓
+		v1.GET("/paper/positions", listPaperPositions)
+		v1.POST("/paper/positions", openPaperPosition)
+		v1.GET("/paper/positions/:id", getPaperPosition)
+		v1.POST("/paper/positions/:id/close", closePaperPosition)
+		v1.DELETE("/paper/positions/:id", deletePaperPosition)
+
+		// 交易对元数据
+		v1.GET("/symbols", getSymbols)
+		v1.GET("/symbols/registry", getSymbolRegistry)
+
+		// 技术指标
+		v1.GET("/indicators", getIndicators)
+
+		// 收益率序列
+		v1.GET("/returns", getReturns)
+
+		// 按时间边界切分的回测/训练数据集
+		v1.GET("/backtest/window", getBacktestWindow)
+
+		// 创建后不再变化的命名数据集，回测可通过dataset参数引用
+		v1.GET("/datasets", listDatasets)
+		v1.POST("/datasets", createDataset)
+		v1.GET("/datasets/:id", getDataset)
+		v1.GET("/datasets/:id/klines", getDatasetKlines)
+
+		// 已物化的滚动统计
+		v1.GET("/rolling", getRollingStats)
+
 		// 手动触发数据更新
 		v1.POST("/update", triggerUpdate)
+		v1.GET("/update/jobs", getUpdateJobs)
+		v1.GET("/update/jobs/:id", getUpdateJob)
+
+		// 低延迟模式收盘K线推送（SSE）
+		v1.GET("/stream", streamKlines)
 
 		// 获取网络连接状态
 		v1.GET("/network", getNetworkStatus)
@@ -84,448 +200,526 @@ func registerRoutes() {
 		// 测试网络连接
 		v1.POST("/network/test", testNetworkConnection)
 
+		// 获取线路探测历史
+		v1.GET("/network/history", getNetworkHistory)
+
+		// 各线路的币安API请求权重使用情况
+		v1.GET("/network/usage", getWeightUsage)
+
+		// TradingView UDF数据源
+		udf := v1.Group("/udf")
+		udf.GET("/config", getUDFConfig)
+		udf.GET("/time", getUDFTime)
+		udf.GET("/symbols", getUDFSymbols)
+		udf.GET("/search", getUDFSearch)
+		udf.GET("/history", getUDFHistory)
+
+		// Grafana SimpleJSON数据源
+		grafana := v1.Group("/grafana")
+		grafana.GET("/", getGrafanaTest)
+		grafana.POST("/search", grafanaSearch)
+		grafana.POST("/query", grafanaQuery)
+		grafana.POST("/annotations", grafanaAnnotations)
+
+		// 数据新鲜度、缺口检查与质量评分
+		v1.GET("/freshness", getFreshness)
+		v1.GET("/gaps", getGaps)
+		v1.GET("/quality", getQuality)
+
+		// 最优买卖价快照
+		v1.GET("/bookticker", getBookTicker)
+
+		// 按格式字符串聚合的错误和警告
+		v1.GET("/errors", getErrorGroups)
+		v1.DELETE("/errors", resetErrorGroups)
+
+		// 每日报告预览与手动发送
+		v1.GET("/report/daily", getDailyReport)
+		v1.POST("/report/daily/send", sendDailyReport)
+
+		// 数据保留策略及过期数据统计
+		v1.GET("/retention", getRetentionReport)
+
 		// 定时任务控制
 		v1.GET("/scheduler", getSchedulerStatus)
 		v1.POST("/scheduler/start", startScheduler)
 		v1.POST("/scheduler/stop", stopScheduler)
+		v1.POST("/scheduler/pause", pauseSeries)
+		v1.POST("/scheduler/resume", resumeSeries)
+		v1.GET("/scheduler/catchup", getCatchupProgress)
+		v1.POST("/scheduler/catchup/priority", setCatchupPriority)
+
+		// 清空并重新回补单个交易对和时间间隔的数据
+		v1.POST("/admin/rebuild", startRebuild)
+		v1.GET("/admin/rebuild", getRebuildJobs)
+		v1.GET("/admin/rebuild/:id", getRebuildJob)
+
+		// 不依赖mysqldump的K线逻辑备份与恢复
+		v1.GET("/admin/backup", downloadBackup)
+		v1.POST("/admin/restore", restoreBackup)
+
+		// K线表索引与示例查询的执行计划
+		v1.GET("/admin/indexes", getIndexes)
+
+		// 写入前加工K线的Lua脚本
+		v1.GET("/admin/scripts", getScripts)
+		v1.POST("/admin/scripts/reload", reloadScripts)
+
+		// 删除数据集
+		v1.DELETE("/admin/datasets/:id", deleteDataset)
 	}
 }
 
+// KlineResponse K线查询结果
+type KlineResponse struct {
+	Symbol      string           `json:"symbol"`
+	Interval    string           `json:"interval"`
+	Klines      []KlineItem      `json:"klines"`
+	Count       int              `json:"count"`
+	Events      []KlineEvent     `json:"events,omitempty"`
+	Quote       *QuoteConversion `json:"quote,omitempty"`
+	Transform   *TransformInfo   `json:"transform,omitempty"`
+	Downsampled *DownsampleInfo  `json:"downsampled,omitempty"`
+}
+
 // getKlineData 获取K线数据处理函数
 func getKlineData(c *gin.Context) {
 	symbol := c.Query("symbol")
 	interval := c.Query("interval")
-	startTime := c.Query("start_time")
-	endTime := c.Query("end_time")
-	limitStr := c.DefaultQuery("limit", "1000")
 
 	// 参数验证
 	if symbol == "" || interval == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "缺少必要参数: symbol, interval",
-		})
+		badRequest(c, "缺少必要参数: symbol, interval")
 		return
 	}
 
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的limit参数",
-		})
+	if !config.IsSupportedInterval(interval) {
+		badRequest(c, "不支持的时间间隔: "+interval)
+		return
+	}
+
+	startTime, endTime, limit, ok := parseKlineRange(c)
+	if !ok {
+		return
+	}
+
+	shape := c.DefaultQuery("shape", "rows")
+	if shape != "rows" && shape != "columns" {
+		badRequest(c, "无效的shape参数，可选 rows、columns")
+		return
+	}
+
+	maxPoints, method, ok := parseDownsample(c)
+	if !ok {
+		return
+	}
+
+	transform, ok := parseTransform(c)
+	if !ok {
+		return
+	}
+
+	quote, ok := parseQuote(c, symbol)
+	if !ok {
 		return
 	}
 
 	// 获取数据
-	data, err := GetKlineDataFromDB(symbol, interval, startTime, endTime, limit)
+	var data []KlineItem
+	var err error
+	if maxPoints > 0 {
+		// 降采样时读取区间内的全部原始K线，指定了limit或last时按其限制原始K线数量
+		sourceLimit := downsampleMaxSource
+		if (c.Query("limit") != "" || c.Query("last") != "") && limit > 0 && limit < sourceLimit {
+			sourceLimit = limit
+		}
+		start, _ := strconv.ParseInt(startTime, 10, 64)
+		end, _ := strconv.ParseInt(endTime, 10, 64)
+		data, err = queryKlineItems(symbol, interval, start, end, sourceLimit)
+	} else {
+		data, err = GetKlineDataFromDB(symbol, interval, startTime, endTime, limit)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		internalError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"symbol":   symbol,
-		"interval": interval,
-		"data":     data,
-		"count":    len(data),
+	// 换算为指定的计价货币，在变换和降采样之前进行
+	if quote != nil {
+		if data, err = convertQuote(data, interval, quote); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+
+	// 附带K线覆盖时间内的市场事件
+	var events []KlineEvent
+	if c.Query("include_events") == "true" {
+		if events, err = klineEvents(symbol, interval, data); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+
+	// 按时间倒序，第一根即最新的K线
+	var latest int64
+	if len(data) > 0 {
+		latest = data[0].Timestamp
+	}
+
+	// 平均K线或Renko变换，在降采样之前进行
+	if transform != nil {
+		data = transformKlines(data, transform)
+	}
+
+	// K线多于max_points时降采样
+	var downsampled *DownsampleInfo
+	if maxPoints > 0 && len(data) > maxPoints {
+		downsampled = &DownsampleInfo{Method: method, MaxPoints: maxPoints, SourceCount: len(data)}
+		data = downsampleKlines(data, maxPoints, method)
+	}
+
+	// 按列返回，体积更小，便于numpy/pandas直接加载
+	if shape == "columns" {
+		resp := toKlineColumns(symbol, interval, data)
+		resp.Events = events
+		resp.Quote = quote
+		resp.Transform = transform
+		resp.Downsampled = downsampled
+		respondCached(c, interval, latest, resp)
+		return
+	}
+
+	respondCached(c, interval, latest, KlineResponse{
+		Symbol:      symbol,
+		Interval:    interval,
+		Klines:      data,
+		Count:       len(data),
+		Events:      events,
+		Quote:       quote,
+		Transform:   transform,
+		Downsampled: downsampled,
 	})
 }
 
-// triggerUpdate 手动触发数据更新处理函数
+// UpdateTriggered 已触发的手动更新
+type UpdateTriggered struct {
+	Symbol    string              `json:"symbol"`
+	Intervals []string            `json:"intervals"`
+	Jobs      []UpdateJobAssigned `json:"jobs"`
+}
+
+// UpdateJobAssigned 时间间隔对应的更新任务，coalesced为true表示该时间间隔正在更新，本次请求合并到已有任务
+type UpdateJobAssigned struct {
+	Interval  string `json:"interval"`
+	JobID     string `json:"job_id"`
+	Coalesced bool   `json:"coalesced"`
+}
+
+// UpdateDryRun 试运行手动更新的结果，没有写入任何数据
+type UpdateDryRun struct {
+	Symbol    string          `json:"symbol"`
+	Intervals []string        `json:"intervals"`
+	DryRun    bool            `json:"dry_run"`
+	Reports   []*DryRunReport `json:"reports"`
+}
+
+// triggerUpdate 手动触发数据更新处理函数，dry_run为true时同步返回试运行结果，不写入数据
 func triggerUpdate(c *gin.Context) {
 	var req struct {
 		Symbol    string   `json:"symbol"`
 		Intervals []string `json:"intervals"`
+		DryRun    bool     `json:"dry_run"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+		badRequest(c, "无效的请求参数")
 		return
 	}
 
 	// 参数验证
 	if req.Symbol == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "缺少必要参数: symbol",
-		})
+		badRequest(c, "缺少必要参数: symbol")
 		return
 	}
 
 	if len(req.Intervals) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "缺少必要参数: intervals",
-		})
+		badRequest(c, "缺少必要参数: intervals")
 		return
 	}
 
-	// 异步更新数据
+	for _, interval := range req.Intervals {
+		if !config.IsSupportedInterval(interval) {
+			badRequest(c, "不支持的时间间隔: "+interval)
+			return
+		}
+	}
+
+	if req.DryRun || c.Query("dry_run") == "true" {
+		logRequestInfo(c, "试运行更新 %s %v", req.Symbol, req.Intervals)
+		result := UpdateDryRun{Symbol: req.Symbol, Intervals: req.Intervals, DryRun: true, Reports: []*DryRunReport{}}
+		for _, interval := range req.Intervals {
+			report, err := PreviewInterval(req.Symbol, interval, 0, 0)
+			if err != nil {
+				internalError(c, err)
+				return
+			}
+			result.Reports = append(result.Reports, report)
+		}
+		respondOK(c, result)
+		return
+	}
+
+	// 高可用模式下只有主节点写入
+	if !requireLeader(c) {
+		return
+	}
+
+	// 异步更新数据，gin.Context在处理函数返回后会被复用，提前取出请求ID
+	reqID := requestID(c)
+	logRequestInfo(c, "手动触发更新 %s %v", req.Symbol, req.Intervals)
+	jobs := claimUpdateJobs(req.Symbol, req.Intervals, "manual")
 	go func() {
-		UpdateSymbolData(req.Symbol, req.Intervals)
+		defer utils.Recover("manual_update", nil)
+		if _, err := defaultUpdater.runUpdateJobs(req.Symbol, jobs); err != nil {
+			utils.LogWithFields(utils.LevelError, utils.Fields{"request_id": reqID}, "手动更新 %s 数据失败: %v", req.Symbol, err)
+		}
 	}()
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "数据更新已触发",
-		"symbol":  req.Symbol,
+	assigned := make([]UpdateJobAssigned, len(jobs))
+	for i, cj := range jobs {
+		assigned[i] = UpdateJobAssigned{Interval: cj.job.Interval, JobID: cj.job.ID, Coalesced: !cj.owned}
+	}
+	respondMessage(c, "数据更新已触发", UpdateTriggered{
+		Symbol:    req.Symbol,
+		Intervals: req.Intervals,
+		Jobs:      assigned,
+	})
+}
+
+// streamKlines 以Server-Sent Events推送低延迟模式的收盘K线
+func streamKlines(c *gin.Context) {
+	ch := subscribe(c.Query("symbol"))
+	defer unsubscribe(ch)
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case candle := <-ch:
+			c.SSEvent("kline", candle)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
 	})
 }
 
+// getMetrics 输出Prometheus格式的运行指标
+func getMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	utils.WriteMetrics(c.Writer)
+}
+
+// NetworkStatus 网络连接状态
+type NetworkStatus struct {
+	UseProxy   bool            `json:"use_proxy"`
+	Mode       string          `json:"mode"`
+	Route      string          `json:"route"`
+	Routes     []routeProbe    `json:"routes"`
+	BaseURL    string          `json:"base_url"`
+	Endpoints  []apiEndpoint   `json:"endpoints"`
+	ProxyURL   string          `json:"proxy_url"`
+	Proxies    []proxyEndpoint `json:"proxies"`
+	TestSymbol string          `json:"test_symbol"`
+}
+
 // getNetworkStatus 获取网络连接状态
 func getNetworkStatus(c *gin.Context) {
 	if appConfig == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "配置未初始化",
-		})
+		internalError(c, errConfigNotInitialized)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"use_proxy":   appConfig.Binance.UseProxy,
-		"base_url":    appConfig.Binance.BaseURL,
-		"proxy_url":   appConfig.Binance.ProxyURL,
-		"test_symbol": appConfig.Binance.TestSymbol,
+	mode, active, routes := getRouteStatus()
+	respondOK(c, NetworkStatus{
+		UseProxy:   appConfig.Binance.UseProxy,
+		Mode:       mode,
+		Route:      active,
+		Routes:     routes,
+		BaseURL:    currentBaseURL(),
+		Endpoints:  getEndpointStatus(),
+		ProxyURL:   appConfig.Binance.ProxyURL,
+		Proxies:    getProxyStatus(),
+		TestSymbol: appConfig.Binance.TestSymbol,
 	})
 }
 
-// setNetworkMode 手动设置网络模式
+// NetworkMode 当前线路选择模式
+type NetworkMode struct {
+	Mode     string `json:"mode"`
+	Route    string `json:"route"`
+	UseProxy bool   `json:"use_proxy"`
+}
+
+// setNetworkMode 手动设置网络模式，route为auto时按探测结果自动选择线路，否则固定使用指定线路
 func setNetworkMode(c *gin.Context) {
 	if appConfig == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "配置未初始化",
-		})
+		internalError(c, errConfigNotInitialized)
 		return
 	}
 
 	var req struct {
-		UseProxy bool `json:"use_proxy"`
+		Route    string `json:"route"`
+		UseProxy *bool  `json:"use_proxy"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Route == "" && req.UseProxy == nil) {
+		badRequest(c, "无效的请求参数")
 		return
 	}
 
-	// 设置代理模式
-	appConfig.Binance.UseProxy = req.UseProxy
+	// 兼容旧的use_proxy参数：false固定直接连接，true固定使用第一条代理线路
+	route := req.Route
+	if route == "" {
+		route = routeDirect
+		if *req.UseProxy {
+			name, ok := firstIndirectRoute()
+			if !ok {
+				badRequest(c, "未配置代理线路")
+				return
+			}
+			route = name
+		}
+	}
 
-	mode := "直接连接"
-	if req.UseProxy {
-		mode = "代理模式"
+	if err := setRouteMode(route); err != nil {
+		badRequest(c, err.Error())
+		return
 	}
 
-	utils.LogInfo("手动切换网络连接模式为: %s", mode)
+	mode, active, _ := getRouteStatus()
+	logRequestInfo(c, "手动切换网络线路模式为: %s，当前线路: %s", mode, active)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":   "网络模式已切换",
-		"use_proxy": appConfig.Binance.UseProxy,
+	respondMessage(c, "网络模式已切换", NetworkMode{
+		Mode:     mode,
+		Route:    active,
+		UseProxy: appConfig.Binance.UseProxy,
 	})
 }
 
-// testNetworkConnection 测试网络连接
+// NetworkTestResult 立即探测的结果
+type NetworkTestResult struct {
+	Connected bool         `json:"connected"`
+	UseProxy  bool         `json:"use_proxy"`
+	Mode      string       `json:"mode"`
+	Route     string       `json:"route"`
+	Routes    []routeProbe `json:"routes"`
+}
+
+// testNetworkConnection 立即探测全部线路
 func testNetworkConnection(c *gin.Context) {
 	isConnected := CheckBinanceConnection()
 
-	mode := "直接连接"
-	if appConfig.Binance.UseProxy {
-		mode = "代理模式"
+	mode, active, routes := getRouteStatus()
+	respondOK(c, NetworkTestResult{
+		Connected: isConnected,
+		UseProxy:  appConfig.Binance.UseProxy,
+		Mode:      mode,
+		Route:     active,
+		Routes:    routes,
+	})
+}
+
+// NetworkHistory 线路探测历史
+type NetworkHistory struct {
+	History []probeRound `json:"history"`
+	Count   int          `json:"count"`
+}
+
+// getNetworkHistory 获取线路探测历史，按时间倒序
+func getNetworkHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		badRequest(c, "无效的limit参数")
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"connected": isConnected,
-		"use_proxy": appConfig.Binance.UseProxy,
-		"mode":      mode,
+	history := getProbeHistory(limit)
+	respondOK(c, NetworkHistory{
+		History: history,
+		Count:   len(history),
 	})
 }
 
+// SchedulerStatus 定时任务状态
+type SchedulerStatus struct {
+	Running      bool                `json:"running"`
+	HAEnabled    bool                `json:"ha_enabled"`
+	Leader       bool                `json:"leader"`
+	Tasks        []TaskStatus        `json:"tasks"`
+	Paused       []PausedSeries      `json:"paused"`
+	Maintenance  *MaintenanceStatus  `json:"maintenance,omitempty"`  // 配置了维护窗口时返回
+	Backpressure *BackpressureStatus `json:"backpressure,omitempty"` // 启用背压时返回
+}
+
+// currentSchedulerStatus 获取当前定时任务状态
+func currentSchedulerStatus() SchedulerStatus {
+	updateMutex.Lock()
+	paused := pausedList()
+	updateMutex.Unlock()
+
+	return SchedulerStatus{
+		Running:      IsSchedulerRunning(),
+		HAEnabled:    haEnabled,
+		Leader:       IsLeader(),
+		Tasks:        taskStatuses(),
+		Paused:       paused,
+		Maintenance:  currentMaintenanceStatus(),
+		Backpressure: currentBackpressureStatus(),
+	}
+}
+
 // getSchedulerStatus 获取定时任务状态
 func getSchedulerStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"running": IsSchedulerRunning(),
-	})
+	respondOK(c, currentSchedulerStatus())
 }
 
-// startScheduler 启动定时任务
+// startScheduler 启动定时任务，期望状态会保存到数据库，重启后保持
 func startScheduler(c *gin.Context) {
 	if IsSchedulerRunning() {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "定时任务已经在运行中",
-			"running": true,
-		})
+		respondMessage(c, "定时任务已经在运行中", currentSchedulerStatus())
+		return
+	}
+
+	// 高可用模式下只有主节点可以运行定时任务
+	if !IsLeader() {
+		respondError(c, http.StatusConflict, CodeConflict, "当前实例不是主节点，无法启动定时任务")
 		return
 	}
 
+	if err := setSchedulerEnabled(true); err != nil {
+		logRequestError(c, "保存定时任务状态失败: %v", err)
+	}
 	StartScheduler()
+	logRequestInfo(c, "手动启动定时任务")
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "定时任务已启动",
-		"running": true,
-	})
+	respondMessage(c, "定时任务已启动", currentSchedulerStatus())
 }
 
-// stopScheduler 停止定时任务
+// stopScheduler 停止定时任务，期望状态会保存到数据库，重启或主节点切换后保持停止
 func stopScheduler(c *gin.Context) {
+	// 从节点上停止同样需要记录，该实例成为主节点后不会启动定时任务
+	if err := setSchedulerEnabled(false); err != nil {
+		logRequestError(c, "保存定时任务状态失败: %v", err)
+	}
+
 	if !IsSchedulerRunning() {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "定时任务已经停止",
-			"running": false,
-		})
+		respondMessage(c, "定时任务已经停止", currentSchedulerStatus())
 		return
 	}
 
 	StopScheduler()
+	logRequestInfo(c, "手动停止定时任务")
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "定时任务已停止",
-		"running": false,
-	})
-}
-
-// viewLogs 显示日志HTML页面
-func viewLogs(c *gin.Context) {
-	logs := utils.GetLogBuffer()
-	htmlContent := generateLogsHTML(logs)
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	c.String(http.StatusOK, htmlContent)
-}
-
-// generateLogsHTML 生成日志HTML内容
-func generateLogsHTML(logs []string) string {
-	html := `
-<!DOCTYPE html>
-<html lang="zh-CN">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>BiUpData 系统日志</title>
-    <style>
-        body {
-            font-family: Arial, sans-serif;
-            margin: 0;
-            padding: 20px;
-            background-color: #f5f5f5;
-        }
-        h1 {
-            color: #333;
-            border-bottom: 1px solid #ddd;
-            padding-bottom: 10px;
-        }
-        .container {
-            max-width: 1200px;
-            margin: 0 auto;
-            background-color: white;
-            padding: 20px;
-            border-radius: 5px;
-            box-shadow: 0 1px 3px rgba(0,0,0,0.1);
-        }
-        .logs {
-            height: 600px;
-            overflow-y: auto;
-            background-color: #f8f8f8;
-            padding: 10px;
-            border: 1px solid #ddd;
-            border-radius: 3px;
-            font-family: monospace;
-            white-space: pre-wrap;
-        }
-        .log-entry {
-            margin: 5px 0;
-            padding: 5px;
-            border-bottom: 1px solid #eee;
-        }
-        .info {
-            color: #31708f;
-        }
-        .error {
-            color: #a94442;
-            font-weight: bold;
-        }
-        .warning {
-            color: #8a6d3b;
-        }
-        .controls {
-            margin-top: 20px;
-            display: flex;
-            justify-content: space-between;
-        }
-        button {
-            padding: 8px 16px;
-            background-color: #337ab7;
-            color: white;
-            border: none;
-            border-radius: 3px;
-            cursor: pointer;
-        }
-        button:hover {
-            background-color: #286090;
-        }
-        .status {
-            margin-top: 20px;
-            padding: 10px;
-            background-color: #dff0d8;
-            border: 1px solid #d6e9c6;
-            border-radius: 3px;
-            color: #3c763d;
-        }
-        #autoRefresh {
-            margin-right: 10px;
-        }
-        .refresh-indicator {
-            font-size: 12px;
-            margin-left: 10px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>BiUpData 系统日志</h1>
-        
-        <div class="controls">
-            <div>
-                <input type="checkbox" id="autoRefresh" checked>
-                <label for="autoRefresh">自动刷新 (10秒)</label>
-                <span class="refresh-indicator" id="refreshIndicator"></span>
-            </div>
-            <button onclick="refreshLogs()">立即刷新</button>
-        </div>
-        
-        <div class="logs" id="logsContainer">
-`
-
-	// 添加日志条目
-	if len(logs) == 0 {
-		html += "            <div class='log-entry'>暂无日志记录</div>\n"
-	} else {
-		// 倒序显示日志，最新的在顶部
-		for i := len(logs) - 1; i >= 0; i-- {
-			logClass := "info"
-			if strings.Contains(logs[i], "[ERROR]") {
-				logClass = "error"
-			} else if strings.Contains(logs[i], "[WARNING]") {
-				logClass = "warning"
-			}
-			html += "            <div class='log-entry " + logClass + "'>" + logs[i] + "</div>\n"
-		}
-	}
-
-	html += `
-        </div>
-        
-        <div class="status" id="statusContainer">
-            正在从服务器获取状态...
-        </div>
-    </div>
-
-    <script>
-        let autoRefreshEnabled = true;
-        let refreshTimer;
-        let countdown = 10;
-        
-        // 页面加载完成后自动刷新
-        document.addEventListener('DOMContentLoaded', function() {
-            refreshLogs();
-            getStatus();
-            startRefreshTimer();
-            
-            // 自动刷新开关
-            document.getElementById('autoRefresh').addEventListener('change', function() {
-                autoRefreshEnabled = this.checked;
-                if (autoRefreshEnabled) {
-                    startRefreshTimer();
-                } else {
-                    clearTimeout(refreshTimer);
-                    document.getElementById('refreshIndicator').textContent = '';
-                }
-            });
-        });
-        
-        // 刷新日志
-        function refreshLogs() {
-            fetch('/logs')
-                .then(response => response.json())
-                .then(data => {
-                    const logsContainer = document.getElementById('logsContainer');
-                    logsContainer.innerHTML = '';
-                    
-                    if (data.logs.length === 0) {
-                        logsContainer.innerHTML = "<div class='log-entry'>暂无日志记录</div>";
-                    } else {
-                        // 倒序显示日志，最新的在顶部
-                        for (let i = data.logs.length - 1; i >= 0; i--) {
-                            const log = data.logs[i];
-                            let logClass = 'info';
-                            
-                            if (log.includes('[ERROR]')) {
-                                logClass = 'error';
-                            } else if (log.includes('[WARNING]')) {
-                                logClass = 'warning';
-                            }
-                            
-                            const logEntry = document.createElement('div');
-                            logEntry.className = 'log-entry ' + logClass;
-                            logEntry.textContent = log;
-                            logsContainer.appendChild(logEntry);
-                        }
-                    }
-                })
-                .catch(error => {
-                    console.error('获取日志失败:', error);
-                });
-        }
-        
-        // 获取系统状态
-        function getStatus() {
-            Promise.all([
-                fetch('/api/v1/scheduler').then(response => response.json()),
-                fetch('/api/v1/network').then(response => response.json())
-            ])
-            .then(([schedulerData, networkData]) => {
-                const status = document.getElementById('statusContainer');
-                const schedulerStatus = schedulerData.running ? '运行中' : '已停止';
-                const networkMode = networkData.use_proxy ? '代理模式' : '直接连接';
-                
-                status.innerHTML = 
-                    '<strong>系统状态:</strong> 正常运行<br>' +
-                    '<strong>定时任务:</strong> ' + schedulerStatus + '<br>' +
-                    '<strong>网络模式:</strong> ' + networkMode + '<br>' +
-                    '<strong>更新时间:</strong> ' + new Date().toLocaleString();
-            })
-            .catch(error => {
-                console.error('获取状态失败:', error);
-                document.getElementById('statusContainer').innerHTML = 
-                    '<strong>系统状态:</strong> 无法获取状态信息<br>' +
-                    '<strong>错误信息:</strong> ' + error.message;
-            });
-        }
-        
-        // 开始自动刷新计时器
-        function startRefreshTimer() {
-            clearTimeout(refreshTimer);
-            countdown = 10;
-            updateCountdown();
-            
-            function updateCountdown() {
-                if (countdown > 0) {
-                    document.getElementById('refreshIndicator').textContent = '(' + countdown + '秒后刷新)';
-                    countdown--;
-                    refreshTimer = setTimeout(updateCountdown, 1000);
-                } else {
-                    refreshLogs();
-                    getStatus();
-                    countdown = 10;
-                    if (autoRefreshEnabled) {
-                        startRefreshTimer();
-                    }
-                }
-            }
-        }
-    </script>
-</body>
-</html>
-`
-
-	return html
+	respondMessage(c, "定时任务已停止", currentSchedulerStatus())
 }
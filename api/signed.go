@@ -0,0 +1,114 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 签名请求的时间戳与币安服务器时间相差过大时返回的错误码
+const binanceTimestampCode = -1021
+
+// 本地时钟与币安服务器时间的差值（毫秒），签名请求的时间戳按此修正
+var serverTimeOffset int64
+
+// ErrNoAPISecret 未配置API密钥或私钥时无法请求需要签名的接口
+var ErrNoAPISecret = errors.New("未配置 BINANCE_API_KEY 和 BINANCE_API_SECRET，无法请求需要签名的接口")
+
+// binanceSignedGet 请求需要签名的接口（账户余额、挂单、成交记录等），附加时间戳并按HMAC-SHA256签名
+// 时间戳被拒绝时同步一次服务器时间后重试
+func binanceSignedGet(path string, params url.Values) ([]byte, error) {
+	if appConfig == nil || appConfig.Binance.APIKey == "" || appConfig.Binance.APISecret == "" {
+		return nil, ErrNoAPISecret
+	}
+
+	body, err := binanceGet(signedPath(path, params))
+	var apiErr *binanceError
+	if errors.As(err, &apiErr) && apiErr.Code == binanceTimestampCode {
+		utils.LogWarning("签名请求的时间戳被拒绝，同步币安服务器时间后重试: %v", err)
+		if syncErr := syncServerTime(); syncErr != nil {
+			return nil, err
+		}
+		body, err = binanceGet(signedPath(path, params))
+	}
+	return body, err
+}
+
+// signedPath 附加timestamp、recvWindow和signature参数
+func signedPath(path string, params url.Values) string {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli()+atomic.LoadInt64(&serverTimeOffset), 10))
+	if appConfig.Binance.RecvWindow > 0 {
+		query.Set("recvWindow", strconv.Itoa(appConfig.Binance.RecvWindow))
+	}
+
+	encoded := query.Encode()
+	return path + "?" + encoded + "&signature=" + signQuery(appConfig.Binance.APISecret, encoded)
+}
+
+// isSignedURL 是否为带签名的请求地址；签名、时间戳和recvWindow不能出现在日志和返回的错误中
+func isSignedURL(rawURL string) bool {
+	return strings.Contains(rawURL, "signature=")
+}
+
+// redactURL 去掉签名请求地址中的查询参数，其他地址原样返回
+func redactURL(rawURL string) string {
+	if !isSignedURL(rawURL) {
+		return rawURL
+	}
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		return rawURL[:i] + "?<已隐藏签名参数>"
+	}
+	return rawURL
+}
+
+// redactError 签名请求失败时把 *url.Error 中的地址换成去掉查询参数的地址，其他错误原样返回
+func redactError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || !isSignedURL(urlErr.URL) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: redactURL(urlErr.URL), Err: urlErr.Err}
+}
+
+// signQuery 用私钥对查询字符串计算HMAC-SHA256签名
+func signQuery(secret, query string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(query))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// syncServerTime 获取币安服务器时间并记录与本地时钟的差值，按请求往返时间的一半修正
+func syncServerTime() error {
+	start := time.Now()
+	body, err := binanceGet("/api/v3/time")
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+
+	rtt := time.Since(start)
+	offset := resp.ServerTime - start.Add(rtt/2).UnixMilli()
+	atomic.StoreInt64(&serverTimeOffset, offset)
+	utils.SetGauge("biupdata_binance_clock_offset_ms", float64(offset))
+	utils.LogInfo("本地时钟与币安服务器相差 %d 毫秒", offset)
+	return nil
+}
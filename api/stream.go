@@ -0,0 +1,250 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"golang.org/x/net/websocket"
+)
+
+// 低延迟模式固定订阅的时间间隔
+const slaInterval = "5m"
+
+// wsKlineEvent 币安WebSocket K线推送结构
+type wsKlineEvent struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	Symbol    string `json:"s"`
+	Kline     struct {
+		OpenTime  int64  `json:"t"`
+		CloseTime int64  `json:"T"`
+		Interval  string `json:"i"`
+		Open      string `json:"o"`
+		Close     string `json:"c"`
+		High      string `json:"h"`
+		Low       string `json:"l"`
+		Volume    string `json:"v"`
+		IsClosed  bool   `json:"x"`
+	} `json:"k"`
+}
+
+// StreamCandle 推送给订阅者的已收盘K线
+type StreamCandle struct {
+	Symbol     string  `json:"symbol"`
+	Interval   string  `json:"interval"`
+	Timestamp  int64   `json:"timestamp"`
+	OpenPrice  string  `json:"open_price"`
+	ClosePrice string  `json:"close_price"`
+	HighPrice  string  `json:"high_price"`
+	LowPrice   string  `json:"low_price"`
+	Volume     string  `json:"volume"`
+	LatencyMs  float64 `json:"latency_ms"`
+}
+
+var (
+	streamStop  chan struct{} // 订阅运行中时非nil，由streamMu保护
+	streamMu    sync.Mutex
+	subscribers = make(map[chan StreamCandle]string) // 订阅通道 -> 过滤的交易对（空表示全部）
+	subMu       sync.Mutex
+)
+
+// StartSLAStreams 为配置的交易对启动WebSocket订阅，已启动时不重复启动；
+// 高可用模式下由主节点选举在成为主节点时调用，失去主节点时停止
+func StartSLAStreams(cfg *config.Config) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if len(cfg.Binance.SLASymbols) == 0 || streamStop != nil {
+		return
+	}
+	if replaying(defaultUpdater.Exchange) {
+		utils.LogWarning("回放模式下不启动低延迟模式的WebSocket订阅")
+		return
+	}
+
+	streamStop = make(chan struct{})
+	for _, symbol := range cfg.Binance.SLASymbols {
+		go runKlineStream(cfg, strings.ToUpper(symbol), streamStop)
+	}
+	utils.LogInfo("低延迟模式已启动，交易对: %v，目标延迟: %d秒", cfg.Binance.SLASymbols, cfg.Binance.SLATargetSeconds)
+}
+
+// StopSLAStreams 停止所有WebSocket订阅
+func StopSLAStreams() {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if streamStop != nil {
+		close(streamStop)
+		streamStop = nil
+	}
+}
+
+// runKlineStream 维持单个交易对的WebSocket连接，断线后按退避时间重连，stop关闭时退出
+func runKlineStream(cfg *config.Config, symbol string, stop chan struct{}) {
+	backoff := time.Second
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		err := func() (err error) {
+			// 异常消息导致的panic按连接中断处理，重连后继续订阅
+			defer utils.Recover("kline_stream", &err)
+			return consumeKlineStream(cfg, symbol, stop)
+		}()
+		if err == nil {
+			return
+		}
+
+		utils.LogWarning("%s WebSocket连接中断: %v，%v后重连", symbol, err, backoff)
+		utils.IncCounter(utils.MetricName("biupdata_stream_reconnects_total", "symbol", symbol))
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// consumeKlineStream 读取WebSocket推送直到连接断开或收到停止信号
+func consumeKlineStream(cfg *config.Config, symbol string, stop chan struct{}) error {
+	// 低延迟模式的交易对不一定在BINANCE_SYMBOLS中，启动时不会为它建表
+	if err := db.CreateTableIfNotExists(symbol, slaInterval); err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/ws/%s@kline_%s", strings.TrimRight(cfg.Binance.StreamURL, "/"), strings.ToLower(symbol), slaInterval)
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	utils.LogInfo("已连接币安WebSocket: %s", url)
+
+	// 收到停止信号时关闭连接以中断阻塞读取
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var event wsKlineEvent
+		if err := websocket.JSON.Receive(ws, &event); err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if event.EventType != "kline" || !event.Kline.IsClosed {
+			continue
+		}
+
+		handleClosedCandle(cfg, symbol, &event)
+	}
+}
+
+// handleClosedCandle 立即写入已收盘K线，记录延迟并推送给订阅者
+func handleClosedCandle(cfg *config.Config, symbol string, event *wsKlineEvent) {
+	klines, err := runFetchedHooks(activeHooks(), symbol, slaInterval, []db.Candle{{
+		OpenTime: event.Kline.OpenTime,
+		Open:     event.Kline.Open,
+		High:     event.Kline.High,
+		Low:      event.Kline.Low,
+		Close:    event.Kline.Close,
+		Volume:   event.Kline.Volume,
+		Closed:   true,
+	}})
+	if err != nil {
+		utils.LogError("低延迟模式写入 %s %s 失败: %v", symbol, slaInterval, err)
+		return
+	}
+	if len(klines) == 0 {
+		return
+	}
+	k := klines[0]
+
+	if err := db.SaveKlineData(symbol, slaInterval, k.OpenTime, k.Open, k.Close, k.High, k.Low, k.Volume, k.Note); err != nil {
+		utils.LogError("低延迟模式写入 %s %s 失败: %v", symbol, slaInterval, err)
+		return
+	}
+	// 查询缓存中可能有写入前的数据，删除后下一次查询读到刚收盘的K线
+	invalidateKlineCache(symbol, slaInterval)
+	runStoredHooks(activeHooks(), symbol, slaInterval, klines[:1])
+
+	// 延迟 = 写库完成时间 - K线收盘时间（收盘时间为区间最后一毫秒）
+	latency := time.Since(time.UnixMilli(event.Kline.CloseTime + 1))
+	latencySeconds := latency.Seconds()
+
+	utils.SetGauge(utils.MetricName("biupdata_sla_latency_seconds", "symbol", symbol), latencySeconds)
+	utils.IncCounter(utils.MetricName("biupdata_sla_candles_total", "symbol", symbol))
+	if latencySeconds > float64(cfg.Binance.SLATargetSeconds) {
+		utils.IncCounter(utils.MetricName("biupdata_sla_breaches_total", "symbol", symbol))
+		utils.LogWarning("%s %s 收盘K线延迟 %.2f 秒，超过目标 %d 秒", symbol, slaInterval, latencySeconds, cfg.Binance.SLATargetSeconds)
+	}
+
+	publishCandle(StreamCandle{
+		Symbol:     symbol,
+		Interval:   slaInterval,
+		Timestamp:  k.OpenTime,
+		OpenPrice:  k.Open,
+		ClosePrice: k.Close,
+		HighPrice:  k.High,
+		LowPrice:   k.Low,
+		Volume:     k.Volume,
+		LatencyMs:  float64(latency.Milliseconds()),
+	})
+}
+
+// subscribe 注册推送订阅，symbol为空表示订阅全部交易对
+func subscribe(symbol string) chan StreamCandle {
+	ch := make(chan StreamCandle, 16)
+	subMu.Lock()
+	subscribers[ch] = strings.ToUpper(symbol)
+	subMu.Unlock()
+	return ch
+}
+
+// unsubscribe 取消推送订阅
+func unsubscribe(ch chan StreamCandle) {
+	subMu.Lock()
+	delete(subscribers, ch)
+	subMu.Unlock()
+}
+
+// publishCandle 向所有匹配的订阅者推送K线，订阅者处理不过来时丢弃以免阻塞写入路径
+func publishCandle(candle StreamCandle) {
+	subMu.Lock()
+	defer subMu.Unlock()
+
+	for ch, symbol := range subscribers {
+		if symbol != "" && symbol != candle.Symbol {
+			continue
+		}
+		select {
+		case ch <- candle:
+		default:
+			utils.LogWarning("推送订阅者处理过慢，丢弃 %s K线", candle.Symbol)
+		}
+	}
+}
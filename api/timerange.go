@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// range参数支持的单位
+var rangeUnits = map[byte]time.Duration{
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseKlineRange 解析K线查询的时间范围和条数，出错时已返回响应
+// 开始时间可用start_time（毫秒）、from（RFC3339或毫秒）或range（如7d，从结束时间往前推）指定，结束时间可用end_time或to指定，
+// last=N表示最近N根K线，等同于limit=N且不限制开始时间
+// 返回的时间为毫秒时间戳字符串，未指定时为空
+func parseKlineRange(c *gin.Context) (string, string, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil {
+		badRequest(c, "无效的limit参数")
+		return "", "", 0, false
+	}
+
+	var startTime, endTime int64
+	startSet := 0
+	if v := c.Query("start_time"); v != "" {
+		if startTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的start_time参数，应为毫秒时间戳")
+			return "", "", 0, false
+		}
+		startSet++
+	}
+	if v := c.Query("from"); v != "" {
+		if startTime, err = parseTimeParam(v); err != nil {
+			badRequest(c, "无效的from参数，"+err.Error())
+			return "", "", 0, false
+		}
+		startSet++
+	}
+	if c.Query("range") != "" {
+		startSet++
+	}
+	if startSet > 1 {
+		badRequest(c, "start_time、from、range 只能指定一个")
+		return "", "", 0, false
+	}
+
+	if v := c.Query("end_time"); v != "" {
+		if endTime, err = strconv.ParseInt(v, 10, 64); err != nil {
+			badRequest(c, "无效的end_time参数，应为毫秒时间戳")
+			return "", "", 0, false
+		}
+		if c.Query("to") != "" {
+			badRequest(c, "end_time、to 只能指定一个")
+			return "", "", 0, false
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if endTime, err = parseTimeParam(v); err != nil {
+			badRequest(c, "无效的to参数，"+err.Error())
+			return "", "", 0, false
+		}
+	}
+
+	if v := c.Query("range"); v != "" {
+		d, err := parseRangeParam(v)
+		if err != nil {
+			badRequest(c, "无效的range参数，"+err.Error())
+			return "", "", 0, false
+		}
+		end := endTime
+		if end == 0 {
+			end = time.Now().UnixMilli()
+		}
+		startTime = end - d.Milliseconds()
+	}
+
+	if v := c.Query("last"); v != "" {
+		last, err := strconv.Atoi(v)
+		if err != nil || last <= 0 {
+			badRequest(c, "无效的last参数，应为正整数")
+			return "", "", 0, false
+		}
+		if c.Query("limit") != "" {
+			badRequest(c, "last 和 limit 不能同时指定")
+			return "", "", 0, false
+		}
+		if startSet > 0 {
+			badRequest(c, "last 表示最近N根K线，不能与 start_time、from、range 同时指定")
+			return "", "", 0, false
+		}
+		limit = last
+	}
+
+	if startTime < 0 || endTime < 0 {
+		badRequest(c, "时间不能早于1970-01-01")
+		return "", "", 0, false
+	}
+	if startTime > 0 && endTime > 0 && startTime > endTime {
+		badRequest(c, "开始时间不能晚于结束时间")
+		return "", "", 0, false
+	}
+
+	var start, end string
+	if startTime > 0 {
+		start = strconv.FormatInt(startTime, 10)
+	}
+	if endTime > 0 {
+		end = strconv.FormatInt(endTime, 10)
+	}
+	return start, end, limit, true
+}
+
+// parseTimeParam 解析RFC3339时间或毫秒时间戳，返回UTC毫秒时间戳
+func parseTimeParam(v string) (int64, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, errors.New("应为RFC3339时间（如 2024-01-01T00:00:00Z、2024-01-01T08:00:00+08:00）或毫秒时间戳")
+	}
+	return t.UnixMilli(), nil
+}
+
+// parseRangeParam 解析如30m、12h、7d、2w的时间长度
+func parseRangeParam(v string) (time.Duration, error) {
+	invalid := errors.New("格式应为数字加单位 m（分钟）、h（小时）、d（天）、w（周），如 7d")
+	if len(v) < 2 {
+		return 0, invalid
+	}
+	unit, ok := rangeUnits[v[len(v)-1]]
+	if !ok {
+		return 0, invalid
+	}
+	n, err := strconv.Atoi(v[:len(v)-1])
+	if err != nil || n <= 0 {
+		return 0, invalid
+	}
+	return time.Duration(n) * unit, nil
+}
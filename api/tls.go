@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 自签名证书的存放位置
+const (
+	selfSignedCertFile = "certs/self-signed.crt"
+	selfSignedKeyFile  = "certs/self-signed.key"
+)
+
+// resolveTLSFiles 返回实际使用的证书和私钥路径，必要时生成自签名证书
+func resolveTLSFiles(cfg *config.APIConfig) (string, string, error) {
+	if cfg.TLSCert != "" && cfg.TLSKey != "" {
+		return cfg.TLSCert, cfg.TLSKey, nil
+	}
+
+	// 已生成过且未过期则直接复用
+	if certValid(selfSignedCertFile) {
+		if _, err := os.Stat(selfSignedKeyFile); err == nil {
+			return selfSignedCertFile, selfSignedKeyFile, nil
+		}
+	}
+
+	utils.LogWarning("未配置TLS证书，正在生成自签名证书，仅适用于开发环境")
+	if err := generateSelfSignedCert(selfSignedCertFile, selfSignedKeyFile); err != nil {
+		return "", "", err
+	}
+	return selfSignedCertFile, selfSignedKeyFile, nil
+}
+
+// certValid 检查证书文件存在且仍在有效期内
+func certValid(certFile string) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(cert.NotAfter)
+}
+
+// generateSelfSignedCert 生成一年有效期的自签名证书，覆盖localhost和本机回环地址
+func generateSelfSignedCert(certFile, keyFile string) error {
+	if err := os.MkdirAll(filepath.Dir(certFile), 0755); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"BiUpData"}, CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+// startHTTPSRedirect 监听HTTP端口并将所有请求永久重定向到HTTPS端口
+func startHTTPSRedirect(cfg *config.APIConfig) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		target := "https://" + host
+		if cfg.Port != "443" {
+			target += ":" + cfg.Port
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	go func() {
+		utils.LogInfo("启动HTTP重定向服务，监听端口: %s", cfg.TLSRedirectPort)
+		if err := http.ListenAndServe(":"+cfg.TLSRedirectPort, handler); err != nil {
+			utils.LogError("HTTP重定向服务异常退出: %v", err)
+		}
+	}()
+}
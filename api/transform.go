@@ -0,0 +1,127 @@
+package api
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// K线变换类型
+const (
+	transformHeikinAshi = "heikin_ashi"
+	transformRenko      = "renko"
+)
+
+// TransformInfo K线变换说明
+type TransformInfo struct {
+	Type      string  `json:"type"`
+	BrickSize float64 `json:"brick_size,omitempty"` // Renko砖块大小（价格单位）
+}
+
+// parseTransform 解析transform和brick_size参数，未指定transform时返回nil，出错时已返回响应
+func parseTransform(c *gin.Context) (*TransformInfo, bool) {
+	switch t := c.Query("transform"); t {
+	case "":
+		return nil, true
+	case transformHeikinAshi:
+		return &TransformInfo{Type: t}, true
+	case transformRenko:
+		size, err := strconv.ParseFloat(c.Query("brick_size"), 64)
+		if err != nil || size <= 0 || math.IsInf(size, 0) {
+			badRequest(c, "transform=renko 需要正数的brick_size参数")
+			return nil, false
+		}
+		return &TransformInfo{Type: t, BrickSize: size}, true
+	default:
+		badRequest(c, "无效的transform参数，可选 heikin_ashi、renko")
+		return nil, false
+	}
+}
+
+// transformKlines 对按时间倒序的K线做变换，返回结果同样按时间倒序
+func transformKlines(klines []KlineItem, info *TransformInfo) []KlineItem {
+	asc := make([]KlineItem, len(klines))
+	for i, k := range klines {
+		asc[len(klines)-1-i] = k
+	}
+
+	var result []KlineItem
+	if info.Type == transformRenko {
+		result = renkoKlines(asc, info.BrickSize)
+	} else {
+		result = heikinAshiKlines(asc)
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// heikinAshiKlines 由升序K线计算平均K线：收盘为四价均值，开盘为前一根平均K线开盘与收盘的均值，
+// 第一根的开盘取原始开盘与收盘的均值；成交量和标注保持不变
+func heikinAshiKlines(klines []KlineItem) []KlineItem {
+	result := make([]KlineItem, len(klines))
+	var prevOpen, prevClose float64
+	for i, k := range klines {
+		o, h, l, c := parseDecimal(k.OpenPrice), parseDecimal(k.HighPrice), parseDecimal(k.LowPrice), parseDecimal(k.ClosePrice)
+
+		haClose := (o + h + l + c) / 4
+		haOpen := (o + c) / 2
+		if i > 0 {
+			haOpen = (prevOpen + prevClose) / 2
+		}
+		prevOpen, prevClose = haOpen, haClose
+
+		result[i] = k
+		result[i].OpenPrice = formatFloat(haOpen)
+		result[i].ClosePrice = formatFloat(haClose)
+		result[i].HighPrice = formatFloat(math.Max(h, math.Max(haOpen, haClose)))
+		result[i].LowPrice = formatFloat(math.Min(l, math.Min(haOpen, haClose)))
+	}
+	return result
+}
+
+// renkoKlines 由升序K线的收盘价生成Renko砖块：收盘价超出上一块砖顶部一个砖块大小时向上加砖，
+// 低于底部一个砖块大小时向下加砖，反转因此需要移动两个砖块大小；起点为第一根K线的收盘价
+// 每块砖的时间为完成它的K线的开盘时间，成交量为上一块砖之后累计的成交量，记在该K线形成的第一块砖上
+func renkoKlines(klines []KlineItem, brick float64) []KlineItem {
+	var result []KlineItem
+	if len(klines) == 0 {
+		return result
+	}
+
+	base := parseDecimal(klines[0].ClosePrice)
+	top, bottom := base, base
+	var volume float64
+	for _, k := range klines[1:] {
+		c := parseDecimal(k.ClosePrice)
+		volume += parseDecimal(k.Volume)
+
+		for {
+			var open, close float64
+			if c >= top+brick {
+				open, close = top, top+brick
+				bottom, top = top, top+brick
+			} else if c <= bottom-brick {
+				open, close = bottom, bottom-brick
+				top, bottom = bottom, bottom-brick
+			} else {
+				break
+			}
+
+			result = append(result, KlineItem{
+				Timestamp:  k.Timestamp,
+				Datetime:   k.Datetime,
+				OpenPrice:  formatFloat(open),
+				ClosePrice: formatFloat(close),
+				HighPrice:  formatFloat(math.Max(open, close)),
+				LowPrice:   formatFloat(math.Min(open, close)),
+				Volume:     formatFloat(volume),
+			})
+			volume = 0
+		}
+	}
+	return result
+}
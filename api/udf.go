@@ -0,0 +1,314 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// TradingView UDF协议（Universal Data Feed），数据源地址为 /api/v1/udf
+// 协议规定了响应格式，这组接口不使用统一响应结构
+
+// 单次history请求最多返回的K线数量
+const udfMaxBars = 5000
+
+// udfExchange 交易所名称
+const udfExchange = "BINANCE"
+
+// resolutionToInterval 将TradingView的分辨率转换为时间间隔，如 5 -> 5m、240 -> 4h、1D -> 1d
+func resolutionToInterval(resolution string) (string, bool) {
+	switch strings.ToUpper(resolution) {
+	case "D", "1D":
+		return "1d", true
+	case "3D":
+		return "3d", true
+	case "W", "1W":
+		return "1w", true
+	case "M", "1M":
+		return "1M", true
+	}
+
+	if strings.HasSuffix(resolution, "S") {
+		n, err := strconv.Atoi(strings.TrimSuffix(resolution, "S"))
+		if err != nil || n <= 0 {
+			return "", false
+		}
+		return strconv.Itoa(n) + "s", true
+	}
+
+	minutes, err := strconv.Atoi(resolution)
+	if err != nil || minutes <= 0 {
+		return "", false
+	}
+	if minutes%60 == 0 {
+		return strconv.Itoa(minutes/60) + "h", true
+	}
+	return strconv.Itoa(minutes) + "m", true
+}
+
+// intervalToResolution 将时间间隔转换为TradingView的分辨率
+func intervalToResolution(interval string) string {
+	n := interval[:len(interval)-1]
+	switch interval[len(interval)-1] {
+	case 's':
+		return n + "S"
+	case 'm':
+		return n
+	case 'h':
+		hours, _ := strconv.Atoi(n)
+		return strconv.Itoa(hours * 60)
+	case 'd':
+		return n + "D"
+	case 'w':
+		return n + "W"
+	default:
+		return n + "M"
+	}
+}
+
+// udfResolutions 时间间隔对应的分辨率
+func udfResolutions(intervals []string) []string {
+	var result []string
+	for _, interval := range intervals {
+		result = append(result, intervalToResolution(interval))
+	}
+	return result
+}
+
+// udfError 按UDF协议返回错误
+func udfError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{
+		"s":      "error",
+		"errmsg": message,
+	})
+}
+
+// udfSymbolConfigured 交易对是否在采集范围内
+func udfSymbolConfigured(symbol string) bool {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	return containsSymbol(appConfig.Binance.CurrentSymbols(), symbol)
+}
+
+// udfSymbolName 去掉 BINANCE: 前缀并转为大写
+func udfSymbolName(s string) string {
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		s = s[idx+1:]
+	}
+	return strings.ToUpper(s)
+}
+
+// getUDFConfig 数据源配置
+func getUDFConfig(c *gin.Context) {
+	if appConfig == nil {
+		udfError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"supported_resolutions":    udfResolutions(appConfig.Binance.AllIntervals()),
+		"supports_search":          true,
+		"supports_group_request":   false,
+		"supports_marks":           false,
+		"supports_timescale_marks": false,
+		"supports_time":            true,
+		"exchanges": []gin.H{
+			{"value": udfExchange, "name": "Binance", "desc": "Binance"},
+		},
+		"symbols_types": []gin.H{
+			{"name": "crypto", "value": "crypto"},
+		},
+	})
+}
+
+// getUDFTime 服务器时间（秒）
+func getUDFTime(c *gin.Context) {
+	c.String(http.StatusOK, strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// udfSymbolInfo 交易对信息，价格精度取自交易对元数据的tickSize
+func udfSymbolInfo(symbol string) gin.H {
+	info := gin.H{
+		"name":                   symbol,
+		"ticker":                 symbol,
+		"description":            symbol,
+		"type":                   "crypto",
+		"session":                "24x7",
+		"exchange":               udfExchange,
+		"listed_exchange":        udfExchange,
+		"timezone":               "Etc/UTC",
+		"minmov":                 1,
+		"pricescale":             100,
+		"has_intraday":           true,
+		"has_seconds":            false,
+		"has_daily":              true,
+		"has_weekly_and_monthly": true,
+		"supported_resolutions":  udfResolutions(appConfig.Binance.IntervalsFor(symbol)),
+		"volume_precision":       8,
+		"data_status":            "streaming",
+	}
+
+	if meta, ok := GetExchangeSymbol(symbol); ok {
+		info["description"] = meta.BaseAsset + "/" + meta.QuoteAsset
+		if scale := priceScale(meta.TickSize); scale > 0 {
+			info["pricescale"] = scale
+		}
+	}
+	return info
+}
+
+// priceScale 由最小价格变动单位计算pricescale，如 0.01000000 -> 100
+func priceScale(tickSize string) int64 {
+	tick, err := strconv.ParseFloat(tickSize, 64)
+	if err != nil || tick <= 0 || tick >= 1 {
+		return 0
+	}
+	return int64(math.Round(1 / tick))
+}
+
+// getUDFSymbols 查询单个交易对信息
+func getUDFSymbols(c *gin.Context) {
+	if appConfig == nil {
+		udfError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	symbol := udfSymbolName(c.Query("symbol"))
+	if symbol == "" || !udfSymbolConfigured(symbol) {
+		udfError(c, http.StatusNotFound, "unknown_symbol")
+		return
+	}
+	c.JSON(http.StatusOK, udfSymbolInfo(symbol))
+}
+
+// getUDFSearch 按名称搜索采集范围内的交易对
+func getUDFSearch(c *gin.Context) {
+	if appConfig == nil {
+		udfError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	query := strings.ToUpper(c.Query("query"))
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 {
+		limit = 30
+	}
+
+	updateMutex.Lock()
+	symbols := appConfig.Binance.CurrentSymbols()
+	updateMutex.Unlock()
+
+	results := []gin.H{}
+	for _, symbol := range symbols {
+		if len(results) >= limit {
+			break
+		}
+		if query != "" && !strings.Contains(symbol, query) {
+			continue
+		}
+		results = append(results, gin.H{
+			"symbol":      symbol,
+			"full_name":   udfExchange + ":" + symbol,
+			"description": symbol,
+			"exchange":    udfExchange,
+			"ticker":      symbol,
+			"type":        "crypto",
+		})
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// getUDFHistory 按时间范围返回K线，from/to为秒，countback指定时返回to之前的最近countback根
+func getUDFHistory(c *gin.Context) {
+	if appConfig == nil {
+		udfError(c, http.StatusInternalServerError, errConfigNotInitialized.Error())
+		return
+	}
+
+	symbol := udfSymbolName(c.Query("symbol"))
+	if symbol == "" || !udfSymbolConfigured(symbol) {
+		udfError(c, http.StatusNotFound, "unknown_symbol")
+		return
+	}
+
+	interval, ok := resolutionToInterval(c.Query("resolution"))
+	if !ok || !containsSymbol(appConfig.Binance.IntervalsFor(symbol), interval) {
+		udfError(c, http.StatusBadRequest, "不支持的分辨率: "+c.Query("resolution"))
+		return
+	}
+
+	from, err1 := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, err2 := strconv.ParseInt(c.Query("to"), 10, 64)
+	if err1 != nil || err2 != nil || to < from {
+		udfError(c, http.StatusBadRequest, "无效的from/to参数")
+		return
+	}
+
+	limit := udfMaxBars
+	startMs := from * 1000
+	if v := c.Query("countback"); v != "" {
+		if countback, err := strconv.Atoi(v); err == nil && countback > 0 {
+			if countback < limit {
+				limit = countback
+			}
+			startMs = 0
+		}
+	}
+	endMs := to*1000 - 1
+
+	rows, err := querySeries(symbol, interval, startMs, endMs, limit)
+	if errors.Is(err, db.ErrQueryTimeout) {
+		udfError(c, http.StatusGatewayTimeout, "数据库查询超时，请缩小查询范围后重试")
+		return
+	}
+	if err != nil {
+		udfError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(rows) == 0 {
+		// 区间内没有数据时告知更早一根K线的时间，图表据此继续向前加载
+		resp := gin.H{"s": "no_data"}
+		if startMs <= 0 {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		if prev, err := querySeries(symbol, interval, 0, startMs-1, 1); err == nil && len(prev) > 0 {
+			resp["nextTime"] = utils.StoredTimestampToUTC(prev[0].Timestamp) / 1000
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	t := make([]int64, len(rows))
+	o := make([]float64, len(rows))
+	h := make([]float64, len(rows))
+	l := make([]float64, len(rows))
+	cl := make([]float64, len(rows))
+	v := make([]float64, len(rows))
+	for i, row := range rows {
+		t[i] = utils.StoredTimestampToUTC(row.Timestamp) / 1000
+		o[i] = row.Open
+		h[i] = row.High
+		l[i] = row.Low
+		cl[i] = row.Close
+		v[i] = row.Volume
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"s": "ok",
+		"t": t,
+		"o": o,
+		"h": h,
+		"l": l,
+		"c": cl,
+		"v": v,
+	})
+}
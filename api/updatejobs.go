@@ -0,0 +1,189 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 保留的已结束更新任务数量
+const maxFinishedUpdateJobs = 100
+
+// UpdateJob 一个交易对一个时间间隔的一次更新，同一交易对和时间间隔同时只有一个任务在执行
+type UpdateJob struct {
+	ID         string `json:"id"`
+	Symbol     string `json:"symbol"`
+	Interval   string `json:"interval"`
+	Source     string `json:"source"` // 发起方：scheduler、manual、catchup、rebuild
+	Status     string `json:"status"` // pending、running、done、failed、skipped
+	Updated    int    `json:"updated"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Coalesced  int    `json:"coalesced"` // 合并到该任务的重复请求次数
+}
+
+// claimedJob claimUpdateJobs 的结果，owned为false表示已有同一交易对和时间间隔的任务在执行，本次请求合并到该任务
+type claimedJob struct {
+	job   *UpdateJob
+	owned bool
+}
+
+// updateJobRegistry 更新任务列表：同一交易对和时间间隔同时只执行一个任务，保留最近结束的任务供查询
+type updateJobRegistry struct {
+	inflight map[string]*UpdateJob // 按 交易对 时间间隔
+	jobs     map[string]*UpdateJob // 按任务ID，包括最近结束的任务
+	finished []string              // 已结束任务的ID，按结束顺序
+	mu       sync.Mutex
+}
+
+// newUpdateJobRegistry 创建空的任务列表
+func newUpdateJobRegistry() *updateJobRegistry {
+	return &updateJobRegistry{
+		inflight: make(map[string]*UpdateJob),
+		jobs:     make(map[string]*UpdateJob),
+	}
+}
+
+// sharedUpdateJobs 默认更新流程、回补和重建共用的任务列表，HTTP接口查询的就是它
+var sharedUpdateJobs = newUpdateJobRegistry()
+
+// claimUpdateJobs 在共用的任务列表中登记更新任务
+func claimUpdateJobs(symbol string, intervals []string, source string) []claimedJob {
+	return sharedUpdateJobs.claim(symbol, intervals, source)
+}
+
+// startUpdateJob 标记共用任务列表中的任务开始执行
+func startUpdateJob(job *UpdateJob) {
+	sharedUpdateJobs.start(job)
+}
+
+// finishUpdateJob 标记共用任务列表中的任务结束
+func finishUpdateJob(job *UpdateJob, updated int, err error) {
+	sharedUpdateJobs.finish(job, updated, err)
+}
+
+// skipUpdateJob 共用任务列表中的任务未执行就结束
+func skipUpdateJob(job *UpdateJob) {
+	sharedUpdateJobs.skip(job)
+}
+
+// claim 为每个时间间隔登记更新任务，已有任务在执行时返回已有任务
+func (r *updateJobRegistry) claim(symbol string, intervals []string, source string) []claimedJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	claimed := make([]claimedJob, 0, len(intervals))
+	for _, interval := range intervals {
+		key := updateJobKey(symbol, interval)
+		if job, ok := r.inflight[key]; ok {
+			job.Coalesced++
+			utils.IncCounter(utils.MetricName("biupdata_update_coalesced_total", "source", source))
+			claimed = append(claimed, claimedJob{job: job})
+			continue
+		}
+
+		job := &UpdateJob{
+			ID:        newRequestID(),
+			Symbol:    symbol,
+			Interval:  interval,
+			Source:    source,
+			Status:    "pending",
+			CreatedAt: now,
+		}
+		r.inflight[key] = job
+		r.jobs[job.ID] = job
+		claimed = append(claimed, claimedJob{job: job, owned: true})
+	}
+	return claimed
+}
+
+// updateJobKey 同一交易对和时间间隔的任务互斥，交易对不区分大小写
+func updateJobKey(symbol, interval string) string {
+	return strings.ToUpper(symbol) + " " + interval
+}
+
+// start 标记任务开始执行
+func (r *updateJobRegistry) start(job *UpdateJob) {
+	r.mu.Lock()
+	job.Status = "running"
+	r.mu.Unlock()
+}
+
+// finish 标记任务结束并释放该交易对和时间间隔
+func (r *updateJobRegistry) finish(job *UpdateJob, updated int, err error) {
+	status := "done"
+	if err != nil {
+		status = "failed"
+	}
+	r.end(job, status, updated, err)
+}
+
+// skip 任务未执行就结束，如正由其他实例更新或交易对已下架
+func (r *updateJobRegistry) skip(job *UpdateJob) {
+	r.end(job, "skipped", 0, nil)
+}
+
+// end 记录任务结果，已结束的任务不会重复处理
+func (r *updateJobRegistry) end(job *UpdateJob, status string, updated int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job.FinishedAt != "" {
+		return
+	}
+	job.Status = status
+	job.Updated = updated
+	if err != nil {
+		job.Error = err.Error()
+	}
+	job.FinishedAt = utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	delete(r.inflight, updateJobKey(job.Symbol, job.Interval))
+
+	r.finished = append(r.finished, job.ID)
+	if len(r.finished) > maxFinishedUpdateJobs {
+		delete(r.jobs, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
+
+// UpdateJobList 更新任务列表
+type UpdateJobList struct {
+	Jobs  []UpdateJob `json:"jobs"`
+	Count int         `json:"count"`
+}
+
+// getUpdateJobs 查询正在执行和最近结束的更新任务，按创建时间倒序
+func getUpdateJobs(c *gin.Context) {
+	sharedUpdateJobs.mu.Lock()
+	jobs := make([]UpdateJob, 0, len(sharedUpdateJobs.jobs))
+	for _, job := range sharedUpdateJobs.jobs {
+		jobs = append(jobs, *job)
+	}
+	sharedUpdateJobs.mu.Unlock()
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt > jobs[j].CreatedAt })
+	respondOK(c, UpdateJobList{Jobs: jobs, Count: len(jobs)})
+}
+
+// getUpdateJob 按ID查询更新任务
+func getUpdateJob(c *gin.Context) {
+	sharedUpdateJobs.mu.Lock()
+	job, ok := sharedUpdateJobs.jobs[c.Param("id")]
+	var result UpdateJob
+	if ok {
+		result = *job
+	}
+	sharedUpdateJobs.mu.Unlock()
+
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "更新任务不存在或已过期")
+		return
+	}
+	respondOK(c, result)
+}
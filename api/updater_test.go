@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/binancetest"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// newTestUpdater 启动模拟币安服务器，返回访问它并写入内存存储的更新流程
+func newTestUpdater(t *testing.T) (*Updater, *binancetest.Server, *db.MemoryStore) {
+	t.Helper()
+	srv := binancetest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddSymbol("BTCUSDT", time.Now().Add(-48*time.Hour))
+
+	store := db.NewMemoryStore()
+	return NewUpdater(NewHTTPExchange(srv.URL), store), srv, store
+}
+
+func TestUpdateSymbolDataStoresCandles(t *testing.T) {
+	u, _, store := newTestUpdater(t)
+
+	current := intervalStart("1h", time.Now().UnixMilli())
+	watermark := current - 10*time.Hour.Milliseconds()
+	store.AdvanceSyncWatermark("BTCUSDT", "1h", watermark)
+
+	result, err := u.UpdateSymbolData("BTCUSDT", []string{"1h"})
+	if err != nil {
+		t.Fatalf("UpdateSymbolData: %v", err)
+	}
+	if result["1h"] != 10 {
+		t.Fatalf("updated = %d, want 10", result["1h"])
+	}
+
+	stored := store.Klines("BTCUSDT", "1h")
+	if len(stored) != 10 {
+		t.Fatalf("stored %d candles, want 10", len(stored))
+	}
+	for i, k := range stored {
+		openTime := k.Timestamp
+		if want := watermark + int64(i+1)*time.Hour.Milliseconds(); openTime != want {
+			t.Fatalf("candle %d open time = %d, want %d", i, openTime, want)
+		}
+		want := binancetest.Kline("BTCUSDT", "1h", openTime)
+		if k.Open != want[1] || k.High != want[2] || k.Low != want[3] || k.Close != want[4] || k.Volume != want[5] {
+			t.Fatalf("candle %d = %+v, want %v", i, k, want[:6])
+		}
+	}
+
+	// 最新一根为未收盘的当前K线，读出的口径与MySQL相同
+	if last, ok, _ := store.LastKlineTimestamp("BTCUSDT", "1h"); !ok || utils.StoredTimestampToUTC(last) != current {
+		t.Fatalf("last stored timestamp = %d, want %d", utils.StoredTimestampToUTC(last), current)
+	}
+
+	// 未收盘的K线不计入水位
+	state, err := store.GetSyncState("BTCUSDT", "1h")
+	if err != nil || state == nil {
+		t.Fatalf("GetSyncState: %v, %v", state, err)
+	}
+	if want := current - time.Hour.Milliseconds(); state.Watermark != want {
+		t.Fatalf("watermark = %d, want %d", state.Watermark, want)
+	}
+}
+
+func TestUpdateSymbolDataKeepsWatermarkOnFailure(t *testing.T) {
+	u, srv, store := newTestUpdater(t)
+
+	watermark := intervalStart("1h", time.Now().UnixMilli()) - 5*time.Hour.Milliseconds()
+	store.AdvanceSyncWatermark("BTCUSDT", "1h", watermark)
+	srv.FailNext(http.StatusInternalServerError, 1)
+
+	if _, err := u.UpdateSymbolData("BTCUSDT", []string{"1h"}); err == nil {
+		t.Fatal("UpdateSymbolData succeeded, want error")
+	}
+	if n := len(store.Klines("BTCUSDT", "1h")); n != 0 {
+		t.Fatalf("stored %d candles after failure, want 0", n)
+	}
+	state, _ := store.GetSyncState("BTCUSDT", "1h")
+	if state == nil || state.Watermark != watermark {
+		t.Fatalf("watermark changed after failure: %+v", state)
+	}
+
+	// 服务恢复后从原水位继续
+	result, err := u.UpdateSymbolData("BTCUSDT", []string{"1h"})
+	if err != nil {
+		t.Fatalf("UpdateSymbolData: %v", err)
+	}
+	if result["1h"] != 5 {
+		t.Fatalf("updated = %d, want 5", result["1h"])
+	}
+}
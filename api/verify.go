@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 数值比较的相对误差容忍度，数据库保留8位小数
+const verifyTolerance = 1e-9
+
+// VerifyDivergence 数据库与币安数据不一致的一处记录
+type VerifyDivergence struct {
+	Time    string `json:"time"`  // K线开盘时间（上海时间）
+	Kind    string `json:"kind"`  // missing: 数据库缺失；extra: 币安没有该K线；mismatch: 数值不一致
+	Field   string `json:"field"` // 不一致的字段，仅mismatch时有值
+	Stored  string `json:"stored,omitempty"`
+	Binance string `json:"binance,omitempty"`
+}
+
+// VerifyReport 单个交易对和时间间隔的校验结果
+type VerifyReport struct {
+	Symbol      string             `json:"symbol"`
+	Interval    string             `json:"interval"`
+	Samples     int                `json:"samples"`
+	Compared    int                `json:"compared"`
+	Divergences []VerifyDivergence `json:"divergences"`
+}
+
+// verifyFields 参与比较的字段：数据库列名及其在K线中的取值
+var verifyFields = []struct {
+	column string
+	value  func(db.Candle) string
+}{
+	{"open_price", func(k db.Candle) string { return k.Open }},
+	{"high_price", func(k db.Candle) string { return k.High }},
+	{"low_price", func(k db.Candle) string { return k.Low }},
+	{"close_price", func(k db.Candle) string { return k.Close }},
+	{"volume", func(k db.Candle) string { return k.Volume }},
+}
+
+// VerifySymbolInterval 在已有数据范围内随机抽取samples个窗口，重新从币安获取并与数据库逐根比较
+func VerifySymbolInterval(symbol, interval string, samples, window int) (*VerifyReport, error) {
+	report := &VerifyReport{Symbol: symbol, Interval: interval, Divergences: []VerifyDivergence{}}
+
+	first, last, count, err := db.GetKlineTimeRange(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return report, nil
+	}
+	firstUTC := utils.StoredTimestampToUTC(first)
+	lastUTC := utils.StoredTimestampToUTC(last)
+
+	// 最后一根K线可能尚未收盘，不参与比较
+	closedBefore := intervalStart(interval, time.Now().UnixMilli())
+
+	for i := 0; i < samples; i++ {
+		start := randomWindowStart(interval, firstUTC, lastUTC, window)
+		end := advanceIntervals(interval, start, window) - 1
+		if end >= closedBefore {
+			end = closedBefore - 1
+		}
+		if end < start {
+			continue
+		}
+
+		if err := verifyWindow(report, symbol, interval, start, end, window); err != nil {
+			return report, err
+		}
+		report.Samples++
+	}
+
+	return report, nil
+}
+
+// randomWindowStart 在[firstUTC, lastUTC]范围内随机选择一个窗口的起始周期
+func randomWindowStart(interval string, firstUTC, lastUTC int64, window int) int64 {
+	bars := countIntervalBars(interval, firstUTC, lastUTC) + 1
+	offset := int64(0)
+	if bars > int64(window) {
+		offset = rand.Int63n(bars - int64(window) + 1)
+	}
+	return intervalStart(interval, advanceIntervals(interval, firstUTC, int(offset)))
+}
+
+// verifyWindow 比较[startUTC, endUTC]范围内的K线
+func verifyWindow(report *VerifyReport, symbol, interval string, startUTC, endUTC int64, window int) error {
+	klines, err := FetchKlineData(context.Background(), symbol, interval, startUTC, endUTC, window)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.GetKlineData(symbol, interval, startUTC, endUTC, window)
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[int64]db.Candle, len(rows))
+	for _, row := range rows {
+		stored[utils.StoredTimestampToUTC(row.OpenTime)] = row
+	}
+
+	for _, kline := range klines {
+		openTime := kline.OpenTime
+		label := utils.TimestampToShanghai(openTime).Format("2006-01-02 15:04")
+
+		row, ok := stored[openTime]
+		if !ok {
+			report.Divergences = append(report.Divergences, VerifyDivergence{Time: label, Kind: "missing"})
+			continue
+		}
+		delete(stored, openTime)
+		report.Compared++
+
+		for _, f := range verifyFields {
+			remote, local := f.value(kline), f.value(row)
+			if !decimalEqual(local, remote) {
+				report.Divergences = append(report.Divergences, VerifyDivergence{
+					Time: label, Kind: "mismatch", Field: f.column, Stored: local, Binance: remote,
+				})
+			}
+		}
+	}
+
+	// 剩下的记录在币安不存在，通常是时区换算错误写入了错位的时间戳
+	for openTime := range stored {
+		report.Divergences = append(report.Divergences, VerifyDivergence{
+			Time: utils.TimestampToShanghai(openTime).Format("2006-01-02 15:04"),
+			Kind: "extra",
+		})
+	}
+	return nil
+}
+
+// decimalEqual 按数值比较两个十进制字符串
+func decimalEqual(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return math.Abs(x-y) <= verifyTolerance*math.Max(1, math.Max(math.Abs(x), math.Abs(y)))
+}
+
+// RunVerification 校验所有配置的交易对和时间间隔，返回各自的校验结果
+func RunVerification(cfg *config.Config) []*VerifyReport {
+	var reports []*VerifyReport
+	total := 0
+
+	for _, symbol := range cfg.Binance.CurrentSymbols() {
+		for _, interval := range cfg.Binance.IntervalsFor(symbol) {
+			report, err := VerifySymbolInterval(symbol, interval, cfg.Verify.Samples, cfg.Verify.Window)
+			if err != nil {
+				utils.LogError("校验 %s %s 数据失败: %v", symbol, interval, err)
+				continue
+			}
+			reports = append(reports, report)
+			total += len(report.Divergences)
+
+			utils.SetGauge(utils.MetricName("biupdata_verify_divergences", "symbol", symbol, "interval", interval), float64(len(report.Divergences)))
+			if len(report.Divergences) > 0 {
+				utils.LogWarning("%s %s 数据校验发现 %d 处不一致（比较 %d 根K线）", symbol, interval, len(report.Divergences), report.Compared)
+			}
+		}
+	}
+
+	utils.SetGauge("biupdata_verify_last_run_timestamp_seconds", float64(time.Now().Unix()))
+	utils.LogInfo("数据校验完成，共 %d 个交易对/时间间隔，发现 %d 处不一致", len(reports), total)
+	return reports
+}
+
+// AddVerifyTask 添加定期抽样校验数据的定时任务
+func AddVerifyTask(cfg *config.Config) error {
+	if !cfg.Verify.Enabled {
+		return nil
+	}
+	if scheduler == nil {
+		InitScheduler()
+	}
+
+	err := addScheduledTask("verify", cfg.Cron.VerifySchedule, func() error {
+		RunVerification(cfg)
+		return nil
+	})
+	if err != nil {
+		utils.LogError("添加数据校验任务失败: %v", err)
+		return err
+	}
+
+	utils.LogInfo("已添加数据校验任务，cron表达式: %s", cfg.Cron.VerifySchedule)
+	return nil
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 币安默认每个IP每分钟的请求权重上限，同步交易对元数据后以exchangeInfo中的REQUEST_WEIGHT为准
+const defaultWeightLimit = 6000
+
+// 保留的每分钟权重记录数
+const maxWeightHistory = 60
+
+// 单分钟权重超过上限的该比例时记录警告
+const weightWarnRatio = 0.8
+
+// weightMinute 一分钟内使用的请求权重
+type weightMinute struct {
+	Minute   string `json:"minute"` // 分钟开始时间（配置时区）
+	Weight   int    `json:"weight"`
+	Requests int    `json:"requests"`
+
+	start int64 // 分钟开始的Unix秒
+}
+
+// routeWeight 单条线路的权重使用情况，币安按出口IP计算权重，不同线路分别统计
+type routeWeight struct {
+	current   weightMinute
+	history   []weightMinute // 已结束的分钟，按时间顺序
+	limited   int            // 收到429/418的次数
+	updatedAt time.Time
+	warned    int64 // 最近一次记录警告的分钟
+}
+
+var (
+	routeWeights = make(map[string]*routeWeight)
+	weightLimit  = defaultWeightLimit
+	weightMutex  sync.Mutex
+)
+
+// recordWeight 从币安响应头X-MBX-USED-WEIGHT-1M记录线路当前分钟已使用的权重
+func recordWeight(route string, resp *http.Response) {
+	limited := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot
+	used, err := strconv.Atoi(resp.Header.Get("X-Mbx-Used-Weight-1m"))
+	if err != nil && !limited {
+		return
+	}
+
+	now := time.Now()
+	minute := now.Unix() / 60 * 60
+
+	weightMutex.Lock()
+	rw, ok := routeWeights[route]
+	if !ok {
+		rw = &routeWeight{}
+		routeWeights[route] = rw
+	}
+	if rw.current.start != minute {
+		if rw.current.start != 0 {
+			rw.history = append(rw.history, rw.current)
+			if len(rw.history) > maxWeightHistory {
+				rw.history = rw.history[len(rw.history)-maxWeightHistory:]
+			}
+		}
+		rw.current = weightMinute{Minute: utils.UTCToShanghai(time.Unix(minute, 0)).Format("2006-01-02 15:04"), start: minute}
+	}
+	rw.current.Requests++
+	if used > rw.current.Weight {
+		rw.current.Weight = used
+	}
+	if limited {
+		rw.limited++
+	}
+	rw.updatedAt = now
+	limit := weightLimit
+	warn := float64(rw.current.Weight) >= float64(limit)*weightWarnRatio && rw.warned != minute
+	if warn {
+		rw.warned = minute
+	}
+	current := rw.current.Weight
+	weightMutex.Unlock()
+
+	utils.SetGauge(utils.MetricName("biupdata_binance_used_weight", "route", route), float64(current))
+	if limited {
+		utils.IncCounter(utils.MetricName("biupdata_binance_rate_limited_total", "route", route, "status", strconv.Itoa(resp.StatusCode)))
+	}
+	if warn {
+		utils.LogWarning("线路 %s 本分钟已使用请求权重 %d，接近上限 %d", route, current, limit)
+	}
+}
+
+// setWeightLimit 设置每分钟的请求权重上限
+func setWeightLimit(limit int) {
+	if limit <= 0 {
+		return
+	}
+	weightMutex.Lock()
+	weightLimit = limit
+	weightMutex.Unlock()
+	utils.SetGauge("biupdata_binance_weight_limit", float64(limit))
+}
+
+// RouteWeightUsage 单条线路的请求权重使用情况
+type RouteWeightUsage struct {
+	Route       string         `json:"route"`
+	UsedWeight  int            `json:"used_weight"`  // 当前分钟已使用的权重，上一次请求在之前的分钟时为0
+	UsedPercent float64        `json:"used_percent"` // 占上限的百分比
+	PeakWeight  int            `json:"peak_weight"`  // 最近60分钟内单分钟的最大权重
+	AvgWeight   float64        `json:"avg_weight"`   // 最近60分钟内有请求的分钟的平均权重
+	Headroom    int            `json:"headroom"`     // 上限减去最近60分钟的峰值
+	RateLimited int            `json:"rate_limited"` // 收到429/418的次数
+	UpdatedAt   string         `json:"updated_at,omitempty"`
+	History     []weightMinute `json:"history"`
+}
+
+// WeightUsageResponse 币安API请求权重使用情况
+type WeightUsageResponse struct {
+	Limit  int                `json:"limit"` // 每分钟的请求权重上限
+	Routes []RouteWeightUsage `json:"routes"`
+}
+
+// getWeightUsage 查询各线路每分钟使用的币安API请求权重，用于在增加交易对前评估离限流还有多少余量
+func getWeightUsage(c *gin.Context) {
+	minute := time.Now().Unix() / 60 * 60
+
+	weightMutex.Lock()
+	limit := weightLimit
+	routes := make([]RouteWeightUsage, 0, len(routeWeights))
+	for name, rw := range routeWeights {
+		usage := RouteWeightUsage{
+			Route:       name,
+			RateLimited: rw.limited,
+			UpdatedAt:   utils.UTCToShanghai(rw.updatedAt).Format("2006-01-02 15:04:05"),
+			History:     make([]weightMinute, 0, len(rw.history)+1),
+		}
+		usage.History = append(usage.History, rw.history...)
+		if rw.current.start == minute {
+			usage.UsedWeight = rw.current.Weight
+		}
+		usage.History = append(usage.History, rw.current)
+
+		// 只统计最近60分钟
+		total, count := 0, 0
+		for _, m := range usage.History {
+			if m.start <= minute-maxWeightHistory*60 {
+				continue
+			}
+			total += m.Weight
+			count++
+			if m.Weight > usage.PeakWeight {
+				usage.PeakWeight = m.Weight
+			}
+		}
+		if count > 0 {
+			usage.AvgWeight = float64(total*10/count) / 10
+		}
+		usage.UsedPercent = float64(usage.UsedWeight*1000/limit) / 10
+		usage.Headroom = limit - usage.PeakWeight
+		routes = append(routes, usage)
+	}
+	weightMutex.Unlock()
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+	respondOK(c, WeightUsageResponse{Limit: limit, Routes: routes})
+}
@@ -0,0 +1,372 @@
+// Package binancetest 提供模拟币安REST接口的HTTP服务器，配合 db.MemoryStore 可以在没有网络和MySQL的环境中
+// 运行完整的K线更新流程：
+//
+//	srv := binancetest.NewServer()
+//	defer srv.Close()
+//	srv.AddSymbol("BTCUSDT", time.Now().Add(-24*time.Hour))
+//	store := db.NewMemoryStore()
+//	updater := api.NewUpdater(api.NewHTTPExchange(srv.URL), store)
+//	updater.UpdateSymbolData("BTCUSDT", []string{"1h"})
+package binancetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每分钟的请求权重上限，与币安现货一致
+const weightLimit = 6000
+
+// 各接口的请求权重
+var endpointWeights = map[string]int{
+	"/api/v3/ping":         1,
+	"/api/v3/time":         1,
+	"/api/v3/exchangeInfo": 20,
+	"/api/v3/klines":       2,
+}
+
+// 时间间隔单位对应的毫秒数，1M按自然月处理
+var unitMilliseconds = map[byte]int64{
+	's': 1000,
+	'm': 60 * 1000,
+	'h': 60 * 60 * 1000,
+	'd': 24 * 60 * 60 * 1000,
+	'w': 7 * 24 * 60 * 60 * 1000,
+}
+
+// 1970-01-01是星期四，周K线从星期一00:00(UTC)开始
+const weekAlignOffset = 4 * 24 * 60 * 60 * 1000
+
+// Server 模拟币安REST接口的HTTP服务器，K线按开盘时间确定性生成，同一根K线每次请求的结果相同
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	symbols  map[string]time.Time // 交易对及其上市时间
+	failures []int                // 接下来依次返回的错误状态码
+	requests map[string]int       // 各路径的请求次数
+	weight   int                  // 当前分钟已使用的请求权重
+	minute   int64
+
+	// Now 返回当前时间，默认为 time.Now，可以替换以固定最新一根K线
+	Now func() time.Time
+}
+
+// NewServer 启动模拟服务器，使用完后调用 Close
+func NewServer() *Server {
+	s := &Server{
+		symbols:  make(map[string]time.Time),
+		requests: make(map[string]int),
+		Now:      time.Now,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/ping", s.handlePing)
+	mux.HandleFunc("/api/v3/time", s.handleTime)
+	mux.HandleFunc("/api/v3/exchangeInfo", s.handleExchangeInfo)
+	mux.HandleFunc("/api/v3/klines", s.handleKlines)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// AddSymbol 添加交易对，listed之前没有K线
+func (s *Server) AddSymbol(symbol string, listed time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.symbols[strings.ToUpper(symbol)] = listed
+}
+
+// RemoveSymbol 移除交易对，之后请求该交易对返回无效交易对错误（-1121）
+func (s *Server) RemoveSymbol(symbol string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.symbols, strings.ToUpper(symbol))
+}
+
+// FailNext 接下来的n个请求返回status状态码，如500模拟服务端故障、429模拟限流
+func (s *Server) FailNext(status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Requests 路径的请求次数，如 /api/v3/klines
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// apiError 币安格式的错误响应
+type apiError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// middleware 统计请求次数和权重，按 FailNext 注入错误
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		minute := s.Now().Unix() / 60
+		if minute != s.minute {
+			s.minute = minute
+			s.weight = 0
+		}
+		s.weight += endpointWeights[r.URL.Path]
+		weight := s.weight
+		status := 0
+		if len(s.failures) > 0 {
+			status = s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", strconv.Itoa(weight))
+		if status != 0 {
+			writeJSON(w, status, apiError{Code: -1000, Msg: "injected failure"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]int64{"serverTime": s.Now().UnixMilli()})
+}
+
+// exchangeSymbol exchangeInfo中的一个交易对
+type exchangeSymbol struct {
+	Symbol     string              `json:"symbol"`
+	Status     string              `json:"status"`
+	BaseAsset  string              `json:"baseAsset"`
+	QuoteAsset string              `json:"quoteAsset"`
+	Filters    []map[string]string `json:"filters"`
+}
+
+// rateLimit exchangeInfo中的限流规则
+type rateLimit struct {
+	RateLimitType string `json:"rateLimitType"`
+	Interval      string `json:"interval"`
+	IntervalNum   int    `json:"intervalNum"`
+	Limit         int    `json:"limit"`
+}
+
+func (s *Server) handleExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.symbols))
+	for name := range s.symbols {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	symbols := make([]exchangeSymbol, 0, len(names))
+	for _, name := range names {
+		base, quote := splitSymbol(name)
+		symbols = append(symbols, exchangeSymbol{
+			Symbol:     name,
+			Status:     "TRADING",
+			BaseAsset:  base,
+			QuoteAsset: quote,
+			Filters: []map[string]string{
+				{"filterType": "PRICE_FILTER", "tickSize": "0.01000000"},
+				{"filterType": "LOT_SIZE", "stepSize": "0.00001000", "minQty": "0.00001000"},
+				{"filterType": "NOTIONAL", "minNotional": "5.00000000"},
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ServerTime int64            `json:"serverTime"`
+		RateLimits []rateLimit      `json:"rateLimits"`
+		Symbols    []exchangeSymbol `json:"symbols"`
+	}{
+		ServerTime: s.Now().UnixMilli(),
+		RateLimits: []rateLimit{{RateLimitType: "REQUEST_WEIGHT", Interval: "MINUTE", IntervalNum: 1, Limit: weightLimit}},
+		Symbols:    symbols,
+	})
+}
+
+// splitSymbol 按常见计价货币拆分交易对
+func splitSymbol(symbol string) (string, string) {
+	for _, quote := range []string{"USDT", "USDC", "FDUSD", "BUSD", "BTC", "ETH", "BNB"} {
+		if strings.HasSuffix(symbol, quote) && len(symbol) > len(quote) {
+			return strings.TrimSuffix(symbol, quote), quote
+		}
+	}
+	return symbol, ""
+}
+
+// handleKlines 与币安相同：有startTime时从不早于它的第一根K线开始，只有endTime时返回截至endTime的最后limit根，
+// 都没有时返回最新的limit根；limit默认500，最大1000
+func (s *Server) handleKlines(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbol := q.Get("symbol")
+	interval := q.Get("interval")
+
+	s.mu.Lock()
+	listed, ok := s.symbols[symbol]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, apiError{Code: -1121, Msg: "Invalid symbol."})
+		return
+	}
+	if _, ok := parseInterval(interval); !ok && interval != "1M" {
+		writeJSON(w, http.StatusBadRequest, apiError{Code: -1120, Msg: "Invalid interval."})
+		return
+	}
+
+	limit := 500
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSON(w, http.StatusBadRequest, apiError{Code: -1100, Msg: "Illegal characters found in parameter 'limit'."})
+			return
+		}
+		limit = n
+	}
+	startTime, hasStart := queryInt(q.Get("startTime"))
+	endTime, hasEnd := queryInt(q.Get("endTime"))
+
+	now := s.Now().UnixMilli()
+	if !hasEnd || endTime > now {
+		endTime = now
+	}
+	first := intervalStart(interval, listed.UnixMilli())
+	if first < listed.UnixMilli() {
+		first = nextIntervalStart(interval, first)
+	}
+
+	var opens []int64
+	if hasStart {
+		t := intervalStart(interval, startTime)
+		if t < startTime {
+			t = nextIntervalStart(interval, t)
+		}
+		if t < first {
+			t = first
+		}
+		for ; t <= endTime && len(opens) < limit; t = nextIntervalStart(interval, t) {
+			opens = append(opens, t)
+		}
+	} else {
+		for t := intervalStart(interval, endTime); t >= first && len(opens) < limit; t = intervalStart(interval, t-1) {
+			opens = append([]int64{t}, opens...)
+		}
+	}
+
+	klines := make([][]interface{}, 0, len(opens))
+	for _, open := range opens {
+		klines = append(klines, Kline(symbol, interval, open))
+	}
+	writeJSON(w, http.StatusOK, klines)
+}
+
+// queryInt 解析整数查询参数
+func queryInt(v string) (int64, bool) {
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+// Kline 模拟服务器为交易对和时间间隔返回的开盘时间为openTime的K线，格式与币安相同：
+// [开盘时间, 开盘价, 最高价, 最低价, 收盘价, 成交量, 收盘时间, 成交额, 成交笔数, 主动买入成交量, 主动买入成交额, 忽略]
+func Kline(symbol, interval string, openTime int64) []interface{} {
+	seed := int64(0)
+	for _, c := range symbol + interval {
+		seed = seed*31 + int64(c)
+	}
+	step := (openTime/60000 + seed) % 100
+	if step < 0 {
+		step += 100
+	}
+
+	open := 100 + float64(step)
+	closePrice := open + float64(step%7) - 3
+	high := open + 5
+	low := open - 5
+	volume := float64(step%13) + 1
+	closeTime := nextIntervalStart(interval, openTime) - 1
+	return []interface{}{
+		openTime,
+		formatPrice(open),
+		formatPrice(high),
+		formatPrice(low),
+		formatPrice(closePrice),
+		formatPrice(volume),
+		closeTime,
+		formatPrice(volume * open),
+		int(step) + 1,
+		formatPrice(volume / 2),
+		formatPrice(volume / 2 * open),
+		"0",
+	}
+}
+
+func formatPrice(v float64) string {
+	return fmt.Sprintf("%.8f", v)
+}
+
+// parseInterval 按“数字+单位”解析固定长度时间间隔
+func parseInterval(interval string) (int64, bool) {
+	if len(interval) < 2 {
+		return 0, false
+	}
+	unit, ok := unitMilliseconds[interval[len(interval)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return int64(n) * unit, true
+}
+
+// intervalStart UTC毫秒时间戳所在K线周期的开始时间
+func intervalStart(interval string, ms int64) int64 {
+	if interval == "1M" {
+		t := time.UnixMilli(ms).UTC()
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	size, _ := parseInterval(interval)
+	offset := int64(0)
+	if interval == "1w" {
+		offset = weekAlignOffset
+	}
+	mod := (ms - offset) % size
+	if mod < 0 {
+		mod += size
+	}
+	return ms - mod
+}
+
+// nextIntervalStart 下一个K线周期的开始时间
+func nextIntervalStart(interval string, ms int64) int64 {
+	start := intervalStart(interval, ms)
+	if interval == "1M" {
+		return time.UnixMilli(start).UTC().AddDate(0, 1, 0).UnixMilli()
+	}
+	size, _ := parseInterval(interval)
+	return start + size
+}
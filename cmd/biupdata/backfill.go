@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// runBackfill 执行 backfill 子命令：未指定 -start 时与定时任务相同做增量更新，
+// 指定时重新获取该日期范围内的K线并覆盖写入；-dry-run 只获取、校验并报告将要写入的记录
+//
+//	biupdata -env config.env backfill [-symbol BTCUSDT] [-interval 5m] [-start 2024-01-01] [-end 2024-02-01] [-dry-run]
+func runBackfill(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "只回补指定交易对，默认回补全部配置的交易对")
+	interval := fs.String("interval", "", "只回补指定时间间隔，默认回补交易对配置的全部时间间隔")
+	start := fs.String("start", "", "回补的开始日期（YYYY-MM-DD），默认从同步进度继续")
+	end := fs.String("end", "", "回补的结束日期（YYYY-MM-DD，不含当天），默认到当前时间")
+	dryRun := fs.Bool("dry-run", false, "只获取并校验数据，报告将要插入和更新的记录，不写入数据库")
+	fs.Parse(args)
+
+	if *interval != "" && !config.IsSupportedInterval(*interval) {
+		fmt.Printf("不支持的时间间隔: %s\n", *interval)
+		return 2
+	}
+
+	var startUTC, endUTC int64
+	if *start != "" {
+		t, err := utils.ParseShanghaiDate(*start)
+		if err != nil {
+			fmt.Printf("无效的开始日期 %q，格式应为 YYYY-MM-DD\n", *start)
+			return 2
+		}
+		startUTC = t.UnixMilli()
+	}
+	if *end != "" {
+		if *start == "" {
+			fmt.Println("指定 -end 时需要同时指定 -start")
+			return 2
+		}
+		t, err := utils.ParseShanghaiDate(*end)
+		if err != nil || t.UnixMilli() <= startUTC {
+			fmt.Printf("无效的结束日期 %q，格式应为 YYYY-MM-DD 且晚于开始日期\n", *end)
+			return 2
+		}
+		endUTC = t.UnixMilli() - 1
+	}
+
+	symbols := cfg.Binance.Symbols
+	if *symbol != "" {
+		symbols = []string{strings.ToUpper(*symbol)}
+	}
+
+	failed := 0
+	for _, s := range symbols {
+		intervals := cfg.Binance.IntervalsFor(s)
+		if *interval != "" {
+			intervals = []string{*interval}
+		}
+
+		for _, iv := range intervals {
+			if *dryRun {
+				report, err := api.PreviewInterval(s, iv, startUTC, endUTC)
+				if err != nil {
+					fmt.Printf("%s %s: 试运行失败: %v\n", s, iv, err)
+					failed++
+					if report == nil {
+						continue
+					}
+				}
+				printDryRunReport(report)
+				continue
+			}
+
+			var n int
+			var err error
+			if startUTC > 0 {
+				n, err = api.BackfillRange(s, iv, startUTC, endUTC)
+			} else {
+				var result map[string]int
+				result, err = api.UpdateSymbolData(s, []string{iv})
+				n = result[iv]
+			}
+			if err != nil {
+				fmt.Printf("%s %s: 回补失败（已写入 %d 条）: %v\n", s, iv, n, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s %s: 写入 %d 条记录\n", s, iv, n)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("回补完成，%d 个交易对和时间间隔失败\n", failed)
+		return 1
+	}
+	if *dryRun {
+		fmt.Println("试运行完成，未写入任何数据")
+	} else {
+		fmt.Println("回补完成")
+	}
+	return 0
+}
+
+// printDryRunReport 输出单个交易对和时间间隔的试运行结果
+func printDryRunReport(r *api.DryRunReport) {
+	fmt.Printf("%s %s: %s ~ %s 获取 %d 根K线，将插入 %d 根，更新 %d 根，不变 %d 根",
+		r.Symbol, r.Interval,
+		utils.TimestampToShanghai(r.StartTime).Format("2006-01-02 15:04"),
+		utils.TimestampToShanghai(r.EndTime).Format("2006-01-02 15:04"),
+		r.Fetched, r.Inserted, r.Updated, r.Unchanged)
+	if r.Skipped > 0 {
+		fmt.Printf("，跳过未收盘 %d 根", r.Skipped)
+	}
+	if r.Invalid > 0 {
+		fmt.Printf("，无效 %d 根", r.Invalid)
+	}
+	fmt.Printf("（耗时 %s）\n", r.Elapsed)
+
+	if r.CreateTable {
+		fmt.Println("  数据表不存在，将会创建")
+	}
+	for _, g := range r.Gaps {
+		fmt.Printf("  补齐缺口 %s ~ %s，共 %d 根\n", g.StartDatetime, g.EndDatetime, g.Missing)
+	}
+	for _, p := range r.Problems {
+		fmt.Printf("  无效K线: %s\n", p)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// runBackup 执行 backup 子命令，不依赖mysqldump备份或恢复K线表
+//
+//	biupdata -env config.env backup create -file klines.tar.gz [-symbol BTCUSDT,ETHUSDT] [-interval 1h] [-format sql|csv]
+//	biupdata -env config.env backup restore -file klines.tar.gz [-symbol BTCUSDT] [-interval 1h]
+func runBackup(args []string) int {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "restore") {
+		fmt.Println("用法: biupdata backup create|restore -file 备份文件")
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("backup "+action, flag.ExitOnError)
+	file := fs.String("file", "", "备份文件路径（tar.gz）")
+	symbols := fs.String("symbol", "", "只处理指定交易对，多个用逗号分隔，默认全部")
+	intervals := fs.String("interval", "", "只处理指定时间间隔，多个用逗号分隔，默认全部")
+	format := fs.String("format", db.BackupFormatSQL, "备份格式：sql 或 csv")
+	fs.Parse(args[1:])
+	if *file == "" {
+		fmt.Println("缺少 -file 参数")
+		return 2
+	}
+	filter := db.NewBackupFilter(*symbols, *intervals)
+	for _, interval := range filter.Intervals {
+		if !config.IsSupportedInterval(interval) {
+			fmt.Printf("不支持的时间间隔: %s\n", interval)
+			return 2
+		}
+	}
+
+	if action == "create" {
+		return createBackup(*file, filter, *format)
+	}
+	return restoreBackup(*file, filter)
+}
+
+// createBackup 备份到文件，先写临时文件，成功后再改名
+func createBackup(file string, filter db.BackupFilter, format string) int {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		fmt.Printf("创建备份文件失败: %v\n", err)
+		return 1
+	}
+	manifest, err := db.WriteBackup(f, filter, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Printf("备份失败: %v\n", err)
+		return 1
+	}
+
+	for _, s := range manifest.Series {
+		fmt.Printf("  %s %s: %d 条K线\n", s.Symbol, s.Interval, s.Rows)
+	}
+	fmt.Printf("已备份 %d 个K线表共 %d 条K线到 %s\n", len(manifest.Series), manifest.Rows, file)
+	return 0
+}
+
+// restoreBackup 从文件恢复，备份不完整时返回退出码1
+func restoreBackup(file string, filter db.BackupFilter) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Printf("打开备份文件失败: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	result, err := db.RestoreBackup(f, filter)
+	if err != nil {
+		fmt.Printf("恢复失败: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("备份由 %s 于 %s 生成（%s格式）\n", result.Manifest.Host, result.Manifest.CreatedAt, result.Manifest.Format)
+	for _, s := range result.Series {
+		fmt.Printf("  %s %s: 恢复 %d 条K线，备份中 %d 条\n", s.Symbol, s.Interval, s.Rows, s.Expected)
+	}
+	if !result.Complete {
+		fmt.Printf("备份不完整，已恢复 %d 条K线，请核对各表的行数\n", result.Rows)
+		return 1
+	}
+	fmt.Printf("恢复完成，共 %d 条K线\n", result.Rows)
+	return 0
+}
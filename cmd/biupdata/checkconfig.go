@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// runCheckConfig 执行 -check-config，只校验配置而不连接数据库和币安，配置无效时返回非零退出码
+//
+//	biupdata -env config.env -check-config
+func runCheckConfig(cfg *config.Config) int {
+	if err := utils.InitHTTPClient(&cfg.HTTP); err != nil {
+		fmt.Printf("HTTP客户端配置无效: %v\n", err)
+		return 1
+	}
+	if err := api.SetConfig(cfg); err != nil {
+		fmt.Printf("API配置无效: %v\n", err)
+		return 1
+	}
+
+	fmt.Println("配置有效")
+	fmt.Printf("交易对: %v\n", cfg.Binance.Symbols)
+	fmt.Printf("时间间隔: %v\n", cfg.Binance.Intervals)
+	for symbol, intervals := range cfg.Binance.SymbolIntervals {
+		fmt.Printf("  %s 的时间间隔: %v\n", symbol, intervals)
+	}
+	return 0
+}
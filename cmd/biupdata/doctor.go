@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 等待数据库时的重试间隔，每次失败后翻倍
+const (
+	doctorInitialBackoff = time.Second
+	doctorMaxBackoff     = 30 * time.Second
+)
+
+// doctorOptions doctor 子命令的参数
+type doctorOptions struct {
+	Timeout   time.Duration // 等待数据库的最长时间
+	CheckOnly bool          // 检查通过后退出，不启动服务
+}
+
+// parseDoctorArgs 解析 doctor 子命令的参数
+//
+//	biupdata -env config.env doctor [-timeout 2m] [-check-only]
+func parseDoctorArgs(args []string) doctorOptions {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 2*time.Minute, "等待数据库接受连接的最长时间")
+	checkOnly := fs.Bool("check-only", false, "检查通过后退出，不启动服务（用于init容器）")
+	fs.Parse(args)
+
+	return doctorOptions{Timeout: *timeout, CheckOnly: *checkOnly}
+}
+
+// waitForDatabase 按指数退避反复连接数据库，直到连接成功或超过timeout
+func waitForDatabase(cfg *config.DatabaseConfig, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := doctorInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.InitDB(cfg)
+		if err == nil {
+			return nil
+		}
+		db.CloseDB()
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("等待 %v 后数据库仍不可用: %v", timeout, err)
+		}
+		fmt.Printf("数据库尚未就绪（第%d次尝试）: %v，%v 后重试\n", attempt, err, backoff)
+		utils.LogWarning("数据库尚未就绪（第%d次尝试）: %v，%v 后重试", attempt, err, backoff)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > doctorMaxBackoff {
+			backoff = doctorMaxBackoff
+		}
+	}
+}
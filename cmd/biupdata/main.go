@@ -8,45 +8,73 @@ import (
 	"syscall"
 
 	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/collector"
 	"github.com/ganlian2020AI/biupdata/config"
 	"github.com/ganlian2020AI/biupdata/db"
 	"github.com/ganlian2020AI/biupdata/utils"
 )
 
 var (
-	envFile = flag.String("env", "", "环境变量文件路径")
+	envFile     = flag.String("env", "", "环境变量文件路径")
+	configFile  = flag.String("config", "", "YAML或TOML配置文件路径，默认查找 config.yaml、config.yml、config.toml")
+	showVersion = flag.Bool("version", false, "打印版本信息后退出")
+	checkConfig = flag.Bool("check-config", false, "校验配置后退出，配置无效时退出码为1")
 )
 
 func main() {
 	// 解析命令行参数
 	flag.Parse()
 
+	if *showVersion {
+		info := utils.GetBuildInfo()
+		fmt.Printf("biupdata %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.BuildDate, info.GoVersion)
+		return
+	}
+
 	// 加载配置
 	fmt.Println("正在加载配置...")
-	cfg, err := config.LoadConfig(*envFile)
+	cfg, err := config.LoadConfig(*envFile, *configFile)
 	if err != nil {
 		fmt.Printf("加载配置失败: %v\n", err)
 		os.Exit(1)
 	}
 	fmt.Println("配置加载成功")
 
-	// 初始化时区
-	fmt.Println("正在初始化时区...")
-	utils.InitTimezone(&cfg.Timezone)
-	fmt.Printf("时区已设置为: %s (UTC%+d)\n", cfg.Timezone.Name, cfg.Timezone.Offset)
+	if *checkConfig {
+		os.Exit(runCheckConfig(cfg))
+	}
 
-	// 初始化日志系统
-	fmt.Println("正在初始化日志系统...")
-	if err := utils.InitLogger(&cfg.Log); err != nil {
-		fmt.Printf("初始化日志系统失败: %v\n", err)
+	// 初始化时区、日志、链路追踪、HTTP客户端和表名规则，迁移数据等子命令也按同样的设置运行
+	fmt.Println("正在初始化运行环境...")
+	shutdown, err := collector.Setup(cfg)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	defer shutdown()
 	utils.LogInfo("日志系统初始化成功")
-	fmt.Println("日志系统初始化成功")
+	fmt.Printf("时区已设置为: %s (UTC%+d)\n", cfg.Timezone.Name, cfg.Timezone.Offset)
+
+	// 子命令：在两个存储之间复制数据后退出，不需要连接配置的数据库
+	if flag.Arg(0) == "migrate-data" {
+		os.Exit(runMigrateData(flag.Args()[1:]))
+	}
+
+	// 子命令：容器启动时先等待数据库就绪、建表并校验配置，通过后在同一进程中启动服务
+	doctor := flag.Arg(0) == "doctor"
+	var doctorOpts doctorOptions
+	if doctor {
+		doctorOpts = parseDoctorArgs(flag.Args()[1:])
+	}
 
 	// 初始化数据库
 	fmt.Println("正在初始化数据库...")
-	if err := db.InitDB(&cfg.Database); err != nil {
+	if doctor {
+		err = waitForDatabase(&cfg.Database, doctorOpts.Timeout)
+	} else {
+		err = db.InitDB(&cfg.Database)
+	}
+	if err != nil {
 		fmt.Printf("初始化数据库失败: %v\n", err)
 		utils.LogError("初始化数据库失败: %v", err)
 		os.Exit(1)
@@ -55,50 +83,68 @@ func main() {
 	utils.LogInfo("数据库初始化成功")
 	fmt.Println("数据库初始化成功")
 
-	// 初始化所有数据表
-	fmt.Println("正在初始化所有数据表...")
-	if err := db.InitAllTables(cfg.Binance.Symbols, cfg.Binance.Intervals); err != nil {
-		fmt.Printf("初始化数据表失败: %v\n", err)
-		utils.LogError("初始化数据表失败: %v", err)
-		os.Exit(1)
+	// 子命令：导出或导入部署状态后退出
+	if flag.Arg(0) == "snapshot" {
+		os.Exit(runSnapshot(flag.Args()[1:]))
 	}
-	fmt.Println("所有数据表初始化成功")
 
-	// 设置API配置
-	fmt.Println("正在设置API配置...")
-	api.SetConfig(cfg)
+	// 子命令：备份或恢复K线表后退出
+	if flag.Arg(0) == "backup" {
+		os.Exit(runBackup(flag.Args()[1:]))
+	}
+
+	// 初始化缓存队列、时序数据库输出和Redis，创建所有数据表并应用API配置
+	fmt.Println("正在初始化采集器...")
+	c, err := collector.OpenServer(cfg, collector.ServerOptions{})
+	if err != nil {
+		fmt.Println(err)
+		utils.LogError("初始化采集器失败: %v", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+	fmt.Println("采集器初始化成功")
+	if doctor && doctorOpts.CheckOnly {
+		fmt.Println("检查通过：数据库已就绪，数据表已初始化，配置有效")
+		os.Exit(0)
+	}
 
 	// 检查币安API连接状态
 	fmt.Println("正在检查币安API连接状态...")
 	isConnected := api.CheckBinanceConnection()
 	if isConnected {
-		utils.LogInfo("币安API连接正常，使用直接连接")
-		fmt.Println("币安API连接正常，使用直接连接")
+		fmt.Println("币安API连接正常")
 	} else {
-		utils.LogWarning("币安API连接异常，将使用代理: %s", cfg.Binance.ProxyURL)
-		fmt.Printf("币安API连接异常，将使用代理: %s\n", cfg.Binance.ProxyURL)
+		utils.LogWarning("币安API所有线路均不可用，将在后续探测中重试")
+		fmt.Println("币安API所有线路均不可用，将在后续探测中重试")
 	}
 
-	// 初始化定时任务
-	fmt.Println("正在初始化定时任务...")
-	api.InitScheduler()
-	if err := api.AddUpdateTask(cfg); err != nil {
-		fmt.Printf("添加定时任务失败: %v\n", err)
-		utils.LogError("添加定时任务失败: %v", err)
-		os.Exit(1)
+	// 子命令：抽样校验数据后退出
+	if flag.Arg(0) == "verify" {
+		os.Exit(runVerify(cfg, flag.Args()[1:]))
+	}
+
+	// 子命令：回补数据后退出，-dry-run 时只报告不写入
+	if flag.Arg(0) == "backfill" {
+		os.Exit(runBackfill(cfg, flag.Args()[1:]))
 	}
-	api.StartScheduler()
-	defer api.StopScheduler()
-	fmt.Println("定时任务初始化成功")
 
-	// 初始化HTTP服务器
-	fmt.Println("正在初始化HTTP服务器...")
-	api.InitServer(&cfg.API)
+	// 启动线路探测、元数据同步、定时任务和低延迟模式的WebSocket订阅
+	fmt.Println("正在启动定时任务...")
+	if err := c.Start(); err != nil {
+		fmt.Println(err)
+		utils.LogError("启动采集器失败: %v", err)
+		os.Exit(1)
+	}
+	if cfg.HA.Enabled || api.SchedulerEnabled() {
+		fmt.Println("定时任务初始化成功")
+	} else {
+		fmt.Println("定时任务已被手动停止，可通过 POST /api/v1/scheduler/start 启动")
+	}
 
 	// 启动HTTP服务器（非阻塞）
 	fmt.Println("正在启动HTTP服务器...")
 	go func() {
-		if err := api.StartServer(&cfg.API); err != nil {
+		if err := c.Serve(); err != nil {
 			fmt.Printf("启动HTTP服务器失败: %v\n", err)
 			utils.LogError("启动HTTP服务器失败: %v", err)
 			os.Exit(1)
@@ -108,10 +154,16 @@ func main() {
 	utils.LogInfo("BiUpData 服务已启动")
 	fmt.Println("BiUpData 服务已启动")
 	fmt.Printf("监听端口: %s\n", cfg.API.Port)
+	if cfg.API.TLSEnabled() {
+		fmt.Println("已启用HTTPS")
+	}
 	fmt.Printf("支持的交易对: %v\n", cfg.Binance.Symbols)
 	fmt.Printf("支持的时间间隔: %v\n", cfg.Binance.Intervals)
+	for symbol, intervals := range cfg.Binance.SymbolIntervals {
+		fmt.Printf("  %s 的时间间隔: %v\n", symbol, intervals)
+	}
 	if cfg.Binance.UseProxy {
-		fmt.Printf("使用代理URL: %s\n", cfg.Binance.ProxyURL)
+		fmt.Println("当前通过代理线路访问币安API")
 	}
 
 	// 等待中断信号
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// runSnapshot 执行 snapshot 子命令，导出或导入K线以外的部署状态（设置、同步进度、交易对元数据、市场事件、标注）
+//
+//	biupdata -env config.env snapshot export -file state.tar.gz
+//	biupdata -env config.env snapshot import -file state.tar.gz [-dry-run]
+func runSnapshot(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Println("用法: biupdata snapshot export|import -file 快照文件")
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("snapshot "+action, flag.ExitOnError)
+	file := fs.String("file", "", "快照文件路径（tar.gz）")
+	dryRun := fs.Bool("dry-run", false, "导入时只读取快照并报告内容，不写入数据库")
+	fs.Parse(args[1:])
+	if *file == "" {
+		fmt.Println("缺少 -file 参数")
+		return 2
+	}
+
+	if action == "export" {
+		return exportSnapshot(*file)
+	}
+	return importSnapshot(*file, *dryRun)
+}
+
+// exportSnapshot 导出快照到文件，先写临时文件，成功后再改名，避免留下不完整的快照
+func exportSnapshot(file string) int {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		fmt.Printf("创建快照文件失败: %v\n", err)
+		return 1
+	}
+	manifest, err := db.ExportSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Printf("导出快照失败: %v\n", err)
+		return 1
+	}
+
+	printSnapshotTables(manifest.Tables)
+	fmt.Printf("  K线标注: %d 条\n", manifest.Notes)
+	fmt.Printf("快照已导出到 %s\n", file)
+	return 0
+}
+
+// importSnapshot 从文件导入快照
+func importSnapshot(file string, dryRun bool) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Printf("打开快照文件失败: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	result, err := db.ImportSnapshot(f, dryRun)
+	if err != nil {
+		fmt.Printf("导入快照失败: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("快照由 %s 于 %s 生成\n", result.Manifest.Host, result.Manifest.CreatedAt)
+	printSnapshotTables(result.Tables)
+	if dryRun {
+		fmt.Printf("  K线标注: %d 条\n", result.Notes)
+		fmt.Println("dry-run 模式，未写入数据库")
+		return 0
+	}
+
+	fmt.Printf("  K线标注: 恢复 %d 条，K线不存在跳过 %d 条\n", result.Notes, len(result.SkippedNotes))
+	for _, note := range result.SkippedNotes {
+		fmt.Printf("    %s %s %s\n", note.Symbol, note.Interval, note.Time)
+	}
+	if len(result.SkippedNotes) > 0 {
+		fmt.Println("跳过的标注可在迁移K线数据后重新导入快照恢复")
+	}
+	fmt.Println("快照导入完成")
+	return 0
+}
+
+// printSnapshotTables 按表名输出每张表的行数
+func printSnapshotTables(tables map[string]int) {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d 行\n", name, tables[name])
+	}
+}
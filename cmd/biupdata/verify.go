@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// runVerify 执行 verify 子命令，发现不一致时返回非零退出码
+//
+//	biupdata -env config.env verify [-symbol BTCUSDT] [-interval 5m] [-samples 3] [-window 100]
+func runVerify(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "只校验指定交易对，默认校验全部配置的交易对")
+	interval := fs.String("interval", "", "只校验指定时间间隔，默认校验全部配置的时间间隔")
+	samples := fs.Int("samples", cfg.Verify.Samples, "每个交易对和时间间隔抽样的窗口数")
+	window := fs.Int("window", cfg.Verify.Window, "每个窗口的K线数量（最多1000）")
+	fs.Parse(args)
+
+	if *symbol != "" {
+		cfg.Binance.Symbols = []string{strings.ToUpper(*symbol)}
+	}
+	if *interval != "" {
+		if !config.IsSupportedInterval(*interval) {
+			fmt.Printf("不支持的时间间隔: %s\n", *interval)
+			return 2
+		}
+		cfg.Binance.Intervals = []string{*interval}
+		// 单独配置了时间间隔的交易对只在包含该时间间隔时校验
+		for symbol, intervals := range cfg.Binance.SymbolIntervals {
+			var kept []string
+			for _, iv := range intervals {
+				if iv == *interval {
+					kept = append(kept, iv)
+				}
+			}
+			cfg.Binance.SymbolIntervals[symbol] = kept
+		}
+	}
+	if *samples < 1 || *window < 1 || *window > 1000 {
+		fmt.Println("samples 不能小于1，window 必须在1到1000之间")
+		return 2
+	}
+	cfg.Verify.Samples = *samples
+	cfg.Verify.Window = *window
+
+	fmt.Printf("正在抽样校验数据，每个交易对和时间间隔 %d 个窗口，每个窗口 %d 根K线...\n", *samples, *window)
+	reports := api.RunVerification(cfg)
+
+	total := 0
+	for _, report := range reports {
+		fmt.Printf("%s %s: 抽样 %d 个窗口，比较 %d 根K线，不一致 %d 处\n",
+			report.Symbol, report.Interval, report.Samples, report.Compared, len(report.Divergences))
+		for _, d := range report.Divergences {
+			switch d.Kind {
+			case "missing":
+				fmt.Printf("  %s 数据库缺失\n", d.Time)
+			case "extra":
+				fmt.Printf("  %s 币安不存在该K线\n", d.Time)
+			default:
+				fmt.Printf("  %s %s 数据库: %s 币安: %s\n", d.Time, d.Field, d.Stored, d.Binance)
+			}
+		}
+		total += len(report.Divergences)
+	}
+
+	if total > 0 {
+		fmt.Printf("校验完成，共发现 %d 处不一致\n", total)
+		return 1
+	}
+	fmt.Println("校验完成，未发现不一致")
+	return 0
+}
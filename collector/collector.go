@@ -0,0 +1,173 @@
+// Package collector 把K线采集核心（交易所访问、存储、定时任务）封装为可嵌入的库，
+// 其他Go程序（如交易机器人）可以在自己的进程中运行采集，cmd/biupdata 只是它的一层命令行包装。
+//
+// Collector 是独立的采集器：交易所访问、数据库连接、更新流程、任务列表和定时任务都属于它自己，
+// 不使用 api、db 包的包级状态，同一进程中可以同时运行多个（如分别写入不同的数据库）。
+// 需要HTTP接口、管理页面、主节点选举等完整功能时使用 Server，它与biupdata服务相同，一个进程只能打开一个
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/robfig/cron/v3"
+)
+
+// Options 创建采集器的选项，零值时直接请求配置的币安地址并按数据库配置新建MySQL连接
+type Options struct {
+	// Exchange 访问交易所的实现，为空时直接请求 BINANCE_BASE_URL，不经过线路选择和故障切换
+	Exchange api.Exchange
+	// Store 写入K线和同步进度的存储，为空时按 SINK_PRIMARY 打开时序数据库，或按数据库配置新建一个MySQL连接（db.ConnStore），Close时关闭
+	Store db.UpdateStore
+	// Locker 多个进程采集同一个数据库时互斥更新同一交易对和时间间隔，为空时不加锁
+	Locker api.Locker
+	// Hooks 依次执行的K线处理扩展
+	Hooks []api.CandleHook
+}
+
+// KlineReader 可以读取已存储K线的存储，db.ConnStore 和TimescaleDB主存储实现了它
+type KlineReader interface {
+	KlineRange(symbol, interval string, startUTC, endUTC int64, limit int) ([]db.Candle, error)
+}
+
+// ErrNotReadable 存储不支持读取K线
+var ErrNotReadable = errors.New("存储不支持读取K线（需要实现 collector.KlineReader）")
+
+// Collector 独立的K线采集器，按配置的交易对、时间间隔和 CRON_UPDATE_SCHEDULE 定时增量更新
+type Collector struct {
+	cfg        *config.Config
+	updater    *api.Updater
+	closeStore func() error // 关闭New打开的存储
+
+	mu         sync.Mutex
+	scheduler  *cron.Cron
+	lastUpdate map[string]time.Time // 交易对 时间间隔 -> 上次定时更新成功的时间
+	updating   sync.Mutex           // 上一轮定时更新未结束时跳过本轮
+}
+
+// New 创建采集器，不启动定时任务；cfg 只被读取，同一份配置可以交给多个采集器
+func New(cfg *config.Config, opts Options) (*Collector, error) {
+	c := &Collector{cfg: cfg, lastUpdate: make(map[string]time.Time)}
+
+	store := opts.Store
+	if store == nil {
+		primary, err := db.OpenPrimaryStore(&cfg.Sinks)
+		if err != nil {
+			return nil, fmt.Errorf("初始化K线主存储失败: %v", err)
+		}
+		if primary != nil {
+			store, c.closeStore = primary, primary.Close
+		} else {
+			s, conn, err := db.OpenConnStore(&cfg.Database)
+			if err != nil {
+				return nil, fmt.Errorf("初始化数据库失败: %v", err)
+			}
+			store, c.closeStore = s, conn.Close
+		}
+	}
+	exchange := opts.Exchange
+	if exchange == nil {
+		exchange = api.NewHTTPExchange(cfg.Binance.BaseURL)
+	}
+
+	c.updater = &api.Updater{
+		Exchange: exchange,
+		Store:    store,
+		Locker:   opts.Locker,
+		Config:   &cfg.Binance,
+		Hooks:    opts.Hooks,
+		LockTTL:  time.Duration(cfg.Redis.LockTTL) * time.Second,
+	}
+	return c, nil
+}
+
+// Start 按 CRON_UPDATE_SCHEDULE 启动定时更新
+func (c *Collector) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scheduler != nil {
+		return errors.New("采集器已启动")
+	}
+
+	scheduler := cron.New(cron.WithSeconds())
+	if _, err := scheduler.AddFunc(c.cfg.Cron.UpdateSchedule, c.runScheduledUpdate); err != nil {
+		return fmt.Errorf("添加定时任务失败: %v", err)
+	}
+	scheduler.Start()
+	c.scheduler = scheduler
+	return nil
+}
+
+// runScheduledUpdate 依次更新各交易对到了更新频率（见 api.UpdateFrequency）的时间间隔，失败的下一轮重试
+func (c *Collector) runScheduledUpdate() {
+	if !c.updating.TryLock() {
+		return
+	}
+	defer c.updating.Unlock()
+	defer utils.Recover("collector_update", nil)
+
+	for _, symbol := range c.cfg.Binance.CurrentSymbols() {
+		var due []string
+		c.mu.Lock()
+		for _, interval := range c.cfg.Binance.IntervalsFor(symbol) {
+			if time.Since(c.lastUpdate[symbol+" "+interval]) >= api.UpdateFrequency(interval) {
+				due = append(due, interval)
+			}
+		}
+		c.mu.Unlock()
+		if len(due) == 0 {
+			continue
+		}
+
+		result, err := c.updater.UpdateSymbolData(symbol, due)
+		if err != nil {
+			utils.LogWarning("采集器更新 %s 失败，下次重试: %v", symbol, err)
+		}
+		now := time.Now()
+		c.mu.Lock()
+		for interval := range result {
+			c.lastUpdate[symbol+" "+interval] = now
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Update 立即更新一个交易对，intervals为空时更新该交易对配置的全部时间间隔，返回各时间间隔写入的记录数
+func (c *Collector) Update(symbol string, intervals ...string) (map[string]int, error) {
+	if len(intervals) == 0 {
+		intervals = c.cfg.Binance.IntervalsFor(symbol)
+	}
+	return c.updater.UpdateSymbolData(symbol, intervals)
+}
+
+// Klines 按时间升序读取已存储的K线，startUTC/endUTC为UTC毫秒时间戳，为0时不限制该端，最多limit条；
+// 返回的OpenTime与数据库中存储的时间口径一致，可用utils.StoredTimestampToUTC转换。存储不支持读取时返回 ErrNotReadable
+func (c *Collector) Klines(symbol, interval string, startUTC, endUTC int64, limit int) ([]db.Candle, error) {
+	reader, ok := c.updater.Store.(KlineReader)
+	if !ok {
+		return nil, ErrNotReadable
+	}
+	return reader.KlineRange(symbol, interval, startUTC, endUTC, limit)
+}
+
+// Close 停止定时任务，等待进行中的更新结束，并关闭New打开的存储
+func (c *Collector) Close() {
+	c.mu.Lock()
+	scheduler := c.scheduler
+	c.scheduler = nil
+	c.mu.Unlock()
+
+	if scheduler != nil {
+		<-scheduler.Stop().Done()
+	}
+	if c.closeStore != nil {
+		c.closeStore()
+		c.closeStore = nil
+	}
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/binancetest"
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+)
+
+// newTestCollector 创建写入内存存储的采集器，同步水位设在当前K线之前hours根
+func newTestCollector(t *testing.T, srv *binancetest.Server, interval string, hours int) (*Collector, *db.MemoryStore) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Binance.Symbols = []string{"BTCUSDT"}
+	cfg.Binance.Intervals = []string{interval}
+
+	store := db.NewMemoryStore()
+	current := time.Now().UTC().Truncate(time.Hour).UnixMilli()
+	store.AdvanceSyncWatermark("BTCUSDT", interval, current-int64(hours)*time.Hour.Milliseconds())
+
+	c, err := New(cfg, Options{Exchange: api.NewHTTPExchange(srv.URL), Store: store})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c, store
+}
+
+func TestCollectorsAreIndependent(t *testing.T) {
+	srv := binancetest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddSymbol("BTCUSDT", time.Now().Add(-48*time.Hour))
+
+	first, firstStore := newTestCollector(t, srv, "1h", 6)
+	second, secondStore := newTestCollector(t, srv, "1h", 3)
+
+	// 同一交易对和时间间隔在两个采集器中同时更新，各自执行，不会合并到另一个采集器的任务
+	var wg sync.WaitGroup
+	results := make([]map[string]int, 2)
+	errs := make([]error, 2)
+	for i, c := range []*Collector{first, second} {
+		wg.Add(1)
+		go func(i int, c *Collector) {
+			defer wg.Done()
+			results[i], errs[i] = c.Update("BTCUSDT")
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, want := range []int{6, 3} {
+		if errs[i] != nil {
+			t.Fatalf("collector %d: Update: %v", i, errs[i])
+		}
+		if results[i]["1h"] != want {
+			t.Fatalf("collector %d updated %d candles, want %d", i, results[i]["1h"], want)
+		}
+	}
+	if n := len(firstStore.Klines("BTCUSDT", "1h")); n != 6 {
+		t.Fatalf("first store has %d candles, want 6", n)
+	}
+	if n := len(secondStore.Klines("BTCUSDT", "1h")); n != 3 {
+		t.Fatalf("second store has %d candles, want 3", n)
+	}
+
+	if _, err := first.Klines("BTCUSDT", "1h", 0, 0, 10); err != ErrNotReadable {
+		t.Fatalf("Klines on MemoryStore = %v, want ErrNotReadable", err)
+	}
+}
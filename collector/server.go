@@ -0,0 +1,284 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ganlian2020AI/biupdata/api"
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/db"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ServerOptions 打开完整服务的选项，零值即与biupdata服务相同的行为
+type ServerOptions struct {
+	// Exchange 访问交易所的实现，为空时保持api.SetExchange的设置（默认访问币安）
+	Exchange api.Exchange
+	// Store K线更新流程使用的存储，为空时按 SINK_PRIMARY 打开时序数据库，主存储为MySQL时保持api.SetStore的设置（默认写入配置的MySQL）
+	Store db.UpdateStore
+	// Hooks 启用的K线处理扩展，排在配置HOOKS启用的扩展之后
+	Hooks []api.CandleHook
+}
+
+// ErrAlreadyOpen 进程中已有打开的服务，Close之后才能再次OpenServer
+var ErrAlreadyOpen = errors.New("同一进程只能同时打开一个biupdata服务，请先关闭已打开的服务")
+
+// Server 完整的biupdata服务：HTTP接口、管理页面、元数据同步、数据保留、主节点选举和WebSocket订阅等，
+// 使用 api、db 包的进程级连接和定时任务，一个进程同时只能打开一个；只需要采集K线时使用 Collector
+type Server struct {
+	cfg     *config.Config
+	mu      sync.Mutex
+	started bool
+	router  *gin.Engine
+	closers []func() // 按打开的相反顺序执行
+}
+
+var (
+	activeMutex sync.Mutex
+	active      *Server
+)
+
+// Setup 初始化进程级组件：时区、日志、链路追踪、访问币安的HTTP客户端和表名规则，返回的函数在退出前调用；
+// OpenServer会在需要时自动调用，命令行在连接数据库之前单独调用以便子命令使用同样的设置
+func Setup(cfg *config.Config) (func(), error) {
+	utils.InitTimezone(&cfg.Timezone)
+	if err := utils.InitLogger(&cfg.Log); err != nil {
+		return nil, fmt.Errorf("初始化日志系统失败: %v", err)
+	}
+	utils.InitTracing(&cfg.Tracing)
+	if err := utils.InitHTTPClient(&cfg.HTTP); err != nil {
+		utils.ShutdownTracing()
+		return nil, fmt.Errorf("初始化HTTP客户端失败: %v", err)
+	}
+	db.SetTableNaming(&cfg.Database)
+	return utils.ShutdownTracing, nil
+}
+
+// OpenServer 连接数据库、缓存队列、时序数据库输出和Redis，创建数据表并应用配置，不启动任何后台任务；
+// 调用方已通过db.InitDB连接数据库时直接使用该连接，否则先调用Setup再连接。已有打开的服务时返回 ErrAlreadyOpen
+func OpenServer(cfg *config.Config, opts ServerOptions) (*Server, error) {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	if active != nil {
+		return nil, ErrAlreadyOpen
+	}
+
+	c := &Server{cfg: cfg}
+	if err := c.open(opts); err != nil {
+		c.runClosers()
+		return nil, err
+	}
+	active = c
+	return c, nil
+}
+
+// open 按依赖顺序初始化各组件，每打开一个登记对应的关闭函数
+func (c *Server) open(opts ServerOptions) error {
+	cfg := c.cfg
+	if db.DB == nil {
+		shutdown, err := Setup(cfg)
+		if err != nil {
+			return err
+		}
+		c.closers = append(c.closers, shutdown)
+
+		if err := db.InitDB(&cfg.Database); err != nil {
+			return fmt.Errorf("初始化数据库失败: %v", err)
+		}
+		c.closers = append(c.closers, db.CloseDB)
+	}
+
+	if err := db.InitQueue(&cfg.Database); err != nil {
+		return fmt.Errorf("初始化缓存队列失败: %v", err)
+	}
+	c.closers = append(c.closers, db.CloseQueue)
+
+	if err := db.InitSinks(&cfg.Sinks); err != nil {
+		return fmt.Errorf("初始化时序数据库输出失败: %v", err)
+	}
+	c.closers = append(c.closers, db.CloseSinks)
+
+	if cfg.Redis.Addr != "" {
+		if err := db.InitRedis(&cfg.Redis); err != nil {
+			return fmt.Errorf("初始化Redis失败: %v", err)
+		}
+		c.closers = append(c.closers, db.CloseRedis)
+	}
+
+	// 跳过已下架停止采集的交易对
+	if err := api.ApplyRetiredSymbols(cfg); err != nil {
+		utils.LogWarning("加载已停止采集的交易对失败: %v", err)
+	}
+
+	if err := db.InitAllTables(cfg.Binance.CurrentSymbols(), cfg.Binance.IntervalsFor); err != nil {
+		return fmt.Errorf("初始化数据表失败: %v", err)
+	}
+	if err := api.InitPaperTrading(cfg); err != nil {
+		return fmt.Errorf("初始化模拟持仓表失败: %v", err)
+	}
+
+	if opts.Exchange != nil {
+		api.SetExchange(opts.Exchange)
+		c.closers = append(c.closers, func() { api.SetExchange(nil) })
+	}
+	store := opts.Store
+	if store == nil {
+		primary, err := db.OpenPrimaryStore(&cfg.Sinks)
+		if err != nil {
+			return fmt.Errorf("初始化K线主存储失败: %v", err)
+		}
+		if primary != nil {
+			utils.LogInfo("K线主存储: %s", primary.Name())
+			c.closers = append(c.closers, func() { primary.Close() })
+			store = primary
+		}
+	}
+	if store != nil {
+		api.SetStore(store)
+		c.closers = append(c.closers, func() { api.SetStore(nil) })
+	}
+	for _, h := range opts.Hooks {
+		api.AddHook(h)
+	}
+	if len(opts.Hooks) > 0 {
+		c.closers = append(c.closers, api.ResetHooks)
+	}
+	if err := api.SetConfig(cfg); err != nil {
+		return fmt.Errorf("API配置无效: %v", err)
+	}
+	return nil
+}
+
+// Start 启动线路探测、交易对元数据同步和自动发现、定时任务和低延迟模式的WebSocket订阅，不启动HTTP服务
+func (c *Server) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return errors.New("采集器已启动")
+	}
+	cfg := c.cfg
+
+	// 定期探测网络线路，自动选择最快的可用线路
+	api.StartNetworkProbe(&cfg.Binance)
+	c.closers = append(c.closers, api.StopNetworkProbe)
+
+	// 同步交易对元数据并校验配置的交易对
+	if invalid, err := api.SyncExchangeInfo(cfg); err != nil {
+		utils.LogWarning("同步交易对元数据失败: %v", err)
+	} else if len(invalid) > 0 {
+		utils.LogWarning("以下交易对在币安不存在或未处于交易状态: %v", invalid)
+	}
+
+	if cfg.Binance.AutoDiscover {
+		if err := api.RefreshDiscoveredSymbols(cfg); err != nil {
+			utils.LogWarning("自动发现交易对失败: %v，暂时只采集配置的交易对", err)
+		}
+	}
+
+	api.InitScheduler()
+	for _, add := range []func(*config.Config) error{
+		api.AddUpdateTask,
+		api.AddExchangeInfoTask,
+		api.AddDiscoveryTask,
+		api.AddVerifyTask,
+		api.AddRetentionTask,
+		api.AddPartitionTask,
+		api.AddAccountTask,
+		api.AddReportTask,
+		api.AddBookTickerTask,
+	} {
+		if err := add(cfg); err != nil {
+			return fmt.Errorf("添加定时任务失败: %v", err)
+		}
+	}
+
+	if cfg.HA.Enabled {
+		// 高可用模式下由选举结果决定是否启动定时任务
+		api.StartLeaderElection(&cfg.HA)
+		c.closers = append(c.closers, func() { api.StopLeaderElection(&cfg.HA) })
+	} else {
+		// 恢复上次手动设置的定时任务状态和暂停记录
+		if err := api.RestoreSchedulerState(); err != nil {
+			utils.LogWarning("恢复定时任务状态失败: %v，使用默认状态", err)
+		}
+		if api.SchedulerEnabled() {
+			api.StartSchedulerWithCatchup()
+		} else {
+			utils.LogInfo("定时任务已被手动停止，可通过 POST /api/v1/scheduler/start 启动")
+		}
+		c.closers = append(c.closers, api.StopScheduler)
+	}
+
+	// 高可用模式下只有主节点订阅，由选举结果启动和停止
+	if len(cfg.Binance.SLASymbols) > 0 {
+		if !cfg.HA.Enabled {
+			api.StartSLAStreams(cfg)
+		}
+		c.closers = append(c.closers, api.StopSLAStreams)
+	}
+
+	c.started = true
+	return nil
+}
+
+// Handler 返回HTTP接口的处理器，可挂载到调用方已有的HTTP服务上
+func (c *Server) Handler() http.Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.router == nil {
+		c.router = api.InitServer(&c.cfg.API)
+	}
+	return c.router
+}
+
+// Serve 按API配置监听端口提供HTTP接口（以及配置的调试端口），阻塞直到服务出错
+func (c *Server) Serve() error {
+	c.Handler()
+	api.StartDebugServer(&c.cfg.API)
+	return api.StartServer(&c.cfg.API)
+}
+
+// Update 立即更新一个交易对，intervals为空时更新该交易对配置的全部时间间隔，返回各时间间隔写入的记录数
+func (c *Server) Update(symbol string, intervals ...string) (map[string]int, error) {
+	if len(intervals) == 0 {
+		intervals = c.cfg.Binance.IntervalsFor(symbol)
+	}
+	return api.UpdateSymbolData(symbol, intervals)
+}
+
+// Klines 按时间倒序读取已存储的K线，startUTC/endUTC为UTC毫秒时间戳，为0时不限制该端；
+// 返回的OpenTime与数据库中存储的时间口径一致，可用utils.StoredTimestampToUTC转换
+func (c *Server) Klines(symbol, interval string, startUTC, endUTC int64, limit int) ([]db.Candle, error) {
+	return db.QueryKlineData(symbol, interval, startUTC, endUTC, limit)
+}
+
+// StreamKlines 按时间升序逐根读取已存储的K线，参数与db.StreamKlineRange相同
+func (c *Server) StreamKlines(ctx context.Context, symbol, interval string, startUTC, endUTC int64, limit int, fn func(db.Candle) error) error {
+	return db.StreamKlineRange(ctx, symbol, interval, startUTC, endUTC, limit, fn)
+}
+
+// Close 停止后台任务并关闭OpenServer打开的连接，之后可以重新OpenServer
+func (c *Server) Close() {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.runClosers()
+	c.started = false
+	if active == c {
+		active = nil
+	}
+}
+
+// runClosers 按相反顺序执行已登记的关闭函数
+func (c *Server) runClosers() {
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		c.closers[i]()
+	}
+	c.closers = nil
+}
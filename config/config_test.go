@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestNormalizeCronSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"*/5 * * * *", "0 */5 * * * *"},
+		{"  30 2 * * 1-5 ", "0 30 2 * * 1-5"},
+		{"0 40 0 * * *", "0 40 0 * * *"},
+		{"@every 5m", "@every 5m"},
+		{"@daily", "@daily"},
+		{"CRON_TZ=Asia/Shanghai 30 2 * * *", "CRON_TZ=Asia/Shanghai 0 30 2 * * *"},
+		{"TZ=UTC 0 30 2 * * *", "TZ=UTC 0 30 2 * * *"},
+		{"CRON_TZ=UTC @hourly", "CRON_TZ=UTC @hourly"},
+		{"TZ=UTC", "TZ=UTC"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCronSpec(tt.spec); got != tt.want {
+			t.Errorf("normalizeCronSpec(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
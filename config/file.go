@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 未指定配置文件时按顺序在当前目录查找
+var defaultConfigFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+// pairFormat 映射转为环境变量时的分隔符
+type pairFormat struct {
+	itemSep string // 各项之间
+	kvSep   string // 键与值之间
+}
+
+// 取值为键值列表的配置项，在配置文件中可以写成映射
+var mapValuedKeys = map[string]pairFormat{
+	"RETENTION_POLICY":             {",", "="},
+	"CATCHUP_INTERVAL_WEIGHTS":     {",", "="},
+	"OTEL_EXPORTER_OTLP_HEADERS":   {",", "="},
+	"BINANCE_SYMBOL_INTERVALS":     {";", ":"},
+	"BINANCE_INTERVAL_START_DATES": {",", "="},
+	"BINANCE_SYMBOL_START_DATES":   {",", "="},
+	"AUTH_JWT_ROLE_MAPPING":        {",", "="},
+	"DB_ROUTES":                    {",", "="},
+	"DB_ROUTE_SYMBOLS":             {";", ":"},
+}
+
+// knownKeys 加载配置时读取过的配置项，用于发现配置文件中拼错的键
+var knownKeys = make(map[string]bool)
+
+// findConfigFile 返回要加载的配置文件，未指定时查找默认文件名，都不存在时返回空字符串
+func findConfigFile(path string) string {
+	if path != "" {
+		return path
+	}
+	for _, name := range defaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyConfigFile 读取YAML或TOML配置文件，把其中的配置项写入尚未设置的环境变量，
+// 已经由环境变量或环境变量文件设置的配置项保持不变；返回文件中出现的全部配置项
+func applyConfigFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件 %s 失败: %v", path, err)
+	}
+
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("不支持的配置文件格式 %s，仅支持 .yaml、.yml、.toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+
+	values := make(map[string]string)
+	for key, value := range doc {
+		if err := flattenConfig(strings.ToUpper(key), value, values); err != nil {
+			return nil, fmt.Errorf("配置文件 %s: %v", path, err)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		keys = append(keys, key)
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// flattenConfig 把嵌套的配置展开为环境变量形式：各层的键用下划线连接并转为大写，
+// 列表用逗号连接，mapValuedKeys中的映射按各自的分隔符转为键值列表
+func flattenConfig(key string, value interface{}, out map[string]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if format, ok := mapValuedKeys[key]; ok {
+			return flattenPairs(key, v, format, out)
+		}
+		for k, item := range v {
+			if err := flattenConfig(key+"_"+strings.ToUpper(k), item, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := scalarString(item)
+			if !ok {
+				return fmt.Errorf("%s 的列表元素只能是字符串、数字或布尔值", key)
+			}
+			items[i] = s
+		}
+		out[key] = strings.Join(items, ",")
+		return nil
+	case nil:
+		return nil
+	default:
+		s, ok := scalarString(v)
+		if !ok {
+			return fmt.Errorf("%s 的取值类型 %T 不受支持", key, v)
+		}
+		out[key] = s
+		return nil
+	}
+}
+
+// flattenPairs 把映射转为按键排序的“键=值”列表，值可以是列表（用逗号连接）
+func flattenPairs(key string, m map[string]interface{}, format pairFormat, out map[string]string) error {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, k := range names {
+		value := m[k]
+		var items []interface{}
+		if list, ok := value.([]interface{}); ok {
+			items = list
+		} else {
+			items = []interface{}{value}
+		}
+
+		values := make([]string, len(items))
+		for j, item := range items {
+			s, ok := scalarString(item)
+			if !ok {
+				return fmt.Errorf("%s.%s 只能是字符串、数字、布尔值或它们的列表", key, k)
+			}
+			values[j] = s
+		}
+		pairs[i] = k + format.kvSep + strings.Join(values, ",")
+	}
+	out[key] = strings.Join(pairs, format.itemSep)
+	return nil
+}
+
+// scalarString 把标量转换为环境变量中的写法
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case time.Time:
+		// YAML中不加引号的日期被解析为时间
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format(dateLayout), true
+		}
+		return v.Format(time.RFC3339), true
+	case toml.LocalDate:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// checkFileKeys 检查配置文件中的配置项是否都被读取过，拼错的键不会静默失效
+func checkFileKeys(path string, keys []string) error {
+	var unknown []string
+	for _, key := range keys {
+		if !knownKeys[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("配置文件 %s 中有未知的配置项: %s", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// 访问外部密钥存储的超时时间
+const secretsTimeout = 10 * time.Second
+
+// 外部密钥存储引用的前缀，如 vault:secret/data/biupdata#db_password、awssm:prod/biupdata#db_password
+const (
+	vaultRefPrefix = "vault:"
+	awsRefPrefix   = "awssm:"
+)
+
+// secretKeys 可以从文件或外部密钥存储读取的配置项
+var secretKeys = []string{
+	"DB_USER", "DB_PASSWORD", "DB_READ_DSN", "DB_ROUTES",
+	"REDIS_PASSWORD",
+	"BINANCE_API_KEY", "BINANCE_API_SECRET", "BINANCE_PROXY_URL",
+	"SMTP_USERNAME", "SMTP_PASSWORD", "NOTIFY_WEBHOOK_URL", "TELEGRAM_BOT_TOKEN",
+	"INFLUX_TOKEN", "TIMESCALE_DSN", "SECONDARY_DSN",
+	"OTEL_EXPORTER_OTLP_HEADERS",
+}
+
+var secretsClient = &http.Client{Timeout: secretsTimeout}
+
+// resolveSecrets 在读取配置前解析敏感配置项：
+// 1. 设置了 KEY_FILE 时，读取该文件的内容（去掉首尾空白）作为 KEY 的值，优先于 KEY 本身
+// 2. KEY 的值为 vault:路径#字段 或 awssm:密钥ID#字段 时，从Vault或AWS Secrets Manager读取
+func resolveSecrets() error {
+	cache := make(map[string]map[string]interface{})
+	for _, key := range secretKeys {
+		knownKeys[key+"_FILE"] = true
+
+		if path := os.Getenv(key + "_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("读取 %s_FILE 指定的文件失败: %v", key, err)
+			}
+			os.Setenv(key, strings.TrimSpace(string(data)))
+		}
+
+		value := os.Getenv(key)
+		var ref string
+		var fetch func(string) (map[string]interface{}, error)
+		switch {
+		case strings.HasPrefix(value, vaultRefPrefix):
+			ref, fetch = strings.TrimPrefix(value, vaultRefPrefix), fetchVaultSecret
+		case strings.HasPrefix(value, awsRefPrefix):
+			ref, fetch = strings.TrimPrefix(value, awsRefPrefix), fetchAWSSecret
+		default:
+			continue
+		}
+
+		path, field, ok := strings.Cut(ref, "#")
+		if !ok || path == "" || field == "" {
+			return fmt.Errorf("%s 的密钥引用 %q 格式应为 路径#字段", key, value)
+		}
+		cacheKey := value[:strings.Index(value, ":")+1] + path
+		secret, cached := cache[cacheKey]
+		if !cached {
+			var err error
+			if secret, err = fetch(path); err != nil {
+				return fmt.Errorf("读取 %s 的密钥失败: %v", key, err)
+			}
+			cache[cacheKey] = secret
+		}
+
+		v, ok := secret[field].(string)
+		if !ok {
+			return fmt.Errorf("读取 %s 的密钥失败: %s 中没有字符串字段 %s", key, path, field)
+		}
+		os.Setenv(key, v)
+	}
+	return nil
+}
+
+// fetchVaultSecret 读取Vault中的密钥，兼容KV v1和v2（v2的路径需要包含data/，如 secret/data/biupdata）
+func fetchVaultSecret(path string) (map[string]interface{}, error) {
+	addr := getEnv("VAULT_ADDR", "")
+	token := getEnv("VAULT_TOKEN", "")
+	if tokenFile := getEnv("VAULT_TOKEN_FILE", ""); token == "" && tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 VAULT_TOKEN_FILE 失败: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("使用 vault: 引用需要配置 VAULT_ADDR 和 VAULT_TOKEN")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := getEnv("VAULT_NAMESPACE", ""); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	body, err := doSecretsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析Vault响应失败: %v", err)
+	}
+	// KV v2 的字段位于 data.data 中
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := resp.Data["metadata"]; versioned {
+			return inner, nil
+		}
+	}
+	return resp.Data, nil
+}
+
+// fetchAWSSecret 调用AWS Secrets Manager的GetSecretValue，SecretString需要是JSON对象
+func fetchAWSSecret(secretID string) (map[string]interface{}, error) {
+	region := getEnv("AWS_REGION", "")
+	accessKey := getEnv("AWS_ACCESS_KEY_ID", "")
+	secretKey := getEnv("AWS_SECRET_ACCESS_KEY", "")
+	if region == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("使用 awssm: 引用需要配置 AWS_REGION、AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY")
+	}
+
+	payload, _ := json.Marshal(map[string]string{"SecretId": secretID})
+	host := "secretsmanager." + region + ".amazonaws.com"
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if token := getEnv("AWS_SESSION_TOKEN", ""); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSRequest(req, host, region, "secretsmanager", accessKey, secretKey, payload, time.Now().UTC())
+
+	body, err := doSecretsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析Secrets Manager响应失败: %v", err)
+	}
+	var secret map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.SecretString), &secret); err != nil {
+		return nil, fmt.Errorf("密钥 %s 的SecretString不是JSON对象", secretID)
+	}
+	return secret, nil
+}
+
+// signAWSRequest 按AWS Signature Version 4为请求签名，签名的请求头为content-type、host、x-amz-*
+func signAWSRequest(req *http.Request, host, region, service, accessKey, secretKey string, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         host,
+		"x-amz-date":   amzDate,
+		"x-amz-target": req.Header.Get("X-Amz-Target"),
+	}
+	names := []string{"content-type", "host", "x-amz-date"}
+	if token := req.Header.Get("X-Amz-Security-Token"); token != "" {
+		headers["x-amz-security-token"] = token
+		names = append(names, "x-amz-security-token")
+	}
+	names = append(names, "x-amz-target")
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", "", canonicalHeaders.String(), signedHeaders, sha256Hex(payload),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// doSecretsRequest 发送请求并返回响应内容，非2xx状态码视为失败
+func doSecretsRequest(req *http.Request) ([]byte, error) {
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// sha256Hex 返回数据SHA-256摘要的十六进制形式
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
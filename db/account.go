@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// AccountBalance 账户中单个资产的余额
+type AccountBalance struct {
+	Asset  string `json:"asset"`
+	Free   string `json:"free"`
+	Locked string `json:"locked"`
+}
+
+// AccountOrder 未成交的挂单
+type AccountOrder struct {
+	Symbol      string
+	OrderID     int64
+	Side        string
+	Type        string
+	Price       string
+	OrigQty     string
+	ExecutedQty string
+	Status      string
+	Time        int64 // 下单时间（UTC毫秒）
+}
+
+// AccountTrade 账户的一笔成交
+type AccountTrade struct {
+	Symbol          string
+	ID              int64
+	OrderID         int64
+	Price           string
+	Qty             string
+	QuoteQty        string
+	Commission      string
+	CommissionAsset string
+	Time            int64 // 成交时间（UTC毫秒）
+	IsBuyer         bool
+	IsMaker         bool
+}
+
+// CreateAccountTablesIfNotExists 创建账户余额、挂单和成交记录表
+func CreateAccountTablesIfNotExists() error {
+	queries := map[string]string{
+		"account_balances": fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			asset VARCHAR(16) NOT NULL,
+			free DECIMAL(36,18) NOT NULL,
+			locked DECIMAL(36,18) NOT NULL,
+			updated_at DATETIME NOT NULL COMMENT '上海时间',
+			PRIMARY KEY (asset)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+		`, prefixTable("account_balances")),
+		"account_open_orders": fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			symbol VARCHAR(32) NOT NULL,
+			order_id BIGINT NOT NULL,
+			side VARCHAR(8) NOT NULL,
+			type VARCHAR(32) NOT NULL,
+			price DECIMAL(30,8) NOT NULL,
+			orig_qty DECIMAL(30,8) NOT NULL,
+			executed_qty DECIMAL(30,8) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			order_time DATETIME NOT NULL COMMENT '上海时间',
+			updated_at DATETIME NOT NULL COMMENT '上海时间',
+			PRIMARY KEY (symbol, order_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+		`, prefixTable("account_open_orders")),
+		"account_trades": fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			symbol VARCHAR(32) NOT NULL,
+			trade_id BIGINT NOT NULL,
+			order_id BIGINT NOT NULL,
+			price DECIMAL(30,8) NOT NULL,
+			qty DECIMAL(30,8) NOT NULL,
+			quote_qty DECIMAL(30,8) NOT NULL,
+			commission DECIMAL(30,12) NOT NULL,
+			commission_asset VARCHAR(16) NOT NULL,
+			trade_time DATETIME(3) NOT NULL COMMENT '上海时间',
+			is_buyer TINYINT(1) NOT NULL,
+			is_maker TINYINT(1) NOT NULL,
+			PRIMARY KEY (symbol, trade_id),
+			KEY idx_time (symbol, trade_time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+		`, prefixTable("account_trades")),
+	}
+
+	for _, name := range []string{"account_balances", "account_open_orders", "account_trades"} {
+		if _, err := execSchema(DB, queries[name]); err != nil {
+			utils.LogError("创建表 %s 失败: %v", name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveAccountBalances 用最新余额替换全部记录
+func SaveAccountBalances(balances []AccountBalance) error {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	return replaceAll(prefixTable("account_balances"), func(tx *sql.Tx) error {
+		for _, b := range balances {
+			if _, err := execQuery(tx, fmt.Sprintf(`
+			INSERT INTO %s (asset, free, locked, updated_at) VALUES (?, ?, ?, ?)
+			`, prefixTable("account_balances")), b.Asset, b.Free, b.Locked, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveOpenOrders 用最新挂单替换全部记录，已成交或撤销的挂单随之删除
+func SaveOpenOrders(orders []AccountOrder) error {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	return replaceAll(prefixTable("account_open_orders"), func(tx *sql.Tx) error {
+		for _, o := range orders {
+			if _, err := execQuery(tx, fmt.Sprintf(`
+			INSERT INTO %s (symbol, order_id, side, type, price, orig_qty, executed_qty, status, order_time, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, prefixTable("account_open_orders")), o.Symbol, o.OrderID, o.Side, o.Type, o.Price, o.OrigQty, o.ExecutedQty, o.Status,
+				utils.TimestampToShanghai(o.Time).Format("2006-01-02 15:04:05"), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// replaceAll 在一个事务中清空表并写入新数据
+func replaceAll(table string, insert func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := execQuery(tx, "DELETE FROM "+table); err != nil {
+		tx.Rollback()
+		utils.LogError("清空表 %s 失败: %v", table, err)
+		return err
+	}
+	if err := insert(tx); err != nil {
+		tx.Rollback()
+		utils.LogError("写入表 %s 失败: %v", table, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveAccountTrades 保存成交记录，同一成交重复写入时忽略
+func SaveAccountTrades(trades []AccountTrade) error {
+	for _, t := range trades {
+		_, err := execQuery(DB, fmt.Sprintf(`
+		INSERT IGNORE INTO %s (symbol, trade_id, order_id, price, qty, quote_qty, commission, commission_asset, trade_time, is_buyer, is_maker)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, prefixTable("account_trades")), t.Symbol, t.ID, t.OrderID, t.Price, t.Qty, t.QuoteQty, t.Commission, t.CommissionAsset,
+			utils.TimestampToShanghai(t.Time).Format("2006-01-02 15:04:05.000"), t.IsBuyer, t.IsMaker)
+		if err != nil {
+			utils.LogError("保存 %s 成交 %d 失败: %v", t.Symbol, t.ID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLastAccountTradeID 获取交易对已保存的最大成交ID，没有记录时返回-1
+func GetLastAccountTradeID(symbol string) (int64, error) {
+	var id sql.NullInt64
+	err := queryRow(DB, fmt.Sprintf("SELECT MAX(trade_id) FROM %s WHERE symbol = ?", prefixTable("account_trades")), symbol).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if !id.Valid {
+		return -1, nil
+	}
+	return id.Int64, nil
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrAlertRuleNotFound 告警规则不存在
+var ErrAlertRuleNotFound = errors.New("告警规则不存在")
+
+// AlertRule 每次更新后对最新一根已收盘K线求值的告警规则，Symbol为*表示对所有交易对生效
+type AlertRule struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Symbol     string  `json:"symbol"`
+	Interval   string  `json:"interval"`
+	Type       string  `json:"type"`                 // cross、volume_spike、change
+	Direction  string  `json:"direction"`            // above、below、any（change规则为up、down、any）
+	Indicator  string  `json:"indicator,omitempty"`  // cross规则比较的均线：sma、ema
+	Period     int     `json:"period,omitempty"`     // 均线或平均成交量的周期
+	Multiplier float64 `json:"multiplier,omitempty"` // volume_spike规则：成交量超过平均成交量的倍数
+	Window     string  `json:"window,omitempty"`     // change规则：比较的时间窗口，如 15m
+	Threshold  float64 `json:"threshold,omitempty"`  // change规则：涨跌幅百分比
+	Cooldown   int     `json:"cooldown"`             // 对同一交易对触发后的冷却时间（秒），期间不再通知
+	Enabled    bool    `json:"enabled"`
+	LastFired  string  `json:"last_fired,omitempty"` // 任一交易对最近一次触发的时间（上海时间）
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// AlertRecord 一次告警触发记录
+type AlertRecord struct {
+	ID        int64  `json:"id"`
+	RuleID    int64  `json:"rule_id"`
+	RuleName  string `json:"rule_name"`
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	Timestamp int64  `json:"timestamp"` // 触发告警的K线开盘时间（UTC毫秒）
+	Message   string `json:"message"`
+	FiredAt   string `json:"fired_at"` // 上海时间
+}
+
+// CreateAlertTablesIfNotExists 创建告警规则表和触发记录表
+func CreateAlertTablesIfNotExists() error {
+	rules := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		name VARCHAR(255) NOT NULL,
+		symbol VARCHAR(32) NOT NULL COMMENT '*表示所有交易对',
+		kline_interval VARCHAR(8) NOT NULL,
+		rule_type VARCHAR(16) NOT NULL,
+		direction VARCHAR(8) NOT NULL,
+		indicator VARCHAR(8) NOT NULL DEFAULT '',
+		period INT NOT NULL DEFAULT 0,
+		multiplier DOUBLE NOT NULL DEFAULT 0,
+		time_window VARCHAR(8) NOT NULL DEFAULT '',
+		threshold DOUBLE NOT NULL DEFAULT 0,
+		cooldown INT NOT NULL DEFAULT 0 COMMENT '秒',
+		enabled TINYINT(1) NOT NULL DEFAULT 1,
+		last_fired_at DATETIME NULL COMMENT '上海时间',
+		created_at DATETIME NOT NULL COMMENT '上海时间',
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		KEY idx_series (symbol, kline_interval)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("alert_rules"))
+	if _, err := execSchema(DB, rules); err != nil {
+		utils.LogError("创建表 alert_rules 失败: %v", err)
+		return err
+	}
+
+	history := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		rule_id BIGINT NOT NULL,
+		rule_name VARCHAR(255) NOT NULL,
+		symbol VARCHAR(32) NOT NULL,
+		kline_interval VARCHAR(8) NOT NULL,
+		kline_time DATETIME NOT NULL COMMENT '上海时间',
+		message TEXT,
+		fired_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		KEY idx_rule (rule_id, symbol, kline_interval, id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("alert_history"))
+	if _, err := execSchema(DB, history); err != nil {
+		utils.LogError("创建表 alert_history 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CreateAlertRule 新增告警规则，返回规则ID
+func CreateAlertRule(r *AlertRule) (int64, error) {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	result, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (name, symbol, kline_interval, rule_type, direction, indicator, period, multiplier, time_window, threshold, cooldown, enabled, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, prefixTable("alert_rules")), r.Name, r.Symbol, r.Interval, r.Type, r.Direction, r.Indicator, r.Period,
+		r.Multiplier, r.Window, r.Threshold, r.Cooldown, r.Enabled, now, now)
+	if err != nil {
+		utils.LogError("新增告警规则失败: %v", err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateAlertRule 修改告警规则，不改变上次触发时间
+func UpdateAlertRule(r *AlertRule) error {
+	if _, err := GetAlertRule(r.ID); err != nil {
+		return err
+	}
+
+	_, err := execQuery(DB, fmt.Sprintf(`
+	UPDATE %s
+	SET name = ?, symbol = ?, kline_interval = ?, rule_type = ?, direction = ?, indicator = ?, period = ?,
+		multiplier = ?, time_window = ?, threshold = ?, cooldown = ?, enabled = ?, updated_at = ?
+	WHERE id = ?
+	`, prefixTable("alert_rules")), r.Name, r.Symbol, r.Interval, r.Type, r.Direction, r.Indicator, r.Period,
+		r.Multiplier, r.Window, r.Threshold, r.Cooldown, r.Enabled,
+		utils.GetShanghaiNow().Format("2006-01-02 15:04:05"), r.ID)
+	if err != nil {
+		utils.LogError("修改告警规则 %d 失败: %v", r.ID, err)
+	}
+	return err
+}
+
+// DeleteAlertRule 删除告警规则，保留触发记录
+func DeleteAlertRule(id int64) error {
+	result, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE id = ?", prefixTable("alert_rules")), id)
+	if err != nil {
+		utils.LogError("删除告警规则 %d 失败: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+// GetAlertRule 按ID查询告警规则
+func GetAlertRule(id int64) (*AlertRule, error) {
+	rules, err := queryAlertRules("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrAlertRuleNotFound
+	}
+	return &rules[0], nil
+}
+
+// ListAlertRules 按ID升序查询全部告警规则
+func ListAlertRules() ([]AlertRule, error) {
+	return queryAlertRules("")
+}
+
+// queryAlertRules 按条件查询告警规则
+func queryAlertRules(where string, args ...interface{}) ([]AlertRule, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT id, name, symbol, kline_interval, rule_type, direction, indicator, period, multiplier, time_window,
+		threshold, cooldown, enabled, last_fired_at, created_at, updated_at
+	FROM %s %s ORDER BY id
+	`, prefixTable("alert_rules"), where), args...)
+	if err != nil {
+		utils.LogError("查询告警规则失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AlertRule
+	for rows.Next() {
+		var r AlertRule
+		var lastFired sql.NullTime
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&r.ID, &r.Name, &r.Symbol, &r.Interval, &r.Type, &r.Direction, &r.Indicator, &r.Period,
+			&r.Multiplier, &r.Window, &r.Threshold, &r.Cooldown, &r.Enabled, &lastFired, &createdAt, &updatedAt); err != nil {
+			utils.LogError("扫描告警规则失败: %v", err)
+			return nil, err
+		}
+		if lastFired.Valid {
+			r.LastFired = lastFired.Time.Format("2006-01-02 15:04:05")
+		}
+		r.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		r.UpdatedAt = updatedAt.Format("2006-01-02 15:04:05")
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// RecordAlert 记录一次告警并更新规则的上次触发时间
+func RecordAlert(rec *AlertRecord) error {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	if _, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (rule_id, rule_name, symbol, kline_interval, kline_time, message, fired_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, prefixTable("alert_history")), rec.RuleID, rec.RuleName, rec.Symbol, rec.Interval,
+		utils.TimestampToShanghai(rec.Timestamp).Format("2006-01-02 15:04:05"), rec.Message, now); err != nil {
+		utils.LogError("记录告警失败: %v", err)
+		return err
+	}
+	if _, err := execQuery(DB, fmt.Sprintf("UPDATE %s SET last_fired_at = ? WHERE id = ?", prefixTable("alert_rules")), now, rec.RuleID); err != nil {
+		utils.LogError("更新告警规则 %d 的触发时间失败: %v", rec.RuleID, err)
+		return err
+	}
+	return nil
+}
+
+// ListAlertHistory 按触发时间倒序查询告警记录，ruleID为0时查询全部规则
+func ListAlertHistory(ruleID int64, limit int) ([]AlertRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT id, rule_id, rule_name, symbol, kline_interval, kline_time, IFNULL(message, ''), fired_at
+	FROM %s
+	`, prefixTable("alert_history"))
+	var args []interface{}
+	if ruleID > 0 {
+		query += " WHERE rule_id = ?"
+		args = append(args, ruleID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := queryRows(DB, query, args...)
+	if err != nil {
+		utils.LogError("查询告警记录失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AlertRecord
+	for rows.Next() {
+		var rec AlertRecord
+		var klineTime, firedAt time.Time
+		if err := rows.Scan(&rec.ID, &rec.RuleID, &rec.RuleName, &rec.Symbol, &rec.Interval, &klineTime, &rec.Message, &firedAt); err != nil {
+			utils.LogError("扫描告警记录失败: %v", err)
+			return nil, err
+		}
+		rec.Timestamp = utils.StoredTimestampToUTC(klineTime.UnixMilli())
+		rec.FiredAt = firedAt.Format("2006-01-02 15:04:05")
+		result = append(result, rec)
+	}
+	return result, rows.Err()
+}
+
+// LastAlertTime 规则对某个交易对和时间间隔上次触发的时间（UTC毫秒），从未触发时返回0
+func LastAlertTime(ruleID int64, symbol, interval string) (int64, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT fired_at FROM %s
+	WHERE rule_id = ? AND symbol = ? AND kline_interval = ?
+	ORDER BY id DESC LIMIT 1
+	`, prefixTable("alert_history")), ruleID, symbol, interval)
+	if err != nil {
+		utils.LogError("查询告警规则 %d 的触发时间失败: %v", ruleID, err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	var firedAt time.Time
+	if err := rows.Scan(&firedAt); err != nil {
+		return 0, err
+	}
+	return utils.StoredTimestampToUTC(firedAt.UnixMilli()), nil
+}
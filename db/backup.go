@@ -0,0 +1,724 @@
+package db
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrNoBackupTables 没有符合条件的K线表
+var ErrNoBackupTables = errors.New("没有符合条件的K线表")
+
+// 备份格式版本，格式不兼容时递增
+const backupVersion = 1
+
+// 备份格式
+const (
+	BackupFormatSQL = "sql" // INSERT语句，可直接用mysql客户端执行
+	BackupFormatCSV = "csv"
+)
+
+const (
+	backupPartRows  = 50000 // 每个数据文件的K线条数，备份时在内存中缓冲一个文件
+	backupChunkRows = 5000  // 每次从数据库读取的K线条数
+	backupStmtRows  = 500   // SQL格式每条INSERT语句的K线条数
+)
+
+// 备份中K线表的列，CSV文件的表头与此相同
+var backupColumns = []string{"timestamp", "open_price", "close_price", "high_price", "low_price", "volume", "note"}
+
+// CSV中表示NULL的值，与 LOAD DATA 相同
+const csvNull = `\N`
+
+// 恢复时接受的交易对，与币安交易对的字符范围相同，另允许其他交易所常用的分隔符
+var backupSymbolPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// BackupFilter 按交易对和时间间隔选择K线表，为空表示不过滤
+type BackupFilter struct {
+	Symbols   []string `json:"symbols,omitempty"`
+	Intervals []string `json:"intervals,omitempty"`
+}
+
+// NewBackupFilter 解析逗号分隔的交易对和时间间隔列表
+func NewBackupFilter(symbols, intervals string) BackupFilter {
+	var f BackupFilter
+	for _, s := range strings.Split(symbols, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.Symbols = append(f.Symbols, strings.ToUpper(s))
+		}
+	}
+	for _, s := range strings.Split(intervals, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.Intervals = append(f.Intervals, s)
+		}
+	}
+	return f
+}
+
+// Match 序列是否被选中；表名不区分大小写，时间间隔按不区分大小写比较
+func (f BackupFilter) Match(symbol, interval string) bool {
+	if len(f.Symbols) > 0 && !containsFold(f.Symbols, symbol) {
+		return false
+	}
+	if len(f.Intervals) > 0 && !containsFold(f.Intervals, interval) {
+		return false
+	}
+	return true
+}
+
+// containsFold 列表中是否有不区分大小写相等的值
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupSeries 备份中的一个K线序列
+type BackupSeries struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	Table    string `json:"table"`
+	Rows     int64  `json:"rows"`
+	Parts    int    `json:"parts"`
+}
+
+// BackupManifest 备份说明，开头的 manifest.json 列出要备份的序列，
+// 结尾的 summary.json 与之相同并带有每个序列的行数，缺少 summary.json 说明备份不完整
+type BackupManifest struct {
+	Version    int            `json:"version"`
+	Format     string         `json:"format"`
+	CreatedAt  string         `json:"created_at"` // 上海时间
+	FinishedAt string         `json:"finished_at,omitempty"`
+	Host       string         `json:"host"`
+	Series     []BackupSeries `json:"series"`
+	Rows       int64          `json:"rows"`
+}
+
+// WriteBackup 在一致性快照中读取选中的K线表，以tar.gz归档写入w：
+// manifest.json、每个序列的 表名/part-00001.sql（或.csv）等数据文件、summary.json
+func WriteBackup(w io.Writer, filter BackupFilter, format string) (*BackupManifest, error) {
+	if format != BackupFormatSQL && format != BackupFormatCSV {
+		return nil, fmt.Errorf("不支持的备份格式: %s（支持 sql、csv）", format)
+	}
+
+	all, err := ListKlineSeries()
+	if err != nil {
+		return nil, fmt.Errorf("列出K线表失败: %v", err)
+	}
+	host, _ := os.Hostname()
+	manifest := &BackupManifest{
+		Version:   backupVersion,
+		Format:    format,
+		CreatedAt: utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		Host:      host,
+		Series:    []BackupSeries{},
+	}
+	for _, s := range all {
+		if filter.Match(s.Symbol, s.Interval) {
+			manifest.Series = append(manifest.Series, BackupSeries{Symbol: s.Symbol, Interval: s.Interval, Table: GetTableName(s.Symbol, s.Interval)})
+		}
+	}
+	if len(manifest.Series) == 0 {
+		return nil, ErrNoBackupTables
+	}
+
+	// 每个数据库在一个连接上开启一致性快照，同一数据库中的表读取的是同一时刻的数据
+	conns := make(map[*sql.DB]*sql.Conn)
+	defer func() {
+		for _, conn := range conns {
+			execSchema(conn, "COMMIT")
+			conn.Close()
+		}
+	}()
+	for _, s := range manifest.Series {
+		pool := klineConn(s.Symbol)
+		if conns[pool] != nil {
+			continue
+		}
+		conn, err := pool.Conn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := execSchema(conn, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("开启一致性快照失败: %v", err)
+		}
+		conns[pool] = conn
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeTarJSON(tw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	for i := range manifest.Series {
+		conn := conns[klineConn(manifest.Series[i].Symbol)]
+		if err := backupSeries(conn, tw, &manifest.Series[i], format); err != nil {
+			return nil, fmt.Errorf("备份表 %s 失败: %v", manifest.Series[i].Table, err)
+		}
+		manifest.Rows += manifest.Series[i].Rows
+	}
+	manifest.FinishedAt = utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	if err := writeTarJSON(tw, "summary.json", manifest); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	utils.AddCounter("biupdata_backup_rows_total", float64(manifest.Rows))
+	utils.LogInfo("已备份 %d 个K线表共 %d 条K线（%s格式）", len(manifest.Series), manifest.Rows, format)
+	return manifest, nil
+}
+
+// backupSeries 按开盘时间分块读取一张表，每backupPartRows条写成一个数据文件
+func backupSeries(conn *sql.Conn, tw *tar.Writer, series *BackupSeries, format string) error {
+	var part bytes.Buffer
+	var enc backupEncoder
+	flush := func() error {
+		if enc == nil {
+			return nil
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		series.Parts++
+		name := fmt.Sprintf("%s/part-%05d.%s", series.Table, series.Parts, format)
+		if err := writeTarFile(tw, name, part.Bytes()); err != nil {
+			return err
+		}
+		part.Reset()
+		enc = nil
+		return nil
+	}
+
+	after := "1000-01-01 00:00:00"
+	partRows := 0
+	for {
+		rows, err := queryRows(conn, fmt.Sprintf(`
+		SELECT timestamp, open_price, close_price, high_price, low_price, volume, note
+		FROM %s WHERE timestamp > ? ORDER BY timestamp LIMIT ?
+		`, series.Table), after, backupChunkRows)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for rows.Next() {
+			var ts time.Time
+			var open, closePrice, high, low, volume string
+			var note sql.NullString
+			if err := rows.Scan(&ts, &open, &closePrice, &high, &low, &volume, &note); err != nil {
+				rows.Close()
+				return err
+			}
+			after = ts.Format("2006-01-02 15:04:05")
+			values := []*string{&after, &open, &closePrice, &high, &low, &volume, nil}
+			if note.Valid {
+				values[6] = &note.String
+			}
+
+			if enc == nil {
+				enc = newBackupEncoder(&part, series.Table, format)
+				partRows = 0
+			}
+			if err := enc.Write(values); err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+			partRows++
+			series.Rows++
+			if partRows >= backupPartRows {
+				if err := flush(); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if n < backupChunkRows {
+			break
+		}
+	}
+	return flush()
+}
+
+// writeTarJSON 把v编码为JSON写入归档
+func writeTarJSON(tw *tar.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, name, data)
+}
+
+// writeTarFile 写入归档中的一个文件
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// backupEncoder 把K线写成数据文件
+type backupEncoder interface {
+	Write(values []*string) error
+	Close() error
+}
+
+// newBackupEncoder 按格式创建数据文件的编码器
+func newBackupEncoder(w io.Writer, table, format string) backupEncoder {
+	if format == BackupFormatCSV {
+		cw := csv.NewWriter(w)
+		cw.Write(backupColumns)
+		return &csvBackupEncoder{w: cw}
+	}
+	return &sqlBackupEncoder{w: w, table: table}
+}
+
+// csvBackupEncoder CSV格式，首行为列名，NULL写为 \N
+type csvBackupEncoder struct {
+	w *csv.Writer
+}
+
+// Write 写入一行
+func (e *csvBackupEncoder) Write(values []*string) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			record[i] = csvNull
+		} else {
+			record[i] = *v
+		}
+	}
+	return e.w.Write(record)
+}
+
+// Close 写出缓冲的数据
+func (e *csvBackupEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// sqlBackupEncoder SQL格式，每backupStmtRows条K线一条INSERT语句，每行一条K线，字符串中的换行已转义
+type sqlBackupEncoder struct {
+	w     io.Writer
+	table string
+	rows  int // 当前语句已写入的K线数
+}
+
+// sqlInsertHeader INSERT语句的第一行
+func sqlInsertHeader(table string) string {
+	return fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES", table, strings.Join(backupColumns, "`, `"))
+}
+
+// sqlInsertFooter INSERT语句的最后一行，重复的开盘时间覆盖已有数据
+func sqlInsertFooter() string {
+	updates := make([]string, 0, len(backupColumns)-1)
+	for _, column := range backupColumns[1:] {
+		updates = append(updates, fmt.Sprintf("`%s` = VALUES(`%s`)", column, column))
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ") + ";"
+}
+
+// Write 写入一条K线，语句满backupStmtRows条时结束
+func (e *sqlBackupEncoder) Write(values []*string) error {
+	var b strings.Builder
+	if e.rows == 0 {
+		b.WriteString(sqlInsertHeader(e.table))
+		b.WriteString("\n(")
+	} else {
+		b.WriteString(",\n(")
+	}
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(sqlQuote(v))
+	}
+	b.WriteString(")")
+	e.rows++
+	if e.rows >= backupStmtRows {
+		b.WriteString("\n" + sqlInsertFooter() + "\n")
+		e.rows = 0
+	}
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+// Close 结束未写完的语句
+func (e *sqlBackupEncoder) Close() error {
+	if e.rows == 0 {
+		return nil
+	}
+	e.rows = 0
+	_, err := io.WriteString(e.w, "\n"+sqlInsertFooter()+"\n")
+	return err
+}
+
+// sqlQuote 转义为SQL字符串字面量，换行等控制字符转义后语句中的每条K线只占一行
+func sqlQuote(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(*v); i++ {
+		switch c := (*v)[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		case '\\', '\'':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// RestoredSeries 恢复的一个K线序列
+type RestoredSeries struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	Rows     int64  `json:"rows"`     // 恢复的K线数
+	Expected int64  `json:"expected"` // 备份中的K线数，备份不完整时为0
+}
+
+// RestoreResult 恢复结果
+type RestoreResult struct {
+	Manifest BackupManifest   `json:"manifest"`
+	Series   []RestoredSeries `json:"series"`
+	Rows     int64            `json:"rows"`
+	Complete bool             `json:"complete"` // 备份包含summary.json且每个序列的行数一致
+}
+
+// RestoreBackup 读取WriteBackup生成的归档并写入主库：缺少的K线表按当前配置创建，
+// 已有的K线按开盘时间覆盖；只恢复filter选中的序列。目标表由序列的交易对和时间间隔按当前配置推导，
+// 不使用归档中记录的表名，数据文件中的值解析后以参数绑定写入，不执行归档中的任何SQL文本。
+// 恢复按数据文件逐批写入，不是单个事务，中途失败时已写入的数据保留，可以重新执行
+func RestoreBackup(r io.Reader, filter BackupFilter) (*RestoreResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("不是有效的备份文件: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != "manifest.json" {
+		return nil, fmt.Errorf("不是有效的备份文件: 缺少 manifest.json")
+	}
+	result := &RestoreResult{}
+	if err := json.NewDecoder(tr).Decode(&result.Manifest); err != nil {
+		return nil, fmt.Errorf("解析 manifest.json 失败: %v", err)
+	}
+	if result.Manifest.Version > backupVersion {
+		return nil, fmt.Errorf("备份格式版本 %d 高于当前支持的版本 %d，请升级后再恢复", result.Manifest.Version, backupVersion)
+	}
+
+	tables := make(map[string]int) // 归档中的表名（数据文件所在目录） -> result.Series中的下标
+	for _, s := range result.Manifest.Series {
+		if !backupSymbolPattern.MatchString(s.Symbol) || !config.IsSupportedInterval(s.Interval) {
+			return nil, fmt.Errorf("manifest.json 中无效的序列: %q %q", s.Symbol, s.Interval)
+		}
+		if filter.Match(s.Symbol, s.Interval) {
+			tables[s.Table] = len(result.Series)
+			result.Series = append(result.Series, RestoredSeries{Symbol: strings.ToUpper(s.Symbol), Interval: s.Interval})
+		}
+	}
+	targets := make(map[int]string) // result.Series中的下标 -> 写入的表名
+
+	var summary *BackupManifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("读取备份失败（已恢复 %d 条K线）: %v", result.Rows, err)
+		}
+
+		if header.Name == "summary.json" {
+			summary = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(summary); err != nil {
+				return result, fmt.Errorf("解析 summary.json 失败: %v", err)
+			}
+			continue
+		}
+
+		idx, ok := tables[path.Dir(header.Name)]
+		if !ok {
+			continue
+		}
+		series := &result.Series[idx]
+		table, ok := targets[idx]
+		if !ok {
+			if err := CreateTableIfNotExists(series.Symbol, series.Interval); err != nil {
+				return result, err
+			}
+			table = GetTableName(series.Symbol, series.Interval)
+			targets[idx] = table
+		}
+
+		var n int64
+		switch path.Ext(header.Name) {
+		case ".sql":
+			n, err = restoreSQLPart(klineConn(series.Symbol), tr, table)
+		case ".csv":
+			n, err = restoreCSVPart(klineConn(series.Symbol), tr, table)
+		default:
+			continue
+		}
+		series.Rows += n
+		result.Rows += n
+		if err != nil {
+			return result, fmt.Errorf("恢复 %s 失败（已恢复 %d 条K线）: %v", header.Name, result.Rows, err)
+		}
+	}
+
+	result.Complete = summary != nil
+	if summary != nil {
+		expected := make(map[string]int64, len(summary.Series))
+		for _, s := range summary.Series {
+			expected[s.Table] = s.Rows
+		}
+		for table, idx := range tables {
+			result.Series[idx].Expected = expected[table]
+			if result.Series[idx].Rows != expected[table] {
+				result.Complete = false
+			}
+		}
+	}
+
+	utils.AddCounter("biupdata_restore_rows_total", float64(result.Rows))
+	if result.Complete {
+		utils.LogInfo("已从 %s 于 %s 生成的备份恢复 %d 个K线表共 %d 条K线", result.Manifest.Host, result.Manifest.CreatedAt, len(result.Series), result.Rows)
+	} else {
+		utils.LogWarning("备份不完整，已恢复 %d 个K线表共 %d 条K线，请核对各表的行数", len(result.Series), result.Rows)
+	}
+	return result, nil
+}
+
+// restoreSQLPart 解析SQL数据文件中的INSERT语句并按参数绑定写入表table；只接受WriteBackup生成的格式，
+// 语句首尾行必须与生成时的格式一致（表名不限，写入的表由调用方决定），每行一条K线，值只能是NULL或字符串字面量
+func restoreSQLPart(conn *sql.DB, r io.Reader, table string) (int64, error) {
+	footerLine := sqlInsertFooter()
+	headerSuffix := strings.TrimPrefix(sqlInsertHeader(""), "INSERT INTO ``")
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	w := newRestoreWriter(conn, table)
+	inStmt := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !inStmt:
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "--") {
+				continue
+			}
+			if !strings.HasPrefix(line, "INSERT INTO `") || !strings.HasSuffix(line, headerSuffix) {
+				return w.total, fmt.Errorf("无法识别的语句: %.80s", line)
+			}
+			inStmt = true
+		case line == footerLine:
+			if err := w.flush(); err != nil {
+				return w.total, err
+			}
+			inStmt = false
+		default:
+			values, err := parseSQLTuple(line)
+			if err != nil {
+				return w.total, err
+			}
+			if err := w.add(values); err != nil {
+				return w.total, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return w.total, err
+	}
+	if inStmt {
+		return w.total, fmt.Errorf("最后一条语句不完整")
+	}
+	return w.total, nil
+}
+
+// parseSQLTuple 解析sqlBackupEncoder写出的一行，如 ('2024-01-01 00:00:00', '1.5', NULL),
+// 只接受NULL和sqlQuote转义的字符串，列数必须与backupColumns相同
+func parseSQLTuple(line string) ([]interface{}, error) {
+	bad := func() ([]interface{}, error) {
+		return nil, fmt.Errorf("无法识别的行: %.80s", line)
+	}
+	rest := strings.TrimSuffix(line, ",")
+	if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+		return bad()
+	}
+	rest = rest[1 : len(rest)-1]
+
+	values := make([]interface{}, 0, len(backupColumns))
+	for {
+		if strings.HasPrefix(rest, "NULL") {
+			values = append(values, nil)
+			rest = rest[len("NULL"):]
+		} else if strings.HasPrefix(rest, "'") {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '\''; i++ {
+				c := rest[i]
+				if c != '\\' {
+					b.WriteByte(c)
+					continue
+				}
+				if i++; i >= len(rest) {
+					return bad()
+				}
+				switch rest[i] {
+				case '0':
+					b.WriteByte(0)
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 'Z':
+					b.WriteByte(0x1a)
+				case '\\', '\'':
+					b.WriteByte(rest[i])
+				default:
+					return bad()
+				}
+			}
+			if i >= len(rest) {
+				return bad()
+			}
+			values = append(values, b.String())
+			rest = rest[i+1:]
+		} else {
+			return bad()
+		}
+
+		if rest == "" {
+			break
+		}
+		if !strings.HasPrefix(rest, ", ") {
+			return bad()
+		}
+		rest = rest[2:]
+	}
+	if len(values) != len(backupColumns) {
+		return bad()
+	}
+	return values, nil
+}
+
+// restoreWriter 把解析出的K线按参数绑定分批写入K线表，每backupStmtRows条一条INSERT语句
+type restoreWriter struct {
+	conn  *sql.DB
+	table string
+	args  []interface{}
+	rows  int
+	total int64
+}
+
+// newRestoreWriter 创建写入表table的restoreWriter
+func newRestoreWriter(conn *sql.DB, table string) *restoreWriter {
+	return &restoreWriter{conn: conn, table: table}
+}
+
+// add 加入一条K线，满backupStmtRows条时写入
+func (w *restoreWriter) add(values []interface{}) error {
+	w.args = append(w.args, values...)
+	w.rows++
+	if w.rows >= backupStmtRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush 写入已加入的K线
+func (w *restoreWriter) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(backupColumns)), ", ") + ")"
+	query := sqlInsertHeader(w.table) + " " + strings.TrimSuffix(strings.Repeat(placeholder+", ", w.rows), ", ") + " " + strings.TrimSuffix(sqlInsertFooter(), ";")
+	if _, err := execQuery(w.conn, query, w.args...); err != nil {
+		return err
+	}
+	w.total += int64(w.rows)
+	w.args, w.rows = w.args[:0], 0
+	return nil
+}
+
+// restoreCSVPart 按表头读取CSV数据文件，每backupStmtRows条写入一次
+func restoreCSVPart(conn *sql.DB, r io.Reader, table string) (int64, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	if strings.Join(header, ",") != strings.Join(backupColumns, ",") {
+		return 0, fmt.Errorf("表头应为 %s", strings.Join(backupColumns, ","))
+	}
+
+	w := newRestoreWriter(conn, table)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return w.total, err
+		}
+		if len(record) != len(backupColumns) {
+			return w.total, fmt.Errorf("列数应为 %d", len(backupColumns))
+		}
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != csvNull {
+				values[i] = v
+			}
+		}
+		if err := w.add(values); err != nil {
+			return w.total, err
+		}
+	}
+	return w.total, w.flush()
+}
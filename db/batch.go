@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 批量写入遇到死锁或锁等待超时时的最大重试次数
+const batchMaxRetries = 3
+
+// MySQL错误码：死锁、锁等待超时、唯一键冲突
+const (
+	mysqlErrDeadlock        = 1213
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDuplicateEntry  = 1062
+)
+
+// 批量写入耗时的指数加权平均中最近一次写入的权重
+const batchLatencyAlpha = 0.3
+
+// 超过该时间没有批量写入时，平均耗时不再反映数据库当前的状态
+const batchLatencyTTL = 5 * time.Minute
+
+var (
+	batchLatency   time.Duration // 批量写入耗时的指数加权平均
+	batchLatencyAt time.Time     // 最近一次批量写入的时间
+	batchLatencyMu sync.Mutex
+)
+
+// recordBatchLatency 记录一次批量写入事务的耗时
+func recordBatchLatency(d time.Duration) {
+	batchLatencyMu.Lock()
+	if batchLatencyAt.IsZero() || time.Since(batchLatencyAt) > batchLatencyTTL {
+		batchLatency = d
+	} else {
+		batchLatency = time.Duration(batchLatencyAlpha*float64(d) + (1-batchLatencyAlpha)*float64(batchLatency))
+	}
+	batchLatencyAt = time.Now()
+	avg := batchLatency
+	batchLatencyMu.Unlock()
+
+	utils.SetGauge("biupdata_db_batch_latency_seconds", avg.Seconds())
+}
+
+// BatchWriteLatency 最近批量写入事务的平均耗时，最近一段时间没有写入时返回0
+func BatchWriteLatency() time.Duration {
+	batchLatencyMu.Lock()
+	defer batchLatencyMu.Unlock()
+
+	if batchLatencyAt.IsZero() || time.Since(batchLatencyAt) > batchLatencyTTL {
+		return 0
+	}
+	return batchLatency
+}
+
+// SaveKlineBatch 在一个事务中写入同一交易对和时间间隔的一批K线，要么全部写入，要么全部不写入
+// 死锁或锁等待超时时整批重试（写入为幂等的upsert，重复执行结果相同），数据库连接不可用时整批写入缓存队列
+func SaveKlineBatch(ctx context.Context, symbol, interval string, records []KlineRecord) (err error) {
+	if len(records) == 0 {
+		return nil
+	}
+
+	ctx, span := utils.StartSpan(ctx, "db.save_kline_batch", utils.SpanKindClient)
+	span.SetAttr("db.table", GetTableName(symbol, interval))
+	span.SetAttr("db.rows", len(records))
+	defer func() { span.End(err) }()
+
+	// 缓存队列中还有未回放的数据时，整批排队，保证写入顺序
+	queued, err := enqueueBatchIfPending(records)
+	if queued {
+		span.SetAttr("db.queued", true)
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		span.SetAttr("db.attempts", attempt)
+		start := time.Now()
+		err = writeKlineBatchTx(klineConn(symbol), GetTableName(symbol, interval), records)
+		if err == nil || isRetryableTxError(err) {
+			// 锁冲突同样说明数据库写入繁忙，计入耗时
+			recordBatchLatency(time.Since(start))
+		}
+		if err == nil {
+			if len(sinks) == 0 {
+				return nil
+			}
+			_, sinkSpan := utils.StartSpan(ctx, "sinks.write", utils.SpanKindClient)
+			err = writeSinks(records)
+			sinkSpan.End(err)
+			return err
+		}
+
+		if queuePath != "" && isConnectionError(err) {
+			utils.LogWarning("数据库不可用，%s %s 的 %d 条K线已写入缓存队列", symbol, interval, len(records))
+			span.SetAttr("db.queued", true)
+			return enqueueBatch(records)
+		}
+
+		if !isRetryableTxError(err) || attempt > batchMaxRetries {
+			utils.IncCounter(utils.MetricName("biupdata_db_batch_failures_total", "symbol", symbol, "interval", interval))
+			return fmt.Errorf("批量写入 %s %s 失败（已尝试 %d 次）: %v", symbol, interval, attempt, err)
+		}
+
+		utils.IncCounter(utils.MetricName("biupdata_db_batch_retries_total", "symbol", symbol, "interval", interval))
+		utils.LogWarning("批量写入 %s %s 遇到锁冲突，第 %d 次重试: %v", symbol, interval, attempt, err)
+		time.Sleep(time.Duration(attempt*attempt) * 100 * time.Millisecond)
+	}
+}
+
+// writeKlineBatchTx 在conn上执行一次批量写入tableName的事务
+func writeKlineBatchTx(conn *sql.DB, tableName string, records []KlineRecord) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := klineUpsertSQL(tableName)
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, k := range records {
+		formattedTime := utils.TimestampToShanghai(k.Timestamp).Format("2006-01-02 15:04:05")
+		if _, err := execStmt(stmt, query, formattedTime, k.Open, k.Close, k.High, k.Low, k.Volume, k.Note); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// isRetryableTxError 判断是否为可以整批重试的事务错误
+func isRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	return false
+}
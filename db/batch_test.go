@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSaveKlineBatchRetries(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found when trying to get lock"}
+	lockWait := &mysql.MySQLError{Number: mysqlErrLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+	duplicate := &mysql.MySQLError{Number: mysqlErrDuplicateEntry, Message: "Duplicate entry"}
+
+	tests := []struct {
+		name         string
+		failures     []error // 各次尝试返回的错误，之后的尝试成功
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"first attempt succeeds", nil, 1, false},
+		{"deadlock then success", []error{deadlock}, 2, false},
+		{"lock wait timeouts then success", []error{lockWait, deadlock}, 3, false},
+		{"persistent deadlock gives up", []error{deadlock, deadlock, deadlock, deadlock, deadlock}, batchMaxRetries + 1, true},
+		{"other errors are not retried", []error{duplicate}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDB(t)
+			attempts := 0
+			fake.OnExec = func(query string, args []driver.Value) error {
+				attempts++
+				if attempts <= len(tt.failures) {
+					return tt.failures[attempts-1]
+				}
+				return nil
+			}
+
+			records := []KlineRecord{{Symbol: "BTCUSDT", Interval: "1h", Timestamp: 3600000, Open: "1", Close: "2", High: "3", Low: "0.5", Volume: "10"}}
+			err := SaveKlineBatch(context.Background(), "BTCUSDT", "1h", records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveKlineBatch error = %v, want error %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// BookTicker 一个交易对某一时刻的最优买卖价
+type BookTicker struct {
+	Symbol    string
+	Timestamp int64 // 采集时间（UTC毫秒）
+	BidPrice  string
+	BidQty    string
+	AskPrice  string
+	AskQty    string
+}
+
+// CreateBookTickerTableIfNotExists 创建最优买卖价快照表
+func CreateBookTickerTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		symbol VARCHAR(32) NOT NULL,
+		timestamp DATETIME(3) NOT NULL COMMENT '上海时间，采集时间',
+		bid_price DECIMAL(30,8) NOT NULL,
+		bid_qty DECIMAL(30,8) NOT NULL,
+		ask_price DECIMAL(30,8) NOT NULL,
+		ask_qty DECIMAL(30,8) NOT NULL,
+		PRIMARY KEY (symbol, timestamp),
+		KEY idx_time (timestamp)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("book_ticker"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 book_ticker 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SaveBookTickers 在一条语句中保存一批快照，同一交易对同一时刻重复写入时忽略
+func SaveBookTickers(tickers []BookTicker) error {
+	if len(tickers) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(tickers))
+	args := make([]interface{}, 0, len(tickers)*6)
+	for i, t := range tickers {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, t.Symbol, utils.TimestampToShanghai(t.Timestamp).Format("2006-01-02 15:04:05.000"),
+			t.BidPrice, t.BidQty, t.AskPrice, t.AskQty)
+	}
+
+	_, err := execQuery(DB, fmt.Sprintf(`
+	INSERT IGNORE INTO %s (symbol, timestamp, bid_price, bid_qty, ask_price, ask_qty)
+	VALUES `, prefixTable("book_ticker"))+strings.Join(placeholders, ", "), args...)
+	if err != nil {
+		utils.LogError("保存最优买卖价失败: %v", err)
+	}
+	return err
+}
+
+// ListBookTickers 在只读连接上按时间倒序查询[startTime, endTime]区间内的快照（UTC毫秒，为0时不限制该端）
+func ListBookTickers(symbol string, startTime, endTime int64, limit int) ([]BookTicker, error) {
+	query := fmt.Sprintf(`
+	SELECT symbol, timestamp, bid_price, bid_qty, ask_price, ask_qty
+	FROM %s WHERE symbol = ?
+	`, prefixTable("book_ticker"))
+	args := []interface{}{symbol}
+	if startTime > 0 {
+		query += " AND timestamp >= ?"
+		args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05.000"))
+	}
+	if endTime > 0 {
+		query += " AND timestamp <= ?"
+		args = append(args, utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05.000"))
+	}
+	query += " ORDER BY timestamp DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := queryRows(readConn(), query, args...)
+	if err != nil {
+		utils.LogError("查询 %s 最优买卖价失败: %v", symbol, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []BookTicker
+	for rows.Next() {
+		var t BookTicker
+		var ts time.Time
+		if err := rows.Scan(&t.Symbol, &ts, &t.BidPrice, &t.BidQty, &t.AskPrice, &t.AskQty); err != nil {
+			utils.LogError("扫描最优买卖价失败: %v", err)
+			return nil, err
+		}
+		t.Timestamp = utils.StoredTimestampToUTC(ts.UnixMilli())
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+// DeleteBookTickersBefore 分批删除采集时间早于cutoffUTC的快照
+func DeleteBookTickersBefore(cutoffUTC int64) (int64, error) {
+	cutoff := utils.TimestampToShanghai(cutoffUTC).Format("2006-01-02 15:04:05.000")
+	n, err := deleteBefore(DB, prefixTable("book_ticker"), cutoff)
+	if err != nil {
+		return n, fmt.Errorf("清理最优买卖价快照失败: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Candle 一根K线，币安返回的K线、数据库读出的K线和接口返回的K线共用；价格和成交量保持十进制字符串，避免浮点误差
+type Candle struct {
+	// 开盘时间（毫秒）：币安返回的为UTC时间戳，数据库读出的与表中存储的上海时间一致（见utils.StoredTimestampToUTC）
+	OpenTime int64  `json:"open_time"`
+	Open     string `json:"open"`
+	High     string `json:"high"`
+	Low      string `json:"low"`
+	Close    string `json:"close"`
+	Volume   string `json:"volume"`
+	Closed   bool   `json:"closed"` // 是否已收盘，数据库读出的K线由调用方按时间间隔判断
+	Note     string `json:"note,omitempty"`
+}
+
+// UnmarshalJSON 解析币安的K线数组 [开盘时间, 开盘价, 最高价, 最低价, 收盘价, 成交量, 收盘时间, ...]，
+// 收盘时间早于当前时间的为已收盘；也接受Candle自身序列化的对象
+func (c *Candle) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		type candle Candle
+		return json.Unmarshal(data, (*candle)(c))
+	}
+
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) < 6 {
+		return fmt.Errorf("K线数据格式不正确: %s", data)
+	}
+	var k Candle
+	if err := json.Unmarshal(fields[0], &k.OpenTime); err != nil {
+		return fmt.Errorf("K线开盘时间格式不正确: %s", fields[0])
+	}
+	for i, dest := range []*string{&k.Open, &k.High, &k.Low, &k.Close, &k.Volume} {
+		if err := json.Unmarshal(fields[i+1], dest); err != nil {
+			return fmt.Errorf("K线 %d 的第%d个字段不是字符串: %s", k.OpenTime, i+2, fields[i+1])
+		}
+	}
+	if len(fields) > 6 {
+		var closeTime int64
+		if err := json.Unmarshal(fields[6], &closeTime); err != nil {
+			return fmt.Errorf("K线收盘时间格式不正确: %s", fields[6])
+		}
+		k.Closed = closeTime < time.Now().UnixMilli()
+	}
+	*c = k
+	return nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ConnStore 使用调用方传入的MySQL连接的 UpdateStore 实现，不使用 InitDB 建立的全局连接、缓存队列、
+// 输出目标和交易对标识登记，同一进程中可以同时使用多个，分别写入不同的数据库。
+// 表名按配置的前缀和模板生成，交易对标识按规则推导（见 canonicalSymbolID）；K线表不分区
+type ConnStore struct {
+	conn     *sql.DB
+	prefix   string
+	template string
+
+	mu        sync.Mutex
+	tables    map[string]bool // 已确认存在的K线表
+	syncReady bool            // 同步进度表已创建
+}
+
+// NewConnStore 创建写入conn的存储，cfg提供表名前缀和模板，为nil时使用默认值；conn由调用方关闭
+func NewConnStore(conn *sql.DB, cfg *config.DatabaseConfig) *ConnStore {
+	s := &ConnStore{conn: conn, template: config.DefaultTableTemplate, tables: make(map[string]bool)}
+	if cfg != nil {
+		s.prefix = strings.ToLower(cfg.TablePrefix)
+		if cfg.TableTemplate != "" {
+			s.template = strings.ToLower(cfg.TableTemplate)
+		}
+	}
+	return s
+}
+
+// OpenConnStore 按数据库配置新建一个MySQL连接，返回使用它的存储和连接，连接由调用方关闭
+func OpenConnStore(cfg *config.DatabaseConfig) (*ConnStore, *sql.DB, error) {
+	conn, err := sql.Open("mysql", cfg.GetDSN())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	return NewConnStore(conn, cfg), conn, nil
+}
+
+// TableName 交易对和时间间隔的K线表名
+func (s *ConnStore) TableName(symbol, interval string) string {
+	return formatTableName(s.prefix, s.template, canonicalSymbolID(symbol), interval)
+}
+
+// syncTable 同步进度表名
+func (s *ConnStore) syncTable() string {
+	return s.prefix + "sync_state"
+}
+
+// CreateTable 确保K线表存在
+func (s *ConnStore) CreateTable(symbol, interval string) error {
+	tableName := s.TableName(symbol, interval)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tables[tableName] {
+		return nil
+	}
+
+	if _, err := execSchema(s.conn, klineTableDDL(tableName)); err != nil {
+		utils.LogError("创建表 %s 失败: %v", tableName, err)
+		return err
+	}
+	s.tables[tableName] = true
+	return nil
+}
+
+// ensureSyncTable 确保同步进度表存在
+func (s *ConnStore) ensureSyncTable() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncReady {
+		return nil
+	}
+	if _, err := execSchema(s.conn, syncStateTableDDL(s.syncTable())); err != nil {
+		utils.LogError("创建表 %s 失败: %v", s.syncTable(), err)
+		return err
+	}
+	s.syncReady = true
+	return nil
+}
+
+// SaveKlines 在一个事务中写入一批K线，遇到死锁或锁等待超时时整批重试
+func (s *ConnStore) SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tableName := s.TableName(symbol, interval)
+	for attempt := 1; ; attempt++ {
+		err := writeKlineBatchTx(s.conn, tableName, records)
+		if err == nil {
+			return nil
+		}
+		if !isRetryableTxError(err) || attempt > batchMaxRetries {
+			return fmt.Errorf("批量写入 %s %s 失败（已尝试 %d 次）: %v", symbol, interval, attempt, err)
+		}
+		utils.LogWarning("批量写入 %s %s 遇到锁冲突，第 %d 次重试: %v", symbol, interval, attempt, err)
+		time.Sleep(time.Duration(attempt*attempt) * 100 * time.Millisecond)
+	}
+}
+
+// LastKlineTimestamp 最新一根K线的时间戳（按上海时间存储），表不存在时先建表
+func (s *ConnStore) LastKlineTimestamp(symbol, interval string) (int64, bool, error) {
+	if err := s.CreateTable(symbol, interval); err != nil {
+		return 0, false, err
+	}
+	var timestamp time.Time
+	err := queryRow(s.conn, fmt.Sprintf("SELECT timestamp FROM %s ORDER BY timestamp DESC LIMIT 1", s.TableName(symbol, interval))).Scan(&timestamp)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return timestamp.Unix() * 1000, true, nil
+}
+
+// GetSyncState 读取同步进度，没有记录时返回nil
+func (s *ConnStore) GetSyncState(symbol, interval string) (*SyncState, error) {
+	if err := s.ensureSyncTable(); err != nil {
+		return nil, err
+	}
+	return getSyncState(s.conn, s.syncTable(), symbol, interval)
+}
+
+// AdvanceSyncWatermark 把水位推进到watermark（UTC毫秒），只前进不后退
+func (s *ConnStore) AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	if err := s.ensureSyncTable(); err != nil {
+		return err
+	}
+	return advanceSyncWatermark(s.conn, s.syncTable(), symbol, interval, watermark)
+}
+
+// MarkSyncSuccess 记录最近一次同步成功的时间
+func (s *ConnStore) MarkSyncSuccess(symbol, interval string) error {
+	if err := s.ensureSyncTable(); err != nil {
+		return err
+	}
+	return markSyncSuccess(s.conn, s.syncTable(), symbol, interval)
+}
+
+// KlineRange 按时间升序读取[startUTC, endUTC]内的K线，最多limit条，startUTC/endUTC为0时不限制该端；
+// 返回的OpenTime与数据库中存储的时间口径一致
+func (s *ConnStore) KlineRange(symbol, interval string, startUTC, endUTC int64, limit int) ([]Candle, error) {
+	if err := s.CreateTable(symbol, interval); err != nil {
+		return nil, err
+	}
+	return queryKlineRange(s.conn, s.TableName(symbol, interval), startUTC, endUTC, limit)
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+func TestConnStoreUsesOwnConnection(t *testing.T) {
+	// 全局连接未初始化，ConnStore的任何操作都不能用到它
+	prevDB := DB
+	DB = nil
+	t.Cleanup(func() { DB = prevDB })
+
+	fake, conn := openFakeDB(t)
+	store := NewConnStore(conn, &config.DatabaseConfig{TablePrefix: "Bot_"})
+
+	if got := store.TableName("1000SHIBUSDT", "1M"); got != "bot_s_1000shibusdt_1mo" {
+		t.Fatalf("TableName = %q, want bot_s_1000shibusdt_1mo", got)
+	}
+
+	ts := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	if err := store.CreateTable("BTCUSDT", "1h"); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	records := []KlineRecord{
+		{Symbol: "BTCUSDT", Interval: "1h", Timestamp: ts, Open: "1", Close: "2", High: "3", Low: "0.5", Volume: "10"},
+		{Symbol: "BTCUSDT", Interval: "1h", Timestamp: ts + time.Hour.Milliseconds(), Open: "2", Close: "3", High: "4", Low: "1", Volume: "20"},
+	}
+	if err := store.SaveKlines(context.Background(), "BTCUSDT", "1h", records); err != nil {
+		t.Fatalf("SaveKlines: %v", err)
+	}
+	if err := store.AdvanceSyncWatermark("BTCUSDT", "1h", ts); err != nil {
+		t.Fatalf("AdvanceSyncWatermark: %v", err)
+	}
+
+	if n := len(fake.ExecsMatching("CREATE TABLE IF NOT EXISTS bot_btcusdt_1h")); n != 1 {
+		t.Fatalf("created kline table %d times, want 1", n)
+	}
+	if n := len(fake.ExecsMatching("INSERT INTO bot_btcusdt_1h")); n != len(records) {
+		t.Fatalf("inserted %d candles, want %d", n, len(records))
+	}
+	if n := len(fake.ExecsMatching("CREATE TABLE IF NOT EXISTS bot_sync_state")); n != 1 {
+		t.Fatalf("created sync state table %d times, want 1", n)
+	}
+	if n := len(fake.ExecsMatching("INSERT INTO bot_sync_state")); n != 1 {
+		t.Fatalf("watermark writes = %d, want 1", n)
+	}
+
+	// 表已确认存在后不再重复建表
+	if err := store.CreateTable("BTCUSDT", "1h"); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	if n := len(fake.ExecsMatching("CREATE TABLE IF NOT EXISTS bot_btcusdt_1h")); n != 1 {
+		t.Fatalf("created kline table %d times after second CreateTable, want 1", n)
+	}
+}
@@ -14,6 +14,19 @@ import (
 // DB 数据库连接实例
 var DB *sql.DB
 
+// KlineRecord 待写入数据库的一条K线，字段与SaveKlineData的参数一致，也是缓存队列的记录格式
+type KlineRecord struct {
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	Timestamp int64  `json:"timestamp"` // UTC毫秒时间戳
+	Open      string `json:"open"`
+	Close     string `json:"close"`
+	High      string `json:"high"`
+	Low       string `json:"low"`
+	Volume    string `json:"volume"`
+	Note      string `json:"note"`
+}
+
 // InitDB 初始化数据库连接
 func InitDB(cfg *config.DatabaseConfig) error {
 	var err error
@@ -29,21 +42,60 @@ func InitDB(cfg *config.DatabaseConfig) error {
 		return err
 	}
 
+	initQueryTimeout(cfg)
+	if err := initRoutes(cfg); err != nil {
+		return err
+	}
+	if err := loadSymbolRegistry(DB); err != nil {
+		return fmt.Errorf("加载交易对标识失败: %v", err)
+	}
+
+	partitioning = cfg.Partitioning
+	ensureIndexes = cfg.EnsureIndexes
+	partitionAhead = cfg.PartitionAheadMonths
+
 	utils.LogInfo("数据库连接成功")
-	return nil
+	return initReadDB(cfg)
 }
 
 // CloseDB 关闭数据库连接
 func CloseDB() {
+	closeReadDB()
+	closeRoutes()
 	if DB != nil {
 		DB.Close()
+		DB = nil
 	}
 }
 
-// InitAllTables 初始化所有需要的表
-func InitAllTables(symbols []string, intervals []string) error {
+// InitAllTables 初始化所有需要的表，intervalsFor返回每个交易对采集的时间间隔
+func InitAllTables(symbols []string, intervalsFor func(symbol string) []string) error {
+	if err := CreateNoteAuditTableIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateEventsTableIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateAlertTablesIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateDatasetTablesIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateSettingsTableIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateSyncStateTableIfNotExists(); err != nil {
+		return err
+	}
+	if err := CreateSymbolRegistryTableIfNotExists(); err != nil {
+		return err
+	}
 	for _, symbol := range symbols {
-		for _, interval := range intervals {
+		if err := migrateMonthTables(symbol, intervalsFor(symbol)); err != nil {
+			return err
+		}
+		for _, interval := range intervalsFor(symbol) {
 			if err := CreateTableIfNotExists(symbol, interval); err != nil {
 				return err
 			}
@@ -53,42 +105,131 @@ func InitAllTables(symbols []string, intervals []string) error {
 	return nil
 }
 
+// migrateMonthTables 早期版本的月线表与1分钟线表同名（如 btcusdt_1m），月线现在使用 btcusdt_1mo；
+// 只采集1M不采集1m时旧表中只有月线，连同滚动统计表一起改名；两者都采集时旧表中两种K线互相覆盖，
+// 无法区分，保留旧表作为1分钟线表并记录警告，月线表新建后重新回补
+func migrateMonthTables(symbol string, intervals []string) error {
+	var month, minute bool
+	for _, interval := range intervals {
+		month = month || interval == "1M"
+		minute = minute || interval == "1m"
+	}
+	if !month {
+		return nil
+	}
+	if _, err := RegisterSymbol(symbol); err != nil {
+		return err
+	}
+
+	conn := klineConn(symbol)
+	legacy := tableNameFor(symbolID(symbol), "1m")
+	current := GetTableName(symbol, "1M")
+	legacyExists, err := tableExists(conn, legacy)
+	if err != nil || !legacyExists {
+		return err
+	}
+	currentExists, err := tableExists(conn, current)
+	if err != nil || currentExists {
+		return err
+	}
+
+	if minute {
+		utils.LogWarning("表 %s 中的1分钟线和月线可能互相覆盖，月线改为写入 %s 并重新回补，建议重建 %s 1m 的数据", legacy, current, symbol)
+		return nil
+	}
+	for _, suffix := range []string{"", "_stats"} {
+		exists, err := tableExists(conn, legacy+suffix)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := execSchema(conn, fmt.Sprintf("RENAME TABLE %s TO %s", legacy+suffix, current+suffix)); err != nil {
+			utils.LogError("月线表 %s 改名为 %s 失败: %v", legacy+suffix, current+suffix, err)
+			return err
+		}
+	}
+	utils.LogInfo("月线表 %s 已改名为 %s", legacy, current)
+	return nil
+}
+
 // CreateTableIfNotExists 如果表不存在则创建表
 func CreateTableIfNotExists(symbol, interval string) error {
+	if _, err := RegisterSymbol(symbol); err != nil {
+		return err
+	}
 	tableName := GetTableName(symbol, interval)
 
-	query := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		timestamp DATETIME NOT NULL COMMENT '上海时间',
-		open_price DECIMAL(30,8) NOT NULL,
-		close_price DECIMAL(30,8) NOT NULL,
-		high_price DECIMAL(30,8) NOT NULL,
-		low_price DECIMAL(30,8) NOT NULL,
-		volume DECIMAL(30,8) NOT NULL,
-		note TEXT,
-		PRIMARY KEY (timestamp)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-	`, tableName)
-
-	_, err := DB.Exec(query)
+	_, err := execSchema(klineConn(symbol), klineTableDDL(tableName))
 	if err != nil {
 		utils.LogError("创建表 %s 失败: %v", tableName, err)
 		return err
 	}
 
+	// 早期版本创建的表没有覆盖索引
+	if err := ensureKlineIndexes(klineConn(symbol), tableName); err != nil {
+		return err
+	}
+
+	// 启用分区时确保按月分区并提前创建未来月份的分区
+	if err := EnsurePartitions(symbol, interval); err != nil {
+		return err
+	}
+
 	utils.LogInfo("表 %s 已就绪", tableName)
 	return nil
 }
 
-// SaveKlineData 保存K线数据到数据库
+// SaveKlineData 保存K线数据到数据库，数据库连接不可用时写入磁盘缓存队列，恢复后自动回放
 func SaveKlineData(symbol, interval string, timestamp int64, openPrice, closePrice, highPrice, lowPrice, volume, note string) error {
-	tableName := GetTableName(symbol, interval)
+	k := KlineRecord{
+		Symbol:    symbol,
+		Interval:  interval,
+		Timestamp: timestamp,
+		Open:      openPrice,
+		Close:     closePrice,
+		High:      highPrice,
+		Low:       lowPrice,
+		Volume:    volume,
+		Note:      note,
+	}
 
-	// 将时间戳转换为上海时间
-	dateTime := utils.TimestampToShanghai(timestamp)
-	formattedTime := dateTime.Format("2006-01-02 15:04:05")
+	if queued, err := enqueueIfPending(k); queued {
+		return err
+	}
 
-	query := fmt.Sprintf(`
+	err := saveKlineRow(k)
+	if err != nil && queuePath != "" && isConnectionError(err) {
+		utils.LogWarning("数据库不可用，%s %s K线已写入缓存队列", symbol, interval)
+		return enqueueKline(k)
+	}
+	if err != nil {
+		return err
+	}
+	return writeSinks([]KlineRecord{k})
+}
+
+// klineTableDDL K线表的建表语句，主库和MySQL副本共用
+func klineTableDDL(tableName string) string {
+	return fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		timestamp DATETIME NOT NULL COMMENT '上海时间',
+		open_price DECIMAL(30,8) NOT NULL,
+		close_price DECIMAL(30,8) NOT NULL,
+		high_price DECIMAL(30,8) NOT NULL,
+		low_price DECIMAL(30,8) NOT NULL,
+		volume DECIMAL(30,8) NOT NULL,
+		note TEXT,
+		PRIMARY KEY (timestamp),
+		%s
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, tableName, klineIndexDefs())
+}
+
+// klineUpsertSQL 写入一条K线的语句，同一开盘时间重复写入时覆盖，采集时写入的空标注不会覆盖已有标注
+func klineUpsertSQL(tableName string) string {
+	return fmt.Sprintf(`
 	INSERT INTO %s (timestamp, open_price, close_price, high_price, low_price, volume, note)
 	VALUES (?, ?, ?, ?, ?, ?, ?)
 	ON DUPLICATE KEY UPDATE
@@ -97,10 +238,19 @@ func SaveKlineData(symbol, interval string, timestamp int64, openPrice, closePri
 		high_price = VALUES(high_price),
 		low_price = VALUES(low_price),
 		volume = VALUES(volume),
-		note = VALUES(note)
+		note = IF(VALUES(note) = '', note, VALUES(note))
 	`, tableName)
+}
+
+// saveKlineRow 将一条K线写入数据库
+func saveKlineRow(k KlineRecord) error {
+	tableName := GetTableName(k.Symbol, k.Interval)
 
-	_, err := DB.Exec(query, formattedTime, openPrice, closePrice, highPrice, lowPrice, volume, note)
+	// 将时间戳转换为上海时间
+	dateTime := utils.TimestampToShanghai(k.Timestamp)
+	formattedTime := dateTime.Format("2006-01-02 15:04:05")
+
+	_, err := execQuery(klineConn(k.Symbol), klineUpsertSQL(tableName), formattedTime, k.Open, k.Close, k.High, k.Low, k.Volume, k.Note)
 	if err != nil {
 		utils.LogError("保存K线数据到表 %s 失败: %v", tableName, err)
 		return err
@@ -109,12 +259,22 @@ func SaveKlineData(symbol, interval string, timestamp int64, openPrice, closePri
 	return nil
 }
 
-// GetKlineData 获取K线数据
-func GetKlineData(symbol, interval string, startTime, endTime int64, limit int) ([]map[string]interface{}, error) {
+// GetKlineData 从主库获取K线数据，写入路径需要读取最新数据时使用
+func GetKlineData(symbol, interval string, startTime, endTime int64, limit int) ([]Candle, error) {
+	return queryKlineData(klineConn(symbol), symbol, interval, startTime, endTime, limit)
+}
+
+// QueryKlineData 从只读连接获取K线数据，供查询接口使用，未配置只读连接时使用主库
+func QueryKlineData(symbol, interval string, startTime, endTime int64, limit int) ([]Candle, error) {
+	return queryKlineData(klineReadConn(symbol), symbol, interval, startTime, endTime, limit)
+}
+
+// queryKlineData 按时间倒序获取K线数据
+func queryKlineData(conn *sql.DB, symbol, interval string, startTime, endTime int64, limit int) ([]Candle, error) {
 	tableName := GetTableName(symbol, interval)
 
 	var query string
-	var rows *sql.Rows
+	var rows *timedRows
 	var err error
 
 	// 转换时间戳为日期时间格式
@@ -134,7 +294,7 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 		ORDER BY timestamp DESC
 		LIMIT ?
 		`, tableName)
-		rows, err = DB.Query(query, startTimeStr, endTimeStr, limit)
+		rows, err = queryRows(conn, query, startTimeStr, endTimeStr, limit)
 	} else if startTime > 0 {
 		query = fmt.Sprintf(`
 		SELECT timestamp, open_price, close_price, high_price, low_price, volume, note
@@ -143,7 +303,7 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 		ORDER BY timestamp DESC
 		LIMIT ?
 		`, tableName)
-		rows, err = DB.Query(query, startTimeStr, limit)
+		rows, err = queryRows(conn, query, startTimeStr, limit)
 	} else if endTime > 0 {
 		query = fmt.Sprintf(`
 		SELECT timestamp, open_price, close_price, high_price, low_price, volume, note
@@ -152,7 +312,7 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 		ORDER BY timestamp DESC
 		LIMIT ?
 		`, tableName)
-		rows, err = DB.Query(query, endTimeStr, limit)
+		rows, err = queryRows(conn, query, endTimeStr, limit)
 	} else {
 		query = fmt.Sprintf(`
 		SELECT timestamp, open_price, close_price, high_price, low_price, volume, note
@@ -160,7 +320,7 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 		ORDER BY timestamp DESC
 		LIMIT ?
 		`, tableName)
-		rows, err = DB.Query(query, limit)
+		rows, err = queryRows(conn, query, limit)
 	}
 
 	if err != nil {
@@ -169,7 +329,53 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 	}
 	defer rows.Close()
 
-	var result []map[string]interface{}
+	return scanKlineRows(rows, tableName)
+}
+
+// QueryKlineRange 从只读连接按时间升序获取[startTime, endTime]区间内的K线，最多limit条，startTime/endTime为0时不限制该端
+// 返回格式与QueryKlineData相同，用于分块导出大量数据
+func QueryKlineRange(symbol, interval string, startTime, endTime int64, limit int) ([]Candle, error) {
+	return queryKlineRange(klineReadConn(symbol), GetTableName(symbol, interval), startTime, endTime, limit)
+}
+
+// queryKlineRange 在指定连接上按开盘时间范围查询K线表，需要读到最新数据时传入主库连接
+func queryKlineRange(conn sqlConn, tableName string, startTime, endTime int64, limit int) ([]Candle, error) {
+
+	var conditions []string
+	var args []interface{}
+	if startTime > 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"))
+	}
+	if endTime > 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05"))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+
+	rows, err := queryRows(conn, fmt.Sprintf(`
+	SELECT timestamp, open_price, close_price, high_price, low_price, volume, note
+	FROM %s
+	%s
+	ORDER BY timestamp
+	LIMIT ?
+	`, tableName, where), args...)
+	if err != nil {
+		utils.LogError("查询表 %s 数据失败: %v", tableName, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanKlineRows(rows, tableName)
+}
+
+// scanKlineRows 读取K线查询结果，OpenTime与数据库中存储的时间口径一致
+func scanKlineRows(rows *timedRows, tableName string) ([]Candle, error) {
+	var result []Candle
 
 	for rows.Next() {
 		var timestamp time.Time
@@ -181,34 +387,163 @@ func GetKlineData(symbol, interval string, startTime, endTime int64, limit int)
 			return nil, err
 		}
 
-		// 格式化时间
-		formattedTime := timestamp.Format("2006-01-02 15:04")
-
-		// 转回时间戳以保持API兼容性
-		unixTimestamp := timestamp.Unix() * 1000
-
-		data := map[string]interface{}{
-			"timestamp":   unixTimestamp,
-			"datetime":    formattedTime,
-			"open_price":  openPrice.String,
-			"close_price": closePrice.String,
-			"high_price":  highPrice.String,
-			"low_price":   lowPrice.String,
-			"volume":      volume.String,
-			"note":        note.String,
+		result = append(result, Candle{
+			// 转回时间戳以保持API兼容性
+			OpenTime: timestamp.Unix() * 1000,
+			Open:     openPrice.String,
+			High:     highPrice.String,
+			Low:      lowPrice.String,
+			Close:    closePrice.String,
+			Volume:   volume.String,
+			Note:     note.String,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		utils.LogError("读取表 %s 数据失败: %v", tableName, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetKlineTimeRange 获取表中最早和最晚一条K线的时间戳（与GetKlineData返回的OpenTime口径一致）及记录数
+func GetKlineTimeRange(symbol, interval string) (int64, int64, int64, error) {
+	tableName := GetTableName(symbol, interval)
+
+	var first, last sql.NullTime
+	var count int64
+	query := fmt.Sprintf("SELECT MIN(timestamp), MAX(timestamp), COUNT(*) FROM %s %s", tableName, coveringIndexHint(tableName))
+	if err := queryRow(klineConn(symbol), query).Scan(&first, &last, &count); err != nil {
+		utils.LogError("查询表 %s 时间范围失败: %v", tableName, err)
+		return 0, 0, 0, err
+	}
+
+	if count == 0 {
+		return 0, 0, 0, nil
+	}
+	return first.Time.Unix() * 1000, last.Time.Unix() * 1000, count, nil
+}
+
+// KlineAggregate 一段时间内K线的聚合值，价格和成交量保持数据库中的十进制字符串
+type KlineAggregate struct {
+	Count     int64
+	FirstTime int64 // 区间内第一根K线的开盘时间（UTC毫秒）
+	LastTime  int64 // 区间内最后一根K线的开盘时间（UTC毫秒）
+	High      string
+	Low       string
+	AvgClose  string
+	Volume    string
+}
+
+// GetKlineAggregate 在只读连接上聚合[startTime, endTime]区间内的K线，startTime/endTime为0时不限制该端
+func GetKlineAggregate(symbol, interval string, startTime, endTime int64) (*KlineAggregate, error) {
+	tableName := GetTableName(symbol, interval)
+
+	var conditions []string
+	var args []interface{}
+	if startTime > 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"))
+	}
+	if endTime > 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05"))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+	SELECT COUNT(*), MIN(timestamp), MAX(timestamp),
+		MAX(high_price), MIN(low_price), ROUND(AVG(close_price), 8), SUM(volume)
+	FROM %s %s
+	%s
+	`, tableName, coveringIndexHint(tableName), where)
+
+	var agg KlineAggregate
+	var first, last sql.NullTime
+	var high, low, avgClose, volume sql.NullString
+	if err := queryRow(klineReadConn(symbol), query, args...).Scan(&agg.Count, &first, &last, &high, &low, &avgClose, &volume); err != nil {
+		utils.LogError("聚合表 %s 数据失败: %v", tableName, err)
+		return nil, err
+	}
+
+	if agg.Count == 0 {
+		return &agg, nil
+	}
+	agg.FirstTime = utils.StoredTimestampToUTC(first.Time.UnixMilli())
+	agg.LastTime = utils.StoredTimestampToUTC(last.Time.UnixMilli())
+	agg.High = high.String
+	agg.Low = low.String
+	agg.AvgClose = avgClose.String
+	agg.Volume = volume.String
+	return &agg, nil
+}
+
+// GetKlineTimestamps 按升序获取[startTime, endTime]区间内所有K线的开盘时间（UTC毫秒），最多limit条
+func GetKlineTimestamps(symbol, interval string, startTime, endTime int64, limit int) ([]int64, error) {
+	tableName := GetTableName(symbol, interval)
+
+	rows, err := queryRows(klineConn(symbol), fmt.Sprintf(`
+	SELECT timestamp FROM %s %s
+	WHERE timestamp >= ? AND timestamp <= ?
+	ORDER BY timestamp
+	LIMIT ?
+	`, tableName, coveringIndexHint(tableName)),
+		utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"),
+		utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05"),
+		limit)
+	if err != nil {
+		utils.LogError("查询表 %s 开盘时间失败: %v", tableName, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []int64
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
 		}
+		result = append(result, utils.StoredTimestampToUTC(ts.UnixMilli()))
+	}
+	return result, rows.Err()
+}
 
-		result = append(result, data)
+// ensureColumn 检查表中是否存在指定列，不存在时追加，用于升级已有的表结构
+func ensureColumn(tableName, column, definition string) error {
+	var count int
+	err := queryRow(DB, `
+	SELECT COUNT(*) FROM information_schema.COLUMNS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, tableName, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
 	}
 
-	return result, nil
+	if _, err := execSchema(DB, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, column, definition)); err != nil {
+		utils.LogError("为表 %s 添加列 %s 失败: %v", tableName, column, err)
+		return err
+	}
+	utils.LogInfo("已为表 %s 添加列 %s", tableName, column)
+	return nil
 }
 
-// GetTableName 获取表名
-func GetTableName(symbol, interval string) string {
-	// 统一转换为小写并移除特殊字符
-	symbol = strings.ToLower(symbol)
-	interval = strings.ToLower(interval)
+// tableExists 检查数据库conn中的表是否存在
+func tableExists(conn sqlConn, tableName string) (bool, error) {
+	var count int
+	err := queryRow(conn, `
+	SELECT COUNT(*) FROM information_schema.TABLES
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, tableName).Scan(&count)
+	return count > 0, err
+}
 
-	return fmt.Sprintf("%s_%s", symbol, interval)
+// KlineTableExists 检查交易对和时间间隔的K线表是否存在
+func KlineTableExists(symbol, interval string) (bool, error) {
+	return tableExists(klineConn(symbol), GetTableName(symbol, interval))
 }
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 物化数据集时每条INSERT写入的行数
+const datasetInsertRows = 1000
+
+// ErrDatasetNotFound 数据集不存在
+var ErrDatasetNotFound = errors.New("数据集不存在")
+
+// ErrDatasetExists 数据集名称已被使用
+var ErrDatasetExists = errors.New("数据集名称已存在")
+
+// ErrDatasetEmpty 区间内没有K线
+var ErrDatasetEmpty = errors.New("区间内没有K线")
+
+// ErrDatasetTooLarge 区间内K线超过数据集的行数上限
+var ErrDatasetTooLarge = errors.New("区间内的K线超过数据集的行数上限")
+
+// Dataset 一个命名的、创建后不再变化的K线数据集，K线复制到数据集行表中，之后修复或修改K线表不影响数据集
+type Dataset struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Interval    string `json:"interval"`
+	StartTime   int64  `json:"start_time"` // 第一根K线的开盘时间（UTC毫秒）
+	EndTime     int64  `json:"end_time"`   // 最后一根K线的开盘时间（UTC毫秒）
+	Rows        int    `json:"rows"`
+	Checksum    string `json:"checksum"` // 全部行的SHA-256，见DatasetChecksum
+	Status      string `json:"status"`   // building、ready
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// CreateDatasetTablesIfNotExists 创建数据集表和数据集行表
+func CreateDatasetTablesIfNotExists() error {
+	datasets := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		name VARCHAR(128) NOT NULL,
+		symbol VARCHAR(32) NOT NULL,
+		kline_interval VARCHAR(8) NOT NULL,
+		start_time BIGINT NOT NULL DEFAULT 0 COMMENT 'UTC毫秒',
+		end_time BIGINT NOT NULL DEFAULT 0 COMMENT 'UTC毫秒',
+		row_count INT NOT NULL DEFAULT 0,
+		checksum CHAR(64) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL,
+		description TEXT,
+		created_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		UNIQUE KEY uk_name (name)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("datasets"))
+	if _, err := execSchema(DB, datasets); err != nil {
+		utils.LogError("创建表 datasets 失败: %v", err)
+		return err
+	}
+
+	// 开盘时间直接保存UTC毫秒，不受时区配置影响
+	rows := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		dataset_id BIGINT NOT NULL,
+		open_time BIGINT NOT NULL COMMENT 'UTC毫秒',
+		open_price DECIMAL(30,8) NOT NULL,
+		high_price DECIMAL(30,8) NOT NULL,
+		low_price DECIMAL(30,8) NOT NULL,
+		close_price DECIMAL(30,8) NOT NULL,
+		volume DECIMAL(30,8) NOT NULL,
+		PRIMARY KEY (dataset_id, open_time)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("dataset_rows"))
+	if _, err := execSchema(DB, rows); err != nil {
+		utils.LogError("创建表 dataset_rows 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// datasetHasher 按固定格式逐行计算数据集的校验和
+type datasetHasher struct {
+	h hash.Hash
+}
+
+// newDatasetHasher 创建校验和计算器
+func newDatasetHasher() *datasetHasher {
+	return &datasetHasher{h: sha256.New()}
+}
+
+// add 写入一行，openTime为UTC毫秒，价格和成交量为数据库中DECIMAL(30,8)的文本形式
+func (d *datasetHasher) add(k Candle) {
+	io.WriteString(d.h, fmt.Sprintf("%d,%s,%s,%s,%s,%s\n", k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume))
+}
+
+// sum 返回十六进制的校验和
+func (d *datasetHasher) sum() string {
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// CreateDataset 把K线表中[startTime, endTime]区间（UTC毫秒）的K线复制为数据集，最多maxRows行；
+// 先以building状态登记名称，复制完成后写入行数和校验和并标记为ready，失败时删除已写入的部分
+func CreateDataset(ctx context.Context, d *Dataset, startTime, endTime int64, maxRows int) (*Dataset, error) {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	result, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (name, symbol, kline_interval, status, description, created_at)
+	VALUES (?, ?, ?, 'building', ?, ?)
+	`, prefixTable("datasets")), d.Name, d.Symbol, d.Interval, d.Description, now)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return nil, ErrDatasetExists
+		}
+		utils.LogError("登记数据集 %s 失败: %v", d.Name, err)
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fillDataset(ctx, id, d.Symbol, d.Interval, startTime, endTime, maxRows); err != nil {
+		if _, derr := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE dataset_id = ?", prefixTable("dataset_rows")), id); derr != nil {
+			utils.LogError("清理数据集 %d 的行失败: %v", id, derr)
+		}
+		if _, derr := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE id = ?", prefixTable("datasets")), id); derr != nil {
+			utils.LogError("清理数据集 %d 失败: %v", id, derr)
+		}
+		return nil, err
+	}
+	return GetDataset(id)
+}
+
+// fillDataset 流式读取K线，分批写入数据集行表并计算校验和，最后把数据集标记为ready
+func fillDataset(ctx context.Context, id int64, symbol, interval string, startTime, endTime int64, maxRows int) error {
+	hasher := newDatasetHasher()
+	var batch []Candle
+	var first, last int64
+	count := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		placeholders := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*7)
+		for i, k := range batch {
+			placeholders[i] = "(?, ?, ?, ?, ?, ?, ?)"
+			args = append(args, id, k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume)
+		}
+		_, err := execQuery(DB, fmt.Sprintf(`
+		INSERT INTO %s (dataset_id, open_time, open_price, high_price, low_price, close_price, volume)
+		VALUES `, prefixTable("dataset_rows"))+strings.Join(placeholders, ", "), args...)
+		batch = batch[:0]
+		return err
+	}
+
+	err := StreamKlineRange(ctx, symbol, interval, startTime, endTime, maxRows+1, func(k Candle) error {
+		count++
+		if count > maxRows {
+			return ErrDatasetTooLarge
+		}
+		k.OpenTime = utils.StoredTimestampToUTC(k.OpenTime)
+		if first == 0 {
+			first = k.OpenTime
+		}
+		last = k.OpenTime
+		hasher.add(k)
+		batch = append(batch, k)
+		if len(batch) >= datasetInsertRows {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if err != ErrDatasetTooLarge {
+			utils.LogError("复制数据集 %d 失败: %v", id, err)
+		}
+		return err
+	}
+	if count == 0 {
+		return ErrDatasetEmpty
+	}
+
+	_, err = execQuery(DB, fmt.Sprintf(`
+	UPDATE %s SET start_time = ?, end_time = ?, row_count = ?, checksum = ?, status = 'ready' WHERE id = ?
+	`, prefixTable("datasets")), first, last, count, hasher.sum(), id)
+	if err != nil {
+		utils.LogError("更新数据集 %d 失败: %v", id, err)
+	}
+	return err
+}
+
+// isDuplicateKey 是否为唯一键冲突
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry
+}
+
+// DeleteDataset 删除数据集及其全部行
+func DeleteDataset(id int64) error {
+	result, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE id = ?", prefixTable("datasets")), id)
+	if err != nil {
+		utils.LogError("删除数据集 %d 失败: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDatasetNotFound
+	}
+	if _, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE dataset_id = ?", prefixTable("dataset_rows")), id); err != nil {
+		utils.LogError("删除数据集 %d 的行失败: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// GetDataset 按ID查询数据集
+func GetDataset(id int64) (*Dataset, error) {
+	datasets, err := queryDatasets("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasets) == 0 {
+		return nil, ErrDatasetNotFound
+	}
+	return &datasets[0], nil
+}
+
+// ListDatasets 按ID倒序查询数据集，symbol为空时不过滤
+func ListDatasets(symbol string, limit int) ([]Dataset, error) {
+	if symbol != "" {
+		return queryDatasets("WHERE symbol = ? ORDER BY id DESC LIMIT ?", symbol, limit)
+	}
+	return queryDatasets("ORDER BY id DESC LIMIT ?", limit)
+}
+
+// queryDatasets 按条件查询数据集
+func queryDatasets(where string, args ...interface{}) ([]Dataset, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT id, name, symbol, kline_interval, start_time, end_time, row_count, checksum, status, IFNULL(description, ''), created_at
+	FROM %s %s
+	`, prefixTable("datasets"), where), args...)
+	if err != nil {
+		utils.LogError("查询数据集失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Dataset
+	for rows.Next() {
+		var d Dataset
+		var createdAt time.Time
+		if err := rows.Scan(&d.ID, &d.Name, &d.Symbol, &d.Interval, &d.StartTime, &d.EndTime, &d.Rows,
+			&d.Checksum, &d.Status, &d.Description, &createdAt); err != nil {
+			utils.LogError("扫描数据集失败: %v", err)
+			return nil, err
+		}
+		d.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+// QueryDatasetRange 按时间升序读取数据集中[startTime, endTime]区间（UTC毫秒）的K线，最多limit条，
+// startTime/endTime为0时不限制该端；返回的OpenTime为UTC毫秒
+func QueryDatasetRange(id int64, startTime, endTime int64, limit int) ([]Candle, error) {
+	where := "WHERE dataset_id = ?"
+	args := []interface{}{id}
+	if startTime > 0 {
+		where += " AND open_time >= ?"
+		args = append(args, startTime)
+	}
+	if endTime > 0 {
+		where += " AND open_time <= ?"
+		args = append(args, endTime)
+	}
+	args = append(args, limit)
+	return queryDatasetRows(where+" ORDER BY open_time LIMIT ?", args...)
+}
+
+// QueryDatasetBefore 按时间倒序读取数据集中开盘时间早于before（UTC毫秒）的最多limit根K线
+func QueryDatasetBefore(id int64, before int64, limit int) ([]Candle, error) {
+	return queryDatasetRows("WHERE dataset_id = ? AND open_time < ? ORDER BY open_time DESC LIMIT ?", id, before, limit)
+}
+
+// queryDatasetRows 按条件读取数据集行
+func queryDatasetRows(where string, args ...interface{}) ([]Candle, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT open_time, open_price, high_price, low_price, close_price, volume
+	FROM %s %s
+	`, prefixTable("dataset_rows"), where), args...)
+	if err != nil {
+		utils.LogError("查询数据集行失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Candle
+	for rows.Next() {
+		k := Candle{Closed: true}
+		if err := rows.Scan(&k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume); err != nil {
+			utils.LogError("扫描数据集行失败: %v", err)
+			return nil, err
+		}
+		result = append(result, k)
+	}
+	return result, rows.Err()
+}
+
+// DatasetChecksum 按开盘时间顺序重新计算数据集全部行的校验和，用于确认数据集未被改动
+func DatasetChecksum(ctx context.Context, id int64) (string, int, error) {
+	hasher := newDatasetHasher()
+	count := 0
+	var after int64 = -1
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", 0, err
+		}
+		chunk, err := queryDatasetRows("WHERE dataset_id = ? AND open_time > ? ORDER BY open_time LIMIT ?", id, after, streamChunkRows)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, k := range chunk {
+			hasher.add(k)
+		}
+		count += len(chunk)
+		if len(chunk) < streamChunkRows {
+			return hasher.sum(), count, nil
+		}
+		after = chunk[len(chunk)-1].OpenTime
+	}
+}
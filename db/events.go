@@ -0,0 +1,177 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrEventNotFound 市场事件不存在
+var ErrEventNotFound = errors.New("事件不存在")
+
+// MarketEvent 带时间点的市场事件，如暂停交易、硬分叉、宏观数据发布，Symbol为空表示对所有交易对生效
+type MarketEvent struct {
+	ID          int64  `json:"id"`
+	Symbol      string `json:"symbol"`
+	Category    string `json:"category"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Timestamp   int64  `json:"timestamp"` // 事件发生时间（UTC毫秒）
+	Datetime    string `json:"datetime"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// CreateEventsTableIfNotExists 创建市场事件表
+func CreateEventsTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		symbol VARCHAR(32) NOT NULL DEFAULT '' COMMENT '为空表示对所有交易对生效',
+		category VARCHAR(32) NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		description TEXT,
+		event_time DATETIME NOT NULL COMMENT '上海时间',
+		created_at DATETIME NOT NULL COMMENT '上海时间',
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		KEY idx_time (event_time),
+		KEY idx_symbol_time (symbol, event_time)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("market_events"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 market_events 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CreateEvent 新增市场事件，返回事件ID
+func CreateEvent(e *MarketEvent) (int64, error) {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	result, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (symbol, category, title, description, event_time, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, prefixTable("market_events")), e.Symbol, e.Category, e.Title, e.Description,
+		utils.TimestampToShanghai(e.Timestamp).Format("2006-01-02 15:04:05"), now, now)
+	if err != nil {
+		utils.LogError("新增市场事件失败: %v", err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateEvent 修改市场事件
+func UpdateEvent(e *MarketEvent) error {
+	if _, err := GetEvent(e.ID); err != nil {
+		return err
+	}
+
+	_, err := execQuery(DB, fmt.Sprintf(`
+	UPDATE %s
+	SET symbol = ?, category = ?, title = ?, description = ?, event_time = ?, updated_at = ?
+	WHERE id = ?
+	`, prefixTable("market_events")), e.Symbol, e.Category, e.Title, e.Description,
+		utils.TimestampToShanghai(e.Timestamp).Format("2006-01-02 15:04:05"),
+		utils.GetShanghaiNow().Format("2006-01-02 15:04:05"), e.ID)
+	if err != nil {
+		utils.LogError("修改市场事件 %d 失败: %v", e.ID, err)
+	}
+	return err
+}
+
+// DeleteEvent 删除市场事件
+func DeleteEvent(id int64) error {
+	result, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE id = ?", prefixTable("market_events")), id)
+	if err != nil {
+		utils.LogError("删除市场事件 %d 失败: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+// GetEvent 按ID查询市场事件
+func GetEvent(id int64) (*MarketEvent, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT id, symbol, category, title, IFNULL(description, ''), event_time, created_at, updated_at
+	FROM %s WHERE id = ?
+	`, prefixTable("market_events")), id)
+	if err != nil {
+		utils.LogError("查询市场事件 %d 失败: %v", id, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrEventNotFound
+	}
+	return &events[0], nil
+}
+
+// ListEvents 按事件时间升序查询[startTime, endTime]区间内的事件（UTC毫秒，为0时不限制该端）
+// symbol不为空时返回该交易对及对所有交易对生效的事件，category为空时不过滤
+func ListEvents(symbol string, startTime, endTime int64, category string, limit int) ([]MarketEvent, error) {
+	query := fmt.Sprintf(`
+	SELECT id, symbol, category, title, IFNULL(description, ''), event_time, created_at, updated_at
+	FROM %s
+	WHERE 1 = 1
+	`, prefixTable("market_events"))
+	var args []interface{}
+	if symbol != "" {
+		query += " AND symbol IN (?, '')"
+		args = append(args, symbol)
+	}
+	if category != "" {
+		query += " AND category = ?"
+		args = append(args, category)
+	}
+	if startTime > 0 {
+		query += " AND event_time >= ?"
+		args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"))
+	}
+	if endTime > 0 {
+		query += " AND event_time <= ?"
+		args = append(args, utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05"))
+	}
+	query += " ORDER BY event_time, id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := queryRows(DB, query, args...)
+	if err != nil {
+		utils.LogError("查询市场事件失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// scanEvents 读取市场事件查询结果
+func scanEvents(rows *timedRows) ([]MarketEvent, error) {
+	var result []MarketEvent
+	for rows.Next() {
+		var e MarketEvent
+		var eventTime, createdAt, updatedAt time.Time
+		if err := rows.Scan(&e.ID, &e.Symbol, &e.Category, &e.Title, &e.Description,
+			&eventTime, &createdAt, &updatedAt); err != nil {
+			utils.LogError("扫描市场事件失败: %v", err)
+			return nil, err
+		}
+		e.Timestamp = utils.StoredTimestampToUTC(eventTime.UnixMilli())
+		e.Datetime = eventTime.Format("2006-01-02 15:04:05")
+		e.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		e.UpdatedAt = updatedAt.Format("2006-01-02 15:04:05")
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
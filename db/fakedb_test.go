@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlMaxPlaceholders MySQL单条预处理语句允许的最大参数个数
+const mysqlMaxPlaceholders = 65535
+
+// fakeStmt 模拟连接收到的一条语句
+type fakeStmt struct {
+	Query string
+	Args  []driver.Value
+}
+
+// fakeResult 模拟查询返回的结果集
+type fakeResult struct {
+	Columns []string
+	Rows    [][]driver.Value
+}
+
+// fakeDB 记录执行语句并按测试设定返回结果的database/sql驱动，用于在没有MySQL的环境下测试本包
+type fakeDB struct {
+	mu    sync.Mutex
+	execs []fakeStmt
+
+	// OnExec 返回非nil错误时该语句执行失败
+	OnExec func(query string, args []driver.Value) error
+	// OnQuery 返回查询的结果集
+	OnQuery func(query string, args []driver.Value) (*fakeResult, error)
+}
+
+// openFakeDB 创建模拟连接，测试结束后关闭
+func openFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{}
+	conn := sql.OpenDB(f)
+	t.Cleanup(func() { conn.Close() })
+	return f, conn
+}
+
+// useFakeDB 把全局连接替换为模拟连接，测试结束后恢复
+func useFakeDB(t *testing.T) *fakeDB {
+	t.Helper()
+	f, conn := openFakeDB(t)
+	prevDB := DB
+	DB = conn
+	t.Cleanup(func() { DB = prevDB })
+	return f
+}
+
+// Execs 返回已执行的写入语句
+func (f *fakeDB) Execs() []fakeStmt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeStmt(nil), f.execs...)
+}
+
+// ExecsMatching 返回包含指定片段的写入语句
+func (f *fakeDB) ExecsMatching(fragment string) []fakeStmt {
+	var matched []fakeStmt
+	for _, stmt := range f.Execs() {
+		if strings.Contains(stmt.Query, fragment) {
+			matched = append(matched, stmt)
+		}
+	}
+	return matched
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDriver只能通过sql.OpenDB使用")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakePrepared{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	args := namedValues(named)
+	if strings.Count(query, "?") > mysqlMaxPlaceholders {
+		return nil, &mysql.MySQLError{Number: 1390, Message: "Prepared statement contains too many placeholders"}
+	}
+	if c.db.OnExec != nil {
+		if err := c.db.OnExec(query, args); err != nil {
+			return nil, err
+		}
+	}
+	c.db.mu.Lock()
+	c.db.execs = append(c.db.execs, fakeStmt{Query: query, Args: args})
+	c.db.mu.Unlock()
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	result := &fakeResult{}
+	if c.db.OnQuery != nil {
+		r, err := c.db.OnQuery(query, namedValues(named))
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			result = r
+		}
+	}
+	return &fakeRows{result: result}, nil
+}
+
+// fakePrepared 预处理语句，每次执行按普通语句记录
+type fakePrepared struct {
+	conn  *fakeConn
+	query string
+}
+
+func (p *fakePrepared) Close() error  { return nil }
+func (p *fakePrepared) NumInput() int { return -1 }
+
+func (p *fakePrepared) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("fakePrepared只支持ExecContext")
+}
+
+func (p *fakePrepared) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("fakePrepared只支持QueryContext")
+}
+
+func (p *fakePrepared) ExecContext(ctx context.Context, named []driver.NamedValue) (driver.Result, error) {
+	return p.conn.ExecContext(ctx, p.query, named)
+}
+
+func (p *fakePrepared) QueryContext(ctx context.Context, named []driver.NamedValue) (driver.Rows, error) {
+	return p.conn.QueryContext(ctx, p.query, named)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.Columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
+
+func namedValues(named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+	return args
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// klineIndex K线表应有的二级索引
+type klineIndex struct {
+	Name    string
+	Columns []string
+}
+
+// 主键(timestamp)已能满足“时间范围 + 按时间倒序 + LIMIT”的查询；覆盖索引不含note，
+// 聚合、计数、缺口检查等只用价格和成交量的查询只扫描索引，不读取整行
+var klineIndexes = []klineIndex{
+	{Name: "idx_time_ohlcv", Columns: []string{"timestamp", "open_price", "high_price", "low_price", "close_price", "volume"}},
+}
+
+var (
+	ensureIndexes bool // 启动时为已有的K线表补建缺少的索引
+
+	// 已确认建有覆盖索引的表，查询时才加索引提示
+	indexedTables   = make(map[string]bool)
+	indexedTablesMu sync.Mutex
+)
+
+// IndexReport 一个K线表的索引检查结果
+type IndexReport struct {
+	Symbol   string      `json:"symbol"`
+	Interval string      `json:"interval"`
+	Table    string      `json:"table"`
+	Missing  []string    `json:"missing"` // 缺少的索引
+	Plans    []QueryPlan `json:"plans"`
+	OK       bool        `json:"ok"` // 索引齐全且示例查询的执行计划符合预期
+	Error    string      `json:"error,omitempty"`
+}
+
+// QueryPlan 一条示例查询的EXPLAIN结果
+type QueryPlan struct {
+	Name     string `json:"name"`
+	Query    string `json:"query"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Rows     int64  `json:"rows"`
+	Extra    string `json:"extra"`
+	Expected string `json:"expected"` // 预期的执行方式
+	OK       bool   `json:"ok"`
+}
+
+// klineIndexDefs 建表语句中的索引定义
+func klineIndexDefs() string {
+	var defs []string
+	for _, index := range klineIndexes {
+		defs = append(defs, fmt.Sprintf("KEY %s (%s)", index.Name, strings.Join(index.Columns, ", ")))
+	}
+	return strings.Join(defs, ",\n\t\t")
+}
+
+// tableIndexes 查询表的索引及其按顺序排列的列
+func tableIndexes(conn sqlConn, tableName string) (map[string][]string, error) {
+	rows, err := queryRows(conn, `
+	SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	ORDER BY INDEX_NAME, SEQ_IN_INDEX
+	`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]string)
+	for rows.Next() {
+		var name, column string
+		if err := rows.Scan(&name, &column); err != nil {
+			return nil, err
+		}
+		result[name] = append(result[name], column)
+	}
+	return result, rows.Err()
+}
+
+// missingKlineIndexes 表中缺少的索引，同名但列不一致的也视为缺少
+func missingKlineIndexes(existing map[string][]string) []klineIndex {
+	var missing []klineIndex
+	for _, index := range klineIndexes {
+		if strings.Join(existing[index.Name], ",") != strings.Join(index.Columns, ",") {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// ensureKlineIndexes 检查K线表的索引，启用DB_ENSURE_INDEXES时在线补建缺少的索引
+func ensureKlineIndexes(conn *sql.DB, tableName string) error {
+	existing, err := tableIndexes(conn, tableName)
+	if err != nil {
+		return err
+	}
+	missing := missingKlineIndexes(existing)
+	if len(missing) > 0 && !ensureIndexes {
+		utils.LogWarning("表 %s 缺少索引 %s，可通过 GET /api/v1/admin/indexes 查看", tableName, missing[0].Name)
+		return nil
+	}
+
+	for _, index := range missing {
+		start := time.Now()
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s), ALGORITHM=INPLACE, LOCK=NONE",
+			tableName, index.Name, strings.Join(index.Columns, ", "))
+		if _, ok := existing[index.Name]; ok {
+			stmt = fmt.Sprintf("ALTER TABLE %s DROP INDEX %s, ADD INDEX %s (%s), ALGORITHM=INPLACE, LOCK=NONE",
+				tableName, index.Name, index.Name, strings.Join(index.Columns, ", "))
+		}
+		if _, err := execSchema(conn, stmt); err != nil {
+			utils.LogError("为表 %s 添加索引 %s 失败: %v", tableName, index.Name, err)
+			return err
+		}
+		utils.LogInfo("已为表 %s 添加索引 %s，耗时 %v", tableName, index.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	indexedTablesMu.Lock()
+	indexedTables[tableName] = true
+	indexedTablesMu.Unlock()
+	return nil
+}
+
+// coveringIndexHint 表已建有覆盖索引时返回索引提示，用于不读取note的范围查询
+func coveringIndexHint(tableName string) string {
+	indexedTablesMu.Lock()
+	defer indexedTablesMu.Unlock()
+	if indexedTables[tableName] {
+		return fmt.Sprintf("USE INDEX (%s)", klineIndexes[0].Name)
+	}
+	return ""
+}
+
+// CheckKlineIndexes 检查K线表的索引，并对最近30天的“范围 + 倒序 + LIMIT”查询和聚合查询执行EXPLAIN
+func CheckKlineIndexes(symbol, interval string) IndexReport {
+	tableName := GetTableName(symbol, interval)
+	report := IndexReport{Symbol: symbol, Interval: interval, Table: tableName, Missing: []string{}, Plans: []QueryPlan{}}
+	conn := klineConn(symbol)
+
+	existing, err := tableIndexes(conn, tableName)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	for _, index := range missingKlineIndexes(existing) {
+		report.Missing = append(report.Missing, index.Name)
+	}
+	hint := ""
+	if len(report.Missing) == 0 {
+		hint = fmt.Sprintf("USE INDEX (%s)", klineIndexes[0].Name)
+	}
+
+	now := utils.GetShanghaiNow()
+	start := now.AddDate(0, 0, -30).Format("2006-01-02 15:04:05")
+	end := now.Format("2006-01-02 15:04:05")
+	samples := []struct {
+		name, query, expected string
+		check                 func(plan *QueryPlan) bool
+	}{
+		{
+			name: "range_desc_limit",
+			query: fmt.Sprintf(`SELECT timestamp, open_price, close_price, high_price, low_price, volume, note FROM %s `+
+				`WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp DESC LIMIT 100`, tableName),
+			expected: "按主键范围扫描，无 filesort",
+			check: func(plan *QueryPlan) bool {
+				return plan.Key == "PRIMARY" && !strings.Contains(plan.Extra, "filesort")
+			},
+		},
+		{
+			name: "range_aggregate",
+			query: fmt.Sprintf(`SELECT COUNT(*), MAX(high_price), MIN(low_price), SUM(volume) FROM %s %s `+
+				`WHERE timestamp >= ? AND timestamp <= ?`, tableName, hint),
+			expected: "只扫描覆盖索引 " + klineIndexes[0].Name + "（Using index）",
+			check: func(plan *QueryPlan) bool {
+				return plan.Key == klineIndexes[0].Name && strings.Contains(plan.Extra, "Using index")
+			},
+		},
+	}
+
+	report.OK = len(report.Missing) == 0
+	for _, sample := range samples {
+		plan, err := explainQuery(conn, sample.query, start, end)
+		if err != nil {
+			report.Error = err.Error()
+			report.OK = false
+			return report
+		}
+		plan.Name = sample.name
+		plan.Query = sample.query
+		plan.Expected = sample.expected
+		plan.OK = sample.check(plan)
+		if !plan.OK {
+			report.OK = false
+		}
+		report.Plans = append(report.Plans, *plan)
+	}
+	return report
+}
+
+// explainQuery 执行EXPLAIN并取第一行的访问方式，各MySQL版本输出的列不同，按列名读取
+func explainQuery(conn *sql.DB, query string, args ...interface{}) (*QueryPlan, error) {
+	rows, err := queryRows(conn, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	plan := &QueryPlan{}
+	if !rows.Next() {
+		return plan, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	for i, column := range columns {
+		switch strings.ToLower(column) {
+		case "type":
+			plan.Type = values[i].String
+		case "key":
+			plan.Key = values[i].String
+		case "rows":
+			plan.Rows, _ = strconv.ParseInt(values[i].String, 10, 64)
+		case "extra":
+			plan.Extra = values[i].String
+		}
+	}
+	return plan, rows.Err()
+}
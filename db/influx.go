@@ -0,0 +1,88 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// influxSink 通过HTTP写入接口以行协议写入InfluxDB，兼容1.x（/write）和2.x（/api/v2/write）
+type influxSink struct {
+	writeURL    string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+// newInfluxSink 创建InfluxDB输出，配置了组织时使用2.x接口，否则使用1.x接口
+func newInfluxSink(cfg *config.SinkConfig) *influxSink {
+	base := strings.TrimRight(cfg.InfluxURL, "/")
+
+	var writeURL string
+	if cfg.InfluxOrg != "" {
+		writeURL = fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ms",
+			base, url.QueryEscape(cfg.InfluxOrg), url.QueryEscape(cfg.InfluxBucket))
+	} else {
+		writeURL = fmt.Sprintf("%s/write?db=%s&precision=ms", base, url.QueryEscape(cfg.InfluxBucket))
+	}
+
+	return &influxSink{
+		writeURL:    writeURL,
+		token:       cfg.InfluxToken,
+		measurement: cfg.InfluxMeasurement,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name 输出名称
+func (s *influxSink) Name() string {
+	return "influxdb"
+}
+
+// WriteKlines 以行协议批量写入，相同标签和时间戳的点会被覆盖，写入是幂等的
+func (s *influxSink) WriteKlines(records []KlineRecord) error {
+	var buf bytes.Buffer
+	for _, k := range records {
+		// kline,symbol=BTCUSDT,interval=5m open=...,high=...,low=...,close=...,volume=... 1700000000000
+		fmt.Fprintf(&buf, "%s,symbol=%s,interval=%s open=%s,high=%s,low=%s,close=%s,volume=%s %d\n",
+			s.measurement, k.Symbol, k.Interval, k.Open, k.High, k.Low, k.Close, k.Volume, k.Timestamp)
+	}
+
+	return s.write(&buf)
+}
+
+// write 把行协议数据提交到写入接口
+func (s *influxSink) write(body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, s.writeURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB返回状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close 关闭输出
+func (s *influxSink) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// leaderConn 持有主节点锁的专用连接，MySQL的GET_LOCK与会话绑定，连接断开即自动释放
+var leaderConn *sql.Conn
+
+// TryAcquireLeaderLock 尝试获取主节点锁，成功返回true
+func TryAcquireLeaderLock(name string) (bool, error) {
+	if leaderConn != nil {
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var result sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", name).Scan(&result); err != nil {
+		conn.Close()
+		return false, err
+	}
+
+	if !result.Valid || result.Int64 != 1 {
+		conn.Close()
+		return false, nil
+	}
+
+	leaderConn = conn
+	return true, nil
+}
+
+// CheckLeaderLock 确认当前连接仍持有主节点锁
+func CheckLeaderLock(name string) error {
+	if leaderConn == nil {
+		return errors.New("未持有主节点锁")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var owned sql.NullInt64
+	err := leaderConn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", name).Scan(&owned)
+	if err != nil {
+		leaderConn.Close()
+		leaderConn = nil
+		return err
+	}
+
+	if !owned.Valid || owned.Int64 != 1 {
+		leaderConn.Close()
+		leaderConn = nil
+		return errors.New("主节点锁已被其他实例持有")
+	}
+	return nil
+}
+
+// ReleaseLeaderLock 释放主节点锁
+func ReleaseLeaderLock(name string) {
+	if leaderConn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leaderConn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	leaderConn.Close()
+	leaderConn = nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore 保存在内存中的 UpdateStore 实现，不需要MySQL即可运行完整的K线更新流程，用于集成测试
+type MemoryStore struct {
+	mu     sync.Mutex
+	tables map[SeriesKey]map[int64]KlineRecord // 按开盘时间去重，重复写入同一根K线时覆盖，与MySQL的upsert一致
+	states map[SeriesKey]*SyncState
+
+	// SaveErr 不为nil时 SaveKlines 返回该错误且不写入，用于模拟数据库故障
+	SaveErr error
+}
+
+// NewMemoryStore 创建空的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tables: make(map[SeriesKey]map[int64]KlineRecord),
+		states: make(map[SeriesKey]*SyncState),
+	}
+}
+
+// memKey 交易对不区分大小写
+func memKey(symbol, interval string) SeriesKey {
+	return SeriesKey{Symbol: strings.ToUpper(symbol), Interval: interval}
+}
+
+func (m *MemoryStore) CreateTable(symbol, interval string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memKey(symbol, interval)
+	if m.tables[key] == nil {
+		m.tables[key] = make(map[int64]KlineRecord)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	key := memKey(symbol, interval)
+	if m.tables[key] == nil {
+		m.tables[key] = make(map[int64]KlineRecord)
+	}
+	for _, k := range records {
+		m.tables[key][k.Timestamp] = k
+	}
+	return nil
+}
+
+// LastKlineTimestamp 与从MySQL读出的口径一致：表中保存上海时间，按UTC解析后的时间戳
+func (m *MemoryStore) LastKlineTimestamp(symbol, interval string) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last int64
+	found := false
+	for ts := range m.tables[memKey(symbol, interval)] {
+		if !found || ts > last {
+			last = ts
+			found = true
+		}
+	}
+	if !found {
+		return 0, false, nil
+	}
+	return utcToStoredTimestamp(last), true, nil
+}
+
+func (m *MemoryStore) GetSyncState(symbol, interval string) (*SyncState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[memKey(symbol, interval)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (m *MemoryStore) AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memKey(symbol, interval)
+	state, ok := m.states[key]
+	if !ok {
+		m.states[key] = &SyncState{Symbol: symbol, Interval: interval, Watermark: watermark}
+		return nil
+	}
+	if watermark > state.Watermark {
+		state.Watermark = watermark
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkSyncSuccess(symbol, interval string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 与MySQL相同，还没有水位时不记录
+	if state, ok := m.states[memKey(symbol, interval)]; ok {
+		state.LastSuccess = time.Now()
+	}
+	return nil
+}
+
+// Klines 按时间戳升序返回已写入的K线，Timestamp为UTC毫秒
+func (m *MemoryStore) Klines(symbol, interval string) []KlineRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	table := m.tables[memKey(symbol, interval)]
+	result := make([]KlineRecord, 0, len(table))
+	for _, k := range table {
+		result = append(result, k)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	return result
+}
+
+// Series 列出已创建表的K线序列
+func (m *MemoryStore) Series() []SeriesKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]SeriesKey, 0, len(m.tables))
+	for key := range m.tables {
+		result = append(result, key)
+	}
+	sortSeries(result)
+	return result
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrKlineNotFound 要标注的K线不存在
+var ErrKlineNotFound = errors.New("K线不存在")
+
+// NoteAudit 一次K线标注修改记录
+type NoteAudit struct {
+	ID        int64  `json:"id"`
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	Timestamp int64  `json:"timestamp"` // 与GetKlineData返回的OpenTime一致
+	Datetime  string `json:"datetime"`
+	OldNote   string `json:"old_note"`
+	NewNote   string `json:"new_note"`
+	Author    string `json:"author"`
+	ClientIP  string `json:"client_ip"`
+	RequestID string `json:"request_id"`
+	ChangedAt string `json:"changed_at"`
+}
+
+// CreateNoteAuditTableIfNotExists 创建K线标注修改记录表
+func CreateNoteAuditTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		symbol VARCHAR(32) NOT NULL,
+		kline_interval VARCHAR(8) NOT NULL,
+		kline_time DATETIME NOT NULL COMMENT '被标注K线的开盘时间（上海时间）',
+		old_note TEXT,
+		new_note TEXT,
+		author VARCHAR(64) NOT NULL,
+		client_ip VARCHAR(64) NOT NULL,
+		request_id VARCHAR(64) NOT NULL,
+		changed_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		KEY idx_series (symbol, kline_interval, kline_time)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("kline_note_audit"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 kline_note_audit 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SetKlineNote 修改一根K线的标注并记录修改人，timestamp与GetKlineData返回的OpenTime一致，返回修改前的标注；
+// K线表路由到其他数据库时修改记录仍写入主库，先写修改记录再提交标注
+func SetKlineNote(symbol, interval string, timestamp int64, note, author, clientIP, requestID string) (string, error) {
+	tableName := GetTableName(symbol, interval)
+	klineTime := time.UnixMilli(timestamp).UTC().Format("2006-01-02 15:04:05")
+
+	tx, err := klineConn(symbol).Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldNote sql.NullString
+	err = queryRow(tx, fmt.Sprintf("SELECT note FROM %s WHERE timestamp = ? FOR UPDATE", tableName), klineTime).Scan(&oldNote)
+	if err == sql.ErrNoRows {
+		return "", ErrKlineNotFound
+	}
+	if err != nil {
+		utils.LogError("查询表 %s 标注失败: %v", tableName, err)
+		return "", err
+	}
+
+	if _, err := execQuery(tx, fmt.Sprintf("UPDATE %s SET note = ? WHERE timestamp = ?", tableName), note, klineTime); err != nil {
+		utils.LogError("更新表 %s 标注失败: %v", tableName, err)
+		return "", err
+	}
+
+	var auditConn sqlConn = tx
+	if klineConn(symbol) != DB {
+		auditConn = DB
+	}
+	_, err = execQuery(auditConn, fmt.Sprintf(`
+	INSERT INTO %s (symbol, kline_interval, kline_time, old_note, new_note, author, client_ip, request_id, changed_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, prefixTable("kline_note_audit")), symbol, interval, klineTime, oldNote.String, note, author, clientIP, requestID,
+		utils.GetShanghaiNow().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		utils.LogError("写入标注修改记录失败: %v", err)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return oldNote.String, nil
+}
+
+// GetNoteAudits 按时间倒序查询标注修改记录，symbol/interval为空时不过滤
+func GetNoteAudits(symbol, interval string, limit int) ([]NoteAudit, error) {
+	query := fmt.Sprintf(`
+	SELECT id, symbol, kline_interval, kline_time, IFNULL(old_note, ''), IFNULL(new_note, ''), author, client_ip, request_id, changed_at
+	FROM %s
+	WHERE 1 = 1
+	`, prefixTable("kline_note_audit"))
+	var args []interface{}
+	if symbol != "" {
+		query += " AND symbol = ?"
+		args = append(args, symbol)
+	}
+	if interval != "" {
+		query += " AND kline_interval = ?"
+		args = append(args, interval)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := queryRows(DB, query, args...)
+	if err != nil {
+		utils.LogError("查询标注修改记录失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []NoteAudit
+	for rows.Next() {
+		var a NoteAudit
+		var klineTime, changedAt time.Time
+		if err := rows.Scan(&a.ID, &a.Symbol, &a.Interval, &klineTime, &a.OldNote, &a.NewNote,
+			&a.Author, &a.ClientIP, &a.RequestID, &changedAt); err != nil {
+			utils.LogError("扫描标注修改记录失败: %v", err)
+			return nil, err
+		}
+		a.Timestamp = klineTime.Unix() * 1000
+		a.Datetime = klineTime.Format("2006-01-02 15:04")
+		a.ChangedAt = changedAt.Format("2006-01-02 15:04:05")
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
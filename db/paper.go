@@ -0,0 +1,221 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrPaperPositionNotFound 模拟持仓不存在
+var ErrPaperPositionNotFound = errors.New("模拟持仓不存在")
+
+// ErrPaperPositionClosed 模拟持仓已平仓
+var ErrPaperPositionClosed = errors.New("模拟持仓已平仓")
+
+// PaperPosition 一笔模拟持仓，开仓后随对应交易对和时间间隔写入的K线按收盘价估值
+type PaperPosition struct {
+	ID         int64  `json:"id"`
+	Symbol     string `json:"symbol"`
+	Interval   string `json:"interval"` // 用于估值的K线时间间隔
+	Side       string `json:"side"`     // long、short
+	Quantity   string `json:"quantity"`
+	EntryPrice string `json:"entry_price"`
+	EntryTime  int64  `json:"entry_time"` // 开仓时间（UTC毫秒）
+	MarkPrice  string `json:"mark_price,omitempty"`
+	MarkTime   int64  `json:"mark_time,omitempty"` // 最近一次估值所用K线的开盘时间（UTC毫秒）
+	ExitPrice  string `json:"exit_price,omitempty"`
+	ExitTime   int64  `json:"exit_time,omitempty"` // 平仓时间（UTC毫秒）
+	Status     string `json:"status"`              // open、closed
+	Note       string `json:"note"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// CreatePaperTableIfNotExists 创建模拟持仓表
+func CreatePaperTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		symbol VARCHAR(32) NOT NULL,
+		kline_interval VARCHAR(8) NOT NULL,
+		side VARCHAR(8) NOT NULL,
+		quantity DECIMAL(30,8) NOT NULL,
+		entry_price DECIMAL(30,8) NOT NULL,
+		entry_time DATETIME NOT NULL COMMENT '上海时间',
+		mark_price DECIMAL(30,8) NULL,
+		mark_time DATETIME NULL COMMENT '上海时间，估值所用K线的开盘时间',
+		exit_price DECIMAL(30,8) NULL,
+		exit_time DATETIME NULL COMMENT '上海时间',
+		status VARCHAR(8) NOT NULL DEFAULT 'open',
+		note TEXT,
+		created_at DATETIME NOT NULL COMMENT '上海时间',
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (id),
+		KEY idx_series_status (symbol, kline_interval, status)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("paper_positions"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 paper_positions 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// OpenPaperPosition 新增一笔模拟持仓，MarkTime为开仓时最新K线的开盘时间，之后只用更新的K线估值；返回持仓ID
+func OpenPaperPosition(p *PaperPosition) (int64, error) {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	result, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (symbol, kline_interval, side, quantity, entry_price, entry_time, mark_price, mark_time, status, note, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'open', ?, ?, ?)
+	`, prefixTable("paper_positions")), p.Symbol, p.Interval, p.Side, p.Quantity, p.EntryPrice,
+		utils.TimestampToShanghai(p.EntryTime).Format("2006-01-02 15:04:05"),
+		p.MarkPrice, utils.TimestampToShanghai(p.MarkTime).Format("2006-01-02 15:04:05"), p.Note, now, now)
+	if err != nil {
+		utils.LogError("新增模拟持仓失败: %v", err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ClosePaperPosition 按指定价格平仓
+func ClosePaperPosition(id int64, price string, exitTime int64) error {
+	p, err := GetPaperPosition(id)
+	if err != nil {
+		return err
+	}
+	if p.Status != "open" {
+		return ErrPaperPositionClosed
+	}
+
+	_, err = execQuery(DB, fmt.Sprintf(`
+	UPDATE %s SET exit_price = ?, exit_time = ?, status = 'closed', updated_at = ?
+	WHERE id = ? AND status = 'open'
+	`, prefixTable("paper_positions")), price, utils.TimestampToShanghai(exitTime).Format("2006-01-02 15:04:05"),
+		utils.GetShanghaiNow().Format("2006-01-02 15:04:05"), id)
+	if err != nil {
+		utils.LogError("模拟持仓 %d 平仓失败: %v", id, err)
+	}
+	return err
+}
+
+// DeletePaperPosition 删除模拟持仓
+func DeletePaperPosition(id int64) error {
+	result, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE id = ?", prefixTable("paper_positions")), id)
+	if err != nil {
+		utils.LogError("删除模拟持仓 %d 失败: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPaperPositionNotFound
+	}
+	return nil
+}
+
+// MarkPaperPositions 用一根K线的收盘价为该交易对和时间间隔的全部未平仓持仓估值，
+// openTime为K线开盘时间（UTC毫秒），不会用更早的K线覆盖较新的估值，返回更新的持仓数
+func MarkPaperPositions(symbol, interval, price string, openTime int64) (int64, error) {
+	t := utils.TimestampToShanghai(openTime).Format("2006-01-02 15:04:05")
+	result, err := execQuery(DB, fmt.Sprintf(`
+	UPDATE %s SET mark_price = ?, mark_time = ?
+	WHERE symbol = ? AND kline_interval = ? AND status = 'open' AND (mark_time IS NULL OR mark_time <= ?)
+	`, prefixTable("paper_positions")), price, t, symbol, interval, t)
+	if err != nil {
+		utils.LogError("更新 %s %s 模拟持仓估值失败: %v", symbol, interval, err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPaperPosition 按ID查询模拟持仓
+func GetPaperPosition(id int64) (*PaperPosition, error) {
+	positions, err := queryPaperPositions("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 {
+		return nil, ErrPaperPositionNotFound
+	}
+	return &positions[0], nil
+}
+
+// ListPaperPositions 按ID倒序查询模拟持仓，status、symbol为空时不过滤
+func ListPaperPositions(status, symbol string, limit int) ([]PaperPosition, error) {
+	where := "WHERE 1 = 1"
+	var args []interface{}
+	if status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+	if symbol != "" {
+		where += " AND symbol = ?"
+		args = append(args, symbol)
+	}
+	args = append(args, limit)
+	return queryPaperPositions(where+" ORDER BY id DESC LIMIT ?", args...)
+}
+
+// OpenPaperSeries 返回有未平仓持仓的交易对和时间间隔，用于判断写入K线后是否需要估值
+func OpenPaperSeries() (map[string]bool, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT DISTINCT symbol, kline_interval FROM %s WHERE status = 'open'
+	`, prefixTable("paper_positions")))
+	if err != nil {
+		utils.LogError("查询未平仓的模拟持仓失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var symbol, interval string
+		if err := rows.Scan(&symbol, &interval); err != nil {
+			return nil, err
+		}
+		result[symbol+"/"+interval] = true
+	}
+	return result, rows.Err()
+}
+
+// queryPaperPositions 按条件查询模拟持仓
+func queryPaperPositions(where string, args ...interface{}) ([]PaperPosition, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT id, symbol, kline_interval, side, quantity, entry_price, entry_time, mark_price, mark_time,
+		exit_price, exit_time, status, IFNULL(note, ''), created_at, updated_at
+	FROM %s %s
+	`, prefixTable("paper_positions"), where), args...)
+	if err != nil {
+		utils.LogError("查询模拟持仓失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []PaperPosition
+	for rows.Next() {
+		var p PaperPosition
+		var markPrice, exitPrice sql.NullString
+		var markTime, exitTime sql.NullTime
+		var entryTime, createdAt, updatedAt time.Time
+		if err := rows.Scan(&p.ID, &p.Symbol, &p.Interval, &p.Side, &p.Quantity, &p.EntryPrice, &entryTime,
+			&markPrice, &markTime, &exitPrice, &exitTime, &p.Status, &p.Note, &createdAt, &updatedAt); err != nil {
+			utils.LogError("扫描模拟持仓失败: %v", err)
+			return nil, err
+		}
+		p.EntryTime = utils.StoredTimestampToUTC(entryTime.UnixMilli())
+		p.MarkPrice = markPrice.String
+		if markTime.Valid {
+			p.MarkTime = utils.StoredTimestampToUTC(markTime.Time.UnixMilli())
+		}
+		p.ExitPrice = exitPrice.String
+		if exitTime.Valid {
+			p.ExitTime = utils.StoredTimestampToUTC(exitTime.Time.UnixMilli())
+		}
+		p.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		p.UpdatedAt = updatedAt.Format("2006-01-02 15:04:05")
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 存放超出已创建月份数据的兜底分区
+const maxPartition = "pmax"
+
+var (
+	partitioning   bool // 是否按月分区
+	partitionAhead int  // 提前创建的月份数
+
+	// 已确认分区覆盖到的月份，避免每次写入都查询information_schema
+	partitionedUntil   = make(map[string]string)
+	partitionedUntilMu sync.Mutex
+)
+
+// partitionName 获取某月份的分区名称，如 p202401
+func partitionName(t time.Time) string {
+	return t.Format("p200601")
+}
+
+// monthStart 获取上海时间所在月份的第一天
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// partitionDef 生成某月份的分区定义，分区保存该月的数据
+func partitionDef(month time.Time) string {
+	return fmt.Sprintf("PARTITION %s VALUES LESS THAN ('%s')",
+		partitionName(month), month.AddDate(0, 1, 0).Format("2006-01-02 15:04:05"))
+}
+
+// getPartitions 获取数据库conn中表的分区名称，未分区时返回空
+func getPartitions(conn *sql.DB, tableName string) ([]string, error) {
+	rows, err := queryRows(conn, `
+	SELECT PARTITION_NAME FROM information_schema.PARTITIONS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL
+	ORDER BY PARTITION_ORDINAL_POSITION
+	`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, rows.Err()
+}
+
+// EnsurePartitions 确保K线表按月分区并提前创建未来若干个月的分区，未启用分区时不做任何操作
+// 未分区的已有表会从最早一条数据所在月份开始转换为分区表
+func EnsurePartitions(symbol, interval string) error {
+	if !partitioning {
+		return nil
+	}
+
+	tableName := GetTableName(symbol, interval)
+	conn := klineConn(symbol)
+	last := monthStart(utils.GetShanghaiNow()).AddDate(0, partitionAhead, 0)
+
+	partitionedUntilMu.Lock()
+	done := partitionedUntil[tableName] == partitionName(last)
+	partitionedUntilMu.Unlock()
+	if done {
+		return nil
+	}
+
+	parts, err := getPartitions(conn, tableName)
+	if err != nil {
+		utils.LogError("查询表 %s 分区失败: %v", tableName, err)
+		return err
+	}
+
+	var query string
+	if len(parts) == 0 {
+		// 从最早一条数据所在月份开始分区（数据库中的时间即上海时间）
+		first := monthStart(utils.GetShanghaiNow())
+		var minTime sql.NullTime
+		if err := queryRow(conn, fmt.Sprintf("SELECT MIN(timestamp) FROM %s", tableName)).Scan(&minTime); err != nil {
+			return err
+		}
+		if minTime.Valid && minTime.Time.Before(first) {
+			first = time.Date(minTime.Time.Year(), minTime.Time.Month(), 1, 0, 0, 0, 0, first.Location())
+		}
+
+		var defs []string
+		for m := first; !m.After(last); m = m.AddDate(0, 1, 0) {
+			defs = append(defs, partitionDef(m))
+		}
+		defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN (MAXVALUE)", maxPartition))
+		query = fmt.Sprintf("ALTER TABLE %s PARTITION BY RANGE COLUMNS(timestamp) (%s)", tableName, strings.Join(defs, ", "))
+		utils.LogInfo("正在将表 %s 转换为按月分区（%s 至 %s）", tableName, partitionName(first), partitionName(last))
+	} else {
+		// 在兜底分区之前追加缺少的月份
+		latest := ""
+		for _, p := range parts {
+			if p != maxPartition && p > latest {
+				latest = p
+			}
+		}
+		next := monthStart(utils.GetShanghaiNow())
+		if t, err := time.ParseInLocation("p200601", latest, next.Location()); err == nil {
+			next = t.AddDate(0, 1, 0)
+		}
+
+		var defs []string
+		for m := next; !m.After(last); m = m.AddDate(0, 1, 0) {
+			defs = append(defs, partitionDef(m))
+		}
+		if len(defs) > 0 {
+			defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN (MAXVALUE)", maxPartition))
+			query = fmt.Sprintf("ALTER TABLE %s REORGANIZE PARTITION %s INTO (%s)", tableName, maxPartition, strings.Join(defs, ", "))
+			utils.LogInfo("为表 %s 新增分区 %s 至 %s", tableName, partitionName(next), partitionName(last))
+		}
+	}
+
+	if query != "" {
+		if _, err := execSchema(conn, query); err != nil {
+			utils.LogError("维护表 %s 分区失败: %v", tableName, err)
+			return err
+		}
+	}
+
+	partitionedUntilMu.Lock()
+	partitionedUntil[tableName] = partitionName(last)
+	partitionedUntilMu.Unlock()
+	return nil
+}
+
+// PartitioningEnabled 是否按月分区
+func PartitioningEnabled() bool {
+	return partitioning
+}
+
+// MaintainPartitions 为所有K线表补齐未来月份的分区，intervalsFor返回每个交易对的时间间隔，返回失败的表数；
+// 写入时也会补齐，定时维护保证长时间没有写入的表（如停更的时间间隔）同样提前建好分区
+func MaintainPartitions(symbols []string, intervalsFor func(string) []string) int {
+	if !partitioning {
+		return 0
+	}
+	failed := 0
+	for _, symbol := range symbols {
+		for _, interval := range intervalsFor(symbol) {
+			if err := EnsurePartitions(symbol, interval); err != nil {
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// dropPartitionsBefore 删除整月都早于cutoff（上海时间）的分区，返回删除的行数
+func dropPartitionsBefore(conn *sql.DB, tableName, cutoff string) (int64, error) {
+	parts, err := getPartitions(conn, tableName)
+	if err != nil || len(parts) == 0 {
+		return 0, err
+	}
+
+	cutoffTime, err := time.ParseInLocation("2006-01-02 15:04:05", cutoff, utils.GetShanghaiNow().Location())
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, p := range parts {
+		month, err := time.ParseInLocation("p200601", p, cutoffTime.Location())
+		if err != nil || month.AddDate(0, 1, 0).After(cutoffTime) {
+			continue
+		}
+
+		var count int64
+		if err := queryRow(conn, fmt.Sprintf("SELECT COUNT(*) FROM %s PARTITION (%s)", tableName, p)).Scan(&count); err != nil {
+			return total, err
+		}
+		if _, err := execSchema(conn, fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", tableName, p)); err != nil {
+			utils.LogError("删除表 %s 分区 %s 失败: %v", tableName, p, err)
+			return total, err
+		}
+		utils.LogInfo("已删除表 %s 分区 %s，共 %d 条记录", tableName, p, count)
+		total += count
+	}
+	return total, nil
+}
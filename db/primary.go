@@ -0,0 +1,340 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// PrimaryStore 以时序数据库为主存储的 UpdateStore，K线和同步进度都保存在该数据库中，不使用MySQL
+type PrimaryStore interface {
+	UpdateStore
+	Name() string
+	Close() error
+}
+
+// OpenPrimaryStore 按 SINK_PRIMARY 打开作为主存储的时序数据库，主存储为MySQL时返回nil
+func OpenPrimaryStore(cfg *config.SinkConfig) (PrimaryStore, error) {
+	switch cfg.Primary {
+	case "", "mysql":
+		return nil, nil
+	case "timescale":
+		sink, err := newTimescaleSink(cfg.TimescaleDSN, cfg.TimescaleTable)
+		if err != nil {
+			return nil, err
+		}
+		store := &timescaleStore{sink: sink}
+		if _, err := execSchema(sink.db, store.syncStateDDL()); err != nil {
+			sink.Close()
+			return nil, err
+		}
+		return store, nil
+	case "influx":
+		return newInfluxStore(cfg), nil
+	default:
+		return nil, fmt.Errorf("不支持的主存储: %s", cfg.Primary)
+	}
+}
+
+// utcToStoredTimestamp 把UTC毫秒时间戳换算为与从MySQL读出的相同口径（上海时间按UTC解析），
+// 是 utils.StoredTimestampToUTC 的逆运算
+func utcToStoredTimestamp(timestamp int64) int64 {
+	wall := utils.TimestampToShanghai(timestamp)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC).UnixMilli()
+}
+
+// timescaleStore 写入TimescaleDB超表，同步进度保存在同一数据库的 <表名>_sync_state 表
+type timescaleStore struct {
+	sink *timescaleSink
+}
+
+func (s *timescaleStore) Name() string {
+	return s.sink.Name()
+}
+
+func (s *timescaleStore) syncTable() string {
+	return s.sink.table + "_sync_state"
+}
+
+func (s *timescaleStore) syncStateDDL() string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			symbol TEXT NOT NULL,
+			timeframe TEXT NOT NULL,
+			watermark BIGINT NOT NULL,
+			last_success TIMESTAMPTZ NULL,
+			PRIMARY KEY (symbol, timeframe)
+		)`, s.syncTable())
+}
+
+// CreateTable 所有交易对和时间间隔共用打开时创建的超表
+func (s *timescaleStore) CreateTable(symbol, interval string) error {
+	return nil
+}
+
+// SaveKlines 在一个事务中upsert一批K线
+func (s *timescaleStore) SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.sink.WriteKlines(records)
+}
+
+// LastKlineTimestamp 超表中保存的是UTC时间，换算为与MySQL相同的口径
+func (s *timescaleStore) LastKlineTimestamp(symbol, interval string) (int64, bool, error) {
+	var last sql.NullTime
+	err := queryRow(s.sink.db, fmt.Sprintf("SELECT max(time) FROM %s WHERE symbol = $1 AND timeframe = $2", s.sink.table),
+		symbol, interval).Scan(&last)
+	if err != nil || !last.Valid {
+		return 0, false, err
+	}
+	return utcToStoredTimestamp(last.Time.UnixMilli()), true, nil
+}
+
+func (s *timescaleStore) GetSyncState(symbol, interval string) (*SyncState, error) {
+	var lastSuccess sql.NullTime
+	state := &SyncState{Symbol: symbol, Interval: interval}
+	err := queryRow(s.sink.db, fmt.Sprintf("SELECT watermark, last_success FROM %s WHERE symbol = $1 AND timeframe = $2", s.syncTable()),
+		symbol, interval).Scan(&state.Watermark, &lastSuccess)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastSuccess.Valid {
+		state.LastSuccess = lastSuccess.Time
+	}
+	return state, nil
+}
+
+func (s *timescaleStore) AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	_, err := execQuery(s.sink.db, fmt.Sprintf(`
+	INSERT INTO %[1]s (symbol, timeframe, watermark) VALUES ($1, $2, $3)
+	ON CONFLICT (symbol, timeframe) DO UPDATE SET watermark = GREATEST(%[1]s.watermark, EXCLUDED.watermark)
+	`, s.syncTable()), symbol, interval, watermark)
+	return err
+}
+
+// MarkSyncSuccess 与MySQL相同，还没有水位时不记录
+func (s *timescaleStore) MarkSyncSuccess(symbol, interval string) error {
+	_, err := execQuery(s.sink.db, fmt.Sprintf("UPDATE %s SET last_success = now() WHERE symbol = $1 AND timeframe = $2", s.syncTable()),
+		symbol, interval)
+	return err
+}
+
+// KlineRange 按时间升序读取[startUTC, endUTC]内的K线，最多limit条，startUTC/endUTC为0时不限制该端；
+// 返回的OpenTime换算为与MySQL相同的口径
+func (s *timescaleStore) KlineRange(symbol, interval string, startUTC, endUTC int64, limit int) ([]Candle, error) {
+	query := fmt.Sprintf("SELECT time, open::text, high::text, low::text, close::text, volume::text FROM %s WHERE symbol = $1 AND timeframe = $2", s.sink.table)
+	args := []interface{}{symbol, interval}
+	if startUTC > 0 {
+		args = append(args, time.UnixMilli(startUTC).UTC())
+		query += fmt.Sprintf(" AND time >= $%d", len(args))
+	}
+	if endUTC > 0 {
+		args = append(args, time.UnixMilli(endUTC).UTC())
+		query += fmt.Sprintf(" AND time <= $%d", len(args))
+	}
+	query += " ORDER BY time"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := queryRows(s.sink.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Candle
+	for rows.Next() {
+		var c Candle
+		var ts time.Time
+		if err := rows.Scan(&ts, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			return nil, err
+		}
+		c.OpenTime = utcToStoredTimestamp(ts.UnixMilli())
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func (s *timescaleStore) Close() error {
+	return s.sink.Close()
+}
+
+// influxStore 以行协议写入InfluxDB，通过InfluxQL查询接口（/query，2.x为1.x兼容接口，需要bucket的DBRP映射）读取最新K线和同步进度；
+// 同步进度写入 <measurement>_sync_state，每次推进水位写入一个点，读取时取最大值
+type influxStore struct {
+	sink     *influxSink
+	queryURL string
+}
+
+func newInfluxStore(cfg *config.SinkConfig) *influxStore {
+	base := strings.TrimRight(cfg.InfluxURL, "/")
+	return &influxStore{
+		sink:     newInfluxSink(cfg),
+		queryURL: fmt.Sprintf("%s/query?db=%s&epoch=ms", base, url.QueryEscape(cfg.InfluxBucket)),
+	}
+}
+
+func (s *influxStore) Name() string {
+	return s.sink.Name()
+}
+
+func (s *influxStore) syncMeasurement() string {
+	return s.sink.measurement + "_sync_state"
+}
+
+// CreateTable InfluxDB写入时自动创建measurement
+func (s *influxStore) CreateTable(symbol, interval string) error {
+	return nil
+}
+
+// SaveKlines 一次请求写入一批K线；相同标签和时间戳的点会被覆盖，请求失败后整批重试不会产生重复数据
+func (s *influxStore) SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.sink.WriteKlines(records)
+}
+
+// influxSeries InfluxQL查询结果中的一个序列
+type influxSeries struct {
+	Columns []string        `json:"columns"`
+	Values  [][]json.Number `json:"values"`
+}
+
+// query 执行一条InfluxQL查询，返回第一个语句的结果序列
+func (s *influxStore) query(q string) ([]influxSeries, error) {
+	req, err := http.NewRequest(http.MethodGet, s.queryURL+"&q="+url.QueryEscape(q), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.sink.token != "" {
+		req.Header.Set("Authorization", "Token "+s.sink.token)
+	}
+
+	resp, err := s.sink.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("InfluxDB返回状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Results []struct {
+			Series []influxSeries `json:"series"`
+			Error  string         `json:"error"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析InfluxDB查询结果失败: %v", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("InfluxDB查询失败: %s", result.Error)
+	}
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+	if result.Results[0].Error != "" {
+		return nil, fmt.Errorf("InfluxDB查询失败: %s", result.Results[0].Error)
+	}
+	return result.Results[0].Series, nil
+}
+
+// seriesFilter 按标签选择一个交易对和时间间隔，标签值只包含字母和数字，无需转义
+func seriesFilter(symbol, interval string) string {
+	return fmt.Sprintf(`"symbol" = '%s' AND "interval" = '%s'`, symbol, interval)
+}
+
+// LastKlineTimestamp 最后一个点的时间为UTC开盘时间，换算为与MySQL相同的口径
+func (s *influxStore) LastKlineTimestamp(symbol, interval string) (int64, bool, error) {
+	series, err := s.query(fmt.Sprintf(`SELECT last("close") FROM "%s" WHERE %s`, s.sink.measurement, seriesFilter(symbol, interval)))
+	if err != nil {
+		return 0, false, err
+	}
+	if len(series) == 0 || len(series[0].Values) == 0 {
+		return 0, false, nil
+	}
+	last, err := series[0].Values[0][0].Int64()
+	if err != nil {
+		return 0, false, fmt.Errorf("无效的时间 %q: %v", series[0].Values[0][0], err)
+	}
+	return utcToStoredTimestamp(last), true, nil
+}
+
+func (s *influxStore) GetSyncState(symbol, interval string) (*SyncState, error) {
+	series, err := s.query(fmt.Sprintf(`SELECT max("watermark"), last("last_success") FROM "%s" WHERE %s`,
+		s.syncMeasurement(), seriesFilter(symbol, interval)))
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 || len(series[0].Values) == 0 {
+		return nil, nil
+	}
+
+	// 列依次为 time、max、last，没有写入过的字段为null
+	row := series[0].Values[0]
+	if len(row) < 3 || row[1] == "" {
+		return nil, nil
+	}
+	state := &SyncState{Symbol: symbol, Interval: interval}
+	if state.Watermark, err = row[1].Int64(); err != nil {
+		return nil, fmt.Errorf("无效的水位 %q: %v", row[1], err)
+	}
+	if row[2] != "" {
+		lastSuccess, err := row[2].Int64()
+		if err != nil {
+			return nil, fmt.Errorf("无效的同步成功时间 %q: %v", row[2], err)
+		}
+		state.LastSuccess = time.UnixMilli(lastSuccess)
+	}
+	return state, nil
+}
+
+// writeSyncField 以当前时间写入一个同步进度字段
+func (s *influxStore) writeSyncField(symbol, interval, field string, value int64) error {
+	line := fmt.Sprintf("%s,symbol=%s,interval=%s %s=%di %d\n",
+		s.syncMeasurement(), symbol, interval, field, value, time.Now().UnixMilli())
+	return s.sink.write(strings.NewReader(line))
+}
+
+// AdvanceSyncWatermark 读取时取水位的最大值，写入较小的值不会使水位后退
+func (s *influxStore) AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	return s.writeSyncField(symbol, interval, "watermark", watermark)
+}
+
+// MarkSyncSuccess 与MySQL相同，还没有水位时不记录
+func (s *influxStore) MarkSyncSuccess(symbol, interval string) error {
+	state, err := s.GetSyncState(symbol, interval)
+	if err != nil || state == nil {
+		return err
+	}
+	return s.writeSyncField(symbol, interval, "last_success", time.Now().UnixMilli())
+}
+
+func (s *influxStore) Close() error {
+	return s.sink.Close()
+}
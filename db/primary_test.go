@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+func TestTimescaleStoreLastKlineTimestamp(t *testing.T) {
+	fake, conn := openFakeDB(t)
+	openTime := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	fake.OnQuery = func(query string, args []driver.Value) (*fakeResult, error) {
+		return &fakeResult{Columns: []string{"max"}, Rows: [][]driver.Value{{openTime}}}, nil
+	}
+	store := &timescaleStore{sink: &timescaleSink{db: conn, table: "klines"}}
+
+	// 超表保存UTC时间，返回的时间戳与从MySQL读出的口径一致
+	last, ok, err := store.LastKlineTimestamp("BTCUSDT", "1h")
+	if err != nil || !ok {
+		t.Fatalf("LastKlineTimestamp = %d, %v, %v", last, ok, err)
+	}
+	if got := utils.StoredTimestampToUTC(last); got != openTime.UnixMilli() {
+		t.Fatalf("stored timestamp converts to %d, want %d", got, openTime.UnixMilli())
+	}
+}
+
+// fakeInflux 模拟InfluxDB的写入和InfluxQL查询接口，查询按语句中的measurement返回设定的结果
+type fakeInflux struct {
+	mu      sync.Mutex
+	lines   []string
+	results map[string]string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/write":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.lines = append(f.lines, strings.TrimSpace(string(body)))
+		w.WriteHeader(http.StatusNoContent)
+	case "/query":
+		q := r.URL.Query().Get("q")
+		for measurement, result := range f.results {
+			if strings.Contains(q, `FROM "`+measurement+`"`) {
+				w.Write([]byte(result))
+				return
+			}
+		}
+		w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestInfluxStoreSyncState(t *testing.T) {
+	influx := &fakeInflux{results: make(map[string]string)}
+	srv := httptest.NewServer(influx)
+	t.Cleanup(srv.Close)
+	store := newInfluxStore(&config.SinkConfig{InfluxURL: srv.URL, InfluxBucket: "biupdata", InfluxMeasurement: "kline"})
+
+	// 没有水位时不记录同步成功
+	if state, err := store.GetSyncState("BTCUSDT", "1h"); err != nil || state != nil {
+		t.Fatalf("GetSyncState without points = %+v, %v", state, err)
+	}
+	if err := store.MarkSyncSuccess("BTCUSDT", "1h"); err != nil {
+		t.Fatalf("MarkSyncSuccess: %v", err)
+	}
+	if len(influx.lines) != 0 {
+		t.Fatalf("MarkSyncSuccess without watermark wrote %q", influx.lines)
+	}
+
+	if err := store.AdvanceSyncWatermark("BTCUSDT", "1h", 1709280000000); err != nil {
+		t.Fatalf("AdvanceSyncWatermark: %v", err)
+	}
+	if len(influx.lines) != 1 || !strings.HasPrefix(influx.lines[0], "kline_sync_state,symbol=BTCUSDT,interval=1h watermark=1709280000000i ") {
+		t.Fatalf("wrote %q", influx.lines)
+	}
+
+	// 还没有同步成功过时last_success为null
+	influx.results["kline_sync_state"] = `{"results":[{"statement_id":0,"series":[{"name":"kline_sync_state","columns":["time","max","last"],"values":[[0,1709280000000,null]]}]}]}`
+	state, err := store.GetSyncState("BTCUSDT", "1h")
+	if err != nil || state == nil {
+		t.Fatalf("GetSyncState = %+v, %v", state, err)
+	}
+	if state.Watermark != 1709280000000 || !state.LastSuccess.IsZero() {
+		t.Fatalf("state = %+v", state)
+	}
+
+	influx.results["kline"] = `{"results":[{"statement_id":0,"series":[{"name":"kline","columns":["time","last"],"values":[[1709280000000,61000.5]]}]}]}`
+	last, ok, err := store.LastKlineTimestamp("BTCUSDT", "1h")
+	if err != nil || !ok || utils.StoredTimestampToUTC(last) != 1709280000000 {
+		t.Fatalf("LastKlineTimestamp = %d, %v, %v", last, ok, err)
+	}
+
+	records := []KlineRecord{{Symbol: "BTCUSDT", Interval: "1h", Timestamp: 1709283600000, Open: "1", High: "2", Low: "0.5", Close: "1.5", Volume: "10"}}
+	if err := store.SaveKlines(context.Background(), "BTCUSDT", "1h", records); err != nil {
+		t.Fatalf("SaveKlines: %v", err)
+	}
+	if got := influx.lines[len(influx.lines)-1]; got != "kline,symbol=BTCUSDT,interval=1h open=1,high=2,low=0.5,close=1.5,volume=10 1709283600000" {
+		t.Fatalf("SaveKlines wrote %q", got)
+	}
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// CountKlineAnomalies 在只读连接上统计价格关系不成立的K线：最高价低于开盘、收盘或最低价，最低价高于开盘或收盘价，
+// 价格不为正数或成交量为负
+func CountKlineAnomalies(symbol, interval string) (int64, error) {
+	tableName := GetTableName(symbol, interval)
+
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) FROM %s %s
+	WHERE high_price < low_price
+		OR high_price < open_price OR high_price < close_price
+		OR low_price > open_price OR low_price > close_price
+		OR open_price <= 0 OR close_price <= 0 OR low_price <= 0
+		OR volume < 0
+	`, tableName, coveringIndexHint(tableName))
+
+	var count int64
+	if err := queryRow(klineReadConn(symbol), query).Scan(&count); err != nil {
+		utils.LogError("统计表 %s 异常K线失败: %v", tableName, err)
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ErrQueryTimeout 查询超过DB_QUERY_TIMEOUT被取消，调用方可据此返回504
+var ErrQueryTimeout = errors.New("数据库查询超时")
+
+var (
+	queryTimeout       time.Duration // 单条语句的执行期限，0表示不限制
+	slowQueryThreshold time.Duration // 超过该耗时的语句记录到日志，0表示不记录
+)
+
+// 慢查询日志中参数的最大长度，避免批量参数刷屏
+const slowQueryMaxArgs = 200
+
+// sqlConn 可执行语句的连接，*sql.DB和*sql.Tx均满足
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// initQueryTimeout 读取查询超时和慢查询阈值配置
+func initQueryTimeout(cfg *config.DatabaseConfig) {
+	queryTimeout = time.Duration(cfg.QueryTimeout) * time.Second
+	slowQueryThreshold = time.Duration(cfg.SlowQueryMs) * time.Millisecond
+}
+
+// queryContext 返回带执行期限的上下文，done需在读取完结果后调用，用于释放上下文并记录慢查询
+func queryContext(query string, args []interface{}) (context.Context, func()) {
+	return newQueryContext(context.Background(), queryTimeout, query, args)
+}
+
+// newQueryContext 返回基于parent、期限为timeout的上下文，timeout为0时不限制
+func newQueryContext(parent context.Context, timeout time.Duration, query string, args []interface{}) (context.Context, func()) {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	}
+
+	start := time.Now()
+	finished := false
+	return ctx, func() {
+		if finished {
+			return
+		}
+		finished = true
+		cancel()
+		logSlowQuery(query, args, time.Since(start))
+	}
+}
+
+// queryError 将因超过执行期限而失败的错误转换为ErrQueryTimeout
+func queryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		utils.IncCounter("biupdata_db_query_timeouts_total")
+		return fmt.Errorf("%w（超过%v）: %v", ErrQueryTimeout, queryTimeout, err)
+	}
+	return err
+}
+
+// logSlowQuery 记录耗时超过DB_SLOW_QUERY_MS的语句及其参数
+func logSlowQuery(query string, args []interface{}, elapsed time.Duration) {
+	if slowQueryThreshold <= 0 || elapsed < slowQueryThreshold {
+		return
+	}
+	utils.IncCounter("biupdata_db_slow_queries_total")
+
+	params := fmt.Sprintf("%v", args)
+	if len(params) > slowQueryMaxArgs {
+		params = params[:slowQueryMaxArgs] + "..."
+	}
+	utils.LogWarning("慢查询 %v: %s 参数: %s", elapsed.Round(time.Millisecond), strings.Join(strings.Fields(query), " "), params)
+}
+
+// execQuery 带执行期限执行语句
+func execQuery(c sqlConn, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := queryContext(query, args)
+	defer done()
+	result, err := c.ExecContext(ctx, query, args...)
+	return result, queryError(ctx, err)
+}
+
+// execSchema 执行建表、修改表结构、维护分区等语句，这类语句耗时与数据量相关，不受执行期限限制，仍记录慢查询
+func execSchema(c sqlConn, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := newQueryContext(context.Background(), 0, query, args)
+	defer done()
+	return c.ExecContext(ctx, query, args...)
+}
+
+// execStmt 带执行期限执行预处理语句，query仅用于慢查询日志
+func execStmt(stmt *sql.Stmt, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := queryContext(query, args)
+	defer done()
+	result, err := stmt.ExecContext(ctx, args...)
+	return result, queryError(ctx, err)
+}
+
+// pingDB 带执行期限检查连接是否可用
+func pingDB(db *sql.DB) error {
+	ctx, done := queryContext("PING", nil)
+	defer done()
+	return queryError(ctx, db.PingContext(ctx))
+}
+
+// timedRow 带执行期限的单行查询结果，Scan后释放上下文
+type timedRow struct {
+	row  *sql.Row
+	ctx  context.Context
+	done func()
+}
+
+// Scan 扫描结果，超时时返回ErrQueryTimeout
+func (r timedRow) Scan(dest ...interface{}) error {
+	defer r.done()
+	return queryError(r.ctx, r.row.Scan(dest...))
+}
+
+// queryRow 带执行期限查询单行，返回值必须调用Scan
+func queryRow(c sqlConn, query string, args ...interface{}) timedRow {
+	ctx, done := queryContext(query, args)
+	return timedRow{row: c.QueryRowContext(ctx, query, args...), ctx: ctx, done: done}
+}
+
+// timedRows 带执行期限的多行查询结果，Close后释放上下文，读取中途超时时Err返回ErrQueryTimeout
+type timedRows struct {
+	*sql.Rows
+	ctx  context.Context
+	done func()
+}
+
+// Err 返回遍历过程中的错误
+func (r *timedRows) Err() error {
+	return queryError(r.ctx, r.Rows.Err())
+}
+
+// Scan 扫描当前行，超时时返回ErrQueryTimeout
+func (r *timedRows) Scan(dest ...interface{}) error {
+	return queryError(r.ctx, r.Rows.Scan(dest...))
+}
+
+// Close 关闭结果集并释放上下文
+func (r *timedRows) Close() error {
+	err := r.Rows.Close()
+	r.done()
+	return err
+}
+
+// streamRows 查询多行，不受执行期限限制，随ctx取消，用于边读边输出、耗时取决于调用方的查询
+func streamRows(ctx context.Context, c sqlConn, query string, args ...interface{}) (*timedRows, error) {
+	ctx, done := newQueryContext(ctx, 0, query, args)
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return &timedRows{Rows: rows, ctx: ctx, done: done}, nil
+}
+
+// queryRows 带执行期限查询多行，调用方需Close并在遍历结束后检查Err
+func queryRows(c sqlConn, query string, args ...interface{}) (*timedRows, error) {
+	ctx, done := queryContext(query, args)
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		done()
+		return nil, queryError(ctx, err)
+	}
+	return &timedRows{Rows: rows, ctx: ctx, done: done}, nil
+}
@@ -0,0 +1,357 @@
+package db
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 缓存队列文件名，每行一条JSON格式的K线记录
+const queueFileName = "pending.jsonl"
+
+// 回放时因SQL错误（而非连接错误）无法写入的记录移到该文件，不再阻塞后续回放
+const deadLetterFileName = "dead.jsonl"
+
+var (
+	queuePath    string         // 为空表示未启用缓存队列
+	queueCount   int            // 队列中未写入数据库的记录数
+	queueSeries  map[string]int // 每个交易对和时间间隔在队列中的记录数
+	queueMu      sync.Mutex
+	queueStop    chan struct{}
+	queueStopped chan struct{}
+)
+
+// InitQueue 初始化磁盘缓存队列并启动后台回放，程序重启后会继续回放上次未写入的数据
+func InitQueue(cfg *config.DatabaseConfig) error {
+	if cfg.QueueDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.QueueDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(cfg.QueueDir, queueFileName)
+	queueMu.Lock()
+	queuePath = path
+	records, err := readQueueLocked()
+	if err != nil {
+		queuePath = ""
+		queueMu.Unlock()
+		return err
+	}
+	setQueueRecordsLocked(records)
+	count := queueCount
+	queueMu.Unlock()
+	utils.SetGauge("biupdata_queue_pending", float64(count))
+	if count > 0 {
+		utils.LogWarning("缓存队列中有 %d 条上次未写入数据库的K线，将在数据库可用后回放", count)
+	}
+
+	interval := time.Duration(cfg.QueueDrainInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	queueStop = make(chan struct{})
+	queueStopped = make(chan struct{})
+	go func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				utils.Safe("queue_drain", DrainQueue)
+			}
+		}
+	}(queueStop, queueStopped)
+
+	utils.LogInfo("已启用磁盘缓存队列: %s", path)
+	return nil
+}
+
+// CloseQueue 停止后台回放
+func CloseQueue() {
+	if queueStop == nil {
+		return
+	}
+	close(queueStop)
+	<-queueStopped
+	queueStop = nil
+}
+
+// QueuePending 获取缓存队列中未写入数据库的记录数
+func QueuePending() int {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	return queueCount
+}
+
+// SeriesQueued 交易对和时间间隔是否还有只写入了缓存队列、尚未写入数据库的K线，
+// 此时不能推进同步水位，否则回放失败的K线不会再被同步
+func SeriesQueued(symbol, interval string) bool {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	return queueSeries[symbol+"/"+interval] > 0
+}
+
+// setQueueRecordsLocked 按队列中的记录重新统计记录数，调用方需持有queueMu
+func setQueueRecordsLocked(records []KlineRecord) {
+	queueCount = len(records)
+	queueSeries = make(map[string]int)
+	for _, k := range records {
+		queueSeries[k.Symbol+"/"+k.Interval]++
+	}
+}
+
+// enqueueKline 将K线追加到缓存队列并落盘
+func enqueueKline(k KlineRecord) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	return appendQueueLocked(k)
+}
+
+// enqueueIfPending 队列中仍有未回放的数据时，新数据也先排队，保证同一根K线按写入顺序生效
+func enqueueIfPending(k KlineRecord) (bool, error) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if queuePath == "" || queueCount == 0 {
+		return false, nil
+	}
+	return true, appendQueueLocked(k)
+}
+
+// enqueueBatch 将一批K线追加到缓存队列并落盘
+func enqueueBatch(records []KlineRecord) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	return appendQueueLocked(records...)
+}
+
+// enqueueBatchIfPending 与enqueueIfPending相同，用于批量写入
+func enqueueBatchIfPending(records []KlineRecord) (bool, error) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if queuePath == "" || queueCount == 0 {
+		return false, nil
+	}
+	return true, appendQueueLocked(records...)
+}
+
+// appendQueueLocked 追加记录，调用方需持有queueMu
+func appendQueueLocked(records ...KlineRecord) error {
+	var buf []byte
+	for _, k := range records {
+		data, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+
+	f, err := os.OpenFile(queuePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		utils.LogError("打开缓存队列失败: %v", err)
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buf); err != nil {
+		utils.LogError("写入缓存队列失败: %v", err)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	queueCount += len(records)
+	if queueSeries == nil {
+		queueSeries = make(map[string]int)
+	}
+	for _, k := range records {
+		queueSeries[k.Symbol+"/"+k.Interval]++
+	}
+	utils.AddCounter("biupdata_queue_enqueued_total", float64(len(records)))
+	utils.SetGauge("biupdata_queue_pending", float64(queueCount))
+	return nil
+}
+
+// DrainQueue 数据库恢复后按顺序回放缓存的K线，遇到连接错误时保留剩余记录等待下次回放；
+// 其他错误（如表已被归档或重建改名）重试也不会成功，记录移到死信文件后继续回放，避免一条记录阻塞全部写入
+func DrainQueue() {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if queuePath == "" || queueCount == 0 {
+		return
+	}
+	if err := pingDB(DB); err != nil {
+		return
+	}
+
+	records, err := readQueueLocked()
+	if err != nil {
+		utils.LogError("读取缓存队列失败: %v", err)
+		return
+	}
+
+	var applied, dead []KlineRecord
+	done := 0
+	for _, k := range records {
+		if err := saveKlineRow(k); err != nil {
+			if isConnectionError(err) {
+				utils.LogWarning("回放缓存队列中断，剩余 %d 条: %v", len(records)-done, err)
+				break
+			}
+			utils.LogError("回放 %s %s %d 的K线失败，已移到死信文件: %v", k.Symbol, k.Interval, k.Timestamp, err)
+			dead = append(dead, k)
+		} else {
+			applied = append(applied, k)
+		}
+		done++
+	}
+
+	if len(dead) > 0 {
+		if err := appendDeadLetters(dead); err != nil {
+			utils.LogError("写入死信文件失败，记录保留在缓存队列: %v", err)
+			return
+		}
+	}
+	if err := rewriteQueueLocked(records[done:]); err != nil {
+		utils.LogError("更新缓存队列失败: %v", err)
+		return
+	}
+
+	setQueueRecordsLocked(records[done:])
+	writeSinks(applied)
+	utils.AddCounter("biupdata_queue_drained_total", float64(len(applied)))
+	utils.AddCounter("biupdata_queue_dead_total", float64(len(dead)))
+	utils.SetGauge("biupdata_queue_pending", float64(queueCount))
+	if done > 0 {
+		utils.LogInfo("已从缓存队列回放 %d 条K线到数据库，%d 条移到死信文件，剩余 %d 条", len(applied), len(dead), queueCount)
+	}
+}
+
+// appendDeadLetters 把无法回放的记录追加到队列目录下的死信文件，便于人工检查后重新导入
+func appendDeadLetters(records []KlineRecord) error {
+	var buf []byte
+	for _, k := range records {
+		data, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+
+	path := filepath.Join(filepath.Dir(queuePath), deadLetterFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readQueueLocked 读取队列中的全部记录，跳过无法解析的行（通常是崩溃时写了一半的最后一行）
+func readQueueLocked() ([]KlineRecord, error) {
+	f, err := os.Open(queuePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []KlineRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var k KlineRecord
+		if err := json.Unmarshal(scanner.Bytes(), &k); err != nil {
+			utils.LogWarning("跳过无法解析的缓存记录: %v", err)
+			continue
+		}
+		records = append(records, k)
+	}
+	return records, scanner.Err()
+}
+
+// rewriteQueueLocked 用剩余记录替换队列文件，先写临时文件再重命名，避免中途崩溃丢数据
+func rewriteQueueLocked(records []KlineRecord) error {
+	if len(records) == 0 {
+		if err := os.Remove(queuePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := queuePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, k := range records {
+		data, err := json.Marshal(k)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, queuePath)
+}
+
+// isConnectionError 判断是否为数据库连接不可用导致的错误，SQL本身的错误不进入缓存队列
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "bad connection")
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// useTestQueue 在临时目录启用缓存队列（不启动后台回放），测试结束后恢复
+func useTestQueue(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	queueMu.Lock()
+	queuePath = filepath.Join(dir, queueFileName)
+	setQueueRecordsLocked(nil)
+	queueMu.Unlock()
+	t.Cleanup(func() {
+		queueMu.Lock()
+		queuePath = ""
+		setQueueRecordsLocked(nil)
+		queueMu.Unlock()
+	})
+	return dir
+}
+
+// readTestQueueFile 读取队列目录下的记录文件，返回各记录的开盘价
+func readTestQueueFile(t *testing.T, path string) []string {
+	t.Helper()
+	queueMu.Lock()
+	prev := queuePath
+	queuePath = path
+	records, err := readQueueLocked()
+	queuePath = prev
+	queueMu.Unlock()
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	var opens []string
+	for _, k := range records {
+		opens = append(opens, k.Open)
+	}
+	return opens
+}
+
+func TestDrainQueue(t *testing.T) {
+	// 开盘价标记回放该记录时数据库的行为
+	errs := map[string]error{
+		"sql":  &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"},
+		"conn": errors.New("dial tcp: connection refused"),
+	}
+
+	tests := []struct {
+		name        string
+		opens       []string
+		wantApplied []string
+		wantPending []string
+		wantDead    []string
+	}{
+		{"all applied", []string{"a", "b"}, []string{"a", "b"}, nil, nil},
+		{"sql error moves to dead letters", []string{"a", "sql", "b"}, []string{"a", "b"}, nil, []string{"sql"}},
+		{"connection error keeps remaining", []string{"a", "conn", "b"}, []string{"a"}, []string{"conn", "b"}, nil},
+		{"dead letters before connection error", []string{"sql", "a", "conn"}, []string{"a"}, []string{"conn"}, []string{"sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTestQueue(t)
+			fake := useFakeDB(t)
+			fake.OnExec = func(query string, args []driver.Value) error {
+				return errs[args[1].(string)]
+			}
+
+			var records []KlineRecord
+			for i, open := range tt.opens {
+				records = append(records, KlineRecord{Symbol: "BTCUSDT", Interval: "1h", Timestamp: int64(i+1) * 3600000, Open: open})
+			}
+			if err := enqueueBatch(records); err != nil {
+				t.Fatalf("enqueueBatch: %v", err)
+			}
+
+			DrainQueue()
+
+			var applied []string
+			for _, stmt := range fake.ExecsMatching("INSERT INTO") {
+				applied = append(applied, stmt.Args[1].(string))
+			}
+			if !reflect.DeepEqual(applied, tt.wantApplied) {
+				t.Fatalf("applied %v, want %v", applied, tt.wantApplied)
+			}
+			if got := readTestQueueFile(t, filepath.Join(dir, queueFileName)); !reflect.DeepEqual(got, tt.wantPending) {
+				t.Fatalf("pending %v, want %v", got, tt.wantPending)
+			}
+			if got := readTestQueueFile(t, filepath.Join(dir, deadLetterFileName)); !reflect.DeepEqual(got, tt.wantDead) {
+				t.Fatalf("dead letters %v, want %v", got, tt.wantDead)
+			}
+			if QueuePending() != len(tt.wantPending) || SeriesQueued("BTCUSDT", "1h") != (len(tt.wantPending) > 0) {
+				t.Fatalf("QueuePending = %d, want %d", QueuePending(), len(tt.wantPending))
+			}
+			if len(tt.wantPending) == 0 {
+				if _, err := os.Stat(filepath.Join(dir, queueFileName)); !os.IsNotExist(err) {
+					t.Fatalf("queue file still exists: %v", err)
+				}
+			}
+		})
+	}
+}
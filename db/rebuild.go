@@ -0,0 +1,54 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// ResetKlineTable 清空交易对单个时间间隔的K线表，供重建数据使用：
+// keepAside为true时把原表重命名为 rebuild_表名_时间 后重新建表，返回保留的表名；否则直接清空原表。
+// 同时清空滚动统计伴生表并删除同步进度，之后的更新从头开始
+func ResetKlineTable(symbol, interval string, keepAside bool) (string, error) {
+	tableName := GetTableName(symbol, interval)
+	conn := klineConn(symbol)
+	exists, err := tableExists(conn, tableName)
+	if err != nil {
+		return "", err
+	}
+
+	var backup string
+	switch {
+	case !exists:
+		// 表不存在时直接建表
+	case keepAside:
+		backup = fmt.Sprintf("%s_%s", asideTableName("rebuild", tableName), utils.GetShanghaiNow().Format("20060102150405"))
+		if _, err := execSchema(conn, fmt.Sprintf("RENAME TABLE %s TO %s", tableName, backup)); err != nil {
+			utils.LogError("将表 %s 重命名为 %s 失败: %v", tableName, backup, err)
+			return "", err
+		}
+		utils.LogInfo("已将表 %s 重命名为 %s", tableName, backup)
+	default:
+		if _, err := execSchema(conn, fmt.Sprintf("TRUNCATE TABLE %s", tableName)); err != nil {
+			utils.LogError("清空表 %s 失败: %v", tableName, err)
+			return "", err
+		}
+		utils.LogInfo("已清空表 %s", tableName)
+	}
+	if err := CreateTableIfNotExists(symbol, interval); err != nil {
+		return backup, err
+	}
+
+	// 滚动统计由K线计算，随K线一起重建
+	statsTable := GetStatsTableName(symbol, interval)
+	if ok, err := tableExists(conn, statsTable); err != nil {
+		return backup, err
+	} else if ok {
+		if _, err := execSchema(conn, fmt.Sprintf("TRUNCATE TABLE %s", statsTable)); err != nil {
+			utils.LogError("清空表 %s 失败: %v", statsTable, err)
+			return backup, err
+		}
+	}
+
+	return backup, DeleteSyncState(symbol, interval)
+}
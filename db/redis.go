@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis Redis连接实例，未配置时为nil
+var Redis *redis.Client
+
+// redisKeyPrefix 所有键的统一前缀，避免与其他应用冲突
+const redisKeyPrefix = "biupdata:"
+
+// 释放锁时只删除自己持有的锁
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// 只延长自己仍然持有的锁
+var extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// InitRedis 初始化Redis连接，未配置地址时不启用
+func InitRedis(cfg *config.RedisConfig) error {
+	if cfg.Addr == "" {
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return err
+	}
+
+	Redis = client
+	utils.LogInfo("Redis连接成功: %s", cfg.Addr)
+	return nil
+}
+
+// CloseRedis 关闭Redis连接
+func CloseRedis() {
+	if Redis != nil {
+		Redis.Close()
+	}
+}
+
+// CacheGet 读取缓存并解码到dest，未启用Redis或未命中时返回false；
+// dest应为写入时的具体类型（如[]KlineItem），时间戳等整数字段按字段类型解码，不经过浮点数
+func CacheGet(key string, dest interface{}) bool {
+	if Redis == nil {
+		return false
+	}
+
+	data, err := Redis.Get(context.Background(), redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			utils.LogWarning("读取Redis缓存 %s 失败: %v", key, err)
+		}
+		return false
+	}
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		utils.LogWarning("解析Redis缓存 %s 失败: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// CacheSet 写入缓存，未启用Redis时忽略
+func CacheSet(key string, value interface{}, ttl time.Duration) {
+	if Redis == nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		utils.LogWarning("序列化Redis缓存 %s 失败: %v", key, err)
+		return
+	}
+
+	if err := Redis.Set(context.Background(), redisKeyPrefix+key, data, ttl).Err(); err != nil {
+		utils.LogWarning("写入Redis缓存 %s 失败: %v", key, err)
+	}
+}
+
+// CacheDeletePattern 删除匹配pattern的所有缓存，未启用Redis时忽略
+func CacheDeletePattern(pattern string) {
+	if Redis == nil {
+		return
+	}
+
+	ctx := context.Background()
+	iter := Redis.Scan(ctx, 0, redisKeyPrefix+pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := Redis.Del(ctx, iter.Val()).Err(); err != nil {
+			utils.LogWarning("删除Redis缓存 %s 失败: %v", iter.Val(), err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		utils.LogWarning("扫描Redis缓存 %s 失败: %v", pattern, err)
+	}
+}
+
+// AcquireLock 获取分布式锁，返回锁令牌；未启用Redis时总是成功（令牌为空）。
+// Redis出错时无法确认其他实例是否持有锁，返回错误，调用方按未获取处理
+func AcquireLock(key string, ttl time.Duration) (string, bool, error) {
+	if Redis == nil {
+		return "", true, nil
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(buf)
+
+	ok, err := Redis.SetNX(context.Background(), redisKeyPrefix+"lock:"+key, token, ttl).Result()
+	if err != nil {
+		return "", false, err
+	}
+	return token, ok, nil
+}
+
+// ExtendLock 把仍由token持有的锁的过期时间重置为ttl，锁已过期或被其他实例取得时返回false
+func ExtendLock(key, token string, ttl time.Duration) (bool, error) {
+	if Redis == nil || token == "" {
+		return true, nil
+	}
+
+	n, err := extendLockScript.Run(context.Background(), Redis, []string{redisKeyPrefix + "lock:" + key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReleaseLock 释放分布式锁
+func ReleaseLock(key, token string) {
+	if Redis == nil || token == "" {
+		return
+	}
+
+	if err := releaseLockScript.Run(context.Background(), Redis, []string{redisKeyPrefix + "lock:" + key}, token).Err(); err != nil {
+		utils.LogWarning("释放分布式锁 %s 失败: %v", key, err)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 每次删除的最大行数，分批删除避免长时间锁表
+const pruneBatchSize = 10000
+
+// CountKlinesBefore 统计开盘时间早于cutoffUTC（UTC毫秒时间戳）的K线数量
+func CountKlinesBefore(symbol, interval string, cutoffUTC int64) (int64, error) {
+	tableName := GetTableName(symbol, interval)
+	cutoff := utils.TimestampToShanghai(cutoffUTC).Format("2006-01-02 15:04:05")
+
+	var count int64
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE timestamp < ?", tableName)
+	if err := queryRow(klineConn(symbol), query, cutoff).Scan(&count); err != nil {
+		utils.LogError("统计表 %s 过期数据失败: %v", tableName, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteKlinesBefore 分批删除开盘时间早于cutoffUTC的K线及对应的滚动统计，返回删除的K线数量
+func DeleteKlinesBefore(symbol, interval string, cutoffUTC int64) (int64, error) {
+	cutoff := utils.TimestampToShanghai(cutoffUTC).Format("2006-01-02 15:04:05")
+
+	tableName := GetTableName(symbol, interval)
+	conn := klineConn(symbol)
+
+	// 分区表先整块删除完全过期的月份分区，剩余部分再逐行删除
+	dropped, err := dropPartitionsBefore(conn, tableName, cutoff)
+	if err != nil {
+		return dropped, err
+	}
+
+	deleted, err := deleteBefore(conn, tableName, cutoff)
+	deleted += dropped
+	if err != nil {
+		return deleted, err
+	}
+
+	statsTable := GetStatsTableName(symbol, interval)
+	if exists, err := tableExists(conn, statsTable); err == nil && exists {
+		if _, err := deleteBefore(conn, statsTable, cutoff); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteBefore 分批删除表中timestamp早于cutoff的记录
+func deleteBefore(conn *sql.DB, tableName, cutoff string) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE timestamp < ? ORDER BY timestamp LIMIT %d", tableName, pruneBatchSize)
+
+	var total int64
+	for {
+		result, err := execQuery(conn, query, cutoff)
+		if err != nil {
+			utils.LogError("删除表 %s 过期数据失败: %v", tableName, err)
+			return total, err
+		}
+
+		n, _ := result.RowsAffected()
+		total += n
+		if n < pruneBatchSize {
+			return total, nil
+		}
+	}
+}
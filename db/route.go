@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+var (
+	routeConfig *config.DatabaseConfig
+	routeDBs    = make(map[string]*sql.DB) // 路由名称 -> 连接
+)
+
+// RouteStatus 一个数据库路由的状态
+type RouteStatus struct {
+	Name     string   `json:"name"`
+	Database string   `json:"database"` // host:port/db，不含用户名和密码
+	Symbols  []string `json:"symbols"`
+	Healthy  bool     `json:"healthy"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// initRoutes 连接按交易对路由的数据库，设置、同步进度、事件等非K线数据始终在主库
+func initRoutes(cfg *config.DatabaseConfig) error {
+	routeConfig = cfg
+	for name, dsn := range cfg.Routes {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return fmt.Errorf("数据库路由 %s 的连接串无效", name)
+		}
+		conn, err := openMySQLURL(u)
+		if err != nil {
+			return fmt.Errorf("连接数据库路由 %s（%s%s）失败: %v", name, u.Host, u.Path, err)
+		}
+		routeDBs[name] = conn
+		utils.LogInfo("已连接数据库路由 %s（%s%s），交易对: %v", name, u.Host, u.Path, cfg.RouteSymbols[name])
+	}
+	return nil
+}
+
+// closeRoutes 关闭路由的数据库连接
+func closeRoutes() {
+	for name, conn := range routeDBs {
+		conn.Close()
+		delete(routeDBs, name)
+	}
+}
+
+// routeName 交易对路由到的数据库名称，使用主库时为空
+func routeName(symbol string) string {
+	if routeConfig == nil || len(routeDBs) == 0 {
+		return ""
+	}
+	return routeConfig.RouteFor(symbol)
+}
+
+// klineConn 交易对的K线表、滚动统计表所在的数据库
+func klineConn(symbol string) *sql.DB {
+	if conn, ok := routeDBs[routeName(symbol)]; ok {
+		return conn
+	}
+	return DB
+}
+
+// klineReadConn 查询交易对K线使用的连接：路由到其他数据库时直接读该数据库，否则与readConn相同
+func klineReadConn(symbol string) *sql.DB {
+	if conn, ok := routeDBs[routeName(symbol)]; ok {
+		return conn
+	}
+	return readConn()
+}
+
+// ListKlineSeries 列出主库和各路由数据库中的K线序列，每个数据库只列出路由到该库的交易对
+func ListKlineSeries() ([]SeriesKey, error) {
+	series, err := listRouteSeries("", DB)
+	if err != nil {
+		return nil, err
+	}
+	for name, conn := range routeDBs {
+		routed, err := listRouteSeries(name, conn)
+		if err != nil {
+			return nil, fmt.Errorf("列出数据库路由 %s 的K线表失败: %v", name, err)
+		}
+		series = append(series, routed...)
+	}
+	sortSeries(series)
+	return series, nil
+}
+
+// listRouteSeries 列出一个数据库中属于该路由的K线序列
+func listRouteSeries(name string, conn *sql.DB) ([]SeriesKey, error) {
+	all, err := (&mysqlReplica{db: conn}).ListSeries()
+	if err != nil {
+		return nil, err
+	}
+	var result []SeriesKey
+	for _, s := range all {
+		if routeName(s.Symbol) == name {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// GetRouteStatuses 各数据库路由的连接状态，未配置路由时返回空
+func GetRouteStatuses() []RouteStatus {
+	if routeConfig == nil {
+		return nil
+	}
+	var result []RouteStatus
+	for name, conn := range routeDBs {
+		status := RouteStatus{Name: name, Symbols: routeConfig.RouteSymbols[name], Healthy: true}
+		if u, err := url.Parse(routeConfig.Routes[name]); err == nil {
+			status.Database = u.Host + u.Path
+		}
+		if err := pingDB(conn); err != nil {
+			status.Healthy = false
+			status.Error = err.Error()
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
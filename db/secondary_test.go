@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// recordingSink 记录写入的K线，err不为nil时写入失败
+type recordingSink struct {
+	mu      sync.Mutex
+	records []KlineRecord
+	err     error
+}
+
+func (r *recordingSink) Name() string { return "recording" }
+func (r *recordingSink) Close() error { return nil }
+
+func (r *recordingSink) WriteKlines(records []KlineRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.records = append(r.records, records...)
+	return nil
+}
+
+// storedKlineRows 生成K线查询结果，openTimes为UTC毫秒，时间列与数据库中一样为上海时间
+func storedKlineRows(openTimes ...int64) *fakeResult {
+	result := &fakeResult{Columns: []string{"timestamp", "open_price", "close_price", "high_price", "low_price", "volume", "note"}}
+	for _, ts := range openTimes {
+		stored, _ := time.ParseInLocation("2006-01-02 15:04:05", utils.TimestampToShanghai(ts).Format("2006-01-02 15:04:05"), time.UTC)
+		result.Rows = append(result.Rows, []driver.Value{stored, "1", "2", "3", "0.5", "10", nil})
+	}
+	return result
+}
+
+// newTestAsyncSink 创建不启动后台协程的异步输出，缺口只保存在内存中
+func newTestAsyncSink(inner KlineSink) *asyncSink {
+	return &asyncSink{inner: inner, gaps: make(map[string]*secondaryGap)}
+}
+
+func TestResyncGapReadsPrimary(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	hour := time.Hour.Milliseconds()
+
+	primary := useFakeDB(t)
+	primary.OnQuery = func(query string, args []driver.Value) (*fakeResult, error) {
+		return storedKlineRows(start, start+hour, start+2*hour), nil
+	}
+
+	// 延迟的从库还没有缺口内的数据，补写不能读它
+	replica, replicaConn := openFakeDB(t)
+	replica.OnQuery = func(query string, args []driver.Value) (*fakeResult, error) {
+		return nil, nil
+	}
+	prevReplicas := replicas
+	replicas = []*readReplica{{name: "replica", db: replicaConn, healthy: true}}
+	t.Cleanup(func() { replicas = prevReplicas })
+
+	inner := &recordingSink{}
+	s := newTestAsyncSink(inner)
+	s.addGaps([]KlineRecord{
+		{Symbol: "BTCUSDT", Interval: "1h", Timestamp: start},
+		{Symbol: "BTCUSDT", Interval: "1h", Timestamp: start + 2*hour},
+	})
+
+	written, err := s.resyncGap(*s.gaps["BTCUSDT/1h"])
+	if err != nil {
+		t.Fatalf("resyncGap: %v", err)
+	}
+	if written != 3 || len(inner.records) != 3 {
+		t.Fatalf("resynced %d candles (%d written), want 3", written, len(inner.records))
+	}
+	for i, k := range inner.records {
+		if want := start + int64(i)*hour; k.Timestamp != want {
+			t.Fatalf("candle %d timestamp = %d, want %d", i, k.Timestamp, want)
+		}
+	}
+	if len(s.gaps) != 0 {
+		t.Fatalf("gaps after resync = %v, want none", s.gaps)
+	}
+}
+
+func TestResyncGapKeepsGapOnWriteFailure(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	primary := useFakeDB(t)
+	primary.OnQuery = func(query string, args []driver.Value) (*fakeResult, error) {
+		if !strings.Contains(query, "ORDER BY timestamp") {
+			return nil, errors.New("unexpected query")
+		}
+		return storedKlineRows(start), nil
+	}
+
+	inner := &recordingSink{err: errors.New("replica down")}
+	s := newTestAsyncSink(inner)
+	s.addGaps([]KlineRecord{{Symbol: "BTCUSDT", Interval: "1h", Timestamp: start}})
+
+	if _, err := s.resyncGap(*s.gaps["BTCUSDT/1h"]); err == nil {
+		t.Fatal("resyncGap succeeded, want error")
+	}
+	gap, ok := s.gaps["BTCUSDT/1h"]
+	if !ok || gap.Start != start || gap.End != start {
+		t.Fatalf("gap after failed resync = %+v, want [%d, %d]", gap, start, start)
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// CreateSettingsTableIfNotExists 创建运行时设置表，保存需要跨重启保留的操作状态
+func CreateSettingsTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		name VARCHAR(64) NOT NULL,
+		value TEXT NOT NULL,
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (name)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("settings"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 settings 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetSetting 读取设置，不存在时第二个返回值为false
+func GetSetting(name string) (string, bool, error) {
+	var value string
+	err := queryRow(DB, fmt.Sprintf("SELECT value FROM %s WHERE name = ?", prefixTable("settings")), name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		utils.LogError("读取设置 %s 失败: %v", name, err)
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// SaveSetting 保存设置
+func SaveSetting(name, value string) error {
+	_, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (name, value, updated_at) VALUES (?, ?, ?)
+	ON DUPLICATE KEY UPDATE value = VALUES(value), updated_at = VALUES(updated_at)
+	`, prefixTable("settings")), name, value, utils.GetShanghaiNow().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		utils.LogError("保存设置 %s 失败: %v", name, err)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// KlineSink K线输出目标，K线写入MySQL成功后同步写入
+type KlineSink interface {
+	Name() string
+	WriteKlines(records []KlineRecord) error
+	Close() error
+}
+
+var (
+	sinks      []KlineSink
+	sinkStrict bool
+)
+
+// InitSinks 根据配置初始化输出目标
+func InitSinks(cfg *config.SinkConfig) error {
+	sinkStrict = cfg.Strict
+
+	// 作为主存储的时序数据库由 OpenPrimaryStore 打开，不再作为输出重复写入
+	if cfg.InfluxURL != "" && cfg.Primary != "influx" {
+		sinks = append(sinks, newInfluxSink(cfg))
+		utils.LogInfo("已启用InfluxDB输出: %s", cfg.InfluxURL)
+	}
+
+	if cfg.TimescaleDSN != "" && cfg.Primary != "timescale" {
+		sink, err := newTimescaleSink(cfg.TimescaleDSN, cfg.TimescaleTable)
+		if err != nil {
+			utils.LogError("初始化TimescaleDB输出失败: %v", err)
+			return err
+		}
+		sinks = append(sinks, sink)
+		utils.LogInfo("已启用TimescaleDB输出，表: %s", cfg.TimescaleTable)
+	}
+
+	// 副本异步写入，不受严格模式影响
+	if cfg.SecondaryDSN != "" {
+		sink, err := newSecondarySink(cfg.SecondaryDSN)
+		if err != nil {
+			utils.LogError("初始化副本失败: %v", err)
+			return err
+		}
+		sinks = append(sinks, newAsyncSink(sink, cfg.SecondaryQueueSize))
+		utils.LogInfo("已启用%s副本异步双写，队列容量: %d", sink.Name(), cfg.SecondaryQueueSize)
+	}
+	return nil
+}
+
+// CloseSinks 关闭所有输出目标
+func CloseSinks() {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+	sinks = nil
+}
+
+// writeSinks 将已写入MySQL的K线写入所有输出目标，严格模式下任一输出失败都返回错误
+func writeSinks(records []KlineRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.WriteKlines(records); err != nil {
+			utils.IncCounter(utils.MetricName("biupdata_sink_failures_total", "sink", sink.Name()))
+			utils.LogError("写入 %s 失败: %v", sink.Name(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		utils.AddCounter(utils.MetricName("biupdata_sink_written_total", "sink", sink.Name()), float64(len(records)))
+	}
+
+	if sinkStrict {
+		return firstErr
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package db
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 快照格式版本，格式不兼容时递增
+const snapshotVersion = 1
+
+// 快照中的表，按导入顺序排列；K线数据不在快照中，标注单独导出
+var snapshotTables = []string{"settings", "sync_state", "symbols", "symbol_registry", "market_events", "kline_note_audit", "alert_rules"}
+
+// 快照中K线标注的文件名
+const snapshotNotesFile = "kline_notes.json"
+
+// SnapshotManifest 快照说明，保存在归档的 manifest.json 中
+type SnapshotManifest struct {
+	Version   int            `json:"version"`
+	CreatedAt string         `json:"created_at"` // 上海时间
+	Host      string         `json:"host"`
+	Tables    map[string]int `json:"tables"` // 每张表导出的行数
+	Notes     int            `json:"notes"`  // 导出的K线标注数
+}
+
+// SnapshotTable 快照中一张表的全部行，值为数据库中的文本形式，NULL为nil
+type SnapshotTable struct {
+	Name    string      `json:"name"`
+	Columns []string    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+}
+
+// KlineNote 一根K线的标注
+type KlineNote struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	Time     string `json:"time"` // K线开盘时间，与表中存储的一致（上海时间）
+	Note     string `json:"note"`
+}
+
+// SnapshotImportResult 导入快照的结果
+type SnapshotImportResult struct {
+	Manifest     SnapshotManifest `json:"manifest"`
+	Tables       map[string]int   `json:"tables"`        // 每张表导入的行数
+	Notes        int              `json:"notes"`         // 恢复的K线标注数
+	SkippedNotes []KlineNote      `json:"skipped_notes"` // K线不存在而未恢复的标注
+}
+
+// ExportSnapshot 把设置、同步进度、交易对元数据、市场事件、标注修改记录、告警规则和K线标注写入tar.gz归档
+func ExportSnapshot(w io.Writer) (*SnapshotManifest, error) {
+	host, _ := os.Hostname()
+	manifest := &SnapshotManifest{
+		Version:   snapshotVersion,
+		CreatedAt: utils.GetShanghaiNow().Format("2006-01-02 15:04:05"),
+		Host:      host,
+		Tables:    make(map[string]int),
+	}
+
+	// 升级前的部署可能还没有交易对标识登记表
+	if err := CreateSymbolRegistryTableIfNotExists(); err != nil {
+		return nil, err
+	}
+	files := map[string]interface{}{"manifest.json": manifest}
+	for _, name := range snapshotTables {
+		table, err := exportTable(name)
+		if err != nil {
+			return nil, fmt.Errorf("导出表 %s 失败: %v", name, err)
+		}
+		files[name+".json"] = table
+		manifest.Tables[name] = len(table.Rows)
+	}
+	notes, err := listKlineNotes()
+	if err != nil {
+		return nil, fmt.Errorf("导出K线标注失败: %v", err)
+	}
+	files[snapshotNotesFile] = notes
+	manifest.Notes = len(notes)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	names := append(append([]string{"manifest.json"}, tableFiles()...), snapshotNotesFile)
+	for _, name := range names {
+		if err := writeTarJSON(tw, name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// tableFiles 快照中各表的文件名
+func tableFiles() []string {
+	names := make([]string, len(snapshotTables))
+	for i, name := range snapshotTables {
+		names[i] = name + ".json"
+	}
+	return names
+}
+
+// ImportSnapshot 读取ExportSnapshot生成的归档并写入当前数据库：各表按主键覆盖已有的行，
+// 标注写回已存在的K线，K线不存在的标注跳过并在结果中列出；dryRun时只解析归档不写入
+func ImportSnapshot(r io.Reader, dryRun bool) (*SnapshotImportResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("不是有效的快照文件: %v", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取快照失败: %v", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("读取快照失败: %v", err)
+		}
+		files[header.Name] = data
+	}
+
+	result := &SnapshotImportResult{Tables: make(map[string]int)}
+	data, ok := files["manifest.json"]
+	if !ok {
+		return nil, fmt.Errorf("快照中缺少 manifest.json")
+	}
+	if err := json.Unmarshal(data, &result.Manifest); err != nil {
+		return nil, fmt.Errorf("解析 manifest.json 失败: %v", err)
+	}
+	if result.Manifest.Version > snapshotVersion {
+		return nil, fmt.Errorf("快照格式版本 %d 高于当前支持的版本 %d，请升级后再导入", result.Manifest.Version, snapshotVersion)
+	}
+
+	var tables []SnapshotTable
+	for _, name := range snapshotTables {
+		data, ok := files[name+".json"]
+		if !ok {
+			continue
+		}
+		var table SnapshotTable
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("解析 %s.json 失败: %v", name, err)
+		}
+		table.Name = name
+		tables = append(tables, table)
+	}
+	var notes []KlineNote
+	if data, ok := files[snapshotNotesFile]; ok {
+		if err := json.Unmarshal(data, &notes); err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", snapshotNotesFile, err)
+		}
+	}
+
+	if dryRun {
+		for _, table := range tables {
+			result.Tables[table.Name] = len(table.Rows)
+		}
+		result.Notes = len(notes)
+		return result, nil
+	}
+
+	// 升级前的部署可能还没有交易对标识登记表
+	if err := CreateSymbolRegistryTableIfNotExists(); err != nil {
+		return nil, err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		n, err := importTable(tx, &table)
+		if err != nil {
+			return nil, fmt.Errorf("导入表 %s 失败: %v", table.Name, err)
+		}
+		result.Tables[table.Name] = n
+	}
+	// 标注按导入的交易对标识定位K线表
+	if err := loadSymbolRegistry(tx); err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		restored, err := restoreKlineNote(tx, note)
+		if err != nil {
+			return nil, fmt.Errorf("恢复 %s %s %s 的标注失败: %v", note.Symbol, note.Interval, note.Time, err)
+		}
+		if restored {
+			result.Notes++
+		} else {
+			result.SkippedNotes = append(result.SkippedNotes, note)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	utils.LogInfo("已导入 %s 于 %s 生成的快照，恢复K线标注 %d 条，跳过 %d 条",
+		result.Manifest.Host, result.Manifest.CreatedAt, result.Notes, len(result.SkippedNotes))
+	return result, nil
+}
+
+// exportTable 读取一张表的全部行
+func exportTable(name string) (*SnapshotTable, error) {
+	rows, err := queryRows(DB, "SELECT * FROM "+prefixTable(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	table := &SnapshotTable{Name: name, Columns: columns, Rows: [][]*string{}}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]*string, len(columns))
+		for i, v := range values {
+			row[i] = snapshotValue(v)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, rows.Err()
+}
+
+// snapshotValue 把扫描出的值转为文本，DATETIME按存储的时间原样输出，不做时区换算
+func snapshotValue(v interface{}) *string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		s = string(v)
+	case time.Time:
+		s = v.Format("2006-01-02 15:04:05")
+	default:
+		s = fmt.Sprint(v)
+	}
+	return &s
+}
+
+// importTable 按主键写入或覆盖快照中的行，快照中有当前表不存在的列时报错
+func importTable(tx *sql.Tx, table *SnapshotTable) (int, error) {
+	if len(table.Rows) == 0 {
+		return 0, nil
+	}
+	existing, err := tableColumns(tx, prefixTable(table.Name))
+	if err != nil {
+		return 0, err
+	}
+	var updates []string
+	for _, column := range table.Columns {
+		if !existing[column] {
+			return 0, fmt.Errorf("当前表中没有列 %s", column)
+		}
+		updates = append(updates, fmt.Sprintf("`%s` = VALUES(`%s`)", column, column))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (`%s`) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		prefixTable(table.Name),
+		strings.Join(table.Columns, "`, `"),
+		strings.TrimSuffix(strings.Repeat("?, ", len(table.Columns)), ", "),
+		strings.Join(updates, ", "))
+	for _, row := range table.Rows {
+		if len(row) != len(table.Columns) {
+			return 0, fmt.Errorf("行的列数 %d 与表头 %d 不一致", len(row), len(table.Columns))
+		}
+		args := make([]interface{}, len(row))
+		for i, v := range row {
+			if v != nil {
+				args[i] = *v
+			}
+		}
+		if _, err := execQuery(tx, query, args...); err != nil {
+			return 0, err
+		}
+	}
+	return len(table.Rows), nil
+}
+
+// tableColumns 查询表的列名
+func tableColumns(tx *sql.Tx, name string) (map[string]bool, error) {
+	rows, err := queryRows(tx, `
+	SELECT COLUMN_NAME FROM information_schema.COLUMNS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns[column] = true
+	}
+	return columns, rows.Err()
+}
+
+// listKlineNotes 查询全部K线表中有标注的K线
+func listKlineNotes() ([]KlineNote, error) {
+	series, err := ListKlineSeries()
+	if err != nil {
+		return nil, err
+	}
+
+	notes := []KlineNote{}
+	for _, s := range series {
+		tableName := GetTableName(s.Symbol, s.Interval)
+		rows, err := queryRows(klineConn(s.Symbol), fmt.Sprintf("SELECT timestamp, note FROM %s WHERE note IS NOT NULL AND note <> '' ORDER BY timestamp", tableName))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var ts time.Time
+			note := KlineNote{Symbol: s.Symbol, Interval: s.Interval}
+			if err := rows.Scan(&ts, &note.Note); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			note.Time = ts.Format("2006-01-02 15:04:05")
+			notes = append(notes, note)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return notes, nil
+}
+
+// restoreKlineNote 把标注写回K线，K线表或K线不存在时返回false；不写标注修改记录，修改记录随快照单独导入。
+// K线表路由到其他数据库时直接写入该数据库，不在主库的事务中
+func restoreKlineNote(tx *sql.Tx, note KlineNote) (bool, error) {
+	tableName := GetTableName(note.Symbol, note.Interval)
+	var conn sqlConn = tx
+	if routed := klineConn(note.Symbol); routed != DB {
+		conn = routed
+	}
+	exists, err := tableExists(conn, tableName)
+	if err != nil || !exists {
+		return false, err
+	}
+	result, err := execQuery(conn, fmt.Sprintf("UPDATE %s SET note = ? WHERE timestamp = ?", tableName), note.Note, note.Time)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	// 标注与已有的相同时不计入影响行数
+	var count int
+	err = queryRow(conn, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE timestamp = ?", tableName), note.Time).Scan(&count)
+	return count > 0, err
+}
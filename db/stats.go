@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// KlineStats 单根K线对应的滚动统计值，nil表示数据不足无法计算
+type KlineStats struct {
+	Timestamp  int64 // 与GetKlineData返回的OpenTime一致
+	VWAP       *float64
+	Volatility *float64
+	ATR        *float64
+}
+
+// GetStatsTableName 获取滚动统计伴生表名
+func GetStatsTableName(symbol, interval string) string {
+	return GetTableName(symbol, interval) + "_stats"
+}
+
+// CreateStatsTableIfNotExists 如果滚动统计伴生表不存在则创建
+func CreateStatsTableIfNotExists(symbol, interval string) error {
+	tableName := GetStatsTableName(symbol, interval)
+
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		timestamp DATETIME NOT NULL COMMENT '上海时间',
+		vwap DECIMAL(30,8) NULL COMMENT '滚动成交量加权均价',
+		volatility DECIMAL(20,10) NULL COMMENT '滚动收益率标准差',
+		atr DECIMAL(30,8) NULL COMMENT '平均真实波幅',
+		PRIMARY KEY (timestamp)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, tableName)
+
+	if _, err := execSchema(klineConn(symbol), query); err != nil {
+		utils.LogError("创建表 %s 失败: %v", tableName, err)
+		return err
+	}
+	return nil
+}
+
+// 写入滚动统计时每条INSERT写入的行数，MySQL单条预处理语句最多65535个参数
+const statsInsertRows = 1000
+
+// SaveKlineStats 批量写入滚动统计值，每statsInsertRows行一条INSERT
+func SaveKlineStats(symbol, interval string, stats []KlineStats) error {
+	tableName := GetStatsTableName(symbol, interval)
+	for start := 0; start < len(stats); start += statsInsertRows {
+		end := start + statsInsertRows
+		if end > len(stats) {
+			end = len(stats)
+		}
+		if err := saveKlineStatsChunk(symbol, tableName, stats[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveKlineStatsChunk 在一条INSERT中写入一批滚动统计值
+func saveKlineStatsChunk(symbol, tableName string, stats []KlineStats) error {
+	placeholders := make([]string, 0, len(stats))
+	args := make([]interface{}, 0, len(stats)*4)
+	for _, s := range stats {
+		placeholders = append(placeholders, "(?, ?, ?, ?)")
+		args = append(args,
+			formatStoredTimestamp(s.Timestamp),
+			nullableFloat(s.VWAP), nullableFloat(s.Volatility), nullableFloat(s.ATR))
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO %s (timestamp, vwap, volatility, atr)
+	VALUES %s
+	ON DUPLICATE KEY UPDATE
+		vwap = VALUES(vwap),
+		volatility = VALUES(volatility),
+		atr = VALUES(atr)
+	`, tableName, strings.Join(placeholders, ","))
+
+	if _, err := execQuery(klineConn(symbol), query, args...); err != nil {
+		utils.LogError("保存滚动统计到表 %s 失败: %v", tableName, err)
+		return err
+	}
+	return nil
+}
+
+// GetKlineStats 查询滚动统计值，按时间倒序返回
+func GetKlineStats(symbol, interval string, startTime, endTime int64, limit int) ([]map[string]interface{}, error) {
+	tableName := GetStatsTableName(symbol, interval)
+
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if startTime > 0 {
+		where = append(where, "timestamp >= ?")
+		args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"))
+	}
+	if endTime > 0 {
+		where = append(where, "timestamp <= ?")
+		args = append(args, utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05"))
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+	SELECT timestamp, vwap, volatility, atr
+	FROM %s
+	WHERE %s
+	ORDER BY timestamp DESC
+	LIMIT ?
+	`, tableName, strings.Join(where, " AND "))
+
+	rows, err := queryRows(klineConn(symbol), query, args...)
+	if err != nil {
+		utils.LogError("查询表 %s 数据失败: %v", tableName, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		var timestamp time.Time
+		var vwap, volatility, atr sql.NullString
+		if err := rows.Scan(&timestamp, &vwap, &volatility, &atr); err != nil {
+			utils.LogError("扫描表 %s 数据失败: %v", tableName, err)
+			return nil, err
+		}
+
+		result = append(result, map[string]interface{}{
+			"timestamp":  timestamp.Unix() * 1000,
+			"datetime":   timestamp.Format("2006-01-02 15:04"),
+			"vwap":       nullStringValue(vwap),
+			"volatility": nullStringValue(volatility),
+			"atr":        nullStringValue(atr),
+		})
+	}
+
+	return result, rows.Err()
+}
+
+// formatStoredTimestamp 将GetKlineData返回的时间戳还原为表中存储的时间字符串
+func formatStoredTimestamp(timestamp int64) string {
+	return time.UnixMilli(timestamp).UTC().Format("2006-01-02 15:04:05")
+}
+
+// nullableFloat 将可空浮点数转换为数据库参数
+func nullableFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// nullStringValue 将可空字符串转换为JSON值，空值输出null
+func nullStringValue(v sql.NullString) interface{} {
+	if !v.Valid {
+		return nil
+	}
+	return v.String
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveKlineStatsSplitsLargeBatches(t *testing.T) {
+	fake := useFakeDB(t)
+
+	// 超过单条语句的参数上限（65535/4行）
+	const rows = 20000
+	vwap := 1.5
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	stats := make([]KlineStats, rows)
+	for i := range stats {
+		stats[i] = KlineStats{Timestamp: start + int64(i)*time.Minute.Milliseconds(), VWAP: &vwap}
+	}
+
+	if err := SaveKlineStats("BTCUSDT", "1m", stats); err != nil {
+		t.Fatalf("SaveKlineStats: %v", err)
+	}
+
+	inserts := fake.ExecsMatching("INSERT INTO")
+	if want := (rows + statsInsertRows - 1) / statsInsertRows; len(inserts) != want {
+		t.Fatalf("executed %d inserts, want %d", len(inserts), want)
+	}
+	written := 0
+	for _, stmt := range inserts {
+		if n := strings.Count(stmt.Query, "?"); n > mysqlMaxPlaceholders || n != len(stmt.Args) {
+			t.Fatalf("insert has %d placeholders and %d args", n, len(stmt.Args))
+		}
+		written += len(stmt.Args) / 4
+	}
+	if written != rows {
+		t.Fatalf("wrote %d rows, want %d", written, rows)
+	}
+	if first := inserts[0].Args[0]; first != formatStoredTimestamp(start) {
+		t.Fatalf("first row timestamp = %v, want %v", first, formatStoredTimestamp(start))
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// SeriesKey 一个交易对和时间间隔组成的K线序列
+type SeriesKey struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+}
+
+// KlineStore 可读可写的K线存储，用于在不同存储之间复制数据
+type KlineStore interface {
+	KlineSink
+	// ListSeries 列出存储中的全部K线序列
+	ListSeries() ([]SeriesKey, error)
+	// ReadKlines 按开盘时间升序读取afterUTC之后（不含）的最多limit条K线
+	ReadKlines(symbol, interval string, afterUTC int64, limit int) ([]KlineRecord, error)
+	// CountKlines 统计序列的K线数量
+	CountKlines(symbol, interval string) (int64, error)
+}
+
+// OpenKlineStore 按连接串的协议打开存储，支持 mysql://（按交易对和时间间隔分表）和 postgres://（TimescaleDB单表）
+func OpenKlineStore(dsn string) (KlineStore, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("无效的连接串: %v", err)
+	}
+
+	switch u.Scheme {
+	case "mysql":
+		return newMySQLReplica(u)
+	case "postgres", "postgresql":
+		// table参数不是PostgreSQL的连接参数，取出后再连接
+		query := u.Query()
+		table := query.Get("table")
+		if table == "" {
+			table = "klines"
+		}
+		query.Del("table")
+		u.RawQuery = query.Encode()
+		return newTimescaleSink(u.String(), table)
+	default:
+		return nil, fmt.Errorf("不支持的存储类型: %s（支持 mysql、postgres）", u.Scheme)
+	}
+}
+
+// ListSeries 从表名解析K线序列，只列出符合当前表名前缀和模板的表，跳过统计表和归档表
+func (r *mysqlReplica) ListSeries() ([]SeriesKey, error) {
+	rows, err := queryRows(r.db, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []SeriesKey
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		symbol, interval, ok := parseTableName(name)
+		if !ok {
+			continue
+		}
+		result = append(result, SeriesKey{Symbol: symbol, Interval: interval})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortSeries(result)
+	return result, nil
+}
+
+// ReadKlines 读取K线，数据库中存储的是上海时间，返回时换算回UTC
+func (r *mysqlReplica) ReadKlines(symbol, interval string, afterUTC int64, limit int) ([]KlineRecord, error) {
+	tableName := GetTableName(symbol, interval)
+	after := utils.TimestampToShanghai(afterUTC).Format("2006-01-02 15:04:05")
+
+	rows, err := queryRows(r.db, fmt.Sprintf(`
+	SELECT timestamp, open_price, close_price, high_price, low_price, volume, IFNULL(note, '')
+	FROM %s WHERE timestamp > ? ORDER BY timestamp LIMIT ?
+	`, tableName), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []KlineRecord
+	for rows.Next() {
+		k := KlineRecord{Symbol: symbol, Interval: interval}
+		var ts time.Time
+		if err := rows.Scan(&ts, &k.Open, &k.Close, &k.High, &k.Low, &k.Volume, &k.Note); err != nil {
+			return nil, err
+		}
+		k.Timestamp = utils.StoredTimestampToUTC(ts.UnixMilli())
+		result = append(result, k)
+	}
+	return result, rows.Err()
+}
+
+// CountKlines 统计表中的K线数量，表不存在时返回0
+func (r *mysqlReplica) CountKlines(symbol, interval string) (int64, error) {
+	tableName := GetTableName(symbol, interval)
+
+	var exists int
+	if err := queryRow(r.db, `
+	SELECT COUNT(*) FROM information_schema.TABLES
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, tableName).Scan(&exists); err != nil || exists == 0 {
+		return 0, err
+	}
+
+	var count int64
+	err := queryRow(r.db, fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&count)
+	return count, err
+}
+
+// ListSeries 列出超表中的全部交易对和时间间隔
+func (s *timescaleSink) ListSeries() ([]SeriesKey, error) {
+	rows, err := queryRows(s.db, fmt.Sprintf("SELECT DISTINCT symbol, timeframe FROM %s", s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []SeriesKey
+	for rows.Next() {
+		var key SeriesKey
+		if err := rows.Scan(&key.Symbol, &key.Interval); err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortSeries(result)
+	return result, nil
+}
+
+// ReadKlines 读取K线，超表中存储的是UTC时间
+func (s *timescaleSink) ReadKlines(symbol, interval string, afterUTC int64, limit int) ([]KlineRecord, error) {
+	rows, err := queryRows(s.db, fmt.Sprintf(`
+	SELECT time, open::text, close::text, high::text, low::text, volume::text
+	FROM %s WHERE symbol = $1 AND timeframe = $2 AND time > $3
+	ORDER BY time LIMIT $4
+	`, s.table), symbol, interval, time.UnixMilli(afterUTC).UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []KlineRecord
+	for rows.Next() {
+		k := KlineRecord{Symbol: symbol, Interval: interval}
+		var ts time.Time
+		if err := rows.Scan(&ts, &k.Open, &k.Close, &k.High, &k.Low, &k.Volume); err != nil {
+			return nil, err
+		}
+		k.Timestamp = ts.UnixMilli()
+		result = append(result, k)
+	}
+	return result, rows.Err()
+}
+
+// CountKlines 统计序列的K线数量
+func (s *timescaleSink) CountKlines(symbol, interval string) (int64, error) {
+	var count int64
+	err := queryRow(s.db, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE symbol = $1 AND timeframe = $2", s.table),
+		symbol, interval).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
+
+// sortSeries 按交易对、时间间隔排序，保证每次复制的顺序一致
+func sortSeries(series []SeriesKey) {
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Symbol != series[j].Symbol {
+			return series[i].Symbol < series[j].Symbol
+		}
+		return series[i].Interval < series[j].Interval
+	})
+}
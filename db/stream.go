@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 流式读取时每次查询的K线条数，按主键分块，避免单条查询长时间占用连接
+const streamChunkRows = 5000
+
+// StreamKlineRange 从只读连接按时间升序逐行读取[startTime, endTime]区间内的K线并回调fn，最多limit条，
+// startTime/endTime为0时不限制该端，limit为0时不限制条数；结果不整体载入内存，
+// fn返回错误或ctx取消时停止读取并返回该错误
+func StreamKlineRange(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int, fn func(Candle) error) error {
+	tableName := GetTableName(symbol, interval)
+	conn := klineReadConn(symbol)
+
+	var end string
+	if endTime > 0 {
+		end = utils.TimestampToShanghai(endTime).Format("2006-01-02 15:04:05")
+	}
+	var after string // 已读取的最后一根K线的存储时间，按主键继续读取
+	total := 0
+	for {
+		var conditions []string
+		var args []interface{}
+		switch {
+		case after != "":
+			conditions = append(conditions, "timestamp > ?")
+			args = append(args, after)
+		case startTime > 0:
+			conditions = append(conditions, "timestamp >= ?")
+			args = append(args, utils.TimestampToShanghai(startTime).Format("2006-01-02 15:04:05"))
+		}
+		if end != "" {
+			conditions = append(conditions, "timestamp <= ?")
+			args = append(args, end)
+		}
+		where := ""
+		if len(conditions) > 0 {
+			where = "WHERE " + strings.Join(conditions, " AND ")
+		}
+		chunk := streamChunkRows
+		if limit > 0 && limit-total < chunk {
+			chunk = limit - total
+		}
+		args = append(args, chunk)
+
+		rows, err := streamRows(ctx, conn, fmt.Sprintf(`
+		SELECT timestamp, open_price, close_price, high_price, low_price, volume, IFNULL(note, '')
+		FROM %s
+		%s
+		ORDER BY timestamp
+		LIMIT ?
+		`, tableName, where), args...)
+		if err != nil {
+			utils.LogError("查询表 %s 数据失败: %v", tableName, err)
+			return err
+		}
+
+		n := 0
+		var last time.Time
+		for rows.Next() {
+			var k Candle
+			if err := rows.Scan(&last, &k.Open, &k.Close, &k.High, &k.Low, &k.Volume, &k.Note); err != nil {
+				rows.Close()
+				utils.LogError("扫描表 %s 数据失败: %v", tableName, err)
+				return err
+			}
+			k.OpenTime = last.Unix() * 1000
+			if err := fn(k); err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		total += n
+		if n < chunk || (limit > 0 && total >= limit) {
+			return nil
+		}
+		after = last.Format("2006-01-02 15:04:05")
+	}
+}
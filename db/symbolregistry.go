@@ -0,0 +1,279 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// 标识的最大长度，留出前缀、模板和时间间隔的长度，表名不超过MySQL的64个字符
+const maxSymbolIDLength = 40
+
+// SymbolIdentifier 交易对与表名中使用的标识的对应关系
+type SymbolIdentifier struct {
+	Symbol     string `json:"symbol"`
+	Identifier string `json:"identifier"`
+	CreatedAt  string `json:"created_at"`
+}
+
+var (
+	symbolIDMutex sync.RWMutex
+	symbolIDs     = make(map[string]string) // 交易对 -> 标识
+	symbolsByID   = make(map[string]string) // 标识 -> 交易对
+	legacyChecked = make(map[string]string) // 未登记的交易对 -> 检查旧版表名后确定的标识
+	registryReady bool                      // 登记表已创建
+
+	unsafeSymbolChars = regexp.MustCompile(`[^a-z0-9]+`)
+	legacySymbolID    = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// CreateSymbolRegistryTableIfNotExists 创建交易对标识登记表并加载已登记的标识
+func CreateSymbolRegistryTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		symbol VARCHAR(64) NOT NULL,
+		identifier VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (symbol),
+		UNIQUE KEY uk_identifier (identifier)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("symbol_registry"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 symbol_registry 失败: %v", err)
+		return err
+	}
+	if err := loadSymbolRegistry(DB); err != nil {
+		return err
+	}
+	symbolIDMutex.Lock()
+	registryReady = true
+	symbolIDMutex.Unlock()
+	return nil
+}
+
+// loadSymbolRegistry 加载数据库conn中已登记的标识，登记表不存在时跳过
+func loadSymbolRegistry(conn sqlConn) error {
+	exists, err := tableExists(conn, prefixTable("symbol_registry"))
+	if err != nil || !exists {
+		return err
+	}
+	rows, err := queryRows(conn, fmt.Sprintf("SELECT symbol, identifier FROM %s", prefixTable("symbol_registry")))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	symbolIDMutex.Lock()
+	defer symbolIDMutex.Unlock()
+	for rows.Next() {
+		var symbol, id string
+		if err := rows.Scan(&symbol, &id); err != nil {
+			return err
+		}
+		symbolIDs[symbol] = id
+		symbolsByID[id] = symbol
+	}
+	return rows.Err()
+}
+
+// canonicalSymbolID 按规则把交易对转换为表名中使用的标识：转为小写，字母和数字以外的字符替换为下划线，
+// 以数字开头时加 s_ 前缀，过长时截断并加上哈希，如 BTC-USDT -> btc_usdt，1000SHIBUSDT -> s_1000shibusdt
+func canonicalSymbolID(symbol string) string {
+	id := strings.Trim(unsafeSymbolChars.ReplaceAllString(strings.ToLower(symbol), "_"), "_")
+	if id == "" {
+		id = "symbol"
+	}
+	if id[0] >= '0' && id[0] <= '9' {
+		id = "s_" + id
+	}
+	if len(id) > maxSymbolIDLength {
+		h := fnv.New32a()
+		h.Write([]byte(symbol))
+		id = fmt.Sprintf("%s_%08x", id[:maxSymbolIDLength-9], h.Sum32())
+	}
+	return id
+}
+
+// symbolID 交易对在表名中使用的标识，已登记时取登记的标识，否则按规则推导；
+// 按规则推导的标识与旧版本的“小写交易对”不同时（如以数字开头），已有旧版表名的K线表则登记并沿用旧标识
+func symbolID(symbol string) string {
+	symbol = strings.ToUpper(symbol)
+	symbolIDMutex.RLock()
+	id, ok := symbolIDs[symbol]
+	if !ok {
+		id, ok = legacyChecked[symbol]
+	}
+	ready := registryReady
+	symbolIDMutex.RUnlock()
+	if ok {
+		return id
+	}
+
+	id = canonicalSymbolID(symbol)
+	legacy := strings.ToLower(symbol)
+	if !ready || legacy == id || !legacySymbolID.MatchString(legacy) {
+		return id
+	}
+	return resolveLegacySymbolID(symbol, legacy, id)
+}
+
+// resolveLegacySymbolID 检查未登记的交易对是否已有旧版表名的K线表：有则登记旧标识，
+// 没有则使用按规则推导的标识；结果缓存，每个交易对只查询一次，查询失败时下次重试
+func resolveLegacySymbolID(symbol, legacy, canonical string) string {
+	exists, err := legacyTablesExist(symbol, legacy)
+	if err != nil {
+		utils.LogWarning("检查交易对 %s 的旧版K线表失败: %v", symbol, err)
+		return canonical
+	}
+	if exists {
+		id, err := RegisterSymbol(symbol)
+		if err != nil {
+			utils.LogWarning("登记交易对 %s 的旧版标识失败，暂时沿用旧版表名: %v", symbol, err)
+			return legacy
+		}
+		return id
+	}
+
+	symbolIDMutex.Lock()
+	legacyChecked[symbol] = canonical
+	symbolIDMutex.Unlock()
+	return canonical
+}
+
+// symbolFromID 表名中的标识对应的交易对，未登记时按大写处理
+func symbolFromID(id string) string {
+	symbolIDMutex.RLock()
+	defer symbolIDMutex.RUnlock()
+	if symbol, ok := symbolsByID[id]; ok {
+		return symbol
+	}
+	return strings.ToUpper(id)
+}
+
+// RegisterSymbol 为交易对登记表名中使用的标识，已登记时直接返回；
+// 旧版本已按“小写交易对”建过K线表的沿用原来的标识，按规则推导的标识已被其他交易对占用时加上数字后缀
+func RegisterSymbol(symbol string) (string, error) {
+	symbol = strings.ToUpper(symbol)
+	symbolIDMutex.RLock()
+	id, ok := symbolIDs[symbol]
+	ready := registryReady
+	symbolIDMutex.RUnlock()
+	if ok {
+		return id, nil
+	}
+	if !ready {
+		if err := CreateSymbolRegistryTableIfNotExists(); err != nil {
+			return "", err
+		}
+		if id, ok := lookupSymbolID(symbol); ok {
+			return id, nil
+		}
+	}
+
+	id = canonicalSymbolID(symbol)
+	if legacy := strings.ToLower(symbol); legacy != id && legacySymbolID.MatchString(legacy) {
+		exists, err := legacyTablesExist(symbol, legacy)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			id = legacy
+		}
+	}
+	id = uniqueSymbolID(symbol, id)
+
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	if _, err := execQuery(DB, fmt.Sprintf(
+		"INSERT IGNORE INTO %s (symbol, identifier, created_at) VALUES (?, ?, ?)", prefixTable("symbol_registry")),
+		symbol, id, now); err != nil {
+		utils.LogError("登记交易对 %s 的标识失败: %v", symbol, err)
+		return "", err
+	}
+	// 其他实例可能同时登记，以数据库中的记录为准
+	if err := loadSymbolRegistry(DB); err != nil {
+		return "", err
+	}
+	registered, ok := lookupSymbolID(symbol)
+	if !ok {
+		return "", fmt.Errorf("交易对 %s 的标识 %s 已被其他交易对占用", symbol, id)
+	}
+	if registered != strings.ToLower(symbol) {
+		utils.LogInfo("交易对 %s 在表名中使用标识 %s", symbol, registered)
+	}
+	return registered, nil
+}
+
+// lookupSymbolID 查询缓存中已登记的标识
+func lookupSymbolID(symbol string) (string, bool) {
+	symbolIDMutex.RLock()
+	defer symbolIDMutex.RUnlock()
+	id, ok := symbolIDs[symbol]
+	return id, ok
+}
+
+// uniqueSymbolID 标识已被其他交易对占用时依次加上 _2、_3 等后缀
+func uniqueSymbolID(symbol, id string) string {
+	symbolIDMutex.RLock()
+	defer symbolIDMutex.RUnlock()
+	candidate := id
+	for n := 2; ; n++ {
+		owner, used := symbolsByID[candidate]
+		if !used || owner == symbol {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d", id, n)
+	}
+}
+
+// legacyTablesExist 检查是否已有以id为标识的任一时间间隔的K线表
+func legacyTablesExist(symbol, id string) (bool, error) {
+	seen := make(map[string]bool)
+	var names []interface{}
+	for _, interval := range config.SupportedIntervals {
+		name := tableNameFor(id, interval)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var count int
+	err := queryRow(klineConn(symbol), fmt.Sprintf(`
+	SELECT COUNT(*) FROM information_schema.TABLES
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME IN (%s)
+	`, strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")), names...).Scan(&count)
+	return count > 0, err
+}
+
+// GetSymbolRegistry 查询全部已登记的交易对标识，按交易对排序
+func GetSymbolRegistry() ([]SymbolIdentifier, error) {
+	rows, err := queryRows(DB, fmt.Sprintf("SELECT symbol, identifier, created_at FROM %s", prefixTable("symbol_registry")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []SymbolIdentifier{}
+	for rows.Next() {
+		var item SymbolIdentifier
+		var createdAt time.Time
+		if err := rows.Scan(&item.Symbol, &item.Identifier, &createdAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+	return result, nil
+}
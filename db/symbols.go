@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// SymbolInfo 交易对元数据，来自币安exchangeInfo
+type SymbolInfo struct {
+	Symbol      string `json:"symbol"`
+	Status      string `json:"status"`
+	BaseAsset   string `json:"base_asset"`
+	QuoteAsset  string `json:"quote_asset"`
+	TickSize    string `json:"tick_size"`
+	StepSize    string `json:"step_size"`
+	MinQty      string `json:"min_qty"`
+	MinNotional string `json:"min_notional"`
+	UpdatedAt   string `json:"updated_at"`
+	Retired     bool   `json:"retired"`
+	RetiredAt   string `json:"retired_at,omitempty"`
+}
+
+// CreateSymbolsTableIfNotExists 创建交易对元数据表
+func CreateSymbolsTableIfNotExists() error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		symbol VARCHAR(32) NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		base_asset VARCHAR(16) NOT NULL,
+		quote_asset VARCHAR(16) NOT NULL,
+		tick_size DECIMAL(30,12) NULL COMMENT '价格最小变动单位',
+		step_size DECIMAL(30,12) NULL COMMENT '数量最小变动单位',
+		min_qty DECIMAL(30,12) NULL COMMENT '最小下单数量',
+		min_notional DECIMAL(30,12) NULL COMMENT '最小下单金额',
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (symbol)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, prefixTable("symbols"))
+
+	if _, err := execSchema(DB, query); err != nil {
+		utils.LogError("创建表 symbols 失败: %v", err)
+		return err
+	}
+
+	// 旧版本创建的表没有下架标记列
+	if err := ensureColumn(prefixTable("symbols"), "retired", "TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否已停止采集'"); err != nil {
+		return err
+	}
+	return ensureColumn(prefixTable("symbols"), "retired_at", "DATETIME NULL COMMENT '停止采集时间（上海时间）'")
+}
+
+// SaveSymbolInfo 保存或更新交易对元数据
+func SaveSymbolInfo(info *SymbolInfo) error {
+	query := fmt.Sprintf(`
+	INSERT INTO %s (symbol, status, base_asset, quote_asset, tick_size, step_size, min_qty, min_notional, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+		status = VALUES(status),
+		base_asset = VALUES(base_asset),
+		quote_asset = VALUES(quote_asset),
+		tick_size = VALUES(tick_size),
+		step_size = VALUES(step_size),
+		min_qty = VALUES(min_qty),
+		min_notional = VALUES(min_notional),
+		updated_at = VALUES(updated_at),
+		retired = IF(VALUES(status) = 'TRADING', 0, retired),
+		retired_at = IF(VALUES(status) = 'TRADING', NULL, retired_at)
+	`, prefixTable("symbols"))
+
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	_, err := execQuery(DB, query, info.Symbol, info.Status, info.BaseAsset, info.QuoteAsset,
+		nullIfEmpty(info.TickSize), nullIfEmpty(info.StepSize), nullIfEmpty(info.MinQty), nullIfEmpty(info.MinNotional), now)
+	if err != nil {
+		utils.LogError("保存交易对 %s 元数据失败: %v", info.Symbol, err)
+		return err
+	}
+	return nil
+}
+
+// GetSymbolInfos 查询交易对元数据，symbol为空时返回全部
+func GetSymbolInfos(symbol string) ([]SymbolInfo, error) {
+	query := fmt.Sprintf(`
+	SELECT symbol, status, base_asset, quote_asset, tick_size, step_size, min_qty, min_notional, updated_at, retired, retired_at
+	FROM %s
+	`, prefixTable("symbols"))
+	var args []interface{}
+	if symbol != "" {
+		query += " WHERE symbol = ?"
+		args = append(args, symbol)
+	}
+	query += " ORDER BY symbol"
+
+	rows, err := queryRows(DB, query, args...)
+	if err != nil {
+		utils.LogError("查询交易对元数据失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []SymbolInfo
+	for rows.Next() {
+		var info SymbolInfo
+		var tickSize, stepSize, minQty, minNotional sql.NullString
+		var updatedAt time.Time
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&info.Symbol, &info.Status, &info.BaseAsset, &info.QuoteAsset,
+			&tickSize, &stepSize, &minQty, &minNotional, &updatedAt, &info.Retired, &retiredAt); err != nil {
+			utils.LogError("扫描交易对元数据失败: %v", err)
+			return nil, err
+		}
+
+		info.TickSize = tickSize.String
+		info.StepSize = stepSize.String
+		info.MinQty = minQty.String
+		info.MinNotional = minNotional.String
+		info.UpdatedAt = updatedAt.Format("2006-01-02 15:04:05")
+		if retiredAt.Valid {
+			info.RetiredAt = retiredAt.Time.Format("2006-01-02 15:04:05")
+		}
+		result = append(result, info)
+	}
+
+	return result, rows.Err()
+}
+
+// RetireSymbol 将交易对标记为已停止采集，交易对不存在于元数据表时插入一条记录
+func RetireSymbol(symbol, status string) error {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	_, err := execQuery(DB, fmt.Sprintf(`
+	INSERT INTO %s (symbol, status, base_asset, quote_asset, updated_at, retired, retired_at)
+	VALUES (?, ?, '', '', ?, 1, ?)
+	ON DUPLICATE KEY UPDATE
+		status = VALUES(status),
+		updated_at = VALUES(updated_at),
+		retired = 1,
+		retired_at = IFNULL(retired_at, VALUES(retired_at))
+	`, prefixTable("symbols")), symbol, status, now, now)
+	if err != nil {
+		utils.LogError("标记交易对 %s 停止采集失败: %v", symbol, err)
+		return err
+	}
+	return nil
+}
+
+// GetRetiredSymbols 获取所有已停止采集的交易对
+func GetRetiredSymbols() ([]string, error) {
+	rows, err := queryRows(DB, fmt.Sprintf("SELECT symbol FROM %s WHERE retired = 1", prefixTable("symbols")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, err
+		}
+		result = append(result, symbol)
+	}
+	return result, rows.Err()
+}
+
+// ArchiveSymbolTables 将交易对的数据表重命名为 archived_ 前缀，保留数据但不再参与采集
+func ArchiveSymbolTables(symbol string, intervals []string) error {
+	for _, interval := range intervals {
+		tableName := GetTableName(symbol, interval)
+		exists, err := tableExists(klineConn(symbol), tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		archived := asideTableName("archived", tableName)
+		if _, err := execSchema(klineConn(symbol), fmt.Sprintf("RENAME TABLE %s TO %s", tableName, archived)); err != nil {
+			utils.LogError("归档表 %s 失败: %v", tableName, err)
+			return err
+		}
+		utils.LogInfo("已将表 %s 归档为 %s", tableName, archived)
+	}
+	return DeleteSyncStates(symbol)
+}
+
+// nullIfEmpty 空字符串写入数据库时转换为NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
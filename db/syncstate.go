@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+)
+
+// SyncState 一个交易对一个时间间隔的增量同步进度
+type SyncState struct {
+	Symbol   string
+	Interval string
+	// 最后一根完整写入的已收盘K线的开盘时间（UTC毫秒），该时间及之前的数据已按页连续拉取过
+	Watermark int64
+	// 最近一次同步成功的时间，从未成功过时为零值
+	LastSuccess time.Time
+}
+
+// CreateSyncStateTableIfNotExists 创建增量同步进度表
+func CreateSyncStateTableIfNotExists() error {
+	if _, err := execSchema(DB, syncStateTableDDL(prefixTable("sync_state"))); err != nil {
+		utils.LogError("创建表 sync_state 失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// syncStateTableDDL 同步进度表的建表语句
+func syncStateTableDDL(tableName string) string {
+	return fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		symbol VARCHAR(32) NOT NULL,
+		kline_interval VARCHAR(8) NOT NULL,
+		watermark DATETIME NOT NULL COMMENT '上海时间，最后一根完整写入的K线开盘时间',
+		last_success_at DATETIME NULL COMMENT '上海时间',
+		updated_at DATETIME NOT NULL COMMENT '上海时间',
+		PRIMARY KEY (symbol, kline_interval)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, tableName)
+}
+
+// GetSyncState 读取同步进度，没有记录时返回nil
+func GetSyncState(symbol, interval string) (*SyncState, error) {
+	return getSyncState(DB, prefixTable("sync_state"), symbol, interval)
+}
+
+// getSyncState 从指定连接的同步进度表读取同步进度
+func getSyncState(conn sqlConn, tableName, symbol, interval string) (*SyncState, error) {
+	rows, err := queryRows(conn, fmt.Sprintf(`
+	SELECT symbol, kline_interval, watermark, last_success_at
+	FROM %s WHERE symbol = ? AND kline_interval = ?
+	`, tableName), symbol, interval)
+	if err != nil {
+		utils.LogError("查询 %s %s 同步进度失败: %v", symbol, interval, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	states, err := scanSyncStates(rows)
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return &states[0], nil
+}
+
+// ListSyncStates 读取全部同步进度
+func ListSyncStates() ([]SyncState, error) {
+	rows, err := queryRows(DB, fmt.Sprintf(`
+	SELECT symbol, kline_interval, watermark, last_success_at
+	FROM %s ORDER BY symbol, kline_interval
+	`, prefixTable("sync_state")))
+	if err != nil {
+		utils.LogError("查询同步进度失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSyncStates(rows)
+}
+
+// AdvanceSyncWatermark 推进同步水位（UTC毫秒），水位只前进不后退；
+// 该序列还有只写入了缓存队列的K线时不推进，下次更新从原水位重新获取
+func AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	if SeriesQueued(symbol, interval) {
+		return nil
+	}
+	return advanceSyncWatermark(DB, prefixTable("sync_state"), symbol, interval, watermark)
+}
+
+// advanceSyncWatermark 在指定连接的同步进度表中推进同步水位
+func advanceSyncWatermark(conn sqlConn, tableName, symbol, interval string, watermark int64) error {
+	_, err := execQuery(conn, fmt.Sprintf(`
+	INSERT INTO %s (symbol, kline_interval, watermark, updated_at) VALUES (?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE watermark = GREATEST(watermark, VALUES(watermark)), updated_at = VALUES(updated_at)
+	`, tableName), symbol, interval,
+		utils.TimestampToShanghai(watermark).Format("2006-01-02 15:04:05"),
+		utils.GetShanghaiNow().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		utils.LogError("保存 %s %s 同步水位失败: %v", symbol, interval, err)
+	}
+	return err
+}
+
+// MarkSyncSuccess 记录一次成功的同步，还没有水位时不记录
+func MarkSyncSuccess(symbol, interval string) error {
+	return markSyncSuccess(DB, prefixTable("sync_state"), symbol, interval)
+}
+
+// markSyncSuccess 在指定连接的同步进度表中记录一次成功的同步
+func markSyncSuccess(conn sqlConn, tableName, symbol, interval string) error {
+	now := utils.GetShanghaiNow().Format("2006-01-02 15:04:05")
+	_, err := execQuery(conn, fmt.Sprintf(`
+	UPDATE %s SET last_success_at = ?, updated_at = ? WHERE symbol = ? AND kline_interval = ?
+	`, tableName), now, now, symbol, interval)
+	if err != nil {
+		utils.LogError("保存 %s %s 同步时间失败: %v", symbol, interval, err)
+	}
+	return err
+}
+
+// DeleteSyncStates 删除交易对的同步进度，数据表被归档或重建后从头同步
+func DeleteSyncStates(symbol string) error {
+	if _, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE symbol = ?", prefixTable("sync_state")), symbol); err != nil {
+		utils.LogError("删除 %s 同步进度失败: %v", symbol, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteSyncState 删除交易对单个时间间隔的同步进度
+func DeleteSyncState(symbol, interval string) error {
+	if _, err := execQuery(DB, fmt.Sprintf("DELETE FROM %s WHERE symbol = ? AND kline_interval = ?", prefixTable("sync_state")), symbol, interval); err != nil {
+		utils.LogError("删除 %s %s 同步进度失败: %v", symbol, interval, err)
+		return err
+	}
+	return nil
+}
+
+// scanSyncStates 读取同步进度查询结果
+func scanSyncStates(rows *timedRows) ([]SyncState, error) {
+	var result []SyncState
+	for rows.Next() {
+		var s SyncState
+		var watermark time.Time
+		var lastSuccess sql.NullTime
+		if err := rows.Scan(&s.Symbol, &s.Interval, &watermark, &lastSuccess); err != nil {
+			utils.LogError("扫描同步进度失败: %v", err)
+			return nil, err
+		}
+		s.Watermark = utils.StoredTimestampToUTC(watermark.UnixMilli())
+		if lastSuccess.Valid {
+			s.LastSuccess = lastSuccess.Time
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
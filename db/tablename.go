@@ -0,0 +1,112 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// 表名中 {exchange} 占位符的取值
+const tableExchange = "binance"
+
+// 月线在表名中的时间间隔，表名统一小写，1M直接转小写会与1分钟线的表同名
+const monthTableInterval = "1mo"
+
+var (
+	tablePrefix   string
+	tableTemplate = config.DefaultTableTemplate
+	tablePattern  = compileTablePattern(tablePrefix, tableTemplate)
+)
+
+// SetTableNaming 设置表名前缀和K线表名模板，须在连接数据库、建表之前调用；
+// 前缀和模板统一转为小写，避免 lower_case_table_names 不同的MySQL实例上表名不一致
+func SetTableNaming(cfg *config.DatabaseConfig) {
+	tablePrefix = strings.ToLower(cfg.TablePrefix)
+	tableTemplate = strings.ToLower(cfg.TableTemplate)
+	if tableTemplate == "" {
+		tableTemplate = config.DefaultTableTemplate
+	}
+	tablePattern = compileTablePattern(tablePrefix, tableTemplate)
+}
+
+// prefixTable 为设置、同步进度等非K线表的表名加上前缀
+func prefixTable(name string) string {
+	return tablePrefix + name
+}
+
+// GetTableName 获取表名：按模板填入交易对的标识（见RegisterSymbol）和时间间隔（见tableInterval），再加上前缀
+func GetTableName(symbol, interval string) string {
+	return tableNameFor(symbolID(symbol), interval)
+}
+
+// tableNameFor 按模板和前缀生成标识为id的交易对的K线表名
+func tableNameFor(id, interval string) string {
+	return formatTableName(tablePrefix, tableTemplate, id, interval)
+}
+
+// formatTableName 按指定的前缀和模板生成K线表名
+func formatTableName(prefix, template, id, interval string) string {
+	name := strings.NewReplacer(
+		"{exchange}", tableExchange,
+		"{symbol}", id,
+		"{interval}", tableInterval(interval),
+	).Replace(template)
+	return prefix + name
+}
+
+// tableInterval 时间间隔在表名中的写法：小写，月线（1M）写为1mo，与1分钟线（1m）区分
+func tableInterval(interval string) string {
+	if interval == "1M" {
+		return monthTableInterval
+	}
+	return strings.ToLower(interval)
+}
+
+// asideTableName 归档、重建时改名保留的表名，如 archived_btcusdt_1h，kind 放在前缀之后，改名后仍带有本实例的前缀
+func asideTableName(kind, tableName string) string {
+	return tablePrefix + kind + "_" + strings.TrimPrefix(tableName, tablePrefix)
+}
+
+// parseTableName 从K线表名解析交易对和时间间隔，统计表、归档表和不符合当前前缀和模板的表返回false
+func parseTableName(name string) (symbol, interval string, ok bool) {
+	if strings.HasPrefix(name, tablePrefix+"archived_") || strings.HasPrefix(name, tablePrefix+"rebuild_") ||
+		strings.HasSuffix(name, "_stats") {
+		return "", "", false
+	}
+	m := tablePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+	for i, group := range tablePattern.SubexpNames() {
+		switch group {
+		case "symbol":
+			symbol = symbolFromID(m[i])
+		case "interval":
+			interval = m[i]
+			if interval == monthTableInterval {
+				interval = "1M"
+			}
+		}
+	}
+	return symbol, interval, config.IsSupportedInterval(interval)
+}
+
+// compileTablePattern 把前缀和模板转换为匹配K线表名的正则表达式
+func compileTablePattern(prefix, template string) *regexp.Regexp {
+	seen := make(map[string]bool)
+	var intervals []string
+	for _, interval := range config.SupportedIntervals {
+		interval = tableInterval(interval)
+		if !seen[interval] {
+			seen[interval] = true
+			intervals = append(intervals, regexp.QuoteMeta(interval))
+		}
+	}
+
+	pattern := regexp.QuoteMeta(prefix + template)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{exchange}"), tableExchange, -1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{symbol}"), "(?P<symbol>[a-z0-9_]+)", 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{interval}"), "(?P<interval>"+strings.Join(intervals, "|")+")", 1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/utils"
+	"github.com/lib/pq"
+)
+
+// timescaleSink 写入TimescaleDB超表，所有交易对和时间间隔共用一张表
+type timescaleSink struct {
+	db    *sql.DB
+	table string
+}
+
+// newTimescaleSink 连接TimescaleDB并创建超表
+func newTimescaleSink(dsn, table string) (*timescaleSink, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// interval是PostgreSQL关键字，时间间隔列命名为timeframe
+	ddl := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			time TIMESTAMPTZ NOT NULL,
+			symbol TEXT NOT NULL,
+			timeframe TEXT NOT NULL,
+			open NUMERIC(30,8) NOT NULL,
+			high NUMERIC(30,8) NOT NULL,
+			low NUMERIC(30,8) NOT NULL,
+			close NUMERIC(30,8) NOT NULL,
+			volume NUMERIC(30,8) NOT NULL,
+			PRIMARY KEY (symbol, timeframe, time)
+		)`, table)
+	if _, err := execSchema(conn, ddl); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 未安装TimescaleDB扩展的普通PostgreSQL没有create_hypertable，保留为普通表
+	_, err = execSchema(conn, fmt.Sprintf("SELECT create_hypertable('%s', 'time', if_not_exists => TRUE)", table))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42883" {
+		utils.LogWarning("数据库未安装TimescaleDB扩展，表 %s 作为普通PostgreSQL表使用", table)
+	} else if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &timescaleSink{db: conn, table: table}, nil
+}
+
+// Name 输出名称
+func (s *timescaleSink) Name() string {
+	return "timescaledb"
+}
+
+// WriteKlines 在一个事务中批量upsert
+func (s *timescaleSink) WriteKlines(records []KlineRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO %s (time, symbol, timeframe, open, high, low, close, volume)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (symbol, timeframe, time) DO UPDATE SET
+		open = EXCLUDED.open,
+		high = EXCLUDED.high,
+		low = EXCLUDED.low,
+		close = EXCLUDED.close,
+		volume = EXCLUDED.volume
+	`, s.table)
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, k := range records {
+		if _, err := execStmt(stmt, query, time.UnixMilli(k.Timestamp).UTC(), k.Symbol, k.Interval, k.Open, k.High, k.Low, k.Close, k.Volume); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close 关闭连接
+func (s *timescaleSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,55 @@
+package db
+
+import "context"
+
+// UpdateStore K线更新流程使用的存储操作，默认为MySQL，测试时可以替换为 MemoryStore
+type UpdateStore interface {
+	// CreateTable 确保交易对和时间间隔的K线表存在
+	CreateTable(symbol, interval string) error
+	// SaveKlines 在一个事务中写入一批K线，要么全部写入，要么全部不写入
+	SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error
+	// LastKlineTimestamp 最新一根K线的时间戳（与写入时相同，按上海时间存储），没有数据时ok为false
+	LastKlineTimestamp(symbol, interval string) (timestamp int64, ok bool, err error)
+	// GetSyncState 读取同步进度，没有记录时返回nil
+	GetSyncState(symbol, interval string) (*SyncState, error)
+	// AdvanceSyncWatermark 把水位推进到watermark（UTC毫秒），只前进不后退
+	AdvanceSyncWatermark(symbol, interval string, watermark int64) error
+	// MarkSyncSuccess 记录最近一次同步成功的时间
+	MarkSyncSuccess(symbol, interval string) error
+}
+
+// mysqlStore 使用全局数据库连接 DB 的默认实现
+type mysqlStore struct{}
+
+// MySQLStore 返回使用 InitDB 建立的数据库连接的存储
+func MySQLStore() UpdateStore {
+	return mysqlStore{}
+}
+
+func (mysqlStore) CreateTable(symbol, interval string) error {
+	return CreateTableIfNotExists(symbol, interval)
+}
+
+func (mysqlStore) SaveKlines(ctx context.Context, symbol, interval string, records []KlineRecord) error {
+	return SaveKlineBatch(ctx, symbol, interval, records)
+}
+
+func (mysqlStore) LastKlineTimestamp(symbol, interval string) (int64, bool, error) {
+	data, err := GetKlineData(symbol, interval, 0, 0, 1)
+	if err != nil || len(data) == 0 {
+		return 0, false, err
+	}
+	return data[0].OpenTime, true, nil
+}
+
+func (mysqlStore) GetSyncState(symbol, interval string) (*SyncState, error) {
+	return GetSyncState(symbol, interval)
+}
+
+func (mysqlStore) AdvanceSyncWatermark(symbol, interval string, watermark int64) error {
+	return AdvanceSyncWatermark(symbol, interval, watermark)
+}
+
+func (mysqlStore) MarkSyncSuccess(symbol, interval string) error {
+	return MarkSyncSuccess(symbol, interval)
+}
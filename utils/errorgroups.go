@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 最多保留的错误分组数，超过时淘汰最久没有出现的分组
+const maxErrorGroups = 500
+
+// 格式字符串中的占位符
+var formatVerb = regexp.MustCompile(`%[-+# 0-9.*\[\]]*[a-zA-Z%]`)
+
+// ErrorGroup 同一格式字符串的错误或警告日志的聚合
+type ErrorGroup struct {
+	Level       string `json:"level"`
+	Format      string `json:"format"`       // 日志的格式字符串，只有占位符时为完整消息
+	Count       int64  `json:"count"`        // 出现次数
+	FirstSeen   string `json:"first_seen"`   // 首次出现时间
+	LastSeen    string `json:"last_seen"`    // 最近出现时间
+	LastMessage string `json:"last_message"` // 最近一次的完整消息
+
+	lastSeen time.Time
+}
+
+// ErrorGroupQuery 错误分组查询条件
+type ErrorGroupQuery struct {
+	Level string    // 只返回该级别：WARNING、ERROR，为空时都返回
+	Since time.Time // 只返回此后出现过的分组
+	Sort  string    // count（默认，按次数倒序）或 recent（按最近出现时间倒序）
+	Limit int       // 最多返回的分组数，0表示不限制
+}
+
+// 按级别和格式字符串聚合的错误，与日志缓冲区共用 mu
+var errorGroups = make(map[string]*ErrorGroup)
+
+// recordErrorGroup 把一条错误或警告日志计入所属分组，调用方需持有 mu
+func recordErrorGroup(level, format, message string, now time.Time) {
+	// "%v" 等只有占位符的格式字符串无法区分错误类型，按完整消息分组
+	if strings.TrimSpace(formatVerb.ReplaceAllString(format, "")) == "" {
+		format = message
+	}
+	key := level + "\x00" + format
+
+	group, ok := errorGroups[key]
+	if !ok {
+		if len(errorGroups) >= maxErrorGroups {
+			evictOldestErrorGroup()
+		}
+		group = &ErrorGroup{Level: level, Format: format, FirstSeen: now.Format("2006-01-02 15:04:05")}
+		errorGroups[key] = group
+	}
+	group.Count++
+	group.LastSeen = now.Format("2006-01-02 15:04:05")
+	group.LastMessage = message
+	group.lastSeen = now
+}
+
+// evictOldestErrorGroup 淘汰最久没有出现的分组
+func evictOldestErrorGroup() {
+	var oldestKey string
+	var oldest time.Time
+	for key, group := range errorGroups {
+		if oldestKey == "" || group.lastSeen.Before(oldest) {
+			oldestKey = key
+			oldest = group.lastSeen
+		}
+	}
+	delete(errorGroups, oldestKey)
+}
+
+// GetErrorGroups 查询错误分组，total为匹配分组的总出现次数
+func GetErrorGroups(q ErrorGroupQuery) (groups []ErrorGroup, total int64) {
+	level := strings.ToUpper(q.Level)
+
+	mu.Lock()
+	groups = make([]ErrorGroup, 0, len(errorGroups))
+	for _, group := range errorGroups {
+		if level != "" && group.Level != level {
+			continue
+		}
+		if !q.Since.IsZero() && group.lastSeen.Before(q.Since) {
+			continue
+		}
+		groups = append(groups, *group)
+		total += group.Count
+	}
+	mu.Unlock()
+
+	sort.Slice(groups, func(i, j int) bool {
+		if q.Sort == "recent" || groups[i].Count == groups[j].Count {
+			return groups[i].lastSeen.After(groups[j].lastSeen)
+		}
+		return groups[i].Count > groups[j].Count
+	})
+	if q.Limit > 0 && len(groups) > q.Limit {
+		groups = groups[:q.Limit]
+	}
+	return groups, total
+}
+
+// ResetErrorGroups 清空错误分组，返回清除的分组数
+func ResetErrorGroups() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	n := len(errorGroups)
+	errorGroups = make(map[string]*ErrorGroup)
+	return n
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// 访问币安API的所有线路共用同一组连接参数，直连和URL前缀中转共用同一个连接池，
+// 标准代理各自需要独立的Transport（Proxy设置不同），由NewHTTPTransport复制基础Transport生成
+
+var (
+	httpMu        sync.Mutex
+	httpTransport *http.Transport // 基础Transport，未初始化时使用默认参数
+	httpClient    *http.Client
+	httpTimeout   = 10 * time.Second
+)
+
+// InitHTTPClient 按配置创建共享的HTTP客户端，需在创建网络线路之前调用
+func InitHTTPClient(cfg *config.HTTPClientConfig) error {
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return err
+	}
+
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	if httpTransport != nil {
+		httpTransport.CloseIdleConnections()
+	}
+	httpTransport = transport
+	httpTimeout = time.Duration(cfg.Timeout) * time.Second
+	httpClient = &http.Client{Transport: transport, Timeout: httpTimeout}
+
+	LogInfo("HTTP客户端已初始化，超时 %v，每个主机空闲连接 %d，HTTP/2: %v", httpTimeout, cfg.MaxIdleConnsPerHost, cfg.HTTP2)
+	if len(cfg.DNSServers) > 0 {
+		LogInfo("使用自定义DNS服务器: %v", cfg.DNSServers)
+	}
+	return nil
+}
+
+// HTTPClient 获取共享的HTTP客户端，复用连接池
+func HTTPClient() *http.Client {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: httpTimeout}
+	}
+	return httpClient
+}
+
+// NewHTTPClient 基于共享配置创建使用指定代理的HTTP客户端
+func NewHTTPClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+
+	var transport *http.Transport
+	if httpTransport != nil {
+		transport = httpTransport.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.Proxy = proxy
+	return &http.Client{Transport: transport, Timeout: httpTimeout}
+}
+
+// newTransport 按配置创建Transport
+func newTransport(cfg *config.HTTPClientConfig) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
+		KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
+	}
+	if len(cfg.DNSServers) > 0 {
+		dialer.Resolver = newResolver(cfg.DNSServers, dialer.Timeout)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSMinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	if cfg.TLSCAFile != "" {
+		pool, err := loadCAFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout) * time.Second,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.IdleConnTimeout) * time.Second,
+		ExpectContinueTimeout: time.Second,
+		// 自定义了DialContext和TLSClientConfig后需显式开启才会协商HTTP/2
+		ForceAttemptHTTP2: cfg.HTTP2,
+	}
+	if !cfg.HTTP2 {
+		// 非nil的空map禁止升级到HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport, nil
+}
+
+// newResolver 通过指定的DNS服务器解析域名，前一个服务器失败时尝试下一个
+func newResolver(servers []string, timeout time.Duration) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			var lastErr error
+			for _, server := range servers {
+				conn, err := d.DialContext(ctx, network, server)
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			return nil, lastErr
+		},
+	}
+}
+
+// loadCAFile 在系统证书的基础上加入额外信任的CA证书
+func loadCAFile(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取CA证书失败: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("CA证书文件中没有有效的PEM证书: " + file)
+	}
+	return pool, nil
+}
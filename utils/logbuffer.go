@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志级别，数值越大越严重
+var logLevels = map[string]int{
+	LevelInfo:    0,
+	LevelWarning: 1,
+	LevelError:   2,
+}
+
+// LogEntry 缓冲区中的一条日志
+type LogEntry struct {
+	Time      string `json:"time"`      // 配置时区的时间，精确到毫秒
+	Timestamp int64  `json:"timestamp"` // 毫秒时间戳
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Fields    Fields `json:"fields,omitempty"`
+
+	time time.Time
+}
+
+func newLogEntry(t time.Time, level, message string, fields Fields) LogEntry {
+	return LogEntry{
+		Time:      t.Format("2006-01-02 15:04:05.000"),
+		Timestamp: t.UnixMilli(),
+		Level:     level,
+		Message:   message,
+		Fields:    fields,
+		time:      t,
+	}
+}
+
+// text 消息和按键名排序的 key=value 字段，值含空白或引号时加引号
+func (e LogEntry) text() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, k := range keys {
+		v := e.Fields[k]
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + k + "=" + v)
+	}
+	return b.String()
+}
+
+// String 带时间和级别前缀的完整日志行
+func (e LogEntry) String() string {
+	return e.time.Format("2006-01-02 15:04:05") + " [" + e.Level + "] " + e.text()
+}
+
+// logRing 固定容量的环形缓冲区，写满后覆盖最旧的记录，写入和淘汰都是O(1)
+type logRing struct {
+	records []LogEntry
+	head    int // 写满后下一条记录的写入位置，即最旧记录的位置
+	size    int
+}
+
+func newLogRing(size int) *logRing {
+	initial := size
+	if initial > 1024 {
+		initial = 1024 // 按需扩容，未写满时不占用全部容量
+	}
+	if initial < 0 {
+		initial = 0
+	}
+	return &logRing{records: make([]LogEntry, 0, initial), size: size}
+}
+
+// push 添加一条记录
+func (r *logRing) push(rec LogEntry) {
+	if r.size <= 0 {
+		return
+	}
+	if len(r.records) < r.size {
+		r.records = append(r.records, rec)
+		return
+	}
+	r.records[r.head] = rec
+	r.head = (r.head + 1) % r.size
+}
+
+// len 当前记录数
+func (r *logRing) len() int {
+	return len(r.records)
+}
+
+// at 按时间顺序取第i条记录，0为最旧
+func (r *logRing) at(i int) LogEntry {
+	return r.records[(r.head+i)%len(r.records)]
+}
+
+// LogQuery 日志查询条件，零值表示不限制
+type LogQuery struct {
+	Level    string         // 最低级别：INFO、WARNING、ERROR
+	Contains string         // 包含的子串
+	Pattern  *regexp.Regexp // 匹配的正则表达式
+	Start    time.Time      // 不早于该时间
+	End      time.Time      // 不晚于该时间
+	Offset   int            // 跳过最新的若干条匹配记录，用于向前翻页
+	Limit    int            // 最多返回的记录数，0表示不限制
+}
+
+// IsLogLevel 是否为有效的日志级别（不区分大小写）
+func IsLogLevel(level string) bool {
+	_, ok := logLevels[strings.ToUpper(level)]
+	return ok
+}
+
+// SearchLogs 从最新的记录开始查找匹配的日志，跳过Offset条后最多取Limit条，按时间顺序返回；
+// total为全部匹配的记录数，子串和正则匹配带时间和级别前缀的完整日志行
+func SearchLogs(q LogQuery) (entries []LogEntry, total int) {
+	minLevel := logLevels[strings.ToUpper(q.Level)]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if logBuffer == nil {
+		return []LogEntry{}, 0
+	}
+
+	var matched []LogEntry
+	for i := logBuffer.len() - 1; i >= 0; i-- {
+		rec := logBuffer.at(i)
+		if !q.End.IsZero() && rec.time.After(q.End) {
+			continue
+		}
+		if !q.Start.IsZero() && rec.time.Before(q.Start) {
+			// 记录按时间顺序写入，更早的记录都不会匹配
+			break
+		}
+		if logLevels[rec.Level] < minLevel {
+			continue
+		}
+		if q.Contains != "" || q.Pattern != nil {
+			line := rec.String()
+			if q.Contains != "" && !strings.Contains(line, q.Contains) {
+				continue
+			}
+			if q.Pattern != nil && !q.Pattern.MatchString(line) {
+				continue
+			}
+		}
+
+		total++
+		if total <= q.Offset || (q.Limit > 0 && len(matched) >= q.Limit) {
+			continue
+		}
+		matched = append(matched, rec)
+	}
+
+	// 反转为时间顺序
+	entries = make([]LogEntry, len(matched))
+	for i, entry := range matched {
+		entries[len(matched)-1-i] = entry
+	}
+	return entries, total
+}
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 文件日志每行开头的时间格式（log.LstdFlags，本地时间）
+const fileLogTimeLayout = "2006/01/02 15:04:05"
+
+// ErrLogFileNotFound 日志文件不存在或不属于本程序
+var ErrLogFileNotFound = errors.New("日志文件不存在")
+
+// 当前日志文件路径，由 InitLogger 设置
+var logFilePath string
+
+// LogFile 日志目录中的一个日志文件：当前文件或lumberjack轮转出的旧文件
+type LogFile struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"` // 文件大小（字节），压缩文件为压缩后的大小
+	ModTime    string `json:"mod_time"`
+	Current    bool   `json:"current"`    // 是否为正在写入的文件
+	Compressed bool   `json:"compressed"` // 是否为gzip压缩的旧文件
+}
+
+// LogChunk 从日志文件读取的一段内容
+type LogChunk struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`        // 内容总字节数，压缩文件为解压后的大小
+	Offset     int64  `json:"offset"`      // 本段内容的起始位置
+	NextOffset int64  `json:"next_offset"` // 下一段内容的起始位置，等于size时已读到末尾
+	Content    string `json:"content"`
+}
+
+// isLogFileName 是否为当前日志文件或其轮转文件的文件名
+func isLogFileName(name string) bool {
+	base := filepath.Base(logFilePath)
+	if name == base {
+		return true
+	}
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	trimmed := strings.TrimSuffix(name, ".gz")
+	return strings.HasPrefix(trimmed, prefix) && strings.HasSuffix(trimmed, ext) && len(trimmed) > len(prefix)+len(ext)
+}
+
+// ListLogFiles 列出日志目录中的当前日志文件和轮转出的旧文件，按修改时间倒序
+func ListLogFiles() ([]LogFile, error) {
+	if logFilePath == "" {
+		return []LogFile{}, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(logFilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []LogFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isLogFileName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFile{
+			Name:       entry.Name(),
+			Size:       info.Size(),
+			ModTime:    UTCToShanghai(info.ModTime()).Format("2006-01-02 15:04:05"),
+			Current:    entry.Name() == filepath.Base(logFilePath),
+			Compressed: strings.HasSuffix(entry.Name(), ".gz"),
+		})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Current != files[j].Current {
+			return files[i].Current
+		}
+		return files[i].ModTime > files[j].ModTime
+	})
+	return files, nil
+}
+
+// LogFilePath 返回日志文件的完整路径，name只能是 ListLogFiles 中列出的文件名
+func LogFilePath(name string) (string, error) {
+	if logFilePath == "" || name != filepath.Base(name) || !isLogFileName(name) {
+		return "", ErrLogFileNotFound
+	}
+	path := filepath.Join(filepath.Dir(logFilePath), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrLogFileNotFound
+	}
+	return path, nil
+}
+
+// ReadLogFile 读取日志文件从offset开始的最多length个字节，offset为负数时从末尾倒数；
+// 压缩文件按解压后的内容计算位置
+func ReadLogFile(name string, offset int64, length int) (*LogChunk, error) {
+	path, err := LogFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(name, ".gz") {
+		data, err := readGzipFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return sliceChunk(name, bytes.NewReader(data), int64(len(data)), offset, length)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return sliceChunk(name, f, info.Size(), offset, length)
+}
+
+// sliceChunk 从r中读取[offset, offset+length)范围的内容
+func sliceChunk(name string, r io.ReaderAt, size, offset int64, length int) (*LogChunk, error) {
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > size {
+		offset = size
+	}
+	if int64(length) > size-offset {
+		length = int(size - offset)
+	}
+
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &LogChunk{
+		Name:       name,
+		Size:       size,
+		Offset:     offset,
+		NextOffset: offset + int64(n),
+		Content:    string(buf[:n]),
+	}, nil
+}
+
+// TailLogFile 读取日志文件的最后lines行
+func TailLogFile(name string, lines int) (*LogChunk, error) {
+	path, err := LogFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var size int64
+	if strings.HasSuffix(name, ".gz") {
+		if data, err = readGzipFile(path); err != nil {
+			return nil, err
+		}
+		size = int64(len(data))
+		data = lastLines(data, lines)
+	} else {
+		if data, size, err = tailFile(path, lines); err != nil {
+			return nil, err
+		}
+	}
+	return &LogChunk{
+		Name:       name,
+		Size:       size,
+		Offset:     size - int64(len(data)),
+		NextOffset: size,
+		Content:    string(data),
+	}, nil
+}
+
+// tailFile 从文件末尾向前按块读取，直到包含lines行，避免读取整个大文件
+func tailFile(path string, lines int) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	const block = 64 * 1024
+	var data []byte
+	pos := size
+	for pos > 0 && bytes.Count(bytes.TrimRight(data, "\n"), []byte("\n")) < lines {
+		n := int64(block)
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(buf, data...)
+	}
+	return lastLines(data, lines), size, nil
+}
+
+// lastLines 返回data中最后lines行（结尾的换行不计为一行）
+func lastLines(data []byte, lines int) []byte {
+	i := len(bytes.TrimRight(data, "\n"))
+	for n := 0; n < lines; n++ {
+		j := bytes.LastIndexByte(data[:i], '\n')
+		if j < 0 {
+			return data
+		}
+		i = j
+	}
+	return data[i+1:]
+}
+
+// readGzipFile 读取并解压轮转出的旧日志文件
+func readGzipFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("解压日志文件失败: %v", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// restoreLogBuffer 启动时从当前日志文件末尾恢复最近的日志到内存缓冲区，重启后 /logs 仍能查到重启前的记录
+func restoreLogBuffer(path string, records int) {
+	if records <= 0 {
+		return
+	}
+	data, _, err := tailFile(path, records)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	var last LogEntry
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		entry, ok := parseFileLogLine(line)
+		if !ok {
+			// 多行日志的后续行沿用上一行的时间和级别
+			if last.Level == "" {
+				continue
+			}
+			entry = newLogEntry(last.time, last.Level, line, nil)
+		}
+		logBuffer.push(entry)
+		last = entry
+	}
+}
+
+// parseFileLogLine 解析文件中的一行日志：时间 [级别] 内容，字段保留在消息中
+func parseFileLogLine(line string) (LogEntry, bool) {
+	if len(line) < len(fileLogTimeLayout)+3 {
+		return LogEntry{}, false
+	}
+	t, err := time.ParseInLocation(fileLogTimeLayout, line[:len(fileLogTimeLayout)], time.Local)
+	if err != nil {
+		return LogEntry{}, false
+	}
+	rest := line[len(fileLogTimeLayout)+1:]
+	if !strings.HasPrefix(rest, "[") {
+		return LogEntry{}, false
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return LogEntry{}, false
+	}
+	level := rest[1:end]
+	if _, ok := logLevels[level]; !ok {
+		return LogEntry{}, false
+	}
+
+	return newLogEntry(UTCToShanghai(t), level, strings.TrimPrefix(rest[end+1:], " "), nil), true
+}
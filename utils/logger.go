@@ -2,9 +2,11 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/ganlian2020AI/biupdata/config"
@@ -12,12 +14,17 @@ import (
 )
 
 var (
-	logger     *log.Logger
-	logBuffer  []string
-	bufferSize int
+	logOutputs []logOutput
+	logBuffer  *logRing // 内存中的最近日志，供 /logs 查询
 	mu         sync.Mutex
 )
 
+// logOutput 一组最低级别相同的输出目标
+type logOutput struct {
+	logger   *log.Logger
+	minLevel int
+}
+
 // InitLogger 初始化日志系统
 func InitLogger(cfg *config.LogConfig) error {
 	// 确保日志目录存在
@@ -37,84 +44,94 @@ func InitLogger(cfg *config.LogConfig) error {
 		Compress:   cfg.Compress,   // 是否压缩旧日志文件
 	}
 
-	// 同时输出到控制台和文件
-	multiWriter := log.New(lumberjackLogger, "", log.LstdFlags)
+	// 按最低级别分组，级别相同的文件和控制台共用一个 io.MultiWriter
+	writers := map[string][]io.Writer{
+		strings.ToUpper(cfg.FileLevel): {lumberjackLogger},
+	}
+	if cfg.Console {
+		level := strings.ToUpper(cfg.ConsoleLevel)
+		writers[level] = append(writers[level], os.Stdout)
+	}
+
+	outputs := make([]logOutput, 0, len(writers))
+	for level, ws := range writers {
+		outputs = append(outputs, logOutput{
+			logger:   log.New(io.MultiWriter(ws...), "", log.LstdFlags),
+			minLevel: logLevels[level],
+		})
+	}
+
+	logOutputs = outputs
+	logBuffer = newLogRing(cfg.MaxRecords)
+	logFilePath = cfg.File
 
-	logger = multiWriter
-	bufferSize = cfg.MaxRecords
-	logBuffer = make([]string, 0, bufferSize)
+	// 重启后恢复上次运行的最近日志
+	restoreLogBuffer(cfg.File, cfg.MaxRecords)
 
 	return nil
 }
 
-// LogInfo 记录信息日志
-func LogInfo(format string, v ...interface{}) {
-	if logger == nil {
-		return
-	}
-
-	mu.Lock()
-	defer mu.Unlock()
+// 日志级别
+const (
+	LevelInfo    = "INFO"
+	LevelWarning = "WARNING"
+	LevelError   = "ERROR"
+)
 
-	logger.Printf("[INFO] "+format, v...)
+// Fields 日志的结构化字段，如请求ID、交易对，写入文件时以 key=value 的形式附在消息后
+type Fields map[string]string
 
-	// 将日志添加到缓冲区
-	logMsg := "[INFO] " + format
-	addToBuffer(logMsg, v...)
+// LogInfo 记录信息日志
+func LogInfo(format string, v ...interface{}) {
+	LogWithFields(LevelInfo, nil, format, v...)
 }
 
 // LogError 记录错误日志
 func LogError(format string, v ...interface{}) {
-	if logger == nil {
-		return
-	}
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	logger.Printf("[ERROR] "+format, v...)
-
-	// 将日志添加到缓冲区
-	logMsg := "[ERROR] " + format
-	addToBuffer(logMsg, v...)
+	LogWithFields(LevelError, nil, format, v...)
 }
 
 // LogWarning 记录警告日志
 func LogWarning(format string, v ...interface{}) {
-	if logger == nil {
+	LogWithFields(LevelWarning, nil, format, v...)
+}
+
+// LogWithFields 记录带结构化字段的日志，写入日志文件和内存缓冲区，错误和警告同时按格式字符串聚合
+func LogWithFields(level string, fields Fields, format string, v ...interface{}) {
+	if logBuffer == nil {
 		return
 	}
 
+	// 没有参数时格式字符串就是消息本身，其中的%不是占位符
+	message := format
+	if len(v) > 0 {
+		message = fmt.Sprintf(format, v...)
+	}
+	entry := newLogEntry(GetShanghaiNow(), level, message, fields)
+
 	mu.Lock()
 	defer mu.Unlock()
 
-	logger.Printf("[WARNING] "+format, v...)
+	line := "[" + level + "] " + entry.text()
+	for _, out := range logOutputs {
+		if logLevels[level] >= out.minLevel {
+			out.logger.Print(line)
+		}
+	}
 
 	// 将日志添加到缓冲区
-	logMsg := "[WARNING] " + format
-	addToBuffer(logMsg, v...)
-}
-
-// 添加日志到缓冲区，保持最大记录数限制
-func addToBuffer(format string, v ...interface{}) {
-	// 如果缓冲区已满，移除最旧的日志
-	if len(logBuffer) >= bufferSize {
-		logBuffer = logBuffer[1:]
+	logBuffer.push(entry)
+	if level != LevelInfo {
+		recordErrorGroup(level, format, entry.text(), entry.time)
 	}
-
-	// 格式化日志消息并添加到缓冲区
-	logMsg := fmt.Sprintf(format, v...)
-	logBuffer = append(logBuffer, logMsg)
 }
 
-// GetLogBuffer 获取日志缓冲区
+// GetLogBuffer 获取日志缓冲区中的全部日志，按时间顺序
 func GetLogBuffer() []string {
-	mu.Lock()
-	defer mu.Unlock()
-
-	// 返回日志缓冲区的副本
-	result := make([]string, len(logBuffer))
-	copy(result, logBuffer)
-
-	return result
+	entries, _ := SearchLogs(LogQuery{})
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.String()
+	}
+	return lines
 }
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	metricsMu sync.Mutex
+	counters  = make(map[string]float64) // 累计计数器，键为带标签的完整指标名
+	gauges    = make(map[string]float64) // 瞬时值指标，键为带标签的完整指标名
+)
+
+// MetricName 生成带标签的指标名，labels 为 key,value 交替排列
+func MetricName(name string, labels ...string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+// AddCounter 增加计数器的值
+func AddCounter(name string, delta float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	counters[name] += delta
+}
+
+// IncCounter 计数器加一
+func IncCounter(name string) {
+	AddCounter(name, 1)
+}
+
+// SetGauge 设置瞬时值指标
+func SetGauge(name string, value float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	gauges[name] = value
+}
+
+// GetMetric 获取指标当前值（先查瞬时值，再查计数器）
+func GetMetric(name string) float64 {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if v, ok := gauges[name]; ok {
+		return v
+	}
+	return counters[name]
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有指标
+func WriteMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	writeMetricFamily(w, counters, "counter")
+	writeMetricFamily(w, gauges, "gauge")
+}
+
+// 按指标名排序输出同一类型的指标，同名指标只输出一次TYPE行
+func writeMetricFamily(w io.Writer, values map[string]float64, metricType string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lastBase := ""
+	for _, name := range names {
+		base := name
+		if idx := strings.Index(name, "{"); idx >= 0 {
+			base = name[:idx]
+		}
+		if base != lastBase {
+			fmt.Fprintf(w, "# TYPE %s %s\n", base, metricType)
+			lastBase = base
+		}
+		fmt.Fprintf(w, "%s %g\n", name, values[name])
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// SendEmail 通过SMTP发送HTML邮件，465端口使用SSL直连，其余端口在服务器支持时使用STARTTLS
+func SendEmail(cfg *config.NotifyConfig, subject, htmlBody string) error {
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = cfg.SMTPUsername
+	}
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort))
+	msg := buildEmail(from, cfg.EmailTo, subject, htmlBody)
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	var err error
+	if cfg.SMTPPort == 465 {
+		err = sendMailTLS(addr, cfg.SMTPHost, auth, from, cfg.EmailTo, msg)
+	} else {
+		err = smtp.SendMail(addr, auth, from, cfg.EmailTo, msg)
+	}
+	if err != nil {
+		IncCounter(MetricName("biupdata_notify_failures_total", "channel", "email"))
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return nil
+}
+
+// buildEmail 生成邮件内容，正文按base64编码，避免中文和长行被SMTP服务器改写
+func buildEmail(from string, to []string, subject, htmlBody string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(htmlBody))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// sendMailTLS 通过SSL直连的SMTP服务器发送邮件
+func sendMailTLS(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// PostWebhook 将payload以JSON格式POST到webhook地址
+func PostWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := HTTPClient().Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		IncCounter(MetricName("biupdata_notify_failures_total", "channel", "webhook"))
+		return fmt.Errorf("发送webhook失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		IncCounter(MetricName("biupdata_notify_failures_total", "channel", "webhook"))
+		return fmt.Errorf("webhook返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SendTelegram 通过Telegram机器人向配置的聊天发送文本消息
+func SendTelegram(cfg *config.NotifyConfig, text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": cfg.TelegramChatID, "text": text})
+	if err != nil {
+		return err
+	}
+
+	url := "https://api.telegram.org/bot" + cfg.TelegramBotToken + "/sendMessage"
+	resp, err := HTTPClient().Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		IncCounter(MetricName("biupdata_notify_failures_total", "channel", "telegram"))
+		// 错误信息中的URL包含令牌，只记录底层错误
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("发送Telegram消息失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		IncCounter(MetricName("biupdata_notify_failures_total", "channel", "telegram"))
+		return fmt.Errorf("Telegram返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
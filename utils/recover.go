@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Recover 在defer中直接调用，捕获panic后记录调用栈并增加biupdata_panics_total计数，使单个goroutine的异常不会导致进程退出
+// name标识出错的任务，同时作为指标标签，不要包含交易对等取值很多的内容
+// errp不为nil时把panic转换为错误写入，调用方据此把任务按失败处理
+func Recover(name string, errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	IncCounter(MetricName("biupdata_panics_total", "task", name))
+	LogError("%s 发生panic: %v\n%s", name, r, debug.Stack())
+	if errp != nil {
+		*errp = fmt.Errorf("%s 发生panic: %v", name, r)
+	}
+}
+
+// Go 在新的goroutine中运行fn，panic时记录后结束该goroutine
+func Go(name string, fn func()) {
+	go func() {
+		defer Recover(name, nil)
+		fn()
+	}()
+}
+
+// Safe 运行fn并捕获panic，用于后台循环的每一轮，某一轮出错时循环继续运行
+func Safe(name string, fn func()) {
+	defer Recover(name, nil)
+	fn()
+}
@@ -6,8 +6,8 @@ import (
 	"github.com/ganlian2020AI/biupdata/config"
 )
 
-// 上海时区（东八区）
-var shanghaiLocation *time.Location
+// 配置的时区，InitTimezone之前默认为东八区；在包初始化时设置，不在并发调用中延迟创建
+var shanghaiLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
 
 // InitTimezone 初始化时区
 func InitTimezone(cfg *config.TimezoneConfig) {
@@ -23,19 +23,11 @@ func InitTimezone(cfg *config.TimezoneConfig) {
 
 // UTCToShanghai 将UTC时间转换为配置的时区时间
 func UTCToShanghai(utcTime time.Time) time.Time {
-	if shanghaiLocation == nil {
-		// 默认使用东八区
-		shanghaiLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
-	}
 	return utcTime.In(shanghaiLocation)
 }
 
 // ShanghaiToUTC 将配置的时区时间转换为UTC时间
 func ShanghaiToUTC(shanghaiTime time.Time) time.Time {
-	if shanghaiLocation == nil {
-		// 默认使用东八区
-		shanghaiLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
-	}
 	// 先确保时间是配置的时区
 	inShanghai := shanghaiTime.In(shanghaiLocation)
 	// 然后转换为UTC
@@ -44,10 +36,6 @@ func ShanghaiToUTC(shanghaiTime time.Time) time.Time {
 
 // GetShanghaiNow 获取当前的配置时区时间
 func GetShanghaiNow() time.Time {
-	if shanghaiLocation == nil {
-		// 默认使用东八区
-		shanghaiLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
-	}
 	return time.Now().In(shanghaiLocation)
 }
 
@@ -64,14 +52,26 @@ func ShanghaiToTimestamp(shanghaiTime time.Time) int64 {
 	return utcTime.UnixNano() / int64(time.Millisecond)
 }
 
-// GetDefaultStartTime 根据时间间隔获取默认的起始时间
+// StoredTimestampToUTC 将数据库读取出的时间戳转换为真实的UTC时间戳（毫秒）
+// 表中以DATETIME保存配置时区的本地时间，驱动按UTC解析，读取出的时间戳比真实值多出时区偏移
+func StoredTimestampToUTC(storedTimestamp int64) int64 {
+	wall := time.UnixMilli(storedTimestamp).UTC()
+	local := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), shanghaiLocation)
+	return local.UnixMilli()
+}
+
+// ParseShanghaiDate 把 YYYY-MM-DD 形式的日期解析为配置时区当天的零点
+func ParseShanghaiDate(date string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", date, shanghaiLocation)
+}
+
+// GetDefaultStartTime 根据时间间隔获取内置的默认起始时间
 func GetDefaultStartTime(interval string) time.Time {
-	if shanghaiLocation == nil {
-		// 默认使用东八区
-		shanghaiLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
-	}
 
 	switch interval {
+	case "1d", "3d", "1w", "1M":
+		// 日线及以上数据量小，从币安现货开放数据开始获取（2017-07-14）
+		return time.Date(2017, 7, 1, 0, 0, 0, 0, shanghaiLocation)
 	case "5m":
 		// 2025-01-01 00:00:00 上海时间
 		return time.Date(2025, 1, 1, 0, 0, 0, 0, shanghaiLocation)
@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganlian2020AI/biupdata/config"
+)
+
+// 以OTLP/HTTP JSON格式导出链路追踪数据，Jaeger、Tempo、OpenTelemetry Collector等均可直接接收
+
+const (
+	traceBatchSize     = 512             // 攒够该数量的span立即导出
+	traceQueueSize     = 4096            // 待导出队列长度，导出跟不上时丢弃新的span
+	traceFlushInterval = 5 * time.Second // 定时导出间隔
+)
+
+// SpanKind span类型，取值与OTLP一致
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// Span 一段被追踪的操作，未启用追踪或未被采样时为nil，所有方法对nil安全
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     SpanKind
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	errMsg   string
+}
+
+type spanKey struct{}
+
+var (
+	tracingEnabled bool
+	traceEndpoint  string
+	traceHeaders   map[string]string
+	traceService   string
+	traceRatio     float64
+	traceQueue     chan *Span
+	traceDone      chan struct{}
+	traceStopOnce  sync.Once
+	traceClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// InitTracing 初始化链路追踪，未配置OTEL_EXPORTER_OTLP_ENDPOINT时不启用
+func InitTracing(cfg *config.TracingConfig) {
+	if cfg.Endpoint == "" {
+		return
+	}
+
+	traceEndpoint = strings.TrimRight(cfg.Endpoint, "/") + "/v1/traces"
+	traceHeaders = make(map[string]string)
+	for _, h := range cfg.Headers {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			traceHeaders[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	traceService = cfg.ServiceName
+	traceRatio = cfg.SampleRatio
+	traceQueue = make(chan *Span, traceQueueSize)
+	traceDone = make(chan struct{})
+	tracingEnabled = true
+
+	go exportLoop()
+	LogInfo("链路追踪已启用，导出到 %s，采样比例 %.2f", traceEndpoint, traceRatio)
+}
+
+// ShutdownTracing 导出剩余的span，程序退出前调用
+func ShutdownTracing() {
+	if !tracingEnabled {
+		return
+	}
+	traceStopOnce.Do(func() {
+		close(traceQueue)
+		select {
+		case <-traceDone:
+		case <-time.After(5 * time.Second):
+		}
+	})
+}
+
+// StartSpan 开始一个span，ctx中已有span时作为其子span，否则按采样比例决定是否开启新的链路
+// 返回的ctx用于传递给下游操作，span结束时需调用End
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if !tracingEnabled {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		if parent == nil {
+			// 父链路未被采样，子span同样不记录
+			return ctx, nil
+		}
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		if !sampled() {
+			return context.WithValue(ctx, spanKey{}, (*Span)(nil)), nil
+		}
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// sampled 按采样比例决定是否记录新的链路
+func sampled() bool {
+	if traceRatio >= 1 {
+		return true
+	}
+	if traceRatio <= 0 {
+		return false
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	var n uint64
+	for _, v := range b {
+		n = n<<8 | uint64(v)
+	}
+	return float64(n)/math.MaxUint64 < traceRatio
+}
+
+// SetAttr 设置span属性，值支持字符串、整数、浮点数和布尔值
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// End 结束span并放入导出队列，err不为nil时标记为失败
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.errMsg = err.Error()
+	}
+
+	defer func() {
+		// ShutdownTracing后队列已关闭，丢弃迟到的span
+		recover()
+	}()
+	select {
+	case traceQueue <- s:
+	default:
+		IncCounter("biupdata_trace_spans_dropped_total")
+	}
+}
+
+// exportLoop 攒批导出span
+func exportLoop() {
+	defer close(traceDone)
+
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+	for {
+		select {
+		case s, ok := <-traceQueue:
+			if !ok {
+				exportSpans(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				Safe("trace_export", func() { exportSpans(batch) })
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			Safe("trace_export", func() { exportSpans(batch) })
+			batch = batch[:0]
+		}
+	}
+}
+
+// exportSpans 将一批span以OTLP JSON格式发送到接收端
+func exportSpans(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.errMsg}
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": traceService}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "biupdata"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+
+	if err := postTraces(payload); err != nil {
+		IncCounter("biupdata_trace_export_failures_total")
+		LogWarning("导出链路追踪数据失败（%d 个span）: %v", len(spans), err)
+		return
+	}
+	AddCounter("biupdata_trace_spans_exported_total", float64(len(spans)))
+}
+
+// postTraces 发送OTLP请求
+func postTraces(payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, traceEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range traceHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := traceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("接收端返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpAttributes 转换为OTLP的属性列表
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	result := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch x := v.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": x}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(x)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": x}
+		case bool:
+			value = map[string]interface{}{"boolValue": x}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+		}
+		result = append(result, map[string]interface{}{"key": k, "value": value})
+	}
+	return result
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "runtime"
+
+// 版本信息，构建时通过 -ldflags 注入，例如：
+//
+//	go build -ldflags "-X github.com/ganlian2020AI/biupdata/utils.Version=v1.2.0 -X github.com/ganlian2020AI/biupdata/utils.Commit=$(git rev-parse --short HEAD) -X github.com/ganlian2020AI/biupdata/utils.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/biupdata
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// BuildInfo 程序的版本信息
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetBuildInfo 返回构建时注入的版本信息
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}